@@ -0,0 +1,560 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/sirupsen/logrus"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Annotation kinds
+const (
+	AnnotationHighlight = "highlight"
+	AnnotationNote      = "note"
+	AnnotationInk       = "ink"
+)
+
+const (
+	// annotationsDir holds one file of annotations per main file in the
+	// project cache
+	annotationsDir = "annotations"
+	// defaultAnnotationColor is used when an annotation has no color
+	defaultAnnotationColor = "#ffd400"
+	// noteIconSize is the width and height, in points, of an exported note
+	noteIconSize = 20
+	// annotationExportTimeout bounds the ghostscript run of an export
+	annotationExportTimeout = 2 * time.Minute
+)
+
+var annotationColorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// AnnotationRect is a rectangle on a page, in points from the page's
+// top-left corner like the preview and SyncTeX positions
+type AnnotationRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// AnnotationPoint is a point on a page, in points from its top-left corner
+type AnnotationPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// AnnotationAnchor ties an annotation to the source line SyncTeX finds
+// where it was placed, so it can follow that line when a rebuild moves it
+type AnnotationAnchor struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Page, X and Y are where the line was typeset in the annotation's build
+	Page int     `json:"page"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// Annotation is a review note on the PDF of a main file: a highlight over
+// text, a note at a point, or freehand ink
+type Annotation struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Page int    `json:"page"`
+	// PageHeight is the height of the page in points, used to turn the
+	// top-left coordinates into PDF coordinates on export
+	PageHeight float64 `json:"pageHeight"`
+	// Rects are the highlighted text of a highlight, or the spot of a note
+	Rects    []AnnotationRect    `json:"rects,omitempty"`
+	Ink      [][]AnnotationPoint `json:"ink,omitempty"` // strokes of an ink annotation
+	Contents string              `json:"contents,omitempty"`
+	Color    string              `json:"color,omitempty"` // #rrggbb
+	Anchor   *AnnotationAnchor   `json:"anchor,omitempty"`
+	// BuildID is the build whose PDF the page and coordinates refer to
+	BuildID string `json:"buildId,omitempty"`
+	// Stale is set when a later build no longer has the anchor's line, so
+	// the annotation may sit in the wrong place
+	Stale     bool   `json:"stale,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// annotationFile is what is stored per main file: every build of the main
+// file shares its annotations
+type annotationFile struct {
+	MainFile    string       `json:"mainFile"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+// ListAnnotations returns the annotations of mainFile's PDF. Annotations
+// placed on an earlier build are first moved to where their anchor line is
+// in the latest build. The SyncTeX lookups run without annotationsMu held;
+// an annotation saved or deleted meanwhile keeps that change.
+func (a *App) ListAnnotations(mainFile string) ([]Annotation, error) {
+	path, mainFile, err := a.annotationsPath(mainFile)
+	if err != nil {
+		return nil, err
+	}
+	a.annotationsMu.Lock()
+	set, err := loadAnnotations(path, mainFile)
+	a.annotationsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// loadAnnotations decodes a fresh copy, so it can be reanchored unlocked
+	read := make(map[string]Annotation, len(set.Annotations))
+	for _, ann := range set.Annotations {
+		read[ann.ID] = ann
+	}
+	if !a.reanchorAnnotations(mainFile, set.Annotations) {
+		return set.Annotations, nil
+	}
+
+	a.annotationsMu.Lock()
+	defer a.annotationsMu.Unlock()
+	current, err := loadAnnotations(path, mainFile)
+	if err != nil {
+		return nil, err
+	}
+	moved := make(map[string]Annotation, len(set.Annotations))
+	for _, ann := range set.Annotations {
+		moved[ann.ID] = ann
+	}
+	for i, ann := range current.Annotations {
+		was, ok := read[ann.ID]
+		if ok && ann.UpdatedAt == was.UpdatedAt && ann.BuildID == was.BuildID {
+			current.Annotations[i] = moved[ann.ID]
+		}
+	}
+	if err := saveAnnotations(path, current); err != nil {
+		return nil, err
+	}
+	return current.Annotations, nil
+}
+
+// SaveAnnotation adds an annotation to mainFile's PDF, or replaces the one
+// with the same ID, anchoring it to the source line under it. It is anchored
+// before annotationsMu is taken.
+func (a *App) SaveAnnotation(mainFile string, ann Annotation) (*Annotation, error) {
+	if err := validateAnnotation(&ann); err != nil {
+		return nil, err
+	}
+	path, mainFile, err := a.annotationsPath(mainFile)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	isNew := ann.ID == ""
+	if isNew {
+		id := make([]byte, 8)
+		rand.Read(id)
+		ann.ID = hex.EncodeToString(id)
+		ann.CreatedAt = now
+	}
+	ann.UpdatedAt = now
+	ann.Anchor, ann.BuildID, ann.Stale = nil, "", false
+	if buildID := a.lineageBuild(mainFile); buildID != "" {
+		a.anchorAnnotation(buildID, &ann)
+	}
+
+	a.annotationsMu.Lock()
+	defer a.annotationsMu.Unlock()
+	set, err := loadAnnotations(path, mainFile)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	if !isNew {
+		for i, existing := range set.Annotations {
+			if existing.ID == ann.ID {
+				index = i
+				ann.CreatedAt = existing.CreatedAt
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("annotation %s not found", ann.ID)
+		}
+	}
+
+	if index < 0 {
+		set.Annotations = append(set.Annotations, ann)
+	} else {
+		set.Annotations[index] = ann
+	}
+	if err := saveAnnotations(path, set); err != nil {
+		return nil, err
+	}
+	return &ann, nil
+}
+
+// DeleteAnnotation removes an annotation from mainFile's PDF
+func (a *App) DeleteAnnotation(mainFile, id string) error {
+	path, mainFile, err := a.annotationsPath(mainFile)
+	if err != nil {
+		return err
+	}
+	a.annotationsMu.Lock()
+	defer a.annotationsMu.Unlock()
+	set, err := loadAnnotations(path, mainFile)
+	if err != nil {
+		return err
+	}
+	for i, ann := range set.Annotations {
+		if ann.ID == id {
+			set.Annotations = append(set.Annotations[:i], set.Annotations[i+1:]...)
+			return saveAnnotations(path, set)
+		}
+	}
+	return fmt.Errorf("annotation %s not found", id)
+}
+
+// ExportAnnotatedPDF exports the last built PDF of mainFile with its
+// annotations added as standard PDF annotations, written by ghostscript.
+// Stale annotations are left out.
+func (a *App) ExportAnnotatedPDF(mainFile string) (string, error) {
+	pdfPath, err := a.GetPDFPath()
+	if err != nil {
+		return "", err
+	}
+	anns, err := a.ListAnnotations(mainFile)
+	if err != nil {
+		return "", err
+	}
+	_, mainFile, _ = a.annotationsPath(mainFile)
+	if a.lineageBuild(mainFile) == "" {
+		return "", fmt.Errorf("the last build is not of %s", mainFile)
+	}
+	if _, err := exec.LookPath("gs"); err != nil {
+		return "", fmt.Errorf("ghostscript not installed")
+	}
+
+	name := strings.TrimSuffix(a.exportFileName(), ".pdf") + "-annotated.pdf"
+	savePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:                "Export Annotated PDF",
+		DefaultFilename:      name,
+		ShowHiddenFiles:      false,
+		CanCreateDirectories: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	if savePath == "" {
+		return "", fmt.Errorf("no file selected")
+	}
+
+	marks, skipped := annotationPdfmarks(anns)
+	def, err := os.CreateTemp(a.cacheDir, "annotations-*.ps")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(def.Name())
+	if _, err := def.WriteString(marks); err != nil {
+		def.Close()
+		return "", err
+	}
+	if err := def.Close(); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), annotationExportTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gs", "-dBATCH", "-dNOPAUSE", "-dSAFER", "-q",
+		"-sDEVICE=pdfwrite", "-dPDFSETTINGS=/default", "-sOutputFile="+savePath,
+		pdfPath, def.Name())
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		os.Remove(savePath)
+		return "", fmt.Errorf("ghostscript failed: %w\n%s", err, out.String())
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"action":      "export_annotated_pdf",
+		"annotations": len(anns) - skipped,
+		"stale":       skipped,
+		"path":        savePath,
+	}).Info("Exported annotated PDF")
+	return savePath, nil
+}
+
+// annotationsPath returns the file holding mainFile's annotations and the
+// normalized main file
+func (a *App) annotationsPath(mainFile string) (string, string, error) {
+	return a.mainFileCachePath(annotationsDir, mainFile)
+}
+
+// mainFileCachePath returns the file of the project cache directory dir
+// that holds what is kept for mainFile, and the normalized main file
+func (a *App) mainFileCachePath(dir, mainFile string) (string, string, error) {
+	if a.getRoot() == "" || a.cacheDir == "" {
+		return "", "", fmt.Errorf("project root not set")
+	}
+	if mainFile == "" {
+		mainFile = buildopts.DefaultMainFile
+	}
+	mainFile = filepath.ToSlash(filepath.Clean(mainFile))
+	if err := buildopts.ValidateMainFile(mainFile); err != nil {
+		return "", "", err
+	}
+	name := strings.ReplaceAll(mainFile, "/", "--") + ".json"
+	return filepath.Join(a.cacheDir, dir, name), mainFile, nil
+}
+
+// lineageBuild returns the latest remote build if it built mainFile
+func (a *App) lineageBuild(mainFile string) string {
+	a.statusMu.Lock()
+	last := a.lastBuild.MainFile
+	a.statusMu.Unlock()
+	if last == "" {
+		last = buildopts.DefaultMainFile
+	}
+	if last != mainFile {
+		return ""
+	}
+	return a.getRemoteID()
+}
+
+// reanchorAnnotations moves annotations placed on an earlier build of
+// mainFile to where their anchor line is in the latest one, and anchors
+// those that have none yet. It reports whether any changed.
+func (a *App) reanchorAnnotations(mainFile string, anns []Annotation) bool {
+	buildID := a.lineageBuild(mainFile)
+	if buildID == "" {
+		return false
+	}
+	changed := false
+	for i := range anns {
+		ann := &anns[i]
+		if ann.BuildID == buildID {
+			continue
+		}
+		changed = true
+		if ann.Anchor == nil {
+			a.anchorAnnotation(buildID, ann)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		view, err := a.compilerClient().SyncTeXView(ctx, buildID, ann.Anchor.File, ann.Anchor.Line, 0)
+		cancel()
+		if err != nil {
+			ann.Stale = true
+			continue
+		}
+		ann.Page += view.Page - ann.Anchor.Page
+		ann.shift(view.X-ann.Anchor.X, view.Y-ann.Anchor.Y)
+		ann.Anchor.Page, ann.Anchor.X, ann.Anchor.Y = view.Page, view.X, view.Y
+		ann.BuildID, ann.Stale = buildID, false
+	}
+	return changed
+}
+
+// anchorAnnotation ties ann, placed on buildID's PDF, to the source line
+// under its first point. Without SyncTeX it is left unanchored.
+func (a *App) anchorAnnotation(buildID string, ann *Annotation) {
+	ann.BuildID = buildID
+	x, y := ann.origin()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	edit, err := a.compilerClient().SyncTeXEdit(ctx, buildID, ann.Page, x, y)
+	if err != nil {
+		Logger.WithError(err).WithField("annotation", ann.ID).Debug("Annotation left unanchored")
+		return
+	}
+	view, err := a.compilerClient().SyncTeXView(ctx, buildID, edit.File, edit.Line, 0)
+	if err != nil {
+		Logger.WithError(err).WithField("annotation", ann.ID).Debug("Annotation left unanchored")
+		return
+	}
+	ann.Anchor = &AnnotationAnchor{File: edit.File, Line: edit.Line, Page: view.Page, X: view.X, Y: view.Y}
+}
+
+// origin is the first point of the annotation, the one it is anchored by
+func (ann *Annotation) origin() (float64, float64) {
+	if ann.Kind == AnnotationInk {
+		return ann.Ink[0][0].X, ann.Ink[0][0].Y
+	}
+	return ann.Rects[0].X, ann.Rects[0].Y
+}
+
+func (ann *Annotation) shift(dx, dy float64) {
+	for i := range ann.Rects {
+		ann.Rects[i].X += dx
+		ann.Rects[i].Y += dy
+	}
+	for _, stroke := range ann.Ink {
+		for i := range stroke {
+			stroke[i].X += dx
+			stroke[i].Y += dy
+		}
+	}
+}
+
+func validateAnnotation(ann *Annotation) error {
+	switch ann.Kind {
+	case AnnotationHighlight, AnnotationNote:
+		if len(ann.Rects) == 0 {
+			return fmt.Errorf("%s annotation needs a rectangle", ann.Kind)
+		}
+		ann.Ink = nil
+	case AnnotationInk:
+		if len(ann.Ink) == 0 || len(ann.Ink[0]) == 0 {
+			return fmt.Errorf("ink annotation needs a stroke")
+		}
+		ann.Rects = nil
+	default:
+		return fmt.Errorf("unknown annotation kind %q", ann.Kind)
+	}
+	if ann.Page < 1 {
+		return fmt.Errorf("invalid page %d", ann.Page)
+	}
+	if ann.PageHeight <= 0 {
+		return fmt.Errorf("page height required")
+	}
+	if ann.Color == "" {
+		ann.Color = defaultAnnotationColor
+	}
+	if !annotationColorRe.MatchString(ann.Color) {
+		return fmt.Errorf("invalid color %q (must be #rrggbb)", ann.Color)
+	}
+	return nil
+}
+
+func loadAnnotations(path, mainFile string) (*annotationFile, error) {
+	set := &annotationFile{MainFile: mainFile, Annotations: []Annotation{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return set, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("invalid annotations file %s: %w", filepath.Base(path), err)
+	}
+	if set.Annotations == nil {
+		set.Annotations = []Annotation{}
+	}
+	return set, nil
+}
+
+func saveAnnotations(path string, set *annotationFile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// annotationPdfmarks writes anns as ghostscript pdfmarks, in PDF
+// coordinates from the bottom-left corner, and counts the stale ones left
+// out
+func annotationPdfmarks(anns []Annotation) (string, int) {
+	var b strings.Builder
+	skipped := 0
+	for _, ann := range anns {
+		if ann.Stale {
+			skipped++
+			continue
+		}
+		flip := func(y float64) float64 { return ann.PageHeight - y }
+		fmt.Fprintf(&b, "[ /SrcPg %d /Color [%s] /Contents %s /T %s /M (D:%s) /F 4\n",
+			ann.Page, pdfColor(ann.Color), pdfString(ann.Contents), pdfString("Treefrog"), pdfDate(ann.UpdatedAt))
+
+		switch ann.Kind {
+		case AnnotationHighlight:
+			var quads []string
+			x1, y1, x2, y2 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+			for _, r := range ann.Rects {
+				left, right := r.X, r.X+r.Width
+				top, bottom := flip(r.Y), flip(r.Y+r.Height)
+				quads = append(quads, pdfNumbers(left, top, right, top, left, bottom, right, bottom))
+				x1, y1 = math.Min(x1, left), math.Min(y1, bottom)
+				x2, y2 = math.Max(x2, right), math.Max(y2, top)
+			}
+			fmt.Fprintf(&b, "  /Subtype /Highlight /Rect [%s] /QuadPoints [%s]\n", pdfNumbers(x1, y1, x2, y2), strings.Join(quads, " "))
+		case AnnotationNote:
+			x, y := ann.Rects[0].X, flip(ann.Rects[0].Y)
+			fmt.Fprintf(&b, "  /Subtype /Text /Name /Comment /Open false /Rect [%s]\n", pdfNumbers(x, y-noteIconSize, x+noteIconSize, y))
+		case AnnotationInk:
+			var strokes []string
+			x1, y1, x2, y2 := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+			for _, stroke := range ann.Ink {
+				var points []float64
+				for _, p := range stroke {
+					y := flip(p.Y)
+					points = append(points, p.X, y)
+					x1, y1 = math.Min(x1, p.X), math.Min(y1, y)
+					x2, y2 = math.Max(x2, p.X), math.Max(y2, y)
+				}
+				strokes = append(strokes, "["+pdfNumbers(points...)+"]")
+			}
+			fmt.Fprintf(&b, "  /Subtype /Ink /InkList [%s] /Rect [%s] /BS << /W 1 >>\n", strings.Join(strokes, " "), pdfNumbers(x1, y1, x2, y2))
+		}
+		b.WriteString("/ANN pdfmark\n")
+	}
+	return b.String(), skipped
+}
+
+func pdfNumbers(nums ...float64) string {
+	s := make([]string, len(nums))
+	for i, n := range nums {
+		s[i] = strconv.FormatFloat(n, 'f', 2, 64)
+	}
+	return strings.Join(s, " ")
+}
+
+// pdfColor turns #rrggbb into PDF RGB components
+func pdfColor(color string) string {
+	var rgb [3]float64
+	for i := range rgb {
+		v, _ := strconv.ParseUint(color[1+2*i:3+2*i], 16, 8)
+		rgb[i] = float64(v) / 255
+	}
+	return pdfNumbers(rgb[:]...)
+}
+
+// pdfString writes s as a UTF-16BE hex string, which needs no escaping
+func pdfString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// pdfDate turns an RFC 3339 time into a PDF date
+func pdfDate(s string) string {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t = time.Now()
+	}
+	return t.UTC().Format("20060102150405") + "Z"
+}
@@ -6,13 +6,19 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/client"
+	"github.com/alpha-og/treefrog/packages/go/events"
+	"github.com/alpha-og/treefrog/packages/go/vcs"
 	"github.com/sirupsen/logrus"
+	"github.com/wailsapp/wails/v2/pkg/menu"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
@@ -23,15 +29,23 @@ type Config struct {
 	ProjectRoot       string          `json:"projectRoot"`
 	RemoteCompilerURL string          `json:"remoteCompilerUrl"`
 	Renderer          *RendererConfig `json:"renderer,omitempty"`
+
+	DisableNotifications bool              `json:"disableNotifications,omitempty"`
+	Shortcuts            map[string]string `json:"shortcuts,omitempty"`
+	CompileBeforePush    bool              `json:"compileBeforePush,omitempty"`
+	Network              *NetworkConfig    `json:"network,omitempty"`
+	SourceKey            string            `json:"sourceKey,omitempty"` // base64; encrypts source uploads
+
+	ActiveBuilds []ActiveBuild `json:"activeBuilds,omitempty"` // remote builds with a pending build record in their project
 }
 
 // BuildStatus represents the current state of a build
 type BuildStatus struct {
-	ID        string `json:"id"`
-	State     string `json:"state"` // idle|running|success|error
-	Message   string `json:"message"`
-	StartedAt string `json:"startedAt"`
-	EndedAt   string `json:"endedAt"`
+	ID        string          `json:"id"`
+	State     api.ClientState `json:"state"`
+	Message   string          `json:"message"`
+	StartedAt string          `json:"startedAt"`
+	EndedAt   string          `json:"endedAt"`
 }
 
 // BuildOptions contains options for a LaTeX build
@@ -56,11 +70,14 @@ type ProjectInfo struct {
 	Name        string `json:"name"`
 	Root        string `json:"root"`
 	CompilerURL string `json:"compilerUrl"`
+	HasVCS      bool   `json:"hasVcs"` // the project is in a git repository
 }
 
 // GitStatus represents the git status output
 type GitStatus struct {
-	Raw string `json:"raw"`
+	Raw    string      `json:"raw"`
+	Repo   bool        `json:"repo"`             // false when the project is in no git repository
+	Status *vcs.Status `json:"status,omitempty"` // Raw parsed
 }
 
 // SyncTeXResult holds SyncTeX navigation results
@@ -83,6 +100,7 @@ type App struct {
 	cacheDir      string
 	statusMu      sync.Mutex
 	status        BuildStatus
+	lastBuild     BuildOptions
 	remoteMu      sync.Mutex
 	remoteID      string
 	dockerMgr     *DockerManager
@@ -91,13 +109,58 @@ type App struct {
 	remoteMonitor *RemoteCompilerMonitor
 	authMu        sync.RWMutex
 	authConfig    *authConfig
+	tray          *TrayController
+	launchMu      sync.Mutex
+	pendingOpen   string
+	pendingLinks  []string
+	editorSync    *EditorSync
+	presenter     *Presenter
+	pushGateItem  *menu.MenuItem
+	askpass       *GitAskpass
+	clientMu      sync.Mutex
+	apiClient     *client.Client
+	apiClientURL  string
+	annotationsMu sync.Mutex
+	networkMu     sync.Mutex
+	netTransport  *http.Transport
+	uploadLimit   *client.RateLimiter
+	downloadLimit *client.RateLimiter
+	events        *events.Bus
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{
+	a := &App{
 		status: BuildStatus{State: "idle"},
 	}
+	a.tray = NewTrayController(a)
+	a.editorSync = &EditorSync{app: a}
+	a.presenter = &Presenter{app: a}
+	a.askpass = &GitAskpass{app: a}
+	a.uploadLimit = client.NewRateLimiter(0)
+	a.downloadLimit = client.NewRateLimiter(0)
+	a.events = events.NewBus()
+	a.events.Subscribe(a.forwardEvent)
+	events.On(a.events, func(e events.BuildStatusChanged) {
+		a.tray.Update(BuildStatus{
+			ID:        e.BuildID,
+			State:     e.State,
+			Message:   e.Message,
+			StartedAt: e.StartedAt,
+			EndedAt:   e.EndedAt,
+		})
+	})
+	return a
+}
+
+// forwardEvent re-emits bus events to the frontend as Wails runtime events
+// named after their topic. Log lines stay on the bus; the frontend reads the
+// whole log through GetBuildLog.
+func (a *App) forwardEvent(e events.Event) {
+	if a.ctx == nil || e.Topic() == events.TopicLogLine {
+		return
+	}
+	runtime.EventsEmit(a.ctx, string(e.Topic()), e)
 }
 
 // startup is called when the app starts. The context is saved
@@ -105,6 +168,11 @@ func NewApp() *App {
 func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 	a.loadConfig()
+	a.applyBandwidthLimits()
+	if a.pushGateItem != nil {
+		a.pushGateItem.Checked = a.config.CompileBeforePush
+		runtime.MenuUpdateApplicationMenu(ctx)
+	}
 
 	// Initialize auth
 	a.initAuth()
@@ -112,6 +180,8 @@ func (a *App) startup(ctx context.Context) {
 	if a.config.ProjectRoot != "" {
 		a.setRoot(a.config.ProjectRoot)
 	}
+	a.pruneActiveBuilds()
+	a.resumePendingBuild()
 
 	if a.config.Renderer == nil {
 		a.config.Renderer = DefaultRendererConfig()
@@ -119,6 +189,7 @@ func (a *App) startup(ctx context.Context) {
 	}
 
 	a.dockerMgr = NewDockerManager(a.config.Renderer, Logger)
+	a.metrics = NewMetricsCollector(Logger, filepath.Join(filepath.Dir(a.getConfigPath()), "metrics.json"))
 
 	if a.config.Renderer.Mode == ModeAuto {
 		detectedMode := a.dockerMgr.DetectBestMode(ctx)
@@ -147,9 +218,24 @@ func (a *App) startup(ctx context.Context) {
 	}
 
 	if a.config.RemoteCompilerURL != "" {
-		a.remoteMonitor = NewRemoteCompilerMonitor(a.config.RemoteCompilerURL, Logger)
-		a.remoteMonitor.Start()
+		a.startRemoteMonitor(a.config.RemoteCompilerURL)
+	}
+}
+
+// startRemoteMonitor starts health checks of the remote compiler at url,
+// publishing a CompilerHealthChanged event whenever its health flips
+func (a *App) startRemoteMonitor(url string) {
+	a.remoteMonitor = NewRemoteCompilerMonitor(url, Logger)
+	a.remoteMonitor.HTTPClient = a.httpClient(a.remoteMonitor.timeout)
+	a.remoteMonitor.OnHealthChange = func(h RemoteCompilerHealth) {
+		a.events.Publish(events.CompilerHealthChanged{
+			URL:          h.URL,
+			Healthy:      h.IsHealthy,
+			Error:        h.LastError,
+			ResponseTime: h.ResponseTime,
+		})
 	}
+	a.remoteMonitor.Start()
 }
 
 // shutdown is called when the app closes
@@ -180,6 +266,13 @@ func (a *App) shutdown(ctx context.Context) {
 	if a.remoteMonitor != nil {
 		a.remoteMonitor.Stop()
 	}
+
+	if err := a.editorSync.Stop(); err != nil {
+		Logger.WithError(err).Warn("Failed to stop editor sync on shutdown")
+	}
+	if err := a.askpass.Stop(); err != nil {
+		Logger.WithError(err).Warn("Failed to stop askpass server on shutdown")
+	}
 }
 
 // getConfigPath returns the path to the config file
@@ -220,6 +313,12 @@ func (a *App) GetConfig() Config {
 		ProjectRoot:       a.getRoot(),
 		RemoteCompilerURL: a.getRemoteCompilerURL(),
 		Renderer:          a.config.Renderer,
+
+		DisableNotifications: a.notificationsDisabled(),
+		Shortcuts:            a.config.Shortcuts,
+		CompileBeforePush:    a.compileBeforePushEnabled(),
+		Network:              a.config.Network,
+		SourceKey:            a.config.SourceKey,
 	}
 }
 
@@ -245,8 +344,7 @@ func (a *App) SetRemoteCompilerURL(url string) {
 			a.remoteMonitor = nil
 		}
 		if url != "" {
-			a.remoteMonitor = NewRemoteCompilerMonitor(url, Logger)
-			a.remoteMonitor.Start()
+			a.startRemoteMonitor(url)
 			Logger.WithField("url", url).Info("Started remote compiler monitor")
 		}
 	}
@@ -258,11 +356,17 @@ func (a *App) getRoot() string {
 	return a.projectRoot
 }
 
+// projectCacheDir is where the app keeps build output and state of the
+// project at root
+func projectCacheDir(root string) string {
+	return filepath.Join(root, ".treefrog-cache")
+}
+
 func (a *App) setRoot(root string) error {
 	a.rootMu.Lock()
 	defer a.rootMu.Unlock()
 	a.projectRoot = root
-	a.cacheDir = filepath.Join(root, ".treefrog-cache")
+	a.cacheDir = projectCacheDir(root)
 	os.MkdirAll(a.cacheDir, 0755)
 	return nil
 }
@@ -317,11 +421,11 @@ func (a *App) getCompilerURL() string {
 		if a.dockerMgr != nil {
 			status := a.dockerMgr.GetStatus()
 			if status.State == "running" {
-				return fmt.Sprintf("http://127.0.0.1:%d", a.config.Renderer.Port)
+				return a.config.Renderer.LocalURL()
 			}
 		}
-		// Fall back to default port if nothing is running
-		return fmt.Sprintf("http://127.0.0.1:%d", a.config.Renderer.Port)
+		// Fall back to the configured address if nothing is running
+		return a.config.Renderer.LocalURL()
 	}
 
 	if effectiveMode == ModeRemote {
@@ -334,11 +438,45 @@ func (a *App) getCompilerURL() string {
 	if a.dockerMgr != nil {
 		status := a.dockerMgr.GetStatus()
 		if status.State == "running" {
-			return fmt.Sprintf("http://127.0.0.1:%d", a.config.Renderer.Port)
+			return a.config.Renderer.LocalURL()
 		}
 	}
 
-	return fmt.Sprintf("http://127.0.0.1:%d", a.config.Renderer.Port)
+	return a.config.Renderer.LocalURL()
+}
+
+// compilerClient returns the API client for the configured compiler
+func (a *App) compilerClient() *client.Client {
+	return a.clientFor(a.getCompilerURL(), a.GetSessionToken())
+}
+
+// clientFor reuses the API client while the compiler URL is unchanged, so
+// its negotiated API version is kept between requests
+func (a *App) clientFor(compilerURL, sessionToken string) *client.Client {
+	a.clientMu.Lock()
+	defer a.clientMu.Unlock()
+
+	if a.apiClient == nil || a.apiClientURL != compilerURL {
+		a.apiClient = a.newClient(compilerURL, sessionToken)
+		a.apiClient.OnProgress = a.reportTransferProgress
+		a.apiClientURL = compilerURL
+	} else {
+		a.apiClient.SetToken(sessionToken)
+	}
+	return a.apiClient
+}
+
+// newClient creates an API client for the compiler at compilerURL that
+// sends HTTP requests through the configured proxy
+func (a *App) newClient(compilerURL, sessionToken string) *client.Client {
+	c := client.New(compilerURL, sessionToken)
+	if !client.IsSocketURL(compilerURL) {
+		c.HTTPClient = a.httpClient(client.DefaultTimeout)
+	}
+	c.UploadLimiter = a.uploadLimit
+	c.DownloadLimiter = a.downloadLimit
+	c.SourceKey, _ = a.sourceKey()
+	return c
 }
 
 func (a *App) getRemoteID() string {
@@ -375,7 +513,13 @@ func (a *App) safePath(rel string) (string, error) {
 
 func (a *App) emitBuildStatus(status BuildStatus) {
 	Logger.WithField("state", status.State).Info("Emitting build-status event")
-	runtime.EventsEmit(a.ctx, "build-status", status)
+	a.events.Publish(events.BuildStatusChanged{
+		BuildID:   status.ID,
+		State:     status.State,
+		Message:   status.Message,
+		StartedAt: status.StartedAt,
+		EndedAt:   status.EndedAt,
+	})
 }
 
 // Helper functions
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Environment of the askpass helper. Git runs the app's own executable as
+// GIT_ASKPASS with the prompt as its argument; these tell it where to
+// forward the prompt. The token itself is kept out of the environment,
+// which every filter, fsmonitor, ssh command and credential helper git
+// starts would inherit, and read from a file only the user can read.
+const (
+	askpassURLEnv       = "TREEFROG_ASKPASS_URL"
+	askpassTokenFileEnv = "TREEFROG_ASKPASS_TOKEN_FILE"
+	gitHooksEnv         = "TREEFROG_GIT_HOOKS" // the repository's own hooks directory
+)
+
+// gitHookNames are the hooks git may run, from githooks(5)
+var gitHookNames = []string{
+	"applypatch-msg", "pre-applypatch", "post-applypatch", "pre-commit",
+	"pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+	"pre-rebase", "post-checkout", "post-merge", "pre-push", "pre-receive",
+	"update", "proc-receive", "post-receive", "post-update",
+	"reference-transaction", "push-to-checkout", "pre-auto-gc", "post-rewrite",
+	"sendemail-validate", "fsmonitor-watchman", "post-index-change",
+}
+
+// gitHookWrapper runs the repository's hook of the same name without the
+// askpass environment, so a hook cannot use the token to prompt the user
+// for credentials. Git runs hooks through sh on every platform.
+const gitHookWrapper = `#!/bin/sh
+hook="$` + gitHooksEnv + `/$(basename "$0")"
+[ -x "$hook" ] || exit 0
+unset GIT_ASKPASS SSH_ASKPASS SSH_ASKPASS_REQUIRE ` + askpassURLEnv + ` ` + askpassTokenFileEnv + ` ` + gitHooksEnv + `
+exec "$hook" "$@"
+`
+
+// gitCredentialTimeout is how long a git credential prompt waits for the
+// user before git is told there is no answer
+const gitCredentialTimeout = 2 * time.Minute
+
+// Kinds of git credential prompt
+const (
+	CredentialUsername   = "username"
+	CredentialPassword   = "password"
+	CredentialPassphrase = "passphrase"
+	CredentialConfirm    = "confirm"
+)
+
+// GitCredentialPrompt is emitted as the "git-credential" event when git
+// needs credentials. The frontend answers it with AnswerGitCredential or
+// CancelGitCredential.
+type GitCredentialPrompt struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"` // git's or ssh's prompt text
+	Kind   string `json:"kind"`
+	Secret bool   `json:"secret"` // the answer should be masked
+}
+
+// askpassRequest is the body the helper posts to the app
+type askpassRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+// GitAskpass lets git push and pull ask the user for credentials. Git runs
+// without a terminal in the desktop app, so it would otherwise fail as soon
+// as a remote asks for a username, password, token or key passphrase. A
+// localhost endpoint, started on first use, receives the helper's prompts.
+type GitAskpass struct {
+	app     *App
+	mu      sync.Mutex
+	server  *http.Server
+	url     string
+	token   string
+	dir     string // private to the user: the token file and hook wrappers
+	pending map[string]chan string
+}
+
+// Command returns a git command run in root whose prompts are routed to the
+// app. Its hooks run through wrappers that strip the askpass environment.
+func (g *GitAskpass) Command(ctx context.Context, root string, args ...string) (*exec.Cmd, error) {
+	if err := g.start(); err != nil {
+		return nil, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate askpass helper: %w", err)
+	}
+	hooks, err := repoHooksDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	url, dir := g.url, g.dir
+	g.mu.Unlock()
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.hooksPath=" + filepath.Join(dir, "hooks")}, args...)...)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ASKPASS="+exe,
+		"SSH_ASKPASS="+exe,
+		"SSH_ASKPASS_REQUIRE=force",
+		askpassURLEnv+"="+url,
+		askpassTokenFileEnv+"="+filepath.Join(dir, "token"),
+		gitHooksEnv+"="+hooks,
+	)
+	return cmd, nil
+}
+
+func (g *GitAskpass) start() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.server != nil {
+		return nil
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	dir, err := writeAskpassDir(hex.EncodeToString(token))
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to start askpass listener: %w", err)
+	}
+
+	g.dir = dir
+	g.token = hex.EncodeToString(token)
+	g.url = "http://" + ln.Addr().String() + "/askpass"
+	g.pending = make(map[string]chan string)
+	g.server = tfhttp.NewServer(tfhttp.ServerConfig{ReadTimeout: 10 * time.Second}, http.HandlerFunc(g.handlePrompt))
+	go func() {
+		if err := g.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			Logger.WithError(err).Error("Askpass server stopped")
+		}
+	}()
+	return nil
+}
+
+// writeAskpassDir creates a temporary directory only the user can enter,
+// holding the token file and a gitHookWrapper for every hook name under
+// hooks/
+func writeAskpassDir(token string) (string, error) {
+	dir, err := os.MkdirTemp("", "treefrog-askpass-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create askpass directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte(token), 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to write askpass token: %w", err)
+	}
+	hooks := filepath.Join(dir, "hooks")
+	if err := os.Mkdir(hooks, 0700); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to create hook wrappers: %w", err)
+	}
+	for _, name := range gitHookNames {
+		if err := os.WriteFile(filepath.Join(hooks, name), []byte(gitHookWrapper), 0700); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("failed to create hook wrappers: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// repoHooksDir returns the absolute hooks directory of the repository at
+// root, honouring its own core.hooksPath
+func repoHooksDir(root string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-path", "hooks")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate git hooks: %w", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir, nil
+}
+
+// Stop shuts the endpoint down, failing any prompt still waiting
+func (g *GitAskpass) Stop() error {
+	g.mu.Lock()
+	server, dir := g.server, g.dir
+	g.server, g.dir = nil, ""
+	g.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	os.RemoveAll(dir)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+func (g *GitAskpass) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+g.token)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var req askpassRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	answer, ok := g.ask(r.Context(), req.Prompt)
+	if !ok {
+		http.Error(w, "no credentials given", http.StatusGone)
+		return
+	}
+	io.WriteString(w, answer)
+}
+
+// ask emits the prompt to the frontend and waits for its answer
+func (g *GitAskpass) ask(ctx context.Context, prompt string) (string, bool) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	p := classifyCredentialPrompt(hex.EncodeToString(id), prompt)
+
+	ch := make(chan string, 1)
+	g.mu.Lock()
+	g.pending[p.ID] = ch
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.pending, p.ID)
+		g.mu.Unlock()
+	}()
+
+	Logger.WithField("kind", p.Kind).Info("Git is asking for credentials")
+	runtime.EventsEmit(g.app.ctx, "git-credential", p)
+
+	timeout := time.NewTimer(gitCredentialTimeout)
+	defer timeout.Stop()
+	select {
+	case answer, ok := <-ch:
+		return answer, ok
+	case <-timeout.C:
+		Logger.WithField("kind", p.Kind).Warn("Git credential prompt timed out")
+		return "", false
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// answer settles a waiting prompt; a closed channel cancels it
+func (g *GitAskpass) answer(id string, value string, cancel bool) error {
+	g.mu.Lock()
+	ch, ok := g.pending[id]
+	delete(g.pending, id)
+	g.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no pending credential prompt %q", id)
+	}
+	if cancel {
+		close(ch)
+	} else {
+		ch <- value
+	}
+	return nil
+}
+
+// classifyCredentialPrompt works out what git or ssh is asking for, such
+// as "Username for 'https://github.com': " or "Enter passphrase for key"
+func classifyCredentialPrompt(id, prompt string) GitCredentialPrompt {
+	p := GitCredentialPrompt{ID: id, Prompt: strings.TrimSpace(prompt)}
+	lower := strings.ToLower(prompt)
+	switch {
+	case strings.HasPrefix(lower, "username"):
+		p.Kind = CredentialUsername
+	case strings.Contains(lower, "passphrase"):
+		p.Kind, p.Secret = CredentialPassphrase, true
+	case strings.Contains(lower, "(yes/no"):
+		p.Kind = CredentialConfirm
+	default:
+		p.Kind, p.Secret = CredentialPassword, true
+	}
+	return p
+}
+
+// AnswerGitCredential answers a git credential prompt
+func (a *App) AnswerGitCredential(id, value string) error {
+	return a.askpass.answer(id, value, false)
+}
+
+// CancelGitCredential declines a git credential prompt, so the push or pull
+// fails with an authentication error
+func (a *App) CancelGitCredential(id string) error {
+	return a.askpass.answer(id, "", true)
+}
+
+// runAskpass is the askpass helper: it forwards the prompt in args to the
+// app and prints the answer for git. It returns the exit code.
+func runAskpass(args []string) int {
+	body, _ := json.Marshal(askpassRequest{Prompt: strings.Join(args, " ")})
+	req, err := http.NewRequest(http.MethodPost, os.Getenv(askpassURLEnv), bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "treefrog askpass:", err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token, err := os.ReadFile(os.Getenv(askpassTokenFileEnv))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "treefrog askpass:", err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	client := &http.Client{Timeout: gitCredentialTimeout + 10*time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "treefrog askpass:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	answer, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "treefrog askpass: no credentials given")
+		return 1
+	}
+	fmt.Println(string(answer))
+	return 0
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -303,24 +304,6 @@ func (a *App) fetchUserInfo(sessionToken string) (*AuthUser, error) {
 		return nil, nil
 	}
 
-	req, err := http.NewRequest("GET", compilerURL+"/api/user/me", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	req.Header.Set("Authorization", "Bearer "+sessionToken)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("backend returned status %d", resp.StatusCode)
-	}
-
 	var result struct {
 		ID    string `json:"id"`
 		Email string `json:"email"`
@@ -328,8 +311,8 @@ func (a *App) fetchUserInfo(sessionToken string) (*AuthUser, error) {
 		Tier  string `json:"tier"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if err := a.newClient(compilerURL, sessionToken).GetJSON(context.Background(), "/user/me", &result); err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 
 	return &AuthUser{
@@ -452,8 +435,7 @@ func (a *App) refreshToken(refreshToken string) (accessToken, newRefreshToken st
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("apikey", supabasePublishableKey)
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := a.httpClient(10 * time.Second).Do(req)
 	if err != nil {
 		return "", "", 0, fmt.Errorf("refresh request failed: %w", err)
 	}
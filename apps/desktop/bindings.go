@@ -2,17 +2,12 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"mime/multipart"
-	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -21,6 +16,11 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/alpha-og/treefrog/packages/go/client"
+	"github.com/alpha-og/treefrog/packages/go/events"
+	"github.com/alpha-og/treefrog/packages/go/vcs"
 	"github.com/sirupsen/logrus"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
@@ -38,6 +38,7 @@ func (a *App) GetProject() (*ProjectInfo, error) {
 		Name:        name,
 		Root:        root,
 		CompilerURL: a.getCompilerURL(),
+		HasVCS:      root != "" && vcs.RepoRoot(root) != "",
 	}, nil
 }
 
@@ -83,6 +84,8 @@ func (a *App) SetProject(root string) (*ProjectInfo, error) {
 		"root":   root,
 	}).Info("Project successfully set")
 
+	a.resumePendingBuild()
+
 	return a.GetProject()
 }
 
@@ -258,6 +261,7 @@ func (a *App) WriteFile(path string, content string) error {
 		"action": "write_file",
 		"path":   path,
 	}).Debug("Successfully wrote to file")
+	a.events.Publish(events.FSChanged{Op: events.FSWrite, Path: path})
 	return nil
 }
 
@@ -273,7 +277,11 @@ func (a *App) CreateFile(path string, fileType string) error {
 	}
 
 	if fileType == "dir" {
-		return os.MkdirAll(abs, 0755)
+		if err := os.MkdirAll(abs, 0755); err != nil {
+			return err
+		}
+		a.events.Publish(events.FSChanged{Op: events.FSCreate, Path: path})
+		return nil
 	}
 
 	// Create parent directories
@@ -285,7 +293,11 @@ func (a *App) CreateFile(path string, fileType string) error {
 	if err != nil {
 		return err
 	}
-	return f.Close()
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.events.Publish(events.FSChanged{Op: events.FSCreate, Path: path})
+	return nil
 }
 
 // RenameFile renames a file or directory
@@ -303,7 +315,11 @@ func (a *App) RenameFile(from, to string) error {
 		return err
 	}
 
-	return os.Rename(fromAbs, toAbs)
+	if err := os.Rename(fromAbs, toAbs); err != nil {
+		return err
+	}
+	a.events.Publish(events.FSChanged{Op: events.FSRename, Path: to, From: from})
+	return nil
 }
 
 // DeleteFile deletes a file or directory
@@ -318,22 +334,27 @@ func (a *App) DeleteFile(path string, recursive bool) error {
 		return err
 	}
 
-	if info.IsDir() {
-		if recursive {
-			return os.RemoveAll(abs)
-		}
-		// Check if empty
-		entries, err := os.ReadDir(abs)
-		if err != nil {
-			return err
-		}
-		if len(entries) > 0 {
-			return fmt.Errorf("directory not empty")
+	if info.IsDir() && recursive {
+		err = os.RemoveAll(abs)
+	} else {
+		if info.IsDir() {
+			// Check if empty
+			entries, err := os.ReadDir(abs)
+			if err != nil {
+				return err
+			}
+			if len(entries) > 0 {
+				return fmt.Errorf("directory not empty")
+			}
 		}
-		return os.Remove(abs)
+		err = os.Remove(abs)
+	}
+	if err != nil {
+		return err
 	}
 
-	return os.Remove(abs)
+	a.events.Publish(events.FSChanged{Op: events.FSDelete, Path: path})
+	return nil
 }
 
 // MoveFile moves a file to a different directory
@@ -353,7 +374,11 @@ func (a *App) MoveFile(from, toDir string) error {
 	}
 
 	dest := filepath.Join(toDirAbs, filepath.Base(from))
-	return os.Rename(fromAbs, dest)
+	if err := os.Rename(fromAbs, dest); err != nil {
+		return err
+	}
+	a.events.Publish(events.FSChanged{Op: events.FSRename, Path: filepath.ToSlash(filepath.Join(toDir, filepath.Base(from))), From: from})
+	return nil
 }
 
 // DuplicateFile duplicates a file or directory
@@ -373,13 +398,16 @@ func (a *App) DuplicateFile(from, to string) error {
 	}
 
 	if info.IsDir() {
-		return copyDir(fromAbs, toAbs)
+		err = copyDir(fromAbs, toAbs)
+	} else if err = os.MkdirAll(filepath.Dir(toAbs), 0755); err == nil {
+		err = copyFile(fromAbs, toAbs)
 	}
-
-	if err := os.MkdirAll(filepath.Dir(toAbs), 0755); err != nil {
+	if err != nil {
 		return err
 	}
-	return copyFile(fromAbs, toAbs)
+
+	a.events.Publish(events.FSChanged{Op: events.FSCreate, Path: to})
+	return nil
 }
 
 // Build Operations
@@ -391,16 +419,62 @@ func (a *App) GetBuildStatus() BuildStatus {
 	return a.status
 }
 
+// recommendEngine suggests an engine from the preamble of the project's
+// main file, falling back to the default engine if it cannot be read
+func recommendEngine(root, mainFile string) buildopts.EngineRecommendation {
+	if mainFile == "" {
+		mainFile = buildopts.DefaultMainFile
+	}
+	if err := buildopts.ValidateMainFile(mainFile); err != nil || root == "" {
+		return buildopts.EngineRecommendation{Engine: buildopts.DefaultEngine, Reason: "no main file"}
+	}
+	analysis, err := buildopts.AnalyzeFile(filepath.Join(root, filepath.FromSlash(mainFile)))
+	if err != nil {
+		return buildopts.EngineRecommendation{Engine: buildopts.DefaultEngine, Reason: "main file not readable"}
+	}
+	return analysis.RecommendEngine()
+}
+
+// GetRecommendedEngine suggests an engine for the current project from the
+// packages its main file loads
+func (a *App) GetRecommendedEngine(mainFile string) (buildopts.EngineRecommendation, error) {
+	root := a.getRoot()
+	if root == "" {
+		return buildopts.EngineRecommendation{}, fmt.Errorf("project root not set")
+	}
+	return recommendEngine(root, mainFile), nil
+}
+
 // TriggerBuild starts a new build
 func (a *App) TriggerBuild(mainFile, engine string, shellEscape bool) error {
+	_, err := a.startBuild(mainFile, engine, shellEscape)
+	return err
+}
+
+// startBuild starts a new build in the background and returns its ID
+func (a *App) startBuild(mainFile, engine string, shellEscape bool) (string, error) {
 	Logger.Infof("TriggerBuild called - mainFile: %s, engine: %s, shellEscape: %v", mainFile, engine, shellEscape)
 
 	root := a.getRoot()
 	if root == "" {
 		Logger.Error("Cannot trigger build: project root not set")
-		return fmt.Errorf("project root not set")
+		return "", fmt.Errorf("project root not set")
+	}
+
+	if strings.TrimSpace(engine) == "" {
+		engine = recommendEngine(root, mainFile).Engine
 	}
 
+	// Shell-escape is passed through; the compiler decides whether the
+	// account may use it
+	opts := buildopts.Options{MainFile: mainFile, Engine: engine, ShellEscape: shellEscape}
+	opts.Normalize()
+	if err := opts.Validate(buildopts.Limits{AllowShellEscape: true}); err != nil {
+		Logger.Errorf("Cannot trigger build: %v", err)
+		return "", err
+	}
+	mainFile, engine = opts.MainFile, opts.Engine
+
 	a.statusMu.Lock()
 	a.status = BuildStatus{
 		ID:        fmt.Sprintf("build-%d", time.Now().Unix()),
@@ -408,6 +482,7 @@ func (a *App) TriggerBuild(mainFile, engine string, shellEscape bool) error {
 		Message:   "Starting build...",
 		StartedAt: time.Now().Format(time.RFC3339),
 	}
+	a.lastBuild = BuildOptions{MainFile: mainFile, Engine: engine, ShellEscape: shellEscape}
 	buildID := a.status.ID
 	a.statusMu.Unlock()
 
@@ -427,7 +502,7 @@ func (a *App) TriggerBuild(mainFile, engine string, shellEscape bool) error {
 		a.runBuild(mainFile, engine, shellEscape)
 	}()
 
-	return nil
+	return buildID, nil
 }
 
 // runBuild performs the actual build
@@ -469,14 +544,32 @@ func (a *App) runBuild(mainFile, engine string, shellEscape bool) {
 		a.emitBuildStatus(a.status)
 		return
 	}
+	if info, err := os.Stat(zipPath); err == nil {
+		if err := buildopts.ValidateSourceSize(info.Size(), buildopts.MaxSourceSize); err != nil {
+			Logger.Errorf("Project too large: %v", err)
+			a.statusMu.Lock()
+			a.status.State = "error"
+			a.status.Message = err.Error()
+			a.status.EndedAt = time.Now().Format(time.RFC3339)
+			a.statusMu.Unlock()
+			a.emitBuildStatus(a.status)
+			return
+		}
+	}
 	Logger.Info("Project zip created successfully")
 
-	remoteID, err := a.uploadBuild(zipPath, mainFile, engine, shellEscape, compilerURL, sessionToken)
+	apiClient := a.clientFor(compilerURL, sessionToken)
+
+	remoteID, err := a.uploadBuild(apiClient, zipPath, mainFile, engine, shellEscape)
 	if err != nil {
 		Logger.Errorf("uploadBuild failed: %v", err)
+		message := err.Error()
+		if client.IsTransient(err) && !errors.Is(err, client.ErrDegraded) {
+			message = "Builder degraded: " + message
+		}
 		a.statusMu.Lock()
 		a.status.State = "error"
-		a.status.Message = err.Error()
+		a.status.Message = message
 		a.status.EndedAt = time.Now().Format(time.RFC3339)
 		a.statusMu.Unlock()
 		a.emitBuildStatus(a.status)
@@ -485,304 +578,167 @@ func (a *App) runBuild(mainFile, engine string, shellEscape bool) {
 	Logger.Infof("Build uploaded successfully, remoteID: %s", remoteID)
 
 	a.setRemoteID(remoteID)
+	a.events.Publish(events.BuildStarted{BuildID: remoteID, MainFile: mainFile, Engine: engine})
 
-	a.pollBuildStatus(remoteID, mainFile, engine, shellEscape, compilerURL, sessionToken)
-}
-
-func (a *App) uploadBuild(zipPath, mainFile, engine string, shellEscape bool, compilerURL, sessionToken string) (string, error) {
-	Logger.Infof("Uploading build to %s - mainFile: %s, engine: %s", compilerURL, mainFile, engine)
-
-	file, err := os.Open(zipPath)
-	if err != nil {
-		Logger.Errorf("Failed to open zip file %s: %v", zipPath, err)
-		return "", err
+	// Record the build so a restart resumes it instead of orphaning it
+	a.statusMu.Lock()
+	pending := pendingBuild{
+		RemoteID:    remoteID,
+		BuildID:     a.status.ID,
+		CompilerURL: compilerURL,
+		Options:     a.lastBuild,
+		StartedAt:   a.status.StartedAt,
 	}
-	defer file.Close()
-
-	fileInfo, _ := file.Stat()
-	Logger.Debugf("Uploading zip file (size: %d bytes)", fileInfo.Size())
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	a.statusMu.Unlock()
+	if err := a.savePendingBuild(root, pending); err != nil {
+		Logger.WithError(err).Warn("Failed to record pending build")
+	}
+	defer a.clearPendingBuild(root, remoteID)
 
-	// Send as separate form fields (matching what the compiler expects)
-	_ = writer.WriteField("main_file", mainFile)
-	_ = writer.WriteField("engine", engine)
-	_ = writer.WriteField("shell_escape", fmt.Sprintf("%v", shellEscape))
-	Logger.Debugf("Build options: main_file=%s, engine=%s, shell_escape=%v", mainFile, engine, shellEscape)
+	a.pollBuildStatus(apiClient, remoteID, engine)
+}
 
-	part, err := writer.CreateFormFile("file", "source.zip")
-	if err != nil {
-		Logger.Errorf("Failed to create form file: %v", err)
-		return "", err
-	}
+func (a *App) uploadBuild(apiClient *client.Client, zipPath, mainFile, engine string, shellEscape bool) (string, error) {
+	Logger.Infof("Uploading build to %s - mainFile: %s, engine: %s", apiClient.BaseURL, mainFile, engine)
 
-	if _, err := io.Copy(part, file); err != nil {
-		Logger.Errorf("Failed to copy file to form: %v", err)
+	// A broken key must not fall back to uploading the sources in the clear
+	if _, err := a.sourceKey(); err != nil {
 		return "", err
 	}
-	writer.Close()
 
-	req, err := http.NewRequest("POST", compilerURL+"/api/build", body)
+	file, err := os.Open(zipPath)
 	if err != nil {
-		Logger.Errorf("Failed to create HTTP request: %v", err)
+		Logger.Errorf("Failed to open zip file %s: %v", zipPath, err)
 		return "", err
 	}
+	defer file.Close()
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	if sessionToken != "" {
-		req.Header.Set("Authorization", "Bearer "+sessionToken)
-	}
+	Logger.Debugf("Build options: main_file=%s, engine=%s, shell_escape=%v", mainFile, engine, shellEscape)
 
-	Logger.Debugf("Sending HTTP POST request to %s/api/build", compilerURL)
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	build, err := apiClient.CreateBuild(context.Background(), file, api.BuildOptions{
+		MainFile:    mainFile,
+		Engine:      api.Engine(engine),
+		ShellEscape: shellEscape,
+	})
 	if err != nil {
-		Logger.Errorf("HTTP request failed: %v", err)
+		Logger.Errorf("Build upload failed: %v", err)
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	Logger.Debugf("Upload response status: %d", resp.StatusCode)
-	defer resp.Body.Close()
-
-	// Accept both 200 OK (remote compiler) and 202 Accepted (local compiler)
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		respBody, _ := io.ReadAll(resp.Body)
-		Logger.Errorf("Compiler returned unexpected status %d: %s", resp.StatusCode, string(respBody))
-		return "", fmt.Errorf("compiler error (status %d): %s", resp.StatusCode, string(respBody))
-	}
-
-	var result struct {
-		ID string `json:"id"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
-
-	return result.ID, nil
+	return build.ID, nil
 }
 
-func (a *App) pollBuildStatus(remoteID, mainFile, engine string, shellEscape bool, compilerURL, sessionToken string) {
+func (a *App) pollBuildStatus(apiClient *client.Client, remoteID, engine string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
 	buildStart := time.Now()
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			a.statusMu.Lock()
-			a.status.State = "error"
-			a.status.Message = "Build timeout"
-			a.status.EndedAt = time.Now().Format(time.RFC3339)
-			a.statusMu.Unlock()
-			if a.metrics != nil {
-				a.metrics.RecordAttempt(false, time.Since(buildStart))
-			}
-			a.emitBuildStatus(a.status)
-			return
-		case <-ticker.C:
-			status, statusMessage, err := a.checkRemoteBuild(remoteID, compilerURL, sessionToken)
-			if err != nil {
-				Logger.Errorf("checkRemoteBuild error: %v", err)
-				a.statusMu.Lock()
-				a.status.State = "error"
-				a.status.Message = err.Error()
-				a.status.EndedAt = time.Now().Format(time.RFC3339)
-				statusCopy := a.status
-				a.statusMu.Unlock()
-				if a.metrics != nil {
-					a.metrics.RecordAttempt(false, time.Since(buildStart))
-				}
-				a.emitBuildStatus(statusCopy)
-				return
-			}
-
-			Logger.Infof("Build status poll returned: %s", status)
-
-			// Map compiler status to frontend status
-			displayStatus := status
-			if status == "pending" {
-				displayStatus = "queued"
-			} else if status == "compiling" {
-				displayStatus = "running"
-			} else if status == "retrying" {
-				displayStatus = "retrying"
-			}
-
-			// Use server message if available, otherwise default
-			displayMessage := fmt.Sprintf("Build %s", status)
-			if statusMessage != "" {
-				displayMessage = statusMessage
-			}
 
+	final, err := apiClient.WaitForBuild(ctx, remoteID, func(status *api.StatusResponse, err error) {
+		if err != nil {
+			// The compiler is unreachable; keep the build alive and say so
+			// rather than failing it
+			Logger.Warnf("Build status poll failed, compiler degraded: %v", err)
 			a.statusMu.Lock()
-			a.status.State = displayStatus
-			a.status.Message = displayMessage
+			a.status.State = api.StateDegraded
+			a.status.Message = "Builder degraded, retrying..."
 			statusCopy := a.status
 			a.statusMu.Unlock()
 			a.emitBuildStatus(statusCopy)
-
-			if status == "completed" || status == "success" {
-				Logger.Info("Build completed, downloading PDF...")
-				if err := a.downloadPDF(remoteID, compilerURL, sessionToken); err != nil {
-					Logger.Errorf("PDF download failed: %v", err)
-					a.statusMu.Lock()
-					a.status.State = "error"
-					a.status.Message = err.Error()
-					a.status.EndedAt = time.Now().Format(time.RFC3339)
-					a.statusMu.Unlock()
-					if a.metrics != nil {
-						a.metrics.RecordAttempt(false, time.Since(buildStart))
-					}
-					a.emitBuildStatus(a.status)
-					return
-				}
-				a.statusMu.Lock()
-				a.status.State = "success"
-				a.status.EndedAt = time.Now().Format(time.RFC3339)
-				a.statusMu.Unlock()
-				if a.metrics != nil {
-					a.metrics.RecordAttempt(true, time.Since(buildStart))
-				}
-				a.emitBuildStatus(a.status)
-				return
-			}
-
-			if status == "failed" || status == "error" {
-				a.statusMu.Lock()
-				a.status.State = "error"
-				a.status.EndedAt = time.Now().Format(time.RFC3339)
-				a.statusMu.Unlock()
-				if a.metrics != nil {
-					a.metrics.RecordAttempt(false, time.Since(buildStart))
-				}
-				a.emitBuildStatus(a.status)
-				return
-			}
+			return
 		}
-	}
-}
-
-func (a *App) checkRemoteBuild(remoteID, compilerURL, sessionToken string) (status string, message string, err error) {
-	Logger.Debugf("Checking remote build status for: %s", remoteID)
 
-	url := compilerURL + "/api/build/" + remoteID + "/status"
+		Logger.Infof("Build status poll returned: %s", status.Status)
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		Logger.Errorf("Failed to create HTTP request: %v", err)
-		return "", "", err
-	}
+		displayStatus := api.ClientStateFor(status.Status)
+		if displayStatus == api.StateIdle || status.Status.Terminal() {
+			// Unknown states keep polling; final states are reported
+			// once the PDF is downloaded or the failure recorded
+			displayStatus = api.StateRunning
+		}
 
-	if sessionToken != "" {
-		req.Header.Set("Authorization", "Bearer "+sessionToken)
-	}
+		// Use server message if available, otherwise default
+		displayMessage := fmt.Sprintf("Build %s", status.Status)
+		if status.Message != "" {
+			displayMessage = status.Message
+		}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+		a.statusMu.Lock()
+		a.status.State = displayStatus
+		a.status.Message = displayMessage
+		statusCopy := a.status
+		a.statusMu.Unlock()
+		a.emitBuildStatus(statusCopy)
+	})
 	if err != nil {
-		Logger.Errorf("Build status check failed: %v", err)
-		return "", "", err
+		Logger.Errorf("Waiting for build failed: %v", err)
+		message := err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			message = "Build timeout"
+			if apiClient.Degraded() {
+				message = "Build timeout: builder degraded"
+			}
+		}
+		a.finishBuild(api.StateError, message, engine, buildStart)
+		return
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		Logger.Errorf("Failed to read response body: %v", err)
-		return "", "", err
+	if final.Status != api.StatusCompleted {
+		a.finishBuild(api.StateError, "", engine, buildStart)
+		return
 	}
 
-	var result struct {
-		Status  string `json:"status"`
-		Message string `json:"message"`
-		Error   string `json:"error"`
-	}
-	if err := json.Unmarshal(body, &result); err != nil {
-		Logger.Errorf("Failed to unmarshal build status response: %v", err)
-		return "", "", err
+	Logger.Info("Build completed, downloading PDF...")
+	if err := a.downloadPDF(apiClient, remoteID); err != nil {
+		Logger.Errorf("PDF download failed: %v", err)
+		a.finishBuild(api.StateError, err.Error(), engine, buildStart)
+		return
 	}
-
-	Logger.Debugf("Build status for %s: %s (message: %s)", remoteID, result.Status, result.Message)
-	return result.Status, result.Message, nil
+	a.finishBuild(api.StateSuccess, "Build completed", engine, buildStart)
 }
 
-func (a *App) downloadPDF(remoteID, compilerURL, sessionToken string) error {
-	Logger.Infof("Downloading PDF for build: %s", remoteID)
-
-	// Step 1: Get signed URL for PDF
-	signedURLReq, err := http.NewRequest("GET", compilerURL+"/api/build/"+remoteID+"/pdf/url", nil)
-	if err != nil {
-		Logger.Errorf("Failed to create signed URL request: %v", err)
-		return err
-	}
-	if sessionToken != "" {
-		signedURLReq.Header.Set("Authorization", "Bearer "+sessionToken)
+// reportTransferProgress shows the progress of the project upload and PDF
+// download in the build status message
+func (a *App) reportTransferProgress(p client.Progress) {
+	verb := "Uploading project"
+	if p.Op == client.OpDownload {
+		verb = "Downloading PDF"
 	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	signedURLResp, err := client.Do(signedURLReq)
-	if err != nil {
-		Logger.Errorf("Signed URL request failed: %v", err)
-		return fmt.Errorf("failed to get signed URL: %w", err)
-	}
-	defer signedURLResp.Body.Close()
-
-	if signedURLResp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(signedURLResp.Body)
-		Logger.Errorf("Signed URL request returned status %d: %s", signedURLResp.StatusCode, string(body))
-		return fmt.Errorf("failed to get signed URL: status %d", signedURLResp.StatusCode)
-	}
-
-	var signedURLResult struct {
-		URL string `json:"url"`
-	}
-	if err := json.NewDecoder(signedURLResp.Body).Decode(&signedURLResult); err != nil {
-		Logger.Errorf("Failed to decode signed URL response: %v", err)
-		return fmt.Errorf("failed to decode signed URL: %w", err)
-	}
-
-	if signedURLResult.URL == "" {
-		Logger.Error("Signed URL is empty")
-		return fmt.Errorf("signed URL is empty")
-	}
-
-	Logger.Debugf("Got signed URL for PDF download")
-
-	// Step 2: Download PDF using signed URL
-	// The signed URL is a relative path, prepend the compiler URL
-	downloadURL := signedURLResult.URL
-	if !strings.HasPrefix(downloadURL, "http") {
-		downloadURL = compilerURL + downloadURL
+	message := verb + "..."
+	if pct := p.Percent(); pct >= 0 {
+		message = fmt.Sprintf("%s... %.0f%%", verb, pct)
+		if eta := p.ETA(); eta >= time.Second {
+			message += fmt.Sprintf(" (%s left)", eta.Round(time.Second))
+		}
 	}
 
-	req, err := http.NewRequest("GET", downloadURL, nil)
-	if err != nil {
-		Logger.Errorf("Failed to create PDF download request: %v", err)
-		return err
-	}
+	a.statusMu.Lock()
+	a.status.Message = message
+	statusCopy := a.status
+	a.statusMu.Unlock()
+	a.emitBuildStatus(statusCopy)
+}
 
-	// Still need auth header - signed URL provides additional verification
-	if sessionToken != "" {
-		req.Header.Set("Authorization", "Bearer "+sessionToken)
+// finishBuild records the final state of a remote build. An empty message
+// keeps the last message reported by the compiler.
+func (a *App) finishBuild(state api.ClientState, message, engine string, buildStart time.Time) {
+	a.statusMu.Lock()
+	a.status.State = state
+	if message != "" {
+		a.status.Message = message
 	}
-
-	// Signed URL doesn't need auth header - the token is in the URL
-	resp, err := client.Do(req)
-	if err != nil {
-		Logger.Errorf("PDF download request failed: %v", err)
-		return fmt.Errorf("PDF download failed: %w", err)
+	a.status.EndedAt = time.Now().Format(time.RFC3339)
+	statusCopy := a.status
+	a.statusMu.Unlock()
+	if a.metrics != nil {
+		a.metrics.RecordAttempt(state == api.StateSuccess, time.Since(buildStart), engine, a.getRoot())
 	}
-	defer resp.Body.Close()
+	a.emitBuildStatus(statusCopy)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		Logger.Errorf("PDF download returned status %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("PDF download failed with status %s: %s", resp.Status, string(body))
-	}
+func (a *App) downloadPDF(apiClient *client.Client, remoteID string) error {
+	Logger.Infof("Downloading PDF for build: %s", remoteID)
 
 	pdfPath := filepath.Join(a.cacheDir, "last.pdf")
 
@@ -793,10 +749,10 @@ func (a *App) downloadPDF(remoteID, compilerURL, sessionToken string) error {
 	}
 	defer file.Close()
 
-	n, err := io.Copy(file, resp.Body)
+	n, err := apiClient.DownloadArtifact(context.Background(), remoteID, "pdf", file)
 	if err != nil {
-		Logger.Errorf("Failed to save PDF: %v", err)
-		return fmt.Errorf("failed to save PDF: %w", err)
+		Logger.Errorf("PDF download failed: %v", err)
+		return fmt.Errorf("PDF download failed: %w", err)
 	}
 
 	if n == 0 {
@@ -826,59 +782,33 @@ func (a *App) downloadPDF(remoteID, compilerURL, sessionToken string) error {
 	}
 
 	Logger.Infof("PDF validated successfully: %s", pdfPath)
+	a.events.Publish(events.ArtifactReady{BuildID: remoteID, Resource: "pdf", Path: pdfPath})
 
-	if err := a.downloadBuildLog(remoteID, compilerURL, sessionToken); err != nil {
+	if err := a.downloadBuildLog(apiClient, remoteID); err != nil {
 		Logger.Warnf("Failed to download build log: %v", err)
 	}
 
 	return nil
 }
 
-func (a *App) downloadBuildLog(remoteID, compilerURL, sessionToken string) error {
+func (a *App) downloadBuildLog(apiClient *client.Client, remoteID string) error {
 	Logger.Infof("Downloading build log for build: %s", remoteID)
 
-	url := compilerURL + "/api/build/" + remoteID + "/log"
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		Logger.Errorf("Failed to create build log request: %v", err)
-		return err
-	}
-
-	if sessionToken != "" {
-		req.Header.Set("Authorization", "Bearer "+sessionToken)
-	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	buildLog, err := apiClient.Log(context.Background(), remoteID)
 	if err != nil {
-		Logger.Errorf("Build log download failed: %v", err)
+		Logger.Warnf("Build log download failed: %v", err)
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		Logger.Warnf("Build log download returned status %d: %s", resp.StatusCode, string(body))
-		return fmt.Errorf("failed to download build log: %s", resp.Status)
-	}
 
 	logPath := filepath.Join(a.cacheDir, "build.log")
-
-	file, err := os.Create(logPath)
-	if err != nil {
-		Logger.Errorf("Failed to create build log file: %v", err)
-		return err
-	}
-	defer file.Close()
-
-	n, err := io.Copy(file, resp.Body)
-	if err != nil {
+	if err := os.WriteFile(logPath, []byte(buildLog), 0644); err != nil {
 		Logger.Errorf("Failed to save build log: %v", err)
 		return err
 	}
 
-	Logger.Debugf("Build log downloaded successfully (%d bytes)", n)
+	Logger.Debugf("Build log downloaded successfully (%d bytes)", len(buildLog))
+	a.events.PublishLog(remoteID, buildLog)
+	a.events.Publish(events.ArtifactReady{BuildID: remoteID, Resource: "log", Path: logPath})
 	return nil
 }
 
@@ -989,7 +919,8 @@ func (a *App) GetPDFContent() (string, error) {
 	return encoded, nil
 }
 
-// ExportPDF exports the PDF to a user-selected location
+// ExportPDF exports the PDF to a user-selected location, suggesting the
+// name the project's export_name preset gives it
 func (a *App) ExportPDF() (string, error) {
 	pdfPath, err := a.GetPDFPath()
 	if err != nil {
@@ -998,7 +929,7 @@ func (a *App) ExportPDF() (string, error) {
 
 	savePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
 		Title:                "Export PDF",
-		DefaultFilename:      "document.pdf",
+		DefaultFilename:      a.exportFileName(),
 		ShowHiddenFiles:      false,
 		CanCreateDirectories: true,
 	})
@@ -1012,6 +943,43 @@ func (a *App) ExportPDF() (string, error) {
 	return savePath, copyFile(pdfPath, savePath)
 }
 
+// exportFileName names the last built PDF with the export_name preset of
+// the target that builds its main file, or of the project, in .treefrog.json
+func (a *App) exportFileName() string {
+	a.statusMu.Lock()
+	last := a.lastBuild
+	a.statusMu.Unlock()
+	if last.MainFile == "" {
+		last.MainFile = buildopts.DefaultMainFile
+	}
+
+	vars := buildopts.ExportVars{Main: last.MainFile, Engine: last.Engine}
+	var template string
+	if root := a.getRoot(); root != "" {
+		vars.Project = filepath.Base(root)
+		if vcs.RepoRoot(root) != "" {
+			if commit, err := vcs.Head(a.ctx, root); err == nil {
+				vars.GitSHA = vcs.ShortHash(commit)
+			}
+		}
+		if settings, err := buildopts.LoadProjectSettings(root); err != nil {
+			Logger.WithError(err).Warn("Ignoring export name preset")
+		} else {
+			template = settings.ExportNameFor("", last.MainFile)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(a.cacheDir, "last.pdf")); err == nil {
+		vars.Time = info.ModTime()
+	}
+
+	name, err := buildopts.ExportName(template, vars)
+	if err != nil {
+		Logger.WithError(err).Warn("Invalid export name preset")
+		name, _ = buildopts.ExportName("", vars)
+	}
+	return name
+}
+
 // ExportSource exports the project source as a zip
 func (a *App) ExportSource() (string, error) {
 	root := a.getRoot()
@@ -1044,16 +1012,71 @@ func (a *App) GitStatus() (*GitStatus, error) {
 		return nil, fmt.Errorf("project root not set")
 	}
 
-	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+	if vcs.RepoRoot(root) == "" {
 		return &GitStatus{Raw: "not a git repository"}, nil
 	}
 
-	out, err := runGit(root, "status", "--porcelain=v1", "-b")
+	raw, status, err := vcs.ReadStatus(a.ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GitStatus{Raw: raw, Repo: true, Status: status}, nil
+}
+
+// GitInit makes the project a git repository with a LaTeX .gitignore and
+// commits its files. An empty message uses vcs.DefaultCommitMessage.
+func (a *App) GitInit(message string) (*vcs.InitResult, error) {
+	root := a.getRoot()
+	if root == "" {
+		return nil, fmt.Errorf("project root not set")
+	}
+
+	result, err := vcs.Init(a.ctx, root, message)
+	if err != nil {
+		Logger.WithError(err).WithField("root", root).Error("Git init failed")
+		return nil, err
+	}
+	Logger.WithFields(logrus.Fields{
+		"action": "git_init",
+		"root":   root,
+		"commit": result.Commit,
+	}).Info("Initialized git repository")
+	return result, nil
+}
+
+// GitBlame returns who last changed each line of a project file, as ranges
+// of lines by commit
+func (a *App) GitBlame(path string) ([]vcs.BlameRange, error) {
+	abs, err := a.safePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if vcs.RepoRoot(filepath.Dir(abs)) == "" {
+		return nil, fmt.Errorf("not a git repository")
+	}
+
+	ranges, err := vcs.BlameFile(a.ctx, abs)
 	if err != nil {
+		Logger.WithError(err).WithField("path", path).Warn("Git blame failed")
 		return nil, err
 	}
+	return ranges, nil
+}
+
+// GitSuggestCommitMessage suggests a commit message for all changes in the
+// project from the files changed, the LaTeX sections touched and the word
+// delta. With conventional the subject gets a "type(scope): " prefix.
+func (a *App) GitSuggestCommitMessage(conventional bool) (*vcs.CommitSuggestion, error) {
+	root := a.getRoot()
+	if root == "" {
+		return nil, fmt.Errorf("project root not set")
+	}
+	if vcs.RepoRoot(root) == "" {
+		return nil, fmt.Errorf("not a git repository")
+	}
 
-	return &GitStatus{Raw: out}, nil
+	return vcs.SuggestCommitMessage(a.ctx, root, true, conventional)
 }
 
 // sanitizeGitInput sanitizes user input for git commands to prevent command injection
@@ -1090,6 +1113,22 @@ func runGit(root string, args ...string) (string, error) {
 	return string(out), err
 }
 
+// runGitRemote executes a git command that talks to a remote, routing any
+// credential prompt to the frontend
+func (a *App) runGitRemote(root string, args ...string) (string, error) {
+	cmd, err := a.askpass.Command(a.ctx, root, args...)
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			err = fmt.Errorf("git %s: %s", args[0], msg)
+		}
+	}
+	return string(out), err
+}
+
 // GitCommit commits changes
 func (a *App) GitCommit(message string, files []string, all bool) error {
 	Logger.WithFields(logrus.Fields{
@@ -1125,21 +1164,122 @@ func (a *App) GitCommit(message string, files []string, all bool) error {
 	return err
 }
 
-// GitPush pushes commits
-func (a *App) GitPush(remote string) error {
+// pushCompileTimeout caps how long a push waits for its compile check
+const pushCompileTimeout = 10 * time.Minute
+
+// SetCompileBeforePush toggles compiling the project before every push and
+// refusing to push if it fails
+func (a *App) SetCompileBeforePush(enabled bool) error {
+	a.configMu.Lock()
+	a.config.CompileBeforePush = enabled
+	a.configMu.Unlock()
+	return a.saveConfig()
+}
+
+func (a *App) compileBeforePushEnabled() bool {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	return a.config.CompileBeforePush
+}
+
+// compileForPush builds the commit being pushed, the project as committed
+// at HEAD rather than the working tree, with the options of the last build
+// and returns an error unless it compiles. The check runs beside the app's
+// own builds and leaves the preview alone.
+func (a *App) compileForPush() error {
+	root := a.getRoot()
+	a.statusMu.Lock()
+	last := a.lastBuild
+	a.statusMu.Unlock()
+	if last.MainFile == "" {
+		last.MainFile = buildopts.DefaultMainFile
+	}
+	if strings.TrimSpace(last.Engine) == "" {
+		last.Engine = recommendEngine(root, last.MainFile).Engine
+	}
+	opts := buildopts.Options{MainFile: last.MainFile, Engine: last.Engine, ShellEscape: last.ShellEscape}
+	opts.Normalize()
+	if err := opts.Validate(buildopts.Limits{AllowShellEscape: true}); err != nil {
+		return fmt.Errorf("push blocked: %w", err)
+	}
+
+	zipPath := filepath.Join(a.cacheDir, "push-check.zip")
+	defer os.Remove(zipPath)
+	if err := archiveHead(root, zipPath); err != nil {
+		return fmt.Errorf("push blocked: %w", err)
+	}
+	if info, err := os.Stat(zipPath); err == nil {
+		if err := buildopts.ValidateSourceSize(info.Size(), buildopts.MaxSourceSize); err != nil {
+			return fmt.Errorf("push blocked: %w", err)
+		}
+	}
+
+	Logger.WithField("main_file", opts.MainFile).Info("Compiling HEAD before push")
+	apiClient := a.clientFor(a.getCompilerURL(), a.GetSessionToken())
+	remoteID, err := a.uploadBuild(apiClient, zipPath, opts.MainFile, opts.Engine, opts.ShellEscape)
+	if err != nil {
+		return fmt.Errorf("push blocked: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pushCompileTimeout)
+	defer cancel()
+	final, err := apiClient.WaitForBuild(ctx, remoteID, nil)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("push blocked: the compile check timed out")
+	}
+	if err != nil {
+		return fmt.Errorf("push blocked: %w", err)
+	}
+	if final.Status != api.StatusCompleted {
+		return fmt.Errorf("push blocked: the committed project does not compile (%s); push without the compile check to override", final.Message)
+	}
+	return nil
+}
+
+// archiveHead zips the project directory as committed at HEAD, which is
+// what a push sends
+func archiveHead(root, dest string) error {
+	out, err := runGit(root, "rev-parse", "--show-toplevel", "--show-prefix")
+	if err != nil {
+		return fmt.Errorf("not a git repository: %s", strings.TrimSpace(out))
+	}
+	// A project in a subdirectory is archived from the repository's top,
+	// as the tree of its directory
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	top, prefix := lines[0], ""
+	if len(lines) > 1 {
+		prefix = lines[1]
+	}
+	out, err = runGit(top, "archive", "--format=zip", "--output="+dest, "HEAD:"+prefix)
+	if err != nil {
+		return fmt.Errorf("failed to archive HEAD: %s", strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// GitPush pushes commits. With the CompileBeforePush setting the project
+// is compiled first and the push refused if that fails, unless skipCompile.
+func (a *App) GitPush(remote string, skipCompile bool) error {
 	root := a.getRoot()
 	if root == "" {
 		Logger.Error("Cannot push: project root not set")
 		return fmt.Errorf("project root not set")
 	}
 
+	if a.compileBeforePushEnabled() && !skipCompile {
+		if err := a.compileForPush(); err != nil {
+			Logger.WithError(err).Warn("Push refused by compile check")
+			return err
+		}
+	}
+
 	args := []string{"push"}
 	if remote != "" {
 		args = append(args, sanitizeGitInput(remote))
 	}
 
 	Logger.WithField("remote", remote).Info("Pushing to git remote")
-	out, err := runGit(root, args...)
+	out, err := a.runGitRemote(root, args...)
 	if err != nil {
 		Logger.WithError(err).WithField("output", out).Error("Git push failed")
 		return err
@@ -1163,7 +1303,7 @@ func (a *App) GitPull(remote string) error {
 	}
 
 	Logger.WithField("remote", remote).Info("Pulling from git remote")
-	out, err := runGit(root, args...)
+	out, err := a.runGitRemote(root, args...)
 	if err != nil {
 		Logger.WithError(err).WithField("output", out).Error("Git pull failed")
 		return err
@@ -1189,40 +1329,9 @@ func (a *App) SyncTeXView(file string, line, col int) (*SyncTeXResult, error) {
 		"col":  col,
 	}).Debug("SyncTeX forward search request")
 
-	compilerURL := a.getCompilerURL()
-	url := fmt.Sprintf("%s/api/build/%s/synctex/view?file=%s&line=%d",
-		compilerURL, remoteID, url.QueryEscape(file), line)
-	if col > 0 {
-		url += fmt.Sprintf("&col=%d", col)
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		Logger.WithError(err).Error("Failed to create SyncTeX view request")
-		return nil, err
-	}
-
-	sessionToken := a.GetSessionToken()
-	if sessionToken != "" {
-		req.Header.Set("Authorization", "Bearer "+sessionToken)
-	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	result, err := a.compilerClient().SyncTeXView(context.Background(), remoteID, file, line, col)
 	if err != nil {
-		Logger.WithError(err).Error("SyncTeX view request failed")
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		Logger.WithField("status", resp.Status).Error("SyncTeX view failed")
-		return nil, fmt.Errorf("synctex failed: %s", resp.Status)
-	}
-
-	var result SyncTeXResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		Logger.WithError(err).Error("Failed to decode SyncTeX view response")
+		Logger.WithError(err).Error("SyncTeX view failed")
 		return nil, err
 	}
 
@@ -1232,7 +1341,8 @@ func (a *App) SyncTeXView(file string, line, col int) (*SyncTeXResult, error) {
 		"y":    result.Y,
 	}).Debug("SyncTeX view completed")
 
-	return &result, nil
+	view := SyncTeXResult(*result)
+	return &view, nil
 }
 
 // SyncTeXEdit navigates from PDF to source
@@ -1249,37 +1359,9 @@ func (a *App) SyncTeXEdit(page int, x, y float64) (*SyncTeXResult, error) {
 		"y":    y,
 	}).Debug("SyncTeX reverse search request")
 
-	compilerURL := a.getCompilerURL()
-	url := fmt.Sprintf("%s/api/build/%s/synctex/edit?page=%d&x=%f&y=%f",
-		compilerURL, remoteID, page, x, y)
-
-	req, err := http.NewRequest("GET", url, nil)
+	result, err := a.compilerClient().SyncTeXEdit(context.Background(), remoteID, page, x, y)
 	if err != nil {
-		Logger.WithError(err).Error("Failed to create SyncTeX edit request")
-		return nil, err
-	}
-
-	sessionToken := a.GetSessionToken()
-	if sessionToken != "" {
-		req.Header.Set("Authorization", "Bearer "+sessionToken)
-	}
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		Logger.WithError(err).Error("SyncTeX edit request failed")
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		Logger.WithField("status", resp.Status).Error("SyncTeX edit failed")
-		return nil, fmt.Errorf("synctex failed: %s", resp.Status)
-	}
-
-	var result SyncTeXResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		Logger.WithError(err).Error("Failed to decode SyncTeX edit response")
+		Logger.WithError(err).Error("SyncTeX edit failed")
 		return nil, err
 	}
 
@@ -1289,7 +1371,8 @@ func (a *App) SyncTeXEdit(page int, x, y float64) (*SyncTeXResult, error) {
 		"col":  result.Col,
 	}).Debug("SyncTeX edit completed")
 
-	return &result, nil
+	edit := SyncTeXResult(*result)
+	return &edit, nil
 }
 
 // Renderer lifecycle management endpoints
@@ -1358,6 +1441,23 @@ func (a *App) SetRendererPort(port int) error {
 	return a.saveConfig()
 }
 
+// SetRendererSocket sets the Unix socket the local renderer listens on.
+// An empty path switches it back to its TCP port.
+func (a *App) SetRendererSocket(path string) error {
+	if path != "" && !filepath.IsAbs(path) {
+		return fmt.Errorf("socket path must be absolute")
+	}
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+
+	if a.config.Renderer == nil {
+		a.config.Renderer = DefaultRendererConfig()
+	}
+
+	a.config.Renderer.Socket = path
+	return a.saveConfig()
+}
+
 // SetRendererAutoStart updates the auto-start setting
 func (a *App) SetRendererAutoStart(enabled bool) error {
 	a.configMu.Lock()
@@ -1419,6 +1519,26 @@ func (a *App) SetImageSource(source string, ref string) error {
 	return a.saveConfig()
 }
 
+// SetImageDigest pins the renderer image to digest, or unpins it if digest
+// is empty. It takes effect the next time the renderer starts.
+func (a *App) SetImageDigest(digest string) error {
+	if digest != "" {
+		if err := ValidateImageDigest(digest); err != nil {
+			return err
+		}
+	}
+
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+
+	if a.config.Renderer == nil {
+		a.config.Renderer = DefaultRendererConfig()
+	}
+
+	a.config.Renderer.ImageDigest = digest
+	return a.saveConfig()
+}
+
 func (a *App) VerifyCustomImage(path string) bool {
 	a.configMu.Lock()
 	a.config.Renderer.CustomTarPath = path
@@ -1449,6 +1569,15 @@ func (a *App) GetCompilationMetrics() CompilationMetrics {
 	return a.metrics.GetMetrics()
 }
 
+// GetMetricsHistory returns daily, per-engine and per-project build
+// statistics for the last days days
+func (a *App) GetMetricsHistory(days int) MetricsHistory {
+	if a.metrics == nil {
+		return MetricsHistory{Days: days}
+	}
+	return a.metrics.History(days)
+}
+
 func (a *App) ResetCompilationMetrics() error {
 	if a.metrics == nil {
 		return fmt.Errorf("metrics not initialized")
@@ -1469,6 +1598,15 @@ func (a *App) GetRemoteCompilerHealth() RemoteCompilerHealth {
 	return a.remoteMonitor.GetHealth()
 }
 
+// GetRemoteCompilerHealthHistory returns the recent health checks of the
+// remote compiler for charting
+func (a *App) GetRemoteCompilerHealthHistory() RemoteCompilerHealthHistory {
+	if a.remoteMonitor == nil {
+		return RemoteCompilerHealthHistory{URL: a.config.RemoteCompilerURL, Samples: []HealthSample{}}
+	}
+	return a.remoteMonitor.GetHistory()
+}
+
 func (a *App) IsRemoteCompilerHealthy() bool {
 	if a.remoteMonitor == nil {
 		return false
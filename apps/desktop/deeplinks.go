@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// SyncTeXJump is emitted as "synctex-jump" when a deep link asks the preview
+// to show a source location
+type SyncTeXJump struct {
+	File   string         `json:"file"`
+	Line   int            `json:"line"`
+	Col    int            `json:"col,omitempty"`
+	Result *SyncTeXResult `json:"result"`
+}
+
+// handleDeepLink dispatches treefrog:// URLs other than auth callbacks:
+//
+//	treefrog://open?path=<file or dir>
+//	treefrog://build?project=<dir>&main=<file>&engine=<engine>
+//	treefrog://synctex?file=<file>&line=<n>&col=<n>
+//
+// Links that arrive before the window is ready are queued until domReady.
+func (a *App) handleDeepLink(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid deep link: %w", err)
+	}
+
+	if a.ctx == nil {
+		a.launchMu.Lock()
+		a.pendingLinks = append(a.pendingLinks, rawURL)
+		a.launchMu.Unlock()
+		return nil
+	}
+
+	q := u.Query()
+	switch u.Host {
+	case "open":
+		path := q.Get("path")
+		if !isLaunchPath(path) {
+			return fmt.Errorf("path does not exist: %s", path)
+		}
+		a.openLaunchPath(path)
+		return nil
+
+	case "build":
+		if project := q.Get("project"); project != "" {
+			if err := a.switchProject(project); err != nil {
+				return err
+			}
+		}
+		return a.deepLinkBuild(q.Get("main"), q.Get("engine"))
+
+	case "synctex":
+		line, err := strconv.Atoi(q.Get("line"))
+		if err != nil || line < 1 {
+			return fmt.Errorf("invalid line: %q", q.Get("line"))
+		}
+		col, _ := strconv.Atoi(q.Get("col"))
+		return a.deepLinkSyncTeX(q.Get("file"), line, col)
+
+	default:
+		return fmt.Errorf("unknown deep link action: %s", u.Host)
+	}
+}
+
+// switchProject opens project unless it is already the current root
+func (a *App) switchProject(project string) error {
+	abs, err := filepath.Abs(project)
+	if err != nil {
+		return err
+	}
+	if abs == a.getRoot() {
+		return nil
+	}
+	if !isLaunchPath(abs) {
+		return fmt.Errorf("project does not exist: %s", project)
+	}
+	a.openLaunchPath(abs)
+	return nil
+}
+
+// deepLinkBuild starts a build, falling back to the last build's options.
+// Shell escape is never enabled from a link, since links can come from any page.
+func (a *App) deepLinkBuild(mainFile, engine string) error {
+	a.statusMu.Lock()
+	last := a.lastBuild
+	a.statusMu.Unlock()
+
+	if mainFile == "" {
+		mainFile = last.MainFile
+	}
+	if mainFile == "" {
+		mainFile = buildopts.DefaultMainFile
+	}
+	if engine == "" {
+		engine = last.Engine
+	}
+	return a.TriggerBuild(mainFile, engine, false)
+}
+
+// deepLinkSyncTeX resolves a source location to a PDF position. Absolute
+// paths outside the current project open their project first.
+func (a *App) deepLinkSyncTeX(file string, line, col int) error {
+	if file == "" {
+		return fmt.Errorf("file required")
+	}
+
+	if filepath.IsAbs(file) {
+		root := a.getRoot()
+		rel, err := filepath.Rel(root, file)
+		if root == "" || err != nil || strings.HasPrefix(rel, "..") {
+			projectRoot, relFile, err := findProjectRoot(file)
+			if err != nil {
+				return err
+			}
+			if err := a.switchProject(projectRoot); err != nil {
+				return err
+			}
+			rel = relFile
+		}
+		file = filepath.ToSlash(rel)
+	}
+
+	result, err := a.SyncTeXView(file, line, col)
+	if err != nil {
+		return err
+	}
+
+	runtime.WindowShow(a.ctx)
+	runtime.EventsEmit(a.ctx, "synctex-jump", SyncTeXJump{File: file, Line: line, Col: col, Result: result})
+	return nil
+}
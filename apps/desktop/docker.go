@@ -5,16 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"net/url"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/alpha-og/treefrog/packages/go/client"
 	"github.com/sirupsen/logrus"
 )
 
+// containerSocketDir is where the socket directory is mounted in the
+// container
+const containerSocketDir = "/run/treefrog"
+
 // RendererStatus represents the current state
 type RendererStatus struct {
 	State   string       `json:"state"` // running|stopped|error|not-installed|building
@@ -126,10 +133,19 @@ func (dm *DockerManager) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to prepare image: %w", err)
 	}
 
-	// Handle port with intelligent fallback
-	port, err := dm.resolvePort(ctx)
+	// Verify the image before every run, not only after a pull
+	digest, err := dm.imageMgr.VerifyDigest(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("image verification failed: %w", err)
+	}
+	dm.logger.WithField("digest", digest).Info("Using renderer image")
+
+	// Handle port with intelligent fallback; a socket needs no port
+	port := 0
+	if !dm.config.UsesSocket() {
+		if port, err = dm.resolvePort(ctx); err != nil {
+			return err
+		}
 	}
 
 	// Force remove any existing container (including zombie containers)
@@ -218,6 +234,11 @@ func (dm *DockerManager) startContainerWithRetry(ctx context.Context, port int)
 		delay = DefaultRetryDelay
 	}
 
+	args, err := dm.runArgs(port)
+	if err != nil {
+		return err
+	}
+
 	var lastErr error
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		dm.logger.WithFields(logrus.Fields{
@@ -225,10 +246,7 @@ func (dm *DockerManager) startContainerWithRetry(ctx context.Context, port int)
 			"attempt": attempt + 1,
 		}).Debug("Starting container")
 
-		cmd := exec.CommandContext(ctx, "docker", "run", "-d", "--rm",
-			"-p", fmt.Sprintf("127.0.0.1:%d:8080", port),
-			"--name", "treefrog-local-latex-compiler",
-			LocalImageName)
+		cmd := exec.CommandContext(ctx, "docker", args...)
 
 		output, err := cmd.CombinedOutput()
 		dm.logs.WriteString(string(output))
@@ -259,6 +277,32 @@ func (dm *DockerManager) startContainerWithRetry(ctx context.Context, port int)
 	return fmt.Errorf("failed to start container after %d attempts: %w", maxRetries, lastErr)
 }
 
+// runArgs builds the docker run arguments for the container. With a socket
+// configured, the socket's directory is mounted into the container and the
+// server runs as the current user, so the socket it creates is ours to
+// connect to; no port is published.
+func (dm *DockerManager) runArgs(port int) ([]string, error) {
+	args := []string{"run", "-d", "--rm", "--name", "treefrog-local-latex-compiler"}
+
+	if dm.config.UsesSocket() {
+		dir := filepath.Dir(dm.config.Socket)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create socket directory: %w", err)
+		}
+		args = append(args,
+			"-v", dir+":"+containerSocketDir,
+			"-e", "SERVER_SOCKET="+path.Join(containerSocketDir, filepath.Base(dm.config.Socket)),
+			// The image's build directory belongs to its own user
+			"-e", "COMPILER_WORKDIR=/tmp/treefrog-host-builds",
+			"--user", fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		)
+	} else {
+		args = append(args, "-p", fmt.Sprintf("127.0.0.1:%d:8080", port))
+	}
+
+	return append(args, LocalImageName), nil
+}
+
 // Stop stops the Docker container
 func (dm *DockerManager) Stop(ctx context.Context) error {
 	dm.mu.Lock()
@@ -313,11 +357,11 @@ func (dm *DockerManager) stopContainer(ctx context.Context) error {
 }
 
 func (dm *DockerManager) healthCheck(ctx context.Context, port int) error {
-	url := fmt.Sprintf("http://127.0.0.1:%d/health", port)
-	client := &http.Client{Timeout: 1 * time.Second}
+	httpClient, baseURL := client.NewHTTPClient(dm.config.LocalURL(), 1*time.Second)
+	url := baseURL + "/health"
 
 	for i := 0; i < 30; i++ {
-		resp, err := client.Get(url)
+		resp, err := httpClient.Get(url)
 		if err == nil && resp.StatusCode == 200 {
 			if resp != nil {
 				resp.Body.Close()
@@ -335,8 +379,8 @@ func (dm *DockerManager) healthCheck(ctx context.Context, port int) error {
 
 // healthCheckWithRetry performs health check with exponential backoff retry
 func (dm *DockerManager) healthCheckWithRetry(ctx context.Context, port int) error {
-	url := fmt.Sprintf("http://127.0.0.1:%d/health", port)
-	client := &http.Client{Timeout: 1 * time.Second}
+	httpClient, baseURL := client.NewHTTPClient(dm.config.LocalURL(), 1*time.Second)
+	url := baseURL + "/health"
 
 	maxRetries := HealthCheckMaxRetries
 	delay := HealthCheckDelay
@@ -347,7 +391,7 @@ func (dm *DockerManager) healthCheckWithRetry(ctx context.Context, port int) err
 	}).Debug("Starting health check with retry")
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		resp, err := client.Get(url)
+		resp, err := httpClient.Get(url)
 		if err == nil && resp.StatusCode == 200 {
 			if resp != nil {
 				resp.Body.Close()
@@ -412,6 +456,9 @@ func (dm *DockerManager) GetStatus() RendererStatus {
 	} else if dm.isRunning {
 		state = "running"
 		message = fmt.Sprintf("Running on port %d", dm.config.Port)
+		if dm.config.UsesSocket() {
+			message = "Running on " + dm.config.Socket
+		}
 	}
 
 	return RendererStatus{
@@ -4,7 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"regexp"
+	"runtime"
 	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/client"
 )
 
 const (
@@ -12,6 +16,8 @@ const (
 	GHCRImageRef   = "ghcr.io/alpha-og/treefrog/local-latex-compiler:latest"
 )
 
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
 const (
 	DefaultMaxRetries     = 3
 	DefaultRetryDelay     = 1 * time.Second
@@ -42,9 +48,17 @@ type RendererConfig struct {
 	Port      int          `json:"port"`
 	AutoStart bool         `json:"autoStart"`
 
+	// Socket, if set, is a host path where the local renderer listens on a
+	// Unix socket instead of a TCP port. Ignored on Windows.
+	Socket string `json:"socket,omitempty"`
+
 	ImageSource ImageSource `json:"imageSource"`
 	ImageRef    string      `json:"imageRef"`
 
+	// ImageDigest, if set, pins the renderer image to a sha256 digest. The
+	// image is pulled by digest and checked against it before every start.
+	ImageDigest string `json:"imageDigest,omitempty"`
+
 	RemoteCompilerURL string `json:"remoteCompilerUrl"`
 
 	CustomRegistry string `json:"customRegistry,omitempty"`
@@ -70,6 +84,27 @@ func DefaultRendererConfig() *RendererConfig {
 	}
 }
 
+// UsesSocket reports whether the local renderer listens on a Unix socket
+func (c *RendererConfig) UsesSocket() bool {
+	return c.Socket != "" && runtime.GOOS != "windows"
+}
+
+// LocalURL returns the base URL of the local renderer
+func (c *RendererConfig) LocalURL() string {
+	if c.UsesSocket() {
+		return client.UnixScheme + c.Socket
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d", c.Port)
+}
+
+// ValidateImageDigest checks that digest looks like sha256:<64 hex digits>
+func ValidateImageDigest(digest string) error {
+	if !imageDigestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid image digest %q: want sha256:<64 hex digits>", digest)
+	}
+	return nil
+}
+
 func ValidatePort(port int) error {
 	if port < 1024 || port > 65535 {
 		return errors.New("port must be between 1024 and 65535")
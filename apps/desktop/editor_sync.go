@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
+)
+
+// DefaultEditorSyncPort is the localhost port of the editor sync API
+const DefaultEditorSyncPort = 45817
+
+// editorSyncPollInterval is how often the project is scanned for saved files
+const editorSyncPollInterval = time.Second
+
+// EditorSyncStatus describes the external editor sync mode
+type EditorSyncStatus struct {
+	Running  bool        `json:"running"`
+	Port     int         `json:"port,omitempty"`
+	Project  string      `json:"project,omitempty"`
+	MainFile string      `json:"mainFile,omitempty"`
+	Engine   string      `json:"engine,omitempty"`
+	Build    BuildStatus `json:"build"`
+}
+
+// editorSyncRequest is the body of POST /v1/synctex
+type editorSyncRequest struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+// EditorSync rebuilds the project whenever a source file is saved by an
+// external editor and serves a small localhost API that editor plugins use
+// to trigger builds and SyncTeX jumps. See EDITOR_SYNC.md.
+type EditorSync struct {
+	app      *App
+	mu       sync.Mutex
+	server   *http.Server
+	stop     chan struct{}
+	port     int
+	mainFile string
+	engine   string
+}
+
+// Start begins watching the current project and serving the API
+func (e *EditorSync) Start(mainFile, engine string, port int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.server != nil {
+		return fmt.Errorf("editor sync already running")
+	}
+	if e.app.getRoot() == "" {
+		return fmt.Errorf("project root not set")
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", e.handleStatus)
+	mux.HandleFunc("/v1/build", e.handleBuild)
+	mux.HandleFunc("/v1/synctex", e.handleSyncTeX)
+	mux.HandleFunc("/v1/project/recommended-engine", e.handleRecommendedEngine)
+
+	e.server = tfhttp.NewServer(tfhttp.ServerConfig{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}, rejectBrowserRequests(mux))
+	e.stop = make(chan struct{})
+	e.port = port
+	e.mainFile = mainFile
+	e.engine = engine
+
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			Logger.WithError(err).Error("Editor sync server stopped")
+		}
+	}(e.server)
+	go e.watch(e.app.getRoot(), e.stop)
+
+	Logger.WithField("port", port).WithField("main_file", mainFile).Info("Editor sync started")
+	return nil
+}
+
+// Stop shuts down the watcher and API server
+func (e *EditorSync) Stop() error {
+	e.mu.Lock()
+	srv := e.server
+	if srv == nil {
+		e.mu.Unlock()
+		return nil
+	}
+	close(e.stop)
+	e.server = nil
+	e.mu.Unlock()
+
+	// Shut down outside the lock; in-flight handlers may need it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+
+	Logger.Info("Editor sync stopped")
+	return err
+}
+
+// Status returns the current editor sync state
+func (e *EditorSync) Status() EditorSyncStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := EditorSyncStatus{
+		Running: e.server != nil,
+		Build:   e.app.GetBuildStatus(),
+	}
+	if status.Running {
+		status.Port = e.port
+		status.Project = e.app.getRoot()
+		status.MainFile = e.mainFile
+		status.Engine = e.engine
+	}
+	return status
+}
+
+func (e *EditorSync) build() error {
+	e.mu.Lock()
+	mainFile, engine := e.mainFile, e.engine
+	e.mu.Unlock()
+	return e.app.TriggerBuild(mainFile, engine, false)
+}
+
+// watch polls the project for modified sources and rebuilds after each save.
+// Saves during a running build are coalesced into one follow-up build.
+func (e *EditorSync) watch(root string, stop <-chan struct{}) {
+	ticker := time.NewTicker(editorSyncPollInterval)
+	defer ticker.Stop()
+
+	snapshot := snapshotSources(root)
+	dirty := false
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if e.app.getRoot() != root {
+			Logger.Info("Project changed, stopping editor sync")
+			go e.Stop()
+			return
+		}
+
+		current := snapshotSources(root)
+		if !sameSnapshot(snapshot, current) {
+			snapshot = current
+			dirty = true
+			continue // wait one interval so multi-file saves settle
+		}
+
+		if dirty && e.app.GetBuildStatus().State != "running" {
+			dirty = false
+			if err := e.build(); err != nil {
+				Logger.WithError(err).Warn("Editor sync rebuild failed to start")
+			}
+		}
+	}
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshotSources records the modification stamp of every file that would
+// be uploaded by zipProject
+func snapshotSources(root string) map[string]fileStamp {
+	snap := make(map[string]fileStamp)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." {
+			return nil
+		}
+		if strings.HasPrefix(rel, ".") || strings.HasPrefix(rel, "_") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || isBuildArtifact(rel) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			snap[rel] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return snap
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
+
+// rejectBrowserRequests refuses requests carrying an Origin header so web
+// pages cannot drive the localhost API; editor plugins never send one
+func rejectBrowserRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "Browser requests are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeEditorJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleStatus serves GET /v1/status
+func (e *EditorSync) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeEditorJSON(w, http.StatusOK, e.Status())
+}
+
+// handleBuild serves POST /v1/build
+func (e *EditorSync) handleBuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := e.build(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeEditorJSON(w, http.StatusAccepted, e.app.GetBuildStatus())
+}
+
+// handleRecommendedEngine serves GET /v1/project/recommended-engine, the
+// engine suggested for ?main_file= (default: the sync main file)
+func (e *EditorSync) handleRecommendedEngine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mainFile := r.URL.Query().Get("main_file")
+	if mainFile == "" {
+		mainFile = e.Status().MainFile
+	}
+	writeEditorJSON(w, http.StatusOK, recommendEngine(e.app.getRoot(), mainFile))
+}
+
+// handleSyncTeX serves POST /v1/synctex, showing a source location in the preview
+func (e *EditorSync) handleSyncTeX(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req editorSyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.File == "" || req.Line < 1 {
+		http.Error(w, "file and line are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := e.app.deepLinkSyncTeX(req.File, req.Line, req.Col); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartEditorSync enables external editor sync mode for the current project
+func (a *App) StartEditorSync(mainFile, engine string, port int) (EditorSyncStatus, error) {
+	if mainFile == "" {
+		mainFile = buildopts.DefaultMainFile
+	}
+	if engine == "" {
+		engine = recommendEngine(a.getRoot(), mainFile).Engine
+	}
+	if port <= 0 {
+		port = DefaultEditorSyncPort
+	}
+	if err := a.editorSync.Start(mainFile, engine, port); err != nil {
+		return EditorSyncStatus{}, err
+	}
+	return a.editorSync.Status(), nil
+}
+
+// StopEditorSync disables external editor sync mode
+func (a *App) StopEditorSync() error {
+	return a.editorSync.Stop()
+}
+
+// GetEditorSyncStatus returns the external editor sync state
+func (a *App) GetEditorSyncStatus() EditorSyncStatus {
+	return a.editorSync.Status()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/alpha-og/treefrog/packages/go/security"
+)
+
+// GenerateSourceKey creates and saves a new key encrypting source uploads,
+// and returns it for configuring the builder (SOURCE_ENCRYPTION_KEY)
+func (a *App) GenerateSourceKey() (string, error) {
+	key, err := security.GenerateSourceKey()
+	if err != nil {
+		return "", err
+	}
+	if err := a.SetSourceKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// SetSourceKey sets the base64 key encrypting source uploads, or turns
+// encryption off if key is empty. Only builders configured with the same
+// key accept the uploads.
+func (a *App) SetSourceKey(key string) error {
+	if key != "" {
+		if _, err := security.ParseSourceKey(key); err != nil {
+			return err
+		}
+	}
+
+	a.configMu.Lock()
+	a.config.SourceKey = key
+	a.configMu.Unlock()
+
+	// The next request gets a client with the new key
+	a.clientMu.Lock()
+	a.apiClient = nil
+	a.clientMu.Unlock()
+
+	Logger.WithField("enabled", key != "").Info("Source encryption updated")
+	return a.saveConfig()
+}
+
+// sourceKey returns the key encrypting source uploads, or nil if
+// encryption is off
+func (a *App) sourceKey() ([]byte, error) {
+	a.configMu.Lock()
+	encoded := a.config.SourceKey
+	a.configMu.Unlock()
+	if encoded == "" {
+		return nil, nil
+	}
+	return security.ParseSourceKey(encoded)
+}
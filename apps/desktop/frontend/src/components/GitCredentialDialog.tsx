@@ -0,0 +1,96 @@
+import { useEffect, useState } from "react";
+import { Button } from "@/components/common/Button";
+import { Input } from "@/components/common/Input";
+import { Dialog, DialogDescription, DialogHeader, DialogTitle } from "@/components/common/Dialog";
+import { getWailsApp } from "@/services/api";
+import { GitCredentialPrompt } from "@/types";
+import { createLogger } from "@/utils/logger";
+
+const log = createLogger("GitCredentialDialog");
+
+const titles: Record<GitCredentialPrompt["kind"], string> = {
+  username: "Git Username",
+  password: "Git Password or Token",
+  passphrase: "SSH Key Passphrase",
+  confirm: "Confirm Remote Host",
+};
+
+/**
+ * Collects credentials when git push or pull asks for them. The desktop app
+ * emits "git-credential" for every prompt and waits for the answer.
+ */
+export default function GitCredentialDialog() {
+  const [queue, setQueue] = useState<GitCredentialPrompt[]>([]);
+  const [value, setValue] = useState("");
+  const prompt = queue[0];
+
+  useEffect(() => {
+    const runtime = (window as { runtime?: { EventsOn?: (event: string, cb: (data: unknown) => void) => void; EventsOff?: (...events: string[]) => void } }).runtime;
+    if (!runtime?.EventsOn) return;
+
+    runtime.EventsOn("git-credential", (data: unknown) => {
+      setQueue((q) => [...q, data as GitCredentialPrompt]);
+    });
+    return () => runtime.EventsOff?.("git-credential");
+  }, []);
+
+  const settle = async (answer: string | null) => {
+    if (!prompt) return;
+    setQueue((q) => q.slice(1));
+    setValue("");
+    try {
+      const app = getWailsApp();
+      if (answer === null) {
+        await app?.CancelGitCredential(prompt.id);
+      } else {
+        await app?.AnswerGitCredential(prompt.id, answer);
+      }
+    } catch (err) {
+      log.error("Failed to answer git credential prompt", { kind: prompt.kind, error: err });
+    }
+  };
+
+  if (!prompt) return null;
+
+  return (
+    <Dialog open onOpenChange={() => settle(null)}>
+      <DialogHeader>
+        <DialogTitle>{titles[prompt.kind] ?? "Git Credentials"}</DialogTitle>
+        <DialogDescription className="break-words">{prompt.prompt}</DialogDescription>
+      </DialogHeader>
+      <form
+        className="space-y-4"
+        onSubmit={(e) => {
+          e.preventDefault();
+          settle(value);
+        }}
+      >
+        {prompt.kind === "confirm" ? (
+          <p className="text-sm text-muted-foreground">
+            Only continue if you recognise this host.
+          </p>
+        ) : (
+          <Input
+            type={prompt.secret ? "password" : "text"}
+            value={value}
+            onChange={(e) => setValue(e.target.value)}
+            autoFocus
+            autoComplete="off"
+          />
+        )}
+        <div className="flex gap-2 justify-end">
+          <Button type="button" variant="ghost" onClick={() => settle(null)}>
+            Cancel
+          </Button>
+          {prompt.kind === "confirm" ? (
+            <Button type="button" onClick={() => settle("yes")}>
+              Continue
+            </Button>
+          ) : (
+            <Button type="submit">Continue</Button>
+          )}
+        </div>
+      </form>
+    </Dialog>
+  );
+}
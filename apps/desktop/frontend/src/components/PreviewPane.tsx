@@ -15,6 +15,7 @@ import {
   Check,
   MoreVertical,
   Clock,
+  AlertTriangle,
 } from "lucide-react";
 import { ZOOM_LEVELS } from "../constants";
 import { usePDFUrl } from "../hooks/usePDFUrl";
@@ -426,6 +427,12 @@ export default function PreviewPane({
                   <span className="text-xs font-medium">Running</span>
                 </div>
               )}
+              {buildStatus.state === "degraded" && (
+                <div className="flex items-center gap-1.5 px-2.5 py-1.5 rounded-lg bg-warning/15 border border-warning/30 text-warning shadow-sm whitespace-nowrap" title={buildStatus.message}>
+                  <AlertTriangle size={13} className="shrink-0" />
+                  <span className="text-xs font-medium">Builder degraded</span>
+                </div>
+              )}
               {buildStatus.state === "queued" && (
                 <div className="flex items-center gap-1.5 px-2.5 py-1.5 rounded-lg bg-warning/15 border border-warning/30 text-warning shadow-sm whitespace-nowrap">
                   <Clock size={13} className="shrink-0" />
@@ -6,6 +6,7 @@ import {
   Download,
   GitBranch,
   ChevronRight,
+  Wand2,
 } from "lucide-react";
 import { useFileStore } from "@/stores/fileStore";
 import { useSelectionStore } from "@/stores/selectionStore";
@@ -27,7 +28,7 @@ import {
   loadFilterSettings,
 } from "@/utils/treePersistence";
 import { createLogger } from "@/utils/logger";
-import { FileEntry } from "@/types";
+import { FileEntry, GitStatusDetails } from "@/types";
 import { cn } from "@/lib/utils";
 import { fsUploadFiles } from "@/services/fsService";
 
@@ -58,7 +59,11 @@ interface SidebarProps {
   onRename?: (path: string) => void;
   gitStatus: string;
   gitError: boolean;
+  gitDetails?: GitStatusDetails | null;
+  hasRepo?: boolean;
+  onInitRepo?: () => Promise<void>;
   onCommit: (msg: string) => Promise<void>;
+  onSuggestMessage?: () => Promise<string | null>;
   onPush: () => Promise<void>;
   onPull: () => Promise<void>;
 }
@@ -78,7 +83,11 @@ export default function Sidebar({
   onRename,
   gitStatus,
   gitError,
+  gitDetails,
+  hasRepo = true,
+  onInitRepo,
   onCommit,
+  onSuggestMessage,
   onPush,
   onPull,
 }: SidebarProps) {
@@ -112,6 +121,7 @@ export default function Sidebar({
     const [isFilterOpen, setIsFilterOpen] = useState(false);
    const [commitMessage, setCommitMessage] = useState("");
    const [isCommitting, setIsCommitting] = useState(false);
+  const [isInitializing, setIsInitializing] = useState(false);
    const [isGitExpanded, setIsGitExpanded] = useState(true);
 
   // Track previous project root to detect project switches
@@ -474,6 +484,19 @@ export default function Sidebar({
                 <div className="flex items-center gap-2">
                   <GitBranch size={14} className="text-muted-foreground" />
                   <h3 className="font-semibold text-sm">Source Control</h3>
+                  {!hasRepo && (
+                    <span className="text-xs text-muted-foreground">(none)</span>
+                  )}
+                  {gitDetails && (
+                    <span
+                      className="text-xs text-muted-foreground font-mono truncate"
+                      title={gitDetails.upstream ? `Tracking ${gitDetails.upstream}` : undefined}
+                    >
+                      {gitDetails.detached ? "detached" : gitDetails.branch}
+                      {gitDetails.ahead > 0 && ` ↑${gitDetails.ahead}`}
+                      {gitDetails.behind > 0 && ` ↓${gitDetails.behind}`}
+                    </span>
+                  )}
                 </div>
                  <motion.div
                    animate={{ rotate: isGitExpanded ? 90 : 0 }}
@@ -512,6 +535,34 @@ export default function Sidebar({
                         </pre>
                       </div>
 
+                      {!hasRepo && onInitRepo ? (
+                        /* Init Prompt */
+                        <div className="space-y-2">
+                          <p className="text-xs text-muted-foreground">
+                            This project has no version control. Initialize a git
+                            repository to track its history.
+                          </p>
+                          <Button
+                            variant="outline"
+                            size="sm"
+                            disabled={isInitializing}
+                            onClick={async () => {
+                              setIsInitializing(true);
+                              try {
+                                await onInitRepo();
+                              } finally {
+                                setIsInitializing(false);
+                              }
+                            }}
+                            className="w-full gap-1.5"
+                            title="Initialize a git repository with a LaTeX .gitignore"
+                          >
+                            <GitBranch size={14} />
+                            <span className="text-xs">Initialize repository</span>
+                          </Button>
+                        </div>
+                      ) : (
+                      <>
                       {/* Commit Form */}
                       <form
                         onSubmit={async (e) => {
@@ -541,6 +592,22 @@ export default function Sidebar({
                             className="flex-1 h-8 text-sm"
                             disabled={isCommitting}
                           />
+                          {onSuggestMessage && (
+                            <Button
+                              type="button"
+                              variant="outline"
+                              size="icon"
+                              disabled={isCommitting}
+                              onClick={async () => {
+                                const message = await onSuggestMessage();
+                                if (message) setCommitMessage(message);
+                              }}
+                              title="Suggest a message from the changes"
+                              className="h-8 w-8"
+                            >
+                              <Wand2 size={14} />
+                            </Button>
+                          )}
                           <Button
                             type="submit"
                             size="icon"
@@ -576,6 +643,8 @@ export default function Sidebar({
                           <span className="text-xs">Pull</span>
                         </Button>
                        </div>
+                      </>
+                      )}
                      </div>
                    </motion.div>
                  )}
@@ -1,27 +1,35 @@
 import { useState, useCallback } from "react";
 import {
   gitStatus,
+  gitInit,
+  gitSuggestCommitMessage,
   gitCommit,
   gitPush,
   gitPull,
 } from "../services/gitService";
 import { createLogger } from "../utils/logger";
+import { GitStatusDetails } from "../types";
 
 const log = createLogger("Git");
 
 export function useGit() {
   const [status, setStatus] = useState("");
   const [isError, setIsError] = useState(false);
+  const [details, setDetails] = useState<GitStatusDetails | null>(null);
+  const [hasRepo, setHasRepo] = useState(true);
   const [isInitialized, setIsInitialized] = useState(false);
 
   const refresh = useCallback(async () => {
     try {
       const data = await gitStatus();
       setStatus(data.raw || "");
+      setHasRepo(data.repo !== false);
+      setDetails(data.status ?? null);
       setIsError(false);
     } catch (err) {
       log.error("Failed to get git status", { error: err });
       setStatus("git error");
+      setDetails(null);
       setIsError(true);
     }
   }, []);
@@ -31,6 +39,29 @@ export function useGit() {
     setIsInitialized(true);
   }, [refresh]);
 
+  const init = useCallback(
+    async (msg?: string) => {
+      try {
+        await gitInit(msg);
+        await refresh();
+      } catch (err) {
+        log.error("Failed to initialize repository", { error: err });
+      }
+    },
+    [refresh]
+  );
+
+  const suggestMessage = useCallback(async (conventional: boolean = false) => {
+    try {
+      const suggestion = await gitSuggestCommitMessage(conventional);
+      // The commit box takes one line, so only the subject
+      return suggestion?.subject ?? null;
+    } catch (err) {
+      log.error("Failed to suggest commit message", { error: err });
+      return null;
+    }
+  }, []);
+
   const commit = useCallback(
     async (msg: string) => {
       if (!msg.trim()) return;
@@ -49,6 +80,16 @@ export function useGit() {
       await gitPush();
       await refresh();
     } catch (err) {
+      const message = err instanceof Error ? err.message : String(err);
+      if (message.includes("does not compile") && window.confirm(`${message}\n\nPush anyway?`)) {
+        try {
+          await gitPush(undefined, true);
+          await refresh();
+        } catch (retryErr) {
+          log.error("Failed to push", { error: retryErr });
+        }
+        return;
+      }
       log.error("Failed to push", { error: err });
     }
   }, [refresh]);
@@ -64,10 +105,14 @@ export function useGit() {
 
   return {
     status,
+    details,
     isError,
+    hasRepo,
     isInitialized,
     refresh,
     initRefresh,
+    init,
+    suggestMessage,
     commit,
     push,
     pull,
@@ -11,6 +11,7 @@ import { motion } from "motion/react";
 // Components
 import Toolbar from "@/components/Toolbar";
 import Sidebar from "@/components/Sidebar";
+import GitCredentialDialog from "@/components/GitCredentialDialog";
 import { EditorPane } from "@/components/EditorPane";
 import PreviewPane from "@/components/PreviewPane";
 import ProjectPicker from "@/components/ProjectPicker";
@@ -21,6 +22,9 @@ import FramelessWindow from "@/components/FramelessWindow";
 // Types
 import { BuildStatus, ModalState } from "@/types";
 
+// Services
+import { getRecommendedEngine } from "@/services/projectService";
+
 // Stores
 import { useAppStore } from "@/stores/appStore";
 import { useFileStore } from "@/stores/fileStore";
@@ -112,9 +116,13 @@ export default function Editor() {
   const { status: buildStatus, build, updateStatus } = useBuild();
   const {
     status: gitStatus,
+    details: gitDetails,
     isError: gitError,
+    hasRepo,
     refresh: refreshGit,
     initRefresh: initGitRefresh,
+    init: initRepo,
+    suggestMessage,
     commit,
     push,
     pull,
@@ -187,6 +195,15 @@ export default function Editor() {
 
   useWebSocket(handleBuildMessage);
 
+  // Default the engine to the one the project's preamble needs
+  useEffect(() => {
+    if (projectRoot && !projectLoading) {
+      getRecommendedEngine("")
+        .then((rec) => setEngine(rec.engine))
+        .catch(() => setEngine("pdflatex"));
+    }
+  }, [projectRoot, projectLoading]);
+
   useEffect(() => {
     if (projectRoot && !projectLoading) {
       console.log("[Editor] Loading files for project:", projectRoot);
@@ -598,7 +615,11 @@ onTogglePane={togglePane}
                          }
                          gitStatus={gitStatus}
                          gitError={gitError}
+                         gitDetails={gitDetails}
+                         hasRepo={hasRepo}
+                         onInitRepo={initRepo}
                          onCommit={commit}
+                         onSuggestMessage={suggestMessage}
                          onPush={push}
                          onPull={pull}
                        />
@@ -741,6 +762,9 @@ onTogglePane={togglePane}
         </div>
       </FramelessWindow>
 
+      {/* Git credential prompts during push and pull */}
+      <GitCredentialDialog />
+
       {/* File Operations Modal - rendered outside FramelessWindow to escape overflow-hidden */}
       {modal && (
         <Dialog open={!!modal} onOpenChange={() => closeModal()}>
@@ -1,12 +1,42 @@
-import { GET, POST, getWailsApp } from "./api";
+import { POST, getWailsApp } from "./api";
 import { isWails } from "../utils/env";
 
+// Git runs on the project's folder, which only the desktop app can reach;
+// the compiler's git routes need a path and a session token the browser has
+// neither of
+const desktopOnly = () =>
+  Promise.reject(new Error("Git is only available in the desktop app"));
+
 export const gitStatus = () => {
   if (isWails()) {
     const app = getWailsApp();
     return app?.GitStatus();
   }
-  return GET("/git/status");
+  return desktopOnly();
+};
+
+export const gitInit = (message?: string) => {
+  if (isWails()) {
+    const app = getWailsApp();
+    return app?.GitInit(message || "");
+  }
+  return desktopOnly();
+};
+
+export const gitBlame = (path: string) => {
+  if (isWails()) {
+    const app = getWailsApp();
+    return app?.GitBlame(path);
+  }
+  return desktopOnly();
+};
+
+export const gitSuggestCommitMessage = (conventional: boolean = false) => {
+  if (isWails()) {
+    const app = getWailsApp();
+    return app?.GitSuggestCommitMessage(conventional);
+  }
+  return desktopOnly();
 };
 
 export const gitCommit = (message: string, files?: string[], all: boolean = true) => {
@@ -20,10 +50,10 @@ export const gitCommit = (message: string, files?: string[], all: boolean = true
   });
 };
 
-export const gitPush = (remote?: string) => {
+export const gitPush = (remote?: string, skipCompile: boolean = false) => {
   if (isWails()) {
     const app = getWailsApp();
-    return app?.GitPush(remote || "");
+    return app?.GitPush(remote || "", skipCompile);
   }
   return POST("/git/push", {});
 };
@@ -1,4 +1,5 @@
 import { GET, POST } from "./api";
+import { EngineRecommendation } from "@/types";
 import { createLogger } from "../utils/logger";
 import * as App from "wailsjs/go/main/App";
 import { isWails } from "../utils/env";
@@ -68,3 +69,12 @@ export const openProjectDialog = async () => {
   log.error("Open dialog not available in web mode");
   return Promise.reject(new Error("Open dialog not available in web mode"));
 };
+
+export const getRecommendedEngine = async (
+  mainFile: string,
+): Promise<EngineRecommendation> => {
+  if (isWails()) {
+    return App.GetRecommendedEngine(mainFile);
+  }
+  return GET(`/project/recommended-engine?main_file=${encodeURIComponent(mainFile)}`);
+};
@@ -22,6 +22,7 @@ const fromBackendRendererConfig = (config: RendererConfig): RendererConfig => {
     mode: toRendererMode(config.mode),
     port: config.port,
     autoStart: config.autoStart,
+    socket: config.socket,
     imageSource: config.imageSource,
     imageRef: config.imageRef,
     remoteUrl: config.remoteUrl,
@@ -92,6 +93,10 @@ export const rendererService = {
     return await getApp().SetRendererPort(port);
   },
 
+  async setSocket(path: string): Promise<void> {
+    return await getApp().SetRendererSocket(path);
+  },
+
   async getBuildLog(): Promise<string> {
     return await getApp().GetBuildLog();
   },
@@ -0,0 +1,37 @@
+export type AnnotationKind = "highlight" | "note" | "ink";
+
+export interface AnnotationRect {
+  x: number;
+  y: number;
+  width: number;
+  height: number;
+}
+
+export interface AnnotationPoint {
+  x: number;
+  y: number;
+}
+
+export interface AnnotationAnchor {
+  file: string;
+  line: number;
+  page: number;
+  x: number;
+  y: number;
+}
+
+export interface Annotation {
+  id: string;
+  kind: AnnotationKind;
+  page: number;
+  pageHeight: number;
+  rects?: AnnotationRect[];
+  ink?: AnnotationPoint[][];
+  contents?: string;
+  color?: string;
+  anchor?: AnnotationAnchor;
+  buildId?: string;
+  stale?: boolean;
+  createdAt: string;
+  updatedAt: string;
+}
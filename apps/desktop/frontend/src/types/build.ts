@@ -18,4 +18,22 @@ export interface CompilationMetrics {
   lastAttempt: string;
   lastSuccess: string;
   lastFailure: string;
-}
\ No newline at end of file
+}
+export interface MetricsBreakdown {
+  attempts: number;
+  successes: number;
+  failures: number;
+  averageDuration: number;
+  successRate: number;
+}
+
+export interface DailyMetrics extends MetricsBreakdown {
+  date: string;
+}
+
+export interface MetricsHistory {
+  days: number;
+  daily: DailyMetrics[];
+  byEngine: Record<string, MetricsBreakdown>;
+  byProject: Record<string, MetricsBreakdown>;
+}
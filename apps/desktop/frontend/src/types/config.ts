@@ -5,4 +5,19 @@ export interface Config {
   compilerUrl: string;
   compilerToken: string;
   renderer?: RendererConfig;
-}
\ No newline at end of file
+  network?: NetworkConfig;
+  sourceKey?: string;
+}
+export type ProxyMode = "system" | "none" | "manual";
+
+export interface NetworkConfig {
+  proxyMode: ProxyMode;
+  proxyUrl?: string;
+  noProxy?: string;
+  caCertFiles?: string[];
+  preferIpv6?: boolean;
+  dnsServer?: string;
+  connectTimeout?: number;
+  uploadLimit?: number;
+  downloadLimit?: number;
+}
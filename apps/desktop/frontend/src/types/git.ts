@@ -1,3 +1,66 @@
+export interface GitFileChange {
+  path: string;
+  from?: string;
+  change: "added" | "modified" | "deleted" | "renamed" | "copied" | "type-changed";
+}
+
+export interface GitStatusDetails {
+  branch: string;
+  upstream?: string;
+  ahead: number;
+  behind: number;
+  detached: boolean;
+  no_commits: boolean;
+  staged: GitFileChange[];
+  unstaged: GitFileChange[];
+  untracked: string[];
+  conflicted: string[];
+}
+
 export interface GitStatus {
   raw: string;
-}
\ No newline at end of file
+  repo?: boolean;
+  status?: GitStatusDetails;
+}
+
+export interface GitInitResult {
+  root: string;
+  commit: string;
+  gitignore_created: boolean;
+}
+
+export interface GitCredentialPrompt {
+  id: string;
+  prompt: string;
+  kind: "username" | "password" | "passphrase" | "confirm";
+  secret: boolean;
+}
+
+export interface GitBlameRange {
+  start: number;
+  end: number;
+  commit: string;
+  author: string;
+  email: string;
+  date: string;
+  summary: string;
+  uncommitted: boolean;
+}
+
+export interface GitFileSummary {
+  path: string;
+  change: "added" | "modified" | "deleted";
+  sections?: string[];
+  words_added: number;
+  words_removed: number;
+}
+
+export interface GitCommitSuggestion {
+  message: string;
+  subject: string;
+  type?: string;
+  scope?: string;
+  files: GitFileSummary[];
+  words_added: number;
+  words_removed: number;
+}
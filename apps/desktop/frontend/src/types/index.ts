@@ -1,10 +1,13 @@
+export * from "./annotation";
 export * from "./auth";
 export * from "./build";
 export * from "./config";
 export * from "./file";
 export * from "./git";
+export * from "./presentation";
 export * from "./project";
 export * from "./renderer";
+export * from "./session";
 export * from "./synctex";
 export * from "./wails";
 
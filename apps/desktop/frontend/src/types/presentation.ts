@@ -0,0 +1,72 @@
+export type BeamerNotes = "hidden" | "pages" | "only" | "right" | "left" | "top" | "bottom";
+
+export interface Slide {
+  frame: number;
+  title?: string;
+  file: string;
+  line: number;
+  page?: number;
+  notesPage?: number;
+  notes: string[];
+  targetSeconds?: number;
+}
+
+export interface Presentation {
+  mainFile: string;
+  notes: BeamerNotes;
+  buildId?: string;
+  slides: Slide[];
+}
+
+export interface SlideTime {
+  frame: number;
+  seconds: number;
+}
+
+export interface Rehearsal {
+  startedAt: string;
+  endedAt: string;
+  totalSeconds: number;
+  slides: SlideTime[];
+}
+
+export interface SlideTimings {
+  mainFile: string;
+  targets: Record<number, number>;
+  rehearsals: Rehearsal[];
+}
+
+export interface PresentationLayout {
+  mode: "span" | "fullscreen";
+  notesWidth: number;
+  slidesWidth: number;
+  height: number;
+}
+
+export interface PresentationState {
+  active: boolean;
+  mainFile?: string;
+  page?: number;
+  pageCount?: number;
+  slide?: Slide;
+  rehearsing?: boolean;
+  elapsed?: number;
+  slideElapsed?: number;
+  layout: PresentationLayout;
+  presentation?: Presentation;
+  lastRehearsal?: Rehearsal;
+}
+
+export interface ScreenSize {
+  width: number;
+  height: number;
+}
+
+export interface Display {
+  isCurrent: boolean;
+  isPrimary: boolean;
+  width: number;
+  height: number;
+  size: ScreenSize;
+  physicalSize: ScreenSize;
+}
@@ -2,4 +2,10 @@ export interface ProjectInfo {
   name: string;
   root: string;
   compilerUrl: string;
+  hasVcs?: boolean;
+}
+
+export interface EngineRecommendation {
+  engine: string;
+  reason: string;
 }
\ No newline at end of file
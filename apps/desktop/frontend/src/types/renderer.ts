@@ -13,8 +13,10 @@ export interface RendererConfig {
   mode: string;
   port: number;
   autoStart: boolean;
+  socket?: string;
   imageSource: string;
   imageRef: string;
+  imageDigest?: string;
   remoteUrl: string;
   remoteToken: string;
   customRegistry?: string;
@@ -33,4 +35,25 @@ export interface RemoteCompilerHealth {
   lastError: string;
   responseTime: number;
   upSince: string;
-}
\ No newline at end of file
+}
+export interface HealthSample {
+  time: string;
+  healthy: boolean;
+  responseTime: number;
+  error?: string;
+}
+
+export interface RemoteCompilerHealthHistory {
+  url: string;
+  interval: number;
+  samples: HealthSample[];
+  uptime: number;
+  averageResponseTime: number;
+}
+
+export interface CompilerHealthChanged {
+  url: string;
+  healthy: boolean;
+  error?: string;
+  responseTime: number;
+}
@@ -0,0 +1,25 @@
+export interface EditorCursor {
+  line: number;
+  column: number;
+}
+
+export interface OpenFile {
+  path: string;
+  cursor: EditorCursor;
+  scrollTop: number;
+  scrollLeft: number;
+}
+
+export interface PDFView {
+  page: number;
+  zoom?: number;
+  scrollTop: number;
+}
+
+export interface Session {
+  root: string;
+  openFiles: OpenFile[];
+  activeFile?: string;
+  pdf: PDFView;
+  savedAt?: string;
+}
@@ -1,21 +1,38 @@
+import { Annotation } from "./annotation";
 import { AuthState, AuthUser } from "./auth";
-import { BuildStatus, CompilationMetrics } from "./build";
-import { Config } from "./config";
+import { BuildStatus, CompilationMetrics, MetricsHistory } from "./build";
+import { Config, NetworkConfig } from "./config";
 import { FileContent, FileEntry } from "./file";
-import { GitStatus } from "./git";
-import { ProjectInfo } from "./project";
-import { RemoteCompilerHealth, RendererConfig, RendererStatus } from "./renderer";
+import { GitBlameRange, GitCommitSuggestion, GitInitResult, GitStatus } from "./git";
+import {
+  Display,
+  Presentation,
+  PresentationState,
+  Rehearsal,
+  SlideTimings,
+} from "./presentation";
+import { EngineRecommendation, ProjectInfo } from "./project";
+import {
+  RemoteCompilerHealth,
+  RemoteCompilerHealthHistory,
+  RendererConfig,
+  RendererStatus,
+} from "./renderer";
+import { Session } from "./session";
 import { SyncTeXResult } from "./synctex";
 
 export interface WailsApp {
   CheckDockerDiskSpace(): Promise<number>;
   CleanupDockerSystem(): Promise<void>;
   CreateFile(path: string, type: string): Promise<void>;
+  DeleteAnnotation(mainFile: string, id: string): Promise<void>;
   DeleteFile(path: string, recursive: boolean): Promise<void>;
   DetectBestMode(): Promise<string>;
   DuplicateFile(from: string, to: string): Promise<void>;
+  ExportAnnotatedPDF(mainFile: string): Promise<string>;
   ExportPDF(): Promise<string>;
   ExportSource(): Promise<string>;
+  GenerateSourceKey(): Promise<string>;
   GetAuthSignInURL(): Promise<string>;
   GetAuthSignUpURL(): Promise<string>;
   GetAuthState(): Promise<AuthState>;
@@ -24,41 +41,70 @@ export interface WailsApp {
   GetBuildStatus(): Promise<BuildStatus>;
   GetCompilationMetrics(): Promise<CompilationMetrics>;
   GetConfig(): Promise<Config>;
+  GetDisplays(): Promise<Display[]>;
+  GetMetricsHistory(days: number): Promise<MetricsHistory>;
+  GetNetworkConfig(): Promise<NetworkConfig>;
   GetPDFContent(): Promise<string>;
   GetPDFPath(): Promise<string>;
+  GetPresentation(mainFile: string): Promise<Presentation>;
+  GetPresentationState(): Promise<PresentationState>;
   GetProject(): Promise<ProjectInfo>;
+  GetRecommendedEngine(mainFile: string): Promise<EngineRecommendation>;
   GetRemoteCompilerHealth(): Promise<RemoteCompilerHealth>;
+  GetRemoteCompilerHealthHistory(): Promise<RemoteCompilerHealthHistory>;
   GetRendererConfig(): Promise<RendererConfig>;
   GetRendererLogs(): Promise<string>;
   GetRendererStatus(): Promise<RendererStatus>;
   GetSessionToken(): Promise<string>;
+  GetSlideTimings(mainFile: string): Promise<SlideTimings>;
   GitCommit(message: string, files: string[], all: boolean): Promise<void>;
+  GitInit(message: string): Promise<GitInitResult>;
+  GitBlame(path: string): Promise<GitBlameRange[]>;
+  GitSuggestCommitMessage(conventional: boolean): Promise<GitCommitSuggestion>;
+  AnswerGitCredential(id: string, value: string): Promise<void>;
+  CancelGitCredential(id: string): Promise<void>;
   GitPull(remote: string): Promise<void>;
-  GitPush(remote: string): Promise<void>;
+  GitPush(remote: string, skipCompile: boolean): Promise<void>;
+  SetCompileBeforePush(enabled: boolean): Promise<void>;
   GitStatus(): Promise<GitStatus>;
   HandleAuthCallback(url: string): Promise<void>;
   HandleAuthCallbackWithUser(userId: string, email: string, firstName: string, lastName: string): Promise<void>;
   IsAuthenticated(): Promise<boolean>;
   IsRemoteCompilerHealthy(): Promise<boolean>;
+  ListAnnotations(mainFile: string): Promise<Annotation[]>;
   ListFiles(path: string): Promise<FileEntry[]>;
   MoveFile(from: string, toDir: string): Promise<void>;
   OpenAuthURL(): Promise<void>;
   OpenProjectDialog(): Promise<ProjectInfo>;
+  PresentationGoToFrame(frame: number): Promise<PresentationState>;
+  PresentationGoToPage(page: number): Promise<PresentationState>;
+  PresentationNext(): Promise<PresentationState>;
+  PresentationPrev(): Promise<PresentationState>;
   ReadFile(path: string): Promise<FileContent>;
   RenameFile(from: string, to: string): Promise<void>;
   ResetCompilationMetrics(): Promise<void>;
   RestartRenderer(): Promise<void>;
+  RestoreSession(): Promise<Session>;
+  SaveAnnotation(mainFile: string, annotation: Annotation): Promise<Annotation>;
+  SaveSession(session: Session): Promise<void>;
   SetAuthUser(id: string, email: string, firstName: string): Promise<void>;
   SetCompilerConfig(url: string, token: string): Promise<void>;
   SetImageSource(source: string, ref: string): Promise<void>;
+  SetImageDigest(digest: string): Promise<void>;
+  SetNetworkConfig(config: NetworkConfig): Promise<void>;
   SetProject(root: string): Promise<ProjectInfo>;
+  SetSlideTargets(mainFile: string, targets: Record<number, number>): Promise<void>;
+  SetSourceKey(key: string): Promise<void>;
   SetRendererAutoStart(enabled: boolean): Promise<void>;
   SetRendererMode(mode: string): Promise<void>;
   SetRendererPort(port: number): Promise<void>;
+  SetRendererSocket(path: string): Promise<void>;
   SetRendererRemoteToken(token: string): Promise<void>;
   SetRendererRemoteURL(url: string): Promise<void>;
   SignOut(): Promise<void>;
+  StartPresentation(mainFile: string, pageCount: number, rehearse: boolean): Promise<PresentationState>;
   StartRenderer(): Promise<void>;
+  StopPresentation(): Promise<Rehearsal>;
   StopRenderer(): Promise<void>;
   SyncTeXEdit(page: number, x: number, y: number): Promise<SyncTeXResult>;
   SyncTeXView(file: string, line: number, col: number): Promise<SyncTeXResult>;
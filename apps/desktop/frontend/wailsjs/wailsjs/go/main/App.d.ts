@@ -1,9 +1,17 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 import {main} from '../models';
+import {buildopts} from '../models';
+import {vcs} from '../models';
+
+export function AnswerGitCredential(arg1:string,arg2:string):Promise<void>;
+
+export function CancelGitCredential(arg1:string):Promise<void>;
 
 export function CheckDockerDiskSpace():Promise<number>;
 
+export function CheckShortcutConflicts(arg1:{[key: string]: string}):Promise<Array<main.ShortcutConflict>>;
+
 export function CleanupDockerSystem():Promise<void>;
 
 export function CreateFile(arg1:string,arg2:string):Promise<void>;
@@ -34,14 +42,22 @@ export function GetCompilationMetrics():Promise<main.CompilationMetrics>;
 
 export function GetConfig():Promise<main.Config>;
 
+export function GetEditorSyncStatus():Promise<main.EditorSyncStatus>;
+
+export function GetMetricsHistory(arg1:number):Promise<main.MetricsHistory>;
+
 export function GetPDFContent():Promise<string>;
 
 export function GetPDFPath():Promise<string>;
 
 export function GetProject():Promise<main.ProjectInfo>;
 
+export function GetRecommendedEngine(arg1:string):Promise<buildopts.EngineRecommendation>;
+
 export function GetRemoteCompilerHealth():Promise<main.RemoteCompilerHealth>;
 
+export function GetRemoteCompilerHealthHistory():Promise<main.RemoteCompilerHealthHistory>;
+
 export function GetRendererConfig():Promise<main.RendererConfig>;
 
 export function GetRendererLogs():Promise<string>;
@@ -50,11 +66,21 @@ export function GetRendererStatus():Promise<main.RendererStatus>;
 
 export function GetSessionToken():Promise<string>;
 
+export function GetShortcuts():Promise<Array<main.Shortcut>>;
+
+export function GetTrayState():Promise<string>;
+
+export function GitBlame(arg1:string):Promise<Array<vcs.BlameRange>>;
+
 export function GitCommit(arg1:string,arg2:Array<string>,arg3:boolean):Promise<void>;
 
+export function GitInit(arg1:string):Promise<vcs.InitResult>;
+
 export function GitPull(arg1:string):Promise<void>;
 
-export function GitPush(arg1:string):Promise<void>;
+export function GitPush(arg1:string,arg2:boolean):Promise<void>;
+
+export function GitSuggestCommitMessage(arg1:boolean):Promise<vcs.CommitSuggestion>;
 
 export function GitStatus():Promise<main.GitStatus>;
 
@@ -70,18 +96,30 @@ export function MoveFile(arg1:string,arg2:string):Promise<void>;
 
 export function OpenAuthURL():Promise<void>;
 
+export function OpenPDFExternal():Promise<void>;
+
 export function OpenProjectDialog():Promise<main.ProjectInfo>;
 
+export function OpenProjectFolder():Promise<void>;
+
 export function ReadFile(arg1:string):Promise<main.FileContent>;
 
 export function RenameFile(arg1:string,arg2:string):Promise<void>;
 
 export function ResetCompilationMetrics():Promise<void>;
 
+export function ResetShortcuts():Promise<void>;
+
 export function RestartRenderer():Promise<void>;
 
+export function SetCompileBeforePush(arg1:boolean):Promise<void>;
+
+export function SetImageDigest(arg1:string):Promise<void>;
+
 export function SetImageSource(arg1:string,arg2:string):Promise<void>;
 
+export function SetNotificationsEnabled(arg1:boolean):Promise<void>;
+
 export function SetProject(arg1:string):Promise<main.ProjectInfo>;
 
 export function SetRemoteCompilerURL(arg1:string):Promise<void>;
@@ -92,10 +130,18 @@ export function SetRendererMode(arg1:string):Promise<void>;
 
 export function SetRendererPort(arg1:number):Promise<void>;
 
+export function SetRendererSocket(arg1:string):Promise<void>;
+
+export function SetShortcuts(arg1:{[key: string]: string}):Promise<void>;
+
 export function SignOut():Promise<void>;
 
+export function StartEditorSync(arg1:string,arg2:string,arg3:number):Promise<main.EditorSyncStatus>;
+
 export function StartRenderer():Promise<void>;
 
+export function StopEditorSync():Promise<void>;
+
 export function StopRenderer():Promise<void>;
 
 export function SyncTeXEdit(arg1:number,arg2:number,arg3:number):Promise<main.SyncTeXResult>;
@@ -2,10 +2,22 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AnswerGitCredential(arg1, arg2) {
+  return window['go']['main']['App']['AnswerGitCredential'](arg1, arg2);
+}
+
+export function CancelGitCredential(arg1) {
+  return window['go']['main']['App']['CancelGitCredential'](arg1);
+}
+
 export function CheckDockerDiskSpace() {
   return window['go']['main']['App']['CheckDockerDiskSpace']();
 }
 
+export function CheckShortcutConflicts(arg1,  string>) {
+  return window['go']['main']['App']['CheckShortcutConflicts'](arg1,  string>);
+}
+
 export function CleanupDockerSystem() {
   return window['go']['main']['App']['CleanupDockerSystem']();
 }
@@ -66,6 +78,14 @@ export function GetConfig() {
   return window['go']['main']['App']['GetConfig']();
 }
 
+export function GetEditorSyncStatus() {
+  return window['go']['main']['App']['GetEditorSyncStatus']();
+}
+
+export function GetMetricsHistory(arg1) {
+  return window['go']['main']['App']['GetMetricsHistory'](arg1);
+}
+
 export function GetPDFContent() {
   return window['go']['main']['App']['GetPDFContent']();
 }
@@ -78,10 +98,18 @@ export function GetProject() {
   return window['go']['main']['App']['GetProject']();
 }
 
+export function GetRecommendedEngine(arg1) {
+  return window['go']['main']['App']['GetRecommendedEngine'](arg1);
+}
+
 export function GetRemoteCompilerHealth() {
   return window['go']['main']['App']['GetRemoteCompilerHealth']();
 }
 
+export function GetRemoteCompilerHealthHistory() {
+  return window['go']['main']['App']['GetRemoteCompilerHealthHistory']();
+}
+
 export function GetRendererConfig() {
   return window['go']['main']['App']['GetRendererConfig']();
 }
@@ -98,16 +126,36 @@ export function GetSessionToken() {
   return window['go']['main']['App']['GetSessionToken']();
 }
 
+export function GetShortcuts() {
+  return window['go']['main']['App']['GetShortcuts']();
+}
+
+export function GetTrayState() {
+  return window['go']['main']['App']['GetTrayState']();
+}
+
+export function GitBlame(arg1) {
+  return window['go']['main']['App']['GitBlame'](arg1);
+}
+
 export function GitCommit(arg1, arg2, arg3) {
   return window['go']['main']['App']['GitCommit'](arg1, arg2, arg3);
 }
 
+export function GitInit(arg1) {
+  return window['go']['main']['App']['GitInit'](arg1);
+}
+
 export function GitPull(arg1) {
   return window['go']['main']['App']['GitPull'](arg1);
 }
 
-export function GitPush(arg1) {
-  return window['go']['main']['App']['GitPush'](arg1);
+export function GitPush(arg1, arg2) {
+  return window['go']['main']['App']['GitPush'](arg1, arg2);
+}
+
+export function GitSuggestCommitMessage(arg1) {
+  return window['go']['main']['App']['GitSuggestCommitMessage'](arg1);
 }
 
 export function GitStatus() {
@@ -138,10 +186,18 @@ export function OpenAuthURL() {
   return window['go']['main']['App']['OpenAuthURL']();
 }
 
+export function OpenPDFExternal() {
+  return window['go']['main']['App']['OpenPDFExternal']();
+}
+
 export function OpenProjectDialog() {
   return window['go']['main']['App']['OpenProjectDialog']();
 }
 
+export function OpenProjectFolder() {
+  return window['go']['main']['App']['OpenProjectFolder']();
+}
+
 export function ReadFile(arg1) {
   return window['go']['main']['App']['ReadFile'](arg1);
 }
@@ -154,14 +210,30 @@ export function ResetCompilationMetrics() {
   return window['go']['main']['App']['ResetCompilationMetrics']();
 }
 
+export function ResetShortcuts() {
+  return window['go']['main']['App']['ResetShortcuts']();
+}
+
 export function RestartRenderer() {
   return window['go']['main']['App']['RestartRenderer']();
 }
 
+export function SetCompileBeforePush(arg1) {
+  return window['go']['main']['App']['SetCompileBeforePush'](arg1);
+}
+
+export function SetImageDigest(arg1) {
+  return window['go']['main']['App']['SetImageDigest'](arg1);
+}
+
 export function SetImageSource(arg1, arg2) {
   return window['go']['main']['App']['SetImageSource'](arg1, arg2);
 }
 
+export function SetNotificationsEnabled(arg1) {
+  return window['go']['main']['App']['SetNotificationsEnabled'](arg1);
+}
+
 export function SetProject(arg1) {
   return window['go']['main']['App']['SetProject'](arg1);
 }
@@ -182,14 +254,30 @@ export function SetRendererPort(arg1) {
   return window['go']['main']['App']['SetRendererPort'](arg1);
 }
 
+export function SetRendererSocket(arg1) {
+  return window['go']['main']['App']['SetRendererSocket'](arg1);
+}
+
+export function SetShortcuts(arg1,  string>) {
+  return window['go']['main']['App']['SetShortcuts'](arg1,  string>);
+}
+
 export function SignOut() {
   return window['go']['main']['App']['SignOut']();
 }
 
+export function StartEditorSync(arg1, arg2, arg3) {
+  return window['go']['main']['App']['StartEditorSync'](arg1, arg2, arg3);
+}
+
 export function StartRenderer() {
   return window['go']['main']['App']['StartRenderer']();
 }
 
+export function StopEditorSync() {
+  return window['go']['main']['App']['StopEditorSync']();
+}
+
 export function StopRenderer() {
   return window['go']['main']['App']['StopRenderer']();
 }
@@ -1,3 +1,22 @@
+export namespace buildopts {
+	
+	export class EngineRecommendation {
+	    engine: string;
+	    reason: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new EngineRecommendation(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.engine = source["engine"];
+	        this.reason = source["reason"];
+	    }
+	}
+
+}
+
 export namespace main {
 	
 	export class AuthUser {
@@ -109,8 +128,10 @@ export namespace main {
 	    mode: string;
 	    port: number;
 	    autoStart: boolean;
+	    socket?: string;
 	    imageSource: string;
 	    imageRef: string;
+	    imageDigest?: string;
 	    remoteCompilerUrl: string;
 	    customRegistry?: string;
 	    customTarPath?: string;
@@ -128,8 +149,10 @@ export namespace main {
 	        this.mode = source["mode"];
 	        this.port = source["port"];
 	        this.autoStart = source["autoStart"];
+	        this.socket = source["socket"];
 	        this.imageSource = source["imageSource"];
 	        this.imageRef = source["imageRef"];
+	        this.imageDigest = source["imageDigest"];
 	        this.remoteCompilerUrl = source["remoteCompilerUrl"];
 	        this.customRegistry = source["customRegistry"];
 	        this.customTarPath = source["customTarPath"];
@@ -143,6 +166,9 @@ export namespace main {
 	    projectRoot: string;
 	    remoteCompilerUrl: string;
 	    renderer?: RendererConfig;
+	    disableNotifications?: boolean;
+	    shortcuts?: {[key: string]: string};
+	    compileBeforePush?: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new Config(source);
@@ -153,6 +179,71 @@ export namespace main {
 	        this.projectRoot = source["projectRoot"];
 	        this.remoteCompilerUrl = source["remoteCompilerUrl"];
 	        this.renderer = this.convertValues(source["renderer"], RendererConfig);
+	        this.disableNotifications = source["disableNotifications"];
+	        this.shortcuts = source["shortcuts"];
+	        this.compileBeforePush = source["compileBeforePush"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DailyMetrics {
+	    date: string;
+	    attempts: number;
+	    successes: number;
+	    failures: number;
+	    averageDuration: number;
+	    successRate: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DailyMetrics(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.date = source["date"];
+	        this.attempts = source["attempts"];
+	        this.successes = source["successes"];
+	        this.failures = source["failures"];
+	        this.averageDuration = source["averageDuration"];
+	        this.successRate = source["successRate"];
+	    }
+	}
+	export class EditorSyncStatus {
+	    running: boolean;
+	    port?: number;
+	    project?: string;
+	    mainFile?: string;
+	    engine?: string;
+	    build: BuildStatus;
+	
+	    static createFrom(source: any = {}) {
+	        return new EditorSyncStatus(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.running = source["running"];
+	        this.port = source["port"];
+	        this.project = source["project"];
+	        this.mainFile = source["mainFile"];
+	        this.engine = source["engine"];
+	        this.build = this.convertValues(source["build"], BuildStatus);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -229,6 +320,8 @@ export namespace main {
 	}
 	export class GitStatus {
 	    raw: string;
+	    repo: boolean;
+	    status?: vcs.Status;
 	
 	    static createFrom(source: any = {}) {
 	        return new GitStatus(source);
@@ -237,12 +330,107 @@ export namespace main {
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
 	        this.raw = source["raw"];
+	        this.repo = source["repo"];
+	        this.status = this.convertValues(source["status"], vcs.Status);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class HealthSample {
+	    time: string;
+	    healthy: boolean;
+	    responseTime: number;
+	    error?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new HealthSample(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = source["time"];
+	        this.healthy = source["healthy"];
+	        this.responseTime = source["responseTime"];
+	        this.error = source["error"];
 	    }
 	}
+	export class MetricsBreakdown {
+	    attempts: number;
+	    successes: number;
+	    failures: number;
+	    averageDuration: number;
+	    successRate: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MetricsBreakdown(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.attempts = source["attempts"];
+	        this.successes = source["successes"];
+	        this.failures = source["failures"];
+	        this.averageDuration = source["averageDuration"];
+	        this.successRate = source["successRate"];
+	    }
+	}
+	export class MetricsHistory {
+	    days: number;
+	    daily: DailyMetrics[];
+	    byEngine: {[key: string]: MetricsBreakdown};
+	    byProject: {[key: string]: MetricsBreakdown};
+	
+	    static createFrom(source: any = {}) {
+	        return new MetricsHistory(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.days = source["days"];
+	        this.daily = this.convertValues(source["daily"], DailyMetrics);
+	        this.byEngine = this.convertValues(source["byEngine"], MetricsBreakdown, true);
+	        this.byProject = this.convertValues(source["byProject"], MetricsBreakdown, true);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class ProjectInfo {
 	    name: string;
 	    root: string;
 	    compilerUrl: string;
+	    hasVcs: boolean;
 	
 	    static createFrom(source: any = {}) {
 	        return new ProjectInfo(source);
@@ -253,6 +441,7 @@ export namespace main {
 	        this.name = source["name"];
 	        this.root = source["root"];
 	        this.compilerUrl = source["compilerUrl"];
+	        this.hasVcs = source["hasVcs"];
 	    }
 	}
 	export class RemoteCompilerHealth {
@@ -279,6 +468,44 @@ export namespace main {
 	        this.upSince = source["upSince"];
 	    }
 	}
+	export class RemoteCompilerHealthHistory {
+	    url: string;
+	    interval: number;
+	    samples: HealthSample[];
+	    uptime: number;
+	    averageResponseTime: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new RemoteCompilerHealthHistory(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.url = source["url"];
+	        this.interval = source["interval"];
+	        this.samples = this.convertValues(source["samples"], HealthSample);
+	        this.uptime = source["uptime"];
+	        this.averageResponseTime = source["averageResponseTime"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	
 	export class RendererStatus {
 	    state: string;
@@ -300,6 +527,38 @@ export namespace main {
 	        this.logs = source["logs"];
 	    }
 	}
+	export class Shortcut {
+	    id: string;
+	    label: string;
+	    default: string;
+	    accelerator: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Shortcut(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.label = source["label"];
+	        this.default = source["default"];
+	        this.accelerator = source["accelerator"];
+	    }
+	}
+	export class ShortcutConflict {
+	    accelerator: string;
+	    actions: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ShortcutConflict(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.accelerator = source["accelerator"];
+	        this.actions = source["actions"];
+	    }
+	}
 	export class SyncTeXResult {
 	    page?: number;
 	    x?: number;
@@ -325,3 +584,195 @@ export namespace main {
 
 }
 
+export namespace vcs {
+	
+	export class BlameRange {
+	    start: number;
+	    end: number;
+	    commit: string;
+	    author: string;
+	    email: string;
+	    // Go type: time
+	    date: any;
+	    summary: string;
+	    uncommitted: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new BlameRange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.start = source["start"];
+	        this.end = source["end"];
+	        this.commit = source["commit"];
+	        this.author = source["author"];
+	        this.email = source["email"];
+	        this.date = this.convertValues(source["date"], null);
+	        this.summary = source["summary"];
+	        this.uncommitted = source["uncommitted"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class FileSummary {
+	    path: string;
+	    change: string;
+	    sections?: string[];
+	    words_added: number;
+	    words_removed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FileSummary(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.change = source["change"];
+	        this.sections = source["sections"];
+	        this.words_added = source["words_added"];
+	        this.words_removed = source["words_removed"];
+	    }
+	}
+	export class CommitSuggestion {
+	    message: string;
+	    subject: string;
+	    type?: string;
+	    scope?: string;
+	    files: FileSummary[];
+	    words_added: number;
+	    words_removed: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CommitSuggestion(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message = source["message"];
+	        this.subject = source["subject"];
+	        this.type = source["type"];
+	        this.scope = source["scope"];
+	        this.files = this.convertValues(source["files"], FileSummary);
+	        this.words_added = source["words_added"];
+	        this.words_removed = source["words_removed"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class FileChange {
+	    path: string;
+	    from?: string;
+	    change: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new FileChange(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.from = source["from"];
+	        this.change = source["change"];
+	    }
+	}
+	export class InitResult {
+	    root: string;
+	    commit: string;
+	    gitignore_created: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new InitResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.root = source["root"];
+	        this.commit = source["commit"];
+	        this.gitignore_created = source["gitignore_created"];
+	    }
+	}
+	export class Status {
+	    branch: string;
+	    upstream?: string;
+	    ahead: number;
+	    behind: number;
+	    detached: boolean;
+	    no_commits: boolean;
+	    staged: FileChange[];
+	    unstaged: FileChange[];
+	    untracked: string[];
+	    conflicted: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Status(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.branch = source["branch"];
+	        this.upstream = source["upstream"];
+	        this.ahead = source["ahead"];
+	        this.behind = source["behind"];
+	        this.detached = source["detached"];
+	        this.no_commits = source["no_commits"];
+	        this.staged = this.convertValues(source["staged"], FileChange);
+	        this.unstaged = this.convertValues(source["unstaged"], FileChange);
+	        this.untracked = source["untracked"];
+	        this.conflicted = source["conflicted"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+
+}
+
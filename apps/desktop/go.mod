@@ -3,11 +3,18 @@ module github.com/alpha-og/treefrog/apps/desktop
 go 1.24.0
 
 require (
+	github.com/alpha-og/treefrog/packages/go/api v0.0.0
+	github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+	github.com/alpha-og/treefrog/packages/go/client v0.0.0
+	github.com/alpha-og/treefrog/packages/go/events v0.0.0
+	github.com/alpha-og/treefrog/packages/go/http v0.0.0
+	github.com/alpha-og/treefrog/packages/go/vcs v0.0.0
 	github.com/sirupsen/logrus v1.9.4
 	github.com/wailsapp/wails/v2 v2.11.0
 )
 
 require (
+	github.com/Microsoft/go-winio v0.6.2 // indirect
 	github.com/bep/debounce v1.2.1 // indirect
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
@@ -40,4 +47,15 @@ require (
 	golang.org/x/text v0.34.0 // indirect
 )
 
-replace github.com/alpha-og/treefrog/packages/go/synctex => ../../packages/go/synctex
+require github.com/alpha-og/treefrog/packages/go/security v0.0.0
+
+replace (
+	github.com/alpha-og/treefrog/packages/go/api => ../../packages/go/api
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../../packages/go/buildopts
+	github.com/alpha-og/treefrog/packages/go/client => ../../packages/go/client
+	github.com/alpha-og/treefrog/packages/go/events => ../../packages/go/events
+	github.com/alpha-og/treefrog/packages/go/http => ../../packages/go/http
+	github.com/alpha-og/treefrog/packages/go/security => ../../packages/go/security
+	github.com/alpha-og/treefrog/packages/go/synctex => ../../packages/go/synctex
+	github.com/alpha-og/treefrog/packages/go/vcs => ../../packages/go/vcs
+)
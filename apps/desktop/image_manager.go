@@ -42,8 +42,12 @@ func NewImageManager(config *RendererConfig, logger *logrus.Logger) *ImageManage
 func (im *ImageManager) EnsureImage(ctx context.Context) error {
 	// Check if image already exists
 	if im.ImageExists(ctx) && im.isCacheValid() {
-		im.logger.Info("Using cached image")
-		return nil
+		_, err := im.VerifyDigest(ctx)
+		if err == nil {
+			im.logger.Info("Using cached image")
+			return nil
+		}
+		im.logger.WithError(err).Warn("Cached image failed verification, fetching again")
 	}
 
 	switch im.config.ImageSource {
@@ -84,6 +88,8 @@ func (im *ImageManager) pullFromGHCR(ctx context.Context) error {
 		im.logger.WithError(err).Warn("Failed to cleanup partial pulls")
 	}
 
+	ref := im.pullRef(GHCRImageRef)
+
 	var lastErr error
 	maxRetries := 3
 	baseDelay := time.Second
@@ -93,12 +99,12 @@ func (im *ImageManager) pullFromGHCR(ctx context.Context) error {
 		pullCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 		defer cancel()
 
-		cmd := exec.CommandContext(pullCtx, "docker", "pull", GHCRImageRef)
+		cmd := exec.CommandContext(pullCtx, "docker", "pull", ref)
 		output, err := cmd.CombinedOutput()
 
 		if err == nil {
 			// Tag as local name
-			tagCmd := exec.CommandContext(ctx, "docker", "tag", GHCRImageRef, LocalImageName)
+			tagCmd := exec.CommandContext(ctx, "docker", "tag", ref, LocalImageName)
 			if err := tagCmd.Run(); err != nil {
 				im.logger.WithError(err).Error("Failed to tag image after pull")
 				return fmt.Errorf("failed to tag image: %w", err)
@@ -112,7 +118,7 @@ func (im *ImageManager) pullFromGHCR(ctx context.Context) error {
 			}
 
 			im.cache.LastPull = time.Now()
-			im.cache.PullSource = GHCRImageRef
+			im.cache.PullSource = ref
 			im.logger.Info("Successfully pulled and verified from GHCR")
 			return nil
 		}
@@ -197,7 +203,8 @@ func (im *ImageManager) pullCustom(ctx context.Context) error {
 		return errors.New("no custom registry configured")
 	}
 
-	im.logger.Infof("Pulling from custom registry: %s", im.config.CustomRegistry)
+	ref := im.pullRef(im.config.CustomRegistry)
+	im.logger.Infof("Pulling from custom registry: %s", ref)
 
 	// Cleanup any partial downloads first
 	if err := im.cleanupPartialPulls(ctx); err != nil {
@@ -208,14 +215,14 @@ func (im *ImageManager) pullCustom(ctx context.Context) error {
 	pullCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
-	cmd := exec.CommandContext(pullCtx, "docker", "pull", im.config.CustomRegistry)
+	cmd := exec.CommandContext(pullCtx, "docker", "pull", ref)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("pull failed: %w\nOutput: %s", err, output)
 	}
 
 	// Tag as local name
-	tagCmd := exec.CommandContext(ctx, "docker", "tag", im.config.CustomRegistry, LocalImageName)
+	tagCmd := exec.CommandContext(ctx, "docker", "tag", ref, LocalImageName)
 	if err := tagCmd.Run(); err != nil {
 		im.logger.WithError(err).Error("Failed to tag custom image")
 		return fmt.Errorf("failed to tag custom image: %w", err)
@@ -229,7 +236,7 @@ func (im *ImageManager) pullCustom(ctx context.Context) error {
 	}
 
 	im.cache.LastPull = time.Now()
-	im.cache.PullSource = im.config.CustomRegistry
+	im.cache.PullSource = ref
 	im.logger.Info("Successfully pulled and verified from custom registry")
 	return nil
 }
@@ -318,10 +325,72 @@ func (im *ImageManager) verifyImageIntegrity(ctx context.Context) error {
 		im.logger.WithField("size", size).Debug("Image size verified")
 	}
 
+	if _, err := im.VerifyDigest(ctx); err != nil {
+		return err
+	}
+
 	im.logger.WithField("image_id", imageID).Info("Image integrity verified")
 	return nil
 }
 
+// pullRef returns the reference to pull for ref: ref itself, or the same
+// repository at the pinned digest
+func (im *ImageManager) pullRef(ref string) string {
+	if im.config.ImageDigest == "" {
+		return ref
+	}
+	name := ref
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name + "@" + im.config.ImageDigest
+}
+
+// VerifyDigest returns the digest of the local renderer image, its registry
+// digest if it was pulled and its image ID otherwise, and records it in the
+// cache. If a digest is pinned the image must have it.
+func (im *ImageManager) VerifyDigest(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "image", "inspect",
+		"--format={{.Id}}{{range .RepoDigests}} {{.}}{{end}}", LocalImageName)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", errors.New("image ID is empty - likely corrupted")
+	}
+	imageID := fields[0]
+	var digests []string
+	for _, repoDigest := range fields[1:] {
+		if i := strings.LastIndex(repoDigest, "@"); i >= 0 {
+			digests = append(digests, repoDigest[i+1:])
+		}
+	}
+
+	digest := imageID
+	if len(digests) > 0 {
+		digest = digests[0]
+	}
+	if pinned := im.config.ImageDigest; pinned != "" {
+		digest = ""
+		for _, d := range append(digests, imageID) {
+			if d == pinned {
+				digest = pinned
+			}
+		}
+		if digest == "" {
+			return "", fmt.Errorf("image does not match the pinned digest: want %s, have %s", pinned, imageID)
+		}
+	}
+
+	im.cache.Digest = digest
+	return digest, nil
+}
+
 // removeImage forcefully removes an image
 func (im *ImageManager) removeImage(ctx context.Context, imageName string) error {
 	im.logger.WithField("image", imageName).Info("Removing image...")
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// projectMarkers identify the root of a project when walking up from a file
+var projectMarkers = []string{".git", ".treefrog-cache", "latexmkrc", ".latexmkrc"}
+
+// maxProjectSearchDepth bounds how far up the tree a project root is searched
+const maxProjectSearchDepth = 5
+
+// OpenedProject is emitted as "project-opened" when a file or directory is
+// opened from outside the app
+type OpenedProject struct {
+	Project *ProjectInfo `json:"project"`
+	File    string       `json:"file,omitempty"`
+}
+
+// isLaunchPath reports whether a command-line argument names an existing
+// file or directory rather than a flag or URL
+func isLaunchPath(arg string) bool {
+	if arg == "" || strings.HasPrefix(arg, "-") || strings.Contains(arg, "://") {
+		return false
+	}
+	_, err := os.Stat(arg)
+	return err == nil
+}
+
+// findProjectRoot returns the directory to open for a launch path. Directories
+// open as-is; files open the nearest ancestor holding a project marker, or
+// their own directory when none is found.
+func findProjectRoot(path string) (root string, file string, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", "", err
+	}
+	if info.IsDir() {
+		return abs, "", nil
+	}
+
+	dir := filepath.Dir(abs)
+	root = dir
+	for i, candidate := 0, dir; i < maxProjectSearchDepth; i++ {
+		if hasProjectMarker(candidate) {
+			root = candidate
+			break
+		}
+		parent := filepath.Dir(candidate)
+		if parent == candidate {
+			break
+		}
+		candidate = parent
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", "", err
+	}
+	return root, filepath.ToSlash(rel), nil
+}
+
+func hasProjectMarker(dir string) bool {
+	for _, marker := range projectMarkers {
+		if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// queueLaunchPath remembers a file or directory passed on the command line so
+// it can be opened once the frontend is ready
+func (a *App) queueLaunchPath(path string) {
+	a.launchMu.Lock()
+	defer a.launchMu.Unlock()
+	a.pendingOpen = path
+}
+
+// domReady opens any path or deep link queued before the window existed
+func (a *App) domReady(ctx context.Context) {
+	a.launchMu.Lock()
+	path := a.pendingOpen
+	links := a.pendingLinks
+	a.pendingOpen = ""
+	a.pendingLinks = nil
+	a.launchMu.Unlock()
+
+	if path != "" {
+		a.openLaunchPath(path)
+	}
+	for _, link := range links {
+		if err := a.handleDeepLink(link); err != nil {
+			Logger.WithError(err).WithField("url", link).Warn("Failed to handle deep link")
+		}
+	}
+}
+
+// openLaunchPath opens the project containing path and tells the frontend
+// which file, if any, to focus
+func (a *App) openLaunchPath(path string) {
+	root, file, err := findProjectRoot(path)
+	if err != nil {
+		Logger.WithError(err).WithField("path", path).Warn("Cannot open launch path")
+		return
+	}
+
+	Logger.WithField("root", root).WithField("file", file).Info("Opening project from launch path")
+	project, err := a.SetProject(root)
+	if err != nil {
+		Logger.WithError(err).WithField("root", root).Error("Failed to open project from launch path")
+		return
+	}
+
+	if a.ctx != nil {
+		runtime.WindowUnminimise(a.ctx)
+		runtime.WindowShow(a.ctx)
+		runtime.EventsEmit(a.ctx, "project-opened", OpenedProject{Project: project, File: file})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/wailsapp/wails/v2"
@@ -15,14 +16,21 @@ import (
 var assets embed.FS
 
 func main() {
+	// Git runs this executable as its askpass helper during push and pull
+	if os.Getenv(askpassURLEnv) != "" {
+		os.Exit(runAskpass(os.Args[1:]))
+	}
+
 	app := NewApp()
 
-	// Check for protocol URL in command line args (Windows/Linux)
+	// Check for protocol URLs and files/directories in command line args (Windows/Linux)
 	args := os.Args[1:]
 	if len(args) > 0 {
 		for _, arg := range args {
 			if strings.HasPrefix(arg, "treefrog://") {
 				go app.handleCustomProtocol(arg)
+			} else if isLaunchPath(arg) {
+				app.queueLaunchPath(arg)
 			}
 		}
 	}
@@ -38,6 +46,7 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
 		OnStartup:        app.startup,
+		OnDomReady:       app.domReady,
 		OnShutdown:       app.shutdown,
 		Menu:             app.menu(),
 		Bind: []interface{}{
@@ -58,6 +67,13 @@ func main() {
 			OnUrlOpen: func(url string) {
 				app.handleCustomProtocol(url)
 			},
+			OnFileOpen: func(filePath string) {
+				if app.ctx == nil {
+					app.queueLaunchPath(filePath)
+					return
+				}
+				app.openLaunchPath(filePath)
+			},
 		},
 		SingleInstanceLock: &options.SingleInstanceLock{
 			UniqueId:               "treefrog-app-8f4e2a1b-c9d3-4e7f-a5b6-8c2d1e9f0a3b",
@@ -81,7 +97,9 @@ func (a *App) handleCustomProtocol(url string) {
 		return
 	}
 
-	Logger.WithField("url", url).Warn("Unknown custom protocol URL")
+	if err := a.handleDeepLink(url); err != nil {
+		Logger.WithError(err).WithField("url", url).Warn("Failed to handle deep link")
+	}
 }
 
 // onSecondInstanceLaunch handles when a second instance is launched with args
@@ -91,6 +109,16 @@ func (a *App) onSecondInstanceLaunch(secondInstanceData options.SecondInstanceDa
 	for _, arg := range secondInstanceData.Args {
 		if strings.HasPrefix(arg, "treefrog://") {
 			a.handleCustomProtocol(arg)
+			continue
+		}
+
+		// Relative paths are resolved against the second instance's working directory
+		path := arg
+		if !filepath.IsAbs(path) && secondInstanceData.WorkingDirectory != "" {
+			path = filepath.Join(secondInstanceData.WorkingDirectory, path)
+		}
+		if isLaunchPath(path) {
+			a.openLaunchPath(path)
 		}
 	}
 }
@@ -19,18 +19,18 @@ func (a *App) menu() *menu.Menu {
 
 	// File Menu
 	FileMenu := AppMenu.AddSubmenu("File")
-	FileMenu.AddText("Open Project", keys.CmdOrCtrl("o"), func(cd *menu.CallbackData) {
+	FileMenu.AddText("Open Project", a.accel("open-project"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-open-project", nil)
 	})
 	FileMenu.AddSeparator()
-	FileMenu.AddText("New File", keys.CmdOrCtrl("n"), func(cd *menu.CallbackData) {
+	FileMenu.AddText("New File", a.accel("new-file"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-new-file", nil)
 	})
-	FileMenu.AddText("New Folder", keys.Combo("n", keys.CmdOrCtrlKey, keys.ShiftKey), func(cd *menu.CallbackData) {
+	FileMenu.AddText("New Folder", a.accel("new-folder"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-new-folder", nil)
 	})
 	FileMenu.AddSeparator()
-	FileMenu.AddText("Go to Home", keys.CmdOrCtrl("h"), func(cd *menu.CallbackData) {
+	FileMenu.AddText("Go to Home", a.accel("go-home"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-go-home", nil)
 	})
 	FileMenu.AddSeparator()
@@ -40,53 +40,58 @@ func (a *App) menu() *menu.Menu {
 
 	// Build Menu
 	BuildMenu := AppMenu.AddSubmenu("Build")
-	BuildMenu.AddText("Build Document", keys.CmdOrCtrl("b"), func(cd *menu.CallbackData) {
+	BuildMenu.AddText("Build Document", a.accel("build"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-build", nil)
 	})
 	BuildMenu.AddSeparator()
-	BuildMenu.AddText("Stop Build", keys.CmdOrCtrl("k"), func(cd *menu.CallbackData) {
+	BuildMenu.AddText("Stop Build", a.accel("stop-build"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-stop-build", nil)
 	})
 
 	// View Menu
 	ViewMenu := AppMenu.AddSubmenu("View")
-	ViewMenu.AddText("Toggle Sidebar", keys.CmdOrCtrl("1"), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Toggle Sidebar", a.accel("toggle-sidebar"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-toggle-sidebar", nil)
 	})
-	ViewMenu.AddText("Toggle Editor", keys.CmdOrCtrl("2"), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Toggle Editor", a.accel("toggle-editor"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-toggle-editor", nil)
 	})
-	ViewMenu.AddText("Toggle Preview", keys.CmdOrCtrl("3"), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Toggle Preview", a.accel("toggle-preview"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-toggle-preview", nil)
 	})
 	ViewMenu.AddSeparator()
-	ViewMenu.AddText("Zoom In", keys.CmdOrCtrl("="), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Zoom In", a.accel("zoom-in"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-zoom-in", nil)
 	})
-	ViewMenu.AddText("Zoom Out", keys.CmdOrCtrl("-"), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Zoom Out", a.accel("zoom-out"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-zoom-out", nil)
 	})
-	ViewMenu.AddText("Reset Zoom", keys.CmdOrCtrl("0"), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Reset Zoom", a.accel("zoom-reset"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-zoom-reset", nil)
 	})
 	ViewMenu.AddSeparator()
-	ViewMenu.AddText("Toggle Theme", keys.Combo("t", keys.CmdOrCtrlKey, keys.ShiftKey), func(cd *menu.CallbackData) {
+	ViewMenu.AddText("Toggle Theme", a.accel("toggle-theme"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-toggle-theme", nil)
 	})
 
 	// Git Menu
 	GitMenu := AppMenu.AddSubmenu("Git")
-	GitMenu.AddText("Commit Changes", keys.Combo("k", keys.CmdOrCtrlKey, keys.ShiftKey), func(cd *menu.CallbackData) {
+	GitMenu.AddText("Commit Changes", a.accel("git-commit"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-git-commit", nil)
 	})
-	GitMenu.AddText("Push Changes", keys.Combo("p", keys.CmdOrCtrlKey, keys.ShiftKey), func(cd *menu.CallbackData) {
+	GitMenu.AddText("Push Changes", a.accel("git-push"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-git-push", nil)
 	})
-	GitMenu.AddText("Pull Changes", keys.Combo("l", keys.CmdOrCtrlKey, keys.ShiftKey), func(cd *menu.CallbackData) {
+	GitMenu.AddText("Pull Changes", a.accel("git-pull"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-git-pull", nil)
 	})
 	GitMenu.AddSeparator()
-	GitMenu.AddText("Refresh Status", keys.CmdOrCtrl("r"), func(cd *menu.CallbackData) {
+	a.pushGateItem = GitMenu.AddCheckbox("Compile Before Push", false, nil, func(cd *menu.CallbackData) {
+		if err := a.SetCompileBeforePush(cd.MenuItem.Checked); err != nil {
+			Logger.WithError(err).Error("Failed to save compile-before-push setting")
+		}
+	})
+	GitMenu.AddText("Refresh Status", a.accel("git-refresh"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-git-refresh", nil)
 	})
 
@@ -113,6 +118,9 @@ func (a *App) menu() *menu.Menu {
 		AppMenu.Append(menu.EditMenu())
 	}
 
+	// Status Menu (build state and quick actions)
+	a.tray.Menu(AppMenu)
+
 	// Help Menu
 	HelpMenu := AppMenu.AddSubmenu("Help")
 	HelpMenu.AddText("About Treefrog", nil, func(cd *menu.CallbackData) {
@@ -121,7 +129,7 @@ func (a *App) menu() *menu.Menu {
 	HelpMenu.AddText("Documentation", nil, func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-docs", nil)
 	})
-	HelpMenu.AddText("Keyboard Shortcuts", keys.CmdOrCtrl("?"), func(cd *menu.CallbackData) {
+	HelpMenu.AddText("Keyboard Shortcuts", a.accel("show-shortcuts"), func(cd *menu.CallbackData) {
 		runtime2.EventsEmit(a.ctx, "menu-shortcuts", nil)
 	})
 
@@ -1,12 +1,27 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// Build records older than this, or beyond the newest maxBuildRecords,
+	// are dropped from the history; the totals keep counting them
+	buildRecordRetention = 90 * 24 * time.Hour
+	maxBuildRecords      = 5000
+	// History covers at most the retention period
+	maxHistoryDays = int(buildRecordRetention / (24 * time.Hour))
+
+	initialMinDuration = 24 * 60 * 60 * 1000 // 24 hours in milliseconds
+)
+
 // CompilationMetrics tracks compilation statistics
 type CompilationMetrics struct {
 	TotalAttempts      int64   `json:"totalAttempts"`
@@ -22,38 +37,127 @@ type CompilationMetrics struct {
 	LastFailure        string  `json:"lastFailure"` // RFC3339 timestamp
 }
 
-// MetricsCollector collects and aggregates metrics
+// BuildRecord is one compilation attempt in the metrics history
+type BuildRecord struct {
+	Time     string `json:"time"` // RFC3339 timestamp
+	Success  bool   `json:"success"`
+	Duration int64  `json:"duration"` // milliseconds
+	Engine   string `json:"engine"`
+	Project  string `json:"project"` // project root
+}
+
+// MetricsBreakdown summarizes the attempts in one slice of the history
+type MetricsBreakdown struct {
+	Attempts        int64   `json:"attempts"`
+	Successes       int64   `json:"successes"`
+	Failures        int64   `json:"failures"`
+	AverageDuration int64   `json:"averageDuration"` // milliseconds
+	SuccessRate     float64 `json:"successRate"`
+}
+
+// DailyMetrics summarizes one day of attempts, in local time
+type DailyMetrics struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	MetricsBreakdown
+}
+
+// MetricsHistory breaks down the attempts of the last Days days by day,
+// engine and project. Daily has an entry for every day, oldest first.
+type MetricsHistory struct {
+	Days      int                         `json:"days"`
+	Daily     []DailyMetrics              `json:"daily"`
+	ByEngine  map[string]MetricsBreakdown `json:"byEngine"`
+	ByProject map[string]MetricsBreakdown `json:"byProject"`
+}
+
+// metricsFile is the on-disk form of the collector
+type metricsFile struct {
+	Totals  CompilationMetrics `json:"totals"`
+	History []BuildRecord      `json:"history"`
+}
+
+// MetricsCollector collects and aggregates metrics, persisting them to a
+// JSON file so they survive restarts
 type MetricsCollector struct {
 	logger  *logrus.Logger
+	path    string
 	metrics *CompilationMetrics
+	history []BuildRecord
 	mu      sync.RWMutex
 }
 
-// NewMetricsCollector creates a new metrics collector
-func NewMetricsCollector(logger *logrus.Logger) *MetricsCollector {
-	return &MetricsCollector{
-		logger: logger,
-		metrics: &CompilationMetrics{
-			MinDuration: 24 * 60 * 60 * 1000, // 24 hours in milliseconds
-		},
+// NewMetricsCollector creates a metrics collector backed by the file at
+// path, loading any metrics saved there. An empty path keeps metrics in
+// memory only.
+func NewMetricsCollector(logger *logrus.Logger, path string) *MetricsCollector {
+	mc := &MetricsCollector{
+		logger:  logger,
+		path:    path,
+		metrics: &CompilationMetrics{MinDuration: initialMinDuration},
+	}
+	mc.load()
+	return mc
+}
+
+func (mc *MetricsCollector) load() {
+	if mc.path == "" {
+		return
+	}
+	data, err := os.ReadFile(mc.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			mc.logger.WithError(err).Warn("Failed to read metrics file")
+		}
+		return
+	}
+
+	var file metricsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		mc.logger.WithError(err).Warn("Failed to parse metrics file, starting afresh")
+		return
+	}
+	mc.metrics = &file.Totals
+	if mc.metrics.TotalAttempts == 0 {
+		mc.metrics.MinDuration = initialMinDuration
+	}
+	mc.history = file.History
+}
+
+// save writes the metrics to disk. Callers hold mc.mu.
+func (mc *MetricsCollector) save() {
+	if mc.path == "" {
+		return
+	}
+	data, err := json.Marshal(metricsFile{Totals: *mc.metrics, History: mc.history})
+	if err != nil {
+		mc.logger.WithError(err).Warn("Failed to encode metrics")
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(mc.path), 0755); err != nil {
+		mc.logger.WithError(err).Warn("Failed to create metrics directory")
+		return
+	}
+	if err := os.WriteFile(mc.path, data, 0600); err != nil {
+		mc.logger.WithError(err).Warn("Failed to save metrics")
 	}
 }
 
-// RecordAttempt records a compilation attempt
-func (mc *MetricsCollector) RecordAttempt(success bool, duration time.Duration) {
+// RecordAttempt records a compilation attempt of project with engine
+func (mc *MetricsCollector) RecordAttempt(success bool, duration time.Duration, engine, project string) {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
+	now := time.Now()
 	durationMs := duration.Milliseconds()
 	mc.metrics.TotalAttempts++
-	mc.metrics.LastAttempt = time.Now().Format(time.RFC3339)
+	mc.metrics.LastAttempt = now.Format(time.RFC3339)
 
 	if success {
 		mc.metrics.SuccessfulCompiles++
-		mc.metrics.LastSuccess = time.Now().Format(time.RFC3339)
+		mc.metrics.LastSuccess = now.Format(time.RFC3339)
 	} else {
 		mc.metrics.FailedCompiles++
-		mc.metrics.LastFailure = time.Now().Format(time.RFC3339)
+		mc.metrics.LastFailure = now.Format(time.RFC3339)
 	}
 
 	mc.metrics.TotalDuration += durationMs
@@ -69,6 +173,16 @@ func (mc *MetricsCollector) RecordAttempt(success bool, duration time.Duration)
 	// Update averages
 	mc.updateAverages()
 
+	mc.history = append(mc.history, BuildRecord{
+		Time:     now.Format(time.RFC3339),
+		Success:  success,
+		Duration: durationMs,
+		Engine:   engine,
+		Project:  project,
+	})
+	mc.pruneHistory(now)
+	mc.save()
+
 	mc.logger.WithFields(logrus.Fields{
 		"success":        success,
 		"duration_ms":    durationMs,
@@ -91,6 +205,100 @@ func (mc *MetricsCollector) updateAverages() {
 		float64(mc.metrics.TotalAttempts) * 100
 }
 
+// pruneHistory drops records past the retention period or count. Records
+// are appended in time order, so the oldest are at the front.
+func (mc *MetricsCollector) pruneHistory(now time.Time) {
+	cutoff := now.Add(-buildRecordRetention)
+	drop := sort.Search(len(mc.history), func(i int) bool {
+		t, err := time.Parse(time.RFC3339, mc.history[i].Time)
+		return err == nil && t.After(cutoff)
+	})
+	drop = max(drop, len(mc.history)-maxBuildRecords)
+	if drop > 0 {
+		mc.history = append([]BuildRecord(nil), mc.history[drop:]...)
+	}
+}
+
+// History summarizes the attempts of the last days days, today included,
+// up to maxHistoryDays
+func (mc *MetricsCollector) History(days int) MetricsHistory {
+	if days <= 0 {
+		days = 30
+	}
+	days = min(days, maxHistoryDays)
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	start := today.AddDate(0, 0, -(days - 1))
+
+	history := MetricsHistory{
+		Days:      days,
+		Daily:     make([]DailyMetrics, days),
+		ByEngine:  make(map[string]MetricsBreakdown),
+		ByProject: make(map[string]MetricsBreakdown),
+	}
+	for i := range history.Daily {
+		history.Daily[i].Date = start.AddDate(0, 0, i).Format(time.DateOnly)
+	}
+
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+
+	for _, rec := range mc.history {
+		t, err := time.Parse(time.RFC3339, rec.Time)
+		if err != nil || t.Before(start) {
+			continue
+		}
+		t = t.Local()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, now.Location())
+		// Round so a 23- or 25-hour day across a DST change keeps its date
+		if i := int(day.Sub(start).Hours()/24 + 0.5); i >= 0 && i < days {
+			history.Daily[i].add(rec)
+		}
+
+		engine := history.ByEngine[rec.Engine]
+		engine.add(rec)
+		history.ByEngine[rec.Engine] = engine
+
+		project := history.ByProject[rec.Project]
+		project.add(rec)
+		history.ByProject[rec.Project] = project
+	}
+
+	for i := range history.Daily {
+		history.Daily[i].finish()
+	}
+	for k, b := range history.ByEngine {
+		b.finish()
+		history.ByEngine[k] = b
+	}
+	for k, b := range history.ByProject {
+		b.finish()
+		history.ByProject[k] = b
+	}
+	return history
+}
+
+// add counts rec, keeping its total duration in AverageDuration until
+// finish is called
+func (b *MetricsBreakdown) add(rec BuildRecord) {
+	b.Attempts++
+	if rec.Success {
+		b.Successes++
+	} else {
+		b.Failures++
+	}
+	b.AverageDuration += rec.Duration
+}
+
+func (b *MetricsBreakdown) finish() {
+	if b.Attempts == 0 {
+		return
+	}
+	b.AverageDuration /= b.Attempts
+	b.SuccessRate = float64(b.Successes) / float64(b.Attempts) * 100
+}
+
 // GetMetrics returns a copy of current metrics
 func (mc *MetricsCollector) GetMetrics() CompilationMetrics {
 	mc.mu.RLock()
@@ -109,9 +317,9 @@ func (mc *MetricsCollector) Reset() {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
-	mc.metrics = &CompilationMetrics{
-		MinDuration: 24 * 60 * 60 * 1000, // 24 hours in milliseconds
-	}
+	mc.metrics = &CompilationMetrics{MinDuration: initialMinDuration}
+	mc.history = nil
+	mc.save()
 	mc.logger.Info("Metrics reset")
 }
 
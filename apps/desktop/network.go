@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/client"
+	"github.com/sirupsen/logrus"
+)
+
+// Proxy modes of NetworkConfig
+const (
+	ProxySystem = "system" // HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	ProxyNone   = "none"
+	ProxyManual = "manual"
+)
+
+// NetworkConfig is the proxy, dialing and TLS setup of the app's outgoing HTTP
+// requests: builds, auth, health checks and downloads
+type NetworkConfig struct {
+	ProxyMode   string   `json:"proxyMode"`
+	ProxyURL    string   `json:"proxyUrl,omitempty"`    // http, https or socks5 URL for the manual mode
+	NoProxy     string   `json:"noProxy,omitempty"`     // comma-separated hosts, domains and CIDRs bypassing the manual proxy
+	CACertFiles []string `json:"caCertFiles,omitempty"` // PEM files trusted besides the system roots
+
+	PreferIPv6     bool   `json:"preferIpv6,omitempty"`
+	DNSServer      string `json:"dnsServer,omitempty"`      // host[:port]; empty for the system resolver
+	ConnectTimeout int    `json:"connectTimeout,omitempty"` // seconds for the whole dial; 0 for the default
+
+	UploadLimit   int64 `json:"uploadLimit,omitempty"`   // KiB/s of project uploads; 0 for no limit
+	DownloadLimit int64 `json:"downloadLimit,omitempty"` // KiB/s of artifact downloads; 0 for no limit
+}
+
+// sharedTransport sends requests through the transport built from the
+// current network configuration, so clients made before a change pick it up
+type sharedTransport struct {
+	app *App
+}
+
+func (t sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.app.transport()
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// httpClient returns an HTTP client using the configured proxy and CAs.
+// Every outgoing request of the app should be made with one.
+func (a *App) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: sharedTransport{app: a}}
+}
+
+// GetNetworkConfig returns the proxy and CA configuration
+func (a *App) GetNetworkConfig() NetworkConfig {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	if a.config.Network == nil {
+		return NetworkConfig{ProxyMode: ProxySystem}
+	}
+	return *a.config.Network
+}
+
+// SetNetworkConfig checks and saves the proxy and CA configuration, which
+// applies to the next request
+func (a *App) SetNetworkConfig(cfg NetworkConfig) error {
+	if cfg.ProxyMode == "" {
+		cfg.ProxyMode = ProxySystem
+	}
+	cfg.ProxyURL = strings.TrimSpace(cfg.ProxyURL)
+	cfg.DNSServer = strings.TrimSpace(cfg.DNSServer)
+	if _, err := newTransport(cfg); err != nil {
+		return err
+	}
+
+	a.configMu.Lock()
+	a.config.Network = &cfg
+	a.configMu.Unlock()
+
+	a.networkMu.Lock()
+	if a.netTransport != nil {
+		a.netTransport.CloseIdleConnections()
+		a.netTransport = nil
+	}
+	a.networkMu.Unlock()
+	a.applyBandwidthLimits()
+
+	Logger.WithFields(logrus.Fields{
+		"proxyMode":     cfg.ProxyMode,
+		"caCerts":       len(cfg.CACertFiles),
+		"uploadLimit":   cfg.UploadLimit,
+		"downloadLimit": cfg.DownloadLimit,
+	}).Info("Network configuration updated")
+	return a.saveConfig()
+}
+
+// applyBandwidthLimits sets the rate limiters shared by the API clients to
+// the configured limits, also slowing transfers already running
+func (a *App) applyBandwidthLimits() {
+	cfg := a.GetNetworkConfig()
+	a.uploadLimit.SetRate(cfg.UploadLimit * 1024)
+	a.downloadLimit.SetRate(cfg.DownloadLimit * 1024)
+}
+
+// transport returns the transport for the current network configuration,
+// building it on first use after a change
+func (a *App) transport() (*http.Transport, error) {
+	a.networkMu.Lock()
+	defer a.networkMu.Unlock()
+	if a.netTransport != nil {
+		return a.netTransport, nil
+	}
+	transport, err := newTransport(a.GetNetworkConfig())
+	if err != nil {
+		return nil, fmt.Errorf("invalid network configuration: %w", err)
+	}
+	a.netTransport = transport
+	return transport, nil
+}
+
+// newTransport builds a transport with the proxy, dialer and CAs of cfg
+func newTransport(cfg NetworkConfig) (*http.Transport, error) {
+	if cfg.ConnectTimeout < 0 {
+		return nil, fmt.Errorf("connect timeout must not be negative")
+	}
+	if cfg.UploadLimit < 0 || cfg.DownloadLimit < 0 {
+		return nil, fmt.Errorf("bandwidth limits must not be negative")
+	}
+	if cfg.DNSServer != "" {
+		host := cfg.DNSServer
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			return nil, fmt.Errorf("invalid DNS server %q", cfg.DNSServer)
+		}
+	}
+	transport := client.DialOptions{
+		PreferIPv6:     cfg.PreferIPv6,
+		Resolver:       cfg.DNSServer,
+		ConnectTimeout: time.Duration(cfg.ConnectTimeout) * time.Second,
+	}.Transport()
+
+	switch cfg.ProxyMode {
+	case ProxySystem, "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case ProxyNone:
+		transport.Proxy = nil
+	case ProxyManual:
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", cfg.ProxyURL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q (must be http, https or socks5)", proxyURL.Scheme)
+		}
+		bypass := parseNoProxy(cfg.NoProxy)
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypass(req.URL.Hostname()) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown proxy mode %q", cfg.ProxyMode)
+	}
+
+	if len(cfg.CACertFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range cfg.CACertFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no PEM certificates found in %s", file)
+			}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return transport, nil
+}
+
+// parseNoProxy returns whether a host bypasses the proxy by the NO_PROXY
+// style list: "*", host names, domains ("example.com" and ".example.com"
+// both match subdomains), IPs and CIDRs. Loopback hosts always bypass it.
+func parseNoProxy(list string) func(host string) bool {
+	var domains []string
+	var nets []*net.IPNet
+	all := false
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			all = true
+		default:
+			if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+				nets = append(nets, ipNet)
+			} else {
+				domains = append(domains, strings.TrimPrefix(entry, "."))
+			}
+		}
+	}
+
+	return func(host string) bool {
+		host = strings.ToLower(host)
+		if all || host == "localhost" {
+			return true
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			if ip.IsLoopback() {
+				return true
+			}
+			for _, n := range nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+		for _, d := range domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/events"
+	"github.com/sirupsen/logrus"
+)
+
+// pendingBuildFile records the remote build in flight in the cache dir, so
+// an app restarted mid-build picks it up instead of orphaning it
+const pendingBuildFile = "pending-build.json"
+
+// pendingBuildMaxAge is how long a recorded build is worth resuming; the
+// compiler has expired its artifacts by then
+const pendingBuildMaxAge = 24 * time.Hour
+
+// pendingBuild is a build uploaded to the compiler whose result has not
+// been downloaded yet
+type pendingBuild struct {
+	RemoteID    string       `json:"remoteId"`
+	BuildID     string       `json:"buildId"`
+	CompilerURL string       `json:"compilerUrl"`
+	Options     BuildOptions `json:"options"`
+	StartedAt   string       `json:"startedAt"`
+}
+
+// ActiveBuild names a remote build whose project has a pending build record.
+// Active builds are kept in the config, so builds of projects that are not
+// open when the app starts are still known and can be cleaned up.
+type ActiveBuild struct {
+	RemoteID    string `json:"remoteId"`
+	BuildID     string `json:"buildId"`
+	ProjectRoot string `json:"projectRoot"`
+	StartedAt   string `json:"startedAt"`
+}
+
+// expired reports whether a build started at startedAt is too old to resume,
+// or has no usable start time
+func expired(startedAt string, now time.Time) bool {
+	started, err := time.Parse(time.RFC3339, startedAt)
+	return err != nil || now.Sub(started) > pendingBuildMaxAge
+}
+
+func pendingBuildPath(root string) string {
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(projectCacheDir(root), pendingBuildFile)
+}
+
+// savePendingBuild records a build of the project at root that was uploaded
+// and is being waited on
+func (a *App) savePendingBuild(root string, p pendingBuild) error {
+	path := pendingBuildPath(root)
+	if path == "" {
+		return fmt.Errorf("project root not set")
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return a.trackActiveBuild(ActiveBuild{
+		RemoteID:    p.RemoteID,
+		BuildID:     p.BuildID,
+		ProjectRoot: root,
+		StartedAt:   p.StartedAt,
+	})
+}
+
+// clearPendingBuild forgets the recorded build once its result is in. The
+// project's record is only removed while it still names remoteID, as a newer
+// build may have replaced it.
+func (a *App) clearPendingBuild(root, remoteID string) {
+	a.untrackActiveBuilds(remoteID)
+	path := pendingBuildPath(root)
+	if path == "" {
+		return
+	}
+	if p, _ := readPendingBuild(path); p != nil && p.RemoteID != remoteID {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		Logger.WithError(err).Warn("Failed to clear pending build")
+	}
+}
+
+func readPendingBuild(path string) (*pendingBuild, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p pendingBuild
+	if err := json.Unmarshal(data, &p); err != nil || p.RemoteID == "" {
+		return nil, fmt.Errorf("invalid pending build record: %v", err)
+	}
+	return &p, nil
+}
+
+// loadPendingBuild returns the build recorded for the project at root, or
+// nil if there is none or it is too old to resume
+func (a *App) loadPendingBuild(root string) (*pendingBuild, error) {
+	path := pendingBuildPath(root)
+	if path == "" {
+		return nil, nil
+	}
+	p, err := readPendingBuild(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	if expired(p.StartedAt, time.Now()) {
+		os.Remove(path)
+		return nil, nil
+	}
+	return p, nil
+}
+
+// trackActiveBuild adds b to the active builds in the config
+func (a *App) trackActiveBuild(b ActiveBuild) error {
+	a.configMu.Lock()
+	builds := a.config.ActiveBuilds[:0:0]
+	for _, ab := range a.config.ActiveBuilds {
+		if ab.RemoteID != b.RemoteID {
+			builds = append(builds, ab)
+		}
+	}
+	a.config.ActiveBuilds = append(builds, b)
+	a.configMu.Unlock()
+	return a.saveConfig()
+}
+
+// untrackActiveBuilds drops the active builds with the given remote IDs from
+// the config
+func (a *App) untrackActiveBuilds(remoteIDs ...string) {
+	drop := make(map[string]bool, len(remoteIDs))
+	for _, id := range remoteIDs {
+		drop[id] = true
+	}
+
+	a.configMu.Lock()
+	var builds []ActiveBuild
+	for _, b := range a.config.ActiveBuilds {
+		if !drop[b.RemoteID] {
+			builds = append(builds, b)
+		}
+	}
+	changed := len(builds) != len(a.config.ActiveBuilds)
+	a.config.ActiveBuilds = builds
+	a.configMu.Unlock()
+
+	if changed {
+		if err := a.saveConfig(); err != nil {
+			Logger.WithError(err).Warn("Failed to clear active builds")
+		}
+	}
+}
+
+// activeBuilds returns a copy of the active builds in the config
+func (a *App) activeBuilds() []ActiveBuild {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	return append([]ActiveBuild(nil), a.config.ActiveBuilds...)
+}
+
+// abandonActiveBuilds drops active builds that will not be resumed and
+// reports each as interrupted, so listeners waiting on them are not left
+// hanging
+func (a *App) abandonActiveBuilds(builds []ActiveBuild, reason string) {
+	if len(builds) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(builds))
+	now := time.Now().Format(time.RFC3339)
+	for _, b := range builds {
+		Logger.WithFields(logrus.Fields{
+			"remote_id": b.RemoteID,
+			"root":      b.ProjectRoot,
+		}).Info("Dropping interrupted build: " + reason)
+		ids = append(ids, b.RemoteID)
+		a.emitBuildStatus(BuildStatus{
+			ID:        b.BuildID,
+			State:     api.StateError,
+			Message:   "Build interrupted: " + reason,
+			StartedAt: b.StartedAt,
+			EndedAt:   now,
+		})
+	}
+	a.untrackActiveBuilds(ids...)
+}
+
+// pruneActiveBuilds runs at startup and drops the active builds that can no
+// longer be resumed: those too old, and those whose project no longer
+// records them. Builds of projects that are not open stay active until
+// their project is opened again or they expire.
+func (a *App) pruneActiveBuilds() {
+	now := time.Now()
+	var expiredBuilds, gone []ActiveBuild
+	for _, b := range a.activeBuilds() {
+		if expired(b.StartedAt, now) {
+			expiredBuilds = append(expiredBuilds, b)
+			continue
+		}
+		if p, _ := readPendingBuild(pendingBuildPath(b.ProjectRoot)); p == nil || p.RemoteID != b.RemoteID {
+			gone = append(gone, b)
+		}
+	}
+	a.abandonActiveBuilds(expiredBuilds, "expired")
+	a.abandonActiveBuilds(gone, "no longer recorded for its project")
+}
+
+// resumePendingBuild picks up a build the app was waiting on for the open
+// project when it last stopped: it polls the compiler for it and downloads
+// its PDF as if the build had been started by this run. Other active builds
+// of the project are dropped, as only the recorded one's PDF is worth having.
+func (a *App) resumePendingBuild() {
+	root := a.getRoot()
+	if root == "" {
+		return
+	}
+	p, err := a.loadPendingBuild(root)
+	if err != nil {
+		Logger.WithError(err).Warn("Failed to load pending build")
+	}
+
+	var stale []ActiveBuild
+	for _, b := range a.activeBuilds() {
+		if b.ProjectRoot == root && (p == nil || b.RemoteID != p.RemoteID) {
+			stale = append(stale, b)
+		}
+	}
+	if p == nil {
+		a.abandonActiveBuilds(stale, "expired")
+		return
+	}
+
+	a.statusMu.Lock()
+	if a.status.State == api.StateRunning {
+		// The running build may be the recorded one; it clears its own
+		// record when done
+		a.statusMu.Unlock()
+		return
+	}
+	a.status = BuildStatus{
+		ID:        p.BuildID,
+		State:     api.StateRunning,
+		Message:   "Resuming build...",
+		StartedAt: p.StartedAt,
+	}
+	a.lastBuild = p.Options
+	statusCopy := a.status
+	a.statusMu.Unlock()
+
+	a.abandonActiveBuilds(stale, "superseded by a newer build")
+	// Records written before builds were tracked in the config
+	if err := a.trackActiveBuild(ActiveBuild{
+		RemoteID:    p.RemoteID,
+		BuildID:     p.BuildID,
+		ProjectRoot: root,
+		StartedAt:   p.StartedAt,
+	}); err != nil {
+		Logger.WithError(err).Warn("Failed to record active build")
+	}
+
+	Logger.WithField("remote_id", p.RemoteID).Info("Resuming build interrupted by restart")
+	a.emitBuildStatus(statusCopy)
+	a.setRemoteID(p.RemoteID)
+	a.events.Publish(events.BuildStarted{BuildID: p.RemoteID, MainFile: p.Options.MainFile, Engine: p.Options.Engine})
+
+	a.buildWg.Add(1)
+	go func() {
+		defer a.buildWg.Done()
+		defer a.clearPendingBuild(root, p.RemoteID)
+		a.pollBuildStatus(a.clientFor(p.CompilerURL, a.GetSessionToken()), p.RemoteID, p.Options.Engine)
+	}()
+}
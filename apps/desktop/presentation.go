@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/sirupsen/logrus"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+const (
+	// presentationsDir holds the slide timings of each main file in the
+	// project cache
+	presentationsDir = "presentations"
+	// maxRehearsals caps how many rehearsals are kept per main file
+	maxRehearsals = 20
+)
+
+// Presentation layouts
+const (
+	// LayoutSpan stretches the window over the current screen, showing
+	// notes, and the next one, showing slides
+	LayoutSpan = "span"
+	// LayoutFullscreen puts the window full screen on the current screen
+	LayoutFullscreen = "fullscreen"
+)
+
+// Slide is a frame of a beamer presentation and where it is in the PDF
+type Slide struct {
+	Frame int    `json:"frame"` // 1-based index of the frame
+	Title string `json:"title,omitempty"`
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+	// Page is the first page of the frame, 0 when SyncTeX could not place it
+	Page int `json:"page,omitempty"`
+	// NotesPage is the page with the frame's notes: the next page when
+	// notes are shown as pages, the slide's own page on a second screen
+	NotesPage     int      `json:"notesPage,omitempty"`
+	Notes         []string `json:"notes"`
+	TargetSeconds float64  `json:"targetSeconds,omitempty"`
+}
+
+// Presentation is a beamer deck with its slides placed in the latest build
+type Presentation struct {
+	MainFile string  `json:"mainFile"`
+	Notes    string  `json:"notes"`             // where beamer puts the notes
+	BuildID  string  `json:"buildId,omitempty"` // build the pages are of
+	Slides   []Slide `json:"slides"`
+}
+
+// SlideTime is how long a rehearsal stayed on a frame
+type SlideTime struct {
+	Frame   int     `json:"frame"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Rehearsal is a timed run through a presentation
+type Rehearsal struct {
+	StartedAt    string      `json:"startedAt"`
+	EndedAt      string      `json:"endedAt"`
+	TotalSeconds float64     `json:"totalSeconds"`
+	Slides       []SlideTime `json:"slides"`
+}
+
+// SlideTimings are the planned seconds per frame of a main file's
+// presentation and its latest rehearsals
+type SlideTimings struct {
+	MainFile   string          `json:"mainFile"`
+	Targets    map[int]float64 `json:"targets"`
+	Rehearsals []Rehearsal     `json:"rehearsals"`
+}
+
+// PresentationLayout is how the window was laid out for a presentation. In
+// span mode the notes take the first NotesWidth pixels of the window and
+// the slides the rest; full screen shows both on one screen.
+type PresentationLayout struct {
+	Mode        string `json:"mode"`
+	NotesWidth  int    `json:"notesWidth"`
+	SlidesWidth int    `json:"slidesWidth"`
+	Height      int    `json:"height"`
+}
+
+// PresentationState is where a running presentation is, emitted as the
+// "presentation" event on every change
+type PresentationState struct {
+	Active        bool               `json:"active"`
+	MainFile      string             `json:"mainFile,omitempty"`
+	Page          int                `json:"page,omitempty"`
+	PageCount     int                `json:"pageCount,omitempty"`
+	Slide         *Slide             `json:"slide,omitempty"` // the frame the page belongs to
+	Rehearsing    bool               `json:"rehearsing,omitempty"`
+	Elapsed       float64            `json:"elapsed,omitempty"`      // seconds since the start
+	SlideElapsed  float64            `json:"slideElapsed,omitempty"` // seconds on the current frame
+	Layout        PresentationLayout `json:"layout"`
+	Presentation  *Presentation      `json:"presentation,omitempty"`
+	LastRehearsal *Rehearsal         `json:"lastRehearsal,omitempty"`
+}
+
+// windowState is the window's place before a presentation, to restore
+type windowState struct {
+	x, y, width, height int
+	fullscreen          bool
+	// origin of the screen the window was spanned from, as reported by
+	// WindowGetPosition
+	originX, originY int
+}
+
+// Presenter runs a beamer presentation: it lays the window out over the
+// screens, moves through the PDF's pages and, when rehearsing, times each
+// frame
+type Presenter struct {
+	app        *App
+	mu         sync.Mutex
+	active     bool
+	rehearsing bool
+	pres       *Presentation
+	page       int
+	pageCount  int
+	layout     PresentationLayout
+	window     windowState
+	started    time.Time
+	slideStart time.Time
+	spent      map[int]float64 // seconds per frame
+}
+
+// GetPresentation reads the frames and speaker notes of mainFile's beamer
+// presentation and finds their pages in the latest build of it with SyncTeX
+func (a *App) GetPresentation(mainFile string) (*Presentation, error) {
+	path, mainFile, err := a.mainFileCachePath(presentationsDir, mainFile)
+	if err != nil {
+		return nil, err
+	}
+	deck, err := buildopts.ReadDeck(a.getRoot(), mainFile)
+	if err != nil {
+		return nil, err
+	}
+	timings, err := loadSlideTimings(path, mainFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pres := &Presentation{MainFile: mainFile, Notes: deck.Notes, BuildID: a.lineageBuild(mainFile), Slides: []Slide{}}
+	for _, f := range deck.Frames {
+		s := Slide{Frame: f.Index, Title: f.Title, File: f.File, Line: f.Line, Notes: []string{}, TargetSeconds: timings.Targets[f.Index]}
+		for _, n := range f.Notes {
+			s.Notes = append(s.Notes, n.Text)
+		}
+		if pres.BuildID != "" {
+			s.Page = a.syncTeXPage(pres.BuildID, f.File, f.Line, f.Line+1, f.Line+2)
+			switch deck.Notes {
+			case buildopts.NotesPages:
+				if len(f.Notes) > 0 {
+					s.NotesPage = a.syncTeXPage(pres.BuildID, f.Notes[0].File, f.Notes[0].Line, f.Notes[0].Line+1)
+				}
+			case buildopts.NotesRight, buildopts.NotesLeft, buildopts.NotesTop, buildopts.NotesBottom:
+				s.NotesPage = s.Page
+			}
+		}
+		pres.Slides = append(pres.Slides, s)
+	}
+	return pres, nil
+}
+
+// syncTeXPage returns the page of the first of lines of file SyncTeX can
+// place, or 0. Beamer frames are typeset as a whole, so the lines of a
+// frame's start may have nothing of their own on the page.
+func (a *App) syncTeXPage(buildID, file string, lines ...int) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, line := range lines {
+		if result, err := a.compilerClient().SyncTeXView(ctx, buildID, file, line, 0); err == nil {
+			return result.Page
+		}
+	}
+	return 0
+}
+
+// GetSlideTimings returns the planned frame timings of mainFile's
+// presentation and its latest rehearsals
+func (a *App) GetSlideTimings(mainFile string) (*SlideTimings, error) {
+	path, mainFile, err := a.mainFileCachePath(presentationsDir, mainFile)
+	if err != nil {
+		return nil, err
+	}
+	return loadSlideTimings(path, mainFile)
+}
+
+// SetSlideTargets sets the planned seconds of each frame of mainFile's
+// presentation, by frame index
+func (a *App) SetSlideTargets(mainFile string, targets map[int]float64) error {
+	for frame, seconds := range targets {
+		if frame < 1 || seconds < 0 {
+			return fmt.Errorf("invalid target %v seconds for frame %d", seconds, frame)
+		}
+	}
+	path, mainFile, err := a.mainFileCachePath(presentationsDir, mainFile)
+	if err != nil {
+		return err
+	}
+	a.presenter.mu.Lock()
+	defer a.presenter.mu.Unlock()
+	timings, err := loadSlideTimings(path, mainFile)
+	if err != nil {
+		return err
+	}
+	timings.Targets = targets
+	return saveSlideTimings(path, timings)
+}
+
+// GetDisplays lists the screens a presentation can use
+func (a *App) GetDisplays() ([]runtime.Screen, error) {
+	return runtime.ScreenGetAll(a.ctx)
+}
+
+// StartPresentation shows mainFile's presentation from its first page,
+// which has pageCount pages in the preview. With a second screen the window
+// spans both, notes on the current screen and slides on the next; otherwise
+// it goes full screen. With rehearse each frame is timed.
+func (a *App) StartPresentation(mainFile string, pageCount int, rehearse bool) (*PresentationState, error) {
+	pres, err := a.GetPresentation(mainFile)
+	if err != nil {
+		return nil, err
+	}
+	if pres.Notes == buildopts.NotesOnly {
+		return nil, fmt.Errorf("the PDF has only notes pages; remove \\setbeameroption{show only notes} to present")
+	}
+	if pageCount < 1 {
+		return nil, fmt.Errorf("invalid page count %d", pageCount)
+	}
+
+	p := a.presenter
+	p.mu.Lock()
+	if p.active {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("a presentation is already running")
+	}
+	p.active, p.rehearsing, p.pres, p.pageCount = true, rehearse, pres, pageCount
+	p.window = windowState{fullscreen: runtime.WindowIsFullscreen(a.ctx)}
+	p.window.x, p.window.y = runtime.WindowGetPosition(a.ctx)
+	p.window.width, p.window.height = runtime.WindowGetSize(a.ctx)
+	p.layout = p.layOut()
+	p.started = time.Now()
+	p.slideStart = p.started
+	p.spent = make(map[int]float64)
+	p.page = 0
+	p.moveTo(1)
+	state := p.state()
+	p.mu.Unlock()
+
+	Logger.WithFields(logrus.Fields{
+		"action":    "start_presentation",
+		"main_file": pres.MainFile,
+		"layout":    state.Layout.Mode,
+		"rehearse":  rehearse,
+	}).Info("Presentation started")
+	p.emit(state)
+	return state, nil
+}
+
+// StopPresentation ends the running presentation and restores the window.
+// A rehearsal is saved with the presentation's timings and returned.
+func (a *App) StopPresentation() (*Rehearsal, error) {
+	p := a.presenter
+	p.mu.Lock()
+	if !p.active {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("no presentation running")
+	}
+	p.record(time.Now())
+	var rehearsal *Rehearsal
+	if p.rehearsing {
+		rehearsal = &Rehearsal{
+			StartedAt:    p.started.Format(time.RFC3339),
+			EndedAt:      time.Now().Format(time.RFC3339),
+			TotalSeconds: time.Since(p.started).Seconds(),
+			Slides:       []SlideTime{},
+		}
+		for _, s := range p.pres.Slides {
+			if seconds, ok := p.spent[s.Frame]; ok {
+				rehearsal.Slides = append(rehearsal.Slides, SlideTime{Frame: s.Frame, Seconds: seconds})
+			}
+		}
+	}
+	mainFile := p.pres.MainFile
+	p.active, p.pres = false, nil
+	p.restoreWindow()
+	p.mu.Unlock()
+
+	var err error
+	if rehearsal != nil {
+		err = a.saveRehearsal(mainFile, *rehearsal)
+	}
+	p.emit(&PresentationState{Active: false, MainFile: mainFile, LastRehearsal: rehearsal})
+	return rehearsal, err
+}
+
+// GetPresentationState returns where the running presentation is
+func (a *App) GetPresentationState() *PresentationState {
+	a.presenter.mu.Lock()
+	defer a.presenter.mu.Unlock()
+	return a.presenter.state()
+}
+
+// PresentationNext moves to the next slide page, skipping notes pages
+func (a *App) PresentationNext() (*PresentationState, error) {
+	return a.presenter.navigate(func(p *Presenter) int { return p.step(1) })
+}
+
+// PresentationPrev moves to the previous slide page, skipping notes pages
+func (a *App) PresentationPrev() (*PresentationState, error) {
+	return a.presenter.navigate(func(p *Presenter) int { return p.step(-1) })
+}
+
+// PresentationGoToPage moves to a page of the PDF
+func (a *App) PresentationGoToPage(page int) (*PresentationState, error) {
+	return a.presenter.navigate(func(p *Presenter) int { return page })
+}
+
+// PresentationGoToFrame moves to the first page of a frame
+func (a *App) PresentationGoToFrame(frame int) (*PresentationState, error) {
+	return a.presenter.navigate(func(p *Presenter) int {
+		for _, s := range p.pres.Slides {
+			if s.Frame == frame && s.Page > 0 {
+				return s.Page
+			}
+		}
+		return 0
+	})
+}
+
+func (p *Presenter) navigate(target func(p *Presenter) int) (*PresentationState, error) {
+	p.mu.Lock()
+	if !p.active {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("no presentation running")
+	}
+	page := target(p)
+	if page < 1 || page > p.pageCount {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("no such page")
+	}
+	p.moveTo(page)
+	state := p.state()
+	p.mu.Unlock()
+	p.emit(state)
+	return state, nil
+}
+
+// step returns the page delta slide pages away from the current one
+func (p *Presenter) step(delta int) int {
+	page := p.page
+	for {
+		page += delta
+		if page < 1 || page > p.pageCount {
+			return p.page
+		}
+		if !p.notesPage(page) {
+			return page
+		}
+	}
+}
+
+// notesPage reports whether page holds only notes
+func (p *Presenter) notesPage(page int) bool {
+	if p.pres.Notes != buildopts.NotesPages {
+		return false
+	}
+	for _, s := range p.pres.Slides {
+		if s.NotesPage == page {
+			return true
+		}
+	}
+	return false
+}
+
+// moveTo goes to page, charging the time on the frame left to it
+func (p *Presenter) moveTo(page int) {
+	now := time.Now()
+	before := p.slideAt(p.page)
+	after := p.slideAt(page)
+	if before == nil || after == nil || before.Frame != after.Frame {
+		p.record(now)
+		p.slideStart = now
+	}
+	p.page = page
+}
+
+// record adds the time since the current frame was shown to it
+func (p *Presenter) record(now time.Time) {
+	if s := p.slideAt(p.page); s != nil {
+		p.spent[s.Frame] += now.Sub(p.slideStart).Seconds()
+	}
+	p.slideStart = now
+}
+
+// slideAt returns the frame page belongs to: the last placed frame that
+// starts on or before it
+func (p *Presenter) slideAt(page int) *Slide {
+	var found *Slide
+	for i := range p.pres.Slides {
+		s := &p.pres.Slides[i]
+		if s.Page > 0 && s.Page <= page && (found == nil || s.Page >= found.Page) {
+			found = s
+		}
+	}
+	return found
+}
+
+func (p *Presenter) state() *PresentationState {
+	if !p.active {
+		return &PresentationState{}
+	}
+	now := time.Now()
+	return &PresentationState{
+		Active:       true,
+		MainFile:     p.pres.MainFile,
+		Page:         p.page,
+		PageCount:    p.pageCount,
+		Slide:        p.slideAt(p.page),
+		Rehearsing:   p.rehearsing,
+		Elapsed:      now.Sub(p.started).Seconds(),
+		SlideElapsed: now.Sub(p.slideStart).Seconds(),
+		Layout:       p.layout,
+		Presentation: p.pres,
+	}
+}
+
+func (p *Presenter) emit(state *PresentationState) {
+	if p.app.ctx != nil {
+		runtime.EventsEmit(p.app.ctx, "presentation", state)
+	}
+}
+
+// layOut spans the window over the current screen and the next one, if
+// there is a second screen, and otherwise makes it full screen. Screens are
+// assumed to be side by side, the current one on the left.
+func (p *Presenter) layOut() PresentationLayout {
+	ctx := p.app.ctx
+	screens, err := runtime.ScreenGetAll(ctx)
+	if err != nil {
+		Logger.WithError(err).Warn("Failed to list screens")
+	}
+	var current, other *runtime.Screen
+	for i := range screens {
+		switch {
+		case screens[i].IsCurrent && current == nil:
+			current = &screens[i]
+		case other == nil:
+			other = &screens[i]
+		}
+	}
+	if current == nil || other == nil {
+		runtime.WindowFullscreen(ctx)
+		layout := PresentationLayout{Mode: LayoutFullscreen}
+		if current != nil {
+			layout.NotesWidth = current.Size.Width / 2
+			layout.SlidesWidth = current.Size.Width - layout.NotesWidth
+			layout.Height = current.Size.Height
+		}
+		return layout
+	}
+
+	height := max(current.Size.Height, other.Size.Height)
+	if p.window.fullscreen {
+		runtime.WindowUnfullscreen(ctx)
+	}
+	// Wails reports no screen origins and places the window relative to the
+	// screen it is on, so the current screen's origin is where 0,0 lands
+	runtime.WindowSetPosition(ctx, 0, 0)
+	p.window.originX, p.window.originY = runtime.WindowGetPosition(ctx)
+	runtime.WindowSetSize(ctx, current.Size.Width+other.Size.Width, height)
+	return PresentationLayout{Mode: LayoutSpan, NotesWidth: current.Size.Width, SlidesWidth: other.Size.Width, Height: height}
+}
+
+func (p *Presenter) restoreWindow() {
+	ctx := p.app.ctx
+	if p.layout.Mode == LayoutFullscreen && !p.window.fullscreen {
+		runtime.WindowUnfullscreen(ctx)
+	}
+	if p.layout.Mode == LayoutSpan {
+		// Shrunk back, the window is on the screen it was spanned from
+		runtime.WindowSetSize(ctx, p.window.width, p.window.height)
+		runtime.WindowSetPosition(ctx, p.window.x-p.window.originX, p.window.y-p.window.originY)
+		if p.window.fullscreen {
+			runtime.WindowFullscreen(ctx)
+		}
+	}
+}
+
+// saveRehearsal adds a rehearsal to mainFile's timings, keeping the latest
+// maxRehearsals
+func (a *App) saveRehearsal(mainFile string, r Rehearsal) error {
+	path, mainFile, err := a.mainFileCachePath(presentationsDir, mainFile)
+	if err != nil {
+		return err
+	}
+	a.presenter.mu.Lock()
+	defer a.presenter.mu.Unlock()
+	timings, err := loadSlideTimings(path, mainFile)
+	if err != nil {
+		return err
+	}
+	timings.Rehearsals = append(timings.Rehearsals, r)
+	if len(timings.Rehearsals) > maxRehearsals {
+		timings.Rehearsals = timings.Rehearsals[len(timings.Rehearsals)-maxRehearsals:]
+	}
+	return saveSlideTimings(path, timings)
+}
+
+func loadSlideTimings(path, mainFile string) (*SlideTimings, error) {
+	timings := &SlideTimings{MainFile: mainFile}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, timings); err != nil {
+			return nil, fmt.Errorf("invalid slide timings file %s: %w", filepath.Base(path), err)
+		}
+	}
+	if timings.Targets == nil {
+		timings.Targets = map[int]float64{}
+	}
+	if timings.Rehearsals == nil {
+		timings.Rehearsals = []Rehearsal{}
+	}
+	return timings, nil
+}
+
+func saveSlideTimings(path string, timings *SlideTimings) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(timings, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
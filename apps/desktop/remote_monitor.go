@@ -21,16 +21,47 @@ type RemoteCompilerHealth struct {
 	UpSince          string `json:"upSince"`      // RFC3339 timestamp
 }
 
+// healthHistorySize is how many health checks the monitor keeps, an hour's
+// worth at the default check interval
+const healthHistorySize = 120
+
+// HealthSample is the result of one health check
+type HealthSample struct {
+	Time         string `json:"time"` // RFC3339 timestamp
+	Healthy      bool   `json:"healthy"`
+	ResponseTime int64  `json:"responseTime"` // milliseconds
+	Error        string `json:"error,omitempty"`
+}
+
+// RemoteCompilerHealthHistory is the recent health check history of the
+// remote compiler, oldest sample first
+type RemoteCompilerHealthHistory struct {
+	URL                 string         `json:"url"`
+	Interval            int64          `json:"interval"` // milliseconds between checks
+	Samples             []HealthSample `json:"samples"`
+	Uptime              float64        `json:"uptime"`              // percentage of passing checks
+	AverageResponseTime int64          `json:"averageResponseTime"` // milliseconds, passing checks only
+}
+
 // RemoteCompilerMonitor monitors remote compiler health
 type RemoteCompilerMonitor struct {
 	logger         *logrus.Logger
 	health         *RemoteCompilerHealth
+	history        []HealthSample // ring buffer of the last healthHistorySize checks
+	next           int
 	mu             sync.RWMutex
 	checkInterval  time.Duration
 	maxConsecutive int
 	timeout        time.Duration
 	stopChan       chan struct{}
 	wg             sync.WaitGroup
+
+	// OnHealthChange, if set, is called from the monitor's goroutine when
+	// the compiler turns healthy or unhealthy. Set it before Start.
+	OnHealthChange func(RemoteCompilerHealth)
+
+	// HTTPClient, if set, sends the health checks. Set it before Start.
+	HTTPClient *http.Client
 }
 
 // NewRemoteCompilerMonitor creates a new remote compiler monitor
@@ -95,34 +126,60 @@ func (rbm *RemoteCompilerMonitor) checkHealth() {
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		rbm.recordFailure(fmt.Sprintf("request creation failed: %v", err))
+		rbm.notify(rbm.recordFailure(fmt.Sprintf("request creation failed: %v", err)))
 		return
 	}
 
-	client := &http.Client{Timeout: rbm.timeout}
+	client := rbm.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: rbm.timeout}
+	}
 	resp, err := client.Do(req)
 	duration := time.Since(start)
 
 	if err != nil {
-		rbm.recordFailure(fmt.Sprintf("connection failed: %v", err))
+		rbm.notify(rbm.recordFailure(fmt.Sprintf("connection failed: %v", err)))
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		rbm.recordFailure(fmt.Sprintf("unhealthy status code: %d", resp.StatusCode))
+		rbm.notify(rbm.recordFailure(fmt.Sprintf("unhealthy status code: %d", resp.StatusCode)))
 		return
 	}
 
-	rbm.recordSuccess(duration)
+	rbm.notify(rbm.recordSuccess(duration))
+}
+
+// notify reports a health flip to OnHealthChange
+func (rbm *RemoteCompilerMonitor) notify(changed bool) {
+	if changed && rbm.OnHealthChange != nil {
+		rbm.OnHealthChange(rbm.GetHealth())
+	}
 }
 
-// recordSuccess marks a successful health check
-func (rbm *RemoteCompilerMonitor) recordSuccess(duration time.Duration) {
+// addSample appends a check result to the history. Callers hold rbm.mu.
+func (rbm *RemoteCompilerMonitor) addSample(sample HealthSample) {
+	if len(rbm.history) < healthHistorySize {
+		rbm.history = append(rbm.history, sample)
+		return
+	}
+	rbm.history[rbm.next] = sample
+	rbm.next = (rbm.next + 1) % healthHistorySize
+}
+
+// recordSuccess marks a successful health check and reports whether the
+// compiler has recovered
+func (rbm *RemoteCompilerMonitor) recordSuccess(duration time.Duration) bool {
 	rbm.mu.Lock()
 	defer rbm.mu.Unlock()
 
 	wasUnhealthy := !rbm.health.IsHealthy
+	rbm.addSample(HealthSample{
+		Time:         time.Now().Format(time.RFC3339),
+		Healthy:      true,
+		ResponseTime: duration.Milliseconds(),
+	})
 
 	rbm.health.IsHealthy = true
 	rbm.health.LastCheck = time.Now().Format(time.RFC3339)
@@ -141,13 +198,22 @@ func (rbm *RemoteCompilerMonitor) recordSuccess(duration time.Duration) {
 			"response_time_ms": rbm.health.ResponseTime,
 		}).Debug("Remote compiler health check passed")
 	}
+	return wasUnhealthy
 }
 
-// recordFailure marks a failed health check
-func (rbm *RemoteCompilerMonitor) recordFailure(reason string) {
+// recordFailure marks a failed health check and reports whether the
+// compiler has just been marked unhealthy
+func (rbm *RemoteCompilerMonitor) recordFailure(reason string) bool {
 	rbm.mu.Lock()
 	defer rbm.mu.Unlock()
 
+	wasHealthy := rbm.health.IsHealthy
+	rbm.addSample(HealthSample{
+		Time:    time.Now().Format(time.RFC3339),
+		Healthy: false,
+		Error:   reason,
+	})
+
 	rbm.health.ConsecutiveFails++
 	rbm.health.LastCheck = time.Now().Format(time.RFC3339)
 	rbm.health.LastError = reason
@@ -166,6 +232,37 @@ func (rbm *RemoteCompilerMonitor) recordFailure(reason string) {
 			"reason":            reason,
 		}).Debug("Remote compiler health check failed")
 	}
+	return wasHealthy && !rbm.health.IsHealthy
+}
+
+// GetHistory returns the recent health checks, oldest first, with uptime
+// and average latency over them
+func (rbm *RemoteCompilerMonitor) GetHistory() RemoteCompilerHealthHistory {
+	rbm.mu.RLock()
+	defer rbm.mu.RUnlock()
+
+	history := RemoteCompilerHealthHistory{
+		URL:      rbm.health.URL,
+		Interval: rbm.checkInterval.Milliseconds(),
+		Samples:  make([]HealthSample, 0, len(rbm.history)),
+	}
+	history.Samples = append(history.Samples, rbm.history[rbm.next:]...)
+	history.Samples = append(history.Samples, rbm.history[:rbm.next]...)
+
+	var passed, totalResponse int64
+	for _, sample := range history.Samples {
+		if sample.Healthy {
+			passed++
+			totalResponse += sample.ResponseTime
+		}
+	}
+	if len(history.Samples) > 0 {
+		history.Uptime = float64(passed) / float64(len(history.Samples)) * 100
+	}
+	if passed > 0 {
+		history.AverageResponseTime = totalResponse / passed
+	}
+	return history
 }
 
 // GetHealth returns the current health status
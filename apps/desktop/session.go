@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// sessionsDir holds a session file per project in the config directory
+	sessionsDir = "sessions"
+	// maxSessionFiles caps how many open files a session keeps
+	maxSessionFiles = 50
+)
+
+// EditorCursor is a cursor position in an open file, 1-based
+type EditorCursor struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// OpenFile is a file open in the editor and where it was left
+type OpenFile struct {
+	Path       string       `json:"path"` // relative to the project root
+	Cursor     EditorCursor `json:"cursor"`
+	ScrollTop  float64      `json:"scrollTop"`
+	ScrollLeft float64      `json:"scrollLeft"`
+}
+
+// PDFView is where the PDF preview was left
+type PDFView struct {
+	Page      int     `json:"page"`
+	Zoom      float64 `json:"zoom,omitempty"`
+	ScrollTop float64 `json:"scrollTop"`
+}
+
+// Session is the workspace of a project: its open files, the active one
+// and the PDF preview's page
+type Session struct {
+	Root       string     `json:"root"`
+	OpenFiles  []OpenFile `json:"openFiles"`
+	ActiveFile string     `json:"activeFile,omitempty"`
+	PDF        PDFView    `json:"pdf"`
+	SavedAt    string     `json:"savedAt,omitempty"`
+}
+
+// SaveSession stores the workspace of the current project, so reopening it
+// restores the open files, cursors, scroll offsets and PDF page
+func (a *App) SaveSession(session Session) error {
+	root := a.getRoot()
+	if root == "" {
+		return fmt.Errorf("project root not set")
+	}
+
+	saved := Session{Root: root, OpenFiles: []OpenFile{}, PDF: session.PDF, SavedAt: time.Now().Format(time.RFC3339)}
+	seen := make(map[string]bool)
+	for _, f := range session.OpenFiles {
+		rel, err := a.sessionPath(f.Path)
+		if err != nil || seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		f.Path = rel
+		saved.OpenFiles = append(saved.OpenFiles, f)
+		if len(saved.OpenFiles) == maxSessionFiles {
+			break
+		}
+	}
+	if rel, err := a.sessionPath(session.ActiveFile); err == nil && seen[rel] {
+		saved.ActiveFile = rel
+	}
+	if saved.PDF.Page < 1 {
+		saved.PDF.Page = 1
+	}
+
+	path := a.sessionFile(root)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RestoreSession returns the stored workspace of the current project, or
+// an empty one. Files deleted since are left out.
+func (a *App) RestoreSession() (*Session, error) {
+	root := a.getRoot()
+	if root == "" {
+		return nil, fmt.Errorf("project root not set")
+	}
+
+	session := &Session{Root: root, OpenFiles: []OpenFile{}, PDF: PDFView{Page: 1}}
+	data, err := os.ReadFile(a.sessionFile(root))
+	if os.IsNotExist(err) {
+		return session, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved Session
+	if err := json.Unmarshal(data, &saved); err != nil {
+		Logger.WithError(err).WithField("root", root).Warn("Ignoring unreadable session")
+		return session, nil
+	}
+
+	session.PDF, session.SavedAt = saved.PDF, saved.SavedAt
+	for _, f := range saved.OpenFiles {
+		abs, err := a.safePath(f.Path)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(abs); err != nil || info.IsDir() {
+			continue
+		}
+		session.OpenFiles = append(session.OpenFiles, f)
+		if f.Path == saved.ActiveFile {
+			session.ActiveFile = f.Path
+		}
+	}
+	if session.ActiveFile == "" && len(session.OpenFiles) > 0 {
+		session.ActiveFile = session.OpenFiles[0].Path
+	}
+
+	Logger.WithFields(logrus.Fields{
+		"action": "restore_session",
+		"root":   root,
+		"files":  len(session.OpenFiles),
+	}).Debug("Session restored")
+	return session, nil
+}
+
+// sessionPath checks that p is in the project and returns it relative to
+// the root, with slashes
+func (a *App) sessionPath(p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("empty path")
+	}
+	abs, err := a.safePath(p)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(a.getRoot(), abs)
+	if err != nil || rel == "." {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// sessionFile is where the session of the project at root is stored, named
+// by a hash of its path
+func (a *App) sessionFile(root string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(root)))
+	return filepath.Join(filepath.Dir(a.getConfigPath()), sessionsDir, hex.EncodeToString(sum[:8])+".json")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wailsapp/wails/v2/pkg/menu/keys"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// ShortcutAction describes a configurable keyboard shortcut
+type ShortcutAction struct {
+	ID      string `json:"id"`
+	Label   string `json:"label"`
+	Default string `json:"default"`
+}
+
+// Shortcut is the effective binding for an action
+type Shortcut struct {
+	ShortcutAction
+	Accelerator string `json:"accelerator"`
+}
+
+// ShortcutConflict reports two actions bound to the same key combination
+type ShortcutConflict struct {
+	Accelerator string   `json:"accelerator"`
+	Actions     []string `json:"actions"`
+}
+
+// shortcutActions lists every action whose shortcut can be reconfigured.
+// Accelerators use the Wails syntax, e.g. "CmdOrCtrl+Shift+P".
+var shortcutActions = []ShortcutAction{
+	{ID: "open-project", Label: "Open Project", Default: "CmdOrCtrl+O"},
+	{ID: "new-file", Label: "New File", Default: "CmdOrCtrl+N"},
+	{ID: "new-folder", Label: "New Folder", Default: "CmdOrCtrl+Shift+N"},
+	{ID: "go-home", Label: "Go to Home", Default: "CmdOrCtrl+H"},
+	{ID: "build", Label: "Build Document", Default: "CmdOrCtrl+B"},
+	{ID: "stop-build", Label: "Stop Build", Default: "CmdOrCtrl+K"},
+	{ID: "rebuild", Label: "Rebuild", Default: "CmdOrCtrl+Shift+B"},
+	{ID: "open-pdf", Label: "Open PDF", Default: "CmdOrCtrl+Shift+O"},
+	{ID: "open-project-folder", Label: "Open Project Folder", Default: ""},
+	{ID: "toggle-sidebar", Label: "Toggle Sidebar", Default: "CmdOrCtrl+1"},
+	{ID: "toggle-editor", Label: "Toggle Editor", Default: "CmdOrCtrl+2"},
+	{ID: "toggle-preview", Label: "Toggle Preview", Default: "CmdOrCtrl+3"},
+	{ID: "zoom-in", Label: "Zoom In", Default: "CmdOrCtrl+="},
+	{ID: "zoom-out", Label: "Zoom Out", Default: "CmdOrCtrl+-"},
+	{ID: "zoom-reset", Label: "Reset Zoom", Default: "CmdOrCtrl+0"},
+	{ID: "toggle-theme", Label: "Toggle Theme", Default: "CmdOrCtrl+Shift+T"},
+	{ID: "git-commit", Label: "Commit Changes", Default: "CmdOrCtrl+Shift+K"},
+	{ID: "git-push", Label: "Push Changes", Default: "CmdOrCtrl+Shift+P"},
+	{ID: "git-pull", Label: "Pull Changes", Default: "CmdOrCtrl+Shift+L"},
+	{ID: "git-refresh", Label: "Refresh Status", Default: "CmdOrCtrl+R"},
+	{ID: "show-shortcuts", Label: "Keyboard Shortcuts", Default: "CmdOrCtrl+?"},
+}
+
+// reservedShortcuts are fixed bindings that configurable shortcuts may not take
+var reservedShortcuts = map[string]string{
+	"CmdOrCtrl+Q":       "quit",
+	"CmdOrCtrl+Z":       "undo",
+	"CmdOrCtrl+Shift+Z": "redo",
+	"CmdOrCtrl+X":       "cut",
+	"CmdOrCtrl+C":       "copy",
+	"CmdOrCtrl+V":       "paste",
+}
+
+func findShortcutAction(id string) (ShortcutAction, bool) {
+	for _, action := range shortcutActions {
+		if action.ID == id {
+			return action, true
+		}
+	}
+	return ShortcutAction{}, false
+}
+
+// normalizeAccelerator parses an accelerator and returns a canonical form so
+// "shift+cmdorctrl+p" and "CmdOrCtrl+Shift+P" compare equal
+func normalizeAccelerator(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	acc, err := keys.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	mods := make([]string, 0, len(acc.Modifiers))
+	for _, m := range acc.Modifiers {
+		mods = append(mods, string(m))
+	}
+	sort.Strings(mods)
+	return strings.Join(append(mods, acc.Key), "+"), nil
+}
+
+// effectiveShortcuts merges the configured overrides over the defaults
+func effectiveShortcuts(overrides map[string]string) map[string]string {
+	result := make(map[string]string, len(shortcutActions))
+	for _, action := range shortcutActions {
+		result[action.ID] = action.Default
+		if acc, ok := overrides[action.ID]; ok {
+			result[action.ID] = acc
+		}
+	}
+	return result
+}
+
+// detectShortcutConflicts finds accelerators bound to more than one action,
+// including collisions with reserved system shortcuts
+func detectShortcutConflicts(bindings map[string]string) []ShortcutConflict {
+	byAccel := map[string][]string{}
+	display := map[string]string{}
+
+	for reserved, name := range reservedShortcuts {
+		norm, _ := normalizeAccelerator(reserved)
+		byAccel[norm] = append(byAccel[norm], name)
+		display[norm] = reserved
+	}
+	for id, acc := range bindings {
+		norm, err := normalizeAccelerator(acc)
+		if err != nil || norm == "" {
+			continue
+		}
+		byAccel[norm] = append(byAccel[norm], id)
+		if _, ok := display[norm]; !ok {
+			display[norm] = acc
+		}
+	}
+
+	var conflicts []ShortcutConflict
+	for norm, actions := range byAccel {
+		if len(actions) > 1 {
+			sort.Strings(actions)
+			conflicts = append(conflicts, ShortcutConflict{Accelerator: display[norm], Actions: actions})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Accelerator < conflicts[j].Accelerator
+	})
+	return conflicts
+}
+
+// accel returns the menu accelerator currently bound to an action, or nil
+func (a *App) accel(id string) *keys.Accelerator {
+	a.configMu.Lock()
+	overrides := a.config.Shortcuts
+	a.configMu.Unlock()
+
+	acc := effectiveShortcuts(overrides)[id]
+	if acc == "" {
+		return nil
+	}
+	parsed, err := keys.Parse(acc)
+	if err != nil {
+		Logger.WithError(err).WithField("action", id).Warn("Ignoring invalid shortcut")
+		return nil
+	}
+	return parsed
+}
+
+// GetShortcuts returns the effective shortcut of every configurable action
+func (a *App) GetShortcuts() []Shortcut {
+	a.configMu.Lock()
+	overrides := a.config.Shortcuts
+	a.configMu.Unlock()
+
+	bindings := effectiveShortcuts(overrides)
+	result := make([]Shortcut, 0, len(shortcutActions))
+	for _, action := range shortcutActions {
+		result = append(result, Shortcut{ShortcutAction: action, Accelerator: bindings[action.ID]})
+	}
+	return result
+}
+
+// mergeShortcutOverrides validates changes and applies them over the saved
+// overrides. Bindings equal to the default are dropped from the overrides.
+func (a *App) mergeShortcutOverrides(shortcuts map[string]string) (map[string]string, error) {
+	a.configMu.Lock()
+	overrides := make(map[string]string, len(a.config.Shortcuts)+len(shortcuts))
+	for id, acc := range a.config.Shortcuts {
+		overrides[id] = acc
+	}
+	a.configMu.Unlock()
+
+	for id, acc := range shortcuts {
+		action, ok := findShortcutAction(id)
+		if !ok {
+			return nil, fmt.Errorf("unknown shortcut action: %s", id)
+		}
+		if _, err := normalizeAccelerator(acc); err != nil {
+			return nil, fmt.Errorf("invalid shortcut for %s: %w", id, err)
+		}
+		if acc == action.Default {
+			delete(overrides, id)
+		} else {
+			overrides[id] = acc
+		}
+	}
+	return overrides, nil
+}
+
+// CheckShortcutConflicts reports the conflicts the given changes would cause
+// without saving them
+func (a *App) CheckShortcutConflicts(shortcuts map[string]string) ([]ShortcutConflict, error) {
+	overrides, err := a.mergeShortcutOverrides(shortcuts)
+	if err != nil {
+		return nil, err
+	}
+	conflicts := detectShortcutConflicts(effectiveShortcuts(overrides))
+	if conflicts == nil {
+		conflicts = []ShortcutConflict{}
+	}
+	return conflicts, nil
+}
+
+// SetShortcuts rebinds actions to new accelerators and persists them in the
+// config file. An empty accelerator unbinds the action. The change is
+// rejected as a whole if any accelerator is invalid or two actions would
+// share a key combination.
+func (a *App) SetShortcuts(shortcuts map[string]string) error {
+	overrides, err := a.mergeShortcutOverrides(shortcuts)
+	if err != nil {
+		return err
+	}
+
+	if conflicts := detectShortcutConflicts(effectiveShortcuts(overrides)); len(conflicts) > 0 {
+		descs := make([]string, 0, len(conflicts))
+		for _, c := range conflicts {
+			descs = append(descs, fmt.Sprintf("%s (%s)", c.Accelerator, strings.Join(c.Actions, ", ")))
+		}
+		return fmt.Errorf("shortcut conflicts: %s", strings.Join(descs, "; "))
+	}
+
+	a.configMu.Lock()
+	a.config.Shortcuts = overrides
+	a.configMu.Unlock()
+	if err := a.saveConfig(); err != nil {
+		return err
+	}
+
+	Logger.WithField("overrides", len(overrides)).Info("Keyboard shortcuts updated")
+	a.applyShortcuts()
+	return nil
+}
+
+// ResetShortcuts restores every shortcut to its default binding
+func (a *App) ResetShortcuts() error {
+	a.configMu.Lock()
+	a.config.Shortcuts = nil
+	a.configMu.Unlock()
+	if err := a.saveConfig(); err != nil {
+		return err
+	}
+
+	a.applyShortcuts()
+	return nil
+}
+
+// applyShortcuts rebuilds the native menu so new accelerators take effect.
+// Wails v2 registers accelerators with the OS through the application menu,
+// so they work whenever a Treefrog window has focus.
+func (a *App) applyShortcuts() {
+	if a.ctx == nil {
+		return
+	}
+	runtime.MenuSetApplicationMenu(a.ctx, a.menu())
+	runtime.MenuUpdateApplicationMenu(a.ctx)
+	runtime.EventsEmit(a.ctx, "shortcuts-changed", a.GetShortcuts())
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	goruntime "runtime"
+	"strings"
+	"sync"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/wailsapp/wails/v2/pkg/menu"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Tray states shown by the status indicator
+const (
+	TrayIdle     = "idle"
+	TrayBuilding = "building"
+	TraySuccess  = "success"
+	TrayError    = "error"
+)
+
+var trayLabels = map[string]string{
+	TrayIdle:     "○ Idle",
+	TrayBuilding: "◌ Building…",
+	TraySuccess:  "● Build succeeded",
+	TrayError:    "✕ Build failed",
+}
+
+// TrayController mirrors build status into a status menu with quick actions
+// and raises native notifications when builds finish.
+//
+// Wails v2 has no system tray API, so the indicator lives in the application
+// menu bar; the state machine and actions are independent of where it is drawn.
+type TrayController struct {
+	app       *App
+	mu        sync.Mutex
+	state     string
+	statusItm *menu.MenuItem
+}
+
+// NewTrayController creates a tray controller for the app
+func NewTrayController(app *App) *TrayController {
+	return &TrayController{app: app, state: TrayIdle}
+}
+
+// Menu builds the status submenu attached to the application menu
+func (t *TrayController) Menu(parent *menu.Menu) {
+	statusMenu := parent.AddSubmenu("Status")
+
+	t.mu.Lock()
+	t.statusItm = statusMenu.AddText(trayLabels[t.state], nil, nil).Disable()
+	t.mu.Unlock()
+
+	statusMenu.AddSeparator()
+	statusMenu.AddText("Rebuild", t.app.accel("rebuild"), func(cd *menu.CallbackData) {
+		t.app.rebuild()
+	})
+	statusMenu.AddText("Open PDF", t.app.accel("open-pdf"), func(cd *menu.CallbackData) {
+		if err := t.app.OpenPDFExternal(); err != nil {
+			Logger.WithError(err).Warn("Tray: failed to open PDF")
+		}
+	})
+	statusMenu.AddText("Open Project Folder", t.app.accel("open-project-folder"), func(cd *menu.CallbackData) {
+		if err := t.app.OpenProjectFolder(); err != nil {
+			Logger.WithError(err).Warn("Tray: failed to open project folder")
+		}
+	})
+}
+
+// State returns the current tray state
+func (t *TrayController) State() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+// Update maps a build status onto the tray and notifies on completion
+func (t *TrayController) Update(status BuildStatus) {
+	next := trayStateFor(status.State)
+
+	t.mu.Lock()
+	prev := t.state
+	t.state = next
+	if t.statusItm != nil {
+		t.statusItm.SetLabel(trayLabels[next])
+	}
+	t.mu.Unlock()
+
+	if prev == next {
+		return
+	}
+
+	if t.app.ctx != nil {
+		runtime.MenuUpdateApplicationMenu(t.app.ctx)
+		runtime.EventsEmit(t.app.ctx, "tray-state", next)
+	}
+
+	if prev == TrayBuilding && t.app.notificationsEnabled() {
+		switch next {
+		case TraySuccess:
+			go sendNotification("Treefrog", "Build succeeded")
+		case TrayError:
+			go sendNotification("Treefrog", "Build failed: "+status.Message)
+		}
+	}
+}
+
+func trayStateFor(buildState api.ClientState) string {
+	switch buildState {
+	case api.StateQueued, api.StateRunning, api.StateRetrying, api.StateDegraded:
+		return TrayBuilding
+	case api.StateSuccess:
+		return TraySuccess
+	case api.StateError:
+		return TrayError
+	default:
+		return TrayIdle
+	}
+}
+
+// sendNotification shows a native desktop notification using the platform's
+// own tooling. It is best effort; failures are only logged.
+func sendNotification(title, body string) {
+	if len(body) > 200 {
+		body = body[:200] + "…"
+	}
+
+	var cmd *exec.Cmd
+	switch goruntime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", body, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := fmt.Sprintf(`[reflection.assembly]::loadwithpartialname('System.Windows.Forms') | Out-Null; `+
+			`$n = New-Object System.Windows.Forms.NotifyIcon; $n.Icon = [System.Drawing.SystemIcons]::Information; `+
+			`$n.Visible = $true; $n.ShowBalloonTip(5000, '%s', '%s', 'Info'); Start-Sleep -Seconds 6; $n.Dispose()`,
+			psQuote(title), psQuote(body))
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=Treefrog", title, body)
+	}
+
+	if err := cmd.Run(); err != nil {
+		Logger.WithError(err).Debug("Failed to send desktop notification")
+	}
+}
+
+func psQuote(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+// openWithSystem opens a file or directory with the platform's default handler
+func openWithSystem(path string) error {
+	var cmd *exec.Cmd
+	switch goruntime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("explorer", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	return cmd.Start()
+}
+
+// GetTrayState returns the current tray state (idle|building|success|error)
+func (a *App) GetTrayState() string {
+	if a.tray == nil {
+		return TrayIdle
+	}
+	return a.tray.State()
+}
+
+// SetNotificationsEnabled toggles native notifications on build completion
+func (a *App) SetNotificationsEnabled(enabled bool) error {
+	a.configMu.Lock()
+	a.config.DisableNotifications = !enabled
+	a.configMu.Unlock()
+	return a.saveConfig()
+}
+
+func (a *App) notificationsEnabled() bool {
+	return !a.notificationsDisabled()
+}
+
+func (a *App) notificationsDisabled() bool {
+	a.configMu.Lock()
+	defer a.configMu.Unlock()
+	return a.config.DisableNotifications
+}
+
+// OpenPDFExternal opens the last built PDF in the system viewer
+func (a *App) OpenPDFExternal() error {
+	pdfPath, err := a.GetPDFPath()
+	if err != nil {
+		return err
+	}
+	return openWithSystem(pdfPath)
+}
+
+// OpenProjectFolder opens the project root in the system file manager
+func (a *App) OpenProjectFolder() error {
+	root := a.getRoot()
+	if root == "" {
+		return fmt.Errorf("project root not set")
+	}
+	return openWithSystem(root)
+}
+
+// rebuild repeats the last build, or asks the frontend to start one when
+// nothing has been built yet this session
+func (a *App) rebuild() {
+	a.statusMu.Lock()
+	last := a.lastBuild
+	a.statusMu.Unlock()
+
+	if last.MainFile == "" {
+		runtime.EventsEmit(a.ctx, "menu-build", nil)
+		return
+	}
+	if err := a.TriggerBuild(last.MainFile, last.Engine, last.ShellEscape); err != nil {
+		Logger.WithError(err).Warn("Rebuild failed to start")
+	}
+}
@@ -3,32 +3,46 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
 )
 
 const (
 	defaultImage   = "treefrog-local-latex-compiler:latest"
-	defaultEngine  = "pdflatex"
+	defaultEngine  = buildopts.DefaultEngine
 	defaultTimeout = 5 * time.Minute
+	defaultJobs    = 2
 	version        = "1.0.0"
 )
 
 func main() {
 	var (
-		inputFile   = flag.String("input", "main.tex", "Main LaTeX file to compile")
+		inputFile   = flag.String("input", buildopts.DefaultMainFile, "Main LaTeX file to compile")
 		engine      = flag.String("engine", defaultEngine, "LaTeX engine: pdflatex, xelatex, lualatex")
+		outDir      = flag.String("outdir", buildopts.DefaultOutDir, "Directory for aux files and the PDF, relative to the project")
 		image       = flag.String("image", defaultImage, "Docker image to use")
 		timeout     = flag.Duration("timeout", defaultTimeout, "Compilation timeout")
+		hardened    = flag.Bool("hardened", true, "Run with a read-only root filesystem, no capabilities and as the current user")
+		user        = flag.String("user", "", "Container user[:group] (default: the current user)")
+		seccomp     = flag.String("seccomp", "", "Seccomp profile path, or \"unconfined\" (default: Docker's profile)")
+		apparmor    = flag.String("apparmor", "", "AppArmor profile name (default: Docker's profile)")
+		pidsLimit   = flag.Int64("pids-limit", buildopts.DefaultPidsLimit, "Maximum processes in the container, 0 for no limit")
+		network     = flag.Bool("network", false, "Give the compile network access, e.g. for tlmgr (default: offline)")
+		all         = flag.Bool("all", false, "Compile every target declared in "+buildopts.ProjectConfigName+" in parallel")
+		jobs        = flag.Int("jobs", defaultJobs, "Targets compiled at once with -all")
 		showVersion = flag.Bool("version", false, "Show version information")
 		help        = flag.Bool("help", false, "Show help")
 	)
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "latex-local - Compile LaTeX documents in Docker\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: latex-local [options] <project-directory>\n\n")
+		fmt.Fprintf(os.Stderr, "Usage: latex-local [options] <project-directory>\n")
+		fmt.Fprintf(os.Stderr, "       latex-local -all [options] <project-directory>\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 	}
@@ -51,7 +65,24 @@ func main() {
 
 	projectDir := flag.Arg(0)
 
-	if err := validateEngine(*engine); err != nil {
+	opts := buildopts.Options{MainFile: *inputFile, Engine: *engine, OutDir: *outDir, Network: *network}
+	opts.Normalize()
+	if err := opts.Validate(buildopts.Limits{AllowNetwork: true}); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var profile buildopts.RuntimeProfile
+	if *hardened {
+		profile = buildopts.HardenedProfile()
+	}
+	if *user != "" {
+		profile.User = *user
+	}
+	profile.Seccomp = *seccomp
+	profile.AppArmor = *apparmor
+	profile.PidsLimit = *pidsLimit
+	if err := profile.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -73,13 +104,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	inputPath := filepath.Join(absPath, *inputFile)
+	if *all {
+		os.Exit(compileAll(absPath, opts, profile, *image, *timeout, *jobs))
+	}
+
+	inputPath := filepath.Join(absPath, opts.MainFile)
 	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
 		fmt.Fprintf(os.Stderr, "Input file not found: %s\n", inputPath)
 		os.Exit(1)
 	}
 
-	if err := runCompilation(absPath, *inputFile, *engine, *image, *timeout); err != nil {
+	if err := runCompilation(absPath, opts, profile, *image, *timeout, os.Stdout, os.Stderr); err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			os.Exit(exitErr.ExitCode())
 		}
@@ -90,19 +125,6 @@ func main() {
 	fmt.Println("\nCompilation successful!")
 }
 
-func validateEngine(engine string) error {
-	validEngines := map[string]bool{
-		"pdflatex": true,
-		"xelatex":  true,
-		"lualatex": true,
-	}
-	engine = strings.ToLower(engine)
-	if !validEngines[engine] {
-		return fmt.Errorf("invalid engine '%s'. Must be one of: pdflatex, xelatex, lualatex", engine)
-	}
-	return nil
-}
-
 func validatePath(path string) error {
 	evaluated, err := filepath.EvalSymlinks(path)
 	if err != nil {
@@ -132,7 +154,9 @@ func checkDockerAvailable() error {
 	return cmd.Run()
 }
 
-func runCompilation(projectDir, inputFile, engine, image string, timeout time.Duration) error {
+// runCompilation compiles the project in Docker, writing latexmk's output
+// to stdout and stderr
+func runCompilation(projectDir string, opts buildopts.Options, profile buildopts.RuntimeProfile, image string, timeout time.Duration, stdout, stderr io.Writer) error {
 	args := []string{
 		"run", "--rm",
 		"-v", fmt.Sprintf("%s:/project", projectDir),
@@ -140,16 +164,23 @@ func runCompilation(projectDir, inputFile, engine, image string, timeout time.Du
 		"--memory=2g",
 		"--cpus=2",
 		fmt.Sprintf("--timeout=%d", int(timeout.Seconds())),
+	}
+	if !opts.Network {
+		args = append(args, "--network=none")
+	}
+	args = append(args, profile.DockerArgs()...)
+	args = append(args,
 		image,
 		"latexmk", "-pdf", "-interaction=nonstopmode",
-		fmt.Sprintf("-pdflatex=%s", engine),
-		inputFile,
-	}
+		fmt.Sprintf("-pdflatex=%s", opts.Engine),
+		"-output-directory="+opts.OutDir,
+		opts.MainFile,
+	)
 
 	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
-	fmt.Printf("Compiling %s with %s...\n", inputFile, engine)
+	fmt.Fprintf(stdout, "Compiling %s with %s into %s/...\n", opts.MainFile, opts.Engine, opts.OutDir)
 	return cmd.Run()
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// failedOutputLines is how much of a failed target's output the report shows
+const failedOutputLines = 30
+
+// targetResult is the outcome of compiling one target
+type targetResult struct {
+	target   buildopts.Target
+	err      error
+	duration time.Duration
+	output   bytes.Buffer
+}
+
+// compileAll compiles every target of the project, at most jobs at once,
+// and prints a report of their statuses and durations. base holds the
+// options every target shares. It returns the exit code.
+func compileAll(projectDir string, base buildopts.Options, profile buildopts.RuntimeProfile, image string, timeout time.Duration, jobs int) int {
+	targets, err := buildopts.LoadTargets(projectDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(targets) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no targets declared in %s\n", filepath.Join(projectDir, buildopts.ProjectConfigName))
+		return 1
+	}
+	jobs = max(jobs, 1)
+
+	fmt.Printf("Compiling %d targets, %d at a time...\n", len(targets), min(jobs, len(targets)))
+	start := time.Now()
+	results := make([]targetResult, len(targets))
+	slots := make(chan struct{}, jobs)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		result := &results[i]
+		result.target = t
+
+		opts := base
+		t.Apply(&opts)
+		opts.Normalize()
+		if err := opts.Validate(buildopts.Limits{AllowNetwork: true}); err != nil {
+			result.err = err
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(projectDir, opts.MainFile)); err != nil {
+			result.err = fmt.Errorf("input file not found: %s", opts.MainFile)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			began := time.Now()
+			result.err = runCompilation(projectDir, opts, profile, image, timeout, &result.output, &result.output)
+			result.duration = time.Since(began)
+
+			mu.Lock()
+			fmt.Printf("  %-12s %s in %s\n", t.Name, status(result.err), result.duration.Round(100*time.Millisecond))
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tMAIN FILE\tSTATUS\tDURATION")
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.target.Name, r.target.MainFile, status(r.err), r.duration.Round(100*time.Millisecond))
+	}
+	tw.Flush()
+
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "\n--- %s: %v ---\n", r.target.Name, r.err)
+		if tail := lastLines(r.output.String(), failedOutputLines); tail != "" {
+			fmt.Fprintln(os.Stderr, tail)
+		}
+	}
+
+	fmt.Printf("\n%d of %d targets compiled in %s\n", len(results)-failed, len(results), time.Since(start).Round(100*time.Millisecond))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func status(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "ok"
+}
+
+// lastLines returns the last n lines of s
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
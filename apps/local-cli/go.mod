@@ -1,3 +1,12 @@
 module github.com/alpha-og/treefrog/apps/local-cli
 
-go 1.23
+go 1.24.0
+
+require github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+
+require github.com/alpha-og/treefrog/packages/go/security v0.0.0 // indirect
+
+replace (
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../../packages/go/buildopts
+	github.com/alpha-og/treefrog/packages/go/security => ../../packages/go/security
+)
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/api"
 	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/alpha-og/treefrog/packages/go/events"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
 	"github.com/alpha-og/treefrog/packages/go/security"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -20,101 +28,318 @@ var buildLog = logrus.WithField("component", "handlers/build")
 
 func CreateBuildHandler(store *storage.Store, compiler *build.DockerCompiler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseMultipartForm(build.MaxFileSize); err != nil {
-			http.Error(w, fmt.Sprintf("File too large (max %dMB)", build.MaxFileSize/(1024*1024)), http.StatusBadRequest)
+		if !parseUploadForm(w, r) {
 			return
 		}
 
-		engine := build.Engine(r.FormValue("engine"))
-		mainFile := r.FormValue("main_file")
-		shellEscape := r.FormValue("shell_escape") == "true"
-
-		if engine == "" {
-			engine = build.EnginePDFLaTeX
-		}
-		if mainFile == "" {
-			mainFile = "main.tex"
-		}
-
-		if !build.ValidEngines[string(engine)] {
-			http.Error(w, "Invalid engine", http.StatusBadRequest)
-			return
-		}
-
-		if security.HasPathTraversal(mainFile) {
-			http.Error(w, "Invalid main_file: path traversal not allowed", http.StatusBadRequest)
-			return
-		}
-
-		file, fileHeader, err := r.FormFile("file")
+		file, size, err := formSource(r)
 		if err != nil {
-			http.Error(w, "No file uploaded", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		defer file.Close()
 
-		if fileHeader.Size > build.MaxFileSize {
-			http.Error(w, fmt.Sprintf("File too large (max %dMB)", build.MaxFileSize/(1024*1024)), http.StatusBadRequest)
-			return
-		}
-
-		buildID := "bld_" + uuid.New().String()
-
-		b, err := store.Create(buildID, build.BuildOptions{
-			MainFile:    mainFile,
-			Engine:      engine,
-			ShellEscape: shellEscape,
-		})
+		target := r.URL.Query().Get("target")
+		opts, err := formOptions(r, size)
 		if err != nil {
-			buildLog.WithError(err).Error("Failed to create build")
-			http.Error(w, "Failed to create build", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		zipPath := filepath.Join(b.DirPath, "source.zip")
-		dst, err := os.Create(zipPath)
-		if err != nil {
-			buildLog.WithError(err).Error("Failed to create zip file")
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
-			return
+		var project string
+		if target != "" {
+			// The target's main file and engine replace those of the form
+			t, key, err := projectTarget(file, size, target)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			t.Apply(&opts)
+			project = key
 		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, file); err != nil {
-			buildLog.WithError(err).Error("Failed to save zip file")
-			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		opts.Normalize()
+		limits := buildLimits(compiler)
+		opts.ApplyAutoShell(limits)
+		opts.ApplyTimeout(limits)
+		if err := opts.Validate(limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		dst.Close()
 
-		if err := build.ExtractZip(zipPath, b.DirPath); err != nil {
-			buildLog.WithError(err).Error("Failed to extract zip")
-			http.Error(w, "Failed to extract source files", http.StatusInternalServerError)
+		b, err := startBuild(store, compiler, file, buildOptions(opts, project, target))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		b.Status = build.StatusCompiling
-		store.Update(b)
-
-		go func() {
-			if err := compiler.Compile(b); err != nil {
-				buildLog.WithError(err).WithField("build_id", buildID).Error("Compilation failed")
-				b.Status = build.StatusFailed
-				b.ErrorMessage = err.Error()
-			}
-			store.Update(b)
-		}()
-
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(map[string]string{
-			"id":      buildID,
+			"id":      b.ID,
 			"status":  string(b.Status),
+			"target":  b.Target,
 			"message": "Build started",
 		})
 	}
 }
 
+// sourceFile is the uploaded project archive of a build request
+type sourceFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+}
+
+// sealedSource is a decrypted archive, which lives in memory only
+type sealedSource struct {
+	*bytes.Reader
+}
+
+func (sealedSource) Close() error { return nil }
+
+// parseUploadForm parses a multipart upload of at most build.MaxFileSize,
+// writing the error response and returning false when it fails
+func parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	err := r.ParseMultipartForm(build.MaxFileSize)
+	if err == nil {
+		return true
+	}
+	status := http.StatusBadRequest
+	if tfhttp.BodyTooLarge(err) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, fmt.Sprintf("File too large (max %dMB)", build.MaxFileSize/(1024*1024)), status)
+	return false
+}
+
+// formSource opens the project archive of a multipart build request and
+// returns it with its size. Archives sent with encrypted=true are decrypted
+// in memory with sourceKey, so only ciphertext crosses proxies and lands in
+// multipart spill files.
+func formSource(r *http.Request) (sourceFile, int64, error) {
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		return nil, 0, fmt.Errorf("No file uploaded")
+	}
+	if r.FormValue("encrypted") != "true" {
+		return file, fileHeader.Size, nil
+	}
+	defer file.Close()
+
+	if sourceKey == nil {
+		return nil, 0, fmt.Errorf("encrypted sources are not accepted (SOURCE_ENCRYPTION_KEY is not set)")
+	}
+	sealed, err := io.ReadAll(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to read file")
+	}
+	plaintext, err := security.OpenSource(sourceKey, sealed)
+	if err != nil {
+		return nil, 0, err
+	}
+	return sealedSource{bytes.NewReader(plaintext)}, int64(len(plaintext)), nil
+}
+
+// formOptions reads the build options of a multipart build request
+func formOptions(r *http.Request, size int64) (buildopts.Options, error) {
+	opts := buildopts.Options{
+		MainFile:     r.FormValue("main_file"),
+		Engine:       r.FormValue("engine"),
+		OutDir:       r.FormValue("out_dir"),
+		Reproducible: r.FormValue("reproducible") == "true",
+		Index:        r.FormValue("index"),
+		Network:      r.FormValue("network") == "true",
+		PDFX:         r.FormValue("pdfx") == "true",
+		Split:        r.FormValue("split") == "true",
+		SourceSize:   size,
+	}
+	opts.ShellEscape, opts.ShellRestricted = buildopts.ParseShellEscape(r.FormValue("shell_escape"))
+	var err error
+	if opts.Env, err = buildopts.ParseEnv(r.FormValue("env")); err != nil {
+		return opts, err
+	}
+	if opts.Timeout, err = buildopts.ParseTimeout(r.FormValue("timeout")); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// buildOptions are the options of a build of opts, which Validate accepted
+func buildOptions(opts buildopts.Options, project, target string) build.BuildOptions {
+	return build.BuildOptions{
+		MainFile:        opts.MainFile,
+		Engine:          build.Engine(opts.Engine),
+		ShellEscape:     opts.ShellEscape,
+		ShellRestricted: opts.ShellRestricted,
+		AutoShell:       opts.AutoShell,
+		Env:             opts.Env,
+		OutDir:          opts.OutDir,
+		Reproducible:    opts.Reproducible,
+		Network:         opts.Network,
+		Timeout:         int(opts.Timeout / time.Second),
+		Index:           opts.Index,
+		PDFX:            opts.PDFX,
+		Split:           opts.Split,
+		Target:          target,
+		Project:         project,
+	}
+}
+
+// projectTarget looks up target in the .treefrog.json of the uploaded
+// project zip, and returns it with the file's key
+func projectTarget(src io.ReaderAt, size int64, target string) (buildopts.Target, string, error) {
+	config, err := build.ProjectConfigFromZip(src, size)
+	if err != nil {
+		return buildopts.Target{}, "", err
+	}
+	t, err := buildopts.FindTarget(config.Targets, target)
+	return t, config.Key, err
+}
+
+// buildLimits are the limits of builds on this machine. Shell-escape is the
+// user's own call, so minted documents get the restricted profile unasked
+// unless BUILD_AUTO_SHELL_ESCAPE is off; network access and timeouts are set
+// by the deployment.
+func buildLimits(compiler *build.DockerCompiler) buildopts.Limits {
+	def, max := compiler.Timeouts()
+	return buildopts.Limits{
+		AllowShellEscape: true,
+		AutoShellEscape:  autoShellEscape,
+		AllowNetwork:     compiler.NetworkAllowed(),
+		DefaultTimeout:   def,
+		MaxTimeout:       max,
+	}
+}
+
+// startBuild stores and extracts the zipped sources, then compiles them in
+// the background. Returned errors are safe to show to clients.
+func startBuild(store *storage.Store, compiler *build.DockerCompiler, src io.Reader, opts build.BuildOptions) (*build.Build, error) {
+	b, err := createBuild(store, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	beginBuild(store, b)
+	go compileBuild(store, compiler, b)
+	return b, nil
+}
+
+// createBuild stores and extracts the zipped sources of a build that is
+// left pending. Builds of a target start from the target's aux files.
+func createBuild(store *storage.Store, src io.Reader, opts build.BuildOptions) (*build.Build, error) {
+	buildID := "bld_" + uuid.New().String()
+
+	b, err := store.Create(buildID, opts)
+	if err != nil {
+		buildLog.WithError(err).Error("Failed to create build")
+		return nil, fmt.Errorf("Failed to create build")
+	}
+
+	zipPath := filepath.Join(b.DirPath, "source.zip")
+	dst, err := os.Create(zipPath)
+	if err != nil {
+		buildLog.WithError(err).Error("Failed to create zip file")
+		return nil, fmt.Errorf("Failed to save file")
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		buildLog.WithError(err).Error("Failed to save zip file")
+		return nil, fmt.Errorf("Failed to save file")
+	}
+	dst.Close()
+
+	if err := build.ExtractZip(zipPath, b.DirPath); err != nil {
+		buildLog.WithError(err).Error("Failed to extract zip")
+		return nil, fmt.Errorf("Failed to extract source files")
+	}
+
+	if b.Target != "" {
+		carryForward(store, b)
+	}
+	return b, nil
+}
+
+// beginBuild marks a created build as compiling and announces it
+func beginBuild(store *storage.Store, b *build.Build) {
+	b.Status = build.StatusCompiling
+	store.Update(b)
+
+	buildEvents.Publish(events.BuildStarted{BuildID: b.ID, MainFile: b.MainFile, Engine: string(b.Engine)})
+	publishStatus(b)
+}
+
+// compileBuild compiles a begun build and announces its outcome
+func compileBuild(store *storage.Store, compiler *build.DockerCompiler, b *build.Build) {
+	if err := compiler.Compile(b); err != nil {
+		buildLog.WithError(err).WithField("build_id", b.ID).Error("Compilation failed")
+		b.Status = build.StatusFailed
+		b.ErrorMessage = err.Error()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), build.PDFCheckTimeout)
+	build.CheckPDF(ctx, b, pdfxProfile)
+	cancel()
+	if b.PDFXError != "" {
+		buildLog.WithField("build_id", b.ID).Warnf("PDF/X conversion failed: %s", b.PDFXError)
+	}
+	if b.Split {
+		splitBuild(b)
+	}
+	store.Update(b)
+
+	buildEvents.PublishLog(b.ID, b.BuildLog)
+	if b.PDFPath != "" {
+		buildEvents.Publish(events.ArtifactReady{BuildID: b.ID, Resource: "pdf", Path: b.PDFPath})
+	}
+	if b.SyncTeXPath != "" {
+		buildEvents.Publish(events.ArtifactReady{BuildID: b.ID, Resource: "synctex", Path: b.SyncTeXPath})
+	}
+	if b.PDFXPath != "" {
+		buildEvents.Publish(events.ArtifactReady{BuildID: b.ID, Resource: "pdfx", Path: b.PDFXPath})
+	}
+	if len(b.SplitParts) > 0 {
+		buildEvents.Publish(events.ArtifactReady{BuildID: b.ID, Resource: "split", Path: filepath.Join(b.DirPath, build.SplitDir)})
+	}
+	publishStatus(b)
+}
+
+// carryForward seeds a build of a target with the intermediate files of the
+// target's last completed build, so bibliography and cross-reference passes
+// can be skipped. Targets only reuse their own files, and only within the
+// project whose .treefrog.json declared them.
+func carryForward(store *storage.Store, b *build.Build) {
+	if b.Project == "" {
+		return
+	}
+	prev, err := store.LatestCompletedTarget(b.Project, b.Target)
+	if err != nil {
+		return
+	}
+	copied, err := build.CarryForwardAuxFiles(prev.DirPath, b.DirPath, build.OutDir(b))
+	if err != nil {
+		buildLog.WithError(err).WithField("build_id", b.ID).Warn("Failed to carry forward aux files")
+		return
+	}
+	buildLog.WithFields(logrus.Fields{
+		"build_id": b.ID,
+		"target":   b.Target,
+		"from":     prev.ID,
+		"files":    copied,
+	}).Debug("Carried forward aux files")
+}
+
+// publishStatus announces the current state of a build on the event bus
+func publishStatus(b *build.Build) {
+	e := events.BuildStatusChanged{
+		BuildID:   b.ID,
+		State:     api.ClientStateFor(b.Status),
+		Message:   b.ErrorMessage,
+		StartedAt: b.CreatedAt.Format(time.RFC3339),
+	}
+	if b.Status.Terminal() {
+		e.EndedAt = b.UpdatedAt.Format(time.RFC3339)
+	}
+	buildEvents.Publish(e)
+}
+
 func GetBuildHandler(store *storage.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		buildID := chi.URLParam(r, "id")
@@ -130,14 +355,7 @@ func GetBuildHandler(store *storage.Store) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(build.BuildResponse{
-			ID:        b.ID,
-			Status:    b.Status,
-			Engine:    b.Engine,
-			MainFile:  b.MainFile,
-			CreatedAt: b.CreatedAt,
-			ExpiresAt: b.ExpiresAt,
-		})
+		json.NewEncoder(w).Encode(api.NewBuildResponse(b))
 	}
 }
 
@@ -156,13 +374,7 @@ func GetStatusHandler(store *storage.Store) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(build.StatusResponse{
-			ID:        b.ID,
-			Status:    b.Status,
-			Message:   b.ErrorMessage,
-			Engine:    b.Engine,
-			CreatedAt: b.CreatedAt,
-		})
+		json.NewEncoder(w).Encode(build.NewStatusResponse(b))
 	}
 }
 
@@ -192,10 +404,62 @@ func ServePDFHandler(store *storage.Store) http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "application/pdf")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", buildID))
-		http.ServeFile(w, r, b.PDFPath)
+		serveBuildFile(w, r, b, b.PDFPath)
+	}
+}
+
+// serveBuildFile serves an artifact file of a build. Artifacts never change
+// under their build ID, so browsers and caches may keep them for good and
+// revalidate with the ETag.
+func serveBuildFile(w http.ResponseWriter, r *http.Request, b *build.Build, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
 	}
+	tfhttp.SetArtifactCaching(w, tfhttp.ArtifactETag(b.ID, filepath.Base(path), info), true)
+	http.ServeFile(w, r, path)
 }
 
+// ServePDFXHandler serves the PDF/X-1a conversion of a build made with
+// pdfx=true
+func ServePDFXHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildID := chi.URLParam(r, "id")
+		if buildID == "" {
+			http.Error(w, "Build ID required", http.StatusBadRequest)
+			return
+		}
+
+		b, err := store.Get(buildID)
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+
+		if b.PDFXPath == "" {
+			msg := "PDF/X not available"
+			if b.PDFXError != "" {
+				msg += ": " + b.PDFXError
+			}
+			http.Error(w, msg, http.StatusNotFound)
+			return
+		}
+
+		if _, err := os.Stat(b.PDFXPath); os.IsNotExist(err) {
+			http.Error(w, "PDF/X file not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-pdfx.pdf", buildID))
+		serveBuildFile(w, r, b, b.PDFXPath)
+	}
+}
+
+// ServeLogHandler serves the build log, or while the build runs what latexmk
+// has written so far. ?tail=N keeps the last N lines and ?follow=true keeps
+// streaming output until the build finishes.
 func ServeLogHandler(store *storage.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		buildID := chi.URLParam(r, "id")
@@ -210,9 +474,59 @@ func ServeLogHandler(store *storage.Store) http.HandlerFunc {
 			return
 		}
 
+		tail, _ := strconv.Atoi(r.URL.Query().Get("tail"))
+		follow := r.URL.Query().Get("follow") == "true"
+
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Write([]byte(b.BuildLog))
+		if b.Status.Terminal() {
+			w.Write(build.TailLines([]byte(logRedactor.Redact(b.BuildLog)), tail))
+			return
+		}
+
+		// Still compiling: serve what latexmk has written so far
+		live, err := build.ReadLiveLog(b.DirPath)
+		if err != nil {
+			http.Error(w, "Failed to read log", http.StatusInternalServerError)
+			return
+		}
+		if follow {
+			// A trailing partial line is streamed with the rest of it, so a
+			// secret split across the boundary is still redacted
+			live = live[:bytes.LastIndexByte(live, '\n')+1]
+		}
+		w.Write(build.TailLines(logRedactor.RedactBytes(live), tail))
+		if !follow {
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+		rc.Flush()
+		out := logRedactor.Writer(flushWriter{w, rc})
+		err = build.FollowLog(r.Context(), out, b.DirPath, int64(len(live)), func() bool {
+			b, err := store.Get(buildID)
+			return err != nil || b.Status.Terminal()
+		})
+		if err != nil && r.Context().Err() == nil {
+			buildLog.WithError(err).Warn("Log stream ended early")
+		}
+		out.Flush()
+	}
+}
+
+// flushWriter flushes every write so a followed log reaches the client
+// right away
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.rc.Flush()
 	}
+	return n, err
 }
 
 func ServeSyncTeXHandler(store *storage.Store) http.HandlerFunc {
@@ -241,6 +555,6 @@ func ServeSyncTeXHandler(store *storage.Store) http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "application/gzip")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.synctex.gz", buildID))
-		http.ServeFile(w, r, b.SyncTeXPath)
+		serveBuildFile(w, r, b, b.SyncTeXPath)
 	}
 }
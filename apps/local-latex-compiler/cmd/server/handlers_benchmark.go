@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var benchmarkLog = logrus.WithField("component", "handlers/benchmark")
+
+// EngineBenchmark is the outcome of compiling the project with a single engine
+type EngineBenchmark struct {
+	Engine     build.Engine `json:"engine"`
+	Status     build.Status `json:"status"`
+	DurationMs int64        `json:"duration_ms"`
+	PDFBytes   int64        `json:"pdf_bytes,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+type BenchmarkResponse struct {
+	MainFile string            `json:"main_file"`
+	Results  []EngineBenchmark `json:"results"`
+	Fastest  build.Engine      `json:"fastest,omitempty"`
+}
+
+// BenchmarkHandler compiles the uploaded project once per requested engine,
+// sequentially, and reports how long each engine took. Scratch builds are
+// removed once measured so they never show up as regular builds.
+func BenchmarkHandler(store *storage.Store, compiler *build.DockerCompiler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !parseUploadForm(w, r) {
+			return
+		}
+
+		opts := buildopts.Options{
+			MainFile:     r.FormValue("main_file"),
+			OutDir:       r.FormValue("out_dir"),
+			Reproducible: r.FormValue("reproducible") == "true",
+			Index:        r.FormValue("index"),
+			Network:      r.FormValue("network") == "true",
+		}
+		opts.ShellEscape, opts.ShellRestricted = buildopts.ParseShellEscape(r.FormValue("shell_escape"))
+		env, err := buildopts.ParseEnv(r.FormValue("env"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Env = env
+		if opts.Timeout, err = buildopts.ParseTimeout(r.FormValue("timeout")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Normalize()
+		limits := buildLimits(compiler)
+		opts.ApplyAutoShell(limits)
+		opts.ApplyTimeout(limits)
+		if err := opts.Validate(limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mainFile := opts.MainFile
+
+		engines := []build.Engine{build.EnginePDFLaTeX, build.EngineXeLaTeX, build.EngineLuaLaTeX}
+		if raw := r.FormValue("engines"); raw != "" {
+			engines = nil
+			seen := map[string]bool{}
+			for _, e := range strings.Split(raw, ",") {
+				e = strings.ToLower(strings.TrimSpace(e))
+				if err := buildopts.ValidateEngine(e); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if !seen[e] {
+					seen[e] = true
+					engines = append(engines, build.Engine(e))
+				}
+			}
+		}
+
+		file, fileHeader, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		if err := buildopts.ValidateSourceSize(fileHeader.Size, buildopts.MaxSourceSize); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		source, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusBadRequest)
+			return
+		}
+
+		benchID := uuid.New().String()
+		resp := BenchmarkResponse{MainFile: mainFile}
+		var fastest time.Duration
+
+		for _, engine := range engines {
+			result := runBenchmark(store, compiler, benchID, source, build.BuildOptions{
+				MainFile:        mainFile,
+				Engine:          engine,
+				ShellEscape:     opts.ShellEscape,
+				ShellRestricted: opts.ShellRestricted,
+				AutoShell:       opts.AutoShell,
+				Env:             opts.Env,
+				OutDir:          opts.OutDir,
+				Reproducible:    opts.Reproducible,
+				Network:         opts.Network,
+				Timeout:         int(opts.Timeout / time.Second),
+				Index:           opts.Index,
+			})
+			resp.Results = append(resp.Results, result)
+
+			d := time.Duration(result.DurationMs) * time.Millisecond
+			if result.Status == build.StatusCompleted && (resp.Fastest == "" || d < fastest) {
+				resp.Fastest = engine
+				fastest = d
+			}
+		}
+
+		benchmarkLog.WithFields(logrus.Fields{
+			"benchmark_id": benchID,
+			"engines":      len(engines),
+			"fastest":      resp.Fastest,
+		}).Info("Benchmark completed")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func runBenchmark(store *storage.Store, compiler *build.DockerCompiler, benchID string, source []byte, opts build.BuildOptions) EngineBenchmark {
+	result := EngineBenchmark{Engine: opts.Engine, Status: build.StatusFailed}
+
+	buildID := fmt.Sprintf("bench_%s_%s", benchID, opts.Engine)
+	b, err := store.Create(buildID, opts)
+	if err != nil {
+		result.Error = "failed to create build"
+		return result
+	}
+	defer func() {
+		if err := store.Delete(buildID); err != nil {
+			benchmarkLog.WithError(err).WithField("build_id", buildID).Warn("Failed to remove benchmark build")
+		}
+	}()
+
+	zipPath := filepath.Join(b.DirPath, "source.zip")
+	if err := os.WriteFile(zipPath, source, 0644); err != nil {
+		result.Error = "failed to save source"
+		return result
+	}
+
+	start := time.Now()
+	err = compiler.Compile(b)
+	result.DurationMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Status = b.Status
+	if b.Status != build.StatusCompleted {
+		result.Error = b.ErrorMessage
+		return result
+	}
+	if info, err := os.Stat(b.PDFPath); err == nil {
+		result.PDFBytes = info.Size()
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/editors"
+	"github.com/alpha-og/treefrog/packages/go/synctex"
+	"github.com/go-chi/chi/v5"
+)
+
+type registerEditorRequest struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// RegisterEditorHandler registers an editor callback that receives reverse
+// SyncTeX jumps. Registering the same URL again renews it.
+func RegisterEditorHandler(registry *editors.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req registerEditorRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.URL == "" {
+			http.Error(w, "url required", http.StatusBadRequest)
+			return
+		}
+
+		e, err := registry.Register(req.Name, req.URL, req.Token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+func ListEditorsHandler(registry *editors.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(registry.List())
+	}
+}
+
+func UnregisterEditorHandler(registry *editors.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !registry.Unregister(chi.URLParam(r, "id")) {
+			http.Error(w, "Editor not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// notifyEditors pushes a reverse search result to the registered editors
+func notifyEditors(registry *editors.Registry, buildID string, result *synctex.EditResult) {
+	registry.Notify(editors.Jump{
+		Type:    "synctex.reverse",
+		BuildID: buildID,
+		File:    result.File,
+		Line:    result.Line,
+		Col:     result.Col,
+	})
+}
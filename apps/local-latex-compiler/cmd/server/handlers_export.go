@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/export"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/alpha-og/treefrog/packages/go/vcs"
+	"github.com/sirupsen/logrus"
+)
+
+var exportLog = logrus.WithField("component", "handlers/export")
+
+const exportTimeout = 60 * time.Second
+
+// ExportPDFHandler serves a page range and/or 2-up/booklet imposition of a
+// build's PDF. Without a build query parameter the latest completed build,
+// of ?target= if given, is used. The file is named by the template in
+// ?name= or the project's preset, see exportFileName.
+func ExportPDFHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pages := r.URL.Query().Get("pages")
+		layout := r.URL.Query().Get("layout")
+		buildID := r.URL.Query().Get("build")
+		target := r.URL.Query().Get("target")
+
+		if err := export.ValidatePages(pages); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !export.ValidLayouts[layout] {
+			http.Error(w, "Invalid layout (must be 2up or booklet)", http.StatusBadRequest)
+			return
+		}
+
+		var b *build.Build
+		var err error
+		switch {
+		case buildID != "":
+			b, err = store.Get(buildID)
+		case target != "":
+			b, err = store.LatestCompletedTarget("", target)
+		default:
+			b, err = store.LatestCompleted()
+		}
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+
+		if b.PDFPath == "" {
+			http.Error(w, "PDF not available", http.StatusNotFound)
+			return
+		}
+		if _, err := os.Stat(b.PDFPath); os.IsNotExist(err) {
+			http.Error(w, "PDF file not found", http.StatusNotFound)
+			return
+		}
+
+		// The project path names the file after the project and its commit,
+		// which only clients holding the session token may look up
+		projectPath := ""
+		if hostAccess(r) {
+			projectPath = r.URL.Query().Get("path")
+		}
+		filename, err := exportFileName(r.Context(), r.URL.Query().Get("name"), b, projectPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := export.Options{Pages: pages, Layout: export.Layout(layout)}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+		if pages == "" && opts.Layout == export.LayoutNone {
+			http.ServeFile(w, r, b.PDFPath)
+			return
+		}
+
+		if err := export.Available(opts); err != nil {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, fmt.Sprintf("Export unavailable: %v", err), http.StatusNotImplemented)
+			return
+		}
+
+		tmp, err := os.CreateTemp(b.DirPath, "export-*.pdf")
+		if err != nil {
+			exportLog.WithError(err).Error("Failed to create export file")
+			http.Error(w, "Failed to export PDF", http.StatusInternalServerError)
+			return
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		ctx, cancel := context.WithTimeout(r.Context(), exportTimeout)
+		defer cancel()
+
+		if err := export.Transform(ctx, b.PDFPath, tmp.Name(), opts); err != nil {
+			exportLog.WithError(err).WithFields(logrus.Fields{
+				"build_id": b.ID,
+				"pages":    pages,
+				"layout":   layout,
+			}).Error("PDF export failed")
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Failed to export PDF", http.StatusUnprocessableEntity)
+			return
+		}
+
+		http.ServeFile(w, r, tmp.Name())
+	}
+}
+
+// exportFileName names the exported PDF of b with template, or else the
+// export_name preset of the build's target or project in its .treefrog.json.
+// With the absolute path of the project's directory, {project} and
+// {gitsha} are filled in too.
+func exportFileName(ctx context.Context, template string, b *build.Build, projectPath string) (string, error) {
+	if template == "" {
+		if config, err := build.LoadProjectConfig(b.DirPath); err == nil {
+			template = config.ExportNameFor(b.Target, b.MainFile)
+		}
+	}
+
+	vars := buildopts.ExportVars{
+		Main:   b.MainFile,
+		Target: b.Target,
+		Engine: string(b.Engine),
+		Build:  b.ID,
+		Time:   b.UpdatedAt,
+	}
+	if projectPath != "" && filepath.IsAbs(projectPath) {
+		dir := filepath.Clean(projectPath)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			vars.Project = filepath.Base(dir)
+			if vcs.RepoRoot(dir) != "" {
+				if commit, err := vcs.Head(ctx, dir); err == nil {
+					vars.GitSHA = vcs.ShortHash(commit)
+				}
+			}
+		}
+	}
+	return buildopts.ExportName(template, vars)
+}
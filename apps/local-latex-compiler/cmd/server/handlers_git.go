@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/alpha-og/treefrog/packages/go/vcs"
+	"github.com/sirupsen/logrus"
+)
+
+var gitLog = logrus.WithField("component", "handlers/git")
+
+type gitInitRequest struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type gitRepoResponse struct {
+	Repo bool   `json:"repo"`
+	Root string `json:"root,omitempty"`
+}
+
+// gitStatusResponse matches the desktop app's GitStatus
+type gitStatusResponse struct {
+	Raw    string      `json:"raw"`
+	Repo   bool        `json:"repo"`
+	Status *vcs.Status `json:"status,omitempty"`
+}
+
+// GitRepoHandler reports whether the project at ?path= is in a git
+// repository, so clients can offer to initialize one
+func GitRepoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := projectDir(w, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		root := vcs.RepoRoot(dir)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(gitRepoResponse{Repo: root != "", Root: root})
+	}
+}
+
+// GitStatusHandler returns the git status of the project at ?path=, raw and
+// parsed into branch, ahead/behind and changed files
+func GitStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := projectDir(w, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+
+		resp := gitStatusResponse{Raw: "not a git repository"}
+		if vcs.RepoRoot(dir) != "" {
+			raw, status, err := vcs.ReadStatus(r.Context(), dir)
+			if err != nil {
+				gitLog.WithError(err).WithField("path", dir).Error("Failed to read git status")
+				http.Error(w, "Failed to read git status", http.StatusInternalServerError)
+				return
+			}
+			resp = gitStatusResponse{Raw: raw, Repo: true, Status: status}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// GitBlameHandler returns who last changed each line of the file at the
+// absolute ?path=, or with ?line= only the range of lines around that line
+func GitBlameHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Query().Get("path")
+		if path == "" || !filepath.IsAbs(path) {
+			http.Error(w, "absolute path required", http.StatusBadRequest)
+			return
+		}
+		path = filepath.Clean(path)
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			http.Error(w, "path is not a file", http.StatusBadRequest)
+			return
+		}
+		line := 0
+		if s := r.URL.Query().Get("line"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				http.Error(w, "invalid line", http.StatusBadRequest)
+				return
+			}
+			line = n
+		}
+		if vcs.RepoRoot(filepath.Dir(path)) == "" {
+			http.Error(w, "file is not in a git repository", http.StatusNotFound)
+			return
+		}
+
+		ranges, err := vcs.BlameFile(r.Context(), path)
+		if err != nil {
+			// Untracked files and the like; git's message says which
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if line > 0 {
+			blamed := vcs.BlameLine(ranges, line)
+			ranges = []vcs.BlameRange{}
+			if blamed != nil {
+				ranges = append(ranges, *blamed)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ranges)
+	}
+}
+
+// GitCommitMessageHandler suggests a commit message for the changes in the
+// project at ?path=: all of them, or only what is staged with ?staged=true.
+// ?conventional=true prefixes the subject "type(scope): ".
+func GitCommitMessageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		dir, ok := projectDir(w, q.Get("path"))
+		if !ok {
+			return
+		}
+		if vcs.RepoRoot(dir) == "" {
+			http.Error(w, "project is not in a git repository", http.StatusNotFound)
+			return
+		}
+
+		staged := q.Get("staged") == "true"
+		suggestion, err := vcs.SuggestCommitMessage(r.Context(), dir, !staged, q.Get("conventional") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(suggestion)
+	}
+}
+
+// GitInitHandler initializes a git repository in the project at path with a
+// LaTeX .gitignore and commits the project as it is
+func GitInitHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req gitInitRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		dir, ok := projectDir(w, req.Path)
+		if !ok {
+			return
+		}
+
+		result, err := vcs.Init(r.Context(), dir, req.Message)
+		if errors.Is(err, vcs.ErrAlreadyRepo) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			gitLog.WithError(err).WithField("path", dir).Error("Failed to initialize repository")
+			http.Error(w, "Failed to initialize repository", http.StatusInternalServerError)
+			return
+		}
+		gitLog.WithFields(logrus.Fields{
+			"path":   dir,
+			"commit": result.Commit,
+		}).Info("Repository initialized")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// projectDir checks that path is the absolute path of a directory holding a
+// LaTeX project, writing the error response if it is not
+func projectDir(w http.ResponseWriter, path string) (string, bool) {
+	if path == "" || !filepath.IsAbs(path) {
+		http.Error(w, "absolute path required", http.StatusBadRequest)
+		return "", false
+	}
+	dir := filepath.Clean(path)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		http.Error(w, "path is not a directory", http.StatusBadRequest)
+		return "", false
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tex")); len(matches) == 0 {
+		http.Error(w, "path is not a LaTeX project", http.StatusBadRequest)
+		return "", false
+	}
+	return dir, true
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
+	"strconv"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
 )
 
+// HealthHandler reports that the server is up and which API versions it speaks
 func HealthHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(api.VersionHeader, strconv.Itoa(api.Version))
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
+		json.NewEncoder(w).Encode(api.NewHealthResponse())
 	}
 }
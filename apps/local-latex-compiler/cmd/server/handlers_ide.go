@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/editors"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/alpha-og/treefrog/packages/go/synctex"
+	"github.com/sirupsen/logrus"
+)
+
+var ideLog = logrus.WithField("component", "handlers/ide")
+
+// IDEProtocolVersion is the version of the /api/ide contract. Methods and
+// fields are only ever added within a version; anything that would break an
+// existing client bumps it.
+const IDEProtocolVersion = 1
+
+// JSON-RPC 2.0 error codes, plus application codes in the reserved
+// server-error range
+const (
+	rpcParseError         = -32700
+	rpcInvalidRequest     = -32600
+	rpcMethodNotFound     = -32601
+	rpcInvalidParams      = -32602
+	rpcInternalError      = -32603
+	rpcBuildNotFound      = -32001
+	rpcNotAvailable       = -32002
+	rpcNoMatch            = -32003
+	rpcUnsupportedVersion = -32004
+)
+
+// ideMethods lists the methods of the current protocol version
+var ideMethods = []string{
+	"ide.version",
+	"build.start",
+	"build.status",
+	"synctex.forward",
+	"synctex.reverse",
+	"problems.list",
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func rpcErrorf(code int, format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type ideVersionParams struct {
+	Protocol int `json:"protocol,omitempty"`
+}
+
+type ideVersionResult struct {
+	Protocol int      `json:"protocol"`
+	Server   string   `json:"server"`
+	Methods  []string `json:"methods"`
+}
+
+type ideBuildStartParams struct {
+	Source          string            `json:"source"` // base64-encoded zip of the project
+	MainFile        string            `json:"mainFile,omitempty"`
+	Engine          string            `json:"engine,omitempty"`
+	ShellEscape     bool              `json:"shellEscape,omitempty"`
+	ShellRestricted bool              `json:"shellRestricted,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	OutDir          string            `json:"outDir,omitempty"`
+	Reproducible    bool              `json:"reproducible,omitempty"`
+	Network         bool              `json:"network,omitempty"`
+	Timeout         int               `json:"timeout,omitempty"` // seconds
+	Index           string            `json:"index,omitempty"`
+}
+
+type ideBuildParams struct {
+	ID string `json:"id"`
+}
+
+type ideForwardParams struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col,omitempty"`
+}
+
+type ideReverseParams struct {
+	ID   string  `json:"id"`
+	Page int     `json:"page"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// maxIDEBody leaves room for a base64-encoded upload of the maximum size
+// plus the envelope
+const maxIDEBody = int64(build.MaxFileSize)*4/3 + 64*1024
+
+// IDEHandler serves the JSON-RPC 2.0 endpoint used by editor extensions.
+// Requests are single calls; batches are not supported. See IDE_API.md.
+func IDEHandler(store *storage.Store, compiler *build.DockerCompiler, registry *editors.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Treefrog-IDE-Protocol", fmt.Sprint(IDEProtocolVersion))
+
+		var req rpcRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIDEBody)).Decode(&req); err != nil {
+			writeRPC(w, rpcResponse{Error: rpcErrorf(rpcParseError, "Parse error")})
+			return
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			writeRPC(w, rpcResponse{ID: req.ID, Error: rpcErrorf(rpcInvalidRequest, "Invalid request")})
+			return
+		}
+
+		result, err := dispatchIDE(store, compiler, registry, req)
+
+		// Notifications carry no id and get no response body
+		if len(req.ID) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		resp := rpcResponse{ID: req.ID, Result: result}
+		if err != nil {
+			var rerr *rpcError
+			if !errors.As(err, &rerr) {
+				ideLog.WithError(err).WithField("method", req.Method).Error("IDE request failed")
+				rerr = rpcErrorf(rpcInternalError, "Internal error")
+			}
+			resp.Result = nil
+			resp.Error = rerr
+		}
+		writeRPC(w, resp)
+	}
+}
+
+func writeRPC(w http.ResponseWriter, resp rpcResponse) {
+	resp.JSONRPC = "2.0"
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func decodeParams(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return rpcErrorf(rpcInvalidParams, "params required")
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return rpcErrorf(rpcInvalidParams, "Invalid params: %v", err)
+	}
+	return nil
+}
+
+func dispatchIDE(store *storage.Store, compiler *build.DockerCompiler, registry *editors.Registry, req rpcRequest) (interface{}, error) {
+	switch req.Method {
+	case "ide.version":
+		var p ideVersionParams
+		if len(req.Params) > 0 {
+			if err := decodeParams(req.Params, &p); err != nil {
+				return nil, err
+			}
+		}
+		if p.Protocol > IDEProtocolVersion {
+			return nil, rpcErrorf(rpcUnsupportedVersion, "Protocol %d not supported (server speaks %d)", p.Protocol, IDEProtocolVersion)
+		}
+		return ideVersionResult{Protocol: IDEProtocolVersion, Server: "treefrog-local-latex-compiler", Methods: ideMethods}, nil
+
+	case "build.start":
+		var p ideBuildStartParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return ideStartBuild(store, compiler, p)
+
+	case "build.status":
+		var p ideBuildParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		b, err := ideGetBuild(store, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		return build.NewStatusResponse(b), nil
+
+	case "synctex.forward":
+		var p ideForwardParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if p.File == "" || p.Line < 1 || p.Col < 0 {
+			return nil, rpcErrorf(rpcInvalidParams, "file and line (>= 1) required")
+		}
+		data, err := ideSyncTeX(store, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		result, err := data.ForwardSearch(p.File, p.Line, p.Col)
+		if err != nil {
+			return nil, rpcErrorf(rpcNoMatch, "Forward search failed: %v", err)
+		}
+		return result, nil
+
+	case "synctex.reverse":
+		var p ideReverseParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if p.Page < 1 || p.X < 0 || p.Y < 0 {
+			return nil, rpcErrorf(rpcInvalidParams, "page (>= 1), x and y (>= 0) required")
+		}
+		data, err := ideSyncTeX(store, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		result, err := data.ReverseSearch(p.Page, p.X, p.Y)
+		if err != nil {
+			return nil, rpcErrorf(rpcNoMatch, "Reverse search failed: %v", err)
+		}
+		notifyEditors(registry, p.ID, result)
+		return result, nil
+
+	case "problems.list":
+		var p ideBuildParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		b, err := ideGetBuild(store, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		return buildProblems(b), nil
+
+	default:
+		return nil, rpcErrorf(rpcMethodNotFound, "Method not found: %s", req.Method)
+	}
+}
+
+func ideStartBuild(store *storage.Store, compiler *build.DockerCompiler, p ideBuildStartParams) (interface{}, error) {
+	source, err := base64.StdEncoding.DecodeString(p.Source)
+	if err != nil || len(source) == 0 {
+		return nil, rpcErrorf(rpcInvalidParams, "source must be a base64-encoded zip")
+	}
+
+	opts := buildopts.Options{
+		MainFile:        p.MainFile,
+		Engine:          p.Engine,
+		ShellEscape:     p.ShellEscape,
+		ShellRestricted: p.ShellRestricted,
+		Env:             p.Env,
+		OutDir:          p.OutDir,
+		Reproducible:    p.Reproducible,
+		Network:         p.Network,
+		Timeout:         time.Duration(p.Timeout) * time.Second,
+		Index:           p.Index,
+		SourceSize:      int64(len(source)),
+	}
+	opts.Normalize()
+	limits := buildLimits(compiler)
+	opts.ApplyAutoShell(limits)
+	opts.ApplyTimeout(limits)
+	if err := opts.Validate(limits); err != nil {
+		return nil, rpcErrorf(rpcInvalidParams, "%v", err)
+	}
+
+	b, err := startBuild(store, compiler, bytes.NewReader(source), build.BuildOptions{
+		MainFile:        opts.MainFile,
+		Engine:          build.Engine(opts.Engine),
+		ShellEscape:     opts.ShellEscape,
+		ShellRestricted: opts.ShellRestricted,
+		AutoShell:       opts.AutoShell,
+		Env:             opts.Env,
+		OutDir:          opts.OutDir,
+		Reproducible:    opts.Reproducible,
+		Network:         opts.Network,
+		Timeout:         int(opts.Timeout / time.Second),
+		Index:           opts.Index,
+	})
+	if err != nil {
+		return nil, rpcErrorf(rpcInternalError, "%s", err.Error())
+	}
+	return map[string]string{"id": b.ID, "status": string(b.Status)}, nil
+}
+
+func ideGetBuild(store *storage.Store, id string) (*build.Build, error) {
+	if id == "" {
+		return nil, rpcErrorf(rpcInvalidParams, "id required")
+	}
+	b, err := store.Get(id)
+	if err != nil {
+		return nil, rpcErrorf(rpcBuildNotFound, "Build not found")
+	}
+	return b, nil
+}
+
+func ideSyncTeX(store *storage.Store, id string) (*synctex.SyncTeXData, error) {
+	b, err := ideGetBuild(store, id)
+	if err != nil {
+		return nil, err
+	}
+	if b.SyncTeXPath == "" {
+		return nil, rpcErrorf(rpcNotAvailable, "SyncTeX not available for this build")
+	}
+	data, err := synctex.GetCachedSyncTeX(b.SyncTeXPath)
+	if err != nil {
+		ideLog.WithError(err).WithField("build_id", id).Error("Failed to parse synctex file")
+		return nil, rpcErrorf(rpcInternalError, "Failed to parse SyncTeX data")
+	}
+	return data, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/synctex"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var layoutLog = logrus.WithField("component", "handlers/layout")
+
+// LayoutReportHandler reports on the page layout of a finished build:
+// overfull and underfull boxes, float placement warnings, and floats that
+// landed more than ?drift= pages (default build.DefaultFloatDrift) from
+// their first reference, placed with the build's SyncTeX data
+func LayoutReportHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildID := chi.URLParam(r, "id")
+		if buildID == "" {
+			http.Error(w, "Build ID required", http.StatusBadRequest)
+			return
+		}
+
+		drift := build.DefaultFloatDrift
+		if s := r.URL.Query().Get("drift"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "Invalid drift (must be >= 0)", http.StatusBadRequest)
+				return
+			}
+			drift = n
+		}
+
+		b, err := store.Get(buildID)
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+		if !b.Status.Terminal() {
+			http.Error(w, "Build has not finished", http.StatusConflict)
+			return
+		}
+
+		var pageOf build.PageLocator
+		if b.SyncTeXPath != "" {
+			if data, err := synctex.GetCachedSyncTeX(b.SyncTeXPath); err == nil {
+				pageOf = func(file string, line int) int {
+					if result, err := data.ForwardSearch(file, line, 0); err == nil {
+						return result.Page
+					}
+					return 0
+				}
+			} else {
+				layoutLog.WithError(err).WithField("build_id", buildID).Warn("Failed to parse synctex file; float drift left out")
+			}
+		}
+
+		report, err := build.NewLayoutReport(b, drift, pageOf)
+		if err != nil {
+			layoutLog.WithError(err).WithField("build_id", buildID).Error("Failed to build layout report")
+			http.Error(w, "Failed to build layout report", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
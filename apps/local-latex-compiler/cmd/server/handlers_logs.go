@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/logfile"
+)
+
+const (
+	defaultLogLines = 500
+	maxLogLines     = 10000
+)
+
+// ServerLogHandler serves the most recent lines of the server's own log as
+// JSON lines, for the desktop app's diagnostics panel. ?lines= sets how many.
+func ServerLogHandler(logs *logfile.Writer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if logs == nil {
+			http.Error(w, "Server log file is disabled", http.StatusNotFound)
+			return
+		}
+
+		lines := defaultLogLines
+		if v := r.URL.Query().Get("lines"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "lines must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			lines = min(n, maxLogLines)
+		}
+
+		data, err := logs.Tail(lines)
+		if err != nil {
+			logger.WithError(err).Error("Failed to read server log")
+			http.Error(w, "Failed to read server log", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/synctex"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var problemsLog = logrus.WithField("component", "handlers/problems")
+
+type problemsResult struct {
+	ID       string             `json:"id"`
+	Status   build.Status       `json:"status"`
+	Problems []build.Problem    `json:"problems"`
+	Warnings []api.BuildWarning `json:"warnings"`
+}
+
+// ProblemsHandler returns the errors and warnings of a build's log. With
+// ?withPositions=true each problem with a source line also gets the page
+// and point where that line was typeset, from the build's SyncTeX data, so
+// the preview can mark it; problems SyncTeX cannot place are left without.
+func ProblemsHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildID := chi.URLParam(r, "id")
+		if buildID == "" {
+			http.Error(w, "Build ID required", http.StatusBadRequest)
+			return
+		}
+
+		withPositions := false
+		if s := r.URL.Query().Get("withPositions"); s != "" {
+			var err error
+			if withPositions, err = strconv.ParseBool(s); err != nil {
+				http.Error(w, "Invalid withPositions (must be true or false)", http.StatusBadRequest)
+				return
+			}
+		}
+
+		b, err := store.Get(buildID)
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+
+		result := buildProblems(b)
+		if withPositions && b.SyncTeXPath != "" {
+			if data, err := synctex.GetCachedSyncTeX(b.SyncTeXPath); err == nil {
+				build.LocateProblems(result.Problems, func(file string, line int) *build.Position {
+					v, err := data.ForwardSearch(file, line, 0)
+					if err != nil {
+						return nil
+					}
+					return &build.Position{Page: v.Page, X: v.X, Y: v.Y, Exact: v.Line == line}
+				})
+			} else {
+				problemsLog.WithError(err).WithField("build_id", buildID).Warn("Failed to parse synctex file; positions left out")
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// buildProblems lists the problems of b's log; a failed build whose log
+// shows none gets its error message as the one problem. Warnings are only
+// summarized for completed builds.
+func buildProblems(b *build.Build) problemsResult {
+	problems := build.ParseProblems(b.BuildLog)
+	if len(problems) == 0 && b.Status == build.StatusFailed && b.ErrorMessage != "" {
+		problems = append(problems, build.Problem{Severity: build.SeverityError, Message: b.ErrorMessage})
+	}
+	warnings := []api.BuildWarning{}
+	if b.Status == build.StatusCompleted {
+		warnings = build.ParseBuildWarnings(b.BuildLog)
+	}
+	return problemsResult{ID: b.ID, Status: b.Status, Problems: problems, Warnings: warnings}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/sirupsen/logrus"
+)
+
+var projectLog = logrus.WithField("component", "handlers/project")
+
+type unusedFilesResponse struct {
+	Roots     []string              `json:"roots"`
+	Files     []buildopts.GraphNode `json:"files"`
+	TotalSize int64                 `json:"total_size"`
+}
+
+type trashUnusedRequest struct {
+	Path  string   `json:"path"`
+	Main  []string `json:"main"`
+	Files []string `json:"files"`
+}
+
+type trashUnusedResponse struct {
+	Trash string   `json:"trash"` // relative to the project
+	Moved []string `json:"moved"`
+	Size  int64    `json:"size"`
+}
+
+// ProjectGraphHandler returns the include graph of the project at ?path=:
+// its files with their sizes, and what each LaTeX file inputs, includes,
+// loads or cites. The graph starts from each ?main= given, else from the
+// project's main files.
+func ProjectGraphHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := projectDir(w, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		graph, ok := projectGraph(w, dir, r.URL.Query()["main"])
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(graph)
+	}
+}
+
+// UnusedFilesHandler lists the files of the project at ?path= that no main
+// file uses, such as old chapters and figures, with their total size. Like
+// the graph it starts from each ?main= or the project's main files.
+func UnusedFilesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := projectDir(w, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		graph, ok := projectGraph(w, dir, r.URL.Query()["main"])
+		if !ok {
+			return
+		}
+
+		resp := unusedFilesResponse{Roots: graph.Roots, Files: graph.Orphans()}
+		for _, f := range resp.Files {
+			resp.TotalSize += f.Size
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// TrashUnusedHandler moves the listed unused files of the project at path
+// into its trash directory, so they stop being uploaded with it. Files still
+// in use are refused with 409.
+func TrashUnusedHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req trashUnusedRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(req.Files) == 0 {
+			http.Error(w, "files required", http.StatusBadRequest)
+			return
+		}
+		dir, ok := projectDir(w, req.Path)
+		if !ok {
+			return
+		}
+		graph, ok := projectGraph(w, dir, req.Main)
+		if !ok {
+			return
+		}
+		// Without its main files every file of a project looks unused
+		if len(graph.Roots) == 0 {
+			http.Error(w, "project has no main file", http.StatusUnprocessableEntity)
+			return
+		}
+		for _, n := range graph.Nodes {
+			if n.Root && n.Missing {
+				http.Error(w, fmt.Sprintf("main file %s not found", n.Path), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		unused := make(map[string]int64)
+		for _, f := range graph.Orphans() {
+			unused[f.Path] = f.Size
+		}
+		files := req.Files
+		for _, f := range files {
+			if _, ok := unused[f]; !ok {
+				http.Error(w, fmt.Sprintf("%s is not an unused file of the project", f), http.StatusConflict)
+				return
+			}
+		}
+
+		trash, moved, err := buildopts.MoveToTrash(dir, files)
+		if err != nil {
+			projectLog.WithError(err).WithField("path", dir).WithField("moved", len(moved)).Error("Failed to move unused files to trash")
+			http.Error(w, fmt.Sprintf("Failed to move unused files to trash after %d of %d: %v", len(moved), len(files), err), http.StatusInternalServerError)
+			return
+		}
+		resp := trashUnusedResponse{Trash: trash, Moved: moved}
+		for _, f := range resp.Moved {
+			resp.Size += unused[f]
+		}
+		projectLog.WithField("path", dir).WithField("files", len(resp.Moved)).Info("Moved unused files to trash")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// ReferenceCheckHandler cross-checks the labels and citations of the
+// project at ?path= without compiling it: references to undefined labels,
+// citations of undefined keys, keys defined twice, and labels and bib
+// entries never used. Only files the main files (?main= or the project's)
+// use are checked.
+func ReferenceCheckHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dir, ok := projectDir(w, r.URL.Query().Get("path"))
+		if !ok {
+			return
+		}
+		graph, ok := projectGraph(w, dir, r.URL.Query()["main"])
+		if !ok {
+			return
+		}
+
+		report, err := buildopts.CheckReferences(dir, graph)
+		if err != nil {
+			projectLog.WithError(err).WithField("path", dir).Error("Failed to check references")
+			http.Error(w, "Failed to check references", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// projectGraph loads the graph of the project in dir from mains, writing
+// the error response if it cannot
+func projectGraph(w http.ResponseWriter, dir string, mains []string) (*buildopts.ProjectGraph, bool) {
+	for _, main := range mains {
+		if err := buildopts.ValidateMainFile(main); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	graph, err := buildopts.LoadGraph(dir, mains)
+	if err != nil {
+		projectLog.WithError(err).WithField("path", dir).Error("Failed to read project graph")
+		http.Error(w, "Failed to read project graph: "+err.Error(), http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	return graph, true
+}
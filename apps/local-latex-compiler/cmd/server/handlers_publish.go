@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/publish"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/vcs"
+	"github.com/sirupsen/logrus"
+)
+
+var publishLog = logrus.WithField("component", "handlers/publish")
+
+const publishTimeout = 3 * time.Minute
+
+type githubReleaseRequest struct {
+	Path       string `json:"path"`
+	Build      string `json:"build"`  // build whose PDF is attached
+	Remote     string `json:"remote"` // origin if empty
+	Tag        string `json:"tag"`    // paper-<date>-<commit> if empty
+	Name       string `json:"name"`
+	Notes      string `json:"notes"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+type githubReleaseResponse struct {
+	Tag        string `json:"tag"`
+	Commit     string `json:"commit"`
+	Repository string `json:"repository"` // owner/name
+	URL        string `json:"url"`
+	Asset      string `json:"asset"`     // file name of the PDF, named like exported PDFs
+	AssetURL   string `json:"asset_url"` // where collaborators download it
+	Build      string `json:"build"`
+}
+
+// GitHubReleaseHandler tags the commit checked out in the project at path
+// with a GitHub release of the repository its remote points to, and attaches
+// the PDF of build to it. The build is required: the latest completed build
+// may be of another project. The commit must have been pushed; the release
+// is created with GITHUB_TOKEN.
+func GitHubReleaseHandler(store *storage.Store, gh *publish.GitHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req githubReleaseRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if gh.Token == "" {
+			http.Error(w, "GitHub publishing is not configured (set GITHUB_TOKEN)", http.StatusServiceUnavailable)
+			return
+		}
+		if req.Build == "" {
+			http.Error(w, "Missing build", http.StatusBadRequest)
+			return
+		}
+		dir, ok := projectDir(w, req.Path)
+		if !ok {
+			return
+		}
+		if vcs.RepoRoot(dir) == "" {
+			http.Error(w, "project is not in a git repository", http.StatusNotFound)
+			return
+		}
+
+		b, err := store.Get(req.Build)
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+		if b.PDFPath == "" {
+			http.Error(w, "PDF not available", http.StatusNotFound)
+			return
+		}
+		if _, err := os.Stat(b.PDFPath); os.IsNotExist(err) {
+			http.Error(w, "PDF file not found", http.StatusNotFound)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), publishTimeout)
+		defer cancel()
+		// Uploads outlast the server's write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(publishTimeout + 10*time.Second))
+
+		remote := strings.TrimSpace(req.Remote)
+		if remote == "" {
+			remote = vcs.DefaultRemote
+		}
+		remoteURL, err := vcs.RemoteURL(ctx, dir, remote)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		owner, repo, err := vcs.GitHubRepo(remoteURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		commit, err := vcs.Head(ctx, dir)
+		if err != nil {
+			http.Error(w, "project has no commits", http.StatusUnprocessableEntity)
+			return
+		}
+		if pushed, err := vcs.Pushed(ctx, dir, remote, commit); err == nil && !pushed {
+			http.Error(w, "the current commit is not on "+remote+"; push it first", http.StatusConflict)
+			return
+		}
+
+		tag := strings.TrimSpace(req.Tag)
+		if tag == "" {
+			tag = "paper-" + time.Now().UTC().Format("2006-01-02") + "-" + vcs.ShortHash(commit)
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = tag
+		}
+		asset, err := exportFileName(ctx, "", b, dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		log := publishLog.WithFields(logrus.Fields{
+			"repository": owner + "/" + repo,
+			"tag":        tag,
+			"commit":     commit,
+			"build_id":   b.ID,
+		})
+		rel, err := gh.CreateRelease(ctx, owner, repo, publish.Release{
+			Tag:        tag,
+			Commit:     commit,
+			Name:       name,
+			Notes:      req.Notes,
+			Draft:      req.Draft,
+			Prerelease: req.Prerelease,
+		})
+		if err != nil {
+			log.WithError(err).Error("Failed to create release")
+			writeGitHubError(w, err)
+			return
+		}
+		uploaded, err := gh.UploadAsset(ctx, rel, asset, b.PDFPath, "application/pdf")
+		if err != nil {
+			// The release stays, without the PDF, for the user to fix on GitHub
+			log.WithError(err).WithField("release", rel.HTMLURL).Error("Failed to upload PDF")
+			writeGitHubError(w, err)
+			return
+		}
+
+		// Match the tag GitHub made so the local repository has it without
+		// a fetch; one that already exists is left alone
+		if err := vcs.Tag(ctx, dir, tag, commit); err != nil {
+			log.WithError(err).Debug("Local tag not created")
+		}
+		log.WithField("release", rel.HTMLURL).Info("Published GitHub release")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(githubReleaseResponse{
+			Tag:        tag,
+			Commit:     commit,
+			Repository: owner + "/" + repo,
+			URL:        rel.HTMLURL,
+			Asset:      uploaded.Name,
+			AssetURL:   uploaded.DownloadURL,
+			Build:      b.ID,
+		})
+	}
+}
+
+// writeGitHubError passes GitHub's rejections, e.g. of a tag that exists,
+// on to the client, and reports anything else as a bad gateway
+func writeGitHubError(w http.ResponseWriter, err error) {
+	var ghErr *publish.Error
+	if errors.As(err, &ghErr) {
+		switch ghErr.StatusCode {
+		case http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity:
+			http.Error(w, ghErr.Error(), ghErr.StatusCode)
+			return
+		}
+	}
+	http.Error(w, "Failed to publish to GitHub: "+err.Error(), http.StatusBadGateway)
+}
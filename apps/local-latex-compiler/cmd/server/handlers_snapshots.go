@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/snapshot"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var snapshotLog = logrus.WithField("component", "handlers/snapshots")
+
+// CreateSnapshotHandler snapshots the uploaded project zip (file) under
+// project, with an optional label
+func CreateSnapshotHandler(snapshots *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !parseUploadForm(w, r) {
+			return
+		}
+		file, fileHeader, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		snap, err := snapshots.Create(r.FormValue("project"), r.FormValue("label"), file, fileHeader.Size)
+		if err != nil {
+			http.Error(w, err.Error(), snapshotErrorStatus(err))
+			return
+		}
+		snapshotLog.WithFields(logrus.Fields{
+			"snapshot_id": snap.ID,
+			"project":     snap.Project,
+			"files":       snap.FileCount,
+		}).Info("Snapshot created")
+
+		snap.Files = nil
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(snap)
+	}
+}
+
+// ListSnapshotsHandler lists the snapshots of ?project=, newest first
+func ListSnapshotsHandler(snapshots *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snaps, err := snapshots.List(r.URL.Query().Get("project"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snaps)
+	}
+}
+
+// GetSnapshotHandler describes a snapshot and its files
+func GetSnapshotHandler(snapshots *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, ok := getSnapshot(w, snapshots, chi.URLParam(r, "id"))
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snap)
+	}
+}
+
+// RestoreSnapshotHandler returns the files of a snapshot as a zip for the
+// client to write back into the project
+func RestoreSnapshotHandler(snapshots *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap, ok := getSnapshot(w, snapshots, chi.URLParam(r, "id"))
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", snap.ID+".zip"))
+		if err := snapshots.WriteZip(snap, w); err != nil {
+			// Headers are sent; the client sees a truncated archive
+			snapshotLog.WithError(err).WithField("snapshot_id", snap.ID).Error("Failed to restore snapshot")
+		}
+	}
+}
+
+// DiffSnapshotHandler compares a snapshot with the snapshot ?to= (GET) or
+// with the uploaded project zip (POST, file)
+func DiffSnapshotHandler(snapshots *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, ok := getSnapshot(w, snapshots, chi.URLParam(r, "id"))
+		if !ok {
+			return
+		}
+
+		var diff *snapshot.Diff
+		var err error
+		if r.Method == http.MethodPost {
+			if !parseUploadForm(w, r) {
+				return
+			}
+			file, fileHeader, ferr := r.FormFile("file")
+			if ferr != nil {
+				http.Error(w, "No file uploaded", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			if diff, err = snapshots.DiffZip(from, file, fileHeader.Size); err != nil {
+				http.Error(w, err.Error(), snapshotErrorStatus(err))
+				return
+			}
+		} else {
+			toID := r.URL.Query().Get("to")
+			if toID == "" {
+				http.Error(w, "to required", http.StatusBadRequest)
+				return
+			}
+			to, ok := getSnapshot(w, snapshots, toID)
+			if !ok {
+				return
+			}
+			if diff, err = snapshots.Diff(from, to); err != nil {
+				snapshotLog.WithError(err).WithField("snapshot_id", from.ID).Error("Failed to diff snapshots")
+				http.Error(w, "Failed to diff snapshots", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
+	}
+}
+
+// DeleteSnapshotHandler deletes a snapshot
+func DeleteSnapshotHandler(snapshots *snapshot.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := snapshots.Delete(chi.URLParam(r, "id"))
+		if errors.Is(err, snapshot.ErrNotFound) {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			snapshotLog.WithError(err).Error("Failed to delete snapshot")
+			http.Error(w, "Failed to delete snapshot", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getSnapshot looks up a snapshot, writing the error response if it fails
+func getSnapshot(w http.ResponseWriter, snapshots *snapshot.Store, id string) (*snapshot.Snapshot, bool) {
+	snap, err := snapshots.Get(id)
+	if errors.Is(err, snapshot.ErrNotFound) {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		snapshotLog.WithError(err).WithField("snapshot_id", id).Error("Failed to read snapshot")
+		http.Error(w, "Failed to read snapshot", http.StatusInternalServerError)
+		return nil, false
+	}
+	return snap, true
+}
+
+// snapshotErrorStatus is the status of a project upload the store refused
+func snapshotErrorStatus(err error) int {
+	if errors.Is(err, snapshot.ErrTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/export"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/synctex"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var splitLog = logrus.WithField("component", "handlers/split")
+
+type splitResponse struct {
+	ID    string          `json:"id"`
+	Pages int             `json:"pages"`
+	Parts []api.SplitPart `json:"parts"`
+}
+
+// splitBuild splits the PDF of a completed build made with split=true into
+// a PDF per part, chapter or section, placing the headings of its sources
+// on pages with its SyncTeX data. The full PDF stays as it is; a failed
+// split is recorded in SplitError.
+//
+// The parts are written to a directory next to the build directory and
+// renamed into it, so nothing the build left in its directory is written
+// through; a build that already has a split entry is not split.
+func splitBuild(b *build.Build) {
+	if b.Status != build.StatusCompleted || b.PDFPath == "" {
+		return
+	}
+	fail := func(err error) {
+		b.SplitError = err.Error()
+		splitLog.WithError(err).WithField("build_id", b.ID).Warn("PDF split failed")
+	}
+	if b.SyncTeXPath == "" {
+		fail(fmt.Errorf("SyncTeX not available for this build"))
+		return
+	}
+	if err := export.Available(export.Options{}); err != nil {
+		fail(err)
+		return
+	}
+	data, err := synctex.GetCachedSyncTeX(b.SyncTeXPath)
+	if err != nil {
+		fail(fmt.Errorf("failed to parse SyncTeX data: %w", err))
+		return
+	}
+	headings, err := build.ReadHeadings(b.DirPath, build.OutDir(b))
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	pageCount := 0
+	for page := range data.Pages {
+		pageCount = max(pageCount, page)
+	}
+	parts := build.SplitRanges(headings, "", func(file string, line int) int {
+		if result, err := data.ForwardSearch(file, line, 0); err == nil {
+			return result.Page
+		}
+		return 0
+	}, pageCount)
+
+	if info, err := os.Lstat(b.PDFPath); err != nil || !info.Mode().IsRegular() {
+		fail(fmt.Errorf("PDF is not a regular file"))
+		return
+	}
+	splitDir := filepath.Join(b.DirPath, build.SplitDir)
+	if _, err := os.Lstat(splitDir); !os.IsNotExist(err) {
+		fail(fmt.Errorf("build directory already has a %s entry", build.SplitDir))
+		return
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(b.DirPath), "split-*")
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.Chmod(tmp, 0755); err != nil {
+		fail(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	for _, part := range parts {
+		pages := fmt.Sprintf("%d-%d", part.FirstPage, part.LastPage)
+		if part.Index == len(parts) {
+			// SyncTeX knows no pages without text, so the last part runs
+			// to the end
+			pages = fmt.Sprintf("%d-", part.FirstPage)
+		}
+		if err := export.Transform(ctx, b.PDFPath, filepath.Join(tmp, build.SplitPartName(part.Index)), export.Options{Pages: pages}); err != nil {
+			fail(err)
+			return
+		}
+	}
+	// An entry the build created since the check makes the rename fail,
+	// unless it is an empty directory, which is harmless to replace
+	if err := os.Rename(tmp, splitDir); err != nil {
+		fail(err)
+		return
+	}
+	b.SplitParts = parts
+}
+
+// SplitManifestHandler lists the parts of the split PDF of a build made
+// with split=true, with their headings and page ranges in the full PDF
+func SplitManifestHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := splitBuildOf(w, r, store)
+		if !ok {
+			return
+		}
+		resp := splitResponse{ID: b.ID, Parts: b.SplitParts}
+		if n := len(b.SplitParts); n > 0 {
+			resp.Pages = b.SplitParts[n-1].LastPage
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// ServeSplitPartHandler serves part {part}, counted from 1, of the split
+// PDF of a build
+func ServeSplitPartHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, ok := splitBuildOf(w, r, store)
+		if !ok {
+			return
+		}
+		index, err := strconv.Atoi(chi.URLParam(r, "part"))
+		if err != nil || index < 1 || index > len(b.SplitParts) {
+			http.Error(w, fmt.Sprintf("Invalid part (must be 1 to %d)", len(b.SplitParts)), http.StatusNotFound)
+			return
+		}
+		partPath := build.SplitPartPath(b, index)
+		if _, err := os.Stat(partPath); os.IsNotExist(err) {
+			http.Error(w, "Part file not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%s-part-%d.pdf", b.ID, index))
+		serveBuildFile(w, r, b, partPath)
+	}
+}
+
+// splitBuildOf returns the build of the request if its PDF was split,
+// writing the error response if not
+func splitBuildOf(w http.ResponseWriter, r *http.Request, store *storage.Store) (*build.Build, bool) {
+	buildID := chi.URLParam(r, "id")
+	if buildID == "" {
+		http.Error(w, "Build ID required", http.StatusBadRequest)
+		return nil, false
+	}
+	b, err := store.Get(buildID)
+	if err != nil {
+		http.Error(w, "Build not found", http.StatusNotFound)
+		return nil, false
+	}
+	if len(b.SplitParts) == 0 {
+		msg := "Split PDF not available"
+		if b.SplitError != "" {
+			msg += ": " + b.SplitError
+		} else if !b.Split {
+			msg += " (build with split=true)"
+		}
+		http.Error(w, msg, http.StatusNotFound)
+		return nil, false
+	}
+	return b, true
+}
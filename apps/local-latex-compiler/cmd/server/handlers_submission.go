@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/preflight"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/sirupsen/logrus"
+)
+
+var submissionLog = logrus.WithField("component", "handlers/submission")
+
+const preflightTimeout = 60 * time.Second
+
+// SubmissionCheckHandler checks a build's PDF against the venue profile in
+// ?profile= before it is submitted: page count, page size, margins,
+// embedded fonts and, for anonymous submissions, what may name the authors.
+// ?max_pages=, ?max_content_pages= and ?anonymous= override the profile and
+// each ?forbid= is a string, such as an author's name, the PDF must not
+// contain. Without ?build= the latest completed build, of ?target= if
+// given, is checked.
+func SubmissionCheckHandler(store *storage.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		profile, ok := preflight.LookupProfile(q.Get("profile"))
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown profile %q (one of %s)", q.Get("profile"), strings.Join(preflight.ProfileNames(), ", ")), http.StatusBadRequest)
+			return
+		}
+
+		opts := preflight.Options{Forbidden: q["forbid"]}
+		for name, limit := range map[string]*int{"max_pages": &opts.MaxPages, "max_content_pages": &opts.MaxContentPages} {
+			if s := q.Get(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 1 {
+					http.Error(w, fmt.Sprintf("Invalid %s (must be >= 1)", name), http.StatusBadRequest)
+					return
+				}
+				*limit = n
+			}
+		}
+		if s := q.Get("anonymous"); s != "" {
+			anonymous, err := strconv.ParseBool(s)
+			if err != nil {
+				http.Error(w, "Invalid anonymous (must be true or false)", http.StatusBadRequest)
+				return
+			}
+			opts.Anonymous = &anonymous
+		}
+
+		var b *build.Build
+		var err error
+		switch {
+		case q.Get("build") != "":
+			b, err = store.Get(q.Get("build"))
+		case q.Get("target") != "":
+			b, err = store.LatestCompletedTarget("", q.Get("target"))
+		default:
+			b, err = store.LatestCompleted()
+		}
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+		if b.PDFPath == "" {
+			http.Error(w, "PDF not available", http.StatusNotFound)
+			return
+		}
+		if _, err := os.Stat(b.PDFPath); os.IsNotExist(err) {
+			http.Error(w, "PDF file not found", http.StatusNotFound)
+			return
+		}
+
+		if err := preflight.Available(); err != nil {
+			http.Error(w, fmt.Sprintf("Submission checks unavailable: %v", err), http.StatusNotImplemented)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), preflightTimeout)
+		defer cancel()
+
+		report, err := preflight.Check(ctx, b.PDFPath, profile, opts)
+		if err != nil {
+			submissionLog.WithError(err).WithFields(logrus.Fields{
+				"build_id": b.ID,
+				"profile":  profile.Name,
+			}).Error("Submission check failed")
+			http.Error(w, "Failed to check PDF", http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
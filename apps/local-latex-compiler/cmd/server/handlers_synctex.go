@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/editors"
 	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
 	"github.com/alpha-og/treefrog/packages/go/synctex"
 	"github.com/go-chi/chi/v5"
@@ -79,7 +80,9 @@ func SyncTeXViewHandler(store *storage.Store) http.HandlerFunc {
 	}
 }
 
-func SyncTeXEditHandler(store *storage.Store) http.HandlerFunc {
+// SyncTeXEditHandler answers a reverse search and pushes the result to
+// registered editors
+func SyncTeXEditHandler(store *storage.Store, registry *editors.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		buildID := chi.URLParam(r, "id")
 		if buildID == "" {
@@ -142,6 +145,8 @@ func SyncTeXEditHandler(store *storage.Store) http.HandlerFunc {
 			return
 		}
 
+		notifyEditors(registry, b.ID, result)
+
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(fmt.Sprintf(`{"file":"%s","line":%d,"col":%d}`, result.File, result.Line, result.Col)))
 	}
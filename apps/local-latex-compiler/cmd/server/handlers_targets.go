@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/sirupsen/logrus"
+)
+
+var targetsLog = logrus.WithField("component", "handlers/targets")
+
+// TargetResult is the outcome of building one target
+type TargetResult struct {
+	Target     string       `json:"target"`
+	BuildID    string       `json:"build_id,omitempty"`
+	MainFile   string       `json:"main_file"`
+	Status     build.Status `json:"status"`
+	DurationMs int64        `json:"duration_ms"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// BuildAllResponse reports a build of every target: completed if all of
+// them compiled, failed otherwise
+type BuildAllResponse struct {
+	Status     build.Status   `json:"status"`
+	DurationMs int64          `json:"duration_ms"`
+	Workers    int            `json:"workers"`
+	Targets    []TargetResult `json:"targets"`
+}
+
+// BuildAllHandler builds every target of the uploaded project's
+// .treefrog.json, at most workers at once, and answers once all are done.
+// The form's other options, e.g. shell_escape and env, apply to every target.
+func BuildAllHandler(store *storage.Store, compiler *build.DockerCompiler, workers int) http.HandlerFunc {
+	workers = max(workers, 1)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !parseUploadForm(w, r) {
+			return
+		}
+
+		file, size, err := formSource(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		base, err := formOptions(r, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		config, err := build.ProjectConfigFromZip(file, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(config.Targets) == 0 {
+			http.Error(w, "the project declares no targets in "+buildopts.ProjectConfigName, http.StatusBadRequest)
+			return
+		}
+
+		limits := buildLimits(compiler)
+		targets := make([]buildopts.Options, len(config.Targets))
+		for i, t := range config.Targets {
+			opts := base
+			t.Apply(&opts)
+			opts.Normalize()
+			opts.ApplyAutoShell(limits)
+			opts.ApplyTimeout(limits)
+			if err := opts.Validate(limits); err != nil {
+				http.Error(w, fmt.Sprintf("target %s: %v", t.Name, err), http.StatusBadRequest)
+				return
+			}
+			targets[i] = opts
+		}
+
+		// Every target may take the build timeout, so the answer can come long
+		// after the server's write timeout
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+		resp := BuildAllResponse{
+			Status:  build.StatusCompleted,
+			Workers: min(workers, len(targets)),
+			Targets: make([]TargetResult, len(targets)),
+		}
+		start := time.Now()
+		slots := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for i, opts := range targets {
+			name := config.Targets[i].Name
+			result := &resp.Targets[i]
+			*result = TargetResult{Target: name, MainFile: opts.MainFile, Status: build.StatusFailed}
+
+			b, err := createBuild(store, io.NewSectionReader(file, 0, size), buildOptions(opts, config.Key, name))
+			if err != nil {
+				result.Error = err.Error()
+				continue
+			}
+			result.BuildID = b.ID
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				slots <- struct{}{}
+				defer func() { <-slots }()
+
+				began := time.Now()
+				beginBuild(store, b)
+				compileBuild(store, compiler, b)
+				result.DurationMs = time.Since(began).Milliseconds()
+				result.Status = b.Status
+				result.Error = b.ErrorMessage
+			}()
+		}
+		wg.Wait()
+		resp.DurationMs = time.Since(start).Milliseconds()
+
+		for _, result := range resp.Targets {
+			if result.Status != build.StatusCompleted {
+				resp.Status = build.StatusFailed
+			}
+		}
+		targetsLog.WithFields(logrus.Fields{
+			"targets":     len(resp.Targets),
+			"status":      resp.Status,
+			"duration_ms": resp.DurationMs,
+		}).Info("Built all targets")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
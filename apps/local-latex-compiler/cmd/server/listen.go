@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/config"
+)
+
+// pipePrefix marks a Windows named pipe in SERVER_SOCKET
+const pipePrefix = `\\.\pipe\`
+
+// listen opens the server's listener: the socket or named pipe in
+// cfg.Socket if set, otherwise the TCP port on cfg.Host. A socket is only reachable by
+// the user running the server, which keeps other local users and browsers
+// away from the compiler and avoids clashing with whatever owns the port.
+func listen(cfg config.ServerConfig) (net.Listener, error) {
+	if cfg.Socket == "" {
+		return net.Listen("tcp", net.JoinHostPort(cfg.Host, cfg.Port))
+	}
+	if strings.HasPrefix(cfg.Socket, pipePrefix) {
+		return listenPipe(cfg.Socket)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.Socket), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	// A socket left behind by a server that did not shut down cleanly would
+	// make Listen fail with "address already in use"
+	if info, err := os.Lstat(cfg.Socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(cfg.Socket)
+	}
+
+	ln, err := net.Listen("unix", cfg.Socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(cfg.Socket, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	return ln, nil
+}
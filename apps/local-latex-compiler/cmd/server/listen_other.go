@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func listenPipe(name string) (net.Listener, error) {
+	return nil, fmt.Errorf("named pipes are only supported on Windows: %s", name)
+}
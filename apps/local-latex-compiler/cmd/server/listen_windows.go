@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// pipeSecurity grants access to the pipe's owner only
+const pipeSecurity = "D:P(A;;GA;;;OW)"
+
+func listenPipe(name string) (net.Listener, error) {
+	return winio.ListenPipe(name, &winio.PipeConfig{SecurityDescriptor: pipeSecurity})
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,8 +11,16 @@ import (
 
 	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/cleanup"
 	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/config"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/editors"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/logfile"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/publish"
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/snapshot"
 	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/storage"
+	"github.com/alpha-og/treefrog/packages/go/api"
 	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/events"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
+	"github.com/alpha-og/treefrog/packages/go/security"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -19,15 +29,50 @@ import (
 
 var logger = logrus.New()
 
+// buildEvents carries build lifecycle events to any transport that streams
+// them to clients
+var buildEvents = events.NewBus()
+
+// pdfxProfile is the CMYK ICC profile PDF/X conversions use; "" for
+// ghostscript's default
+var pdfxProfile string
+
+// sourceKey decrypts sources uploaded with encrypted=true; nil refuses them
+var sourceKey []byte
+
+// logRedactor masks secrets in build logs; nil keeps logs as printed
+var logRedactor *build.Redactor
+
+// autoShellEscape lets minted documents compile with the restricted
+// shell-escape profile without asking for it
+var autoShellEscape bool
+
 func main() {
 	cfg := config.Load()
 
-	logger.SetLevel(logrus.InfoLevel)
+	level, err := logrus.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		level = logrus.InfoLevel
+	}
+	logger.SetLevel(level)
 	logger.SetFormatter(&logrus.JSONFormatter{})
 
+	var logFile *logfile.Writer
+	if cfg.Log.Dir != "" {
+		logFile, err = logfile.Open(cfg.Log.Dir, "server", cfg.Log.MaxSize, cfg.Log.MaxFiles)
+		if err != nil {
+			logger.WithError(err).Warn("Logging to stdout only")
+		} else {
+			defer logFile.Close()
+			logger.SetOutput(io.MultiWriter(os.Stdout, logFile))
+		}
+	}
+
 	logger.WithFields(logrus.Fields{
-		"port":    cfg.Server.Port,
-		"workDir": cfg.Build.WorkDir,
+		"port":        cfg.Server.Port,
+		"workDir":     cfg.Build.WorkDir,
+		"logDir":      cfg.Log.Dir,
+		"snapshotDir": cfg.Snapshot.Dir,
 	}).Info("Local LaTeX Compiler starting")
 
 	store, err := storage.NewStore(cfg.Build.WorkDir)
@@ -35,11 +80,56 @@ func main() {
 		logger.WithError(err).Fatal("Failed to initialize storage")
 	}
 
-	compiler, err := build.NewDockerCompiler("treefrog-local-latex-compiler:latest", cfg.Build.WorkDir)
+	snapshots, err := snapshot.NewStore(cfg.Snapshot.Dir, cfg.Snapshot.MaxSize)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize snapshot storage")
+	}
+
+	compiler, err := build.NewDockerCompiler(cfg.Build.Image, cfg.Build.WorkDir)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to initialize Docker compiler")
 	}
 	defer compiler.Close()
+	if cfg.Build.ImageDigest != "" {
+		if err := compiler.PinDigest(cfg.Build.ImageDigest); err != nil {
+			logger.WithError(err).Fatal("Invalid COMPILER_IMAGE_DIGEST")
+		}
+		logger.WithField("digest", cfg.Build.ImageDigest).Info("Compiler image pinned")
+	}
+	profile := cfg.Build.Runtime.Profile()
+	if err := compiler.SetRuntimeProfile(profile); err != nil {
+		logger.WithError(err).Fatal("Invalid compiler runtime profile")
+	}
+	logger.WithFields(logrus.Fields{
+		"read_only": profile.ReadOnlyRootfs,
+		"user":      profile.User,
+		"seccomp":   profile.Seccomp,
+		"apparmor":  profile.AppArmor,
+	}).Info("Compiler runtime profile")
+	compiler.AllowNetwork(cfg.Build.AllowNetwork)
+	compiler.SetTimeouts(cfg.Build.Timeout, cfg.Build.MaxTimeout)
+	if logRedactor, err = build.LoadRedactor(cfg.Build.LogRedact, cfg.Build.LogRedactFile); err != nil {
+		logger.WithError(err).Fatal("Invalid build log redaction patterns")
+	}
+	compiler.SetRedactor(logRedactor)
+	autoShellEscape = cfg.Build.AutoShell
+	pdfxProfile = cfg.Build.PDFXProfile
+	if cfg.Build.SourceKey != "" {
+		if sourceKey, err = security.ParseSourceKey(cfg.Build.SourceKey); err != nil {
+			logger.WithError(err).Fatal("Invalid SOURCE_ENCRYPTION_KEY")
+		}
+		logger.Info("Encrypted source uploads enabled")
+	}
+	if cfg.Build.AllowNetwork {
+		logger.Warn("Builds may opt in to network access (COMPILER_ALLOW_NETWORK)")
+	}
+	if cfg.Build.PoolSize > 0 {
+		compiler.EnablePool(cfg.Build.PoolSize, cfg.Build.PoolMaxUses)
+		logger.WithFields(logrus.Fields{
+			"size":     cfg.Build.PoolSize,
+			"max_uses": cfg.Build.PoolMaxUses,
+		}).Info("Compile container pool enabled")
+	}
 
 	var cleanupEngine *cleanup.Engine
 	if cfg.Cleanup.Enabled {
@@ -48,32 +138,126 @@ func main() {
 		defer cleanupEngine.Stop()
 	}
 
+	// Every outgoing HTTP client dials with the configured network options
+	transport := cfg.Network.DialOptions().Transport()
+	editorRegistry := editors.NewRegistry(transport)
+
+	githubPublisher := publish.NewGitHub(cfg.Publish.GitHubAPI, cfg.Publish.GitHubToken)
+	githubPublisher.HTTPClient.Transport = transport
+
+	events.On(buildEvents, func(e events.BuildStatusChanged) {
+		logger.WithFields(logrus.Fields{
+			"build_id": e.BuildID,
+			"state":    e.State,
+		}).Info("Build status changed")
+	})
+
+	// Routes taking host paths need a session token, and are left out when
+	// other machines can reach the server
+	if hostLocal(cfg.Server) {
+		if sessionToken, err = loadSessionToken(cfg.Server); err != nil {
+			logger.WithError(err).Fatal("Failed to set up the session token")
+		}
+		if cfg.Server.Token == "" {
+			logger.WithField("file", cfg.Server.TokenFile).Info("Session token written")
+		}
+	} else {
+		logger.WithField("host", cfg.Server.Host).Warn("Listening beyond this machine; git and project routes are disabled")
+	}
+
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(requestLogger)
 	r.Use(middleware.Recoverer)
 	r.Use(cors.AllowAll().Handler)
 
 	r.Get("/health", HealthHandler())
-	r.Post("/api/build", CreateBuildHandler(store, compiler))
-	r.Get("/api/build/{id}", GetBuildHandler(store))
-	r.Get("/api/build/{id}/status", GetStatusHandler(store))
-	r.Get("/api/build/{id}/pdf", ServePDFHandler(store))
-	r.Get("/api/build/{id}/log", ServeLogHandler(store))
-	r.Get("/api/build/{id}/synctex", ServeSyncTeXHandler(store))
-	r.Get("/api/build/{id}/synctex/view", SyncTeXViewHandler(store))
-	r.Get("/api/build/{id}/synctex/edit", SyncTeXEditHandler(store))
-
-	srv := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      r,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  cfg.Server.IdleTimeout,
+
+	// Routes taking a project upload; other requests are capped at
+	// SERVER_MAX_BODY_SIZE by the server
+	uploadLimit := tfhttp.LimitBody(build.MaxFileSize + tfhttp.MultipartOverhead)
+	// Logs, the project graph and git status are compressed for clients
+	// sending Accept-Encoding
+	compress := middleware.Compress(tfhttp.CompressionLevel, tfhttp.CompressibleTypes...)
+
+	apiRoutes := func(r chi.Router) {
+		r.With(uploadLimit).Post("/build", CreateBuildHandler(store, compiler))
+		r.With(uploadLimit).Post("/build/all", BuildAllHandler(store, compiler, cfg.Build.Workers))
+		r.Get("/build/{id}", GetBuildHandler(store))
+		r.Get("/build/{id}/status", GetStatusHandler(store))
+		r.Get("/build/{id}/pdf", ServePDFHandler(store))
+		r.Get("/build/{id}/pdfx", ServePDFXHandler(store))
+		r.Get("/build/{id}/split", SplitManifestHandler(store))
+		r.Get("/build/{id}/split/{part}", ServeSplitPartHandler(store))
+		r.With(compress).Get("/build/{id}/log", ServeLogHandler(store))
+		r.Get("/build/{id}/layout-report", LayoutReportHandler(store))
+		r.Get("/build/{id}/problems", ProblemsHandler(store))
+		r.Get("/build/{id}/synctex", ServeSyncTeXHandler(store))
+		r.Get("/build/{id}/synctex/view", SyncTeXViewHandler(store))
+		r.Get("/build/{id}/synctex/edit", SyncTeXEditHandler(store, editorRegistry))
+		r.Get("/export/pdf", ExportPDFHandler(store))
+		r.With(uploadLimit).Post("/benchmark", BenchmarkHandler(store, compiler))
+		r.With(tfhttp.LimitBody(maxIDEBody)).Post("/ide", IDEHandler(store, compiler, editorRegistry))
+		r.Get("/ide/editors", ListEditorsHandler(editorRegistry))
+		r.Post("/ide/editors", RegisterEditorHandler(editorRegistry))
+		r.Delete("/ide/editors/{id}", UnregisterEditorHandler(editorRegistry))
+		r.With(compress).Get("/logs/server", ServerLogHandler(logFile))
+		r.With(uploadLimit).Post("/snapshots", CreateSnapshotHandler(snapshots))
+		r.With(compress).Get("/snapshots", ListSnapshotsHandler(snapshots))
+		r.Get("/snapshots/{id}", GetSnapshotHandler(snapshots))
+		r.Delete("/snapshots/{id}", DeleteSnapshotHandler(snapshots))
+		r.Post("/snapshots/{id}/restore", RestoreSnapshotHandler(snapshots))
+		r.Get("/snapshots/{id}/diff", DiffSnapshotHandler(snapshots))
+		r.With(uploadLimit).Post("/snapshots/{id}/diff", DiffSnapshotHandler(snapshots))
+		r.Get("/check/submission", SubmissionCheckHandler(store))
+		if sessionToken == "" {
+			return
+		}
+
+		// Routes taking a path on this machine
+		r.Group(func(r chi.Router) {
+			r.Use(requireHostAccess)
+			r.Get("/git/repo", GitRepoHandler())
+			r.With(compress).Get("/git/status", GitStatusHandler())
+			r.Get("/git/blame", GitBlameHandler())
+			r.Get("/git/commit-message", GitCommitMessageHandler())
+			r.Post("/git/init", GitInitHandler())
+			r.With(compress).Get("/project/graph", ProjectGraphHandler())
+			r.Get("/project/unused", UnusedFilesHandler())
+			r.Post("/project/unused/trash", TrashUnusedHandler())
+			r.Get("/check/references", ReferenceCheckHandler())
+			r.Post("/publish/github-release", GitHubReleaseHandler(store, githubPublisher))
+		})
+	}
+
+	r.Route(api.VersionPrefix, func(r chi.Router) {
+		r.Use(api.VersionMiddleware)
+		apiRoutes(r)
+	})
+
+	// Unversioned aliases of the /v1 routes, kept for one release
+	r.Route(api.LegacyPrefix, func(r chi.Router) {
+		r.Use(api.VersionMiddleware, api.LegacyMiddleware)
+		apiRoutes(r)
+	})
+
+	srv := tfhttp.NewServer(tfhttp.ServerConfig{
+		Addr:              net.JoinHostPort(cfg.Server.Host, cfg.Server.Port),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxBodyBytes:      cfg.Server.MaxBodySize,
+	}, r)
+
+	ln, err := listen(cfg.Server)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to listen")
 	}
 
 	go func() {
-		logger.WithField("addr", srv.Addr).Info("Server starting")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.WithField("addr", ln.Addr().String()).Info("Server starting")
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			logger.WithError(err).Fatal("Server error")
 		}
 	}()
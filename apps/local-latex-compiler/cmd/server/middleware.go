@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// requestLogger logs every request with its status and duration. Failed
+// requests are logged above debug level so they show up in the server log.
+func requestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		entry := logger.WithFields(logrus.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      status,
+			"bytes":       ww.BytesWritten(),
+			"duration_ms": time.Since(start).Milliseconds(),
+			"request_id":  middleware.GetReqID(r.Context()),
+		})
+
+		switch {
+		case status >= 500:
+			entry.Error("HTTP request failed")
+		case status >= 400:
+			entry.Warn("HTTP request rejected")
+		default:
+			entry.Debug("HTTP request completed")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alpha-og/treefrog/apps/local-latex-compiler/internal/config"
+)
+
+// sessionToken guards the routes that take a path on this machine (git,
+// project graph, unused files, reference checks, GitHub releases). Empty
+// when those routes are off.
+var sessionToken string
+
+// hostLocal reports whether only this machine can reach the server: over a
+// socket or named pipe, or on a loopback interface. Host paths mean nothing
+// to clients elsewhere, such as those of a containerized compiler.
+func hostLocal(cfg config.ServerConfig) bool {
+	if cfg.Socket != "" {
+		return true
+	}
+	if cfg.Host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(cfg.Host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loadSessionToken returns cfg.Token, or a random token written to
+// cfg.TokenFile, readable only by the user running the server, for local
+// clients to send
+func loadSessionToken(cfg config.ServerConfig) (string, error) {
+	if cfg.Token != "" {
+		return cfg.Token, nil
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	if err := os.MkdirAll(filepath.Dir(cfg.TokenFile), 0700); err != nil {
+		return "", fmt.Errorf("failed to create session token directory: %w", err)
+	}
+	// Removed first so an existing file or link cannot keep looser permissions
+	_ = os.Remove(cfg.TokenFile)
+	f, err := os.OpenFile(cfg.TokenFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to write session token: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		return "", fmt.Errorf("failed to write session token: %w", err)
+	}
+	return token, nil
+}
+
+// hostAccess reports whether r carries the session token
+func hostAccess(r *http.Request) bool {
+	if sessionToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(sessionToken)) == 1
+}
+
+// requireHostAccess refuses requests without the session token, so websites
+// and other users cannot reach paths on this machine through the server
+func requireHostAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hostAccess(r) {
+			http.Error(w, "session token required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
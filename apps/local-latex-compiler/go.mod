@@ -3,9 +3,16 @@ module github.com/alpha-og/treefrog/apps/local-latex-compiler
 go 1.24.0
 
 require (
+	github.com/Microsoft/go-winio v0.6.2
+	github.com/alpha-og/treefrog/packages/go/api v0.0.0
 	github.com/alpha-og/treefrog/packages/go/build v0.0.0
+	github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+	github.com/alpha-og/treefrog/packages/go/client v0.0.0
+	github.com/alpha-og/treefrog/packages/go/events v0.0.0
+	github.com/alpha-og/treefrog/packages/go/http v0.0.0
 	github.com/alpha-og/treefrog/packages/go/security v0.0.0
 	github.com/alpha-og/treefrog/packages/go/synctex v0.0.0
+	github.com/alpha-og/treefrog/packages/go/vcs v0.0.0
 	github.com/go-chi/chi/v5 v5.2.5
 	github.com/go-chi/cors v1.2.1
 	github.com/google/uuid v1.6.0
@@ -14,7 +21,6 @@ require (
 
 require (
 	github.com/Azure/go-ansiterm v0.0.0-20250102033503-faa5f7b0171c // indirect
-	github.com/Microsoft/go-winio v0.6.2 // indirect
 	github.com/cenkalti/backoff/v5 v5.0.3 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/containerd/errdefs v1.0.0 // indirect
@@ -46,8 +52,14 @@ require (
 )
 
 replace (
+	github.com/alpha-og/treefrog/packages/go/api => ../../packages/go/api
 	github.com/alpha-og/treefrog/packages/go/build => ../../packages/go/build
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../../packages/go/buildopts
+	github.com/alpha-og/treefrog/packages/go/client => ../../packages/go/client
+	github.com/alpha-og/treefrog/packages/go/events => ../../packages/go/events
+	github.com/alpha-og/treefrog/packages/go/http => ../../packages/go/http
 	github.com/alpha-og/treefrog/packages/go/security => ../../packages/go/security
 	github.com/alpha-og/treefrog/packages/go/synctex => ../../packages/go/synctex
 	github.com/alpha-og/treefrog/packages/go/validation => ../../packages/go/validation
+	github.com/alpha-og/treefrog/packages/go/vcs => ../../packages/go/vcs
 )
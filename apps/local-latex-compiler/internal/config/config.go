@@ -2,28 +2,100 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/alpha-og/treefrog/packages/go/client"
 )
 
 type Config struct {
-	Server  ServerConfig
-	Build   BuildConfig
-	Cleanup CleanupConfig
+	Server   ServerConfig
+	Build    BuildConfig
+	Cleanup  CleanupConfig
+	Log      LogConfig
+	Snapshot SnapshotConfig
+	Publish  PublishConfig
+	Network  NetworkConfig
 }
 
 type ServerConfig struct {
-	Port            string
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	IdleTimeout     time.Duration
-	ShutdownTimeout time.Duration
+	Port              string
+	Host              string        // interface the port listens on; 127.0.0.1 keeps other machines out
+	Socket            string        // Unix socket or Windows named pipe; overrides Port
+	Token             string        // bearer token of routes reaching host paths; generated per session when empty
+	TokenFile         string        // where a generated token is written for local clients to read
+	ReadHeaderTimeout time.Duration // to send request headers, against slow clients
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	MaxBodySize       int64 // request bodies of routes that take no uploads
 }
 
 type BuildConfig struct {
-	WorkDir     string
-	MaxFileSize int64
-	Timeout     time.Duration
+	WorkDir       string
+	MaxFileSize   int64
+	Timeout       time.Duration // timeout of builds that ask for none
+	MaxTimeout    time.Duration // longest timeout a build may ask for
+	Image         string
+	ImageDigest   string // sha256:... the image must match before each build; empty to accept any
+	Runtime       RuntimeConfig
+	AllowNetwork  bool   // builds may opt in to network access; containers are offline otherwise
+	PoolSize      int    // idle compile containers kept warm; 0 starts a container per build
+	PoolMaxUses   int    // builds a pooled container runs before it is replaced
+	Workers       int    // targets a build of all targets compiles at once
+	PDFXProfile   string // CMYK ICC profile of PDF/X conversions; "" for ghostscript's default
+	SourceKey     string // base64 key decrypting sources uploaded with encrypted=true; "" to refuse them
+	LogRedact     bool   // mask AWS keys, bearer tokens and emails in build logs
+	AutoShell     bool   // compile minted documents with the restricted profile unasked
+	LogRedactFile string // more patterns to mask, one regular expression per line
+}
+
+// RuntimeConfig is how compile containers are locked down. Empty fields keep
+// the values of buildopts.HardenedProfile, or Docker's defaults when
+// Hardened is off.
+type RuntimeConfig struct {
+	Hardened  bool
+	User      string
+	Seccomp   string
+	AppArmor  string
+	PidsLimit int
+}
+
+type LogConfig struct {
+	Level    string
+	Dir      string // empty disables the log file
+	MaxSize  int64
+	MaxFiles int
+}
+
+// SnapshotConfig is where project snapshots are kept
+type SnapshotConfig struct {
+	Dir     string
+	MaxSize int64 // decompressed bytes of a snapshotted project
+}
+
+// PublishConfig is how built PDFs are published. The GitHub token needs
+// write access to the contents of the repositories released to.
+type PublishConfig struct {
+	GitHubToken string
+	GitHubAPI   string
+}
+
+// NetworkConfig is how the server dials out, e.g. to publish releases, on
+// IPv6-only networks or behind split-horizon DNS
+type NetworkConfig struct {
+	PreferIPv6     bool
+	DNSServer      string // host[:port]; empty for the system resolver
+	ConnectTimeout time.Duration
+}
+
+// DialOptions returns the dial options of outgoing connections
+func (n NetworkConfig) DialOptions() client.DialOptions {
+	return client.DialOptions{PreferIPv6: n.PreferIPv6, Resolver: n.DNSServer, ConnectTimeout: n.ConnectTimeout}
 }
 
 type CleanupConfig struct {
@@ -35,23 +107,94 @@ type CleanupConfig struct {
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port:            getEnvOrDefault("PORT", "8080"),
-			ReadTimeout:     getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
-			WriteTimeout:    getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
-			IdleTimeout:     getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
-			ShutdownTimeout: getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+			Port:              getEnvOrDefault("PORT", "8080"),
+			Host:              getEnvOrDefault("SERVER_HOST", "127.0.0.1"),
+			Socket:            os.Getenv("SERVER_SOCKET"),
+			Token:             os.Getenv("LOCAL_API_TOKEN"),
+			TokenFile:         getEnvOrDefault("LOCAL_API_TOKEN_FILE", defaultCacheDir("session-token")),
+			ReadHeaderTimeout: getDurationEnv("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+			ReadTimeout:       getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:      getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
+			IdleTimeout:       getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			ShutdownTimeout:   getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+			MaxBodySize:       int64(getIntEnv("SERVER_MAX_BODY_SIZE", 1024*1024)),
 		},
 		Build: BuildConfig{
 			WorkDir:     getEnvOrDefault("COMPILER_WORKDIR", "/tmp/treefrog-builds"),
 			MaxFileSize: int64(getIntEnv("BUILD_MAX_FILE_SIZE", 100*1024*1024)),
 			Timeout:     getDurationEnv("BUILD_TIMEOUT", 5*time.Minute),
+			MaxTimeout:  getDurationEnv("BUILD_MAX_TIMEOUT", 30*time.Minute),
+			Image:       getEnvOrDefault("COMPILER_IMAGE", "treefrog-local-latex-compiler:latest"),
+			ImageDigest: os.Getenv("COMPILER_IMAGE_DIGEST"),
+			Runtime: RuntimeConfig{
+				Hardened:  getBoolEnv("COMPILER_HARDENED", true),
+				User:      os.Getenv("COMPILER_USER"),
+				Seccomp:   os.Getenv("COMPILER_SECCOMP_PROFILE"),
+				AppArmor:  os.Getenv("COMPILER_APPARMOR_PROFILE"),
+				PidsLimit: getIntEnv("COMPILER_PIDS_LIMIT", 0),
+			},
+			AllowNetwork:  getBoolEnv("COMPILER_ALLOW_NETWORK", false),
+			PoolSize:      getIntEnv("COMPILER_POOL_SIZE", build.DefaultPoolSize),
+			PoolMaxUses:   getIntEnv("COMPILER_POOL_MAX_USES", build.DefaultPoolMaxUses),
+			Workers:       getIntEnv("BUILD_WORKERS", 2),
+			PDFXProfile:   os.Getenv("PDFX_ICC_PROFILE"),
+			SourceKey:     os.Getenv("SOURCE_ENCRYPTION_KEY"),
+			LogRedact:     getBoolEnv("BUILD_LOG_REDACT", true),
+			AutoShell:     getBoolEnv("BUILD_AUTO_SHELL_ESCAPE", true),
+			LogRedactFile: os.Getenv("BUILD_LOG_REDACT_FILE"),
 		},
 		Cleanup: CleanupConfig{
 			Enabled:  getBoolEnv("CLEANUP_ENABLED", true),
 			Interval: getDurationEnv("CLEANUP_INTERVAL", time.Hour),
 			TTL:      getDurationEnv("CLEANUP_TTL", 24*time.Hour),
 		},
+		Log: LogConfig{
+			Level:    getEnvOrDefault("LOG_LEVEL", "info"),
+			Dir:      getEnvOrDefault("LOG_DIR", defaultCacheDir("logs")),
+			MaxSize:  int64(getIntEnv("LOG_MAX_SIZE", 10*1024*1024)),
+			MaxFiles: getIntEnv("LOG_MAX_FILES", 5),
+		},
+		Snapshot: SnapshotConfig{
+			Dir:     getEnvOrDefault("SNAPSHOT_DIR", defaultCacheDir("snapshots")),
+			MaxSize: int64(getIntEnv("SNAPSHOT_MAX_SIZE", 1024*1024*1024)),
+		},
+		Publish: PublishConfig{
+			GitHubToken: os.Getenv("GITHUB_TOKEN"),
+			GitHubAPI:   os.Getenv("GITHUB_API_URL"), // empty for api.github.com
+		},
+		Network: NetworkConfig{
+			PreferIPv6:     getBoolEnv("NET_PREFER_IPV6", false),
+			DNSServer:      os.Getenv("NET_DNS_SERVER"),
+			ConnectTimeout: getDurationEnv("NET_CONNECT_TIMEOUT", client.DefaultConnectTimeout),
+		},
+	}
+}
+
+// Profile returns the runtime profile compile containers run with
+func (r RuntimeConfig) Profile() buildopts.RuntimeProfile {
+	var profile buildopts.RuntimeProfile
+	if r.Hardened {
+		profile = buildopts.HardenedProfile()
+	}
+	if r.User != "" {
+		profile.User = r.User
+	}
+	if r.PidsLimit > 0 {
+		profile.PidsLimit = int64(r.PidsLimit)
+	}
+	profile.Seccomp = r.Seccomp
+	profile.AppArmor = r.AppArmor
+	return profile
+}
+
+// defaultCacheDir keeps name, e.g. server logs, under the user cache
+// directory
+func defaultCacheDir(name string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "treefrog", name)
 	}
+	return filepath.Join(dir, "treefrog", name)
 }
 
 func getEnvOrDefault(key, defaultVal string) string {
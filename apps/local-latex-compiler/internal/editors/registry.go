@@ -0,0 +1,281 @@
+package editors
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTTL is how long a registration lives unless it is renewed
+	DefaultTTL = time.Hour
+	// MaxEditors bounds the number of live registrations
+	MaxEditors = 16
+	// maxFailures drops an editor after this many consecutive failed pushes
+	maxFailures = 3
+	pushTimeout = 3 * time.Second
+)
+
+// allowedHosts are the callback hosts besides loopback addresses. The server
+// usually runs in Docker, where the host's editors are reached this way.
+var allowedHosts = map[string]bool{
+	"localhost":            true,
+	"host.docker.internal": true,
+}
+
+// Editor is a registered editor process that receives SyncTeX jumps
+type Editor struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	token    string
+	failures int
+}
+
+// Jump is pushed to every editor after a reverse SyncTeX search
+type Jump struct {
+	Type    string `json:"type"`
+	BuildID string `json:"build_id"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+}
+
+// Registry holds editor callback registrations in memory
+type Registry struct {
+	mu        sync.Mutex
+	editors   map[string]*Editor
+	logger    *logrus.Entry
+	transport http.RoundTripper // of http callbacks
+	unix      *http.Transport   // of unix callbacks, shared so connections are reused
+}
+
+// NewRegistry creates a registry pushing to http callbacks through
+// transport, or http.DefaultTransport if it is nil
+func NewRegistry(transport http.RoundTripper) *Registry {
+	return &Registry{
+		editors:   make(map[string]*Editor),
+		logger:    logrus.WithField("component", "editors"),
+		transport: transport,
+		unix: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				socket, err := unixSocket(addr)
+				if err != nil {
+					return nil, err
+				}
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
+
+// unixHost encodes a socket path as the host of a unix callback request, so
+// the shared transport pools connections per socket
+func unixHost(socket string) string {
+	return hex.EncodeToString([]byte(socket))
+}
+
+// unixSocket decodes the socket path from the address the transport dials
+func unixSocket(addr string) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	socket, err := hex.DecodeString(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid unix callback host %q", host)
+	}
+	return string(socket), nil
+}
+
+// ValidateCallback checks that a callback is an http URL on the local machine
+// or a unix socket ("unix:///path/to.sock")
+func ValidateCallback(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return fmt.Errorf("unix callback needs a socket path")
+		}
+		return nil
+	case "http":
+		host := u.Hostname()
+		if allowedHosts[host] {
+			return nil
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return fmt.Errorf("callback host must be local, got %q", host)
+	default:
+		return fmt.Errorf("callback scheme must be http or unix")
+	}
+}
+
+// Register adds an editor, or renews it when the same URL is registered
+// again. token, if set, is sent back in the X-Treefrog-Editor-Token header.
+func (r *Registry) Register(name, callback, token string) (*Editor, error) {
+	if err := ValidateCallback(callback); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneLocked()
+
+	for _, e := range r.editors {
+		if e.URL == callback {
+			e.Name = name
+			e.token = token
+			e.failures = 0
+			e.ExpiresAt = time.Now().Add(DefaultTTL)
+			renewed := *e
+			return &renewed, nil
+		}
+	}
+	if len(r.editors) >= MaxEditors {
+		return nil, fmt.Errorf("too many registered editors (max %d)", MaxEditors)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	e := &Editor{
+		ID:        "ed_" + hex.EncodeToString(id),
+		Name:      name,
+		URL:       callback,
+		ExpiresAt: time.Now().Add(DefaultTTL),
+		token:     token,
+	}
+	r.editors[e.ID] = e
+	r.logger.WithField("editor_id", e.ID).WithField("url", callback).Info("Editor registered")
+
+	registered := *e
+	return &registered, nil
+}
+
+// Unregister removes an editor; it reports whether the editor existed
+func (r *Registry) Unregister(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.editors[id]
+	delete(r.editors, id)
+	return ok
+}
+
+// List returns the live registrations
+func (r *Registry) List() []Editor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pruneLocked()
+
+	list := make([]Editor, 0, len(r.editors))
+	for _, e := range r.editors {
+		list = append(list, *e)
+	}
+	return list
+}
+
+func (r *Registry) pruneLocked() {
+	now := time.Now()
+	for id, e := range r.editors {
+		if now.After(e.ExpiresAt) {
+			delete(r.editors, id)
+		}
+	}
+}
+
+// Notify pushes a jump to every registered editor in the background.
+// Editors that fail repeatedly are dropped.
+func (r *Registry) Notify(jump Jump) {
+	for _, e := range r.List() {
+		go r.push(e, jump)
+	}
+}
+
+func (r *Registry) push(e Editor, jump Jump) {
+	err := r.send(e, jump)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	live, ok := r.editors[e.ID]
+	if !ok {
+		return
+	}
+	if err == nil {
+		live.failures = 0
+		return
+	}
+
+	live.failures++
+	log := r.logger.WithError(err).WithField("editor_id", e.ID)
+	if live.failures >= maxFailures {
+		delete(r.editors, e.ID)
+		log.Warn("Dropping unreachable editor")
+		return
+	}
+	log.Debug("Failed to push jump to editor")
+}
+
+func (r *Registry) send(e Editor, jump Jump) error {
+	body, err := json.Marshal(jump)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout:   pushTimeout,
+		Transport: r.transport,
+		// Redirects could point the push anywhere; callbacks must answer directly
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	target := e.URL
+	if u.Scheme == "unix" {
+		client.Transport = r.unix
+		target = "http://" + unixHost(u.Path) + "/"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("X-Treefrog-Editor-Token", e.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("editor responded %s", strings.TrimSpace(resp.Status))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Layout string
+
+const (
+	LayoutNone    Layout = ""
+	Layout2Up     Layout = "2up"
+	LayoutBooklet Layout = "booklet"
+)
+
+var ValidLayouts = map[string]bool{
+	"":        true,
+	"2up":     true,
+	"booklet": true,
+}
+
+// pageRangePattern accepts comma separated pages and ranges, e.g. "1-10,12,15-"
+var pageRangePattern = regexp.MustCompile(`^\d+(-\d*)?(,\d+(-\d*)?)*$`)
+
+type Options struct {
+	Pages  string
+	Layout Layout
+}
+
+// ValidatePages checks that a page selection is well formed and ascending
+func ValidatePages(pages string) error {
+	if pages == "" {
+		return nil
+	}
+	if !pageRangePattern.MatchString(pages) {
+		return fmt.Errorf("invalid page range: %q", pages)
+	}
+
+	for _, part := range strings.Split(pages, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, _ := strconv.Atoi(bounds[0])
+		if start < 1 {
+			return fmt.Errorf("invalid page range: pages start at 1")
+		}
+		if len(bounds) == 2 && bounds[1] != "" {
+			end, _ := strconv.Atoi(bounds[1])
+			if end < start {
+				return fmt.Errorf("invalid page range: %s", part)
+			}
+		}
+	}
+	return nil
+}
+
+// Available reports whether the tools needed for the given options are installed
+func Available(opts Options) error {
+	if opts.Layout != LayoutNone {
+		if _, err := exec.LookPath("pdfcpu"); err != nil {
+			return fmt.Errorf("pdfcpu not installed")
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("pdfcpu"); err == nil {
+		return nil
+	}
+	if _, err := exec.LookPath("qpdf"); err == nil {
+		return nil
+	}
+	return fmt.Errorf("neither pdfcpu nor qpdf is installed")
+}
+
+// Transform writes a copy of src to dst with the page selection and layout applied.
+// Page extraction prefers pdfcpu and falls back to qpdf; imposition requires pdfcpu.
+func Transform(ctx context.Context, src, dst string, opts Options) error {
+	if err := ValidatePages(opts.Pages); err != nil {
+		return err
+	}
+
+	switch opts.Layout {
+	case Layout2Up:
+		return runPDFCPU(ctx, "nup", opts.Pages, dst, "2", src)
+	case LayoutBooklet:
+		return runPDFCPU(ctx, "booklet", opts.Pages, dst, "2", src)
+	case LayoutNone:
+	default:
+		return fmt.Errorf("invalid layout: %q", opts.Layout)
+	}
+
+	if _, err := exec.LookPath("pdfcpu"); err == nil {
+		return runPDFCPU(ctx, "trim", opts.Pages, src, dst)
+	}
+	return runQPDF(ctx, opts.Pages, src, dst)
+}
+
+func runPDFCPU(ctx context.Context, command, pages string, args ...string) error {
+	cmdArgs := []string{command}
+	if pages != "" {
+		cmdArgs = append(cmdArgs, "-pages", pages)
+	}
+	cmdArgs = append(cmdArgs, args...)
+	return run(ctx, "pdfcpu", cmdArgs...)
+}
+
+func runQPDF(ctx context.Context, pages, src, dst string) error {
+	if pages == "" {
+		return run(ctx, "qpdf", src, dst)
+	}
+	// qpdf uses "z" for the last page where pdfcpu accepts an open range
+	qpdfPages := strings.ReplaceAll(pages+",", "-,", "-z,")
+	qpdfPages = strings.TrimSuffix(qpdfPages, ",")
+	return run(ctx, "qpdf", "--empty", "--pages", src, qpdfPages, "--", dst)
+}
+
+func run(ctx context.Context, name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w\n%s", name, err, stderr.String())
+	}
+	return nil
+}
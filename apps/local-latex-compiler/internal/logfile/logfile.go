@@ -0,0 +1,157 @@
+// Package logfile is a size-rotated log file. The current file is <name>.log;
+// when it grows past the size limit it becomes <name>.log.1, the previous
+// <name>.log.1 becomes <name>.log.2 and so on, keeping a fixed number of old
+// files.
+package logfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Writer is an io.Writer over a rotated log file. It is safe for concurrent
+// use.
+type Writer struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens or creates dir/name.log for appending. The file is rotated once
+// it exceeds maxSize bytes, keeping maxFiles rotated files.
+func Open(dir, name string, maxSize int64, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	w := &Writer{
+		path:     filepath.Join(dir, name+".log"),
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Path returns the path of the current log file
+func (w *Writer) Path() string {
+	return w.path
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// Write appends p to the log, rotating first if p would take the file past
+// its size limit
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			if w.file == nil {
+				return 0, err
+			}
+			// The log is its own error channel; keep writing to the
+			// current file and retry on the next write
+			fmt.Fprintf(os.Stderr, "logfile: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and opens a new one. If the file
+// cannot be moved it is opened again, so writes go on past the size limit
+// rather than fail; w.file is nil only if no file could be opened.
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	var err error
+	if w.maxFiles > 0 {
+		_ = os.Remove(w.rotated(w.maxFiles))
+		for i := w.maxFiles - 1; i >= 1; i-- {
+			_ = os.Rename(w.rotated(i), w.rotated(i+1))
+		}
+		if rerr := os.Rename(w.path, w.rotated(1)); rerr != nil {
+			err = fmt.Errorf("failed to rotate log file: %w", rerr)
+		}
+	} else if terr := os.Truncate(w.path, 0); terr != nil {
+		err = fmt.Errorf("failed to truncate log file: %w", terr)
+	}
+
+	if oerr := w.open(); oerr != nil {
+		return errors.Join(err, oerr)
+	}
+	return err
+}
+
+func (w *Writer) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", w.path, i)
+}
+
+// Tail returns up to n of the most recent lines, reading back into rotated
+// files if the current one is shorter
+func (w *Writer) Tail(n int) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var lines [][]byte
+	for i := 0; i <= w.maxFiles && len(lines) < n; i++ {
+		path := w.path
+		if i > 0 {
+			path = w.rotated(i)
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read log file: %w", err)
+		}
+
+		fileLines := bytes.SplitAfter(data, []byte("\n"))
+		if last := len(fileLines) - 1; last >= 0 && len(fileLines[last]) == 0 {
+			fileLines = fileLines[:last]
+		}
+		if need := n - len(lines); len(fileLines) > need {
+			fileLines = fileLines[len(fileLines)-need:]
+		}
+		lines = append(fileLines, lines...)
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// Close closes the current log file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
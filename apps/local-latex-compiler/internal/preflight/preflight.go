@@ -0,0 +1,342 @@
+// Package preflight checks a compiled PDF against the formatting rules of
+// the venue it is submitted to, using the poppler tools pdfinfo, pdffonts
+// and pdftotext.
+package preflight
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// Names of the checks
+const (
+	CheckPages     = "pages"
+	CheckPageSize  = "page_size"
+	CheckMargins   = "margins"
+	CheckFonts     = "fonts"
+	CheckAnonymity = "anonymity"
+)
+
+// Severities of violations
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+const (
+	// sizeTolerance is how far, in points, a page may be off its size
+	sizeTolerance = 2.0
+	// marginTolerance is how far text may reach into a margin
+	marginTolerance = 1.0
+	// maxPagesListed caps the pages a violation lists
+	maxPagesListed = 20
+)
+
+var (
+	pageRe      = regexp.MustCompile(`<page width="([\d.]+)" height="([\d.]+)">`)
+	wordRe      = regexp.MustCompile(`<word xMin="([\d.]+)" yMin="([\d.]+)" xMax="([\d.]+)" yMax="([\d.]+)">([^<]*)</word>`)
+	numberRe    = regexp.MustCompile(`^[\d.,:-]+$`)
+	emailRe     = regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`)
+	repoURLRe   = regexp.MustCompile(`(?i)(?:github|gitlab|bitbucket)\.(?:com|org)/[\w.-]+`)
+	ackRe       = regexp.MustCompile(`(?i)^acknowledge?ments?\b`)
+	referenceRe = regexp.MustCompile(`^(?:References|REFERENCES|Bibliography|BIBLIOGRAPHY)$`)
+)
+
+// Options adjust a profile for one submission
+type Options struct {
+	MaxPages        int   // overrides the profile's when > 0
+	MaxContentPages int   // overrides the profile's when > 0
+	Anonymous       *bool // overrides the profile's when set
+	// Forbidden are strings an anonymous submission must not contain, such
+	// as author names and affiliations
+	Forbidden []string
+}
+
+// Violation is a rule of the profile the PDF breaks
+type Violation struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Pages    []int  `json:"pages,omitempty"`
+}
+
+// Report is the result of checking a PDF against a profile
+type Report struct {
+	Profile      Profile     `json:"profile"`
+	Pages        int         `json:"pages"`
+	ContentPages int         `json:"content_pages"` // pages before the references
+	Passed       bool        `json:"passed"`        // no errors; warnings may remain
+	Violations   []Violation `json:"violations"`
+}
+
+// page is the size and words of a page of pdftotext -bbox output
+type page struct {
+	width, height float64
+	words         []word
+}
+
+type word struct {
+	xMin, yMin, xMax, yMax float64
+	text                   string
+}
+
+// Available reports whether the poppler tools the checks need are installed
+func Available() error {
+	for _, tool := range []string{"pdfinfo", "pdffonts", "pdftotext"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s not installed", tool)
+		}
+	}
+	return nil
+}
+
+// Check checks the PDF at pdfPath against profile p as adjusted by opts
+func Check(ctx context.Context, pdfPath string, p Profile, opts Options) (*Report, error) {
+	if opts.MaxPages > 0 {
+		p.MaxPages = opts.MaxPages
+	}
+	if opts.MaxContentPages > 0 {
+		p.MaxContentPages = opts.MaxContentPages
+	}
+	if opts.Anonymous != nil {
+		p.Anonymous = *opts.Anonymous
+	}
+
+	info, err := run(ctx, "pdfinfo", pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	fontsOut, err := run(ctx, "pdffonts", pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	bbox, err := run(ctx, "pdftotext", "-bbox", pdfPath, "-")
+	if err != nil {
+		return nil, err
+	}
+	pages := parsePages(bbox)
+
+	r := &Report{Profile: p, Pages: len(pages), ContentPages: contentPages(pages), Violations: []Violation{}}
+	r.checkPages(p)
+	r.checkPageSize(p, pages)
+	r.checkMargins(p, pages)
+	r.checkFonts(p, build.ParseFonts(fontsOut))
+	if p.Anonymous {
+		r.checkAnonymity(pages, infoField(info, "Author"), opts.Forbidden)
+	}
+
+	r.Passed = true
+	for _, v := range r.Violations {
+		if v.Severity == SeverityError {
+			r.Passed = false
+		}
+	}
+	return r, nil
+}
+
+func (r *Report) add(check, severity, message string, pages []int) {
+	if len(pages) > maxPagesListed {
+		pages = pages[:maxPagesListed]
+	}
+	r.Violations = append(r.Violations, Violation{Check: check, Severity: severity, Message: message, Pages: pages})
+}
+
+func (r *Report) checkPages(p Profile) {
+	if p.MaxPages > 0 && r.Pages > p.MaxPages {
+		r.add(CheckPages, SeverityError, fmt.Sprintf("%d pages, limit is %d", r.Pages, p.MaxPages), nil)
+	}
+	if p.MaxContentPages > 0 && r.ContentPages > p.MaxContentPages {
+		r.add(CheckPages, SeverityError, fmt.Sprintf("%d pages before the references, limit is %d", r.ContentPages, p.MaxContentPages), nil)
+	}
+}
+
+func (r *Report) checkPageSize(p Profile, pages []page) {
+	if p.PageSize == nil {
+		return
+	}
+	var wrong []int
+	for i, pg := range pages {
+		if math.Abs(pg.width-p.PageSize.Width) > sizeTolerance || math.Abs(pg.height-p.PageSize.Height) > sizeTolerance {
+			wrong = append(wrong, i+1)
+		}
+	}
+	if len(wrong) > 0 {
+		pg := pages[wrong[0]-1]
+		r.add(CheckPageSize, SeverityError, fmt.Sprintf("%d of %d pages are %.0fx%.0fpt, not %s (%.0fx%.0fpt)", len(wrong), len(pages), pg.width, pg.height, p.PageSize.Name, p.PageSize.Width, p.PageSize.Height), wrong)
+	}
+}
+
+// checkMargins flags pages with text in the margins. Words that are only
+// numbers are left alone: page numbers and the line numbers of review
+// versions sit in the margins.
+func (r *Report) checkMargins(p Profile, pages []page) {
+	m := p.Margins
+	if m == (Margins{}) {
+		return
+	}
+	var over []int
+	for i, pg := range pages {
+		for _, w := range pg.words {
+			if numberRe.MatchString(w.text) {
+				continue
+			}
+			if w.xMin < m.Left-marginTolerance || w.xMax > pg.width-m.Right+marginTolerance ||
+				w.yMin < m.Top-marginTolerance || w.yMax > pg.height-m.Bottom+marginTolerance {
+				over = append(over, i+1)
+				break
+			}
+		}
+	}
+	if len(over) > 0 {
+		r.add(CheckMargins, SeverityError, fmt.Sprintf("text reaches into the margins on %d of %d pages", len(over), len(pages)), over)
+	}
+}
+
+func (r *Report) checkFonts(p Profile, fonts []api.Font) {
+	var missing, type3 []string
+	for _, f := range fonts {
+		if !f.Embedded {
+			missing = append(missing, f.Name)
+		}
+		if f.Type == "Type 3" {
+			type3 = append(type3, f.Name)
+		}
+	}
+	if len(missing) > 0 {
+		r.add(CheckFonts, SeverityError, "fonts not embedded: "+strings.Join(missing, ", "), nil)
+	}
+	if p.NoType3Fonts && len(type3) > 0 {
+		r.add(CheckFonts, SeverityError, "Type 3 (bitmap) fonts: "+strings.Join(type3, ", "), nil)
+	}
+}
+
+// checkAnonymity looks for what gives away the authors of an anonymous
+// submission: the PDF's Author metadata, forbidden strings, email
+// addresses, repository URLs and acknowledgments
+func (r *Report) checkAnonymity(pages []page, author string, forbidden []string) {
+	if author != "" {
+		r.add(CheckAnonymity, SeverityError, fmt.Sprintf("PDF metadata names the author %q", author), nil)
+	}
+
+	texts := make([]string, len(pages))
+	for i, pg := range pages {
+		words := make([]string, len(pg.words))
+		for j, w := range pg.words {
+			words[j] = w.text
+		}
+		texts[i] = strings.Join(words, " ")
+	}
+
+	for _, s := range forbidden {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if found := pagesMatching(texts, func(t string) bool { return strings.Contains(strings.ToLower(t), strings.ToLower(s)) }); len(found) > 0 {
+			r.add(CheckAnonymity, SeverityError, fmt.Sprintf("contains %q", s), found)
+		}
+	}
+	if found := pagesMatching(texts, emailRe.MatchString); len(found) > 0 {
+		r.add(CheckAnonymity, SeverityWarning, "contains email addresses", found)
+	}
+	if found := pagesMatching(texts, repoURLRe.MatchString); len(found) > 0 {
+		r.add(CheckAnonymity, SeverityWarning, "links to code repositories, which may name the authors", found)
+	}
+	var acks []int
+	for i, pg := range pages {
+		for _, w := range pg.words {
+			if ackRe.MatchString(w.text) {
+				acks = append(acks, i+1)
+				break
+			}
+		}
+	}
+	if len(acks) > 0 {
+		r.add(CheckAnonymity, SeverityWarning, "has acknowledgments, which anonymous submissions usually leave out", acks)
+	}
+}
+
+func pagesMatching(texts []string, match func(string) bool) []int {
+	var pages []int
+	for i, t := range texts {
+		if match(t) {
+			pages = append(pages, i+1)
+		}
+	}
+	return pages
+}
+
+// contentPages counts the pages before the one the references start on,
+// found by a heading word of its own; all pages if there is none
+func contentPages(pages []page) int {
+	for i := len(pages) - 1; i > 0; i-- {
+		for _, w := range pages[i].words {
+			if referenceRe.MatchString(w.text) {
+				// References starting below the top of a page share it with
+				// the content
+				if w.yMin > pages[i].height/4 {
+					return i + 1
+				}
+				return i
+			}
+		}
+	}
+	return len(pages)
+}
+
+// parsePages reads the output of pdftotext -bbox
+func parsePages(out []byte) []page {
+	var pages []page
+	for _, line := range strings.Split(string(out), "\n") {
+		if m := pageRe.FindStringSubmatch(line); m != nil {
+			var pg page
+			pg.width, _ = strconv.ParseFloat(m[1], 64)
+			pg.height, _ = strconv.ParseFloat(m[2], 64)
+			pages = append(pages, pg)
+			continue
+		}
+		if m := wordRe.FindStringSubmatch(line); m != nil && len(pages) > 0 {
+			var w word
+			w.xMin, _ = strconv.ParseFloat(m[1], 64)
+			w.yMin, _ = strconv.ParseFloat(m[2], 64)
+			w.xMax, _ = strconv.ParseFloat(m[3], 64)
+			w.yMax, _ = strconv.ParseFloat(m[4], 64)
+			w.text = html.UnescapeString(m[5])
+			pg := &pages[len(pages)-1]
+			pg.words = append(pg.words, w)
+		}
+	}
+	return pages
+}
+
+// infoField returns a field of pdfinfo's output
+func infoField(out []byte, name string) string {
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		if value, ok := strings.CutPrefix(sc.Text(), name+":"); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w\n%s", name, err, stderr.String())
+	}
+	return out, nil
+}
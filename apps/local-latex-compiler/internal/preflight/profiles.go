@@ -0,0 +1,94 @@
+package preflight
+
+import (
+	"sort"
+	"strings"
+)
+
+// Page sizes in PostScript points
+var (
+	SizeLetter = PageSize{Name: "letter", Width: 612, Height: 792}
+	SizeA4     = PageSize{Name: "a4", Width: 595.28, Height: 841.89}
+)
+
+// PageSize is a paper size a venue asks for
+type PageSize struct {
+	Name   string  `json:"name"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Margins are the least space, in points, a venue leaves between text and
+// the edges of the page
+type Margins struct {
+	Top    float64 `json:"top"`
+	Bottom float64 `json:"bottom"`
+	Left   float64 `json:"left"`
+	Right  float64 `json:"right"`
+}
+
+// Profile holds the formatting rules of a journal or conference. Zero
+// values leave a rule unchecked.
+type Profile struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	PageSize    *PageSize `json:"page_size,omitempty"`
+	// MaxPages limits the whole PDF, MaxContentPages the pages before the
+	// references
+	MaxPages        int     `json:"max_pages,omitempty"`
+	MaxContentPages int     `json:"max_content_pages,omitempty"`
+	Margins         Margins `json:"margins"`
+	NoType3Fonts    bool    `json:"no_type3_fonts,omitempty"`
+	Anonymous       bool    `json:"anonymous,omitempty"`
+}
+
+// Profiles are the venue profiles submissions are checked against. Page
+// limits are the common ones of each venue's call for papers; requests may
+// override them.
+var Profiles = map[string]Profile{
+	"ieee": {
+		Name:            "ieee",
+		Description:     "IEEE conference (IEEEtran, US letter)",
+		PageSize:        &SizeLetter,
+		MaxContentPages: 8,
+		Margins:         Margins{Top: 54, Bottom: 54, Left: 43, Right: 43},
+		NoType3Fonts:    true,
+	},
+	"acm": {
+		Name:         "acm",
+		Description:  "ACM conference (acmart sigconf, US letter)",
+		PageSize:     &SizeLetter,
+		Margins:      Margins{Top: 54, Bottom: 54, Left: 54, Right: 54},
+		NoType3Fonts: true,
+	},
+	"lncs": {
+		Name:        "lncs",
+		Description: "Springer LNCS (llncs)",
+		MaxPages:    16,
+		Margins:     Margins{Top: 72, Bottom: 72, Left: 72, Right: 72},
+	},
+	"neurips": {
+		Name:            "neurips",
+		Description:     "NeurIPS submission (anonymous, US letter)",
+		PageSize:        &SizeLetter,
+		MaxContentPages: 9,
+		Margins:         Margins{Top: 72, Bottom: 72, Left: 108, Right: 108},
+		Anonymous:       true,
+	},
+}
+
+// ProfileNames lists the names of Profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProfile returns the profile called name, in any case
+func LookupProfile(name string) (Profile, bool) {
+	p, ok := Profiles[strings.ToLower(strings.TrimSpace(name))]
+	return p, ok
+}
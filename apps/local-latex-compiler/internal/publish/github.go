@@ -0,0 +1,165 @@
+// Package publish sends compiled PDFs to where collaborators can get them:
+// releases of the project's GitHub repository.
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultGitHubAPI = "https://api.github.com"
+	githubTimeout    = 2 * time.Minute
+	maxErrorBody     = 4096
+)
+
+// GitHub creates releases with a personal access token that may write the
+// repository's contents
+type GitHub struct {
+	APIURL     string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewGitHub creates a client for the GitHub API at apiURL, or api.github.com
+// if it is empty
+func NewGitHub(apiURL, token string) *GitHub {
+	if apiURL == "" {
+		apiURL = DefaultGitHubAPI
+	}
+	return &GitHub{
+		APIURL:     strings.TrimSuffix(apiURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: githubTimeout},
+	}
+}
+
+// Release is what to create: the tag, made on Commit if it does not exist,
+// and how the release reads
+type Release struct {
+	Tag        string `json:"tag_name"`
+	Commit     string `json:"target_commitish"`
+	Name       string `json:"name,omitempty"`
+	Notes      string `json:"body,omitempty"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// CreatedRelease is a release GitHub created
+type CreatedRelease struct {
+	ID        int64  `json:"id"`
+	HTMLURL   string `json:"html_url"`
+	UploadURL string `json:"upload_url"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name        string `json:"name"`
+	Size        int64  `json:"size"`
+	DownloadURL string `json:"browser_download_url"`
+}
+
+// Error is returned when GitHub answers with a non-2xx status
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("GitHub returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// CreateRelease creates a release of owner/repo
+func (g *GitHub) CreateRelease(ctx context.Context, owner, repo string, rel Release) (*CreatedRelease, error) {
+	body, err := json.Marshal(rel)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/releases", g.APIURL, url.PathEscape(owner), url.PathEscape(repo))
+	var created CreatedRelease
+	if err := g.do(ctx, http.MethodPost, endpoint, "application/json", bytes.NewReader(body), int64(len(body)), &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UploadAsset attaches the file at path to rel as name
+func (g *GitHub) UploadAsset(ctx context.Context, rel *CreatedRelease, name, path, contentType string) (*Asset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// upload_url is a URI template: .../assets{?name,label}
+	endpoint, _, _ := strings.Cut(rel.UploadURL, "{")
+	endpoint += "?name=" + url.QueryEscape(name)
+	var asset Asset
+	if err := g.do(ctx, http.MethodPost, endpoint, contentType, f, info.Size(), &asset); err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+func (g *GitHub) do(ctx context.Context, method, endpoint, contentType string, body io.Reader, size int64, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+g.Token)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := g.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &Error{StatusCode: resp.StatusCode, Message: errorMessage(resp.Body)}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// errorMessage reads GitHub's message and the codes of any validation
+// errors, e.g. "Validation Failed (already_exists)"
+func errorMessage(r io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(r, maxErrorBody))
+	var body struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil || body.Message == "" {
+		return strings.TrimSpace(string(data))
+	}
+	var details []string
+	for _, e := range body.Errors {
+		if e.Message != "" {
+			details = append(details, e.Message)
+		} else if e.Code != "" {
+			details = append(details, e.Code)
+		}
+	}
+	if len(details) == 0 {
+		return body.Message
+	}
+	return body.Message + " (" + strings.Join(details, ", ") + ")"
+}
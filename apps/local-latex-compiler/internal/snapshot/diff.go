@@ -0,0 +1,302 @@
+package snapshot
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxPatchSize caps the files a diff shows line changes of
+	maxPatchSize = 512 * 1024
+	// maxDiffCells caps the work of diffing the changed middle of a file,
+	// in lines before times lines after
+	maxDiffCells = 4 * 1024 * 1024
+	// diffContext is how many unchanged lines surround each change
+	diffContext = 3
+)
+
+// File change kinds
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// FileDiff is a file that differs between two snapshots. Patch is a
+// unified diff of a text file, omitted for binary and very large files.
+type FileDiff struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Patch  string `json:"patch,omitempty"`
+}
+
+// Diff is what changed from one snapshot to another
+type Diff struct {
+	From  string     `json:"from"`
+	To    string     `json:"to,omitempty"` // "" for an uploaded project
+	Files []FileDiff `json:"files"`
+}
+
+// Diff compares the files of two snapshots
+func (s *Store) Diff(from, to *Snapshot) (*Diff, error) {
+	return s.diff(from, to, func(_ string, f File) (io.ReadCloser, error) { return s.Open(f) })
+}
+
+// DiffZip compares a snapshot with the project in the zip archive src, of
+// size bytes, such as the project as it is now. Projects larger than the
+// store's maximum size fail with ErrTooLarge.
+func (s *Store) DiffZip(from *Snapshot, src io.ReaderAt, size int64) (*Diff, error) {
+	reader, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip: %w", err)
+	}
+	to := &Snapshot{Files: make(map[string]File)}
+	entries := make(map[string]*zip.File)
+	for _, zf := range reader.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		name := path.Clean(zf.Name)
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", zf.Name, err)
+		}
+		h := sha256.New()
+		n, err := readLimited(h, rc, s.maxSize-to.Size)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", zf.Name, err)
+		}
+		to.Files[name] = File{Hash: hex.EncodeToString(h.Sum(nil)), Size: n}
+		to.Size += n
+		entries[name] = zf
+	}
+	return s.diff(from, to, func(name string, _ File) (io.ReadCloser, error) { return entries[name].Open() })
+}
+
+// diff compares from with to, reading the files of to with openTo
+func (s *Store) diff(from, to *Snapshot, openTo func(name string, f File) (io.ReadCloser, error)) (*Diff, error) {
+	paths := make(map[string]bool)
+	for p := range from.Files {
+		paths[p] = true
+	}
+	for p := range to.Files {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	diff := &Diff{From: from.ID, To: to.ID, Files: []FileDiff{}}
+	for _, p := range sorted {
+		a, inFrom := from.Files[p]
+		b, inTo := to.Files[p]
+		fd := FileDiff{Path: p}
+		switch {
+		case !inFrom:
+			fd.Status = Added
+		case !inTo:
+			fd.Status = Removed
+		case a.Hash != b.Hash:
+			fd.Status = Modified
+		default:
+			continue
+		}
+
+		var before, after []byte
+		var err error
+		if inFrom {
+			if before, err = readText(a, func() (io.ReadCloser, error) { return s.Open(a) }); err != nil {
+				return nil, err
+			}
+		}
+		if inTo {
+			if after, err = readText(b, func() (io.ReadCloser, error) { return openTo(p, b) }); err != nil {
+				return nil, err
+			}
+		}
+		if (before != nil || !inFrom) && (after != nil || !inTo) {
+			fd.Patch = unifiedDiff(p, inFrom, inTo, before, after)
+		}
+		diff.Files = append(diff.Files, fd)
+	}
+	return diff, nil
+}
+
+// readText returns the content of a text file, or nil for binary and very
+// large files
+func readText(file File, open func() (io.ReadCloser, error)) ([]byte, error) {
+	if file.Size > maxPatchSize {
+		return nil, nil
+	}
+	rc, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return nil, nil
+	}
+	return data, nil
+}
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns the unified diff of a file, or "" if it is too large
+// a change to diff
+func unifiedDiff(name string, inFrom, inTo bool, before, after []byte) string {
+	edits, ok := lineEdits(splitLines(before), splitLines(after))
+	if !ok {
+		return ""
+	}
+
+	var b strings.Builder
+	fromName, toName := "a/"+name, "b/"+name
+	if !inFrom {
+		fromName = "/dev/null"
+	}
+	if !inTo {
+		toName = "/dev/null"
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(edits); {
+		// Find the next change and the hunk around it, merging changes
+		// whose context would overlap
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for i := first + 1; i < len(edits) && i <= last+2*diffContext; i++ {
+			if edits[i].op != ' ' {
+				last = i
+			}
+		}
+		lo, hi := max(first-diffContext, 0), min(last+diffContext+1, len(edits))
+
+		aLine, bLine := 1, 1
+		for _, e := range edits[:lo] {
+			if e.op != '+' {
+				aLine++
+			}
+			if e.op != '-' {
+				bLine++
+			}
+		}
+		var aCount, bCount int
+		for _, e := range edits[lo:hi] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aLine--
+		}
+		if bCount == 0 {
+			bLine--
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", aLine, aCount, bLine, bCount)
+		for _, e := range edits[lo:hi] {
+			b.WriteByte(e.op)
+			b.WriteString(e.line)
+			b.WriteByte('\n')
+		}
+		start = hi
+	}
+	return b.String()
+}
+
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// lineEdits returns the shortest edit script from a to b by longest common
+// subsequence, after trimming their common prefix and suffix. It gives up
+// when the changed middle is too large.
+func lineEdits(a, b []string) ([]edit, bool) {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma)*len(mb) > maxDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:]
+	// and mb[j:]
+	lcs := make([][]int32, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	edits := make([]edit, 0, len(a)+len(b)-pre-suf)
+	for _, line := range a[:pre] {
+		edits = append(edits, edit{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(ma) && j < len(mb) {
+		switch {
+		case ma[i] == mb[j]:
+			edits = append(edits, edit{' ', ma[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', ma[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', mb[j]})
+			j++
+		}
+	}
+	for ; i < len(ma); i++ {
+		edits = append(edits, edit{'-', ma[i]})
+	}
+	for ; j < len(mb); j++ {
+		edits = append(edits, edit{'+', mb[j]})
+	}
+	for _, line := range a[len(a)-suf:] {
+		edits = append(edits, edit{' ', line})
+	}
+	return edits, true
+}
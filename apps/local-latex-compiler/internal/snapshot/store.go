@@ -0,0 +1,346 @@
+// Package snapshot keeps checkpoints of projects for users who do not use
+// git. Each snapshot is a manifest of paths to content-addressed copies of
+// the files, so files unchanged between snapshots are stored once.
+package snapshot
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaxLabelLength caps a snapshot's label
+const MaxLabelLength = 200
+
+// DefaultMaxSize caps the decompressed size of a snapshot's files when
+// NewStore is given none
+const DefaultMaxSize = 1 << 30
+
+// ErrNotFound is returned for a snapshot that does not exist
+var ErrNotFound = errors.New("snapshot not found")
+
+// ErrTooLarge is returned for a project whose files decompress to more than
+// the store's maximum size
+var ErrTooLarge = errors.New("project too large")
+
+// projectPattern keeps project names safe to use as directory names
+var projectPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+
+// idPattern matches snapshot IDs
+var idPattern = regexp.MustCompile(`^snap_[0-9a-f-]{36}$`)
+
+// File is one file of a snapshot
+type File struct {
+	Hash string `json:"hash"` // SHA-256 of the content
+	Size int64  `json:"size"`
+}
+
+// Snapshot is a checkpoint of a project's files
+type Snapshot struct {
+	ID        string          `json:"id"`
+	Project   string          `json:"project"`
+	Label     string          `json:"label,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	FileCount int             `json:"file_count"`
+	Size      int64           `json:"size"`
+	Files     map[string]File `json:"files,omitempty"`
+}
+
+// Store keeps snapshots under a directory: file contents in objects/ by
+// hash, and one manifest per snapshot in projects/<project>/
+type Store struct {
+	dir     string
+	maxSize int64 // decompressed bytes of a project's files
+	mu      sync.Mutex
+}
+
+// NewStore opens the snapshot store in dir, creating it if needed. Projects
+// whose files decompress to more than maxSize bytes, or DefaultMaxSize if
+// it is not positive, are refused.
+func NewStore(dir string, maxSize int64) (*Store, error) {
+	for _, sub := range []string{"objects", "projects"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+		}
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Store{dir: dir, maxSize: maxSize}, nil
+}
+
+// ValidateProject checks a project name
+func ValidateProject(project string) error {
+	if !projectPattern.MatchString(project) || project == "." || project == ".." {
+		return fmt.Errorf("invalid project name")
+	}
+	return nil
+}
+
+// Create snapshots the project in the zip archive src, of size bytes. It
+// stops with ErrTooLarge, keeping none of the project's files, once they
+// decompress to more than the store's maximum size.
+func (s *Store) Create(project, label string, src io.ReaderAt, size int64) (*Snapshot, error) {
+	if err := ValidateProject(project); err != nil {
+		return nil, err
+	}
+	if len(label) > MaxLabelLength {
+		return nil, fmt.Errorf("label too long (max %d characters)", MaxLabelLength)
+	}
+	reader, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip: %w", err)
+	}
+
+	snap := &Snapshot{
+		ID:        "snap_" + uuid.New().String(),
+		Project:   project,
+		Label:     label,
+		CreatedAt: time.Now(),
+		Files:     make(map[string]File),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var added []string // objects this snapshot stored first
+	fail := func(err error) (*Snapshot, error) {
+		for _, hash := range added {
+			os.Remove(s.objectPath(hash))
+		}
+		return nil, err
+	}
+	for _, zf := range reader.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		name := path.Clean(zf.Name)
+		if !filepath.IsLocal(name) {
+			return fail(fmt.Errorf("invalid file path %q", zf.Name))
+		}
+		file, isNew, err := s.storeObject(zf, s.maxSize-snap.Size)
+		if err != nil {
+			return fail(err)
+		}
+		if isNew {
+			added = append(added, file.Hash)
+		}
+		snap.Files[name] = file
+		snap.Size += file.Size
+	}
+	snap.FileCount = len(snap.Files)
+
+	if err := s.save(snap); err != nil {
+		return fail(err)
+	}
+	return snap, nil
+}
+
+// readLimited copies at most limit bytes of r to w, failing with
+// ErrTooLarge if r has more
+func readLimited(w io.Writer, r io.Reader, limit int64) (int64, error) {
+	n, err := io.Copy(w, io.LimitReader(r, limit+1))
+	if err == nil && n > limit {
+		err = ErrTooLarge
+	}
+	return n, err
+}
+
+// storeObject copies a file's content, of at most limit bytes, into
+// objects/ unless it is there already, and reports whether it was not. The
+// caller holds s.mu.
+func (s *Store) storeObject(zf *zip.File, limit int64) (File, bool, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return File{}, false, fmt.Errorf("failed to read %s: %w", zf.Name, err)
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "objects"), ".tmp-*")
+	if err != nil {
+		return File{}, false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := readLimited(io.MultiWriter(tmp, h), rc, limit)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return File{}, false, fmt.Errorf("failed to store %s: %w", zf.Name, err)
+	}
+
+	file := File{Hash: hex.EncodeToString(h.Sum(nil)), Size: n}
+	dst := s.objectPath(file.Hash)
+	if _, err := os.Stat(dst); err == nil {
+		return file, false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return File{}, false, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return File{}, false, err
+	}
+	return file, true, nil
+}
+
+func (s *Store) objectPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash[:2], hash)
+}
+
+func (s *Store) manifestPath(project, id string) string {
+	return filepath.Join(s.dir, "projects", project, id+".json")
+}
+
+func (s *Store) save(snap *Snapshot) error {
+	p := s.manifestPath(snap.Project, snap.ID)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create project directory: %w", err)
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// List returns the snapshots of a project, newest first, without their
+// file lists
+func (s *Store) List(project string) ([]*Snapshot, error) {
+	if err := ValidateProject(project); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(s.dir, "projects", project))
+	if os.IsNotExist(err) {
+		return []*Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make([]*Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		snap, err := s.load(project, id)
+		if err != nil {
+			continue
+		}
+		snap.Files = nil
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].CreatedAt.After(snaps[j].CreatedAt) })
+	return snaps, nil
+}
+
+// Get returns a snapshot with its file list
+func (s *Store) Get(id string) (*Snapshot, error) {
+	if !idPattern.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	matches, err := filepath.Glob(filepath.Join(s.dir, "projects", "*", id+".json"))
+	if err != nil || len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	return s.load(filepath.Base(filepath.Dir(matches[0])), id)
+}
+
+func (s *Store) load(project, id string) (*Snapshot, error) {
+	data, err := os.ReadFile(s.manifestPath(project, id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+// Open returns the content of a file of a snapshot
+func (s *Store) Open(file File) (io.ReadCloser, error) {
+	return os.Open(s.objectPath(file.Hash))
+}
+
+// WriteZip writes the files of a snapshot to w as a zip archive
+func (s *Store) WriteZip(snap *Snapshot, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	paths := make([]string, 0, len(snap.Files))
+	for p := range snap.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		dst, err := zw.Create(p)
+		if err != nil {
+			return err
+		}
+		src, err := s.Open(snap.Files[p])
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		_, err = io.Copy(dst, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// Delete removes a snapshot and the file contents no other snapshot uses
+func (s *Store) Delete(id string) error {
+	snap, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.manifestPath(snap.Project, id)); err != nil {
+		return err
+	}
+
+	unused := make(map[string]bool)
+	for _, f := range snap.Files {
+		unused[f.Hash] = true
+	}
+	manifests, _ := filepath.Glob(filepath.Join(s.dir, "projects", "*", "*.json"))
+	for _, m := range manifests {
+		other, err := s.load(filepath.Base(filepath.Dir(m)), strings.TrimSuffix(filepath.Base(m), ".json"))
+		if err != nil {
+			// Keep everything rather than drop files a snapshot may use
+			return nil
+		}
+		for _, f := range other.Files {
+			delete(unused, f.Hash)
+		}
+	}
+	for hash := range unused {
+		os.Remove(s.objectPath(hash))
+	}
+	return nil
+}
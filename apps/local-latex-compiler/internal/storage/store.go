@@ -77,14 +77,27 @@ func (s *Store) Create(id string, opts build.BuildOptions) (*build.Build, error)
 	}
 
 	b := &build.Build{
-		ID:        id,
-		Status:    build.StatusPending,
-		Engine:    opts.Engine,
-		MainFile:  opts.MainFile,
-		DirPath:   buildDir,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		ID:              id,
+		Status:          build.StatusPending,
+		Engine:          opts.Engine,
+		MainFile:        opts.MainFile,
+		Target:          opts.Target,
+		Project:         opts.Project,
+		DirPath:         buildDir,
+		ShellEscape:     opts.ShellEscape,
+		ShellRestricted: opts.ShellRestricted,
+		AutoShell:       opts.AutoShell,
+		Env:             opts.Env,
+		OutDir:          opts.OutDir,
+		Reproducible:    opts.Reproducible,
+		Network:         opts.Network,
+		Timeout:         opts.Timeout,
+		Index:           opts.Index,
+		PDFX:            opts.PDFX,
+		Split:           opts.Split,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+		ExpiresAt:       time.Now().Add(24 * time.Hour),
 	}
 
 	if err := s.save(b); err != nil {
@@ -163,6 +176,38 @@ func (s *Store) List() []*build.Build {
 	return builds
 }
 
+// LatestCompleted returns the most recently updated build that produced a PDF
+func (s *Store) LatestCompleted() (*build.Build, error) {
+	return s.latestCompleted(func(*build.Build) bool { return true })
+}
+
+// LatestCompletedTarget is LatestCompleted among the builds of target in the
+// project with the given .treefrog.json key, or in any project for ""
+func (s *Store) LatestCompletedTarget(project, target string) (*build.Build, error) {
+	return s.latestCompleted(func(b *build.Build) bool {
+		return b.Target == target && (project == "" || b.Project == project)
+	})
+}
+
+func (s *Store) latestCompleted(match func(*build.Build) bool) (*build.Build, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var latest *build.Build
+	for _, b := range s.builds {
+		if b.Status != build.StatusCompleted || b.PDFPath == "" || !match(b) {
+			continue
+		}
+		if latest == nil || b.UpdatedAt.After(latest.UpdatedAt) {
+			latest = b
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no completed build")
+	}
+	return latest, nil
+}
+
 func (s *Store) ListExpired() []*build.Build {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
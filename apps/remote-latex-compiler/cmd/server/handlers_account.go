@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/alpha-og/treefrog/packages/go/validation"
+	"github.com/sirupsen/logrus"
+)
+
+var accountLog = logrus.WithField("component", "handlers/account")
+
+// AccountDeletionResponse is returned by the account deletion endpoints
+type AccountDeletionResponse struct {
+	Status              string     `json:"status"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// ExportUserDataHandler returns everything stored about the user as a zip:
+// profile, build metadata, audit entries and usage, one JSON file each
+// GET /api/user/export
+func ExportUserDataHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userStore, err := user.NewStore(dbInstance)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		profile, err := userStore.GetByID(userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		deletionAt, err := userStore.DeletionScheduledAt(userID)
+		if err != nil {
+			accountLog.WithError(err).WithField("user_id", userID).Error("Failed to get account deletion")
+			http.Error(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+
+		buildStore := build.NewStoreWithDB(dbInstance)
+		builds, err := buildStore.ExportByUser(userID)
+		if err != nil {
+			accountLog.WithError(err).WithField("user_id", userID).Error("Failed to list builds for export")
+			http.Error(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+		audit, err := auditLogger.ListByUser(userID)
+		if err != nil {
+			accountLog.WithError(err).WithField("user_id", userID).Error("Failed to list audit entries for export")
+			http.Error(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+		usage, err := build.NewLimitService(buildStore, userStore).GetUserUsage(userID)
+		if err != nil {
+			accountLog.WithError(err).WithField("user_id", userID).Error("Failed to get usage for export")
+			http.Error(w, "Failed to export data", http.StatusInternalServerError)
+			return
+		}
+
+		files := []struct {
+			name string
+			data interface{}
+		}{
+			{"profile.json", map[string]interface{}{
+				"user":                  profile,
+				"deletion_scheduled_at": deletionAt,
+			}},
+			{"builds.json", builds},
+			{"audit.json", audit},
+			{"usage.json", usage},
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=treefrog-export-%s.zip", time.Now().Format("2006-01-02")))
+		zw := zip.NewWriter(w)
+		for _, f := range files {
+			fw, err := zw.Create(f.name)
+			if err != nil {
+				accountLog.WithError(err).WithField("user_id", userID).Error("Failed to write export")
+				return
+			}
+			enc := json.NewEncoder(fw)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(f.data); err != nil {
+				accountLog.WithError(err).WithField("user_id", userID).Error("Failed to write export")
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			accountLog.WithError(err).WithField("user_id", userID).Error("Failed to write export")
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "data_exported",
+			ResourceType: "user",
+			ResourceID:   userID,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+	}
+}
+
+// DeleteAccountHandler schedules the deletion of the user's account. After
+// ACCOUNT_DELETION_GRACE the cleanup engine removes its builds, caches and
+// database rows; until then the deletion can be canceled.
+// DELETE /api/user/me
+func DeleteAccountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok || !validation.ValidateUUID(userID) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userStore, err := user.NewStore(dbInstance)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		userRec, err := userStore.GetByID(userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		// A deleted account cannot cancel its subscription, so it must go first
+		if userRec.RazorpaySubscriptionID != "" && userRec.SubscriptionCanceledAt == nil {
+			http.Error(w, "Cancel your subscription before deleting your account", http.StatusConflict)
+			return
+		}
+
+		deletionAt, err := userStore.DeletionScheduledAt(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if deletionAt == nil {
+			at := time.Now().Add(cfg.Cleanup.AccountGrace)
+			if err := userStore.ScheduleDeletion(userID, at); err != nil {
+				accountLog.WithError(err).WithField("user_id", userID).Error("Failed to schedule account deletion")
+				http.Error(w, "Failed to schedule deletion", http.StatusInternalServerError)
+				return
+			}
+			deletionAt = &at
+
+			auditLogger.Log(log.AuditEntry{
+				UserID:       userID,
+				Action:       "account_deletion_scheduled",
+				ResourceType: "user",
+				ResourceID:   userID,
+				Details:      fmt.Sprintf(`{"deletion_scheduled_at":%q}`, at.Format(time.RFC3339)),
+				IPAddress:    r.RemoteAddr,
+				UserAgent:    r.UserAgent(),
+				Status:       "success",
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(AccountDeletionResponse{
+			Status:              "deletion_scheduled",
+			DeletionScheduledAt: deletionAt,
+		})
+	}
+}
+
+// RestoreAccountHandler cancels a scheduled account deletion
+// POST /api/user/me/restore
+func RestoreAccountHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		userStore, err := user.NewStore(dbInstance)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		canceled, err := userStore.CancelDeletion(userID)
+		if err != nil {
+			accountLog.WithError(err).WithField("user_id", userID).Error("Failed to cancel account deletion")
+			http.Error(w, "Failed to cancel deletion", http.StatusInternalServerError)
+			return
+		}
+		if !canceled {
+			http.Error(w, "No deletion scheduled", http.StatusNotFound)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "account_deletion_canceled",
+			ResourceType: "user",
+			ResourceID:   userID,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AccountDeletionResponse{Status: "active"})
+	}
+}
@@ -2,9 +2,18 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/cleanup"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
@@ -13,12 +22,31 @@ import (
 var adminLog = logrus.WithField("component", "handlers/admin")
 
 func mustGetUserID(r *http.Request) string {
-	id, _ := r.Context().Value("userID").(string)
+	id, _ := auth.GetUserID(r)
 	return id
 }
 
+// AdminUserList is a page of the admin users list
+type AdminUserList struct {
+	Users      []*user.Summary `json:"users"`
+	Total      int             `json:"total"`
+	Page       int             `json:"page"`
+	PageSize   int             `json:"page_size"`
+	TotalPages int             `json:"total_pages"`
+}
+
+// ListUsersHandler lists users with their build count, build storage and
+// last activity. ?q= searches emails; ?tier=, ?subscription=, ?billing=,
+// ?active_after= and ?active_before= filter; ?sort= and ?order= order; and
+// ?page= and ?page_size= page the list.
 func ListUsersHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseUserListFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		userStore, err := user.NewStore(dbInstance)
 		if err != nil {
 			adminLog.WithError(err).Error("Failed to create user store")
@@ -26,17 +54,99 @@ func ListUsersHandler() http.HandlerFunc {
 			return
 		}
 
-		users, err := userStore.GetAll()
+		users, total, err := userStore.List(filter)
 		if err != nil {
+			adminLog.WithError(err).Error("Failed to list users")
 			http.Error(w, "Failed to list users", http.StatusInternalServerError)
 			return
 		}
+		if users == nil {
+			users = []*user.Summary{}
+		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(users)
+		json.NewEncoder(w).Encode(AdminUserList{
+			Users:      users,
+			Total:      total,
+			Page:       filter.Page,
+			PageSize:   filter.PageSize,
+			TotalPages: (total + filter.PageSize - 1) / filter.PageSize,
+		})
 	}
 }
 
+// parseUserListFilter reads the query of a users list request
+func parseUserListFilter(r *http.Request) (user.ListFilter, error) {
+	q := r.URL.Query()
+	filter := user.ListFilter{
+		Search:        strings.TrimSpace(q.Get("q")),
+		Tier:          q.Get("tier"),
+		Subscription:  q.Get("subscription"),
+		BillingStatus: q.Get("billing"),
+		Sort:          q.Get("sort"),
+		Page:          1,
+		PageSize:      20,
+	}
+	if p := q.Get("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			filter.Page = parsed
+		}
+	}
+	if ps := q.Get("page_size"); ps != "" {
+		if parsed, err := strconv.Atoi(ps); err == nil && parsed > 0 && parsed <= 100 {
+			filter.PageSize = parsed
+		}
+	}
+
+	if filter.Tier != "" && !slices.Contains(billing.PlanTiers, filter.Tier) {
+		return filter, fmt.Errorf("invalid tier (must be %s)", strings.Join(billing.PlanTiers, ", "))
+	}
+	switch filter.Subscription {
+	case "", user.SubscriptionActive, user.SubscriptionPaused, user.SubscriptionCanceled, user.SubscriptionNone:
+	default:
+		return filter, fmt.Errorf("invalid subscription (must be active, paused, canceled or none)")
+	}
+	switch filter.BillingStatus {
+	case "", user.BillingActive, user.BillingPastDue, user.BillingSuspended:
+	default:
+		return filter, fmt.Errorf("invalid billing (must be active, past_due or suspended)")
+	}
+	if _, ok := user.ListSorts[filter.Sort]; filter.Sort != "" && !ok {
+		return filter, fmt.Errorf("invalid sort (must be created, email, last_active, builds or storage)")
+	}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		return filter, fmt.Errorf("invalid order (must be asc or desc)")
+	}
+
+	var err error
+	if filter.ActiveAfter, err = parseListDate(q.Get("active_after")); err != nil {
+		return filter, fmt.Errorf("invalid active_after: %w", err)
+	}
+	if filter.ActiveBefore, err = parseListDate(q.Get("active_before")); err != nil {
+		return filter, fmt.Errorf("invalid active_before: %w", err)
+	}
+	return filter, nil
+}
+
+// parseListDate parses an RFC 3339 time or a YYYY-MM-DD date in UTC; ""
+// is no date
+func parseListDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, s); err != nil {
+			return nil, fmt.Errorf("must be RFC 3339 or YYYY-MM-DD")
+		}
+	}
+	return &t, nil
+}
+
 func GetUserHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := chi.URLParam(r, "id")
@@ -152,6 +262,14 @@ func SetUserAdminHandler() http.HandlerFunc {
 			return
 		}
 
+		// A demoted admin's impersonation tokens stop working anyway; revoke
+		// them so the audit trail says so
+		if !req.IsAdmin {
+			if _, err := impersonationStore.RevokeAdmin(userID); err != nil {
+				adminLog.WithError(err).WithField("user_id", userID).Error("Failed to revoke impersonation tokens")
+			}
+		}
+
 		adminLog.WithFields(logrus.Fields{
 			"admin_id": mustGetUserID(r),
 			"user_id":  userID,
@@ -221,3 +339,106 @@ func GetAdminStatsHandler() http.HandlerFunc {
 		json.NewEncoder(w).Encode(stats)
 	}
 }
+
+// ListDeadLettersHandler lists builds that failed on every allowed attempt
+func ListDeadLettersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if l := r.URL.Query().Get("limit"); l != "" {
+			if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 1000 {
+				limit = parsed
+			}
+		}
+
+		letters, err := buildQueue.GetStore().ListDeadLetters(limit)
+		if err != nil {
+			adminLog.WithError(err).Error("Failed to list dead letters")
+			http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(letters)
+	}
+}
+
+// RetryDeadLetterHandler requeues a dead-lettered build with fresh retries
+func RetryDeadLetterHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildID := chi.URLParam(r, "id")
+		if buildID == "" {
+			http.Error(w, "Build ID required", http.StatusBadRequest)
+			return
+		}
+
+		if err := buildQueue.RetryDeadLetter(buildID); err != nil {
+			if err.Error() == "build not found" {
+				http.Error(w, "Dead-lettered build not found", http.StatusNotFound)
+				return
+			}
+			adminLog.WithError(err).WithField("build_id", buildID).Error("Failed to retry dead letter")
+			http.Error(w, "Failed to retry build", http.StatusInternalServerError)
+			return
+		}
+
+		adminLog.WithFields(logrus.Fields{
+			"admin_id": mustGetUserID(r),
+			"build_id": buildID,
+		}).Info("Dead-lettered build requeued by admin")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"id": buildID, "status": "pending"})
+	}
+}
+
+// GetReconcileReportHandler returns the counts of the latest reconciliation
+// of the work dir against the build store
+// GET /api/admin/reconcile
+func GetReconcileReportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cleanupEngine == nil {
+			http.Error(w, "Cleanup is not running", http.StatusServiceUnavailable)
+			return
+		}
+		report := cleanupEngine.LastReconcile()
+		if report == nil {
+			http.Error(w, "No reconciliation has run yet", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// ReconcileHandler reconciles the work dir against the build store now
+// POST /api/admin/reconcile
+func ReconcileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cleanupEngine == nil {
+			http.Error(w, "Cleanup is not running", http.StatusServiceUnavailable)
+			return
+		}
+
+		report, err := cleanupEngine.Reconcile()
+		if errors.Is(err, cleanup.ErrBusy) {
+			http.Error(w, "Cleanup is running, try again later", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			adminLog.WithError(err).Error("Reconciliation failed")
+			http.Error(w, "Reconciliation failed", http.StatusInternalServerError)
+			return
+		}
+
+		adminLog.WithFields(logrus.Fields{
+			"admin_id":      mustGetUserID(r),
+			"orphaned_dirs": report.OrphanedDirs,
+			"missing_dirs":  report.MissingDirs,
+		}).Info("Reconciliation run by admin")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
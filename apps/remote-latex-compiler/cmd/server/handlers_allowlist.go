@@ -58,6 +58,12 @@ func AddToAllowlistHandler() http.HandlerFunc {
 			http.Error(w, "email required", http.StatusBadRequest)
 			return
 		}
+		email, err := user.NormalizeAllowlistEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Email = email
 
 		if req.Tier == "" {
 			req.Tier = "pro"
@@ -179,6 +185,11 @@ func ApplyTrialCouponHandler() http.HandlerFunc {
 			return
 		}
 
+		if err := couponStore.CheckCampaign(coupon); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		if coupon.OneTimeUse {
 			used, err := couponStore.HasUserUsedCoupon(userRec.ID, coupon.ID)
 			if err != nil {
@@ -204,8 +215,14 @@ func ApplyTrialCouponHandler() http.HandlerFunc {
 				trialDays = 14
 			}
 
+			if err := couponStore.IncrementUsage(coupon.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+
 			trial, err := trialStore.Create(userRec.ID, coupon.TierUpgrade, trialDays, coupon.Code)
 			if err != nil {
+				releaseCouponUsage(couponStore, coupon)
 				http.Error(w, "Failed to create trial", http.StatusInternalServerError)
 				return
 			}
@@ -215,10 +232,6 @@ func ApplyTrialCouponHandler() http.HandlerFunc {
 				allowlistLog.WithError(err).Error("Failed to update user tier")
 			}
 
-			if err := couponStore.IncrementUsage(coupon.ID); err != nil {
-				allowlistLog.WithError(err).Warn("Failed to increment coupon usage")
-			}
-
 			if coupon.OneTimeUse {
 				couponStore.RecordRedemption(userRec.ID, coupon.ID)
 			}
@@ -239,16 +252,18 @@ func ApplyTrialCouponHandler() http.HandlerFunc {
 			})
 
 		case user.CouponTypeUpgrade:
+			if err := couponStore.IncrementUsage(coupon.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+
 			userRec.Tier = coupon.TierUpgrade
 			if err := userStore.Update(userRec); err != nil {
+				releaseCouponUsage(couponStore, coupon)
 				http.Error(w, "Failed to upgrade tier", http.StatusInternalServerError)
 				return
 			}
 
-			if err := couponStore.IncrementUsage(coupon.ID); err != nil {
-				allowlistLog.WithError(err).Warn("Failed to increment coupon usage")
-			}
-
 			if coupon.OneTimeUse {
 				couponStore.RecordRedemption(userRec.ID, coupon.ID)
 			}
@@ -296,16 +311,18 @@ func ApplyTrialCouponHandler() http.HandlerFunc {
 				return
 			}
 
+			if err := couponStore.IncrementUsage(coupon.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+
 			checkoutURL, err := razorpayService.CreateSubscriptionWithCoupon(plan.ID, customerID, coupon.Code)
 			if err != nil {
+				releaseCouponUsage(couponStore, coupon)
 				http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
 				return
 			}
 
-			if err := couponStore.IncrementUsage(coupon.ID); err != nil {
-				allowlistLog.WithError(err).Warn("Failed to increment coupon usage")
-			}
-
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
 				"type":             "discount",
@@ -320,6 +337,14 @@ func ApplyTrialCouponHandler() http.HandlerFunc {
 	}
 }
 
+// releaseCouponUsage gives back a coupon use claimed for a redemption that
+// then failed
+func releaseCouponUsage(store *user.CouponStore, coupon *user.Coupon) {
+	if err := store.ReleaseUsage(coupon.ID); err != nil {
+		allowlistLog.WithError(err).WithField("coupon_id", coupon.ID).Error("Failed to release coupon usage")
+	}
+}
+
 func CheckAllowlistHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := auth.GetUserID(r)
@@ -355,6 +380,10 @@ func CheckAllowlistHandler() http.HandlerFunc {
 			return
 		}
 
+		if err := allowlistStore.Accept(entry.ID, userID); err != nil {
+			allowlistLog.WithError(err).WithField("user_id", userID).Warn("Failed to record allowlist acceptance")
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"allowlisted": true,
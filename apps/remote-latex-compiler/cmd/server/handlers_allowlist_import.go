@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+// maxAllowlistImportSize caps the size of an allowlist import
+const maxAllowlistImportSize = 5 << 20
+
+// AllowlistImportResponse summarizes an allowlist import
+type AllowlistImportResponse struct {
+	Created int                         `json:"created"`
+	Updated int                         `json:"updated"`
+	Skipped []user.AllowlistImportError `json:"skipped"`
+}
+
+// ImportAllowlistHandler adds emails and domain wildcards to the allowlist
+// from CSV or JSON. Invalid rows are skipped and reported; the others are
+// imported together. expires_in gives entries without expires_at an expiry,
+// as for invitations.
+// POST /api/admin/allowlist/import?format=csv|json&expires_in=720h
+func ImportAllowlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+			if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+				format = "csv"
+			}
+		}
+
+		var expiresAt *time.Time
+		if s := r.URL.Query().Get("expires_in"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+				return
+			}
+			at := time.Now().Add(d)
+			expiresAt = &at
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxAllowlistImportSize)
+		var entries []*user.AllowlistEntry
+		var skipped []user.AllowlistImportError
+		var err error
+		switch format {
+		case "csv":
+			entries, skipped, err = user.ParseAllowlistCSV(body)
+		case "json":
+			entries, skipped, err = user.ParseAllowlistJSON(body)
+		default:
+			http.Error(w, "format must be csv or json", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, entry := range entries {
+			if entry.ExpiresAt == nil {
+				entry.ExpiresAt = expiresAt
+			}
+		}
+
+		allowlistStore, err := user.NewAllowlistStore(dbInstance)
+		if err != nil {
+			allowlistLog.WithError(err).Error("Failed to create allowlist store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		created, updated, err := allowlistStore.Import(entries, userID)
+		if err != nil {
+			allowlistLog.WithError(err).Error("Failed to import allowlist")
+			http.Error(w, "Failed to import allowlist", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "allowlist_imported",
+			ResourceType: "allowlist",
+			Details:      fmt.Sprintf(`{"created":%d,"updated":%d,"skipped":%d}`, created, updated, len(skipped)),
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+		allowlistLog.WithFields(logrus.Fields{
+			"admin_id": userID,
+			"created":  created,
+			"updated":  updated,
+			"skipped":  len(skipped),
+		}).Info("Allowlist imported")
+
+		if skipped == nil {
+			skipped = []user.AllowlistImportError{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AllowlistImportResponse{Created: created, Updated: updated, Skipped: skipped})
+	}
+}
+
+// ExportAllowlistHandler downloads the active allowlist with how many users
+// accepted each entry, as CSV or JSON. Both formats can be imported again.
+// GET /api/admin/allowlist/export?format=csv|json
+func ExportAllowlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "json" {
+			http.Error(w, "format must be csv or json", http.StatusBadRequest)
+			return
+		}
+
+		allowlistStore, err := user.NewAllowlistStore(dbInstance)
+		if err != nil {
+			allowlistLog.WithError(err).Error("Failed to create allowlist store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		entries, err := allowlistStore.List()
+		if err != nil {
+			http.Error(w, "Failed to list allowlist", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=allowlist-%s.%s", time.Now().Format("2006-01-02"), format))
+		if format == "json" {
+			if entries == nil {
+				entries = []*user.AllowlistEntry{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entries)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		if err := user.WriteAllowlistCSV(w, entries); err != nil {
+			allowlistLog.WithError(err).Warn("Failed to write allowlist export")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
+	"github.com/alpha-og/treefrog/packages/go/synctex"
+)
+
+// buildLogText returns the build log of a build, decrypted if its artifacts
+// are encrypted at rest. Callers have checked the build belongs to the user.
+func buildLogText(b *buildpkg.Build) (string, error) {
+	if !b.Encrypted {
+		return logRedactor.Redact(b.BuildLog), nil
+	}
+	if artifactKeys == nil {
+		return "", fmt.Errorf("build %s is encrypted and ARTIFACT_ENCRYPTION_KEY is not set", b.ID)
+	}
+	text, err := artifactKeys.OpenText(b.UserID, b.BuildLog)
+	return logRedactor.Redact(text), err
+}
+
+// readArtifact returns an artifact file of a build, decrypted if its
+// artifacts are encrypted at rest
+func readArtifact(b *buildpkg.Build, path string) ([]byte, error) {
+	if !b.Encrypted {
+		return os.ReadFile(path)
+	}
+	if artifactKeys == nil {
+		return nil, fmt.Errorf("build %s is encrypted and ARTIFACT_ENCRYPTION_KEY is not set", b.ID)
+	}
+	return artifactKeys.ReadFile(b.UserID, path)
+}
+
+// presignArtifact returns a URL downloading a build artifact straight from
+// object storage, if the build's artifacts were uploaded to this region's
+// bucket. Encrypted artifacts are never uploaded, as they are decrypted
+// when served.
+func presignArtifact(b *buildpkg.Build, resource string) (string, bool) {
+	if artifactObjects == nil || b.Encrypted || b.Region != cfg.Region.Name || build.ArtifactPath(b, resource) == "" {
+		return "", false
+	}
+	stored, err := buildQueue.GetStore().ObjectStored(b.ID)
+	if err != nil {
+		buildLog.WithError(err).WithField("build_id", b.ID).Warn("Failed to check artifact storage")
+		return "", false
+	}
+	if !stored {
+		return "", false
+	}
+	filename := fmt.Sprintf("%s.%s", b.ID, getFileExtension(resource))
+	return artifactObjects.PresignGet(build.ArtifactKey(b.UserID, b.ID, resource), cfg.Storage.Objects.URLTTL, filename), true
+}
+
+// serveArtifactFile serves an artifact file of a build. Encrypted files are
+// decrypted in memory; the rest are served from disk. Artifacts never change
+// under their build ID, so clients may cache them for good and revalidate
+// with the ETag.
+func serveArtifactFile(w http.ResponseWriter, r *http.Request, b *buildpkg.Build, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	tfhttp.SetArtifactCaching(w, tfhttp.ArtifactETag(b.ID, filepath.Base(path), info), false)
+	if !b.Encrypted {
+		http.ServeFile(w, r, path)
+		return
+	}
+	// Don't decrypt what the client already has
+	if tfhttp.NotModified(w, r) {
+		return
+	}
+	data, err := readArtifact(b, path)
+	if err != nil {
+		buildLog.WithError(err).WithField("build_id", b.ID).Error("Failed to decrypt artifact")
+		http.Error(w, "Failed to decrypt artifact", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), bytes.NewReader(data))
+}
+
+// loadSyncTeX parses the SyncTeX data of a build. Encrypted data is parsed
+// on each request so the plaintext is never cached.
+func loadSyncTeX(b *buildpkg.Build) (*synctex.SyncTeXData, error) {
+	if !b.Encrypted {
+		return synctex.GetCachedSyncTeX(b.SyncTeXPath)
+	}
+	data, err := readArtifact(b, b.SyncTeXPath)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(b.SyncTeXPath, ".gz") {
+		return synctex.ParseSyncTeX(bytes.NewReader(data))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gz.Close()
+	return synctex.ParseSyncTeX(gz)
+}
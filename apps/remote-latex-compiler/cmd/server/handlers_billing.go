@@ -7,6 +7,7 @@ import (
 
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
 	"github.com/sirupsen/logrus"
@@ -14,6 +15,47 @@ import (
 
 var billingLog = logrus.WithField("component", "handlers/billing")
 
+// requireActiveBilling keeps suspended accounts read-only: their requests to
+// start builds or keep them are refused with 402 until a payment succeeds
+func requireActiveBilling(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, ok := auth.GetUserID(r); ok {
+			status, _, err := userStore.BillingStatus(userID)
+			if err != nil {
+				billingLog.WithError(err).WithField("user_id", userID).Warn("Failed to check billing status")
+			} else if status == user.BillingSuspended {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusPaymentRequired)
+				json.NewEncoder(w).Encode(build.LimitCheck{
+					Allowed: false,
+					Reason:  "subscription_suspended",
+					Message: "Your account is suspended for an unpaid subscription. Update your payment method to continue.",
+				})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// billingStatus describes the dunning state of an account for status
+// responses
+func billingStatus(userID string) map[string]interface{} {
+	status, since, err := userStore.BillingStatus(userID)
+	if err != nil {
+		billingLog.WithError(err).WithField("user_id", userID).Warn("Failed to get billing status")
+		return nil
+	}
+	info := map[string]interface{}{"status": status}
+	if since != nil {
+		info["past_due_since"] = since
+		if status == user.BillingPastDue {
+			info["grace_ends_at"] = dunning.GraceEnds(*since)
+		}
+	}
+	return info
+}
+
 // CreateSubscriptionHandler creates a subscription for a user
 func CreateSubscriptionHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -183,8 +225,9 @@ func GetSubscriptionStatusHandler() http.HandlerFunc {
 		if userRec.RazorpaySubscriptionID == "" {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]interface{}{
-				"tier":   userRec.Tier,
-				"status": "active",
+				"tier":    userRec.Tier,
+				"status":  "active",
+				"billing": billingStatus(userID),
 			})
 			return
 		}
@@ -211,6 +254,7 @@ func GetSubscriptionStatusHandler() http.HandlerFunc {
 			"total_count":   subscription.TotalCount,
 			"canceled_at":   userRec.SubscriptionCanceledAt,
 			"paused":        userRec.SubscriptionPaused,
+			"billing":       billingStatus(userID),
 		})
 	}
 }
@@ -290,16 +334,18 @@ func RedeemCouponHandler() http.HandlerFunc {
 			}
 		}
 
+		if err := couponStore.IncrementUsage(coupon.ID); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid coupon: %v", err), http.StatusConflict)
+			return
+		}
+
 		checkoutURL, err := razorpayService.CreateSubscriptionWithCoupon(plan.ID, customerID, req.CouponCode)
 		if err != nil {
+			releaseCouponUsage(couponStore, coupon)
 			http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
 			return
 		}
 
-		if err := couponStore.IncrementUsage(coupon.ID); err != nil {
-			billingLog.WithError(err).WithField("coupon_id", coupon.ID).Warn("Failed to increment coupon usage")
-		}
-
 		billingLog.WithFields(logrus.Fields{
 			"user_id":      userID,
 			"coupon_code":  req.CouponCode,
@@ -331,7 +377,7 @@ func RazorpayWebhookHandler() http.HandlerFunc {
 			return
 		}
 
-		webhookHandler := billing.NewWebhookHandler(razorpayService, userStore, logger)
+		webhookHandler := billing.NewWebhookHandler(razorpayService, userStore, dunning, logger)
 		webhookHandler.ServeHTTP(w, r)
 	}
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,11 +14,14 @@ import (
 	"time"
 
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/alpha-og/treefrog/packages/go/api"
 	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
-	"github.com/alpha-og/treefrog/packages/go/security"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
 	"github.com/alpha-og/treefrog/packages/go/validation"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -24,6 +30,149 @@ import (
 
 var buildLog = logrus.WithField("component", "handlers/build")
 
+// buildLimits returns the build option limits for the requesting user
+func buildLimits(r *http.Request) buildopts.Limits {
+	return tierLimits(auth.GetUserTier(r))
+}
+
+// tierLimits returns the build option limits of a tier.
+// Shell-escape allows arbitrary command execution during compilation, so it is
+// reserved for plans with ShellEscape (enterprise) and should only be used with
+// trusted documents. Other tiers get the restricted profile, which only runs
+// allowlisted commands; documents loading minted only get it unasked when
+// both the server and the plan allow it. Every build of a tier with a sandbox
+// runs in it, and is refused while that sandbox is not offered; other full
+// shell-escape builds run in the shell-escape sandbox when one is offered.
+func tierLimits(tier string) buildopts.Limits {
+	limits := buildopts.Limits{
+		MaxSourceSize:    cfg.Build.MaxFileSize,
+		MaxMainFileLen:   cfg.Build.MaxMainFileLen,
+		AllowShellEscape: billing.Plans[tier].ShellEscape,
+		AutoShellEscape:  cfg.Build.AutoShell && billing.Plans[tier].AutoShellEscape,
+		Sandboxes:        sandboxes,
+		DefaultTimeout:   cfg.Build.DefaultTimeout,
+		MinTimeout:       cfg.Build.MinTimeout,
+		MaxTimeout:       cfg.Build.MaxTimeout,
+	}
+	if max, ok := cfg.Build.TierTimeouts[tier]; ok {
+		limits.MaxTimeout = max
+	}
+	limits.Sandbox = cfg.Build.Sandbox.Tiers[tier]
+	if sandboxes[cfg.Build.Sandbox.ShellEscape] {
+		limits.ShellEscapeSandbox = cfg.Build.Sandbox.ShellEscape
+	}
+	return limits
+}
+
+// diskFullRetryAfter is the Retry-After, in seconds, of builds refused for
+// disk pressure; about as long as a cleanup cycle needs to free space
+const diskFullRetryAfter = 300
+
+// queueFullRetryAfter is the Retry-After, in seconds, of builds refused for
+// a full build queue
+const queueFullRetryAfter = 30
+
+// admitBuild refuses new builds with 503 while the build queue is full, and
+// with 507 while the work dir is past the critical disk threshold, rather
+// than letting them fail midway writing their files, and reports whether the
+// build may go ahead
+func admitBuild(w http.ResponseWriter, userID string) bool {
+	if buildQueue != nil && buildQueue.Full() {
+		refuseQueueFull(w, userID)
+		return false
+	}
+	if cleanupEngine == nil {
+		return true
+	}
+	stats, critical := cleanupEngine.DiskCritical()
+	if !critical {
+		return true
+	}
+
+	buildLog.WithFields(logrus.Fields{
+		"user_id": userID,
+		"usage":   fmt.Sprintf("%.1f%%", stats.UsedPercent),
+	}).Warn("Refusing build: disk usage critical")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(diskFullRetryAfter))
+	w.WriteHeader(http.StatusInsufficientStorage)
+	json.NewEncoder(w).Encode(build.LimitCheck{
+		Allowed: false,
+		Reason:  "insufficient_storage",
+		Message: "The build server is low on disk space. Please try again in a few minutes.",
+	})
+	return false
+}
+
+// refuseQueueFull answers 503 for a build the full build queue cannot take
+func refuseQueueFull(w http.ResponseWriter, userID string) {
+	buildLog.WithField("user_id", userID).Warn("Refusing build: build queue full")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(queueFullRetryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(build.LimitCheck{
+		Allowed: false,
+		Reason:  "queue_full",
+		Message: "The build server is busy. Please try again shortly.",
+	})
+}
+
+// queueBuild enqueues a newly created build. A build the queue refuses is
+// failed, and the error response written; it reports whether the build was
+// queued.
+func queueBuild(w http.ResponseWriter, store *build.Store, b *buildpkg.Build) bool {
+	err := buildQueue.Enqueue(b)
+	if err == nil {
+		return true
+	}
+	buildLog.WithError(err).WithField("build_id", b.ID).Error("Failed to queue build")
+	b.Status = buildpkg.StatusFailed
+	b.ErrorMessage = fmt.Sprintf("Failed to queue build: %v", err)
+	b.UpdatedAt = time.Now()
+	if err := store.Update(b); err != nil {
+		buildLog.WithError(err).WithField("build_id", b.ID).Error("Failed to update build status")
+	}
+	if errors.Is(err, build.ErrQueueFull) {
+		refuseQueueFull(w, b.UserID)
+		return false
+	}
+	http.Error(w, "Failed to queue build", http.StatusInternalServerError)
+	return false
+}
+
+// validateBuildOptions applies defaults to opts and validates them, writing
+// the error response when they are rejected. A request for full shell-escape
+// that the limits do not allow is downgraded to the restricted profile, and a
+// requested timeout is capped to the limits.
+func validateBuildOptions(w http.ResponseWriter, opts *buildopts.Options, limits buildopts.Limits) bool {
+	opts.Normalize()
+	if opts.RestrictShellEscape(limits) {
+		buildLog.Info("Shell-escape downgraded to restricted profile")
+	}
+	opts.ApplyAutoShell(limits)
+	opts.ApplySandbox(limits)
+	opts.ApplyTimeout(limits)
+	err := opts.Validate(limits)
+	if errors.Is(err, buildopts.ErrShellEscapeNotAllowed) {
+		http.Error(w, "Shell-escape feature requires enterprise tier", http.StatusForbidden)
+		return false
+	}
+	if errors.Is(err, buildopts.ErrSandboxNotAvailable) {
+		status := http.StatusBadRequest
+		if limits.Sandbox != "" {
+			// The plan's own sandbox is down; the client can only wait
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 func CreateBuildHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := auth.GetUserID(r)
@@ -38,43 +187,68 @@ func CreateBuildHandler() http.HandlerFunc {
 			return
 		}
 
-		if err := r.ParseMultipartForm(buildpkg.MaxFileSize); err != nil {
-			http.Error(w, fmt.Sprintf("File too large (max %dMB)", buildpkg.MaxFileSize/(1024*1024)), http.StatusBadRequest)
+		// New builds go to the workers and storage of the user's region
+		if routeToRegion(w, r, userRegion(userID)) {
+			return
+		}
+		if !admitBuild(w, userID) {
 			return
 		}
 
-		engine := buildpkg.Engine(r.FormValue("engine"))
-		mainFile := r.FormValue("main_file")
-		shellEscape := r.FormValue("shell_escape") == "true"
+		if err := r.ParseMultipartForm(buildpkg.MaxFileSize); err != nil {
+			status := http.StatusBadRequest
+			if tfhttp.BodyTooLarge(err) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, fmt.Sprintf("File too large (max %dMB)", cfg.Build.MaxFileSize/(1024*1024)), status)
+			return
+		}
 
-		if engine == "" {
-			engine = buildpkg.EnginePDFLaTeX
+		opts := buildopts.Options{
+			MainFile:     r.FormValue("main_file"),
+			Engine:       r.FormValue("engine"),
+			OutDir:       r.FormValue("out_dir"),
+			Reproducible: r.FormValue("reproducible") == "true",
+			Sandbox:      r.FormValue("sandbox"),
+			Index:        r.FormValue("index"),
 		}
-		if mainFile == "" {
-			mainFile = "main.tex"
+		opts.ShellEscape, opts.ShellRestricted = buildopts.ParseShellEscape(r.FormValue("shell_escape"))
+		env, err := buildopts.ParseEnv(r.FormValue("env"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		opts.Env = env
+		if opts.Timeout, err = buildopts.ParseTimeout(r.FormValue("timeout")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		notifyEmail := r.FormValue("notify_email") == "true"
+		mode := buildpkg.Mode(r.FormValue("mode"))
 
-		if !buildpkg.ValidEngines[string(engine)] {
-			http.Error(w, "Invalid engine", http.StatusBadRequest)
+		if mode != buildpkg.ModeDefault && mode != buildpkg.ModeSubfiles {
+			http.Error(w, "Invalid build mode", http.StatusBadRequest)
 			return
 		}
 
-		// Shell-escape is a significant security risk even for enterprise tier.
-		// It allows arbitrary command execution during LaTeX compilation.
-		// Enterprise users should use this feature with caution and only with trusted documents.
-		// WARNING: Documents using shell-escape can execute arbitrary commands on the server.
-		if shellEscape {
-			userTier := auth.GetUserTier(r)
-			if userTier != "enterprise" {
-				http.Error(w, "Shell-escape feature requires enterprise tier", http.StatusForbidden)
-				return
-			}
+		limits := buildLimits(r)
+		if !validateBuildOptions(w, &opts, limits) {
+			return
+		}
+		if opts.ShellEscape {
 			buildLog.WithField("user_id", userID).Warn("Shell-escape enabled for enterprise user - security risk")
 		}
+		engine, mainFile := buildpkg.Engine(opts.Engine), opts.MainFile
 
-		if security.HasPathTraversal(mainFile) {
-			http.Error(w, "Invalid main_file: path traversal not allowed", http.StatusBadRequest)
-			return
+		if notifyEmail {
+			if !billing.Plans[auth.GetUserTier(r)].EmailNotifications {
+				http.Error(w, "Email notifications require enterprise tier", http.StatusForbidden)
+				return
+			}
+			if notifier == nil {
+				http.Error(w, "Email notifications are not configured on this server", http.StatusBadRequest)
+				return
+			}
 		}
 
 		buildStore := build.NewStoreWithDB(dbInstance)
@@ -122,8 +296,8 @@ func CreateBuildHandler() http.HandlerFunc {
 		}
 		defer file.Close()
 
-		if fileHeader.Size > buildpkg.MaxFileSize {
-			http.Error(w, fmt.Sprintf("File too large (max %dMB)", buildpkg.MaxFileSize/(1024*1024)), http.StatusBadRequest)
+		if err := buildopts.ValidateSourceSize(fileHeader.Size, limits.MaxSourceSize); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -143,18 +317,28 @@ func CreateBuildHandler() http.HandlerFunc {
 		}
 
 		buildRec := &buildpkg.Build{
-			ID:             buildID,
-			UserID:         userID,
-			Status:         buildpkg.StatusPending,
-			Engine:         engine,
-			MainFile:       mainFile,
-			DirPath:        buildDir,
-			ShellEscape:    shellEscape,
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
-			ExpiresAt:      time.Now().Add(24 * time.Hour),
-			LastAccessedAt: time.Now(),
-			StorageBytes:   0,
+			ID:              buildID,
+			UserID:          userID,
+			Status:          buildpkg.StatusPending,
+			Engine:          engine,
+			MainFile:        mainFile,
+			DirPath:         buildDir,
+			ShellEscape:     opts.ShellEscape,
+			ShellRestricted: opts.ShellRestricted,
+			AutoShell:       opts.AutoShell,
+			Env:             opts.Env,
+			OutDir:          opts.OutDir,
+			Reproducible:    opts.Reproducible,
+			Sandbox:         opts.Sandbox,
+			Timeout:         int(opts.Timeout / time.Second),
+			Index:           opts.Index,
+			NotifyEmail:     notifyEmail,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			ExpiresAt:       time.Now().Add(billing.RetentionFor(auth.GetUserTier(r))),
+			LastAccessedAt:  time.Now(),
+			StorageBytes:    0,
+			Region:          cfg.Region.Name,
 		}
 
 		if err := buildRec.Validate(); err != nil {
@@ -167,13 +351,28 @@ func CreateBuildHandler() http.HandlerFunc {
 			http.Error(w, "Failed to create build", http.StatusInternalServerError)
 			return
 		}
+		if err := limitService.RecordOverage(userID, buildID, limitCheck); err != nil {
+			buildLog.WithError(err).WithField("build_id", buildID).Error("Failed to record overage build")
+		}
+
+		if !queueBuild(w, buildStore, buildRec) {
+			return
+		}
 
-		buildQueue.Enqueue(buildRec)
+		var subbuilds []string
+		if mode == buildpkg.ModeSubfiles {
+			subbuilds, err = enqueueSubdocuments(buildStore, limitService, buildRec, zipPath)
+			if err != nil {
+				buildLog.WithError(err).WithField("build_id", buildID).Warn("Failed to queue subdocument builds")
+			}
+		}
 
 		buildLog.WithFields(logrus.Fields{
-			"build_id": buildID,
-			"user_id":  userID,
-			"engine":   engine,
+			"build_id":  buildID,
+			"user_id":   userID,
+			"engine":    engine,
+			"subbuilds": len(subbuilds),
+			"overage":   limitCheck.Overage,
 		}).Info("Build created")
 
 		auditLogger.Log(log.AuditEntry{
@@ -187,14 +386,9 @@ func CreateBuildHandler() http.HandlerFunc {
 		})
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(buildpkg.BuildResponse{
-			ID:        buildRec.ID,
-			Status:    buildRec.Status,
-			Engine:    buildRec.Engine,
-			MainFile:  buildRec.MainFile,
-			CreatedAt: buildRec.CreatedAt,
-			ExpiresAt: buildRec.ExpiresAt,
-		})
+		response := api.NewBuildResponse(buildRec)
+		response.Subbuilds = subbuilds
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
@@ -241,14 +435,7 @@ func ListBuildsHandler() http.HandlerFunc {
 		totalPages := (total + pageSize - 1) / pageSize
 		var responses []buildpkg.BuildResponse
 		for _, b := range builds {
-			responses = append(responses, buildpkg.BuildResponse{
-				ID:        b.ID,
-				Status:    b.Status,
-				Engine:    b.Engine,
-				MainFile:  b.MainFile,
-				CreatedAt: b.CreatedAt,
-				ExpiresAt: b.ExpiresAt,
-			})
+			responses = append(responses, api.NewBuildResponse(b))
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -297,6 +484,12 @@ func GetBuildHandler() http.HandlerFunc {
 			buildLog.WithError(err).WithField("build_id", buildID).Warn("Failed to update last accessed time")
 		}
 
+		if buildRec.BuildLog, err = buildLogText(buildRec); err != nil {
+			buildLog.WithError(err).WithField("build_id", buildID).Error("Failed to decrypt build log")
+			http.Error(w, "Failed to decrypt build log", http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(buildRec)
 	}
@@ -331,24 +524,17 @@ func GetStatusHandler() http.HandlerFunc {
 			return
 		}
 
-		response := buildpkg.StatusResponse{
-			ID:        buildRec.ID,
-			Status:    buildRec.Status,
-			Engine:    buildRec.Engine,
-			CreatedAt: buildRec.CreatedAt,
-		}
-
-		if buildRec.Status == buildpkg.StatusCompleted {
-			response.Progress = 100
-			response.CompletedAt = &buildRec.UpdatedAt
-		}
+		response := buildpkg.NewStatusResponse(buildRec)
+		response.QueuedPosition = buildQueue.Position(buildRec)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	}
 }
 
-// GetLogHandler gets the build log
+// GetLogHandler gets the build log. While the build runs it serves what
+// latexmk has written so far; ?tail=N keeps the last N lines and
+// ?follow=true keeps streaming output until the build finishes.
 // Returns an http.HandlerFunc that handles GET /api/build/{id}/log
 func GetLogHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -377,9 +563,144 @@ func GetLogHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, buildRec.Region) {
+			return
+		}
+
+		tail, _ := strconv.Atoi(r.URL.Query().Get("tail"))
+		follow := r.URL.Query().Get("follow") == "true"
+
+		if buildRec.Status.Terminal() {
+			text, err := buildLogText(buildRec)
+			if err != nil {
+				buildLog.WithError(err).WithField("build_id", buildID).Error("Failed to decrypt build log")
+				http.Error(w, "Failed to decrypt build log", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			w.Write(buildpkg.TailLines([]byte(text), tail))
+			return
+		}
+
 		w.Header().Set("Content-Type", "text/plain")
+
+		live, err := buildpkg.ReadLiveLog(buildRec.DirPath)
+		if err != nil {
+			buildLog.WithError(err).WithField("build_id", buildID).Error("Failed to read live log")
+			http.Error(w, "Failed to read log", http.StatusInternalServerError)
+			return
+		}
+		if follow {
+			// A trailing partial line is streamed with the rest of it, so a
+			// secret split across the boundary is still redacted
+			live = live[:bytes.LastIndexByte(live, '\n')+1]
+		}
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(buildRec.BuildLog))
+		w.Write(buildpkg.TailLines(logRedactor.RedactBytes(live), tail))
+		if !follow {
+			return
+		}
+
+		followLog(w, r, buildRec.DirPath, int64(len(live)), func() bool {
+			b, err := buildStore.Get(buildID)
+			return err != nil || b.Status.Terminal()
+		})
+	}
+}
+
+// followLog streams a running build's live log from offset until the build
+// finishes or the client goes away. The stream outlasts the server's write
+// timeout, so the deadline is lifted for it.
+func followLog(w http.ResponseWriter, r *http.Request, buildDir string, offset int64, finished func() bool) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	rc.Flush()
+
+	out := logRedactor.Writer(flushWriter{w, rc})
+	err := buildpkg.FollowLog(r.Context(), out, buildDir, offset, finished)
+	if err != nil && r.Context().Err() == nil {
+		buildLog.WithError(err).Warn("Log stream ended early")
+	}
+	out.Flush()
+}
+
+// flushWriter flushes every write so streamed output reaches the client
+// right away
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.rc.Flush()
+	}
+	return n, err
+}
+
+// GetPagePreviewHandler serves a pre-rendered page image
+// Returns an http.HandlerFunc that handles GET /api/build/{id}/pages/{page}.png?size=thumb|full
+func GetPagePreviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		buildID := chi.URLParam(r, "id")
+		if buildID == "" {
+			http.Error(w, "Build ID required", http.StatusBadRequest)
+			return
+		}
+
+		page, err := strconv.Atoi(chi.URLParam(r, "page"))
+		if err != nil || page < 1 {
+			http.Error(w, "Invalid page number", http.StatusBadRequest)
+			return
+		}
+
+		size := r.URL.Query().Get("size")
+		if size == "" {
+			size = buildpkg.PreviewFull
+		}
+		if !buildpkg.ValidPreviewSizes[size] {
+			http.Error(w, "Invalid size (expected thumb or full)", http.StatusBadRequest)
+			return
+		}
+
+		buildStore := build.NewStoreWithDB(dbInstance)
+		buildRec, err := buildStore.Get(buildID)
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+
+		// STRICT USER ISOLATION
+		if buildRec.UserID != userID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		if routeToRegion(w, r, buildRec.Region) {
+			return
+		}
+
+		if buildRec.Status != buildpkg.StatusCompleted {
+			http.Error(w, "Build not completed", http.StatusConflict)
+			return
+		}
+
+		imagePath := buildpkg.PreviewPath(buildRec.DirPath, page, size)
+		if _, err := os.Stat(imagePath); err != nil {
+			http.Error(w, "Page preview not available", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		serveArtifactFile(w, r, buildRec, imagePath)
 	}
 }
 
@@ -412,6 +733,10 @@ func DeleteBuildHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, buildRec.Region) {
+			return
+		}
+
 		// Soft delete
 		buildRec.Status = buildpkg.StatusDeleted
 		buildRec.ExpiresAt = time.Now()
@@ -427,6 +752,13 @@ func DeleteBuildHandler() http.HandlerFunc {
 				}
 			}()
 			os.RemoveAll(buildRec.DirPath)
+			if artifactObjects != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				defer cancel()
+				if err := build.DeleteArtifacts(ctx, artifactObjects, buildRec.UserID, buildRec.ID); err != nil {
+					buildLog.WithError(err).Warn("Failed to delete build artifacts from object storage")
+				}
+			}
 			buildStore.Delete(buildRec.ID)
 		}()
 
@@ -468,6 +800,11 @@ func GetCurrentUserHandler() http.HandlerFunc {
 			http.Error(w, "User not found", http.StatusNotFound)
 			return
 		}
+		deletionAt, err := userStore.DeletionScheduledAt(userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -480,6 +817,9 @@ func GetCurrentUserHandler() http.HandlerFunc {
 				"paused":   userProfile.SubscriptionPaused,
 				"canceled": userProfile.SubscriptionCanceledAt != nil,
 			},
+			"deletion_scheduled_at": deletionAt,
+			"billing":               billingStatus(userID),
+			"region":                userRegion(userID),
 		})
 	}
 }
@@ -544,14 +884,6 @@ func GetSignedPDFURLHandler() http.HandlerFunc {
 			return
 		}
 
-		// Create signed URL signer
-		signer, err := auth.NewSignedURLSigner()
-		if err != nil {
-			logger.WithError(err).Error("Failed to create signed URL signer")
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
-		}
-
 		// Determine resource type from query parameter or default to PDF
 		resource := r.URL.Query().Get("resource")
 		if resource == "" {
@@ -565,6 +897,27 @@ func GetSignedPDFURLHandler() http.HandlerFunc {
 			return
 		}
 
+		// Artifacts in object storage are downloaded from the bucket
+		// rather than through this server
+		if objectURL, ok := presignArtifact(buildRecord, resource); ok {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"url":        objectURL,
+				"expires_in": cfg.Storage.Objects.URLTTL.Seconds(),
+				"build_id":   buildID,
+				"resource":   resource,
+			})
+			return
+		}
+
+		// Create signed URL signer
+		signer, err := auth.NewSignedURLSigner()
+		if err != nil {
+			logger.WithError(err).Error("Failed to create signed URL signer")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
 		// Generate signed URL
 		signedURL, err := signer.GenerateURL(buildID, resource, userID)
 		if err != nil {
@@ -623,6 +976,10 @@ func ServePDFHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, buildRecord.Region) {
+			return
+		}
+
 		// Verify signed URL
 		signer, err := auth.NewSignedURLSigner()
 		if err != nil {
@@ -638,44 +995,58 @@ func ServePDFHandler() http.HandlerFunc {
 			return
 		}
 
-		// Determine file path based on resource type
-		var filePath string
-		switch resource {
-		case "pdf":
-			filePath = buildRecord.PDFPath
-		case "synctex":
-			filePath = buildRecord.SyncTeXPath
-		case "log":
-			// BuildLog is text content, not a file path
-			if buildRecord.BuildLog == "" {
-				http.Error(w, "Log not available", http.StatusNotFound)
-				return
-			}
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.log", buildID))
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(buildRecord.BuildLog))
-			return
-		default:
-			http.Error(w, "Unknown resource", http.StatusBadRequest)
-			return
-		}
+		serveArtifact(w, r, buildRecord, resource)
+	}
+}
 
-		// Check if file exists
-		if filePath == "" {
-			http.Error(w, "File not available", http.StatusNotFound)
+// serveArtifact writes a build resource (pdf, synctex or log) after the
+// caller has authorized access
+func serveArtifact(w http.ResponseWriter, r *http.Request, buildRecord *buildpkg.Build, resource string) {
+	buildID := buildRecord.ID
+
+	// Determine file path based on resource type
+	var filePath string
+	switch resource {
+	case "pdf":
+		filePath = buildRecord.PDFPath
+	case "synctex":
+		filePath = buildRecord.SyncTeXPath
+	case "log":
+		// BuildLog is text content, not a file path
+		if buildRecord.BuildLog == "" {
+			http.Error(w, "Log not available", http.StatusNotFound)
 			return
 		}
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
+		text, err := buildLogText(buildRecord)
+		if err != nil {
+			logger.WithError(err).WithField("build_id", buildID).Error("Failed to decrypt build log")
+			http.Error(w, "Failed to decrypt build log", http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.log", buildID))
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(text))
+		return
+	default:
+		http.Error(w, "Unknown resource", http.StatusBadRequest)
+		return
+	}
 
-		// Set appropriate content type and serve file
-		w.Header().Set("Content-Type", getContentType(resource))
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", buildID, getFileExtension(resource)))
-		http.ServeFile(w, r, filePath)
+	// Check if file exists
+	if filePath == "" {
+		http.Error(w, "File not available", http.StatusNotFound)
+		return
 	}
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Set appropriate content type and serve file
+	w.Header().Set("Content-Type", getContentType(resource))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", buildID, getFileExtension(resource)))
+	serveArtifactFile(w, r, buildRecord, filePath)
 }
 
 // ServeSyncTeXHandler serves the SyncTeX data
@@ -706,6 +1077,10 @@ func ServeSyncTeXHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, buildRecord.Region) {
+			return
+		}
+
 		if buildRecord.SyncTeXPath == "" {
 			http.Error(w, "SyncTeX not available", http.StatusNotFound)
 			return
@@ -718,7 +1093,7 @@ func ServeSyncTeXHandler() http.HandlerFunc {
 
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.synctex.gz", buildID))
-		http.ServeFile(w, r, buildRecord.SyncTeXPath)
+		serveArtifactFile(w, r, buildRecord, buildRecord.SyncTeXPath)
 	}
 }
 
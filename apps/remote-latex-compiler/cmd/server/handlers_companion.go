@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/sirupsen/logrus"
+)
+
+var companionLog = logrus.WithField("component", "handlers/companion")
+
+const (
+	companionRecentBuilds = 5
+	companionMaxErrors    = 5
+	companionMaxWait      = 25 * time.Second
+	companionPollInterval = time.Second
+)
+
+// CompanionBuild is the compact build representation used by the companion API
+type CompanionBuild struct {
+	ID        string          `json:"id"`
+	Status    buildpkg.Status `json:"status"`
+	Engine    buildpkg.Engine `json:"engine"`
+	MainFile  string          `json:"main_file"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Pages     []string        `json:"pages,omitempty"`
+	Thumbs    []string        `json:"thumbs,omitempty"`
+	Errors    []string        `json:"errors,omitempty"`
+}
+
+// CompanionSummaryResponse is returned by GET /companion/summary
+type CompanionSummaryResponse struct {
+	TotalBuilds int               `json:"total_builds"`
+	Latest      *CompanionBuild   `json:"latest,omitempty"`
+	Recent      []*CompanionBuild `json:"recent"`
+	Cursor      string            `json:"cursor"`
+}
+
+// CompanionStatusResponse is returned by GET /companion/status
+type CompanionStatusResponse struct {
+	Changed bool            `json:"changed"`
+	Latest  *CompanionBuild `json:"latest,omitempty"`
+	Cursor  string          `json:"cursor"`
+}
+
+// toCompanionBuild converts a build record, attaching preview URLs and
+// error lines only when detail is requested
+func toCompanionBuild(b *buildpkg.Build, detail bool) *CompanionBuild {
+	cb := &CompanionBuild{
+		ID:        b.ID,
+		Status:    b.Status,
+		Engine:    b.Engine,
+		MainFile:  b.MainFile,
+		UpdatedAt: b.UpdatedAt,
+	}
+	if !detail {
+		return cb
+	}
+
+	if b.Status == buildpkg.StatusCompleted {
+		for page := 1; page <= buildpkg.PreviewCount(b.DirPath); page++ {
+			base := fmt.Sprintf("/companion/build/%s/pages/%d.png", b.ID, page)
+			cb.Pages = append(cb.Pages, base)
+			cb.Thumbs = append(cb.Thumbs, base+"?size="+buildpkg.PreviewThumb)
+		}
+	}
+	cb.Errors = extractLogErrors(b)
+	return cb
+}
+
+// extractLogErrors returns the first TeX error lines ("! ...") of a build,
+// falling back to the recorded error message
+func extractLogErrors(b *buildpkg.Build) []string {
+	text, err := buildLogText(b)
+	if err != nil {
+		companionLog.WithError(err).WithField("build_id", b.ID).Warn("Failed to decrypt build log")
+	}
+	var errs []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "!") {
+			errs = append(errs, strings.TrimSpace(strings.TrimPrefix(line, "!")))
+			if len(errs) == companionMaxErrors {
+				break
+			}
+		}
+	}
+	if len(errs) == 0 && b.ErrorMessage != "" {
+		errs = append(errs, b.ErrorMessage)
+	}
+	return errs
+}
+
+func companionCursor(b *buildpkg.Build) string {
+	if b == nil {
+		return ""
+	}
+	return b.ID + "@" + b.UpdatedAt.UTC().Format(time.RFC3339Nano)
+}
+
+// latestBuild returns the user's most recent top-level build, or nil
+func latestBuild(buildStore *build.Store, userID string) (*buildpkg.Build, error) {
+	builds, err := buildStore.ListByUser(userID, 1, 1)
+	if err != nil || len(builds) == 0 {
+		return nil, err
+	}
+	return builds[0], nil
+}
+
+// CreatePairingTokenHandler issues a pairing token for the companion app
+// POST /api/companion/pair
+func CreatePairingTokenHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Label string `json:"label"`
+		}
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Label) > 64 {
+			http.Error(w, "Label too long", http.StatusBadRequest)
+			return
+		}
+
+		token, pt, err := pairingStore.Create(userID, req.Label, auth.DefaultPairingTTL)
+		if err != nil {
+			companionLog.WithError(err).WithField("user_id", userID).Error("Failed to create pairing token")
+			http.Error(w, "Failed to create pairing token", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "pairing_token_created",
+			ResourceType: "pairing_token",
+			ResourceID:   pt.ID,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      token,
+			"id":         pt.ID,
+			"label":      pt.Label,
+			"expires_at": pt.ExpiresAt,
+		})
+	}
+}
+
+// ListPairingTokensHandler lists the user's active pairing tokens
+// GET /api/companion/pair
+func ListPairingTokensHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		tokens, err := pairingStore.List(userID)
+		if err != nil {
+			companionLog.WithError(err).WithField("user_id", userID).Error("Failed to list pairing tokens")
+			http.Error(w, "Failed to list pairing tokens", http.StatusInternalServerError)
+			return
+		}
+		if tokens == nil {
+			tokens = []*auth.PairingToken{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tokens": tokens,
+		})
+	}
+}
+
+// RevokePairingTokensHandler revokes all of the user's pairing tokens
+// DELETE /api/companion/pair
+func RevokePairingTokensHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		revoked, err := pairingStore.RevokeAll(userID)
+		if err != nil {
+			companionLog.WithError(err).WithField("user_id", userID).Error("Failed to revoke pairing tokens")
+			http.Error(w, "Failed to revoke pairing tokens", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "pairing_tokens_revoked",
+			ResourceType: "pairing_token",
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"revoked": revoked,
+		})
+	}
+}
+
+// CompanionSummaryHandler returns a compact overview of the user's builds
+// GET /companion/summary
+func CompanionSummaryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		buildStore := build.NewStoreWithDB(dbInstance)
+		builds, err := buildStore.ListByUser(userID, 1, companionRecentBuilds)
+		if err != nil {
+			companionLog.WithError(err).WithField("user_id", userID).Error("Failed to list builds")
+			http.Error(w, "Failed to get summary", http.StatusInternalServerError)
+			return
+		}
+
+		total, err := buildStore.CountByUser(userID)
+		if err != nil {
+			companionLog.WithError(err).WithField("user_id", userID).Error("Failed to count builds")
+			http.Error(w, "Failed to get summary", http.StatusInternalServerError)
+			return
+		}
+
+		response := CompanionSummaryResponse{
+			TotalBuilds: total,
+			Recent:      []*CompanionBuild{},
+		}
+		for i, b := range builds {
+			cb := toCompanionBuild(b, i == 0)
+			if i == 0 {
+				response.Latest = cb
+				response.Cursor = companionCursor(b)
+			}
+			response.Recent = append(response.Recent, cb)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// CompanionStatusHandler long-polls for changes to the user's latest build.
+// It returns as soon as the latest build differs from the cursor the client
+// last saw, or with changed=false once the wait expires.
+// GET /companion/status?cursor=<cursor>&wait=<seconds>
+func CompanionStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		cursor := r.URL.Query().Get("cursor")
+
+		wait := companionMaxWait
+		if v := r.URL.Query().Get("wait"); v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs < 0 {
+				http.Error(w, "Invalid wait", http.StatusBadRequest)
+				return
+			}
+			wait = time.Duration(secs) * time.Second
+		}
+		if wait > companionMaxWait {
+			wait = companionMaxWait
+		}
+		// Leave headroom so the response is written before the server's write deadline
+		if limit := cfg.Server.WriteTimeout - 2*time.Second; cfg.Server.WriteTimeout > 0 && wait > limit {
+			wait = limit
+		}
+
+		buildStore := build.NewStoreWithDB(dbInstance)
+		deadline := time.NewTimer(wait)
+		defer deadline.Stop()
+		ticker := time.NewTicker(companionPollInterval)
+		defer ticker.Stop()
+
+		for {
+			latest, err := latestBuild(buildStore, userID)
+			if err != nil {
+				companionLog.WithError(err).WithField("user_id", userID).Error("Failed to get latest build")
+				http.Error(w, "Failed to get status", http.StatusInternalServerError)
+				return
+			}
+
+			current := companionCursor(latest)
+			if current != cursor {
+				response := CompanionStatusResponse{Changed: true, Cursor: current}
+				if latest != nil {
+					response.Latest = toCompanionBuild(latest, true)
+				}
+				writeCompanionStatus(w, response)
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-deadline.C:
+				writeCompanionStatus(w, CompanionStatusResponse{Cursor: current})
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+func writeCompanionStatus(w http.ResponseWriter, response CompanionStatusResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var couponLog = logrus.WithField("component", "handlers/coupons")
+
+// CreateCampaignResponse is a new campaign with the codes generated for it
+type CreateCampaignResponse struct {
+	*user.CouponCampaign
+	CouponCodes []string `json:"coupon_codes"`
+}
+
+// CreateCouponCampaignHandler creates a coupon campaign and generates its
+// codes from the code pattern
+// POST /api/admin/coupon-campaigns
+func CreateCouponCampaignHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var campaign user.CouponCampaign
+		if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := campaign.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := billing.Plans[campaign.PlanID]; campaign.PlanID != "" && !ok {
+			http.Error(w, "Invalid plan", http.StatusBadRequest)
+			return
+		}
+		campaign.CreatedBy = userID
+		campaign.Stats = nil
+
+		couponStore, err := user.NewCouponStore(dbInstance)
+		if err != nil {
+			couponLog.WithError(err).Error("Failed to create coupon store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		codes, err := couponStore.CreateCampaign(&campaign)
+		if err != nil {
+			couponLog.WithError(err).Error("Failed to create coupon campaign")
+			http.Error(w, "Failed to create campaign", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "coupon_campaign_created",
+			ResourceType: "coupon_campaign",
+			ResourceID:   campaign.ID,
+			Details:      fmt.Sprintf(`{"name":%q,"codes":%d}`, campaign.Name, len(codes)),
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+		couponLog.WithFields(logrus.Fields{
+			"admin_id":    userID,
+			"campaign_id": campaign.ID,
+			"codes":       len(codes),
+		}).Info("Coupon campaign created")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(CreateCampaignResponse{CouponCampaign: &campaign, CouponCodes: codes})
+	}
+}
+
+// ListCouponCampaignsHandler lists coupon campaigns with their redemption
+// stats
+// GET /api/admin/coupon-campaigns
+func ListCouponCampaignsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		couponStore, err := user.NewCouponStore(dbInstance)
+		if err != nil {
+			couponLog.WithError(err).Error("Failed to create coupon store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		campaigns, err := couponStore.ListCampaigns()
+		if err != nil {
+			couponLog.WithError(err).Error("Failed to list coupon campaigns")
+			http.Error(w, "Failed to list campaigns", http.StatusInternalServerError)
+			return
+		}
+		if campaigns == nil {
+			campaigns = []*user.CouponCampaign{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(campaigns)
+	}
+}
+
+// GetCouponCampaignHandler returns a campaign with its redemption stats and
+// the use of each of its coupons. With format=csv it downloads the codes
+// for distribution.
+// GET /api/admin/coupon-campaigns/{id}
+func GetCouponCampaignHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		couponStore, err := user.NewCouponStore(dbInstance)
+		if err != nil {
+			couponLog.WithError(err).Error("Failed to create coupon store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		campaign, err := couponStore.GetCampaign(id)
+		if err != nil {
+			http.Error(w, "Campaign not found", http.StatusNotFound)
+			return
+		}
+		coupons, err := couponStore.ListCampaignCoupons(id)
+		if err != nil {
+			couponLog.WithError(err).WithField("campaign_id", id).Error("Failed to list campaign coupons")
+			http.Error(w, "Failed to list coupons", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", campaignFileName(campaign.Name)))
+			fmt.Fprintln(w, "code,used_count,is_active")
+			for _, coupon := range coupons {
+				fmt.Fprintf(w, "%s,%d,%t\n", coupon.Code, coupon.UsedCount, coupon.IsActive)
+			}
+			return
+		}
+
+		if coupons == nil {
+			coupons = []*user.Coupon{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"campaign": campaign,
+			"coupons":  coupons,
+		})
+	}
+}
+
+// DisableCouponCampaignHandler deactivates a campaign and its coupons
+// POST /api/admin/coupon-campaigns/{id}/disable
+func DisableCouponCampaignHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		couponStore, err := user.NewCouponStore(dbInstance)
+		if err != nil {
+			couponLog.WithError(err).Error("Failed to create coupon store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := couponStore.DisableCampaign(id); err != nil {
+			if err.Error() == "campaign not found" {
+				http.Error(w, "Campaign not found", http.StatusNotFound)
+				return
+			}
+			couponLog.WithError(err).WithField("campaign_id", id).Error("Failed to disable coupon campaign")
+			http.Error(w, "Failed to disable campaign", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       mustGetUserID(r),
+			Action:       "coupon_campaign_disabled",
+			ResourceType: "coupon_campaign",
+			ResourceID:   id,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+		couponLog.WithFields(logrus.Fields{
+			"admin_id":    mustGetUserID(r),
+			"campaign_id": id,
+		}).Info("Coupon campaign disabled")
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// campaignFileName turns a campaign name into a download file name
+func campaignFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, name)
+	return "coupons-" + strings.Trim(name, "-")
+}
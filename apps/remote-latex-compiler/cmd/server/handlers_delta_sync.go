@@ -1,20 +1,20 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/packages/go/api"
 	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
 	"github.com/alpha-og/treefrog/packages/go/security"
 	"github.com/alpha-og/treefrog/packages/go/validation"
 	"github.com/go-chi/chi/v5"
@@ -23,37 +23,9 @@ import (
 
 var deltaLog = logrus.WithField("component", "handlers/delta-sync")
 
-// DeltaSyncInitRequest initializes delta-sync for a build
-type DeltaSyncInitRequest struct {
-	ProjectID     string            `json:"projectId"`
-	ProjectName   string            `json:"projectName"`
-	MainFile      string            `json:"mainFile"`
-	Engine        string            `json:"engine"`
-	ShellEscape   bool              `json:"shellEscape"`
-	FileChecksums map[string]string `json:"fileChecksums"` // path -> checksum
-}
-
-// DeltaSyncInitResponse returns existing cached files
-type DeltaSyncInitResponse struct {
-	BuildID       string                            `json:"buildId"`
-	ExistingFiles map[string]map[string]interface{} `json:"existingFiles"` // path -> {checksum, size}
-	FilesToUpload []string                          `json:"filesToUpload"` // files that need to be uploaded
-}
-
-// FileMetadata stores file info for caching
-type FileMetadata struct {
-	Checksum string `json:"checksum"`
-	Size     int64  `json:"size"`
-	ModTime  string `json:"modTime"`
-}
-
-// ProjectCache stores the cache for a project
-type ProjectCache struct {
-	ProjectID   string                  `json:"projectId"`
-	LastBuildID string                  `json:"lastBuildId"`
-	UpdatedAt   string                  `json:"updatedAt"`
-	Files       map[string]FileMetadata `json:"files"`
-}
+// maxManifestSize caps the file manifest of a delta-sync init, room for the
+// paths and hashes of tens of thousands of files
+const maxManifestSize = 16 * 1024 * 1024
 
 // InitDeltaSyncHandler initializes a delta-sync build
 // POST /api/builds/init
@@ -71,8 +43,20 @@ func InitDeltaSyncHandler() http.HandlerFunc {
 			return
 		}
 
-		var req DeltaSyncInitRequest
+		// The project cache lives in the region its builds go to
+		if routeToRegion(w, r, userRegion(userID)) {
+			return
+		}
+		if !admitBuild(w, userID) {
+			return
+		}
+
+		var req api.DeltaSyncInitRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if tfhttp.BodyTooLarge(err) {
+				http.Error(w, "Manifest too large", http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
@@ -82,8 +66,8 @@ func InitDeltaSyncHandler() http.HandlerFunc {
 			return
 		}
 
-		if security.HasPathTraversal(req.MainFile) {
-			http.Error(w, "Invalid main file path", http.StatusBadRequest)
+		opts := req.Options()
+		if !validateBuildOptions(w, &opts, buildLimits(r)) {
 			return
 		}
 
@@ -101,53 +85,48 @@ func InitDeltaSyncHandler() http.HandlerFunc {
 			return
 		}
 
-		cacheFile := filepath.Join(workDir, userID, fmt.Sprintf(".cache_%s.json", sanitizeProjectID(req.ProjectID)))
-		projectCache := ProjectCache{
-			ProjectID: req.ProjectID,
-			Files:     make(map[string]FileMetadata),
-		}
-
-		if data, err := os.ReadFile(cacheFile); err == nil {
-			if err := json.Unmarshal(data, &projectCache); err != nil {
-				deltaLog.WithError(err).Warn("Failed to parse cache file, starting fresh")
-			}
-		}
-
 		existingFilesResponse := make(map[string]map[string]interface{})
 		var filesToUpload []string
 
+		// Any blob already in the shared store can be reused, whichever
+		// build or user uploaded it first
 		for clientPath, clientChecksum := range req.FileChecksums {
 			if security.HasPathTraversal(clientPath) {
 				continue
 			}
 
-			if cachedMeta, exists := projectCache.Files[clientPath]; exists {
-				if cachedMeta.Checksum == clientChecksum {
-					existingFilesResponse[clientPath] = map[string]interface{}{
-						"checksum": cachedMeta.Checksum,
-						"size":     cachedMeta.Size,
-					}
-				} else {
-					filesToUpload = append(filesToUpload, clientPath)
+			if blobStore.Has(clientChecksum) {
+				existingFilesResponse[clientPath] = map[string]interface{}{
+					"checksum": clientChecksum,
 				}
 			} else {
 				filesToUpload = append(filesToUpload, clientPath)
 			}
 		}
 
+		lastBuildID, err := blobStore.LastBuildID(userID, req.ProjectID)
+		if err != nil {
+			deltaLog.WithError(err).Warn("Failed to look up previous project build")
+		}
+
+		existingDir := ""
+		if lastBuildID != "" {
+			existingDir = filepath.Join(workDir, userID, lastBuildID)
+		}
+
 		// Store build ID in context for upload handler
 		buildContextFile := filepath.Join(buildDir, ".build_context.json")
 		contextData, _ := json.Marshal(map[string]interface{}{
 			"projectId":   req.ProjectID,
 			"projectName": req.ProjectName,
 			"buildId":     buildID,
-			"existingDir": filepath.Join(workDir, userID, projectCache.LastBuildID),
+			"existingDir": existingDir,
 		})
 		if err := os.WriteFile(buildContextFile, contextData, 0644); err != nil {
 			deltaLog.WithError(err).Warn("Failed to write build context file")
 		}
 
-		response := DeltaSyncInitResponse{
+		response := api.DeltaSyncInitResponse{
 			BuildID:       buildID,
 			ExistingFiles: existingFilesResponse,
 			FilesToUpload: filesToUpload,
@@ -165,16 +144,6 @@ func InitDeltaSyncHandler() http.HandlerFunc {
 	}
 }
 
-// DeltaSyncUploadRequest contains metadata for uploaded files
-type DeltaSyncUploadRequest struct {
-	ProjectID    string            `json:"projectId"`
-	CachedFiles  map[string]string `json:"cachedFiles"` // path -> checksum of cached files to reuse
-	MainFile     string            `json:"mainFile"`
-	Engine       string            `json:"engine"`
-	ShellEscape  bool              `json:"shellEscape"`
-	NewChecksums map[string]string `json:"newChecksums"` // checksums for newly uploaded files
-}
-
 // UploadDeltaSyncFilesHandler handles file uploads for delta-sync builds
 // POST /api/builds/{buildId}/upload
 func UploadDeltaSyncFilesHandler() http.HandlerFunc {
@@ -191,6 +160,10 @@ func UploadDeltaSyncFilesHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, userRegion(userID)) {
+			return
+		}
+
 		buildID := chi.URLParam(r, "buildId")
 		if buildID == "" {
 			http.Error(w, "Build ID required", http.StatusBadRequest)
@@ -198,17 +171,30 @@ func UploadDeltaSyncFilesHandler() http.HandlerFunc {
 		}
 
 		if err := r.ParseMultipartForm(buildpkg.MaxFileSize); err != nil {
-			http.Error(w, "Form too large", http.StatusBadRequest)
+			if tfhttp.BodyTooLarge(err) {
+				http.Error(w, "Form too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid form", http.StatusBadRequest)
 			return
 		}
 
 		metadataStr := r.FormValue("metadata")
-		var metadata DeltaSyncUploadRequest
+		var metadata api.DeltaSyncUploadRequest
 		if err := json.Unmarshal([]byte(metadataStr), &metadata); err != nil {
 			http.Error(w, "Invalid metadata", http.StatusBadRequest)
 			return
 		}
 
+		opts := metadata.Options()
+		if !validateBuildOptions(w, &opts, buildLimits(r)) {
+			return
+		}
+		metadata.MainFile, metadata.Engine = opts.MainFile, opts.Engine
+		metadata.ShellEscape, metadata.ShellRestricted = opts.ShellEscape, opts.ShellRestricted
+		metadata.OutDir, metadata.Sandbox = opts.OutDir, opts.Sandbox
+		metadata.Timeout, metadata.Index = int(opts.Timeout/time.Second), opts.Index
+
 		workDir := os.Getenv("COMPILER_WORKDIR")
 		if workDir == "" {
 			workDir = "/tmp/treefrog-builds"
@@ -228,7 +214,7 @@ func UploadDeltaSyncFilesHandler() http.HandlerFunc {
 			metadata.ProjectID = buildContext.ProjectID
 		}
 
-		newFiles := make(map[string]FileMetadata)
+		manifest := make(map[string]string)
 		fileCount := 0
 
 		for _, fileHeader := range r.MultipartForm.File["files"] {
@@ -250,132 +236,83 @@ func UploadDeltaSyncFilesHandler() http.HandlerFunc {
 				continue
 			}
 
-			filePath := filepath.Join(buildDir, relPath)
-			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-				file.Close()
-				continue
-			}
-
-			dst, err := os.Create(filePath)
+			checksum, _, err := blobStore.Put(file)
+			file.Close()
 			if err != nil {
-				file.Close()
-				deltaLog.WithError(err).WithField("path", filePath).Error("Failed to create file")
+				deltaLog.WithError(err).WithField("path", relPath).Error("Failed to store uploaded file")
 				continue
 			}
 
-			// Compute checksum while copying
-			hasher := sha256.New()
-			writer := io.MultiWriter(dst, hasher)
-
-			_, err = io.Copy(writer, file)
-			file.Close()
-			dst.Close()
+			if expected, ok := metadata.NewChecksums[relPath]; ok && expected != checksum {
+				deltaLog.WithFields(logrus.Fields{
+					"path":     relPath,
+					"expected": expected,
+					"actual":   checksum,
+				}).Warn("Checksum mismatch for uploaded file")
+			}
 
-			if err != nil {
-				deltaLog.WithError(err).WithField("path", relPath).Error("Failed to copy file")
+			if err := blobStore.CopyTo(checksum, filepath.Join(buildDir, relPath)); err != nil {
+				deltaLog.WithError(err).WithField("path", relPath).Error("Failed to write file to build")
 				continue
 			}
 
-			checksum := hex.EncodeToString(hasher.Sum(nil))
-			newFiles[relPath] = FileMetadata{
-				Checksum: checksum,
-				Size:     fileHeader.Size,
-				ModTime:  time.Now().Format(time.RFC3339),
-			}
+			manifest[relPath] = checksum
 			fileCount++
 		}
 
-		// Verify checksums if provided
-		if metadata.NewChecksums != nil {
-			for path, expectedChecksum := range metadata.NewChecksums {
-				if meta, exists := newFiles[path]; exists {
-					if meta.Checksum != expectedChecksum {
-						deltaLog.WithFields(logrus.Fields{
-							"path":     path,
-							"expected": expectedChecksum,
-							"actual":   meta.Checksum,
-						}).Warn("Checksum mismatch for uploaded file")
-					}
-				}
+		// Materialize cached files from the shared blob store
+		cachedReused := 0
+		for relPath, checksum := range metadata.CachedFiles {
+			if security.HasPathTraversal(relPath) {
+				continue
 			}
-		}
-
-		// Copy cached files from previous build
-		if buildContext.ExistingDir != "" && len(metadata.CachedFiles) > 0 {
-			for relPath, expectedChecksum := range metadata.CachedFiles {
-				if security.HasPathTraversal(relPath) {
-					continue
-				}
-
-				srcPath := filepath.Join(buildContext.ExistingDir, relPath)
-				dstPath := filepath.Join(buildDir, relPath)
-
-				srcPath = filepath.Clean(srcPath)
-				existingDirClean := filepath.Clean(buildContext.ExistingDir)
-				if !strings.HasPrefix(srcPath, existingDirClean+string(os.PathSeparator)) && srcPath != existingDirClean {
-					deltaLog.WithField("path", relPath).Warn("Skipping file with path traversal outside existing dir")
-					continue
-				}
-
-				if data, err := os.ReadFile(srcPath); err == nil {
-					actualChecksum := computeFileChecksum(data)
-					if actualChecksum == expectedChecksum {
-						os.MkdirAll(filepath.Dir(dstPath), 0755)
-						os.WriteFile(dstPath, data, 0644)
-						fileCount++
-					} else {
-						deltaLog.WithFields(logrus.Fields{
-							"path":     relPath,
-							"expected": expectedChecksum,
-							"actual":   actualChecksum,
-						}).Warn("Cached file checksum mismatch, skipping")
-					}
-				}
+			if _, uploaded := manifest[relPath]; uploaded {
+				continue
 			}
-		}
 
-		// Update project cache with new files
-		cacheFile := filepath.Join(workDir, userID, fmt.Sprintf(".cache_%s.json", sanitizeProjectID(metadata.ProjectID)))
-		projectCache := ProjectCache{
-			ProjectID:   metadata.ProjectID,
-			LastBuildID: buildID,
-			UpdatedAt:   time.Now().Format(time.RFC3339),
-			Files:       make(map[string]FileMetadata),
-		}
+			if err := blobStore.CopyTo(checksum, filepath.Join(buildDir, relPath)); err != nil {
+				deltaLog.WithError(err).WithField("path", relPath).Warn("Cached file unavailable, skipping")
+				continue
+			}
 
-		if data, err := os.ReadFile(cacheFile); err == nil {
-			json.Unmarshal(data, &projectCache.Files)
+			manifest[relPath] = checksum
+			cachedReused++
+			fileCount++
 		}
 
-		for path, meta := range newFiles {
-			projectCache.Files[path] = meta
+		// Carry forward aux/bbl/toc from the previous successful build of this project
+		auxCarried := 0
+		if buildContext.ExistingDir != "" {
+			auxCarried = carryForwardAuxFiles(userID, buildContext.ExistingDir, buildDir, metadata)
 		}
 
-		for path, checksum := range metadata.CachedFiles {
-			if _, exists := projectCache.Files[path]; !exists {
-				projectCache.Files[path] = FileMetadata{
-					Checksum: checksum,
-					ModTime:  time.Now().Format(time.RFC3339),
-				}
+		if metadata.ProjectID != "" {
+			if err := blobStore.SetProjectFiles(userID, metadata.ProjectID, buildID, manifest); err != nil {
+				deltaLog.WithError(err).WithField("project_id", metadata.ProjectID).Warn("Failed to update project manifest")
 			}
 		}
 
-		if cacheData, err := json.MarshalIndent(projectCache, "", "  "); err == nil {
-			os.WriteFile(cacheFile, cacheData, 0644)
-		}
-
 		// Create build record
 		buildRec := &buildpkg.Build{
-			ID:          buildID,
-			UserID:      userID,
-			Status:      buildpkg.StatusPending,
-			Engine:      buildpkg.Engine(metadata.Engine),
-			MainFile:    metadata.MainFile,
-			DirPath:     buildDir,
-			ShellEscape: metadata.ShellEscape,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-			ExpiresAt:   time.Now().Add(24 * time.Hour),
+			ID:              buildID,
+			UserID:          userID,
+			Status:          buildpkg.StatusPending,
+			Engine:          buildpkg.Engine(metadata.Engine),
+			MainFile:        metadata.MainFile,
+			DirPath:         buildDir,
+			ShellEscape:     metadata.ShellEscape,
+			ShellRestricted: metadata.ShellRestricted,
+			AutoShell:       opts.AutoShell,
+			Env:             metadata.Env,
+			OutDir:          metadata.OutDir,
+			Reproducible:    metadata.Reproducible,
+			Sandbox:         metadata.Sandbox,
+			Timeout:         metadata.Timeout,
+			Index:           metadata.Index,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			ExpiresAt:       time.Now().Add(billing.RetentionFor(auth.GetUserTier(r))),
+			Region:          cfg.Region.Name,
 		}
 
 		if err := buildRec.Validate(); err != nil {
@@ -390,34 +327,137 @@ func UploadDeltaSyncFilesHandler() http.HandlerFunc {
 			return
 		}
 
-		buildQueue.Enqueue(buildRec)
+		if !queueBuild(w, buildStore, buildRec) {
+			return
+		}
 
 		deltaLog.WithFields(logrus.Fields{
 			"build_id":       buildID,
 			"files_received": fileCount,
-			"cached_reused":  len(metadata.CachedFiles),
+			"cached_reused":  cachedReused,
+			"aux_carried":    auxCarried,
 		}).Info("Delta-sync files uploaded, build queued")
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"buildId":       buildID,
-			"filesReceived": fileCount,
-			"cachedReused":  len(metadata.CachedFiles),
-			"status":        "queued",
+		json.NewEncoder(w).Encode(api.DeltaSyncUploadResponse{
+			BuildID:       buildID,
+			FilesReceived: fileCount,
+			CachedReused:  cachedReused,
+			AuxCarried:    auxCarried,
+			Status:        "queued",
 		})
 	}
 }
 
-func computeFileChecksum(data []byte) string {
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
+// carryForwardAuxFiles reuses intermediate files only when the previous build
+// succeeded with the same main file, engine and output directory, otherwise
+// stale aux data could break the next compile.
+func carryForwardAuxFiles(userID, existingDir, buildDir string, metadata api.DeltaSyncUploadRequest) int {
+	prevBuildID := filepath.Base(existingDir)
+	prev, err := build.NewStoreWithDB(dbInstance).Get(prevBuildID)
+	if err != nil || prev.UserID != userID {
+		return 0
+	}
+
+	if prev.Status != buildpkg.StatusCompleted ||
+		prev.MainFile != metadata.MainFile ||
+		string(prev.Engine) != metadata.Engine ||
+		buildpkg.OutDir(prev) != metadata.OutDir {
+		return 0
+	}
+
+	if prev.Encrypted && artifactKeys == nil {
+		return 0
+	}
+
+	copied, err := buildpkg.CarryForwardAuxFiles(existingDir, buildDir, metadata.OutDir)
+	if err != nil {
+		deltaLog.WithError(err).WithField("previous_build", prevBuildID).Warn("Failed to carry forward aux files")
+	}
+	if prev.Encrypted && copied > 0 {
+		// The previous build sealed its output directory; latexmk needs
+		// the aux files in the clear
+		outDir := filepath.Join(buildDir, filepath.FromSlash(buildpkg.OutDir(prev)))
+		if err := artifactKeys.OpenDir(userID, outDir); err != nil {
+			deltaLog.WithError(err).WithField("previous_build", prevBuildID).Warn("Failed to decrypt carried-forward aux files")
+			os.RemoveAll(outDir)
+			return 0
+		}
+	}
+	return copied
+}
+
+// GetUserCacheHandler reports the size of the user's delta-sync cache
+// GET /api/user/cache
+func GetUserCacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if routeToRegion(w, r, userRegion(userID)) {
+			return
+		}
+
+		stats, err := blobStore.UserCacheStats(userID)
+		if err != nil {
+			deltaLog.WithError(err).WithField("user_id", userID).Error("Failed to get cache stats")
+			http.Error(w, "Failed to get cache stats", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
 }
 
-func sanitizeProjectID(id string) string {
-	return strings.Map(func(r rune) rune {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
-			return r
+// PurgeUserCacheHandler drops all of the user's delta-sync project caches.
+// The next build of each project uploads every file again.
+// DELETE /api/user/cache
+func PurgeUserCacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
 		}
-		return '_'
-	}, id)
+
+		if !validation.ValidateUUID(userID) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if routeToRegion(w, r, userRegion(userID)) {
+			return
+		}
+
+		removed, err := blobStore.PurgeUser(userID)
+		if err != nil {
+			deltaLog.WithError(err).WithField("user_id", userID).Error("Failed to purge cache")
+			http.Error(w, "Failed to purge cache", http.StatusInternalServerError)
+			return
+		}
+
+		// Remove cache files left over from the per-project JSON cache
+		legacy, _ := filepath.Glob(filepath.Join(cfg.Build.WorkDir, userID, ".cache_*.json"))
+		for _, f := range legacy {
+			os.Remove(f)
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "cache_purged",
+			ResourceType: "cache",
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"projectsRemoved": removed,
+		})
+	}
 }
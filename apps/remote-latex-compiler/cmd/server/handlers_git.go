@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/gitsrc"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/alpha-og/treefrog/packages/go/api"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/validation"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var gitLog = logrus.WithField("component", "handlers/git")
+
+// gitCloneTimeout bounds how long a repository fetch may take
+const gitCloneTimeout = 2 * time.Minute
+
+// GitBuildRequest is the body of POST /api/build/from-git
+type GitBuildRequest struct {
+	RepoURL   string `json:"repo_url"`
+	Ref       string `json:"ref"`
+	DeployKey string `json:"deploy_key"`
+	gitBuildOptions
+}
+
+// gitBuildOptions are the build options of a GitBuildRequest. They have the
+// fields of api.DeltaSyncBuildOptions under snake_case names, so they
+// convert to it.
+type gitBuildOptions struct {
+	MainFile        string            `json:"main_file"`
+	Engine          string            `json:"engine"`
+	ShellEscape     bool              `json:"shell_escape"`
+	ShellRestricted bool              `json:"shell_restricted"`
+	Env             map[string]string `json:"env"`
+	OutDir          string            `json:"out_dir"`
+	Reproducible    bool              `json:"reproducible"`
+	Sandbox         string            `json:"sandbox"`
+	Timeout         int               `json:"timeout"` // seconds
+	Index           string            `json:"index"`
+}
+
+// CreateGitBuildHandler builds a project straight from a git repository.
+// The repository is cloned shallowly in the background, so the build is
+// returned as pending and fails with the git error if the clone does.
+// The deploy key is only used for the clone and is never stored.
+// Returns an http.HandlerFunc that handles POST /api/build/from-git
+func CreateGitBuildHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !validation.ValidateUUID(userID) {
+			gitLog.WithField("user_id", userID).Warn("Invalid user ID format")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if routeToRegion(w, r, userRegion(userID)) {
+			return
+		}
+		if !admitBuild(w, userID) {
+			return
+		}
+
+		var req GitBuildRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		opts := api.DeltaSyncBuildOptions(req.gitBuildOptions).Options()
+		if !validateBuildOptions(w, &opts, buildLimits(r)) {
+			return
+		}
+		if opts.ShellEscape {
+			gitLog.WithField("user_id", userID).Warn("Shell-escape enabled for enterprise user - security risk")
+		}
+
+		if err := gitsrc.ValidateURL(req.RepoURL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := gitsrc.ValidateRef(req.Ref); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		buildStore := build.NewStoreWithDB(dbInstance)
+		userStore, err := user.NewStore(dbInstance)
+		if err != nil {
+			gitLog.WithError(err).Error("Failed to create user store")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		limitService := build.NewLimitService(buildStore, userStore)
+
+		limitCheck, err := limitService.CanCreateBuild(userID)
+		if err != nil {
+			gitLog.WithError(err).WithField("user_id", userID).Error("Limit check failed")
+			http.Error(w, "Failed to check limits", http.StatusInternalServerError)
+			return
+		}
+
+		if !limitCheck.Allowed {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(limitCheck)
+			return
+		}
+
+		buildID := "bld_" + uuid.New().String()
+
+		workDir := os.Getenv("COMPILER_WORKDIR")
+		if workDir == "" {
+			workDir = "/tmp/treefrog-builds"
+		}
+		buildDir := filepath.Join(workDir, userID, buildID)
+
+		if err := os.MkdirAll(buildDir, 0755); err != nil {
+			gitLog.WithError(err).WithField("path", buildDir).Error("Failed to create build directory")
+			http.Error(w, "Failed to create build directory", http.StatusInternalServerError)
+			return
+		}
+
+		buildRec := &buildpkg.Build{
+			ID:              buildID,
+			UserID:          userID,
+			Status:          buildpkg.StatusPending,
+			Engine:          buildpkg.Engine(opts.Engine),
+			MainFile:        opts.MainFile,
+			DirPath:         buildDir,
+			ShellEscape:     opts.ShellEscape,
+			ShellRestricted: opts.ShellRestricted,
+			AutoShell:       opts.AutoShell,
+			Env:             opts.Env,
+			OutDir:          opts.OutDir,
+			Reproducible:    opts.Reproducible,
+			Sandbox:         opts.Sandbox,
+			Timeout:         int(opts.Timeout / time.Second),
+			Index:           opts.Index,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			ExpiresAt:       time.Now().Add(billing.RetentionFor(auth.GetUserTier(r))),
+			LastAccessedAt:  time.Now(),
+			Region:          cfg.Region.Name,
+		}
+
+		if err := buildRec.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid build: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := buildStore.Create(buildRec); err != nil {
+			gitLog.WithError(err).Error("Failed to create build record")
+			http.Error(w, "Failed to create build", http.StatusInternalServerError)
+			return
+		}
+		if err := limitService.RecordOverage(userID, buildID, limitCheck); err != nil {
+			gitLog.WithError(err).WithField("build_id", buildID).Error("Failed to record overage build")
+		}
+
+		src := gitsrc.Source{URL: req.RepoURL, Ref: req.Ref, DeployKey: req.DeployKey}
+		go cloneAndEnqueue(buildStore, buildRec, src)
+
+		gitLog.WithFields(logrus.Fields{
+			"build_id": buildID,
+			"user_id":  userID,
+			"repo":     req.RepoURL,
+			"ref":      req.Ref,
+			"overage":  limitCheck.Overage,
+		}).Info("Git build created")
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "build_created",
+			ResourceType: "build",
+			ResourceID:   buildID,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(api.NewBuildResponse(buildRec))
+	}
+}
+
+// cloneAndEnqueue fetches the repository into the build directory and queues
+// the build, or marks it failed when the clone does not succeed
+func cloneAndEnqueue(buildStore *build.Store, buildRec *buildpkg.Build, src gitsrc.Source) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCloneTimeout)
+	defer cancel()
+
+	fields := logrus.Fields{"build_id": buildRec.ID, "repo": src.URL}
+
+	commit, err := gitsrc.Clone(ctx, src, buildRec.DirPath, gitsrc.Options{
+		MaxBytes:       cfg.Build.MaxFileSize,
+		KnownHostsFile: cfg.Build.GitKnownHosts,
+	})
+	if err != nil {
+		gitLog.WithError(err).WithFields(fields).Warn("Repository clone failed")
+		buildRec.Status = buildpkg.StatusFailed
+		buildRec.ErrorMessage = fmt.Sprintf("Failed to fetch repository: %v", err)
+		buildRec.UpdatedAt = time.Now()
+		if err := buildStore.Update(buildRec); err != nil {
+			gitLog.WithError(err).WithFields(fields).Error("Failed to update build status")
+		}
+		return
+	}
+
+	gitLog.WithFields(fields).WithField("commit", commit).Info("Repository cloned")
+	if err := buildQueue.Enqueue(buildRec); err != nil {
+		gitLog.WithError(err).WithFields(fields).Error("Failed to queue build")
+		buildRec.Status = buildpkg.StatusFailed
+		buildRec.ErrorMessage = fmt.Sprintf("Failed to queue build: %v", err)
+		buildRec.UpdatedAt = time.Now()
+		if err := buildStore.Update(buildRec); err != nil {
+			gitLog.WithError(err).WithFields(fields).Error("Failed to update build status")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var impersonationLog = logrus.WithField("component", "handlers/impersonation")
+
+// maxImpersonationReason caps the reason recorded with an impersonation
+const maxImpersonationReason = 500
+
+// ImpersonateUserHandler mints a short-lived token acting as a user, so
+// support can reproduce what the user sees. The token carries no admin
+// rights and every request made with it is audited.
+// POST /api/admin/users/{id}/impersonate
+func ImpersonateUserHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID := chi.URLParam(r, "id")
+		if userID == "" {
+			http.Error(w, "User ID required", http.StatusBadRequest)
+			return
+		}
+		if userID == adminID {
+			http.Error(w, "Cannot impersonate yourself", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Reason string `json:"reason"`
+			TTL    string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Reason == "" {
+			http.Error(w, "A reason is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Reason) > maxImpersonationReason {
+			http.Error(w, "Reason too long", http.StatusBadRequest)
+			return
+		}
+		ttl := auth.DefaultImpersonationTTL
+		if req.TTL != "" {
+			parsed, err := time.ParseDuration(req.TTL)
+			if err != nil || parsed <= 0 || parsed > auth.MaxImpersonationTTL {
+				http.Error(w, fmt.Sprintf("Invalid ttl (must be a duration up to %s)", auth.MaxImpersonationTTL), http.StatusBadRequest)
+				return
+			}
+			ttl = parsed
+		}
+
+		target, err := userStore.GetByID(userID)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if target.IsAdmin {
+			http.Error(w, "Admins cannot be impersonated", http.StatusForbidden)
+			return
+		}
+
+		token, imp, err := impersonationStore.Create(adminID, userID, req.Reason, ttl)
+		if err != nil {
+			impersonationLog.WithError(err).WithField("user_id", userID).Error("Failed to create impersonation token")
+			http.Error(w, "Failed to create impersonation token", http.StatusInternalServerError)
+			return
+		}
+
+		details, _ := json.Marshal(map[string]interface{}{
+			"user_id":    userID,
+			"reason":     req.Reason,
+			"expires_at": imp.ExpiresAt,
+		})
+		auditLogger.Log(log.AuditEntry{
+			UserID:       adminID,
+			Action:       "impersonation_started",
+			ResourceType: "impersonation",
+			ResourceID:   imp.ID,
+			Details:      string(details),
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+		impersonationLog.WithFields(logrus.Fields{
+			"admin_id": adminID,
+			"user_id":  userID,
+			"expires":  imp.ExpiresAt,
+		}).Warn("Admin started impersonating user")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      token,
+			"id":         imp.ID,
+			"user_id":    userID,
+			"expires_at": imp.ExpiresAt,
+		})
+	}
+}
+
+// EndImpersonationHandler revokes the admin's impersonation tokens for a
+// user
+// DELETE /api/admin/users/{id}/impersonate
+func EndImpersonationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID := chi.URLParam(r, "id")
+
+		revoked, err := impersonationStore.Revoke(adminID, userID)
+		if err != nil {
+			impersonationLog.WithError(err).WithField("user_id", userID).Error("Failed to revoke impersonation tokens")
+			http.Error(w, "Failed to revoke impersonation tokens", http.StatusInternalServerError)
+			return
+		}
+
+		if revoked > 0 {
+			auditLogger.Log(log.AuditEntry{
+				UserID:       adminID,
+				Action:       "impersonation_ended",
+				ResourceType: "impersonation",
+				ResourceID:   userID,
+				Details:      fmt.Sprintf(`{"user_id":%q,"revoked":%d}`, userID, revoked),
+				IPAddress:    r.RemoteAddr,
+				UserAgent:    r.UserAgent(),
+				Status:       "success",
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+	}
+}
+
+// auditImpersonation records every request made with an impersonation
+// token, under the impersonated user and flagged with the admin behind it
+func auditImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		adminID, ok := auth.Impersonator(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		userID, _ := auth.GetUserID(r)
+		status := "success"
+		if rw.statusCode >= http.StatusBadRequest {
+			status = "failure"
+		}
+		auditLogger.Log(log.AuditEntry{
+			UserID:         userID,
+			Action:         "impersonated_request",
+			ResourceType:   "request",
+			ResourceID:     r.Method + " " + r.URL.Path,
+			Details:        fmt.Sprintf(`{"status":%d}`, rw.statusCode),
+			IPAddress:      r.RemoteAddr,
+			UserAgent:      r.UserAgent(),
+			Status:         status,
+			ImpersonatedBy: adminID,
+		})
+	})
+}
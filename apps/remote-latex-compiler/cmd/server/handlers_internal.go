@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/sirupsen/logrus"
+)
+
+var internalLog = logrus.WithField("component", "handlers/internal")
+
+// maxDrainWait bounds how long a drain request waits for running builds
+const maxDrainWait = 10 * time.Minute
+
+// QueueStatsHandler reports queue depth and worker utilization for
+// autoscalers. Returns an http.HandlerFunc that handles GET /internal/queue
+func QueueStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildQueue.Stats())
+	}
+}
+
+// ScaleWorkersHandler sets the number of workers. Surplus workers finish
+// their current build before they exit. Returns an http.HandlerFunc that
+// handles PUT /internal/queue/workers
+func ScaleWorkersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Workers int `json:"workers"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Workers < 0 || (cfg.Build.Autoscale.MaxWorkers > 0 && req.Workers > cfg.Build.Autoscale.MaxWorkers) {
+			http.Error(w, "Worker count out of range", http.StatusBadRequest)
+			return
+		}
+
+		err := buildQueue.Scale(req.Workers)
+		if errors.Is(err, build.ErrDraining) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		internalLog.WithField("workers", req.Workers).Info("Build queue scaled")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildQueue.Stats())
+	}
+}
+
+// DrainQueueHandler drains every worker before the instance is scaled
+// down, waiting up to ?timeout= (default and max 10m) for running builds.
+// Queued builds are released for other instances to requeue. Returns an http.HandlerFunc that handles
+// POST /internal/queue/drain
+func DrainQueueHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := maxDrainWait
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, "Invalid timeout", http.StatusBadRequest)
+				return
+			}
+			timeout = min(d, maxDrainWait)
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		internalLog.Info("Draining build queue")
+		err := buildQueue.Drain(ctx)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(buildQueue.Stats())
+	}
+}
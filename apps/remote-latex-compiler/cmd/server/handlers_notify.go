@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/notify"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var notifyLog = logrus.WithField("component", "handlers/notify")
+
+// initNotifier configures email notifications when an SMTP host is set
+func initNotifier() {
+	if cfg.Notify.SMTPHost == "" {
+		notifyLog.Info("Build email notifications disabled (NOTIFY_SMTP_HOST not set)")
+		return
+	}
+
+	smtpNotifier, err := notify.NewSMTPNotifier(notify.SMTPConfig{
+		Host:     cfg.Notify.SMTPHost,
+		Port:     cfg.Notify.SMTPPort,
+		Username: cfg.Notify.SMTPUsername,
+		Password: cfg.Notify.SMTPPassword,
+		From:     cfg.Notify.From,
+		Timeout:  cfg.Notify.SMTPTimeout,
+	})
+	if err != nil {
+		notifyLog.WithError(err).Error("Failed to configure build email notifications")
+		return
+	}
+	// Mail goes out in the background; builds and billing runs only queue it
+	notifyQueue = notify.NewQueue(smtpNotifier, cfg.Notify.QueueSize)
+	notifyQueue.OnBuildSent = auditBuildNotification
+	notifier = notifyQueue
+	buildQueue.OnFinish(notifyBuildFinished)
+	notifyLog.WithField("host", cfg.Notify.SMTPHost).Info("Build email notifications enabled")
+}
+
+// notifyBuildFinished emails the owner of a build that asked for it
+func notifyBuildFinished(b *buildpkg.Build, duration time.Duration) {
+	if !b.NotifyEmail || notifier == nil {
+		return
+	}
+
+	u, err := userStore.GetByID(b.UserID)
+	if err != nil {
+		notifyLog.WithError(err).WithField("build_id", b.ID).Warn("Cannot notify: user lookup failed")
+		return
+	}
+
+	n := notify.BuildFinished{
+		To:       u.Email,
+		UserID:   b.UserID,
+		BuildID:  b.ID,
+		MainFile: b.MainFile,
+		Status:   b.Status,
+		Duration: duration,
+		Message:  b.ErrorMessage,
+	}
+
+	resource := "pdf"
+	if b.Status != buildpkg.StatusCompleted {
+		resource = "log"
+	}
+	if link, err := signedArtifactLink(b, resource); err != nil {
+		notifyLog.WithError(err).WithField("build_id", b.ID).Warn("Failed to sign artifact link")
+	} else {
+		n.ArtifactURL = link
+	}
+
+	if err := notifier.BuildFinished(n); err != nil {
+		notifyLog.WithError(err).WithField("build_id", b.ID).Error("Failed to send build notification")
+	}
+}
+
+// auditBuildNotification records a delivered build notification
+func auditBuildNotification(n notify.BuildFinished) {
+	auditLogger.Log(log.AuditEntry{
+		UserID:       n.UserID,
+		Action:       "build_notification_sent",
+		ResourceType: "build",
+		ResourceID:   n.BuildID,
+		Status:       "success",
+	})
+}
+
+// signedArtifactLink returns an absolute link to a build resource that works
+// without a session, valid for the configured link TTL
+func signedArtifactLink(b *buildpkg.Build, resource string) (string, error) {
+	signer, err := auth.NewSignedURLSigner()
+	if err != nil {
+		return "", err
+	}
+	signer.URLExpiry = cfg.Notify.LinkTTL
+
+	token, err := signer.GenerateToken(b.ID, resource, b.UserID)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/artifact/%s/%s?token=%s", strings.TrimRight(cfg.Notify.PublicURL, "/"),
+		url.PathEscape(b.ID), url.PathEscape(resource), url.QueryEscape(token)), nil
+}
+
+// ServeSignedArtifactHandler serves a build resource to anyone holding a
+// valid signed token, such as a link in a notification email
+// Returns an http.HandlerFunc that handles GET /artifact/{id}/{resource}
+func ServeSignedArtifactHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildID := chi.URLParam(r, "id")
+		resource := chi.URLParam(r, "resource")
+
+		signer, err := auth.NewSignedURLSigner()
+		if err != nil {
+			logger.WithError(err).Error("Failed to create signed URL signer")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		data, err := signer.VerifyToken(r.URL.Query().Get("token"))
+		if err != nil || data.BuildID != buildID || data.Resource != resource {
+			http.Error(w, "Invalid or expired link", http.StatusForbidden)
+			return
+		}
+
+		buildRecord, err := buildQueue.GetStore().Get(buildID)
+		if err != nil || buildRecord.DeletedAt != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+		if buildRecord.UserID != data.UserID {
+			http.Error(w, "Invalid or expired link", http.StatusForbidden)
+			return
+		}
+
+		if routeToRegion(w, r, buildRecord.Region) {
+			return
+		}
+
+		serveArtifact(w, r, buildRecord, resource)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+var pinLog = logrus.WithField("component", "handlers/pin")
+
+// PinResponse is returned by the pin endpoints
+type PinResponse struct {
+	ID                string    `json:"id"`
+	Pinned            bool      `json:"pinned"`
+	ExpiresAt         time.Time `json:"expires_at,omitempty"`
+	PinnedBytes       int64     `json:"pinned_bytes"`
+	StorageLimitBytes int64     `json:"storage_limit_bytes"`
+}
+
+// ownedBuild loads a build and checks it belongs to the requesting user,
+// writing the error response when it does not
+func ownedBuild(w http.ResponseWriter, r *http.Request, buildStore *build.Store) (*buildpkg.Build, string, bool) {
+	userID, ok := auth.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+
+	buildRec, err := buildStore.Get(chi.URLParam(r, "id"))
+	if err != nil || buildRec.DeletedAt != nil {
+		http.Error(w, "Build not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	if buildRec.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, "", false
+	}
+	return buildRec, userID, true
+}
+
+// PinBuildHandler exempts a completed build from expiry and cleanup until it
+// is unpinned. Pinned builds may not exceed the tier's storage quota.
+// Returns an http.HandlerFunc that handles POST /api/build/{id}/pin
+func PinBuildHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildStore := build.NewStoreWithDB(dbInstance)
+		buildRec, userID, ok := ownedBuild(w, r, buildStore)
+		if !ok {
+			return
+		}
+
+		if buildRec.Status != buildpkg.StatusCompleted {
+			http.Error(w, "Only completed builds can be pinned", http.StatusBadRequest)
+			return
+		}
+
+		tier := auth.GetUserTier(r)
+		limit := int64(billing.Plans[tier].StorageGB) * 1024 * 1024 * 1024
+
+		pinnedBytes, err := buildStore.GetPinnedStorage(userID)
+		if err != nil {
+			pinLog.WithError(err).WithField("user_id", userID).Error("Failed to get pinned storage")
+			http.Error(w, "Failed to check storage quota", http.StatusInternalServerError)
+			return
+		}
+		if !buildRec.Pinned {
+			pinnedBytes += buildRec.StorageBytes
+			if pinnedBytes > limit {
+				http.Error(w, fmt.Sprintf("Pinned builds would exceed your %dGB storage quota", billing.Plans[tier].StorageGB),
+					http.StatusForbidden)
+				return
+			}
+		}
+
+		if err := buildStore.SetPinned(buildRec.ID, true, buildRec.ExpiresAt); err != nil {
+			pinLog.WithError(err).WithField("build_id", buildRec.ID).Error("Failed to pin build")
+			http.Error(w, "Failed to pin build", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "build_pinned",
+			ResourceType: "build",
+			ResourceID:   buildRec.ID,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PinResponse{
+			ID:                buildRec.ID,
+			Pinned:            true,
+			PinnedBytes:       pinnedBytes,
+			StorageLimitBytes: limit,
+		})
+	}
+}
+
+// UnpinBuildHandler returns a build to normal retention. Its expiry restarts
+// from now so an old build is not removed the moment it is unpinned.
+// Returns an http.HandlerFunc that handles DELETE /api/build/{id}/pin
+func UnpinBuildHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildStore := build.NewStoreWithDB(dbInstance)
+		buildRec, userID, ok := ownedBuild(w, r, buildStore)
+		if !ok {
+			return
+		}
+
+		tier := auth.GetUserTier(r)
+		expiresAt := buildRec.ExpiresAt
+		if buildRec.Pinned {
+			expiresAt = time.Now().Add(billing.RetentionFor(tier))
+		}
+		if err := buildStore.SetPinned(buildRec.ID, false, expiresAt); err != nil {
+			pinLog.WithError(err).WithField("build_id", buildRec.ID).Error("Failed to unpin build")
+			http.Error(w, "Failed to unpin build", http.StatusInternalServerError)
+			return
+		}
+
+		pinnedBytes, err := buildStore.GetPinnedStorage(userID)
+		if err != nil {
+			pinLog.WithError(err).WithField("user_id", userID).Warn("Failed to get pinned storage")
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "build_unpinned",
+			ResourceType: "build",
+			ResourceID:   buildRec.ID,
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PinResponse{
+			ID:                buildRec.ID,
+			Pinned:            false,
+			ExpiresAt:         expiresAt,
+			PinnedBytes:       pinnedBytes,
+			StorageLimitBytes: int64(billing.Plans[tier].StorageGB) * 1024 * 1024 * 1024,
+		})
+	}
+}
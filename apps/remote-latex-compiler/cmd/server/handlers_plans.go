@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+)
+
+// Plan describes the limits and features of a tier. It is built from the
+// plan definitions and build limits the server enforces, so clients can
+// show them without keeping their own copy.
+type Plan struct {
+	Tier              string       `json:"tier"`
+	Name              string       `json:"name"`
+	MonthlyBuilds     int          `json:"monthly_builds"` // -1 for unlimited
+	ConcurrentBuilds  int          `json:"concurrent_builds"`
+	StorageGB         int          `json:"storage_gb"`
+	RetentionHours    int          `json:"retention_hours"`
+	MaxTimeoutSeconds int          `json:"max_timeout_seconds"`
+	OveragePrice      int64        `json:"overage_price,omitempty"` // per build beyond monthly_builds, in paise
+	Currency          string       `json:"currency,omitempty"`
+	Features          PlanFeatures `json:"features"`
+}
+
+// PlanFeatures lists the optional features of a tier
+type PlanFeatures struct {
+	ShellEscape        bool   `json:"shell_escape"` // full shell-escape; otherwise the restricted profile
+	EmailNotifications bool   `json:"email_notifications"`
+	OverageBilling     bool   `json:"overage_billing"`   // builds past the quota are charged instead of refused
+	Sandbox            string `json:"sandbox,omitempty"` // sandbox every build of the tier runs in
+}
+
+// PlansResponse lists the plans from the cheapest, with the user's current
+// one
+type PlansResponse struct {
+	Current string `json:"current"`
+	Plans   []Plan `json:"plans"`
+}
+
+// planFor describes a tier as enforced by this instance
+func planFor(tier string) Plan {
+	config := billing.Plans[tier]
+	limits := tierLimits(tier)
+	plan := Plan{
+		Tier:              tier,
+		Name:              config.Name,
+		MonthlyBuilds:     config.MonthlyBuilds,
+		ConcurrentBuilds:  config.Concurrent,
+		StorageGB:         config.StorageGB,
+		RetentionHours:    int(billing.RetentionFor(tier) / time.Hour),
+		MaxTimeoutSeconds: int(limits.MaxTimeout / time.Second),
+		Features: PlanFeatures{
+			ShellEscape:        limits.AllowShellEscape,
+			EmailNotifications: config.EmailNotifications && notifier != nil,
+			OverageBilling:     config.OveragePrice > 0 && config.MonthlyBuilds != -1,
+			Sandbox:            limits.Sandbox,
+		},
+	}
+	if plan.Features.OverageBilling {
+		plan.OveragePrice = config.OveragePrice
+		plan.Currency = billing.Currency
+	}
+	return plan
+}
+
+// ListPlansHandler returns the limits and features of every tier
+// GET /api/plans
+func ListPlansHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := PlansResponse{Current: auth.GetUserTier(r)}
+		for _, tier := range billing.PlanTiers {
+			resp.Plans = append(resp.Plans, planFor(tier))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
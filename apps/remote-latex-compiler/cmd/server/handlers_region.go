@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+var regionLog = logrus.WithField("component", "handlers/region")
+
+// availableRegions lists the regions users may select, ordered by name
+func availableRegions() []string {
+	if cfg.Region.Name == "" {
+		return nil
+	}
+	regions := []string{cfg.Region.Name}
+	for name := range cfg.Region.URLs {
+		if name != cfg.Region.Name {
+			regions = append(regions, name)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// userRegion returns the region the user's new builds go to: the one they
+// selected, or this instance's
+func userRegion(userID string) string {
+	if cfg.Region.Name == "" {
+		return ""
+	}
+	userStore, err := user.NewStore(dbInstance)
+	if err != nil {
+		return cfg.Region.Name
+	}
+	region, err := userStore.Region(userID)
+	if err != nil || region == "" {
+		return cfg.Region.Name
+	}
+	return region
+}
+
+// regionProxyHeader marks requests proxied from another region's instance,
+// so that instances disagreeing about a user's region cannot loop
+const regionProxyHeader = "X-Treefrog-Proxied-From"
+
+var (
+	regionProxiesMu sync.Mutex
+	regionProxies   = map[string]*httputil.ReverseProxy{}
+)
+
+// regionProxy returns the reverse proxy to a region's instance
+func regionProxy(region, baseURL string) (*httputil.ReverseProxy, error) {
+	regionProxiesMu.Lock()
+	defer regionProxiesMu.Unlock()
+
+	if proxy, ok := regionProxies[region]; ok {
+		return proxy, nil
+	}
+	target, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL for region %s: %w", region, err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// Stream live logs and events as they arrive
+	proxy.FlushInterval = -1
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		regionLog.WithError(err).WithField("region", region).Error("Failed to proxy request to region")
+		http.Error(w, fmt.Sprintf("Region %s is not available", region), http.StatusBadGateway)
+	}
+	regionProxies[region] = proxy
+	return proxy, nil
+}
+
+// routeToRegion proxies requests for data of another region to that
+// region's instance, with their body and credentials. It reports whether
+// the request was answered.
+func routeToRegion(w http.ResponseWriter, r *http.Request, region string) bool {
+	if cfg.Region.Name == "" || region == "" || region == cfg.Region.Name {
+		return false
+	}
+	if from := r.Header.Get(regionProxyHeader); from != "" {
+		regionLog.WithFields(logrus.Fields{
+			"region": region,
+			"from":   from,
+		}).Warn("Refusing to proxy a proxied request again")
+		http.Error(w, fmt.Sprintf("Region %s is not served here", region), http.StatusMisdirectedRequest)
+		return true
+	}
+	baseURL, ok := cfg.Region.URLs[region]
+	if !ok {
+		regionLog.WithField("region", region).Warn("No URL configured for region")
+		http.Error(w, fmt.Sprintf("Region %s is not available", region), http.StatusServiceUnavailable)
+		return true
+	}
+	proxy, err := regionProxy(region, baseURL)
+	if err != nil {
+		regionLog.WithError(err).Error("Failed to proxy request to region")
+		http.Error(w, fmt.Sprintf("Region %s is not available", region), http.StatusServiceUnavailable)
+		return true
+	}
+	r.Header.Set(regionProxyHeader, cfg.Region.Name)
+	proxy.ServeHTTP(w, r)
+	return true
+}
+
+// RegionsResponse lists the regions a user may keep their data in
+type RegionsResponse struct {
+	Region    string   `json:"region"`    // the user's region
+	Available []string `json:"available"` // regions the user may select
+}
+
+// GetUserRegionHandler returns the user's region and the regions available
+// GET /api/user/region
+func GetUserRegionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RegionsResponse{
+			Region:    userRegion(userID),
+			Available: availableRegions(),
+		})
+	}
+}
+
+// SetUserRegionHandler selects the region the user's new builds and files
+// are kept in. Existing builds stay in their region until they expire.
+// PUT /api/user/region
+func SetUserRegionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Region string `json:"region"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		available := availableRegions()
+		if available == nil {
+			http.Error(w, "Regions are not enabled", http.StatusNotFound)
+			return
+		}
+		if !slices.Contains(available, req.Region) {
+			http.Error(w, fmt.Sprintf("Unknown region %q", req.Region), http.StatusBadRequest)
+			return
+		}
+
+		userStore, err := user.NewStore(dbInstance)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if err := userStore.SetRegion(userID, req.Region); err != nil {
+			regionLog.WithError(err).WithField("user_id", userID).Error("Failed to set region")
+			http.Error(w, "Failed to set region", http.StatusInternalServerError)
+			return
+		}
+
+		auditLogger.Log(log.AuditEntry{
+			UserID:       userID,
+			Action:       "region_changed",
+			ResourceType: "user",
+			ResourceID:   userID,
+			Details:      fmt.Sprintf(`{"region":%q}`, req.Region),
+			IPAddress:    r.RemoteAddr,
+			UserAgent:    r.UserAgent(),
+			Status:       "success",
+		})
+		regionLog.WithFields(logrus.Fields{
+			"user_id": userID,
+			"region":  req.Region,
+		}).Info("User region changed")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RegionsResponse{Region: req.Region, Available: available})
+	}
+}
+
+// RegionCapacityHandler reports the workers, queue depth and utilization of
+// every region. Configured regions without live instances are listed empty.
+// GET /api/admin/regions
+func RegionCapacityHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buildStore := build.NewStoreWithDB(dbInstance)
+		capacity, err := buildStore.ListRegionCapacity(time.Now().Add(-cfg.Build.Reaper.StaleAfter))
+		if err != nil {
+			adminLog.WithError(err).Error("Failed to list region capacity")
+			http.Error(w, "Failed to list region capacity", http.StatusInternalServerError)
+			return
+		}
+
+		for _, region := range availableRegions() {
+			if !slices.ContainsFunc(capacity, func(c build.RegionCapacity) bool { return c.Region == region }) {
+				capacity = append(capacity, build.RegionCapacity{Region: region})
+			}
+		}
+		sort.Slice(capacity, func(i, j int) bool { return capacity[i].Region < capacity[j].Region })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"region":  cfg.Region.Name,
+			"regions": capacity,
+		})
+	}
+}
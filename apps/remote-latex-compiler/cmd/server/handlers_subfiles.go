@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+var subfilesLog = logrus.WithField("component", "handlers/subfiles")
+
+// SubbuildStatus is the status of one subdocument build
+type SubbuildStatus struct {
+	ID       string          `json:"id"`
+	MainFile string          `json:"main_file"`
+	Status   buildpkg.Status `json:"status"`
+	Message  string          `json:"message,omitempty"`
+}
+
+// SubfilesStatusResponse aggregates the status of a parent build and its subdocuments
+type SubfilesStatusResponse struct {
+	ID        string           `json:"id"`
+	Status    buildpkg.Status  `json:"status"`
+	Total     int              `json:"total"`
+	Completed int              `json:"completed"`
+	Failed    int              `json:"failed"`
+	Builds    []SubbuildStatus `json:"builds"`
+}
+
+// enqueueSubdocuments detects subfiles/standalone documents in the parent's
+// sources and queues one child build per document. Each child gets its own
+// copy of the sources so the queue workers can compile them concurrently.
+// Children count against the user's monthly quota like other builds; once
+// it is used up the rest are left out.
+func enqueueSubdocuments(buildStore *build.Store, limitService *build.LimitService, parent *buildpkg.Build, zipPath string) ([]string, error) {
+	scanDir, err := os.MkdirTemp("", "treefrog-subfiles-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(scanDir)
+
+	if err := buildpkg.ExtractZip(zipPath, scanDir); err != nil {
+		return nil, fmt.Errorf("failed to extract sources: %w", err)
+	}
+
+	docs, err := buildpkg.DetectSubdocuments(scanDir, parent.MainFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan sources: %w", err)
+	}
+	if len(docs) > buildpkg.MaxSubdocuments {
+		docs = docs[:buildpkg.MaxSubdocuments]
+	}
+
+	var childIDs []string
+	for _, doc := range docs {
+		if buildopts.ValidateMainFile(doc) != nil {
+			continue
+		}
+
+		check, err := limitService.CanCreateSubbuild(parent.UserID)
+		if err != nil {
+			return childIDs, err
+		}
+		if !check.Allowed {
+			subfilesLog.WithField("build_id", parent.ID).WithField("reason", check.Reason).Warn("Subdocument builds left out over the build limit")
+			break
+		}
+
+		childID := "bld_" + uuid.New().String()
+		childDir := filepath.Join(filepath.Dir(parent.DirPath), childID)
+		if err := os.MkdirAll(childDir, 0755); err != nil {
+			return childIDs, err
+		}
+		if err := copySourceZip(zipPath, filepath.Join(childDir, "source.zip")); err != nil {
+			return childIDs, err
+		}
+
+		child := &buildpkg.Build{
+			ID:              childID,
+			UserID:          parent.UserID,
+			ParentID:        parent.ID,
+			Status:          buildpkg.StatusPending,
+			Engine:          parent.Engine,
+			MainFile:        doc,
+			DirPath:         childDir,
+			ShellEscape:     parent.ShellEscape,
+			ShellRestricted: parent.ShellRestricted,
+			AutoShell:       parent.AutoShell,
+			Env:             parent.Env,
+			OutDir:          parent.OutDir,
+			Reproducible:    parent.Reproducible,
+			Sandbox:         parent.Sandbox,
+			Timeout:         parent.Timeout,
+			Index:           parent.Index,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+			ExpiresAt:       parent.ExpiresAt,
+			LastAccessedAt:  time.Now(),
+			Region:          parent.Region,
+		}
+		if err := buildStore.Create(child); err != nil {
+			return childIDs, err
+		}
+		if err := limitService.RecordOverage(parent.UserID, childID, check); err != nil {
+			subfilesLog.WithError(err).WithField("build_id", childID).Error("Failed to record overage build")
+		}
+		if err := buildQueue.Enqueue(child); err != nil {
+			child.Status = buildpkg.StatusFailed
+			child.ErrorMessage = fmt.Sprintf("Failed to queue build: %v", err)
+			if err := buildStore.Update(child); err != nil {
+				subfilesLog.WithError(err).WithField("build_id", childID).Error("Failed to update build status")
+			}
+			return childIDs, err
+		}
+		childIDs = append(childIDs, childID)
+	}
+
+	return childIDs, nil
+}
+
+func copySourceZip(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// GetSubfilesStatusHandler reports the aggregate status of a build and its subdocument builds
+// GET /api/build/{id}/subfiles
+func GetSubfilesStatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		buildID := chi.URLParam(r, "id")
+		if buildID == "" {
+			http.Error(w, "Build ID required", http.StatusBadRequest)
+			return
+		}
+
+		buildStore := build.NewStoreWithDB(dbInstance)
+		parent, err := buildStore.Get(buildID)
+		if err != nil {
+			http.Error(w, "Build not found", http.StatusNotFound)
+			return
+		}
+
+		if parent.UserID != userID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		children, err := buildStore.ListChildren(buildID)
+		if err != nil {
+			subfilesLog.WithError(err).WithField("build_id", buildID).Error("Failed to list subdocument builds")
+			http.Error(w, "Failed to get subdocument builds", http.StatusInternalServerError)
+			return
+		}
+
+		response := SubfilesStatusResponse{ID: parent.ID}
+		for _, b := range append([]*buildpkg.Build{parent}, children...) {
+			response.Builds = append(response.Builds, SubbuildStatus{
+				ID:       b.ID,
+				MainFile: b.MainFile,
+				Status:   b.Status,
+				Message:  b.ErrorMessage,
+			})
+			switch b.Status {
+			case buildpkg.StatusCompleted:
+				response.Completed++
+			case buildpkg.StatusFailed:
+				response.Failed++
+			}
+		}
+		response.Total = len(response.Builds)
+
+		switch {
+		case response.Completed+response.Failed < response.Total:
+			response.Status = buildpkg.StatusCompiling
+		case response.Failed > 0:
+			response.Status = buildpkg.StatusFailed
+		default:
+			response.Status = buildpkg.StatusCompleted
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
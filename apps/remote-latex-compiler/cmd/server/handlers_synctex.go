@@ -9,7 +9,6 @@ import (
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
 	"github.com/alpha-og/treefrog/packages/go/security"
-	"github.com/alpha-og/treefrog/packages/go/synctex"
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
 )
@@ -42,6 +41,10 @@ func SyncTeXViewHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, buildRecord.Region) {
+			return
+		}
+
 		if buildRecord.SyncTeXPath == "" {
 			http.Error(w, "SyncTeX not available for this build", http.StatusNotFound)
 			return
@@ -76,7 +79,7 @@ func SyncTeXViewHandler() http.HandlerFunc {
 			}
 		}
 
-		data, err := synctex.GetCachedSyncTeX(buildRecord.SyncTeXPath)
+		data, err := loadSyncTeX(buildRecord)
 		if err != nil {
 			synctexLog.WithError(err).Error("Failed to parse synctex file")
 			http.Error(w, "Failed to parse SyncTeX data", http.StatusInternalServerError)
@@ -125,6 +128,10 @@ func SyncTeXEditHandler() http.HandlerFunc {
 			return
 		}
 
+		if routeToRegion(w, r, buildRecord.Region) {
+			return
+		}
+
 		if buildRecord.SyncTeXPath == "" {
 			http.Error(w, "SyncTeX not available for this build", http.StatusNotFound)
 			return
@@ -157,7 +164,7 @@ func SyncTeXEditHandler() http.HandlerFunc {
 			return
 		}
 
-		data, err := synctex.GetCachedSyncTeX(buildRecord.SyncTeXPath)
+		data, err := loadSyncTeX(buildRecord)
 		if err != nil {
 			synctexLog.WithError(err).Error("Failed to parse synctex file")
 			http.Error(w, "Failed to parse SyncTeX data", http.StatusInternalServerError)
@@ -3,23 +3,32 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/atrest"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/blob"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/cleanup"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/config"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/db"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/log"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/notify"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/objstore"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/rate"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/alpha-og/treefrog/packages/go/api"
 	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	tfhttp "github.com/alpha-og/treefrog/packages/go/http"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
@@ -27,14 +36,24 @@ import (
 )
 
 var (
-	dbInstance    *sql.DB
-	logger        *logrus.Logger
-	auditLogger   *log.AuditLogger
-	buildQueue    *build.Queue
-	userStore     *user.Store
-	cleanupEngine *cleanup.Engine
-	rateLimiter   *rate.Limiter
-	cfg           *config.Config
+	dbInstance         *sql.DB
+	logger             *logrus.Logger
+	auditLogger        *log.AuditLogger
+	buildQueue         *build.Queue
+	userStore          *user.Store
+	blobStore          *blob.Store
+	pairingStore       *auth.PairingStore
+	impersonationStore *auth.ImpersonationStore
+	cleanupEngine      *cleanup.Engine
+	rateLimiter        *rate.Limiter
+	notifier           notify.Notifier
+	notifyQueue        *notify.Queue // nil when email notifications are off
+	dunning            *billing.Dunning
+	cfg                *config.Config
+	artifactKeys       *atrest.Keyring    // nil when artifacts are stored in the clear
+	artifactObjects    *objstore.Store    // nil when artifacts are only kept on local disk
+	logRedactor        *buildpkg.Redactor // nil when build logs are kept as printed
+	sandboxes          map[string]bool    // sandboxes builds may run in
 )
 
 func init() {
@@ -70,21 +89,77 @@ func main() {
 		cfg.Billing.RazorpayKeyID,
 		cfg.Billing.RazorpayKeySecret,
 	)
-	_ = razorpaySvc
+
+	logRedactor, err = buildpkg.LoadRedactor(cfg.Build.LogRedact, cfg.Build.LogRedactFile)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load build log redaction patterns")
+	}
 
 	logger.Info("Initializing native compiler")
 	nativeCompiler, err := buildpkg.NewNativeCompiler(cfg.Build.WorkDir)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to initialize native compiler")
 	}
-	defer nativeCompiler.Close()
+	nativeCompiler.SetRedactor(logRedactor)
 	logger.WithField("workDir", cfg.Build.WorkDir).Info("Native compiler initialized")
 
+	compiler := buildpkg.NewSandboxCompiler(nativeCompiler)
+	defer compiler.Close()
+	initSandboxes(compiler)
+
 	logger.Info("Initializing build queue")
-	buildStore := build.NewStoreWithDB(dbInstance)
-	buildQueue = build.NewQueue(cfg.Build.DefaultWorkers, nativeCompiler, buildStore)
+	buildStore := build.NewStoreWithDB(dbInstance).ForRegion(cfg.Region.Name)
+	buildQueue = build.NewQueue(cfg.Build.DefaultWorkers, compiler, buildStore, cfg.Build.PreviewPages, cfg.Build.MaxInFlight, cfg.Build.MaxQueued)
 	logger.WithField("workers", cfg.Build.DefaultWorkers).Info("Build queue initialized")
 
+	if cfg.Build.ArtifactKey != "" {
+		artifactKeys, err = atrest.NewKeyring(cfg.Build.ArtifactKey)
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid ARTIFACT_ENCRYPTION_KEY")
+		}
+		buildQueue.EncryptArtifacts(artifactKeys)
+		logger.Info("Build artifacts are encrypted at rest")
+	}
+
+	if objects := cfg.Storage.Objects; objects.Bucket != "" {
+		artifactObjects, err = objstore.New(objstore.Config{
+			Endpoint:  objects.Endpoint,
+			Bucket:    objects.Bucket,
+			Region:    objects.Region,
+			AccessKey: objects.AccessKey,
+			SecretKey: objects.SecretKey,
+			PathStyle: objects.PathStyle,
+		})
+		if err != nil {
+			logger.WithError(err).Fatal("Invalid artifact object storage configuration")
+		}
+		buildQueue.StoreArtifacts(artifactObjects)
+		logger.WithField("bucket", objects.Bucket).Info("Build artifacts are uploaded to object storage")
+	}
+
+	queueCtx, stopQueueLoops := context.WithCancel(context.Background())
+	defer stopQueueLoops()
+	if as := cfg.Build.Autoscale; as.Enabled {
+		autoscaler := &build.Autoscaler{
+			Queue:          buildQueue,
+			Scaler:         build.DepthScaler{Min: as.MinWorkers, Max: as.MaxWorkers, JobsPerWorker: as.JobsPerWorker},
+			Interval:       as.Interval,
+			ScaleDownDelay: as.ScaleDownDelay,
+		}
+		go autoscaler.Run(queueCtx)
+		logger.WithFields(logrus.Fields{
+			"min": as.MinWorkers,
+			"max": as.MaxWorkers,
+		}).Info("Worker autoscaling enabled")
+	}
+
+	reaper := &build.Reaper{
+		Queue:      buildQueue,
+		Interval:   cfg.Build.Reaper.Interval,
+		StaleAfter: cfg.Build.Reaper.StaleAfter,
+	}
+	go reaper.Run(queueCtx)
+
 	logger.Info("Initializing user store")
 	var err2 error
 	userStore, err2 = user.NewStore(dbInstance)
@@ -92,6 +167,41 @@ func main() {
 		logger.WithError(err2).Fatal("Failed to initialize user store")
 	}
 
+	logger.Info("Initializing blob store")
+	blobStore, err = blob.NewStore(dbInstance, filepath.Join(cfg.Build.WorkDir, ".blobs"), cfg.Region.Name)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize blob store")
+	}
+
+	pairingStore, err = auth.NewPairingStore(dbInstance)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize pairing store")
+	}
+
+	impersonationStore, err = auth.NewImpersonationStore(dbInstance)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to initialize impersonation store")
+	}
+
+	initNotifier()
+
+	dunning = &billing.Dunning{
+		Users:    userStore,
+		Notifier: notifier,
+		Grace:    cfg.Billing.GracePeriod,
+		Interval: time.Hour,
+		Logger:   logger,
+	}
+	go dunning.Run(queueCtx)
+
+	overages := &billing.Overages{
+		Users:    userStore,
+		Razorpay: razorpaySvc,
+		Interval: time.Hour,
+		Logger:   logger,
+	}
+	go overages.Run(queueCtx)
+
 	logger.Info("Initializing cleanup engine")
 	cleanupConfig := cleanup.Config{
 		Interval:      cfg.Cleanup.Interval,
@@ -101,12 +211,16 @@ func main() {
 		DiskWarning:   cfg.Storage.DiskWarning,
 		DiskCritical:  cfg.Storage.DiskCritical,
 		DiskEmergency: cfg.Storage.DiskEmergency,
+		BlobGrace:     cfg.Cleanup.BlobGrace,
+		ProjectTTL:    cfg.Cleanup.ProjectTTL,
+		Region:        cfg.Region.Name,
+		Regions:       availableRegions(),
 	}
-	cleanupEngine = cleanup.NewEngine(cleanupConfig, buildStore, userStore, logger)
+	cleanupEngine = cleanup.NewEngine(cleanupConfig, buildStore, userStore, blobStore, artifactObjects, logger)
 	cleanupEngine.Start()
 
 	logger.Info("Initializing rate limiter")
-	rateLimiter, err = rate.NewLimiter()
+	rateLimiter, err = rate.NewLimiter(cfg.Rate.RedisURL)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to initialize rate limiter")
 	}
@@ -133,8 +247,8 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Compiler-Token", "X-Request-ID"},
-		ExposedHeaders:   []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Compiler-Token", "X-Request-ID", api.VersionHeader},
+		ExposedHeaders:   []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", api.VersionHeader, "Deprecation", "Link"},
 		AllowCredentials: allowCredentials,
 		MaxAge:           300,
 	}))
@@ -143,60 +257,50 @@ func main() {
 	r.Head("/health", healthHandler)
 	r.Get("/ready", readyHandler)
 
-	r.Route("/api", func(r chi.Router) {
-		r.Use(auth.AuthMiddleware())
-
-		r.With(rateLimiter.Middleware("build")).Post("/build", CreateBuildHandler())
-		r.With(rateLimiter.Middleware("default")).Get("/build", ListBuildsHandler())
-		r.With(rateLimiter.Middleware("default")).Get("/build/{id}", GetBuildHandler())
-		r.With(rateLimiter.Middleware("status")).Get("/build/{id}/status", GetStatusHandler())
-		r.With(rateLimiter.Middleware("default")).Get("/build/{id}/log", GetLogHandler())
-		r.With(rateLimiter.Middleware("default")).Delete("/build/{id}", DeleteBuildHandler())
-
-		r.With(rateLimiter.Middleware("build")).Post("/builds/init", InitDeltaSyncHandler())
-		r.With(rateLimiter.Middleware("build")).Post("/builds/{buildId}/upload", UploadDeltaSyncFilesHandler())
-
-		r.With(rateLimiter.Middleware("default")).Get("/build/{id}/pdf/url", GetSignedPDFURLHandler())
-		r.With(rateLimiter.Middleware("download")).Get("/build/{id}/artifact/{resource}", ServePDFHandler())
-		r.With(rateLimiter.Middleware("download")).Get("/build/{id}/synctex", ServeSyncTeXHandler())
-		r.With(rateLimiter.Middleware("default")).Get("/build/{id}/synctex/view", SyncTeXViewHandler())
-		r.With(rateLimiter.Middleware("default")).Get("/build/{id}/synctex/edit", SyncTeXEditHandler())
-
-		r.Post("/subscription/create", CreateSubscriptionHandler())
-		r.Post("/subscription/cancel", CancelSubscriptionHandler())
-		r.Get("/subscription/status", GetSubscriptionStatusHandler())
-
-		r.Post("/coupon/redeem", RedeemCouponHandler())
-		r.Post("/coupon/apply", ApplyTrialCouponHandler())
-
-		r.Get("/allowlist/check", CheckAllowlistHandler())
-
-		r.Route("/admin", func(r chi.Router) {
-			r.Use(auth.AdminMiddleware())
-			r.Get("/allowlist", ListAllowlistHandler())
-			r.Post("/allowlist", AddToAllowlistHandler())
-			r.Delete("/allowlist/{email}", RemoveFromAllowlistHandler())
-			r.Get("/users", ListUsersHandler())
-			r.Get("/users/{id}", GetUserHandler())
-			r.Put("/users/{id}/tier", UpdateUserTierHandler())
-			r.Put("/users/{id}/admin", SetUserAdminHandler())
-			r.Get("/stats", GetAdminStatsHandler())
-		})
+	r.Route(api.VersionPrefix, func(r chi.Router) {
+		r.Use(api.VersionMiddleware)
+		apiRoutes(r)
+	})
 
-		r.Get("/user/me", GetCurrentUserHandler())
-		r.Get("/user/usage", GetUserUsageHandler())
+	// Unversioned aliases of the /v1 routes, kept for one release
+	r.Route(api.LegacyPrefix, func(r chi.Router) {
+		r.Use(api.VersionMiddleware, api.LegacyMiddleware)
+		apiRoutes(r)
 	})
 
+	// Read-only companion API, authenticated by pairing token instead of a session
+	r.Route("/companion", func(r chi.Router) {
+		r.Use(auth.PairingMiddleware(pairingStore))
+
+		r.With(rateLimiter.Middleware("default")).Get("/summary", CompanionSummaryHandler())
+		r.With(rateLimiter.Middleware("status")).Get("/status", CompanionStatusHandler())
+		r.With(rateLimiter.Middleware("download")).Get("/build/{id}/pages/{page}.png", GetPagePreviewHandler())
+	})
+
+	// Signed artifact links (e.g. from notification emails) work without a session
+	r.With(rateLimiter.Middleware("download")).Get("/artifact/{id}/{resource}", ServeSignedArtifactHandler())
+
 	r.With(webhookRateLimitMiddleware()).Post("/webhooks/razorpay", RazorpayWebhookHandler())
 
-	srv := &http.Server{
-		Addr:         ":" + cfg.Server.Port,
-		Handler:      r,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  cfg.Server.IdleTimeout,
+	// Internal API for orchestrators; not routed unless a token is configured
+	if token := cfg.Build.Autoscale.InternalToken; token != "" {
+		r.Route("/internal", func(r chi.Router) {
+			r.Use(auth.InternalMiddleware(token))
+			r.Get("/queue", QueueStatsHandler())
+			r.Put("/queue/workers", ScaleWorkersHandler())
+			r.Post("/queue/drain", DrainQueueHandler())
+		})
 	}
 
+	srv := tfhttp.NewServer(tfhttp.ServerConfig{
+		Addr:              ":" + cfg.Server.Port,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxBodyBytes:      cfg.Server.MaxBodySize,
+	}, r)
+
 	go func() {
 		logger.WithField("addr", srv.Addr).Info("Server starting")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -213,6 +317,7 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 
+	stopQueueLoops()
 	if buildQueue != nil {
 		go buildQueue.Stop()
 	}
@@ -226,6 +331,11 @@ func main() {
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.WithError(err).Error("Server shutdown error")
 	}
+	if notifyQueue != nil {
+		if err := notifyQueue.Close(shutdownCtx); err != nil {
+			logger.WithError(err).Warn("Notifications left unsent at shutdown")
+		}
+	}
 
 	logger.Info("Server stopped")
 }
@@ -233,6 +343,36 @@ func main() {
 // Middleware for correlation IDs
 type correlationIDKey struct{}
 
+// initSandboxes adds a Docker compiler for each configured sandbox runtime.
+// A sandbox whose compiler cannot start is left out rather than failing the
+// server; builds that ask for it are rejected.
+func initSandboxes(compiler *buildpkg.SandboxCompiler) {
+	sb := cfg.Build.Sandbox
+	if sb.Image == "" {
+		return
+	}
+	for name, runtime := range sb.Runtimes {
+		dc, err := buildpkg.NewDockerCompiler(sb.Image, cfg.Build.WorkDir)
+		if err != nil {
+			logger.WithError(err).WithField("sandbox", name).Error("Failed to initialize sandbox compiler")
+			continue
+		}
+		dc.SetOCIRuntime(runtime)
+		dc.SetRedactor(logRedactor)
+		if err := compiler.Add(name, dc); err != nil {
+			logger.WithError(err).WithField("sandbox", name).Error("Invalid sandbox")
+			dc.Close()
+			continue
+		}
+		logger.WithFields(logrus.Fields{
+			"sandbox": name,
+			"runtime": runtime,
+			"image":   sb.Image,
+		}).Info("Sandbox compiler initialized")
+	}
+	sandboxes = compiler.Available()
+}
+
 func correlationIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		corrID := middleware.GetReqID(r.Context())
@@ -283,11 +423,106 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush a streamed response
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// apiRoutes registers the authenticated build API. It is mounted under
+// /v1 and, for older clients, under /api.
+func apiRoutes(r chi.Router) {
+	r.Use(auth.AuthMiddleware())
+	r.Use(auditImpersonation)
+
+	// Uploads may carry a project of up to BUILD_MAX_FILE_SIZE; other
+	// requests are capped at SERVER_MAX_BODY_SIZE by the server
+	uploadLimit := tfhttp.LimitBody(cfg.Build.MaxFileSize + tfhttp.MultipartOverhead)
+	// Logs and build listings are compressed for clients sending
+	// Accept-Encoding
+	compress := middleware.Compress(tfhttp.CompressionLevel, tfhttp.CompressibleTypes...)
+	// Support impersonating a user may look but not pay, delete or hand out
+	// access on their behalf
+	noImpersonation := auth.DenyImpersonation
+
+	r.With(rateLimiter.Middleware("build"), requireActiveBilling, uploadLimit).Post("/build", CreateBuildHandler())
+	r.With(rateLimiter.Middleware("build"), requireActiveBilling).Post("/build/from-git", CreateGitBuildHandler())
+	r.With(rateLimiter.Middleware("default"), compress).Get("/build", ListBuildsHandler())
+	r.With(rateLimiter.Middleware("default")).Get("/build/{id}", GetBuildHandler())
+	r.With(rateLimiter.Middleware("status")).Get("/build/{id}/status", GetStatusHandler())
+	r.With(rateLimiter.Middleware("status"), compress).Get("/build/{id}/subfiles", GetSubfilesStatusHandler())
+	r.With(rateLimiter.Middleware("default"), compress).Get("/build/{id}/log", GetLogHandler())
+	r.With(rateLimiter.Middleware("download")).Get("/build/{id}/pages/{page}.png", GetPagePreviewHandler())
+	r.With(rateLimiter.Middleware("default"), noImpersonation).Delete("/build/{id}", DeleteBuildHandler())
+	r.With(rateLimiter.Middleware("default"), requireActiveBilling).Post("/build/{id}/pin", PinBuildHandler())
+	r.With(rateLimiter.Middleware("default"), noImpersonation).Delete("/build/{id}/pin", UnpinBuildHandler())
+
+	r.With(rateLimiter.Middleware("build"), requireActiveBilling, tfhttp.LimitBody(maxManifestSize)).Post("/builds/init", InitDeltaSyncHandler())
+	r.With(rateLimiter.Middleware("build"), requireActiveBilling, uploadLimit).Post("/builds/{buildId}/upload", UploadDeltaSyncFilesHandler())
+
+	r.With(rateLimiter.Middleware("default")).Get("/build/{id}/pdf/url", GetSignedPDFURLHandler())
+	r.With(rateLimiter.Middleware("download"), compress).Get("/build/{id}/artifact/{resource}", ServePDFHandler())
+	r.With(rateLimiter.Middleware("download")).Get("/build/{id}/synctex", ServeSyncTeXHandler())
+	r.With(rateLimiter.Middleware("default")).Get("/build/{id}/synctex/view", SyncTeXViewHandler())
+	r.With(rateLimiter.Middleware("default")).Get("/build/{id}/synctex/edit", SyncTeXEditHandler())
+
+	r.With(rateLimiter.Middleware("default")).Get("/plans", ListPlansHandler())
+
+	r.With(noImpersonation).Post("/subscription/create", CreateSubscriptionHandler())
+	r.With(noImpersonation).Post("/subscription/cancel", CancelSubscriptionHandler())
+	r.Get("/subscription/status", GetSubscriptionStatusHandler())
+
+	r.With(noImpersonation).Post("/coupon/redeem", RedeemCouponHandler())
+	r.With(noImpersonation).Post("/coupon/apply", ApplyTrialCouponHandler())
+
+	r.Get("/allowlist/check", CheckAllowlistHandler())
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(auth.AdminMiddleware())
+		r.Get("/allowlist", ListAllowlistHandler())
+		r.Post("/allowlist", AddToAllowlistHandler())
+		r.With(tfhttp.LimitBody(maxAllowlistImportSize)).Post("/allowlist/import", ImportAllowlistHandler())
+		r.Get("/allowlist/export", ExportAllowlistHandler())
+		r.Delete("/allowlist/{email}", RemoveFromAllowlistHandler())
+		r.Get("/users", ListUsersHandler())
+		r.Get("/users/{id}", GetUserHandler())
+		r.Put("/users/{id}/tier", UpdateUserTierHandler())
+		r.Put("/users/{id}/admin", SetUserAdminHandler())
+		r.With(rateLimiter.Middleware("impersonate")).Post("/users/{id}/impersonate", ImpersonateUserHandler())
+		r.Delete("/users/{id}/impersonate", EndImpersonationHandler())
+		r.Get("/stats", GetAdminStatsHandler())
+		r.Get("/dead-letters", ListDeadLettersHandler())
+		r.Post("/dead-letters/{id}/retry", RetryDeadLetterHandler())
+		r.Get("/reconcile", GetReconcileReportHandler())
+		r.Post("/reconcile", ReconcileHandler())
+		r.Get("/regions", RegionCapacityHandler())
+		r.Get("/coupon-campaigns", ListCouponCampaignsHandler())
+		r.Post("/coupon-campaigns", CreateCouponCampaignHandler())
+		r.Get("/coupon-campaigns/{id}", GetCouponCampaignHandler())
+		r.Post("/coupon-campaigns/{id}/disable", DisableCouponCampaignHandler())
+	})
+
+	r.Get("/user/me", GetCurrentUserHandler())
+	r.With(noImpersonation).Delete("/user/me", DeleteAccountHandler())
+	r.With(noImpersonation).Post("/user/me/restore", RestoreAccountHandler())
+	r.With(noImpersonation).Get("/user/export", ExportUserDataHandler())
+	r.Get("/user/usage", GetUserUsageHandler())
+	r.Get("/user/cache", GetUserCacheHandler())
+	r.With(noImpersonation).Delete("/user/cache", PurgeUserCacheHandler())
+	r.Get("/user/region", GetUserRegionHandler())
+	r.With(noImpersonation).Put("/user/region", SetUserRegionHandler())
+
+	r.Get("/companion/pair", ListPairingTokensHandler())
+	r.With(noImpersonation).Post("/companion/pair", CreatePairingTokenHandler())
+	r.With(noImpersonation).Delete("/companion/pair", RevokePairingTokensHandler())
+}
+
 // Health check endpoint
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(api.VersionHeader, strconv.Itoa(api.Version))
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"status":"ok"}`))
+	_ = json.NewEncoder(w).Encode(api.NewHealthResponse())
 }
 
 // Ready check endpoint
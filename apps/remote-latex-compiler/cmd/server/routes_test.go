@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/config"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/rate"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestDestructiveRoutesDenyImpersonation(t *testing.T) {
+	cfg = config.Load()
+	var err error
+	if rateLimiter, err = rate.NewLimiter(""); err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	apiRoutes(r)
+
+	deny := reflect.ValueOf(auth.DenyImpersonation).Pointer()
+	guarded := make(map[string]bool)
+	err = chi.Walk(r, func(method, route string, _ http.Handler, mws ...func(http.Handler) http.Handler) error {
+		for _, mw := range mws {
+			if reflect.ValueOf(mw).Pointer() == deny {
+				guarded[method+" "+route] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range []string{
+		"DELETE /build/{id}",
+		"DELETE /build/{id}/pin",
+		"DELETE /user/cache",
+		"DELETE /user/me",
+		"GET /user/export",
+		"POST /subscription/create",
+		"POST /subscription/cancel",
+		"POST /coupon/redeem",
+		"POST /coupon/apply",
+		"PUT /user/region",
+		"POST /companion/pair",
+		"DELETE /companion/pair",
+	} {
+		if !guarded[route] {
+			t.Errorf("%s is open to impersonated requests", route)
+		}
+	}
+	if guarded["GET /build/{id}"] {
+		t.Error("GET /build/{id} should stay open to support")
+	}
+}
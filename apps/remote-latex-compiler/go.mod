@@ -3,7 +3,10 @@ module github.com/alpha-og/treefrog/apps/remote-latex-compiler
 go 1.24.0
 
 require (
+	github.com/alpha-og/treefrog/packages/go/api v0.0.0
 	github.com/alpha-og/treefrog/packages/go/build v0.0.0
+	github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+	github.com/alpha-og/treefrog/packages/go/http v0.0.0
 	github.com/alpha-og/treefrog/packages/go/security v0.0.0
 	github.com/alpha-og/treefrog/packages/go/signer v0.0.0
 	github.com/alpha-og/treefrog/packages/go/synctex v0.0.0
@@ -58,7 +61,9 @@ require (
 )
 
 replace (
+	github.com/alpha-og/treefrog/packages/go/api => ../../packages/go/api
 	github.com/alpha-og/treefrog/packages/go/build => ../../packages/go/build
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../../packages/go/buildopts
 	github.com/alpha-og/treefrog/packages/go/config => ../../packages/go/config
 	github.com/alpha-og/treefrog/packages/go/http => ../../packages/go/http
 	github.com/alpha-og/treefrog/packages/go/security => ../../packages/go/security
@@ -0,0 +1,273 @@
+// Package atrest encrypts build artifacts at rest: the PDF, SyncTeX data,
+// logs and page previews of a build are sealed with AES-256-GCM under a key
+// derived per user from a master key, and opened only when served to the
+// build's owner.
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// KeySize is the size of master and derived keys
+const KeySize = 32
+
+var (
+	// fileMagic starts sealed files and is authenticated with them
+	fileMagic = []byte("TFREST1\n")
+	// textPrefix starts sealed text, e.g. a build log stored in the database
+	textPrefix = "tfrest1:"
+)
+
+// ErrSealed is returned when sealed data fails to decrypt
+var ErrSealed = errors.New("failed to decrypt artifact: wrong key or corrupted data")
+
+// Keyring derives the artifact key of each user from a master key
+type Keyring struct {
+	master []byte
+}
+
+// NewKeyring creates a keyring from a base64 master key of KeySize bytes
+func NewKeyring(encoded string) (*Keyring, error) {
+	master, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact key: %w", err)
+	}
+	if len(master) != KeySize {
+		return nil, fmt.Errorf("invalid artifact key: must be %d bytes, got %d", KeySize, len(master))
+	}
+	return &Keyring{master: master}, nil
+}
+
+func (k *Keyring) aead(userID string) (cipher.AEAD, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("artifact key needs a user")
+	}
+	key, err := hkdf.Key(sha256.New, k.master, nil, "treefrog artifacts "+userID, KeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsSealed reports whether data was sealed by Seal
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, fileMagic)
+}
+
+// Seal encrypts plaintext under the user's key
+func (k *Keyring) Seal(userID string, plaintext []byte) ([]byte, error) {
+	aead, err := k.aead(userID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(fileMagic)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, fileMagic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, fileMagic), nil
+}
+
+// Open decrypts data sealed under the user's key
+func (k *Keyring) Open(userID string, sealed []byte) ([]byte, error) {
+	aead, err := k.aead(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSealed(sealed) || len(sealed) < len(fileMagic)+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrSealed
+	}
+	body := sealed[len(fileMagic):]
+	plaintext, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], fileMagic)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return plaintext, nil
+}
+
+// SealText encrypts text for storage as text
+func (k *Keyring) SealText(userID, text string) (string, error) {
+	if text == "" || strings.HasPrefix(text, textPrefix) {
+		return text, nil
+	}
+	sealed, err := k.Seal(userID, []byte(text))
+	if err != nil {
+		return "", err
+	}
+	return textPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenText decrypts text sealed by SealText; other text is returned as is
+func (k *Keyring) OpenText(userID, text string) (string, error) {
+	encoded, ok := strings.CutPrefix(text, textPrefix)
+	if !ok {
+		return text, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrSealed
+	}
+	plaintext, err := k.Open(userID, sealed)
+	return string(plaintext), err
+}
+
+// ReadFile returns the content of a file, decrypting it if it is sealed
+func (k *Keyring) ReadFile(userID, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !IsSealed(data) {
+		return data, err
+	}
+	return k.Open(userID, data)
+}
+
+// SealFile encrypts a file in place. Files already sealed are left alone.
+func (k *Keyring) SealFile(userID, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil || IsSealed(data) {
+		return err
+	}
+	sealed, err := k.Seal(userID, data)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, sealed)
+}
+
+// OpenFile decrypts a sealed file in place
+func (k *Keyring) OpenFile(userID, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil || !IsSealed(data) {
+		return err
+	}
+	plaintext, err := k.Open(userID, data)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, plaintext)
+}
+
+// SealBuild encrypts the artifacts of a finished build: its PDF, SyncTeX
+// data, page previews, .log files, everything latexmk left in the output
+// directory and the build log, and marks it Encrypted.
+// Sealing a build twice leaves it as it was.
+func (k *Keyring) SealBuild(b *buildpkg.Build) error {
+	for _, path := range artifactFiles(b) {
+		if err := k.SealFile(b.UserID, path); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", filepath.Base(path), err)
+		}
+	}
+	buildLog, err := k.SealText(b.UserID, b.BuildLog)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt build log: %w", err)
+	}
+	b.BuildLog = buildLog
+	b.Encrypted = true
+	return nil
+}
+
+// OpenBuild decrypts the artifacts of a build in place, as when its output
+// is reused by another build that renders and seals it again
+func (k *Keyring) OpenBuild(b *buildpkg.Build) error {
+	for _, path := range artifactFiles(b) {
+		if err := k.OpenFile(b.UserID, path); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", filepath.Base(path), err)
+		}
+	}
+	buildLog, err := k.OpenText(b.UserID, b.BuildLog)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt build log: %w", err)
+	}
+	b.BuildLog = buildLog
+	b.Encrypted = false
+	return nil
+}
+
+// OpenDir decrypts every sealed file under dir in place, as when the aux
+// files of an encrypted build are carried forward into a new one
+func (k *Keyring) OpenDir(userID, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := k.OpenFile(userID, path); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", filepath.Base(path), err)
+		}
+		return nil
+	})
+}
+
+// artifactFiles lists the existing artifact files of a build. The output
+// directory is included whole: the PDF and SyncTeX data are copied out of
+// it, and its aux files hold the document's text too.
+func artifactFiles(b *buildpkg.Build) []string {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+	add(b.PDFPath)
+	add(b.SyncTeXPath)
+	if b.DirPath == "" {
+		return files
+	}
+	outDir := filepath.ToSlash(filepath.Clean(buildpkg.OutDir(b))) + "/"
+	filepath.WalkDir(b.DirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(b.DirPath, path)
+		rel = filepath.ToSlash(rel)
+		if filepath.Ext(path) == ".log" || strings.HasPrefix(rel, "pages/") || strings.HasPrefix(rel, outDir) {
+			add(path)
+		}
+		return nil
+	})
+	return files
+}
+
+// replaceFile writes data to path through a temporary file, so a crash
+// leaves the old or the new content but never a mix
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
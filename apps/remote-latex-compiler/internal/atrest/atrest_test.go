@@ -0,0 +1,104 @@
+package atrest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+func newTestKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	master := make([]byte, KeySize)
+	rand.Read(master)
+	k, err := NewKeyring(base64.StdEncoding.EncodeToString(master))
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSealBuildLeavesNoPlaintextPDF(t *testing.T) {
+	k := newTestKeyring(t)
+	dir := t.TempDir()
+	const pdf = "%PDF-1.5 secret paper"
+
+	writeFile(t, filepath.Join(dir, "main.tex"), "\\documentclass{article}")
+	writeFile(t, filepath.Join(dir, "output", "main.pdf"), pdf)
+	writeFile(t, filepath.Join(dir, "output", "main.synctex.gz"), "synctex")
+	writeFile(t, filepath.Join(dir, "output", "main.aux"), "\\newlabel{sec:secret}")
+	writeFile(t, filepath.Join(dir, "output", "main.log"), "log")
+	writeFile(t, filepath.Join(dir, "output.pdf"), pdf)
+	writeFile(t, filepath.Join(dir, "output.synctex.gz"), "synctex")
+	writeFile(t, filepath.Join(dir, buildpkg.LiveLogName), "live log")
+
+	b := &buildpkg.Build{
+		UserID:      "user-1",
+		MainFile:    "main.tex",
+		DirPath:     dir,
+		PDFPath:     filepath.Join(dir, "output.pdf"),
+		SyncTeXPath: filepath.Join(dir, "output.synctex.gz"),
+		BuildLog:    "build log",
+	}
+	if err := k.SealBuild(b); err != nil {
+		t.Fatalf("SealBuild: %v", err)
+	}
+
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, _ := os.ReadFile(path)
+		if bytes.Contains(data, []byte("%PDF")) {
+			t.Errorf("%s holds a plaintext PDF after sealing", path)
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if rel != "main.tex" && !IsSealed(data) {
+			t.Errorf("%s is not sealed", rel)
+		}
+		return nil
+	})
+
+	if err := k.OpenBuild(b); err != nil {
+		t.Fatalf("OpenBuild: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "output", "main.pdf"))
+	if string(data) != pdf {
+		t.Errorf("opened output/main.pdf = %q, want %q", data, pdf)
+	}
+}
+
+func TestOpenDir(t *testing.T) {
+	k := newTestKeyring(t)
+	dir := t.TempDir()
+	aux := filepath.Join(dir, "output", "main.aux")
+	writeFile(t, aux, "aux")
+	if err := k.SealFile("user-1", aux); err != nil {
+		t.Fatalf("SealFile: %v", err)
+	}
+
+	if err := k.OpenDir("user-1", filepath.Join(dir, "output")); err != nil {
+		t.Fatalf("OpenDir: %v", err)
+	}
+	if data, _ := os.ReadFile(aux); string(data) != "aux" {
+		t.Errorf("opened aux = %q, want %q", data, "aux")
+	}
+	if err := k.OpenDir("user-1", filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("OpenDir of a missing dir: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ImpersonationTokenPrefix marks impersonation tokens so AuthMiddleware can
+// tell them from Supabase JWTs
+const ImpersonationTokenPrefix = "tfi_"
+
+// Lifetimes of impersonation tokens: support sessions are short, and admins
+// mint a new token rather than keep one around
+const (
+	DefaultImpersonationTTL = 30 * time.Minute
+	MaxImpersonationTTL     = 2 * time.Hour
+)
+
+// ImpersonatorKey holds the admin behind an impersonated request
+const ImpersonatorKey contextKey = "impersonator"
+
+// Impersonation is an issued impersonation token
+type Impersonation struct {
+	ID        string    `json:"id"`
+	AdminID   string    `json:"admin_id"`
+	UserID    string    `json:"user_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ImpersonationStore issues and resolves the tokens admins use to act as a
+// user. Only the SHA256 of each token is persisted.
+type ImpersonationStore struct {
+	db *sql.DB
+}
+
+// NewImpersonationStore creates an impersonation token store
+func NewImpersonationStore(db *sql.DB) (*ImpersonationStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection required")
+	}
+	return &ImpersonationStore{db: db}, nil
+}
+
+// Create issues a token letting adminID act as userID until ttl has passed.
+// The plaintext token is only returned here.
+func (s *ImpersonationStore) Create(adminID, userID, reason string, ttl time.Duration) (string, *Impersonation, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := ImpersonationTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	imp := &Impersonation{
+		AdminID:   adminID,
+		UserID:    userID,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err := s.db.QueryRow(`
+		INSERT INTO impersonation_tokens (admin_id, user_id, token_hash, reason, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		adminID, userID, hashToken(token), reason, imp.CreatedAt, imp.ExpiresAt).Scan(&imp.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("insert failed: %w", err)
+	}
+	return token, imp, nil
+}
+
+// Resolve returns the impersonation an unexpired, unrevoked token grants,
+// as long as the admin who minted it still is one
+func (s *ImpersonationStore) Resolve(token string) (*Impersonation, error) {
+	if !strings.HasPrefix(token, ImpersonationTokenPrefix) {
+		return nil, fmt.Errorf("not an impersonation token")
+	}
+
+	imp := &Impersonation{}
+	err := s.db.QueryRow(`
+		UPDATE impersonation_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+			AND EXISTS (SELECT 1 FROM users WHERE users.id = impersonation_tokens.admin_id AND users.is_admin)
+		RETURNING id, admin_id, user_id, reason, created_at, expires_at`, hashToken(token)).Scan(
+		&imp.ID, &imp.AdminID, &imp.UserID, &imp.Reason, &imp.CreatedAt, &imp.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invalid or expired impersonation token")
+		}
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return imp, nil
+}
+
+// Revoke revokes the active tokens adminID holds for userID
+func (s *ImpersonationStore) Revoke(adminID, userID string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE impersonation_tokens SET revoked_at = NOW()
+		WHERE admin_id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()`, adminID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("update failed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// RevokeAdmin revokes every active token adminID holds, for an admin who
+// loses admin rights
+func (s *ImpersonationStore) RevokeAdmin(adminID string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE impersonation_tokens SET revoked_at = NOW()
+		WHERE admin_id = $1 AND revoked_at IS NULL AND expires_at > NOW()`, adminID)
+	if err != nil {
+		return 0, fmt.Errorf("update failed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// impersonate places the user an impersonation token acts as in the request
+// context. Admin rights are never carried over, so an impersonated session
+// cannot reach the admin API.
+func impersonate(r *http.Request, token string) (*http.Request, error) {
+	store, err := NewImpersonationStore(dbInstance)
+	if err != nil {
+		return nil, err
+	}
+	imp, err := store.Resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	userInfo, err := getUserInfo(imp.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(r.Context(), UserIDKey, imp.UserID)
+	ctx = context.WithValue(ctx, UserTierKey, userInfo.Tier)
+	ctx = context.WithValue(ctx, UserIsAdmin, false)
+	ctx = context.WithValue(ctx, UserEmailKey, userInfo.Email)
+	ctx = context.WithValue(ctx, ImpersonatorKey, imp.AdminID)
+	return r.WithContext(ctx), nil
+}
+
+// Impersonator returns the admin acting as the user of an impersonated
+// request
+func Impersonator(r *http.Request) (string, bool) {
+	adminID, ok := r.Context().Value(ImpersonatorKey).(string)
+	return adminID, ok && adminID != ""
+}
+
+// DenyImpersonation refuses impersonated requests, for routes support must
+// not use on a user's behalf such as billing and account deletion
+func DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := Impersonator(r); ok {
+			http.Error(w, "Not allowed while impersonating", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
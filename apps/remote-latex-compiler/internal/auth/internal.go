@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// InternalMiddleware guards the internal API used by orchestrators such as
+// autoscalers. Requests must carry token as a bearer token.
+func InternalMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			presented := strings.TrimPrefix(authHeader, "Bearer ")
+			if authHeader == "" || presented == authHeader {
+				http.Error(w, "Missing internal token", http.StatusUnauthorized)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				http.Error(w, "Invalid internal token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PairingTokenPrefix marks companion pairing tokens so they are never
+// mistaken for Supabase JWTs
+const PairingTokenPrefix = "tfp_"
+
+// DefaultPairingTTL is how long a pairing token stays valid
+const DefaultPairingTTL = 90 * 24 * time.Hour
+
+// PairingToken describes an issued companion pairing token
+type PairingToken struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// PairingStore issues and resolves pairing tokens for the companion API.
+// Only the SHA256 of each token is persisted.
+type PairingStore struct {
+	db *sql.DB
+}
+
+// NewPairingStore creates a pairing token store
+func NewPairingStore(db *sql.DB) (*PairingStore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection required")
+	}
+	return &PairingStore{db: db}, nil
+}
+
+// Create issues a new pairing token for a user. The plaintext token is only
+// returned here and cannot be recovered later.
+func (s *PairingStore) Create(userID, label string, ttl time.Duration) (string, *PairingToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := PairingTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	pt := &PairingToken{
+		Label:     label,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	err := s.db.QueryRow(`
+		INSERT INTO pairing_tokens (user_id, token_hash, label, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		userID, hashToken(token), label, pt.CreatedAt, pt.ExpiresAt).Scan(&pt.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("insert failed: %w", err)
+	}
+
+	return token, pt, nil
+}
+
+// Resolve returns the user a pairing token belongs to
+func (s *PairingStore) Resolve(token string) (string, error) {
+	if !strings.HasPrefix(token, PairingTokenPrefix) {
+		return "", fmt.Errorf("not a pairing token")
+	}
+
+	var userID string
+	err := s.db.QueryRow(`
+		UPDATE pairing_tokens SET last_used_at = NOW()
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`, hashToken(token)).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("invalid or expired pairing token")
+		}
+		return "", fmt.Errorf("query failed: %w", err)
+	}
+	return userID, nil
+}
+
+// List returns the active pairing tokens of a user
+func (s *PairingStore) List(userID string) ([]*PairingToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(label, ''), created_at, expires_at, last_used_at
+		FROM pairing_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*PairingToken
+	for rows.Next() {
+		pt := &PairingToken{}
+		if err := rows.Scan(&pt.ID, &pt.Label, &pt.CreatedAt, &pt.ExpiresAt, &pt.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		tokens = append(tokens, pt)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAll revokes every active pairing token of a user
+func (s *PairingStore) RevokeAll(userID string) (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE pairing_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("update failed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// hashToken returns the hash pairing and impersonation tokens are stored
+// under
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// PairingMiddleware authenticates companion requests by pairing token and
+// places the owning user in the request context, like AuthMiddleware does
+// for Supabase sessions
+func PairingMiddleware(store *PairingStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(authHeader, "Bearer ")
+			if authHeader == "" || token == authHeader {
+				http.Error(w, "Missing pairing token", http.StatusUnauthorized)
+				return
+			}
+
+			userID, err := store.Resolve(token)
+			if err != nil {
+				log.WithError(err).Debug("Pairing token validation failed")
+				http.Error(w, "Invalid pairing token", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -367,6 +367,17 @@ func AuthMiddleware() func(http.Handler) http.Handler {
 				return
 			}
 
+			if strings.HasPrefix(tokenString, ImpersonationTokenPrefix) {
+				impersonated, err := impersonate(r, tokenString)
+				if err != nil {
+					log.WithError(err).Debug("Impersonation token validation failed")
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, impersonated)
+				return
+			}
+
 			claims, err := validateToken(tokenString)
 			if err != nil {
 				log.WithError(err).Debug("Token validation failed")
@@ -0,0 +1,154 @@
+package billing
+
+import (
+	"context"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/notify"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultGracePeriod is how long a past due account keeps full access
+const DefaultGracePeriod = 7 * 24 * time.Hour
+
+// Dunning moves accounts through active → past_due → suspended as their
+// payments fail, and back to active when a retried payment succeeds. The
+// user is emailed on every change when a notifier is set.
+type Dunning struct {
+	Users    *user.Store
+	Notifier notify.Notifier // nil to change statuses without email
+	Grace    time.Duration   // how long a past due account keeps full access
+	Interval time.Duration   // how often to suspend accounts past their grace period
+	Logger   *logrus.Logger
+}
+
+// PaymentFailed makes an active account past due. Further failures while
+// past due or suspended change nothing.
+func (d *Dunning) PaymentFailed(u *user.User, amount int64) error {
+	now := time.Now()
+	changed, err := d.Users.SetBillingStatus(u.ID, user.BillingActive, user.BillingPastDue, &now)
+	if err != nil || !changed {
+		return err
+	}
+	graceEnds := d.GraceEnds(now)
+	d.changed(u, notify.BillingStatusChanged{Status: user.BillingPastDue, Amount: amount, GraceEnds: &graceEnds})
+	return nil
+}
+
+// PaymentsExhausted suspends an account whose payment retries ran out,
+// without waiting for the grace period
+func (d *Dunning) PaymentsExhausted(u *user.User) error {
+	status, since, err := d.Users.BillingStatus(u.ID)
+	if err != nil || status == user.BillingSuspended {
+		return err
+	}
+	if since == nil {
+		now := time.Now()
+		since = &now
+	}
+	_, err = d.suspend(u, status, since)
+	return err
+}
+
+// PaymentSucceeded restores a past due or suspended account
+func (d *Dunning) PaymentSucceeded(u *user.User) error {
+	status, _, err := d.Users.BillingStatus(u.ID)
+	if err != nil || status == user.BillingActive {
+		return err
+	}
+	changed, err := d.Users.SetBillingStatus(u.ID, status, user.BillingActive, nil)
+	if err != nil || !changed {
+		return err
+	}
+	d.changed(u, notify.BillingStatusChanged{Status: user.BillingActive})
+	return nil
+}
+
+// Run suspends accounts past their grace period every Interval until ctx is
+// done
+func (d *Dunning) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if suspended, err := d.Sweep(); err != nil {
+			d.Logger.WithError(err).Error("Dunning sweep failed")
+		} else if suspended > 0 {
+			d.Logger.WithField("count", suspended).Info("Suspended accounts past their payment grace period")
+		}
+	}
+}
+
+// Sweep suspends the accounts past due for longer than the grace period
+func (d *Dunning) Sweep() (int, error) {
+	ids, err := d.Users.PastDueSince(time.Now().Add(-d.grace()))
+	if err != nil {
+		return 0, err
+	}
+
+	suspended := 0
+	for _, id := range ids {
+		u, err := d.Users.GetByID(id)
+		if err != nil {
+			d.Logger.WithError(err).WithField("user_id", id).Warn("Past due user not found")
+			continue
+		}
+		_, since, err := d.Users.BillingStatus(id)
+		if err != nil {
+			return suspended, err
+		}
+		changed, err := d.suspend(u, user.BillingPastDue, since)
+		if err != nil {
+			return suspended, err
+		}
+		if changed {
+			suspended++
+		}
+	}
+	return suspended, nil
+}
+
+func (d *Dunning) suspend(u *user.User, from string, since *time.Time) (bool, error) {
+	changed, err := d.Users.SetBillingStatus(u.ID, from, user.BillingSuspended, since)
+	if err != nil || !changed {
+		return false, err
+	}
+	d.changed(u, notify.BillingStatusChanged{Status: user.BillingSuspended})
+	return true, nil
+}
+
+// changed logs a status change and emails the user about it
+func (d *Dunning) changed(u *user.User, n notify.BillingStatusChanged) {
+	d.Logger.WithFields(logrus.Fields{
+		"user_id": u.ID,
+		"status":  n.Status,
+	}).Info("Billing status changed")
+
+	if d.Notifier == nil {
+		return
+	}
+	n.To = u.Email
+	if err := d.Notifier.BillingStatusChanged(n); err != nil {
+		d.Logger.WithError(err).WithField("user_id", u.ID).Error("Failed to send billing notification")
+	}
+}
+
+// GraceEnds returns when an account past due since the given time is
+// suspended
+func (d *Dunning) GraceEnds(since time.Time) time.Time {
+	return since.Add(d.grace())
+}
+
+func (d *Dunning) grace() time.Duration {
+	if d.Grace > 0 {
+		return d.Grace
+	}
+	return DefaultGracePeriod
+}
@@ -0,0 +1,97 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
+	"github.com/sirupsen/logrus"
+)
+
+// PeriodStart returns the first day of the month of t, the period monthly
+// build quotas and overages are counted in
+func PeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Overages adds the builds users ran beyond their monthly quota to their
+// next invoice. Once a month is over, its overages are added to the user's
+// subscription as one add-on, charged with the next renewal.
+type Overages struct {
+	Users    *user.Store
+	Razorpay *RazorpayService
+	Interval time.Duration // how often to invoice the overages of past months
+	Logger   *logrus.Logger
+}
+
+// Run invoices the overages of past months every Interval until ctx is done
+func (o *Overages) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if invoiced, err := o.Sweep(); err != nil {
+			o.Logger.WithError(err).Error("Overage invoicing failed")
+		} else if invoiced > 0 {
+			o.Logger.WithField("count", invoiced).Info("Added build overages to invoices")
+		}
+	}
+}
+
+// Sweep adds the uninvoiced overages of past months to the subscriptions of
+// their users and returns how many months of overages it added. Overages of
+// users without a subscription stay uninvoiced until they have one.
+func (o *Overages) Sweep() (int, error) {
+	batches, err := o.Users.UninvoicedOverages(PeriodStart(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+
+	invoiced := 0
+	for _, batch := range batches {
+		fields := logrus.Fields{"user_id": batch.UserID, "period": batch.Period.Format("2006-01")}
+		u, err := o.Users.GetByID(batch.UserID)
+		if err != nil {
+			o.Logger.WithError(err).WithFields(fields).Warn("User with overages not found")
+			continue
+		}
+		if u.RazorpaySubscriptionID == "" {
+			o.Logger.WithFields(fields).Warn("User with overages has no subscription")
+			continue
+		}
+
+		ids, amount, err := o.Users.ClaimOverages(batch.IDs)
+		if err != nil {
+			return invoiced, err
+		}
+		if len(ids) == 0 {
+			continue // invoiced by another instance
+		}
+
+		month := batch.Period.Format("January 2006")
+		addonID, err := o.Razorpay.AddSubscriptionCharge(u.RazorpaySubscriptionID,
+			"Build overage",
+			fmt.Sprintf("%d builds over the monthly quota in %s", len(ids), month),
+			amount)
+		if err != nil {
+			o.Logger.WithError(err).WithFields(fields).Error("Failed to invoice overages")
+			if err := o.Users.ReleaseOverages(ids); err != nil {
+				return invoiced, err
+			}
+			continue
+		}
+		if err := o.Users.SetOveragesInvoiced(ids, addonID); err != nil {
+			o.Logger.WithError(err).WithFields(fields).WithField("addon_id", addonID).Error("Failed to record overage add-on")
+		}
+		invoiced++
+	}
+	return invoiced, nil
+}
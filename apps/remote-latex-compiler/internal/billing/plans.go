@@ -2,36 +2,78 @@ package billing
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 type PlanConfig struct {
-	ID            string
-	Name          string
-	MonthlyBuilds int
-	Concurrent    int
-	StorageGB     int
+	ID                 string
+	Name               string
+	MonthlyBuilds      int
+	Concurrent         int
+	StorageGB          int
+	Retention          time.Duration // how long build artifacts are kept
+	OveragePrice       int64         // per build beyond MonthlyBuilds, in paise; 0 blocks them instead
+	ShellEscape        bool          // full shell-escape; other plans get the restricted profile
+	AutoShellEscape    bool          // minted documents get the restricted profile without asking for it
+	EmailNotifications bool          // emails when builds finish
 }
 
+// Currency of plan and overage prices
+const Currency = "INR"
+
+// DefaultRetention applies to tiers without a plan entry
+const DefaultRetention = 24 * time.Hour
+
 var Plans = map[string]PlanConfig{
 	"free": {
-		ID:            os.Getenv("RAZORPAY_PLAN_FREE"),
-		Name:          "Free",
-		MonthlyBuilds: 50,
-		Concurrent:    2,
-		StorageGB:     1,
+		ID:              os.Getenv("RAZORPAY_PLAN_FREE"),
+		Name:            "Free",
+		MonthlyBuilds:   50,
+		Concurrent:      2,
+		StorageGB:       1,
+		Retention:       24 * time.Hour,
+		AutoShellEscape: true,
 	},
 	"pro": {
-		ID:            os.Getenv("RAZORPAY_PLAN_PRO"),
-		Name:          "Pro",
-		MonthlyBuilds: 500,
-		Concurrent:    10,
-		StorageGB:     10,
+		ID:              os.Getenv("RAZORPAY_PLAN_PRO"),
+		Name:            "Pro",
+		MonthlyBuilds:   500,
+		Concurrent:      10,
+		StorageGB:       10,
+		Retention:       7 * 24 * time.Hour,
+		OveragePrice:    overagePrice("BILLING_OVERAGE_PRICE_PRO", 500),
+		AutoShellEscape: true,
 	},
 	"enterprise": {
-		ID:            os.Getenv("RAZORPAY_PLAN_ENTERPRISE"),
-		Name:          "Enterprise",
-		MonthlyBuilds: -1, // unlimited
-		Concurrent:    50,
-		StorageGB:     100,
+		ID:                 os.Getenv("RAZORPAY_PLAN_ENTERPRISE"),
+		Name:               "Enterprise",
+		MonthlyBuilds:      -1, // unlimited
+		Concurrent:         50,
+		StorageGB:          100,
+		Retention:          30 * 24 * time.Hour,
+		ShellEscape:        true,
+		AutoShellEscape:    true,
+		EmailNotifications: true,
 	},
 }
+
+// PlanTiers lists the tiers of Plans from the cheapest
+var PlanTiers = []string{"free", "pro", "enterprise"}
+
+// RetentionFor returns how long a tier keeps build artifacts
+func RetentionFor(tier string) time.Duration {
+	if plan, ok := Plans[tier]; ok && plan.Retention > 0 {
+		return plan.Retention
+	}
+	return DefaultRetention
+}
+
+// overagePrice reads a per-build overage price in paise from the
+// environment; 0 turns overage billing off for the plan
+func overagePrice(key string, defaultVal int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil && v >= 0 {
+		return v
+	}
+	return defaultVal
+}
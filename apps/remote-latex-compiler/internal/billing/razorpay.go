@@ -248,6 +248,39 @@ func (s *RazorpayService) GetSubscription(subscriptionID string) (*Subscription,
 	}, nil
 }
 
+// AddSubscriptionCharge adds a one-time charge of amount paise to the next
+// invoice of a subscription and returns the id of the add-on
+func (s *RazorpayService) AddSubscriptionCharge(subscriptionID, name, description string, amount int64) (string, error) {
+	data := map[string]interface{}{
+		"item": map[string]interface{}{
+			"name":        name,
+			"amount":      amount,
+			"currency":    Currency,
+			"description": description,
+		},
+		"quantity": 1,
+	}
+
+	addon, err := s.Client.Subscription.CreateAddon(subscriptionID, data, nil)
+	if err != nil {
+		log.WithError(err).WithField("subscription_id", subscriptionID).Error("Failed to add subscription charge")
+		return "", fmt.Errorf("failed to add subscription charge: %w", err)
+	}
+
+	id, err := getString(addon, "id")
+	if err != nil {
+		return "", fmt.Errorf("invalid add-on response: %w", err)
+	}
+
+	log.WithFields(logrus.Fields{
+		"subscription_id": subscriptionID,
+		"addon_id":        id,
+		"amount":          amount,
+	}).Info("Subscription charge added")
+
+	return id, nil
+}
+
 // CreateSubscriptionWithCoupon creates subscription with a coupon
 func (s *RazorpayService) CreateSubscriptionWithCoupon(planID, customerID, couponCode string) (string, error) {
 	data := map[string]interface{}{
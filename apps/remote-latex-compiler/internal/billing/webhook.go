@@ -30,10 +30,11 @@ type WebhookPayload struct {
 			CurrentEnd   int64  `json:"current_end"`
 		} `json:"subscription"`
 		Payment struct {
-			ID        string `json:"id"`
-			Amount    int64  `json:"amount"`
-			Status    string `json:"status"`
-			InvoiceID string `json:"invoice_id"`
+			ID         string `json:"id"`
+			Amount     int64  `json:"amount"`
+			Status     string `json:"status"`
+			InvoiceID  string `json:"invoice_id"`
+			CustomerID string `json:"customer_id"`
 		} `json:"payment"`
 		Customer struct {
 			ID    string `json:"id"`
@@ -57,13 +58,15 @@ func VerifyWebhookSignature(body, signature, secret string) bool {
 type WebhookHandler struct {
 	service   *RazorpayService
 	userStore *user.Store
+	dunning   *Dunning
 	logger    *logrus.Logger
 }
 
-func NewWebhookHandler(service *RazorpayService, userStore *user.Store, logger *logrus.Logger) *WebhookHandler {
+func NewWebhookHandler(service *RazorpayService, userStore *user.Store, dunning *Dunning, logger *logrus.Logger) *WebhookHandler {
 	return &WebhookHandler{
 		service:   service,
 		userStore: userStore,
+		dunning:   dunning,
 		logger:    logger,
 	}
 }
@@ -123,8 +126,12 @@ func (h *WebhookHandler) handleEvent(payload *WebhookPayload) error {
 		return h.handleSubscriptionResumed(payload)
 	case "payment.authorized":
 		return h.handlePaymentAuthorized(payload)
-	case "payment.failed":
+	case "payment.failed", "subscription.pending":
 		return h.handlePaymentFailed(payload)
+	case "payment.captured", "subscription.charged":
+		return h.handlePaymentSucceeded(payload)
+	case "subscription.halted":
+		return h.handleSubscriptionHalted(payload)
 	case "subscription.completed":
 		return h.handleSubscriptionCompleted(payload)
 	default:
@@ -163,7 +170,8 @@ func (h *WebhookHandler) handleSubscriptionActivated(payload *WebhookPayload) er
 		"user_id": u.ID,
 		"tier":    tier,
 	}).Info("Activated subscription for user")
-	return nil
+	// A new subscription settles an account suspended for an old one
+	return h.dunning.PaymentSucceeded(u)
 }
 
 func (h *WebhookHandler) handleSubscriptionCancelled(payload *WebhookPayload) error {
@@ -242,16 +250,12 @@ func (h *WebhookHandler) handlePaymentAuthorized(payload *WebhookPayload) error
 	return nil
 }
 
+// handlePaymentFailed starts the grace period of an account whose
+// subscription payment failed. Razorpay retries the charge meanwhile.
 func (h *WebhookHandler) handlePaymentFailed(payload *WebhookPayload) error {
-	customerID := payload.Payload.Customer.ID
-
-	if customerID == "" {
-		return fmt.Errorf("missing customer ID in payload")
-	}
-
-	u, err := h.userStore.GetByRazorpayCustomerID(customerID)
+	u, err := h.payloadUser(payload)
 	if err != nil {
-		return fmt.Errorf("user not found for customer %s: %w", customerID, err)
+		return err
 	}
 
 	h.logger.WithFields(logrus.Fields{
@@ -260,13 +264,47 @@ func (h *WebhookHandler) handlePaymentFailed(payload *WebhookPayload) error {
 		"amount":       payload.Payload.Payment.Amount,
 	}).Error("Payment failed")
 
-	// Mark subscription as paused due to payment failure
-	u.SubscriptionPaused = true
-	if err := h.userStore.Update(u); err != nil {
-		h.logger.WithError(err).Error("Failed to pause subscription")
+	return h.dunning.PaymentFailed(u, payload.Payload.Payment.Amount)
+}
+
+// handlePaymentSucceeded restores an account once a retried payment went
+// through
+func (h *WebhookHandler) handlePaymentSucceeded(payload *WebhookPayload) error {
+	u, err := h.payloadUser(payload)
+	if err != nil {
+		return err
 	}
+	return h.dunning.PaymentSucceeded(u)
+}
 
-	return nil
+// handleSubscriptionHalted suspends an account whose payment retries ran out
+func (h *WebhookHandler) handleSubscriptionHalted(payload *WebhookPayload) error {
+	u, err := h.payloadUser(payload)
+	if err != nil {
+		return err
+	}
+	return h.dunning.PaymentsExhausted(u)
+}
+
+// payloadUser returns the user of the customer an event is about. Payment
+// and subscription events name the customer in different places.
+func (h *WebhookHandler) payloadUser(payload *WebhookPayload) (*user.User, error) {
+	customerID := payload.Payload.Customer.ID
+	if customerID == "" {
+		customerID = payload.Payload.Subscription.CustomerID
+	}
+	if customerID == "" {
+		customerID = payload.Payload.Payment.CustomerID
+	}
+	if customerID == "" {
+		return nil, fmt.Errorf("missing customer ID in payload")
+	}
+
+	u, err := h.userStore.GetByRazorpayCustomerID(customerID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found for customer %s: %w", customerID, err)
+	}
+	return u, nil
 }
 
 func (h *WebhookHandler) handleSubscriptionCompleted(payload *WebhookPayload) error {
@@ -0,0 +1,416 @@
+package blob
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Store is a content-addressable blob store shared by all delta-sync builds.
+// Blobs live on disk under root/<first two hex chars>/<sha256>, while the
+// database tracks how many project manifests reference each blob so that
+// unreferenced blobs can be swept by the cleanup engine. Every region has
+// its own root; a project cached in one region is uploaded again when it is
+// built in another.
+type Store struct {
+	db     *sql.DB
+	root   string
+	region string
+}
+
+// NewStore creates a blob store of a region rooted at the given directory.
+// region is "" when regions are disabled.
+func NewStore(db *sql.DB, root, region string) (*Store, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database connection required")
+	}
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &Store{db: db, root: root, region: region}, nil
+}
+
+// blobKey identifies a blob in the database
+type blobKey struct {
+	region string
+	hash   string
+}
+
+// ValidHash reports whether s is a lowercase hex SHA-256 digest
+func ValidHash(s string) bool {
+	return hashPattern.MatchString(s)
+}
+
+func (s *Store) path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash)
+}
+
+// Has reports whether a blob with the given hash is stored
+func (s *Store) Has(hash string) bool {
+	if !ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.path(hash))
+	return err == nil
+}
+
+// Put stores the content of r and returns its hash and size. Content that is
+// already present is not written twice.
+func (s *Store) Put(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), "blob-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create temp blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	tmp.Close()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	dst := s.path(hash)
+
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", 0, fmt.Errorf("failed to create blob directory: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), dst); err != nil {
+			return "", 0, fmt.Errorf("failed to store blob: %w", err)
+		}
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO blobs (region, hash, size_bytes, ref_count, created_at, last_used_at)
+		VALUES ($1, $2, $3, 0, NOW(), NOW())
+		ON CONFLICT (region, hash) DO UPDATE SET last_used_at = NOW()`,
+		s.region, hash, size)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to record blob: %w", err)
+	}
+
+	return hash, size, nil
+}
+
+// CopyTo writes the blob with the given hash to dst, verifying its content on the way
+func (s *Store) CopyTo(hash, dst string) error {
+	if !ValidHash(hash) {
+		return fmt.Errorf("invalid blob hash")
+	}
+
+	src, err := os.Open(s.path(hash))
+	if err != nil {
+		return fmt.Errorf("blob not found: %s", hash)
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hasher), src); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != hash {
+		os.Remove(dst)
+		return fmt.Errorf("blob %s is corrupt", hash)
+	}
+
+	_, err = s.db.Exec(`UPDATE blobs SET last_used_at = NOW() WHERE region = $1 AND hash = $2`, s.region, hash)
+	return err
+}
+
+// LastBuildID returns the most recent build recorded for a project, or ""
+// if the project is cached in another region
+func (s *Store) LastBuildID(userID, projectID string) (string, error) {
+	var lastBuildID sql.NullString
+	err := s.db.QueryRow(`
+		SELECT last_build_id FROM delta_projects WHERE user_id = $1 AND project_id = $2 AND region = $3`,
+		userID, projectID, s.region).Scan(&lastBuildID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return lastBuildID.String, nil
+}
+
+// SetProjectFiles replaces the manifest (path -> hash) of a project and
+// adjusts blob reference counts accordingly
+func (s *Store) SetProjectFiles(userID, projectID, buildID string, files map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deltas := make(map[blobKey]int)
+
+	if _, err := tx.Exec(`
+		INSERT INTO delta_projects (user_id, project_id, region, last_build_id, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id, project_id) DO UPDATE SET region = $3, last_build_id = $4, updated_at = NOW()`,
+		userID, projectID, s.region, buildID); err != nil {
+		return err
+	}
+
+	if err := releaseProject(tx, userID, projectID, deltas); err != nil {
+		return err
+	}
+
+	for path, hash := range files {
+		if _, err := tx.Exec(`
+			INSERT INTO project_files (user_id, project_id, path, region, hash)
+			VALUES ($1, $2, $3, $4, $5)`,
+			userID, projectID, path, s.region, hash); err != nil {
+			return err
+		}
+		deltas[blobKey{s.region, hash}]++
+	}
+
+	if err := applyRefDeltas(tx, deltas); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// releaseProject deletes a project's manifest rows, recording one reference
+// decrement per file in deltas
+func releaseProject(tx *sql.Tx, userID, projectID string, deltas map[blobKey]int) error {
+	rows, err := tx.Query(`
+		SELECT region, hash FROM project_files WHERE user_id = $1 AND project_id = $2`,
+		userID, projectID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var key blobKey
+		if err := rows.Scan(&key.region, &key.hash); err != nil {
+			rows.Close()
+			return err
+		}
+		deltas[key]--
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM project_files WHERE user_id = $1 AND project_id = $2`, userID, projectID)
+	return err
+}
+
+func applyRefDeltas(tx *sql.Tx, deltas map[blobKey]int) error {
+	for key, delta := range deltas {
+		if delta == 0 {
+			continue
+		}
+		if _, err := tx.Exec(`
+			UPDATE blobs SET ref_count = GREATEST(ref_count + $1, 0), last_used_at = NOW()
+			WHERE region = $2 AND hash = $3`,
+			delta, key.region, key.hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveProject drops a project's manifest so its blobs become eligible for sweeping
+func (s *Store) RemoveProject(userID, projectID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deltas := make(map[blobKey]int)
+	if err := releaseProject(tx, userID, projectID, deltas); err != nil {
+		return err
+	}
+	if err := applyRefDeltas(tx, deltas); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM delta_projects WHERE user_id = $1 AND project_id = $2`, userID, projectID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PurgeUser removes every project manifest owned by a user. Returns the number of projects removed.
+func (s *Store) PurgeUser(userID string) (int, error) {
+	projects, err := s.projectIDs(`SELECT user_id, project_id FROM delta_projects WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range projects {
+		if err := s.RemoveProject(p[0], p[1]); err != nil {
+			return 0, err
+		}
+	}
+	return len(projects), nil
+}
+
+// ExpireProjects removes manifests of the region's projects that have not
+// been built for longer than idle. Returns the number of projects removed.
+func (s *Store) ExpireProjects(idle time.Duration) (int, error) {
+	projects, err := s.projectIDs(`SELECT user_id, project_id FROM delta_projects WHERE updated_at < $1 AND region = $2`,
+		time.Now().Add(-idle), s.region)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, p := range projects {
+		if err := s.RemoveProject(p[0], p[1]); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (s *Store) projectIDs(query string, args ...interface{}) ([][2]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects [][2]string
+	for rows.Next() {
+		var userID, projectID string
+		if err := rows.Scan(&userID, &projectID); err != nil {
+			return nil, err
+		}
+		projects = append(projects, [2]string{userID, projectID})
+	}
+	return projects, rows.Err()
+}
+
+// ProjectCacheStats summarizes the cached files of a single project
+type ProjectCacheStats struct {
+	ProjectID   string    `json:"project_id"`
+	LastBuildID string    `json:"last_build_id,omitempty"`
+	Files       int       `json:"files"`
+	SizeBytes   int64     `json:"size_bytes"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CacheStats summarizes a user's delta-sync cache. TotalBytes counts each
+// blob once even when several projects share it.
+type CacheStats struct {
+	Projects   []ProjectCacheStats `json:"projects"`
+	Files      int                 `json:"files"`
+	TotalBytes int64               `json:"total_bytes"`
+}
+
+// UserCacheStats returns the delta-sync cache usage of a user
+func (s *Store) UserCacheStats(userID string) (*CacheStats, error) {
+	rows, err := s.db.Query(`
+		SELECT dp.project_id, COALESCE(dp.last_build_id, ''), dp.updated_at,
+		       COUNT(pf.path), COALESCE(SUM(b.size_bytes), 0)
+		FROM delta_projects dp
+		LEFT JOIN project_files pf ON pf.user_id = dp.user_id AND pf.project_id = dp.project_id
+		LEFT JOIN blobs b ON b.region = pf.region AND b.hash = pf.hash
+		WHERE dp.user_id = $1
+		GROUP BY dp.project_id, dp.last_build_id, dp.updated_at
+		ORDER BY dp.updated_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &CacheStats{Projects: []ProjectCacheStats{}}
+	for rows.Next() {
+		var p ProjectCacheStats
+		if err := rows.Scan(&p.ProjectID, &p.LastBuildID, &p.UpdatedAt, &p.Files, &p.SizeBytes); err != nil {
+			return nil, err
+		}
+		stats.Projects = append(stats.Projects, p)
+		stats.Files += p.Files
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRow(`
+		SELECT COALESCE(SUM(size_bytes), 0) FROM blobs
+		WHERE (region, hash) IN (SELECT DISTINCT region, hash FROM project_files WHERE user_id = $1)`,
+		userID).Scan(&stats.TotalBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Sweep removes the region's blobs that no project references and that have
+// not been used for at least the given grace period. Returns the number of
+// blobs and bytes freed.
+func (s *Store) Sweep(grace time.Duration) (int, int64, error) {
+	rows, err := s.db.Query(`
+		SELECT hash, size_bytes FROM blobs
+		WHERE region = $1 AND ref_count <= 0 AND last_used_at < $2`,
+		s.region, time.Now().Add(-grace))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type candidate struct {
+		hash string
+		size int64
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.hash, &c.size); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	removed := 0
+	var freed int64
+	for _, c := range candidates {
+		// Re-check under the delete so a blob re-referenced meanwhile survives
+		res, err := s.db.Exec(`DELETE FROM blobs WHERE region = $1 AND hash = $2 AND ref_count <= 0`, s.region, c.hash)
+		if err != nil {
+			return removed, freed, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if ValidHash(c.hash) {
+			os.Remove(s.path(c.hash))
+		}
+		removed++
+		freed += c.size
+	}
+
+	return removed, freed, nil
+}
@@ -0,0 +1,88 @@
+package build
+
+import (
+	"container/heap"
+	"time"
+)
+
+// delayHeap orders delayed jobs by NotBefore, earliest first
+type delayHeap []*BuildJob
+
+func (h delayHeap) Len() int           { return len(h) }
+func (h delayHeap) Less(i, j int) bool { return h[i].NotBefore.Before(h[j].NotBefore) }
+func (h delayHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap) Push(x interface{}) { *h = append(*h, x.(*BuildJob)) }
+
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return job
+}
+
+// pushAfter holds a job back until its NotBefore time, then queues it at the
+// back of its user's list
+func (f *fairQueue) pushAfter(job *BuildJob) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	heap.Push(&f.delayed, job)
+	f.armTimer()
+}
+
+// armTimer schedules promote for the earliest delayed job. The caller holds
+// f.mu.
+func (f *fairQueue) armTimer() {
+	if len(f.delayed) == 0 {
+		return
+	}
+	wait := time.Until(f.delayed[0].NotBefore)
+	if f.timer == nil {
+		f.timer = time.AfterFunc(wait, f.promote)
+		return
+	}
+	f.timer.Reset(wait)
+}
+
+// promote moves delayed jobs that are due into the pending lists
+func (f *fairQueue) promote() {
+	f.mu.Lock()
+	now := time.Now()
+	promoted := false
+	for len(f.delayed) > 0 && !f.delayed[0].NotBefore.After(now) {
+		f.enqueue(heap.Pop(&f.delayed).(*BuildJob))
+		promoted = true
+	}
+	f.armTimer()
+	f.mu.Unlock()
+	if promoted {
+		f.signal()
+	}
+}
+
+// takeWaiting removes and returns every pending and delayed job
+func (f *fairQueue) takeWaiting() []*BuildJob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	jobs := make([]*BuildJob, 0, f.size+len(f.delayed))
+	for _, userID := range f.turns {
+		jobs = append(jobs, f.pending[userID]...)
+	}
+	jobs = append(jobs, f.delayed...)
+	f.pending = make(map[string][]*BuildJob)
+	f.turns = nil
+	f.size = 0
+	f.delayed = nil
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	return jobs
+}
+
+func (f *fairQueue) delayedLen() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.delayed)
+}
@@ -0,0 +1,192 @@
+package build
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned for builds that arrive while maxQueued builds are
+// already waiting for a worker
+var ErrQueueFull = errors.New("build queue full")
+
+// fairQueue hands pending jobs to workers fairly. Users with pending jobs
+// take turns, one job per turn, so a user who queues fifty builds does not
+// starve the others; a user with maxInFlight builds running is skipped
+// until one of them finishes. Retries wait in a delay queue until their
+// NotBefore time, without holding a worker. New jobs are refused once
+// maxQueued are waiting; retries of accepted jobs are not.
+type fairQueue struct {
+	mu          sync.Mutex
+	pending     map[string][]*BuildJob // per user, oldest first
+	turns       []string               // users with pending jobs, next turn first
+	inFlight    map[string]int
+	running     map[string]bool // IDs of the builds in flight
+	maxInFlight int             // 0 for no cap
+	maxQueued   int             // pending and delayed jobs; 0 for no cap
+	size        int             // pending jobs of all users
+	wake        chan struct{}   // signalled when a job may have become runnable
+	delayed     delayHeap       // jobs waiting for their NotBefore time
+	timer       *time.Timer     // promotes the earliest delayed job
+}
+
+func newFairQueue(maxInFlight, maxQueued int) *fairQueue {
+	return &fairQueue{
+		pending:     make(map[string][]*BuildJob),
+		inFlight:    make(map[string]int),
+		running:     make(map[string]bool),
+		maxInFlight: maxInFlight,
+		maxQueued:   maxQueued,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// push queues a job at the back of its user's list, or returns
+// ErrQueueFull
+func (f *fairQueue) push(job *BuildJob) error {
+	f.mu.Lock()
+	if f.fullLocked() {
+		f.mu.Unlock()
+		return ErrQueueFull
+	}
+	f.enqueue(job)
+	f.mu.Unlock()
+	f.signal()
+	return nil
+}
+
+// full reports whether push would refuse a job
+func (f *fairQueue) full() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fullLocked()
+}
+
+func (f *fairQueue) fullLocked() bool {
+	return f.maxQueued > 0 && f.size+len(f.delayed) >= f.maxQueued
+}
+
+// enqueue appends a job to its user's list. The caller holds f.mu.
+func (f *fairQueue) enqueue(job *BuildJob) {
+	userID := job.Build.UserID
+	if len(f.pending[userID]) == 0 {
+		f.turns = append(f.turns, userID)
+	}
+	f.pending[userID] = append(f.pending[userID], job)
+	f.size++
+}
+
+// pop takes the next runnable job, or returns nil if every user with
+// pending jobs is at the in-flight cap. The job counts as in flight until
+// done is called.
+func (f *fairQueue) pop() *BuildJob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, userID := range f.turns {
+		if f.maxInFlight > 0 && f.inFlight[userID] >= f.maxInFlight {
+			continue
+		}
+		jobs := f.pending[userID]
+		job := jobs[0]
+		f.turns = append(f.turns[:i:i], f.turns[i+1:]...)
+		if len(jobs) > 1 {
+			f.pending[userID] = jobs[1:]
+			f.turns = append(f.turns, userID)
+		} else {
+			delete(f.pending, userID)
+		}
+		f.inFlight[userID]++
+		f.running[job.Build.ID] = true
+		f.size--
+		if f.size > 0 {
+			// Another worker may be able to take the next job
+			f.signal()
+		}
+		return job
+	}
+	return nil
+}
+
+// done releases the in-flight slot of a job taken with pop
+func (f *fairQueue) done(job *BuildJob) {
+	f.mu.Lock()
+	userID := job.Build.UserID
+	if f.inFlight[userID] <= 1 {
+		delete(f.inFlight, userID)
+	} else {
+		f.inFlight[userID]--
+	}
+	delete(f.running, job.Build.ID)
+	f.mu.Unlock()
+	f.signal()
+}
+
+func (f *fairQueue) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *fairQueue) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.size
+}
+
+// ids returns the IDs of every pending, delayed and in-flight build
+func (f *fairQueue) ids() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := make([]string, 0, f.size+len(f.delayed)+len(f.running))
+	for _, jobs := range f.pending {
+		for _, job := range jobs {
+			ids = append(ids, job.Build.ID)
+		}
+	}
+	for _, job := range f.delayed {
+		ids = append(ids, job.Build.ID)
+	}
+	for id := range f.running {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// position estimates where a pending build stands in line, counting from 1,
+// assuming no user hits the in-flight cap. It returns 0 if the build is not
+// pending.
+func (f *fairQueue) position(userID, buildID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := -1
+	for i, job := range f.pending[userID] {
+		if job.Build.ID == buildID {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return 0
+	}
+
+	// Every user gets index turns before the build's own turn, plus one
+	// more if their turn comes before its user's
+	position := index + 1
+	before := true
+	for _, other := range f.turns {
+		if other == userID {
+			before = false
+			continue
+		}
+		turns := index
+		if before {
+			turns++
+		}
+		position += min(len(f.pending[other]), turns)
+	}
+	return position
+}
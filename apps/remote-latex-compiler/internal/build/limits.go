@@ -21,6 +21,20 @@ func NewLimitService(buildStore *Store, userStore *user.Store) *LimitService {
 }
 
 func (s *LimitService) CanCreateBuild(userID string) (*LimitCheck, error) {
+	return s.check(userID, true)
+}
+
+// CanCreateSubbuild checks whether a subdocument build of a build that
+// passed CanCreateBuild may be queued. It counts against the monthly quota
+// like any build but not against the concurrency limit, which its parent
+// already passed.
+func (s *LimitService) CanCreateSubbuild(userID string) (*LimitCheck, error) {
+	return s.check(userID, false)
+}
+
+// check runs the limit checks of a new build, with the concurrency limit
+// if concurrent is set
+func (s *LimitService) check(userID string, concurrent bool) (*LimitCheck, error) {
 	userRec, err := s.userStore.GetByID(userID)
 	if err != nil {
 		return nil, fmt.Errorf("user not found: %w", err)
@@ -60,7 +74,14 @@ func (s *LimitService) CanCreateBuild(userID string) (*LimitCheck, error) {
 		return nil, fmt.Errorf("failed to count builds: %w", err)
 	}
 
+	overage := false
 	if monthlyCount >= config.MonthlyBuilds {
+		overage, err = s.overageAllowed(userRec, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if monthlyCount >= config.MonthlyBuilds && !overage {
 		return &LimitCheck{
 			Allowed: false,
 			Reason:  "monthly_limit_exceeded",
@@ -72,12 +93,15 @@ func (s *LimitService) CanCreateBuild(userID string) (*LimitCheck, error) {
 	}
 
 	// Count concurrent builds
-	concurrentCount, err := s.buildStore.CountActive(userID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to count concurrent builds: %w", err)
+	concurrentCount := 0
+	if concurrent {
+		concurrentCount, err = s.buildStore.CountActive(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count concurrent builds: %w", err)
+		}
 	}
 
-	if concurrentCount >= config.Concurrent {
+	if concurrent && concurrentCount >= config.Concurrent {
 		return &LimitCheck{
 			Allowed: false,
 			Reason:  "concurrent_limit_exceeded",
@@ -87,12 +111,46 @@ func (s *LimitService) CanCreateBuild(userID string) (*LimitCheck, error) {
 		}, nil
 	}
 
-	return &LimitCheck{
+	check := &LimitCheck{
 		Allowed: true,
 		Tier:    tier,
 		Used:    monthlyCount,
 		Limit:   config.MonthlyBuilds,
-	}, nil
+	}
+	if overage {
+		check.Overage = true
+		check.OveragePrice = config.OveragePrice
+		check.Message = fmt.Sprintf("Monthly build limit reached: this build is charged %s", formatAmount(config.OveragePrice))
+	}
+	return check, nil
+}
+
+// overageAllowed reports whether a user over their monthly quota may build
+// on at the plan's overage price: the plan must have one and the user a
+// subscription in good standing to invoice it through
+func (s *LimitService) overageAllowed(u *user.User, config billing.PlanConfig) (bool, error) {
+	if config.OveragePrice <= 0 || u.RazorpaySubscriptionID == "" {
+		return false, nil
+	}
+	status, _, err := s.userStore.BillingStatus(u.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get billing status: %w", err)
+	}
+	return status == user.BillingActive, nil
+}
+
+// RecordOverage records a build allowed by check as an overage, to be added
+// to the user's next invoice. Builds within the quota are not recorded.
+func (s *LimitService) RecordOverage(userID, buildID string, check *LimitCheck) error {
+	if check == nil || !check.Overage {
+		return nil
+	}
+	return s.userStore.RecordOverage(userID, buildID, billing.PeriodStart(time.Now()), check.OveragePrice)
+}
+
+// formatAmount formats an amount in paise
+func formatAmount(amount int64) string {
+	return fmt.Sprintf("%s %d.%02d", billing.Currency, amount/100, amount%100)
 }
 
 func (s *LimitService) getMonthlyResetTime() *time.Time {
@@ -111,13 +169,15 @@ func (s *LimitService) getMonthlyResetTime() *time.Time {
 }
 
 type LimitCheck struct {
-	Allowed bool       `json:"allowed"`
-	Reason  string     `json:"reason,omitempty"`
-	Message string     `json:"message,omitempty"`
-	Tier    string     `json:"tier,omitempty"`
-	Used    int        `json:"used,omitempty"`
-	Limit   int        `json:"limit,omitempty"`
-	ResetAt *time.Time `json:"reset_at,omitempty"`
+	Allowed      bool       `json:"allowed"`
+	Reason       string     `json:"reason,omitempty"`
+	Message      string     `json:"message,omitempty"`
+	Tier         string     `json:"tier,omitempty"`
+	Used         int        `json:"used,omitempty"`
+	Limit        int        `json:"limit,omitempty"`
+	ResetAt      *time.Time `json:"reset_at,omitempty"`
+	Overage      bool       `json:"overage,omitempty"`       // the build is beyond the monthly quota and charged
+	OveragePrice int64      `json:"overage_price,omitempty"` // in paise
 }
 
 // GetUserUsage returns usage statistics for a user
@@ -152,7 +212,12 @@ func (s *LimitService) GetUserUsage(userID string) (*UsageStats, error) {
 
 	resetTime := s.getMonthlyResetTime()
 
-	return &UsageStats{
+	overageBuilds, overageCharge, err := s.userStore.OverageTotal(userID, billing.PeriodStart(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get overages: %w", err)
+	}
+
+	stats := &UsageStats{
 		Tier:            tier,
 		MonthlyUsed:     monthlyCount,
 		MonthlyLimit:    monthlyLimit,
@@ -161,7 +226,32 @@ func (s *LimitService) GetUserUsage(userID string) (*UsageStats, error) {
 		ConcurrentLimit: config.Concurrent,
 		StorageUsedGB:   float64(totalStorage) / (1024 * 1024 * 1024),
 		StorageLimitGB:  float64(config.StorageGB),
-	}, nil
+		OverageBuilds:   overageBuilds,
+		OverageCharge:   overageCharge,
+	}
+	if config.OveragePrice > 0 && monthlyLimit != -1 {
+		stats.OveragePrice = config.OveragePrice
+		stats.Currency = billing.Currency
+		projected := ProjectOverageBuilds(monthlyCount, monthlyLimit, time.Now())
+		stats.ProjectedOverageCharge = overageCharge + int64(max(projected-overageBuilds, 0))*config.OveragePrice
+	} else if overageCharge > 0 {
+		stats.Currency = billing.Currency
+		stats.ProjectedOverageCharge = overageCharge
+	}
+	return stats, nil
+}
+
+// ProjectOverageBuilds extrapolates the builds of the month so far to the
+// whole month and returns how many of them would be beyond the quota
+func ProjectOverageBuilds(used, limit int, now time.Time) int {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	days := start.AddDate(0, 1, 0).Sub(start).Hours() / 24
+	// At least a day has passed, so the first builds of a month are not
+	// projected from a few hours
+	elapsed := max(now.Sub(start).Hours()/24, 1)
+	projected := int(float64(used) * days / min(elapsed, days))
+	return max(projected-limit, 0)
 }
 
 type UsageStats struct {
@@ -173,4 +263,11 @@ type UsageStats struct {
 	ConcurrentLimit int        `json:"concurrent_limit"`
 	StorageUsedGB   float64    `json:"storage_used_gb"`
 	StorageLimitGB  float64    `json:"storage_limit_gb"`
+	// Builds beyond the monthly quota this month and what they cost so far,
+	// added to the next invoice; the projection extrapolates the month's pace
+	OverageBuilds          int    `json:"overage_builds"`
+	OveragePrice           int64  `json:"overage_price,omitempty"`
+	OverageCharge          int64  `json:"overage_charge"`
+	ProjectedOverageCharge int64  `json:"projected_overage_charge"`
+	Currency               string `json:"currency,omitempty"`
 }
@@ -0,0 +1,127 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/objstore"
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// uploadTimeout bounds the upload of one build's artifacts
+const uploadTimeout = 2 * time.Minute
+
+// ArtifactResources are the artifacts uploaded to object storage. Logs are
+// kept in the database and always served by the API.
+var ArtifactResources = []string{"pdf", "synctex"}
+
+// ArtifactKey returns the object key of a build artifact
+func ArtifactKey(userID, buildID, resource string) string {
+	return UserArtifactPrefix(userID) + buildID + "/" + resource
+}
+
+// UserArtifactPrefix returns the prefix of the object keys of all of a
+// user's artifacts
+func UserArtifactPrefix(userID string) string {
+	return "builds/" + userID + "/"
+}
+
+// ArtifactPath returns the file of a build artifact on disk, "" if the build
+// has none
+func ArtifactPath(b *buildpkg.Build, resource string) string {
+	switch resource {
+	case "pdf":
+		return b.PDFPath
+	case "synctex":
+		return b.SyncTeXPath
+	}
+	return ""
+}
+
+// StoreArtifacts uploads the artifacts of builds finishing from now on to
+// objects; nil keeps them on local disk only
+func (q *Queue) StoreArtifacts(objects *objstore.Store) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.objects = objects
+}
+
+func (q *Queue) artifactObjects() *objstore.Store {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.objects
+}
+
+// uploadArtifacts streams the artifacts of a completed build to object
+// storage before the build is reported completed, so clients are handed
+// bucket URLs from the start. Encrypted builds stay on disk, as their files
+// are decrypted when served. Failed uploads are logged and the build is
+// served from disk instead.
+func (w *Worker) uploadArtifacts(job *BuildJob) {
+	objects := w.objects()
+	if objects == nil || job.Status != JobCompleted || job.Build.Encrypted {
+		return
+	}
+
+	b := job.Build
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	for _, resource := range ArtifactResources {
+		path := ArtifactPath(b, resource)
+		if path == "" {
+			continue
+		}
+		if err := objects.PutFile(ctx, ArtifactKey(b.UserID, b.ID, resource), path, artifactContentType(path)); err != nil {
+			log.Printf("Worker %d: Failed to upload %s of build %s: %v", w.id, resource, b.ID, err)
+			return
+		}
+	}
+	if err := w.store.SetObjectStored(b.ID, true); err != nil {
+		log.Printf("Worker %d: Failed to record upload of build %s: %v", w.id, b.ID, err)
+	}
+}
+
+// artifactContentType returns the content type an artifact is stored with
+func artifactContentType(path string) string {
+	switch filepath.Ext(path) {
+	case ".pdf":
+		return "application/pdf"
+	case ".gz":
+		return "application/gzip"
+	}
+	return "application/octet-stream"
+}
+
+// DeleteArtifacts removes the artifacts of a build from object storage.
+// Builds never uploaded have nothing to remove, which succeeds.
+func DeleteArtifacts(ctx context.Context, objects *objstore.Store, userID, buildID string) error {
+	for _, resource := range ArtifactResources {
+		if err := objects.Delete(ctx, ArtifactKey(userID, buildID, resource)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetObjectStored records whether the artifacts of a build are in object
+// storage
+func (s *Store) SetObjectStored(id string, stored bool) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+	_, err := s.db.Exec(`UPDATE builds SET object_stored = $1 WHERE id = $2`, stored, id)
+	return err
+}
+
+// ObjectStored reports whether the artifacts of a build are in object storage
+func (s *Store) ObjectStored(id string) (bool, error) {
+	if s.db == nil {
+		return false, fmt.Errorf("store not initialized with database")
+	}
+	var stored bool
+	err := s.db.QueryRow(`SELECT object_stored FROM builds WHERE id = $1`, id).Scan(&stored)
+	return stored, err
+}
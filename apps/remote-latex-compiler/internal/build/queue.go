@@ -2,12 +2,16 @@ package build
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/atrest"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/objstore"
 	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/google/uuid"
 )
 
 // JobStatus tracks build job status
@@ -20,6 +24,10 @@ const (
 	JobFailed     JobStatus = "failed"
 )
 
+// DefaultMaxRetries is how often a failed build is retried before it is
+// dead-lettered
+const DefaultMaxRetries = 3
+
 // BuildJob represents a build job in the queue
 type BuildJob struct {
 	Build       *buildpkg.Build
@@ -28,107 +36,284 @@ type BuildJob struct {
 	MaxRetries  int
 	Error       error
 	CreatedAt   time.Time
+	NotBefore   time.Time // a retry waits in the delay queue until then
 	StartedAt   *time.Time
 	CompletedAt *time.Time
 }
 
 // Queue manages build job queue with worker pool
 type Queue struct {
-	jobs       chan *BuildJob
+	jobs       *fairQueue
 	workers    int
 	workerPool []*Worker
 	store      *Store
 	wg         sync.WaitGroup
 	done       chan struct{}
 	mu         sync.RWMutex
+	onFinish   FinishHook
+	keyring    *atrest.Keyring
+	objects    *objstore.Store
+	instanceID string // identifies the instance in capacity reports
+
+	compiler buildpkg.Compiler
+	previews int
+	results  resultStats
+	nextID   int
+	draining bool // Drain was called; the pool stays empty
 }
 
+// FinishHook is called after a build reaches a final status
+type FinishHook func(build *buildpkg.Build, duration time.Duration)
+
 // Worker processes build jobs
 type Worker struct {
 	id       int
-	queue    chan *BuildJob
+	queue    *fairQueue
 	compiler buildpkg.Compiler
 	store    *Store
 	done     chan struct{}
+	stop     chan struct{} // closed to drain the worker after its current job
+	previews int
+	results  *resultStats
+	finished func(build *buildpkg.Build, duration time.Duration)
+	exited   func(w *Worker)
+	keyring  func() *atrest.Keyring
+	objects  func() *objstore.Store
+
+	mu        sync.Mutex
+	startedAt time.Time
+	busySince time.Time // zero while idle
+	busyTime  time.Duration
+	buildID   string
+	processed int
+	stopping  bool
 }
 
-// NewQueue creates a new build queue with worker pool (Issue #8)
-func NewQueue(numWorkers int, compiler buildpkg.Compiler, store *Store) *Queue {
+// NewQueue creates a new build queue with worker pool (Issue #8).
+// previewPages is the number of leading pages rendered to PNG after a
+// successful build; zero disables preview generation. Users take turns and
+// may have at most maxInFlight builds compiling at once, and at most
+// maxQueued builds wait for a worker before Enqueue returns ErrQueueFull;
+// zero means no cap. A store scoped with ForRegion limits the queue to
+// builds of that region.
+func NewQueue(numWorkers int, compiler buildpkg.Compiler, store *Store, previewPages, maxInFlight, maxQueued int) *Queue {
 	q := &Queue{
-		jobs:    make(chan *BuildJob, 100), // Buffer 100 jobs
-		workers: numWorkers,
-		store:   store,
-		done:    make(chan struct{}),
+		jobs:       newFairQueue(maxInFlight, maxQueued),
+		store:      store,
+		done:       make(chan struct{}),
+		compiler:   compiler,
+		previews:   previewPages,
+		instanceID: uuid.New().String(),
 	}
 
+	q.mu.Lock()
 	for i := 0; i < numWorkers; i++ {
-		worker := &Worker{
-			id:       i,
-			queue:    q.jobs,
-			compiler: compiler,
-			store:    store,
-			done:     q.done,
-		}
-		q.workerPool = append(q.workerPool, worker)
-		q.wg.Add(1)
-		go worker.process(&q.wg)
+		q.startWorker()
 	}
+	q.mu.Unlock()
 
 	return q
 }
 
-// Enqueue adds a job to the queue
+// startWorker adds a worker to the pool. The caller holds q.mu.
+func (q *Queue) startWorker() {
+	worker := &Worker{
+		id:        q.nextID,
+		queue:     q.jobs,
+		compiler:  q.compiler,
+		store:     q.store,
+		done:      q.done,
+		stop:      make(chan struct{}),
+		previews:  q.previews,
+		results:   &q.results,
+		finished:  q.buildFinished,
+		exited:    q.workerExited,
+		keyring:   q.artifactKeyring,
+		objects:   q.artifactObjects,
+		startedAt: time.Now(),
+	}
+	q.nextID++
+	q.workerPool = append(q.workerPool, worker)
+	q.workers++
+	q.wg.Add(1)
+	go worker.process(&q.wg)
+}
+
+// workerExited removes a drained worker from the pool
+func (q *Queue) workerExited(w *Worker) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, pw := range q.workerPool {
+		if pw == w {
+			q.workerPool = append(q.workerPool[:i], q.workerPool[i+1:]...)
+			break
+		}
+	}
+}
+
+// Enqueue adds a job to the queue. It returns ErrQueueFull if too many
+// builds are already waiting.
 func (q *Queue) Enqueue(build *buildpkg.Build) error {
+	return q.enqueue(build, 0)
+}
+
+// enqueue adds a job that has already used up retries of its MaxRetries
+func (q *Queue) enqueue(build *buildpkg.Build, retries int) error {
 	if build.ID == "" || build.UserID == "" {
 		return fmt.Errorf("invalid build")
 	}
+	if region := q.store.region; region != "" && build.Region != region {
+		return fmt.Errorf("build %s belongs to region %q, not %q", build.ID, build.Region, region)
+	}
 
 	job := &BuildJob{
 		Build:      build,
 		Status:     JobPending,
-		MaxRetries: 3,
+		Retries:    retries,
+		MaxRetries: DefaultMaxRetries,
 		CreatedAt:  time.Now(),
 	}
 
 	select {
-	case q.jobs <- job:
-		log.Printf("Enqueued build job: %s", build.ID)
-		return nil
 	case <-q.done:
 		return fmt.Errorf("queue is closed")
+	default:
 	}
+	if q.isDraining() {
+		if err := q.store.Release([]string{build.ID}); err != nil {
+			return err
+		}
+		log.Printf("Released build %s to other instances: queue is draining", build.ID)
+		return nil
+	}
+	if err := q.jobs.push(job); err != nil {
+		return err
+	}
+	log.Printf("Enqueued build job: %s", build.ID)
+	return nil
+}
+
+// Full reports whether Enqueue would refuse a build for a full queue
+func (q *Queue) Full() bool {
+	return q.jobs.full()
+}
+
+// Position returns where a pending build stands in line, counting from 1,
+// or 0 if it is not waiting for a worker
+func (q *Queue) Position(build *buildpkg.Build) int {
+	return q.jobs.position(build.UserID, build.ID)
 }
 
 // Stop gracefully shuts down the queue and waits for jobs to complete
 func (q *Queue) Stop() {
 	close(q.done)
 	q.wg.Wait()
-	close(q.jobs)
+	if err := q.store.RemoveInstance(q.instanceID); err != nil {
+		log.Printf("Failed to remove instance from capacity reports: %v", err)
+	}
 	log.Println("Build queue stopped")
 }
 
+// OnFinish registers a hook called after each build completes or fails
+func (q *Queue) OnFinish(hook FinishHook) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onFinish = hook
+}
+
+func (q *Queue) buildFinished(build *buildpkg.Build, duration time.Duration) {
+	q.mu.RLock()
+	hook := q.onFinish
+	q.mu.RUnlock()
+	if hook != nil {
+		hook(build, duration)
+	}
+}
+
+// EncryptArtifacts seals the artifacts of builds finishing from now on with
+// keys derived from keyring; nil leaves them in the clear
+func (q *Queue) EncryptArtifacts(keyring *atrest.Keyring) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.keyring = keyring
+}
+
+func (q *Queue) artifactKeyring() *atrest.Keyring {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.keyring
+}
+
 // GetStore returns the underlying Store for direct access to builds
 func (q *Queue) GetStore() *Store {
 	return q.store
 }
 
-// Worker processes jobs
+// Worker processes jobs until the queue stops or the worker is drained. A
+// drained worker finishes the job it is running first.
 func (w *Worker) process(wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer w.exited(w)
 
 	for {
 		select {
-		case job := <-w.queue:
-			if job == nil {
-				return
-			}
+		case <-w.stop:
+			log.Printf("Worker %d: Drained", w.id)
+			return
+		default:
+		}
+
+		if job := w.queue.pop(); job != nil {
+			w.setBusy(job.Build.ID)
 			w.executeJob(job)
+			w.setIdle()
+			w.queue.done(job)
+			continue
+		}
+
+		select {
+		case <-w.queue.wake:
+		case <-w.stop:
+			log.Printf("Worker %d: Drained", w.id)
+			return
 		case <-w.done:
 			return
 		}
 	}
 }
 
+// renderPreviews pre-renders page images so thin clients can show the
+// output without a PDF renderer. Failures are logged and never fail the build.
+func (w *Worker) renderPreviews(build *buildpkg.Build) {
+	if w.previews <= 0 || build.PDFPath == "" || build.DirPath == "" {
+		return
+	}
+
+	pages, err := buildpkg.RenderPreviews(build.PDFPath, build.DirPath, w.previews)
+	if err != nil {
+		log.Printf("Worker %d: Preview rendering failed for build %s: %v", w.id, build.ID, err)
+		return
+	}
+	log.Printf("Worker %d: Rendered %d preview pages for build %s", w.id, pages, build.ID)
+}
+
+// sealArtifacts encrypts the artifacts of a finished build when artifact
+// encryption is on. A build whose artifacts cannot be encrypted fails rather
+// than keep them in the clear.
+func (w *Worker) sealArtifacts(job *BuildJob) {
+	keyring := w.keyring()
+	if keyring == nil {
+		return
+	}
+	if err := keyring.SealBuild(job.Build); err != nil {
+		log.Printf("Worker %d: Failed to encrypt artifacts of build %s: %v", w.id, job.Build.ID, err)
+		job.Status = JobFailed
+		job.Error = err
+		job.Build.Status = buildpkg.StatusFailed
+		job.Build.ErrorMessage = "Failed to encrypt build artifacts"
+	}
+}
+
 // executeJob executes a build job with retry logic (Issue #20 - error recovery)
 func (w *Worker) executeJob(job *BuildJob) {
 	job.Status = JobProcessing
@@ -137,6 +322,12 @@ func (w *Worker) executeJob(job *BuildJob) {
 
 	log.Printf("Worker %d: Processing build %s", w.id, job.Build.ID)
 
+	// Count the attempt so the reaper knows how many retries remain if
+	// this instance dies mid-build
+	if err := w.store.StartAttempt(job.Build.ID); err != nil {
+		log.Printf("Failed to record attempt for build %s: %v", job.Build.ID, err)
+	}
+
 	// Update status to compiling when worker starts
 	job.Build.Status = buildpkg.StatusCompiling
 	job.Build.UpdatedAt = time.Now()
@@ -152,6 +343,9 @@ func (w *Worker) executeJob(job *BuildJob) {
 		job.Error = fmt.Errorf("compiler not initialized")
 		job.Build.Status = buildpkg.StatusFailed
 		job.Build.ErrorMessage = "Compiler not initialized"
+	} else if w.reuseResult(job.Build) {
+		job.Status = JobCompleted
+		w.renderPreviews(job.Build)
 	} else if err := w.compiler.Compile(job.Build); err != nil {
 		log.Printf("Compilation failed: %v", err)
 
@@ -168,14 +362,13 @@ func (w *Worker) executeJob(job *BuildJob) {
 				log.Printf("Failed to update build status to retrying: %v", updateErr)
 			}
 
-			// Re-enqueue job after backoff
+			// Re-enqueue job after backoff; the worker moves on meanwhile
 			backoff := time.Duration(job.Retries) * 30 * time.Second
-			log.Printf("Waiting %v before retry %d/%d for build %s", backoff, job.Retries, job.MaxRetries, job.Build.ID)
-			time.Sleep(backoff)
-			log.Printf("Retrying build %s (attempt %d/%d)", job.Build.ID, job.Retries, job.MaxRetries)
+			log.Printf("Scheduling retry %d/%d for build %s in %v", job.Retries, job.MaxRetries, job.Build.ID, backoff)
 
 			job.Status = JobPending
-			w.queue <- job
+			job.NotBefore = time.Now().Add(backoff)
+			w.queue.pushAfter(job)
 			return
 		}
 
@@ -185,7 +378,10 @@ func (w *Worker) executeJob(job *BuildJob) {
 	} else {
 		job.Status = JobCompleted
 		job.Build.Status = buildpkg.StatusCompleted
+		w.renderPreviews(job.Build)
 	}
+	w.sealArtifacts(job)
+	w.uploadArtifacts(job)
 
 	job.Build.UpdatedAt = time.Now()
 	now = time.Now()
@@ -194,8 +390,14 @@ func (w *Worker) executeJob(job *BuildJob) {
 	if err := w.store.Update(job.Build); err != nil {
 		log.Printf("Failed to update build: %v", err)
 	}
+	if job.Status == JobFailed && job.Retries >= job.MaxRetries {
+		if err := w.store.DeadLetter(job.Build.ID); err != nil {
+			log.Printf("Failed to dead-letter build %s: %v", job.Build.ID, err)
+		}
+	}
 
 	log.Printf("Worker %d: Completed build %s with status %s", w.id, job.Build.ID, job.Status)
+	w.finished(job.Build, time.Since(job.Build.CreatedAt))
 }
 
 // GetJobStatus returns the status of a job (for monitoring)
@@ -230,8 +432,9 @@ func (q *Queue) GetJobStatus(buildID string) (JobStatus, error) {
 
 // Store manages build persistence using database
 type Store struct {
-	db *sql.DB
-	mu sync.RWMutex
+	db     *sql.DB
+	mu     sync.RWMutex
+	region string // limits the builds picked up for work or cleanup; "" for all
 }
 
 // NewStore creates a new build store backed by database
@@ -249,6 +452,12 @@ func NewStoreWithDB(db *sql.DB) *Store {
 	}
 }
 
+// ForRegion returns a store whose queries picking builds to compile, reuse
+// or clean up only see builds of the region. Lookups by ID see every build.
+func (s *Store) ForRegion(region string) *Store {
+	return &Store{db: s.db, region: region}
+}
+
 // Create creates a new build record in the database
 func (s *Store) Create(build *buildpkg.Build) error {
 	if s.db == nil {
@@ -257,8 +466,9 @@ func (s *Store) Create(build *buildpkg.Build) error {
 
 	query := `
 	INSERT INTO builds (id, user_id, status, engine, main_file, dir_path, pdf_path, synctex_path, 
-		build_log, error_message, shell_escape, created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULL)
+		build_log, error_message, shell_escape, created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at, parent_id,
+		notify_email, shell_restricted, env, out_dir, reproducible, sandbox, timeout_seconds, index_processor, region, auto_shell)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULL, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)
 	`
 
 	_, err := s.db.Exec(query,
@@ -278,11 +488,43 @@ func (s *Store) Create(build *buildpkg.Build) error {
 		build.ExpiresAt,
 		build.LastAccessedAt,
 		build.StorageBytes,
+		nullIfEmpty(build.ParentID),
+		build.NotifyEmail,
+		build.ShellRestricted,
+		envJSON(build.Env),
+		nullIfEmpty(build.OutDir),
+		build.Reproducible,
+		nullIfEmpty(build.Sandbox),
+		build.Timeout,
+		nullIfEmpty(build.Index),
+		build.Region,
+		build.AutoShell,
 	)
 
 	return err
 }
 
+// nullIfEmpty returns nil for empty strings so optional columns store NULL
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// envJSON encodes a build environment for the env column, or returns nil
+// when there is none
+func envJSON(env map[string]string) interface{} {
+	if len(env) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
 // Get retrieves a build by ID
 func (s *Store) Get(id string) (*buildpkg.Build, error) {
 	if s.db == nil {
@@ -291,11 +533,14 @@ func (s *Store) Get(id string) (*buildpkg.Build, error) {
 
 	query := `
 	SELECT id, user_id, status, engine, main_file, dir_path, pdf_path, synctex_path,
-		build_log, error_message, shell_escape, created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
+		build_log, error_message, shell_escape, created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at,
+		pinned, shell_restricted, env, out_dir, reproducible, manifest_hash, sandbox, timeout_seconds, index_processor, result_key,
+		encrypted, region, auto_shell
 	FROM builds WHERE id = $1
 	`
 
 	var b buildpkg.Build
+	var env, outDir, manifestHash, sandbox, index, resultKey sql.NullString
 	err := s.db.QueryRow(query, id).Scan(
 		&b.ID,
 		&b.UserID,
@@ -314,6 +559,19 @@ func (s *Store) Get(id string) (*buildpkg.Build, error) {
 		&b.LastAccessedAt,
 		&b.StorageBytes,
 		&b.DeletedAt,
+		&b.Pinned,
+		&b.ShellRestricted,
+		&env,
+		&outDir,
+		&b.Reproducible,
+		&manifestHash,
+		&sandbox,
+		&b.Timeout,
+		&index,
+		&resultKey,
+		&b.Encrypted,
+		&b.Region,
+		&b.AutoShell,
 	)
 
 	if err != nil {
@@ -322,11 +580,22 @@ func (s *Store) Get(id string) (*buildpkg.Build, error) {
 		}
 		return nil, err
 	}
+	b.OutDir = outDir.String
+	b.ManifestHash = manifestHash.String
+	b.Sandbox = sandbox.String
+	b.Index = index.String
+	b.ResultKey = resultKey.String
+	if env.Valid {
+		if err := json.Unmarshal([]byte(env.String), &b.Env); err != nil {
+			return nil, fmt.Errorf("invalid build environment: %w", err)
+		}
+	}
 
 	return &b, nil
 }
 
-// Update updates a build record in the database
+// Update updates a build record in the database. Encrypted is only ever
+// set: builds loaded by the list queries do not carry it.
 func (s *Store) Update(build *buildpkg.Build) error {
 	if s.db == nil {
 		return fmt.Errorf("store not initialized with database")
@@ -335,8 +604,9 @@ func (s *Store) Update(build *buildpkg.Build) error {
 	query := `
 	UPDATE builds 
 	SET status = $1, pdf_path = $2, synctex_path = $3, build_log = $4, error_message = $5, 
-		updated_at = $6, last_accessed_at = $7, storage_bytes = $8
-	WHERE id = $9
+		updated_at = $6, last_accessed_at = $7, storage_bytes = $8, manifest_hash = $9, result_key = $10,
+		encrypted = encrypted OR $11
+	WHERE id = $12
 	`
 
 	_, err := s.db.Exec(query,
@@ -348,12 +618,38 @@ func (s *Store) Update(build *buildpkg.Build) error {
 		build.UpdatedAt,
 		build.LastAccessedAt,
 		build.StorageBytes,
+		nullIfEmpty(build.ManifestHash),
+		nullIfEmpty(build.ResultKey),
+		build.Encrypted,
 		build.ID,
 	)
 
 	return err
 }
 
+// FindResult returns the user's latest completed build with the result key,
+// other than exclude, or nil if there is none
+func (s *Store) FindResult(userID, key, exclude string) (*buildpkg.Build, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	var id string
+	err := s.db.QueryRow(`
+	SELECT id FROM builds
+	WHERE user_id = $1 AND result_key = $2 AND id <> $3 AND status = $4 AND deleted_at IS NULL AND pdf_path <> ''
+		AND ($5 = '' OR region = $5)
+	ORDER BY updated_at DESC LIMIT 1
+	`, userID, key, exclude, buildpkg.StatusCompleted, s.region).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Get(id)
+}
+
 // Delete deletes a build record from the database
 func (s *Store) Delete(id string) error {
 	if s.db == nil {
@@ -375,9 +671,10 @@ func (s *Store) ListByUser(userID string, page, pageSize int) ([]*buildpkg.Build
 	offset := (page - 1) * pageSize
 	query := `
 	SELECT id, user_id, status, engine, main_file, dir_path, pdf_path, synctex_path,
-		build_log, error_message, shell_escape, created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
+		build_log, error_message, shell_escape, created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at,
+		pinned
 	FROM builds 
-	WHERE user_id = $1 AND deleted_at IS NULL
+	WHERE user_id = $1 AND deleted_at IS NULL AND parent_id IS NULL
 	ORDER BY created_at DESC
 	LIMIT $2 OFFSET $3
 	`
@@ -409,6 +706,7 @@ func (s *Store) ListByUser(userID string, page, pageSize int) ([]*buildpkg.Build
 			&b.LastAccessedAt,
 			&b.StorageBytes,
 			&b.DeletedAt,
+			&b.Pinned,
 		)
 		if err != nil {
 			return nil, err
@@ -419,19 +717,101 @@ func (s *Store) ListByUser(userID string, page, pageSize int) ([]*buildpkg.Build
 	return builds, rows.Err()
 }
 
-// CountByUser counts total non-deleted builds for a user
+// ListChildren lists the subdocument builds spawned by a parent build
+func (s *Store) ListChildren(parentID string) ([]*buildpkg.Build, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	SELECT id, user_id, status, engine, main_file, dir_path, pdf_path, 
+	       synctex_path, build_log, error_message, shell_escape, 
+	       created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
+	FROM builds
+	WHERE parent_id = $1 AND deleted_at IS NULL
+	ORDER BY main_file ASC
+	`
+
+	rows, err := s.db.Query(query, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var builds []*buildpkg.Build
+	for rows.Next() {
+		b := &buildpkg.Build{ParentID: parentID}
+		err := rows.Scan(&b.ID, &b.UserID, &b.Status, &b.Engine, &b.MainFile,
+			&b.DirPath, &b.PDFPath, &b.SyncTeXPath, &b.BuildLog, &b.ErrorMessage,
+			&b.ShellEscape, &b.CreatedAt, &b.UpdatedAt, &b.ExpiresAt,
+			&b.LastAccessedAt, &b.StorageBytes, &b.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, b)
+	}
+
+	return builds, rows.Err()
+}
+
+// ExportByUser lists the metadata of every build of a user, including
+// subdocument and soft-deleted builds, for a data export. Paths and logs are
+// left out.
+func (s *Store) ExportByUser(userID string) ([]*buildpkg.Build, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	SELECT id, user_id, parent_id, status, engine, main_file, error_message, shell_escape,
+		created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at,
+		pinned, reproducible, manifest_hash, sandbox, timeout_seconds, encrypted
+	FROM builds
+	WHERE user_id = $1
+	ORDER BY created_at ASC
+	`
+
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var builds []*buildpkg.Build
+	for rows.Next() {
+		var b buildpkg.Build
+		var parentID, manifestHash, sandbox sql.NullString
+		err := rows.Scan(&b.ID, &b.UserID, &parentID, &b.Status, &b.Engine, &b.MainFile,
+			&b.ErrorMessage, &b.ShellEscape, &b.CreatedAt, &b.UpdatedAt, &b.ExpiresAt,
+			&b.LastAccessedAt, &b.StorageBytes, &b.DeletedAt, &b.Pinned, &b.Reproducible,
+			&manifestHash, &sandbox, &b.Timeout, &b.Encrypted)
+		if err != nil {
+			return nil, err
+		}
+		b.ParentID = parentID.String
+		b.ManifestHash = manifestHash.String
+		b.Sandbox = sandbox.String
+		builds = append(builds, &b)
+	}
+
+	return builds, rows.Err()
+}
+
+// CountByUser counts total non-deleted builds for a user, leaving out
+// subdocument builds as ListByUser does
 func (s *Store) CountByUser(userID string) (int, error) {
 	if s.db == nil {
 		return 0, fmt.Errorf("store not initialized with database")
 	}
 
-	query := `SELECT COUNT(*) FROM builds WHERE user_id = $1 AND deleted_at IS NULL`
+	query := `SELECT COUNT(*) FROM builds WHERE user_id = $1 AND deleted_at IS NULL AND parent_id IS NULL`
 	var count int
 	err := s.db.QueryRow(query, userID).Scan(&count)
 	return count, err
 }
 
-// CountMonthly counts monthly builds for a user (created in current month)
+// CountMonthly counts monthly builds for a user (created in current month).
+// Subdocument builds count like any other.
 func (s *Store) CountMonthly(userID string) (int, error) {
 	if s.db == nil {
 		return 0, fmt.Errorf("store not initialized with database")
@@ -451,7 +831,8 @@ func (s *Store) CountMonthly(userID string) (int, error) {
 	return count, err
 }
 
-// CountActive counts active (pending or compiling) builds for a user
+// CountActive counts active (pending or compiling) builds for a user,
+// subdocument builds included
 func (s *Store) CountActive(userID string) (int, error) {
 	if s.db == nil {
 		return 0, fmt.Errorf("store not initialized with database")
@@ -483,6 +864,34 @@ func (s *Store) GetTotalStorage(userID string) (int64, error) {
 	return total, err
 }
 
+// SetPinned pins or unpins a build. Pinned builds are skipped by every
+// cleanup pass; expiresAt is the expiry to resume with once unpinned.
+func (s *Store) SetPinned(id string, pinned bool, expiresAt time.Time) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+
+	_, err := s.db.Exec(`UPDATE builds SET pinned = $1, expires_at = $2, updated_at = $3 WHERE id = $4`,
+		pinned, expiresAt, time.Now(), id)
+	return err
+}
+
+// GetPinnedStorage gets the storage held by a user's pinned builds
+func (s *Store) GetPinnedStorage(userID string) (int64, error) {
+	if s.db == nil {
+		return 0, fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	SELECT COALESCE(SUM(storage_bytes), 0) FROM builds 
+	WHERE user_id = $1 AND pinned AND deleted_at IS NULL
+	`
+
+	var total int64
+	err := s.db.QueryRow(query, userID).Scan(&total)
+	return total, err
+}
+
 // FindExpiredBefore finds builds that expired before the given time
 func (s *Store) FindExpiredBefore(before time.Time) ([]*buildpkg.Build, error) {
 	query := `
@@ -490,11 +899,12 @@ func (s *Store) FindExpiredBefore(before time.Time) ([]*buildpkg.Build, error) {
 	       synctex_path, build_log, error_message, shell_escape, 
 	       created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
 	FROM builds
-	WHERE expires_at < $1 AND deleted_at IS NULL AND status != $2
+	WHERE expires_at < $1 AND deleted_at IS NULL AND status != $2 AND NOT pinned
+		AND ($3 = '' OR region = $3)
 	ORDER BY created_at ASC
 	`
 
-	rows, err := s.db.Query(query, before, buildpkg.StatusExpired)
+	rows, err := s.db.Query(query, before, buildpkg.StatusExpired, s.region)
 	if err != nil {
 		return nil, err
 	}
@@ -523,12 +933,12 @@ func (s *Store) FindOldest(limit int) ([]*buildpkg.Build, error) {
 	       synctex_path, build_log, error_message, shell_escape, 
 	       created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
 	FROM builds
-	WHERE deleted_at IS NULL AND status != $1
+	WHERE deleted_at IS NULL AND status != $1 AND NOT pinned AND ($3 = '' OR region = $3)
 	ORDER BY created_at ASC
 	LIMIT $2
 	`
 
-	rows, err := s.db.Query(query, buildpkg.StatusExpired, limit)
+	rows, err := s.db.Query(query, buildpkg.StatusExpired, limit, s.region)
 	if err != nil {
 		return nil, err
 	}
@@ -559,11 +969,12 @@ func (s *Store) FindExpiringIn(duration time.Duration) ([]*buildpkg.Build, error
 	       synctex_path, build_log, error_message, shell_escape, 
 	       created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
 	FROM builds
-	WHERE expires_at < $1 AND expires_at > $2 AND deleted_at IS NULL AND status != $3
+	WHERE expires_at < $1 AND expires_at > $2 AND deleted_at IS NULL AND status != $3 AND NOT pinned
+		AND ($4 = '' OR region = $4)
 	ORDER BY expires_at ASC
 	`
 
-	rows, err := s.db.Query(query, expireBefore, time.Now(), buildpkg.StatusExpired)
+	rows, err := s.db.Query(query, expireBefore, time.Now(), buildpkg.StatusExpired, s.region)
 	if err != nil {
 		return nil, err
 	}
@@ -592,12 +1003,12 @@ func (s *Store) FindOldestByUser(userID string, limit int) ([]*buildpkg.Build, e
 	       synctex_path, build_log, error_message, shell_escape, 
 	       created_at, updated_at, expires_at, last_accessed_at, storage_bytes, deleted_at
 	FROM builds
-	WHERE user_id = $1 AND deleted_at IS NULL AND status != $2
+	WHERE user_id = $1 AND deleted_at IS NULL AND status != $2 AND NOT pinned AND ($4 = '' OR region = $4)
 	ORDER BY created_at ASC
 	LIMIT $3
 	`
 
-	rows, err := s.db.Query(query, userID, buildpkg.StatusExpired, limit)
+	rows, err := s.db.Query(query, userID, buildpkg.StatusExpired, limit, s.region)
 	if err != nil {
 		return nil, err
 	}
@@ -641,6 +1052,42 @@ func (s *Store) GetAllIDs() ([]string, error) {
 	return ids, rows.Err()
 }
 
+// BuildDir is a build record with the directory holding its files
+type BuildDir struct {
+	ID      string
+	UserID  string
+	Status  buildpkg.Status
+	DirPath string
+}
+
+// ListDirsBefore returns the directories of the live builds not updated
+// since before, the records the work dir is reconciled against
+func (s *Store) ListDirsBefore(before time.Time) ([]BuildDir, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	rows, err := s.db.Query(`
+	SELECT id, user_id, status, dir_path FROM builds
+	WHERE deleted_at IS NULL AND status NOT IN ($1, $2) AND updated_at < $3
+		AND ($4 = '' OR region = $4)
+	`, buildpkg.StatusExpired, buildpkg.StatusDeleted, before, s.region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dirs []BuildDir
+	for rows.Next() {
+		var d BuildDir
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Status, &d.DirPath); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, rows.Err()
+}
+
 // CountAll returns the total number of non-deleted, non-expired builds
 func (s *Store) CountAll() (int64, error) {
 	if s.db == nil {
@@ -0,0 +1,327 @@
+package build
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+const (
+	// DefaultReaperInterval is used when a Reaper has no Interval
+	DefaultReaperInterval = time.Minute
+	// DefaultStaleAfter is used when a Reaper has no StaleAfter
+	DefaultStaleAfter = 15 * time.Minute
+)
+
+// Reaper recovers builds that no instance is working on any more, such as
+// builds left compiling when a worker crashed. Every instance heartbeats the
+// builds it holds, and its capacity for the admin API; a build whose
+// heartbeat is older than StaleAfter is requeued here if it has retries left
+// and dead-lettered otherwise. Only builds of the queue's region are swept.
+type Reaper struct {
+	Queue      *Queue
+	Interval   time.Duration // how often to heartbeat and sweep; DefaultReaperInterval if not positive
+	StaleAfter time.Duration // heartbeat age after which a build is orphaned; DefaultStaleAfter if not positive
+}
+
+// DeadLetter is a build that failed on every attempt it was allowed
+type DeadLetter struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	Engine         string    `json:"engine"`
+	MainFile       string    `json:"main_file"`
+	Region         string    `json:"region,omitempty"`
+	ErrorMessage   string    `json:"error_message"`
+	Attempts       int       `json:"attempts"`
+	CreatedAt      time.Time `json:"created_at"`
+	DeadLetteredAt time.Time `json:"dead_lettered_at"`
+}
+
+// Run heartbeats the queue's builds and sweeps orphaned builds until ctx is
+// done
+func (r *Reaper) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		log.Printf("Reaper: invalid interval %v, using %v", interval, DefaultReaperInterval)
+		interval = DefaultReaperInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.Queue.store.Heartbeat(r.Queue.jobs.ids()); err != nil {
+			log.Printf("Reaper: heartbeat failed: %v", err)
+		}
+		if err := r.Queue.reportCapacity(); err != nil {
+			log.Printf("Reaper: capacity report failed: %v", err)
+		}
+		if r.Queue.isDraining() {
+			// Orphans are for the instances that stay
+			continue
+		}
+		requeued, failed, err := r.Sweep()
+		if err != nil {
+			log.Printf("Reaper: sweep failed: %v", err)
+		}
+		if requeued > 0 || failed > 0 {
+			log.Printf("Reaper: requeued %d and dead-lettered %d orphaned builds", requeued, failed)
+		}
+	}
+}
+
+// Sweep requeues or dead-letters every orphaned build. A build is claimed
+// before it is touched, so instances sweeping at once never both take it.
+func (r *Reaper) Sweep() (requeued, failed int, err error) {
+	store := r.Queue.store
+	staleAfter := r.StaleAfter
+	if staleAfter <= 0 {
+		// A zero age would orphan every live build
+		staleAfter = DefaultStaleAfter
+	}
+	cutoff := time.Now().Add(-staleAfter)
+	orphans, err := store.ListOrphaned(cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, id := range orphans {
+		attempts, claimed, err := store.ClaimOrphan(id, cutoff)
+		if err != nil {
+			return requeued, failed, err
+		}
+		if !claimed {
+			continue
+		}
+		b, err := store.Get(id)
+		if err != nil {
+			return requeued, failed, err
+		}
+
+		b.UpdatedAt = time.Now()
+		if attempts > DefaultMaxRetries {
+			b.Status = buildpkg.StatusFailed
+			b.ErrorMessage = fmt.Sprintf("Build stopped responding after %d attempts", attempts)
+			if err := store.Update(b); err != nil {
+				return requeued, failed, err
+			}
+			if err := store.DeadLetter(id); err != nil {
+				return requeued, failed, err
+			}
+			failed++
+			continue
+		}
+
+		b.Status = buildpkg.StatusPending
+		if err := store.Update(b); err != nil {
+			return requeued, failed, err
+		}
+		if err := r.Queue.enqueue(b, attempts); err != nil {
+			if errors.Is(err, ErrQueueFull) {
+				// The build stays pending for a later sweep
+				return requeued, failed, nil
+			}
+			return requeued, failed, err
+		}
+		requeued++
+	}
+	return requeued, failed, nil
+}
+
+// RetryDeadLetter gives a dead-lettered build a fresh set of retries. A build
+// of another region is left pending for the reaper of its region.
+func (q *Queue) RetryDeadLetter(id string) error {
+	if err := q.store.ResetDeadLetter(id); err != nil {
+		return err
+	}
+	b, err := q.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if region := q.store.region; region != "" && b.Region != region {
+		log.Printf("Build %s of region %q is requeued by its region", b.ID, b.Region)
+		return nil
+	}
+	return q.Enqueue(b)
+}
+
+// StartAttempt counts an attempt at a build and heartbeats it
+func (s *Store) StartAttempt(id string) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+
+	_, err := s.db.Exec(`UPDATE builds SET attempts = attempts + 1, heartbeat_at = $1 WHERE id = $2`,
+		time.Now(), id)
+	return err
+}
+
+// Heartbeat marks builds as held by a live instance
+func (s *Store) Heartbeat(ids []string) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := s.db.Exec(`UPDATE builds SET heartbeat_at = $1 WHERE id = ANY($2)`, time.Now(), ids)
+	return err
+}
+
+// Release hands builds back for the reaper of any instance to requeue. They
+// are left pending and marked as heartbeated long ago, so the next sweep
+// finds them orphaned. Builds that have finished meanwhile are untouched.
+func (s *Store) Release(ids []string) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `
+	UPDATE builds SET status = $1, heartbeat_at = $2
+	WHERE id = ANY($3) AND status IN ($1, $4)
+	`
+	_, err := s.db.Exec(query, buildpkg.StatusPending, time.Unix(0, 0), ids, buildpkg.StatusRetrying)
+	return err
+}
+
+// ListOrphaned lists unfinished builds last heartbeated before cutoff.
+// Builds that were never heartbeated count from their last update.
+func (s *Store) ListOrphaned(cutoff time.Time) ([]string, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	SELECT id FROM builds
+	WHERE status IN ($1, $2, $3) AND deleted_at IS NULL
+		AND COALESCE(heartbeat_at, updated_at) < $4 AND ($5 = '' OR region = $5)
+	ORDER BY created_at ASC
+	`
+
+	rows, err := s.db.Query(query, buildpkg.StatusPending, buildpkg.StatusCompiling, buildpkg.StatusRetrying, cutoff, s.region)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ClaimOrphan heartbeats a build if it is still orphaned and returns how many
+// attempts it has had. claimed is false if another instance got to it first.
+func (s *Store) ClaimOrphan(id string, cutoff time.Time) (attempts int, claimed bool, err error) {
+	if s.db == nil {
+		return 0, false, fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	UPDATE builds SET heartbeat_at = $1
+	WHERE id = $2 AND COALESCE(heartbeat_at, updated_at) < $3
+	RETURNING attempts
+	`
+
+	err = s.db.QueryRow(query, time.Now(), id, cutoff).Scan(&attempts)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return attempts, true, nil
+}
+
+// DeadLetter moves a failed build to the dead-letter list
+func (s *Store) DeadLetter(id string) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+
+	_, err := s.db.Exec(`UPDATE builds SET dead_lettered_at = $1 WHERE id = $2`, time.Now(), id)
+	return err
+}
+
+// ListDeadLetters lists dead-lettered builds, most recent first
+func (s *Store) ListDeadLetters(limit int) ([]DeadLetter, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	SELECT id, user_id, engine, main_file, region, error_message, attempts, created_at, dead_lettered_at
+	FROM builds
+	WHERE dead_lettered_at IS NOT NULL AND deleted_at IS NULL
+	ORDER BY dead_lettered_at DESC
+	LIMIT $1
+	`
+
+	rows, err := s.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	letters := []DeadLetter{}
+	for rows.Next() {
+		var d DeadLetter
+		var errorMessage sql.NullString
+		err := rows.Scan(&d.ID, &d.UserID, &d.Engine, &d.MainFile, &d.Region, &errorMessage,
+			&d.Attempts, &d.CreatedAt, &d.DeadLetteredAt)
+		if err != nil {
+			return nil, err
+		}
+		d.ErrorMessage = errorMessage.String
+		letters = append(letters, d)
+	}
+
+	return letters, rows.Err()
+}
+
+// ResetDeadLetter takes a build off the dead-letter list and marks it pending
+// with no attempts used
+func (s *Store) ResetDeadLetter(id string) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+
+	query := `
+	UPDATE builds
+	SET dead_lettered_at = NULL, attempts = 0, heartbeat_at = $1, status = $2, error_message = '', updated_at = $1
+	WHERE id = $3 AND dead_lettered_at IS NOT NULL AND deleted_at IS NULL
+	`
+
+	result, err := s.db.Exec(query, time.Now(), buildpkg.StatusPending, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("build not found")
+	}
+	return nil
+}
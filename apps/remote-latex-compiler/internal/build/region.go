@@ -0,0 +1,120 @@
+package build
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// RegionCapacity sums the live instances of a data-residency region
+type RegionCapacity struct {
+	Region      string  `json:"region"`
+	Instances   int     `json:"instances"`
+	Workers     int     `json:"workers"`
+	Busy        int     `json:"busy"`
+	Pending     int     `json:"pending"`     // builds waiting for a worker on the region's instances
+	Unfinished  int     `json:"unfinished"`  // pending, compiling or retrying builds of the region
+	Utilization float64 `json:"utilization"` // busy fraction of the region's workers
+}
+
+// reportCapacity heartbeats the instance with its workers and queue depth
+func (q *Queue) reportCapacity() error {
+	stats := q.Stats()
+	return q.store.ReportInstance(q.instanceID, stats)
+}
+
+// ReportInstance records the capacity of a compiler instance of the store's
+// region
+func (s *Store) ReportInstance(id string, stats Stats) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+
+	_, err := s.db.Exec(`
+	INSERT INTO instances (id, region, workers, busy, pending, heartbeat_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (id) DO UPDATE SET region = $2, workers = $3, busy = $4, pending = $5, heartbeat_at = $6
+	`, id, s.region, stats.Workers, stats.Busy, stats.Pending, time.Now())
+	return err
+}
+
+// RemoveInstance forgets a compiler instance that is shutting down
+func (s *Store) RemoveInstance(id string) error {
+	if s.db == nil {
+		return fmt.Errorf("store not initialized with database")
+	}
+
+	_, err := s.db.Exec(`DELETE FROM instances WHERE id = $1`, id)
+	return err
+}
+
+// ListRegionCapacity sums the instances heartbeated since cutoff and the
+// unfinished builds of every region, ordered by region
+func (s *Store) ListRegionCapacity(cutoff time.Time) ([]RegionCapacity, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("store not initialized with database")
+	}
+
+	regions := make(map[string]*RegionCapacity)
+	region := func(name string) *RegionCapacity {
+		if regions[name] == nil {
+			regions[name] = &RegionCapacity{Region: name}
+		}
+		return regions[name]
+	}
+
+	rows, err := s.db.Query(`
+	SELECT region, COUNT(*), COALESCE(SUM(workers), 0), COALESCE(SUM(busy), 0), COALESCE(SUM(pending), 0)
+	FROM instances WHERE heartbeat_at >= $1
+	GROUP BY region
+	`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var c RegionCapacity
+		if err := rows.Scan(&name, &c.Instances, &c.Workers, &c.Busy, &c.Pending); err != nil {
+			return nil, err
+		}
+		r := region(name)
+		r.Instances, r.Workers, r.Busy, r.Pending = c.Instances, c.Workers, c.Busy, c.Pending
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query(`
+	SELECT region, COUNT(*) FROM builds
+	WHERE status IN ($1, $2, $3) AND deleted_at IS NULL
+	GROUP BY region
+	`, buildpkg.StatusPending, buildpkg.StatusCompiling, buildpkg.StatusRetrying)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var unfinished int
+		if err := rows.Scan(&name, &unfinished); err != nil {
+			return nil, err
+		}
+		region(name).Unfinished = unfinished
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	capacity := make([]RegionCapacity, 0, len(regions))
+	for _, r := range regions {
+		if r.Workers > 0 {
+			r.Utilization = float64(r.Busy) / float64(r.Workers)
+		}
+		capacity = append(capacity, *r)
+	}
+	sort.Slice(capacity, func(i, j int) bool { return capacity[i].Region < capacity[j].Region })
+	return capacity, nil
+}
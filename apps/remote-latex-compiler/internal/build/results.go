@@ -0,0 +1,81 @@
+package build
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// resultStats counts how often builds reuse the output of an earlier build
+type resultStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// reuseResult completes a build with the output of the user's latest
+// completed build of the same sources, options and compiler image, as when a
+// user rebuilds without editing, and reports whether there was one to reuse
+func (w *Worker) reuseResult(build *buildpkg.Build) bool {
+	if build.DirPath == "" {
+		return false
+	}
+	digester, ok := w.compiler.(buildpkg.ImageDigester)
+	if !ok {
+		return false
+	}
+	digest, err := digester.ImageDigestFor(build)
+	if err != nil {
+		log.Printf("Worker %d: Failed to check the compiler image of build %s: %v", w.id, build.ID, err)
+		return false
+	}
+	key, err := buildpkg.ResultKey(build, build.DirPath, digest)
+	if err != nil {
+		log.Printf("Worker %d: Failed to hash build %s: %v", w.id, build.ID, err)
+		return false
+	}
+	if key == "" {
+		return false
+	}
+	build.ResultKey = key
+
+	prev, err := w.store.FindResult(build.UserID, key, build.ID)
+	if err != nil {
+		log.Printf("Worker %d: Failed to look up cached result for build %s: %v", w.id, build.ID, err)
+	}
+	if prev == nil || w.openResult(prev) != nil || buildpkg.ReuseResult(build, prev, build.DirPath) != nil {
+		w.results.misses.Add(1)
+		return false
+	}
+	if prev.Encrypted {
+		// The copies are rendered to previews before the build seals them again
+		if err := w.keyring().OpenBuild(build); err != nil {
+			log.Printf("Worker %d: Failed to decrypt output reused by build %s: %v", w.id, build.ID, err)
+			w.results.misses.Add(1)
+			return false
+		}
+	}
+	w.results.hits.Add(1)
+	log.Printf("Worker %d: Build %s reused the output of build %s", w.id, build.ID, prev.ID)
+	return true
+}
+
+// openResult decrypts the build log of an encrypted build whose output is
+// about to be reused. Its files are decrypted once copied.
+func (w *Worker) openResult(prev *buildpkg.Build) error {
+	if !prev.Encrypted {
+		return nil
+	}
+	keyring := w.keyring()
+	if keyring == nil {
+		return fmt.Errorf("build %s is encrypted and artifact encryption is off", prev.ID)
+	}
+	buildLog, err := keyring.OpenText(prev.UserID, prev.BuildLog)
+	if err != nil {
+		log.Printf("Worker %d: Failed to decrypt the log of build %s: %v", w.id, prev.ID, err)
+		return err
+	}
+	prev.BuildLog = buildLog
+	return nil
+}
@@ -0,0 +1,288 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// ErrDraining is returned when a drained queue is asked to scale up
+var ErrDraining = errors.New("queue is draining")
+
+// Stats is a snapshot of the queue for autoscalers
+type Stats struct {
+	Pending     int           `json:"pending"`      // jobs waiting for a worker
+	Delayed     int           `json:"delayed"`      // retries waiting out their backoff
+	Workers     int           `json:"workers"`      // workers taking jobs
+	Busy        int           `json:"busy"`         // workers running a job, draining ones included
+	Draining    int           `json:"draining"`     // workers finishing their last job before exiting
+	Utilization float64       `json:"utilization"`  // mean busy fraction of the workers taking jobs
+	CacheHits   int64         `json:"cache_hits"`   // builds that reused an earlier build's output
+	CacheMisses int64         `json:"cache_misses"` // builds that found no output to reuse and compiled
+	WorkerStats []WorkerStats `json:"worker_stats"`
+}
+
+// WorkerStats describes one worker
+type WorkerStats struct {
+	ID          int     `json:"id"`
+	Busy        bool    `json:"busy"`
+	BuildID     string  `json:"build_id,omitempty"`
+	Processed   int     `json:"processed"`
+	Utilization float64 `json:"utilization"` // fraction of its lifetime spent on jobs
+	Draining    bool    `json:"draining,omitempty"`
+}
+
+func (w *Worker) setBusy(buildID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.busySince = time.Now()
+	w.buildID = buildID
+}
+
+func (w *Worker) setIdle() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.busyTime += time.Since(w.busySince)
+	w.busySince = time.Time{}
+	w.buildID = ""
+	w.processed++
+}
+
+// drain stops the worker after its current job
+func (w *Worker) drain() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopping {
+		w.stopping = true
+		close(w.stop)
+	}
+}
+
+func (w *Worker) stats() WorkerStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	busy := w.busyTime
+	if !w.busySince.IsZero() {
+		busy += time.Since(w.busySince)
+	}
+	var utilization float64
+	if lifetime := time.Since(w.startedAt); lifetime > 0 {
+		utilization = float64(busy) / float64(lifetime)
+	}
+	return WorkerStats{
+		ID:          w.id,
+		Busy:        !w.busySince.IsZero(),
+		BuildID:     w.buildID,
+		Processed:   w.processed,
+		Utilization: utilization,
+		Draining:    w.stopping,
+	}
+}
+
+// Stats returns the queue depth and what each worker is doing
+func (q *Queue) Stats() Stats {
+	q.mu.RLock()
+	pool := append([]*Worker(nil), q.workerPool...)
+	q.mu.RUnlock()
+
+	stats := Stats{
+		Pending:     q.jobs.len(),
+		Delayed:     q.jobs.delayedLen(),
+		CacheHits:   q.results.hits.Load(),
+		CacheMisses: q.results.misses.Load(),
+		WorkerStats: make([]WorkerStats, 0, len(pool)),
+	}
+	var utilization float64
+	for _, w := range pool {
+		ws := w.stats()
+		stats.WorkerStats = append(stats.WorkerStats, ws)
+		if ws.Busy {
+			stats.Busy++
+		}
+		if ws.Draining {
+			stats.Draining++
+			continue
+		}
+		stats.Workers++
+		utilization += ws.Utilization
+	}
+	if stats.Workers > 0 {
+		stats.Utilization = utilization / float64(stats.Workers)
+	}
+	return stats
+}
+
+// Scale starts or drains workers until n take jobs. Idle workers are drained
+// before busy ones, and a busy worker finishes its job before it exits.
+func (q *Queue) Scale(n int) error {
+	if n < 0 {
+		n = 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.draining && n > 0 {
+		return ErrDraining
+	}
+
+	var active, busy []*Worker
+	for _, w := range q.workerPool {
+		ws := w.stats()
+		switch {
+		case ws.Draining:
+		case ws.Busy:
+			busy = append(busy, w)
+		default:
+			active = append(active, w)
+		}
+	}
+	active = append(active, busy...)
+
+	for i := len(active); i < n; i++ {
+		q.startWorker()
+	}
+	for _, w := range active[min(n, len(active)):] {
+		w.drain()
+	}
+	if n != len(active) {
+		log.Printf("Build queue scaled from %d to %d workers", len(active), n)
+	}
+	q.workers = n
+	return nil
+}
+
+// Drain drains every worker and waits until their jobs finish or ctx is
+// done; the queue does not scale up again. Queued builds, and builds
+// enqueued from then on, are released to other instances; see Release.
+func (q *Queue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+	if err := q.Scale(0); err != nil {
+		return err
+	}
+	if err := q.releaseWaiting(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		q.mu.RLock()
+		remaining := len(q.workerPool)
+		q.mu.RUnlock()
+		if remaining == 0 {
+			// Retries the last jobs scheduled
+			return q.releaseWaiting()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseWaiting takes the jobs waiting for a worker out of the queue and
+// releases their builds
+func (q *Queue) releaseWaiting() error {
+	jobs := q.jobs.takeWaiting()
+	if len(jobs) == 0 {
+		return nil
+	}
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.Build.ID
+	}
+	if err := q.store.Release(ids); err != nil {
+		return fmt.Errorf("failed to release %d queued builds: %w", len(ids), err)
+	}
+	log.Printf("Released %d queued builds to other instances", len(ids))
+	return nil
+}
+
+// isDraining reports whether Drain was called
+func (q *Queue) isDraining() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.draining
+}
+
+// Scaler decides how many workers a queue should run
+type Scaler interface {
+	DesiredWorkers(stats Stats) int
+}
+
+// DepthScaler scales on queue depth like a Kubernetes HPA on an external
+// metric: one worker per JobsPerWorker pending or running builds, within
+// Min and Max.
+type DepthScaler struct {
+	Min           int
+	Max           int
+	JobsPerWorker int
+}
+
+func (s DepthScaler) DesiredWorkers(stats Stats) int {
+	per := s.JobsPerWorker
+	if per <= 0 {
+		per = 1
+	}
+	desired := int(math.Ceil(float64(stats.Pending+stats.Busy-stats.Draining) / float64(per)))
+	if desired < s.Min {
+		desired = s.Min
+	}
+	if s.Max > 0 && desired > s.Max {
+		desired = s.Max
+	}
+	return desired
+}
+
+// Autoscaler periodically resizes a queue to what its Scaler wants.
+// Scale-down waits until the lower count has held for ScaleDownDelay, so a
+// brief lull does not drain workers the next burst needs.
+type Autoscaler struct {
+	Queue          *Queue
+	Scaler         Scaler
+	Interval       time.Duration
+	ScaleDownDelay time.Duration
+}
+
+// Run scales the queue until ctx is done
+func (a *Autoscaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+
+	var lowSince time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.Queue.done:
+			return
+		case <-ticker.C:
+		}
+
+		stats := a.Queue.Stats()
+		desired := a.Scaler.DesiredWorkers(stats)
+		switch {
+		case desired > stats.Workers:
+			lowSince = time.Time{}
+		case desired < stats.Workers:
+			if lowSince.IsZero() {
+				lowSince = time.Now()
+			}
+			if time.Since(lowSince) < a.ScaleDownDelay {
+				continue
+			}
+			lowSince = time.Time{}
+		default:
+			lowSince = time.Time{}
+			continue
+		}
+		if err := a.Queue.Scale(desired); errors.Is(err, ErrDraining) {
+			return
+		}
+	}
+}
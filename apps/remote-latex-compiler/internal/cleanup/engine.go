@@ -3,7 +3,9 @@ package cleanup
 import (
 	"time"
 
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/blob"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/objstore"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
 	"github.com/sirupsen/logrus"
 )
@@ -17,6 +19,10 @@ type Config struct {
 	DiskWarning   int // Percentage
 	DiskCritical  int
 	DiskEmergency int
+	BlobGrace     time.Duration // How long unreferenced blobs are kept
+	ProjectTTL    time.Duration // How long idle delta-sync projects keep their cache
+	Region        string        // Region whose files this instance purges; empty when regions are disabled
+	Regions       []string      // Every region that must purge an account before its row is deleted
 }
 
 // Engine manages automatic cleanup of builds
@@ -28,8 +34,8 @@ type Engine struct {
 }
 
 // NewEngine creates a new cleanup engine with dependencies
-func NewEngine(config Config, buildStore *build.Store, userStore *user.Store, logger *logrus.Logger) *Engine {
-	service := NewService(config, buildStore, userStore, logger)
+func NewEngine(config Config, buildStore *build.Store, userStore *user.Store, blobStore *blob.Store, objects *objstore.Store, logger *logrus.Logger) *Engine {
+	service := NewService(config, buildStore, userStore, blobStore, objects, logger)
 	return &Engine{
 		ticker:  time.NewTicker(config.Interval),
 		service: service,
@@ -43,6 +49,13 @@ func (e *Engine) Start() {
 	go func() {
 		e.logger.Info("Cleanup engine started")
 
+		// Repair what a crash or manual cleanup left behind before the
+		// first cycle
+		e.logger.Info("Reconciling work directory with build store")
+		if _, err := e.service.Reconcile(); err != nil {
+			e.logger.WithError(err).Error("Startup reconciliation failed")
+		}
+
 		// Run initial cleanup on startup
 		e.logger.Info("Running initial cleanup")
 		e.service.Run()
@@ -70,3 +83,20 @@ func (e *Engine) Stop() {
 func (e *Engine) ForceRun() {
 	e.service.Run()
 }
+
+// Reconcile runs a reconciliation of the work dir against the build store
+func (e *Engine) Reconcile() (*ReconcileReport, error) {
+	return e.service.Reconcile()
+}
+
+// LastReconcile returns the report of the latest reconciliation, or nil if
+// none has run
+func (e *Engine) LastReconcile() *ReconcileReport {
+	return e.service.LastReconcile()
+}
+
+// DiskCritical reports whether the work dir is past the critical disk
+// threshold, with its current usage
+func (e *Engine) DiskCritical() (*DiskStats, bool) {
+	return e.service.DiskCritical()
+}
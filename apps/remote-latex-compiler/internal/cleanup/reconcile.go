@@ -0,0 +1,140 @@
+package cleanup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrBusy is returned by Reconcile while a cleanup cycle or another
+// reconciliation is running
+var ErrBusy = errors.New("cleanup already running")
+
+// lostBuildMessage is the error of builds failed because their files are gone
+const lostBuildMessage = "Build files were lost; please rebuild"
+
+// ReconcileReport counts what a reconciliation of the work dir against the
+// build store found and repaired
+type ReconcileReport struct {
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	OrphanedDirs  int       `json:"orphaned_dirs"`  // directories without a build record, removed
+	MissingDirs   int       `json:"missing_dirs"`   // build records whose directory is gone
+	FailedBuilds  int       `json:"failed_builds"`  // of those, unfinished builds marked failed
+	DeletedBuilds int       `json:"deleted_builds"` // of those, finished builds deleted
+	Errors        int       `json:"errors"`         // records that could not be repaired
+	Error         string    `json:"error,omitempty"`
+}
+
+// Reconcile brings the work dir and the build store back in line after a
+// crash or manual cleanup: directories without a build record are removed,
+// and records whose directory is gone are failed if unfinished or deleted
+// otherwise. Only builds untouched for the grace period are considered, so
+// builds being created are left alone; instances of a region are assumed to
+// share the work dir.
+func (s *Service) Reconcile() (*ReconcileReport, error) {
+	if !s.cleanupMu.TryLock() {
+		return nil, ErrBusy
+	}
+	defer s.cleanupMu.Unlock()
+
+	report := &ReconcileReport{StartedAt: time.Now()}
+	defer func() {
+		report.FinishedAt = time.Now()
+		s.reportMu.Lock()
+		s.lastReconcile = report
+		s.reportMu.Unlock()
+	}()
+
+	if err := os.MkdirAll(s.config.WorkDir, 0755); err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+
+	orphaned, err := s.cleanOrphanedFiles()
+	report.OrphanedDirs = orphaned
+	if err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+
+	if err := s.repairMissingDirs(report); err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"orphaned_dirs":  report.OrphanedDirs,
+		"missing_dirs":   report.MissingDirs,
+		"failed_builds":  report.FailedBuilds,
+		"deleted_builds": report.DeletedBuilds,
+		"errors":         report.Errors,
+	}).Info("Reconciled work directory with build store")
+	return report, nil
+}
+
+// LastReconcile returns the report of the latest reconciliation, or nil if
+// none has run
+func (s *Service) LastReconcile() *ReconcileReport {
+	s.reportMu.Lock()
+	defer s.reportMu.Unlock()
+	return s.lastReconcile
+}
+
+// repairMissingDirs fails or deletes the build records whose directory is
+// gone
+func (s *Service) repairMissingDirs(report *ReconcileReport) error {
+	dirs, err := s.buildStore.ListDirsBefore(time.Now().Add(-s.config.GracePeriod))
+	if err != nil {
+		return err
+	}
+
+	workDir := filepath.Clean(s.config.WorkDir) + string(filepath.Separator)
+	for _, d := range dirs {
+		// Records pointing outside the work dir were made with another
+		// configuration; their files cannot be judged from here
+		if d.DirPath == "" || !strings.HasPrefix(filepath.Clean(d.DirPath), workDir) {
+			continue
+		}
+		if _, err := os.Stat(d.DirPath); err == nil || !os.IsNotExist(err) {
+			continue
+		}
+
+		report.MissingDirs++
+		buildLog := s.logger.WithFields(logrus.Fields{"buildID": d.ID, "userID": d.UserID})
+		if d.Status.Terminal() {
+			if err := s.buildStore.Delete(d.ID); err != nil {
+				buildLog.WithError(err).Warn("Failed to delete build with missing directory")
+				report.Errors++
+				continue
+			}
+			s.deleteObjects(&buildpkg.Build{ID: d.ID, UserID: d.UserID})
+			buildLog.Debug("Deleted build with missing directory")
+			report.DeletedBuilds++
+			continue
+		}
+
+		b, err := s.buildStore.Get(d.ID)
+		if err != nil {
+			buildLog.WithError(err).Warn("Failed to load build with missing directory")
+			report.Errors++
+			continue
+		}
+		b.Status = buildpkg.StatusFailed
+		b.ErrorMessage = lostBuildMessage
+		b.UpdatedAt = time.Now()
+		if err := s.buildStore.Update(b); err != nil {
+			buildLog.WithError(err).Warn("Failed to fail build with missing directory")
+			report.Errors++
+			continue
+		}
+		buildLog.Info("Failed build with missing directory")
+		report.FailedBuilds++
+	}
+	return nil
+}
@@ -1,15 +1,20 @@
 package cleanup
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/billing"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/blob"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/build"
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/objstore"
 	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/user"
 	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
 	"github.com/sirupsen/logrus"
@@ -28,20 +33,47 @@ type Service struct {
 	config     Config
 	buildStore *build.Store
 	userStore  *user.Store
+	blobStore  *blob.Store
+	objects    *objstore.Store // nil when artifacts are only on local disk
 	logger     *logrus.Logger
 	cleanupMu  sync.Mutex // Prevent concurrent cleanup
+
+	reportMu      sync.Mutex
+	lastReconcile *ReconcileReport
+
+	diskMu     sync.Mutex
+	diskStats  *DiskStats
+	diskStatAt time.Time
 }
 
+// diskStatsTTL is how long the work dir's disk usage is trusted before it is
+// read again for admission checks
+const diskStatsTTL = 10 * time.Second
+
 // NewService creates a new cleanup service
-func NewService(cfg Config, buildStore *build.Store, userStore *user.Store, logger *logrus.Logger) *Service {
+func NewService(cfg Config, buildStore *build.Store, userStore *user.Store, blobStore *blob.Store, objects *objstore.Store, logger *logrus.Logger) *Service {
 	return &Service{
 		config:     cfg,
 		buildStore: buildStore,
 		userStore:  userStore,
+		blobStore:  blobStore,
+		objects:    objects,
 		logger:     logger,
 	}
 }
 
+// deleteObjects removes the artifacts of a deleted build from object storage
+func (s *Service) deleteObjects(b *buildpkg.Build) {
+	if s.objects == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := build.DeleteArtifacts(ctx, s.objects, b.UserID, b.ID); err != nil {
+		s.logger.WithError(err).WithField("buildID", b.ID).Warn("Failed to delete build artifacts from object storage")
+	}
+}
+
 // Run executes a cleanup cycle
 func (s *Service) Run() {
 	// Use lock to prevent concurrent cleanup execution
@@ -64,8 +96,11 @@ func (s *Service) Run() {
 	s.hardDeleteExpired()
 	s.checkDiskSpace()
 	s.cleanOrphanedFiles()
+	s.expireDeltaProjects()
+	s.sweepBlobs()
 	s.cleanupStorageQuotas()
 	s.updateUserStorageUsage()
+	s.purgeDeletedAccounts()
 
 	s.logger.Info("Cleanup cycle completed")
 }
@@ -112,6 +147,7 @@ func (s *Service) hardDeleteExpired() {
 		if err := os.RemoveAll(b.DirPath); err != nil {
 			s.logger.WithError(err).Warn("Failed to remove build directory")
 		}
+		s.deleteObjects(b)
 
 		// Remove from database
 		if err := s.buildStore.Delete(b.ID); err != nil {
@@ -122,6 +158,35 @@ func (s *Service) hardDeleteExpired() {
 	s.logger.WithField("count", len(expired)).Info("Hard deleted expired builds")
 }
 
+// DiskUsage returns the disk usage of the work dir, read at most every
+// diskStatsTTL
+func (s *Service) DiskUsage() (*DiskStats, error) {
+	s.diskMu.Lock()
+	defer s.diskMu.Unlock()
+
+	if s.diskStats != nil && time.Since(s.diskStatAt) < diskStatsTTL {
+		return s.diskStats, nil
+	}
+	stats, err := getDiskStats(s.config.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	s.diskStats, s.diskStatAt = stats, time.Now()
+	return stats, nil
+}
+
+// DiskCritical reports whether the work dir is past the critical disk
+// threshold, above which no new builds are admitted
+func (s *Service) DiskCritical() (*DiskStats, bool) {
+	stats, err := s.DiskUsage()
+	if err != nil {
+		// Unknown usage must not stop builds; running out shows in the logs
+		s.logger.WithError(err).Warn("Failed to get disk usage for admission")
+		return nil, false
+	}
+	return stats, stats.UsedPercent >= float64(s.config.DiskCritical)
+}
+
 // checkDiskSpace monitors disk usage and triggers cleanup
 func (s *Service) checkDiskSpace() error {
 	stats, err := getDiskStats(s.config.WorkDir)
@@ -129,6 +194,7 @@ func (s *Service) checkDiskSpace() error {
 		s.logger.WithError(err).Error("Failed to get disk usage")
 		return err
 	}
+	defer s.forgetDiskUsage() // the cleanup below frees space
 
 	percent := stats.UsedPercent
 
@@ -156,6 +222,14 @@ func (s *Service) checkDiskSpace() error {
 	return nil
 }
 
+// forgetDiskUsage drops the cached disk usage, so the next admission check
+// reads it again
+func (s *Service) forgetDiskUsage() {
+	s.diskMu.Lock()
+	s.diskStats = nil
+	s.diskMu.Unlock()
+}
+
 // getDiskStats retrieves disk statistics using syscall
 func getDiskStats(path string) (*DiskStats, error) {
 	var stat syscall.Statfs_t
@@ -195,6 +269,7 @@ func (s *Service) emergencyCleanup() {
 		s.logger.WithField("buildID", b.ID).Debug("Emergency delete build")
 		s.buildStore.Delete(b.ID)
 		os.RemoveAll(b.DirPath)
+		s.deleteObjects(b)
 	}
 }
 
@@ -218,46 +293,112 @@ func (s *Service) aggressiveCleanup() {
 		s.logger.WithField("buildID", b.ID).Debug("Aggressive delete build")
 		s.buildStore.Delete(b.ID)
 		os.RemoveAll(b.DirPath)
+		s.deleteObjects(b)
 	}
 }
 
 // cleanOrphanedFiles removes build directories without database records
-func (s *Service) cleanOrphanedFiles() {
+// and returns how many it removed
+func (s *Service) cleanOrphanedFiles() (int, error) {
 	entries, err := os.ReadDir(s.config.WorkDir)
 	if err != nil {
 		s.logger.WithError(err).Warn("Failed to read work directory")
-		return
+		return 0, err
 	}
 
 	// Build index of known builds
 	allBuilds, err := s.buildStore.GetAllIDs()
 	if err != nil {
 		s.logger.WithError(err).Warn("Failed to get all build IDs for orphan check")
-		return
+		return 0, err
 	}
 	knownBuilds := make(map[string]bool)
 	for _, id := range allBuilds {
 		knownBuilds[id] = true
 	}
 
+	// Build directories live under <workDir>/<userID>/<buildID>. Delta-sync
+	// builds create their directory before the build record exists, so
+	// recently touched directories are left alone.
+	cutoff := time.Now().Add(-s.config.GracePeriod)
+
 	orphanedCount := 0
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	for _, userEntry := range entries {
+		// Skip non-user directories such as the shared blob store
+		if !userEntry.IsDir() || strings.HasPrefix(userEntry.Name(), ".") {
+			continue
+		}
+
+		userDir := filepath.Join(s.config.WorkDir, userEntry.Name())
+		buildEntries, err := os.ReadDir(userDir)
+		if err != nil {
 			continue
 		}
 
-		// Check if directory is a known build
-		if !knownBuilds[entry.Name()] {
+		for _, entry := range buildEntries {
+			if !entry.IsDir() || knownBuilds[entry.Name()] {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+
 			s.logger.WithField("dir", entry.Name()).Debug("Found orphaned directory")
-			path := filepath.Join(s.config.WorkDir, entry.Name())
-			if err := os.RemoveAll(path); err != nil {
+			if err := os.RemoveAll(filepath.Join(userDir, entry.Name())); err != nil {
 				s.logger.WithError(err).Warn("Failed to remove orphaned directory")
+				continue
 			}
 			orphanedCount++
 		}
 	}
 
 	s.logger.WithField("count", orphanedCount).Info("Cleaned orphaned directories")
+	return orphanedCount, nil
+}
+
+// expireDeltaProjects drops caches of projects idle past the retention period
+// along with leftover per-project JSON cache files
+func (s *Service) expireDeltaProjects() {
+	if s.blobStore == nil {
+		return
+	}
+
+	removed, err := s.blobStore.ExpireProjects(s.config.ProjectTTL)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to expire delta-sync projects")
+	}
+
+	legacy, _ := filepath.Glob(filepath.Join(s.config.WorkDir, "*", ".cache_*.json"))
+	for _, f := range legacy {
+		if err := os.Remove(f); err != nil {
+			s.logger.WithError(err).WithField("file", f).Debug("Failed to remove legacy cache file")
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"projects":     removed,
+		"legacy_files": len(legacy),
+	}).Info("Expired delta-sync project caches")
+}
+
+// sweepBlobs removes delta-sync blobs no longer referenced by any project
+func (s *Service) sweepBlobs() {
+	if s.blobStore == nil {
+		return
+	}
+
+	removed, freed, err := s.blobStore.Sweep(s.config.BlobGrace)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to sweep unreferenced blobs")
+		return
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"count": removed,
+		"freed": fmt.Sprintf("%.1f MB", float64(freed)/(1024*1024)),
+	}).Info("Swept unreferenced blobs")
 }
 
 // cleanupStorageQuotas enforces storage limits per user tier
@@ -299,6 +440,7 @@ func (s *Service) cleanupStorageQuotas() {
 			}
 			for _, b := range oldest {
 				os.RemoveAll(b.DirPath)
+				s.deleteObjects(b)
 				s.buildStore.Delete(b.ID)
 
 				totalStorage -= b.StorageBytes
@@ -342,3 +484,82 @@ func (s *Service) notifyAdmin(subject string, percent float64) {
 	}).Warn("ADMIN NOTIFICATION")
 	// TODO: Implement email notification
 }
+
+// purgeDeletedAccounts removes the accounts whose scheduled deletion is due:
+// their build and cache files, delta-sync manifests and database rows. With
+// regions, each region records the purge of its files and the database rows
+// go once every region has purged.
+func (s *Service) purgeDeletedAccounts() {
+	due, err := s.userStore.DueForDeletion(time.Now(), s.config.Region)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to find accounts due for deletion")
+		return
+	}
+
+	purged := 0
+	for _, id := range due {
+		userLog := s.logger.WithField("userID", id)
+		// IDs come from the database, but never let one reach outside the work dir
+		if id == "" || strings.ContainsAny(id, `/\.`) {
+			userLog.Warn("Skipping account with an invalid id")
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(s.config.WorkDir, id)); err != nil {
+			userLog.WithError(err).Warn("Failed to remove account files")
+			continue
+		}
+		if s.blobStore != nil {
+			if _, err := s.blobStore.PurgeUser(id); err != nil {
+				userLog.WithError(err).Warn("Failed to purge account cache")
+				continue
+			}
+		}
+		if s.objects != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			_, err := s.objects.DeletePrefix(ctx, build.UserArtifactPrefix(id))
+			cancel()
+			if err != nil {
+				userLog.WithError(err).Warn("Failed to delete account artifacts from object storage")
+				continue
+			}
+		}
+		if s.config.Region != "" {
+			if err := s.userStore.RecordPurge(id, s.config.Region); err != nil {
+				userLog.WithError(err).Warn("Failed to record account purge")
+				continue
+			}
+			if !s.purgedEverywhere(id, userLog) {
+				userLog.WithField("region", s.config.Region).Info("Purged account files, waiting for other regions")
+				purged++
+				continue
+			}
+		}
+		if err := s.userStore.Purge(id); err != nil {
+			userLog.WithError(err).Warn("Failed to delete account")
+			continue
+		}
+		userLog.Info("Deleted account")
+		purged++
+	}
+
+	if len(due) > 0 {
+		s.logger.WithField("count", purged).Info("Deleted accounts due for deletion")
+	}
+}
+
+// purgedEverywhere reports whether every region has purged the account's
+// files
+func (s *Service) purgedEverywhere(id string, userLog *logrus.Entry) bool {
+	purged, err := s.userStore.PurgedRegions(id)
+	if err != nil {
+		userLog.WithError(err).Warn("Failed to list regions that purged the account")
+		return false
+	}
+	for _, region := range s.config.Regions {
+		if !slices.Contains(purged, region) {
+			return false
+		}
+	}
+	return true
+}
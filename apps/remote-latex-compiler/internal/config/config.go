@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,14 +14,18 @@ type Config struct {
 	Cleanup CleanupConfig
 	Rate    RateConfig
 	Billing BillingConfig
+	Notify  NotifyConfig
+	Region  RegionConfig
 }
 
 type ServerConfig struct {
-	Port            string
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	IdleTimeout     time.Duration
-	ShutdownTimeout time.Duration
+	Port              string
+	ReadHeaderTimeout time.Duration // to send request headers, against slow clients
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	MaxBodySize       int64 // request bodies of routes that take no uploads
 }
 
 type BuildConfig struct {
@@ -30,9 +35,52 @@ type BuildConfig struct {
 	DefaultTimeout time.Duration
 	MaxTimeout     time.Duration
 	MinTimeout     time.Duration
+	TierTimeouts   map[string]time.Duration // tier -> longest timeout, instead of MaxTimeout
 	DefaultWorkers int
+	MaxInFlight    int // builds per user compiling at once; 0 for no cap
+	MaxQueued      int // builds waiting for a worker before new ones are refused; 0 for no cap
 	WorkDir        string
 	ImageName      string
+	PreviewPages   int
+	ArtifactKey    string // base64 master key encrypting artifacts at rest; empty to store them in the clear
+	LogRedact      bool   // mask AWS keys, bearer tokens and emails in build logs
+	AutoShell      bool   // plans with AutoShellEscape may have minted documents compiled with the restricted profile unasked
+	LogRedactFile  string // more patterns to mask, one regular expression per line
+	GitKnownHosts  string // ssh host keys git builds are checked against; empty allows only https
+	Sandbox        SandboxConfig
+	Autoscale      AutoscaleConfig
+	Reaper         ReaperConfig
+}
+
+// ReaperConfig sets how often builds are heartbeated and how old a heartbeat
+// gets before the build is treated as orphaned. StaleAfter should span
+// several intervals so a slow database does not orphan live builds.
+type ReaperConfig struct {
+	Interval   time.Duration
+	StaleAfter time.Duration
+}
+
+// AutoscaleConfig resizes the worker pool to the queue depth. Deployments
+// that scale from outside use the internal API instead, which is enabled by
+// InternalToken.
+type AutoscaleConfig struct {
+	Enabled        bool
+	MinWorkers     int
+	MaxWorkers     int
+	JobsPerWorker  int
+	Interval       time.Duration
+	ScaleDownDelay time.Duration
+	InternalToken  string // bearer token of /internal; empty disables it
+}
+
+// SandboxConfig sets up gVisor and Firecracker sandboxes, which run builds in
+// Docker with a stronger runtime than runc. Sandboxes are disabled unless
+// Image is set.
+type SandboxConfig struct {
+	Image       string            // compiler image sandboxed builds run in
+	Runtimes    map[string]string // sandbox -> Docker runtime providing it
+	Tiers       map[string]string // tier -> sandbox every build of the tier runs in, whatever it asks for
+	ShellEscape string            // sandbox for full shell-escape builds that ask for none
 }
 
 type StorageConfig struct {
@@ -41,15 +89,34 @@ type StorageConfig struct {
 	DiskWarning   int
 	DiskCritical  int
 	DiskEmergency int
+	Objects       ObjectStoreConfig
+}
+
+// ObjectStoreConfig points at an S3-compatible bucket finished builds'
+// artifacts are uploaded to, so downloads go to the bucket instead of through
+// the server. Object storage is disabled unless Bucket is set.
+type ObjectStoreConfig struct {
+	Endpoint  string // e.g. https://s3.eu-central-1.amazonaws.com
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool          // address the bucket in the path rather than the host, as MinIO needs
+	URLTTL    time.Duration // how long presigned download URLs are valid
 }
 
 type CleanupConfig struct {
-	Interval time.Duration
-	TTL      time.Duration
+	Interval   time.Duration
+	TTL        time.Duration
+	BlobGrace  time.Duration
+	ProjectTTL time.Duration
+	// AccountGrace is how long a deleted account can still be restored
+	// before its data is purged
+	AccountGrace time.Duration
 }
 
 type RateConfig struct {
-	RedisURL string
+	RedisURL string // empty to count rate limits in memory, per instance
 }
 
 type BillingConfig struct {
@@ -59,16 +126,42 @@ type BillingConfig struct {
 	PlanFree              string
 	PlanPro               string
 	PlanEnterprise        string
+	GracePeriod           time.Duration // full access left to accounts whose payment failed
+}
+
+// RegionConfig places the instance in a data-residency region. Builds and
+// their storage stay in the region of the user who started them; requests
+// for another region are proxied to its URL. Regions are disabled unless
+// Name is set.
+type RegionConfig struct {
+	Name string            // region this instance serves, with its workers and storage
+	URLs map[string]string // region -> public base URL, for every region users may select
+}
+
+// NotifyConfig configures build completion emails. Email notifications are
+// disabled unless SMTPHost is set.
+type NotifyConfig struct {
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTimeout  time.Duration // bounds connecting and, separately, sending a message
+	QueueSize    int           // notifications that may wait to be sent before more are dropped
+	From         string
+	PublicURL    string
+	LinkTTL      time.Duration
 }
 
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port:            getEnvOrDefault("SERVER_PORT", "9000"),
-			ReadTimeout:     getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
-			WriteTimeout:    getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
-			IdleTimeout:     getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
-			ShutdownTimeout: getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+			Port:              getEnvOrDefault("SERVER_PORT", "9000"),
+			ReadHeaderTimeout: getDurationEnv("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+			ReadTimeout:       getDurationEnv("SERVER_READ_TIMEOUT", 15*time.Second),
+			WriteTimeout:      getDurationEnv("SERVER_WRITE_TIMEOUT", 15*time.Second),
+			IdleTimeout:       getDurationEnv("SERVER_IDLE_TIMEOUT", 60*time.Second),
+			ShutdownTimeout:   getDurationEnv("SERVER_SHUTDOWN_TIMEOUT", 30*time.Second),
+			MaxBodySize:       getInt64Env("SERVER_MAX_BODY_SIZE", 1024*1024),
 		},
 		Build: BuildConfig{
 			MaxFileSize:    getInt64Env("BUILD_MAX_FILE_SIZE", 100*1024*1024),
@@ -77,9 +170,37 @@ func Load() *Config {
 			DefaultTimeout: getDurationEnv("BUILD_DEFAULT_TIMEOUT", 5*time.Minute),
 			MaxTimeout:     getDurationEnv("BUILD_MAX_TIMEOUT", 10*time.Minute),
 			MinTimeout:     getDurationEnv("BUILD_MIN_TIMEOUT", 30*time.Second),
+			TierTimeouts:   getDurationMapEnv("BUILD_TIER_MAX_TIMEOUTS", map[string]time.Duration{"enterprise": 30 * time.Minute}),
 			DefaultWorkers: getIntEnv("BUILD_WORKERS", 4),
+			MaxInFlight:    getIntEnv("BUILD_MAX_IN_FLIGHT_PER_USER", 2),
+			MaxQueued:      getIntEnv("BUILD_MAX_QUEUED", 1000),
 			WorkDir:        getEnvOrDefault("COMPILER_WORKDIR", "/tmp/treefrog-builds"),
 			ImageName:      getEnvOrDefault("COMPILER_IMAGE", "treefrog-local-latex-compiler:latest"),
+			PreviewPages:   getIntEnv("BUILD_PREVIEW_PAGES", 5),
+			ArtifactKey:    os.Getenv("ARTIFACT_ENCRYPTION_KEY"),
+			LogRedact:      getEnvOrDefault("BUILD_LOG_REDACT", "true") == "true",
+			AutoShell:      getEnvOrDefault("BUILD_AUTO_SHELL_ESCAPE", "true") == "true",
+			LogRedactFile:  os.Getenv("BUILD_LOG_REDACT_FILE"),
+			GitKnownHosts:  os.Getenv("GIT_KNOWN_HOSTS_FILE"),
+			Sandbox: SandboxConfig{
+				Image:       os.Getenv("COMPILER_SANDBOX_IMAGE"),
+				Runtimes:    getMapEnv("COMPILER_SANDBOX_RUNTIMES", map[string]string{"gvisor": "runsc"}),
+				Tiers:       getMapEnv("COMPILER_SANDBOX_TIERS", nil),
+				ShellEscape: getEnvOrDefault("COMPILER_SHELL_ESCAPE_SANDBOX", "gvisor"),
+			},
+			Autoscale: AutoscaleConfig{
+				Enabled:        getEnvOrDefault("BUILD_AUTOSCALE", "false") == "true",
+				MinWorkers:     getIntEnv("BUILD_MIN_WORKERS", 1),
+				MaxWorkers:     getIntEnv("BUILD_MAX_WORKERS", 16),
+				JobsPerWorker:  getIntEnv("BUILD_JOBS_PER_WORKER", 1),
+				Interval:       getDurationEnv("BUILD_AUTOSCALE_INTERVAL", 15*time.Second),
+				ScaleDownDelay: getDurationEnv("BUILD_SCALE_DOWN_DELAY", 5*time.Minute),
+				InternalToken:  os.Getenv("INTERNAL_API_TOKEN"),
+			},
+			Reaper: ReaperConfig{
+				Interval:   getDurationEnv("BUILD_HEARTBEAT_INTERVAL", time.Minute),
+				StaleAfter: getDurationEnv("BUILD_STALE_AFTER", 15*time.Minute),
+			},
 		},
 		Storage: StorageConfig{
 			BuildTTL:      getDurationEnv("STORAGE_BUILD_TTL", 24*time.Hour),
@@ -87,13 +208,25 @@ func Load() *Config {
 			DiskWarning:   getIntEnv("STORAGE_DISK_WARNING", 80),
 			DiskCritical:  getIntEnv("STORAGE_DISK_CRITICAL", 90),
 			DiskEmergency: getIntEnv("STORAGE_DISK_EMERGENCY", 95),
+			Objects: ObjectStoreConfig{
+				Endpoint:  getEnvOrDefault("ARTIFACT_STORE_ENDPOINT", "https://s3.amazonaws.com"),
+				Bucket:    os.Getenv("ARTIFACT_STORE_BUCKET"),
+				Region:    getEnvOrDefault("ARTIFACT_STORE_REGION", "us-east-1"),
+				AccessKey: os.Getenv("ARTIFACT_STORE_ACCESS_KEY"),
+				SecretKey: os.Getenv("ARTIFACT_STORE_SECRET_KEY"),
+				PathStyle: getEnvOrDefault("ARTIFACT_STORE_PATH_STYLE", "false") == "true",
+				URLTTL:    getDurationEnv("ARTIFACT_STORE_URL_TTL", 15*time.Minute),
+			},
 		},
 		Cleanup: CleanupConfig{
-			Interval: getDurationEnv("CLEANUP_INTERVAL", time.Hour),
-			TTL:      getDurationEnv("CLEANUP_TTL", 24*time.Hour),
+			Interval:     getDurationEnv("CLEANUP_INTERVAL", time.Hour),
+			TTL:          getDurationEnv("CLEANUP_TTL", 24*time.Hour),
+			BlobGrace:    getDurationEnv("CLEANUP_BLOB_GRACE", 24*time.Hour),
+			ProjectTTL:   getDurationEnv("CLEANUP_PROJECT_TTL", 30*24*time.Hour),
+			AccountGrace: getDurationEnv("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
 		},
 		Rate: RateConfig{
-			RedisURL: getEnvOrDefault("REDIS_URL", "redis://localhost:6379"),
+			RedisURL: os.Getenv("REDIS_URL"),
 		},
 		Billing: BillingConfig{
 			RazorpayKeyID:         os.Getenv("RAZORPAY_KEY_ID"),
@@ -102,6 +235,22 @@ func Load() *Config {
 			PlanFree:              os.Getenv("RAZORPAY_PLAN_FREE"),
 			PlanPro:               os.Getenv("RAZORPAY_PLAN_PRO"),
 			PlanEnterprise:        os.Getenv("RAZORPAY_PLAN_ENTERPRISE"),
+			GracePeriod:           getDurationEnv("BILLING_GRACE_PERIOD", 7*24*time.Hour),
+		},
+		Notify: NotifyConfig{
+			SMTPHost:     os.Getenv("NOTIFY_SMTP_HOST"),
+			SMTPPort:     getIntEnv("NOTIFY_SMTP_PORT", 587),
+			SMTPUsername: os.Getenv("NOTIFY_SMTP_USERNAME"),
+			SMTPPassword: os.Getenv("NOTIFY_SMTP_PASSWORD"),
+			SMTPTimeout:  getDurationEnv("NOTIFY_SMTP_TIMEOUT", 30*time.Second),
+			QueueSize:    getIntEnv("NOTIFY_QUEUE_SIZE", 256),
+			From:         getEnvOrDefault("NOTIFY_EMAIL_FROM", "Treefrog <builds@treefrog.local>"),
+			PublicURL:    getEnvOrDefault("PUBLIC_URL", "http://localhost:9000"),
+			LinkTTL:      getDurationEnv("NOTIFY_LINK_TTL", 24*time.Hour),
+		},
+		Region: RegionConfig{
+			Name: os.Getenv("REGION"),
+			URLs: getMapEnv("REGION_URLS", nil),
 		},
 	}
 }
@@ -131,6 +280,38 @@ func getInt64Env(key string, defaultVal int64) int64 {
 	return defaultVal
 }
 
+// getMapEnv reads a list of key=value pairs separated by commas
+func getMapEnv(key string, defaultVal map[string]string) map[string]string {
+	val := os.Getenv(key)
+	if val == "" {
+		return defaultVal
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && k != "" && v != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// getDurationMapEnv parses key=duration pairs like getMapEnv, skipping
+// invalid durations
+func getDurationMapEnv(key string, defaultVal map[string]time.Duration) map[string]time.Duration {
+	pairs := getMapEnv(key, nil)
+	if pairs == nil {
+		return defaultVal
+	}
+	m := make(map[string]time.Duration)
+	for k, v := range pairs {
+		if d, err := time.ParseDuration(v); err == nil {
+			m[k] = d
+		}
+	}
+	return m
+}
+
 func getDurationEnv(key string, defaultVal time.Duration) time.Duration {
 	if val := os.Getenv(key); val != "" {
 		if d, err := time.ParseDuration(val); err == nil {
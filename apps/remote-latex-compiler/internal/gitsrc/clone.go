@@ -0,0 +1,340 @@
+// Package gitsrc fetches build sources from git repositories.
+package gitsrc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultRef is fetched when no ref is given
+const DefaultRef = "HEAD"
+
+// Source is a repository revision to build
+type Source struct {
+	URL       string
+	Ref       string
+	DeployKey string // optional SSH private key; never written inside the build directory
+}
+
+var (
+	scpURLRe = regexp.MustCompile(`^[A-Za-z0-9._-]+@([A-Za-z0-9.-]+):([^:].*)$`)
+	refRe    = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	// hostRe keeps hosts to names and IP addresses, which also keeps them
+	// safe to put in GIT_SSH_COMMAND
+	hostRe = regexp.MustCompile(`^[A-Za-z0-9.:-]+$`)
+	// escapeRe finds terminal escape sequences in git's output
+	escapeRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, which is no more
+// public than the private ranges
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Options bound a clone
+type Options struct {
+	MaxBytes int64 // largest checkout accepted; the fetch may use twice this
+	// KnownHostsFile holds the host keys ssh repositories are checked
+	// against; without one only https repositories can be cloned
+	KnownHostsFile string
+}
+
+// remote is where a repository URL points
+type remote struct {
+	scheme string // https or ssh
+	host   string
+	port   string
+}
+
+// ValidateURL accepts https and ssh repository URLs on public hosts.
+// Local paths, file:// and other transports are rejected.
+func ValidateURL(raw string) error {
+	r, err := parseURL(raw)
+	if err != nil {
+		return err
+	}
+	_, err = resolvePublicHost(r.host)
+	return err
+}
+
+func parseURL(raw string) (remote, error) {
+	if m := scpURLRe.FindStringSubmatch(raw); m != nil {
+		if strings.HasPrefix(m[1], "-") {
+			return remote{}, fmt.Errorf("invalid repository host")
+		}
+		return remote{scheme: "ssh", host: m[1], port: "22"}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return remote{}, fmt.Errorf("invalid repository url: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "ssh" {
+		return remote{}, fmt.Errorf("repository url must use https or ssh")
+	}
+	if u.Scheme == "https" && u.User != nil {
+		return remote{}, fmt.Errorf("credentials in repository url are not allowed; use a deploy key")
+	}
+	r := remote{scheme: u.Scheme, host: u.Hostname(), port: u.Port()}
+	if !hostRe.MatchString(r.host) || strings.HasPrefix(r.host, "-") {
+		return remote{}, fmt.Errorf("invalid repository host")
+	}
+	if r.port == "" {
+		r.port = map[string]string{"https": "443", "ssh": "22"}[r.scheme]
+	}
+	return r, nil
+}
+
+// resolvePublicHost resolves host and returns the address to connect to,
+// refusing hosts with any loopback, private or link-local address so the
+// compiler cannot be used to reach internal services
+func resolvePublicHost(host string) (net.IP, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil || len(ips) == 0 {
+		return nil, fmt.Errorf("cannot resolve repository host %q", host)
+	}
+	for _, ip := range ips {
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+			return nil, fmt.Errorf("repository host %q is not public", host)
+		}
+	}
+	return ips[0], nil
+}
+
+// ValidateRef accepts branch, tag and commit names
+func ValidateRef(ref string) error {
+	if ref == "" {
+		return nil
+	}
+	if !refRe.MatchString(ref) || strings.HasPrefix(ref, "-") || strings.Contains(ref, "..") || len(ref) > 255 {
+		return fmt.Errorf("invalid ref %q", ref)
+	}
+	return nil
+}
+
+// Clone shallowly fetches src into dest, which must exist and be empty, and
+// checks out the ref without git metadata. It returns the fetched commit.
+//
+// Git connects to the address the host resolved to when it was checked, so
+// the name cannot be rebound to an internal one in between, and does not
+// follow redirects. ssh host keys must be in opts.KnownHostsFile. The clone
+// is stopped once dest holds more than twice opts.MaxBytes, and the
+// checkout is rejected when it exceeds opts.MaxBytes.
+func Clone(ctx context.Context, src Source, dest string, opts Options) (string, error) {
+	ref := src.Ref
+	if ref == "" {
+		ref = DefaultRef
+	}
+	r, err := parseURL(src.URL)
+	if err != nil {
+		return "", err
+	}
+	ip, err := resolvePublicHost(r.host)
+	if err != nil {
+		return "", err
+	}
+
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ALLOW_PROTOCOL=https:ssh",
+		"GIT_CONFIG_NOSYSTEM=1",
+	)
+	config := []string{
+		"core.symlinks=false",
+		"http.followRedirects=false",
+		// Give up on a transfer slower than 1KB/s for 30s
+		"http.lowSpeedLimit=1024",
+		"http.lowSpeedTime=30",
+	}
+
+	keyFile := ""
+	if r.scheme == "https" {
+		addr := ip.String()
+		if ip.To4() == nil {
+			addr = "[" + addr + "]"
+		}
+		config = append(config, fmt.Sprintf("http.curloptResolve=%s:%s:%s", r.host, r.port, addr))
+	} else {
+		if opts.KnownHostsFile == "" {
+			return "", fmt.Errorf("ssh repositories are not supported on this server; use https")
+		}
+		ssh := []string{"ssh", "-F", "/dev/null",
+			"-o", "BatchMode=yes",
+			"-o", "StrictHostKeyChecking=yes",
+			"-o", "UserKnownHostsFile=" + opts.KnownHostsFile,
+			"-o", "GlobalKnownHostsFile=/dev/null",
+			"-o", "HostKeyAlias=" + r.host,
+			"-o", "HostName=" + ip.String(),
+			"-o", "ConnectTimeout=15",
+			"-o", "ServerAliveInterval=15",
+			"-o", "ServerAliveCountMax=2",
+		}
+		if src.DeployKey != "" {
+			f, err := os.CreateTemp("", "deploy-key-*")
+			if err != nil {
+				return "", fmt.Errorf("failed to store deploy key: %w", err)
+			}
+			keyFile = f.Name()
+			defer os.Remove(keyFile)
+
+			key := strings.TrimSpace(src.DeployKey) + "\n"
+			if _, err := f.WriteString(key); err != nil {
+				f.Close()
+				return "", fmt.Errorf("failed to store deploy key: %w", err)
+			}
+			f.Close()
+			ssh = append(ssh, "-i", keyFile, "-o", "IdentitiesOnly=yes")
+		}
+		env = append(env, "GIT_SSH_COMMAND="+strings.Join(ssh, " "))
+	}
+
+	g := &git{dir: dest, env: env, config: config, keyFile: keyFile, maxBytes: 2 * opts.MaxBytes}
+	steps := [][]string{
+		{"init", "-q"},
+		{"remote", "add", "origin", src.URL},
+		{"fetch", "-q", "--depth", "1", "--no-tags", "origin", ref},
+		{"checkout", "-q", "FETCH_HEAD"},
+	}
+	for _, args := range steps {
+		if _, err := g.run(ctx, args...); err != nil {
+			return "", err
+		}
+	}
+
+	commit, err := g.run(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(filepath.Join(dest, ".git")); err != nil {
+		return "", fmt.Errorf("failed to remove git metadata: %w", err)
+	}
+
+	size, err := dirSize(dest)
+	if err != nil {
+		return "", err
+	}
+	if size > opts.MaxBytes {
+		return "", fmt.Errorf("repository checkout too large (%dMB, max %dMB)", size/(1024*1024), opts.MaxBytes/(1024*1024))
+	}
+
+	return strings.TrimSpace(commit), nil
+}
+
+// sizeCheckInterval is how often a running git command's directory is
+// measured
+const sizeCheckInterval = time.Second
+
+// git runs the commands of one clone
+type git struct {
+	dir      string
+	env      []string
+	config   []string // -c settings of every command
+	keyFile  string   // deploy key path, kept out of error messages
+	maxBytes int64    // dir size at which a command is stopped
+}
+
+// run runs a git subcommand. Symlinks are checked out as plain files so a
+// repository cannot link to files outside the build directory.
+func (g *git) run(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var cmdArgs []string
+	for _, c := range g.config {
+		cmdArgs = append(cmdArgs, "-c", c)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(cmdArgs, args...)...)
+	cmd.Dir = g.dir
+	cmd.Env = g.env
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+
+	tooLarge := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(sizeCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if size, err := dirSize(g.dir); err == nil && size > g.maxBytes {
+				close(tooLarge)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	select {
+	case <-tooLarge:
+		return "", fmt.Errorf("repository too large (max %dMB)", g.maxBytes/2/(1024*1024))
+	default:
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git %s timed out", args[0])
+		}
+		return "", fmt.Errorf("git %s failed: %s", args[0], g.sanitize(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// sanitize makes git's stderr fit to show a user: the deploy key's path is
+// dropped, and so are control characters a remote could send to mess with a
+// terminal
+func (g *git) sanitize(msg string) string {
+	if g.keyFile != "" {
+		msg = strings.ReplaceAll(msg, g.keyFile, "deploy key")
+	}
+	msg = escapeRe.ReplaceAllString(msg, "")
+	msg = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return ' '
+		case unicode.IsPrint(r):
+			return r
+		}
+		return -1
+	}, msg)
+	msg = strings.Join(strings.Fields(msg), " ")
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+	return msg
+}
+
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
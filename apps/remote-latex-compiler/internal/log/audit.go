@@ -14,17 +14,18 @@ type AuditLogger struct {
 }
 
 type AuditEntry struct {
-	ID           string
-	UserID       string
-	Action       string // e.g., "build_created", "subscription_upgraded"
-	ResourceType string // e.g., "build", "subscription"
-	ResourceID   string
-	Details      string // JSON encoded details
-	IPAddress    string
-	UserAgent    string
-	Status       string // "success" or "failure"
-	ErrorMessage string
-	CreatedAt    time.Time
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	Action         string    `json:"action"`        // e.g., "build_created", "subscription_upgraded"
+	ResourceType   string    `json:"resource_type"` // e.g., "build", "subscription"
+	ResourceID     string    `json:"resource_id,omitempty"`
+	Details        string    `json:"details,omitempty"` // JSON encoded details
+	IPAddress      string    `json:"ip_address,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	Status         string    `json:"status"` // "success" or "failure"
+	ErrorMessage   string    `json:"error_message,omitempty"`
+	ImpersonatedBy string    `json:"impersonated_by,omitempty"` // admin acting as UserID, if any
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 func NewAuditLogger(logger *logrus.Logger, db *sql.DB) *AuditLogger {
@@ -49,6 +50,9 @@ func (al *AuditLogger) Log(entry AuditEntry) error {
 		"status":        entry.Status,
 		"ip_address":    entry.IPAddress,
 	}
+	if entry.ImpersonatedBy != "" {
+		fields["impersonated_by"] = entry.ImpersonatedBy
+	}
 	if entry.ErrorMessage != "" {
 		fields["error"] = entry.ErrorMessage
 		al.logger.WithFields(fields).Warn("Audit event: " + entry.Action)
@@ -58,10 +62,40 @@ func (al *AuditLogger) Log(entry AuditEntry) error {
 
 	// Store in database
 	_, err := al.db.Exec(`
-		INSERT INTO audit_logs (id, user_id, action, resource_type, resource_id, details, ip_address, user_agent, status, error_message, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		INSERT INTO audit_logs (id, user_id, action, resource_type, resource_id, details, ip_address, user_agent, status, error_message, impersonated_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, '')::uuid, $12)`,
 		entry.ID, entry.UserID, entry.Action, entry.ResourceType, entry.ResourceID, entry.Details,
-		entry.IPAddress, entry.UserAgent, entry.Status, entry.ErrorMessage, entry.CreatedAt)
+		entry.IPAddress, entry.UserAgent, entry.Status, entry.ErrorMessage, entry.ImpersonatedBy, entry.CreatedAt)
 
 	return err
 }
+
+// ListByUser returns the audit entries of a user, oldest first
+func (al *AuditLogger) ListByUser(userID string) ([]AuditEntry, error) {
+	rows, err := al.db.Query(`
+		SELECT id, action, resource_type, resource_id, details, ip_address, user_agent, status, error_message, impersonated_by, created_at
+		FROM audit_logs WHERE user_id = $1 ORDER BY created_at ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		entry := AuditEntry{UserID: userID}
+		var resourceID, details, ipAddress, userAgent, status, errorMessage, impersonatedBy sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Action, &entry.ResourceType, &resourceID, &details,
+			&ipAddress, &userAgent, &status, &errorMessage, &impersonatedBy, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.ResourceID = resourceID.String
+		entry.Details = details.String
+		entry.IPAddress = ipAddress.String
+		entry.UserAgent = userAgent.String
+		entry.Status = status.String
+		entry.ErrorMessage = errorMessage.String
+		entry.ImpersonatedBy = impersonatedBy.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,226 @@
+// Package notify delivers build completion and billing notifications to
+// users.
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// BuildFinished describes a finished build for a notification
+type BuildFinished struct {
+	To          string
+	UserID      string
+	BuildID     string
+	MainFile    string
+	Status      buildpkg.Status
+	Duration    time.Duration
+	Message     string
+	ArtifactURL string
+}
+
+// BillingStatusChanged tells a user their account moved between billing
+// statuses after a payment failed or succeeded
+type BillingStatusChanged struct {
+	To        string
+	Status    string     // active, past_due or suspended
+	Amount    int64      // of the failed payment, in the smallest currency unit; 0 if unknown
+	GraceEnds *time.Time // when a past due account is suspended
+}
+
+// Notifier sends build completion and billing notifications.
+// Implementations must be safe for concurrent use.
+type Notifier interface {
+	BuildFinished(n BuildFinished) error
+	BillingStatusChanged(n BillingStatusChanged) error
+}
+
+// DefaultSMTPTimeout bounds connecting to the mail server and, separately,
+// the whole exchange of a message
+const DefaultSMTPTimeout = 30 * time.Second
+
+// SMTPConfig configures the SMTP notifier
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration // DefaultSMTPTimeout if zero
+}
+
+// SMTPNotifier sends notifications as plain-text email
+type SMTPNotifier struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPNotifier creates an SMTP notifier
+func NewSMTPNotifier(cfg SMTPConfig) (*SMTPNotifier, error) {
+	if cfg.Host == "" || cfg.From == "" {
+		return nil, fmt.Errorf("smtp host and from address required")
+	}
+	if cfg.Port == 0 {
+		cfg.Port = 587
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultSMTPTimeout
+	}
+	return &SMTPNotifier{cfg: cfg}, nil
+}
+
+// BuildFinished emails the build outcome to n.To
+func (s *SMTPNotifier) BuildFinished(n BuildFinished) error {
+	if n.To == "" {
+		return fmt.Errorf("recipient required")
+	}
+	outcome := "succeeded"
+	if n.Status != buildpkg.StatusCompleted {
+		outcome = "failed"
+	}
+	return s.send(n.To, fmt.Sprintf("Treefrog build %s: %s", outcome, n.MainFile), s.message(n))
+}
+
+// BillingStatusChanged emails a billing status change to n.To
+func (s *SMTPNotifier) BillingStatusChanged(n BillingStatusChanged) error {
+	if n.To == "" {
+		return fmt.Errorf("recipient required")
+	}
+
+	var subject string
+	var text bytes.Buffer
+	switch n.Status {
+	case "past_due":
+		subject = "Treefrog payment failed"
+		text.WriteString("We could not process your latest subscription payment")
+		if n.Amount > 0 {
+			fmt.Fprintf(&text, " of %d.%02d", n.Amount/100, n.Amount%100)
+		}
+		text.WriteString(".\r\n\r\nPlease update your payment method.")
+		if n.GraceEnds != nil {
+			fmt.Fprintf(&text, " Your account keeps full access until %s; after that it becomes read-only.",
+				n.GraceEnds.UTC().Format("January 2, 2006 15:04 MST"))
+		}
+		text.WriteString("\r\n")
+	case "suspended":
+		subject = "Treefrog account suspended"
+		text.WriteString("Your subscription payment is still outstanding, so your account has been suspended.\r\n\r\n")
+		text.WriteString("You can still view and download your builds, but new builds are disabled until a payment succeeds.\r\n")
+	case "active":
+		subject = "Treefrog payment received"
+		text.WriteString("Your payment went through and your account is fully restored. Thank you!\r\n")
+	default:
+		return fmt.Errorf("unknown billing status %q", n.Status)
+	}
+
+	return s.send(n.To, subject, text.Bytes())
+}
+
+// send emails a plain-text message
+func (s *SMTPNotifier) send(to, subject string, text []byte) error {
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	var body bytes.Buffer
+	s.writeHeader(&body, to, subject)
+	body.Write(text)
+
+	if err := s.sendMail(auth, to, body.Bytes()); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// sendMail is smtp.SendMail with deadlines: a mail server that accepts the
+// connection and then stalls cannot hold the sender past the timeout
+func (s *SMTPNotifier) sendMail(auth smtp.Auth, to string, msg []byte) error {
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	conn, err := net.DialTimeout("tcp", addr, s.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp server does not support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.cfg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *SMTPNotifier) writeHeader(body *bytes.Buffer, to, subject string) {
+	fmt.Fprintf(body, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(body, "To: %s\r\n", to)
+	fmt.Fprintf(body, "Subject: %s\r\n", headerSafe(subject))
+	fmt.Fprintf(body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+}
+
+// message is the text of a build notification
+func (s *SMTPNotifier) message(n BuildFinished) []byte {
+	outcome := "succeeded"
+	if n.Status != buildpkg.StatusCompleted {
+		outcome = "failed"
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Your build of %s %s.\r\n\r\n", n.MainFile, outcome)
+	fmt.Fprintf(&body, "Build:    %s\r\n", n.BuildID)
+	fmt.Fprintf(&body, "Status:   %s\r\n", n.Status)
+	fmt.Fprintf(&body, "Duration: %s\r\n", n.Duration.Round(time.Second))
+	if n.Message != "" {
+		fmt.Fprintf(&body, "\r\n%s\r\n", n.Message)
+	}
+	if n.ArtifactURL != "" {
+		fmt.Fprintf(&body, "\r\nDownload: %s\r\n", n.ArtifactURL)
+	}
+	return body.Bytes()
+}
+
+// headerSafe strips line breaks so user-controlled values cannot inject headers
+func headerSafe(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	buildpkg "github.com/alpha-og/treefrog/packages/go/build"
+)
+
+// fakeSMTP accepts one connection on a local port and hands it to serve
+func fakeSMTP(t *testing.T, serve func(net.Conn)) SMTPConfig {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return SMTPConfig{Host: host, Port: p, From: "builds@example.com"}
+}
+
+func TestSMTPNotifierSends(t *testing.T) {
+	received := make(chan string, 1)
+	cfg := fakeSMTP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	})
+
+	s, err := NewSMTPNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+	err = s.BuildFinished(BuildFinished{
+		To:       "user@example.com",
+		BuildID:  "b1",
+		MainFile: "main.tex",
+		Status:   buildpkg.StatusCompleted,
+	})
+	if err != nil {
+		t.Fatalf("BuildFinished: %v", err)
+	}
+	msg := <-received
+	if !strings.Contains(msg, "Subject: Treefrog build succeeded: main.tex") {
+		t.Errorf("message = %q, want the build subject", msg)
+	}
+}
+
+func TestSMTPNotifierTimesOut(t *testing.T) {
+	// A server that accepts the connection and never greets
+	release := make(chan struct{})
+	defer close(release)
+	cfg := fakeSMTP(t, func(net.Conn) { <-release })
+	cfg.Timeout = 100 * time.Millisecond
+
+	s, err := NewSMTPNotifier(cfg)
+	if err != nil {
+		t.Fatalf("NewSMTPNotifier: %v", err)
+	}
+	start := time.Now()
+	err = s.BuildFinished(BuildFinished{To: "user@example.com", MainFile: "main.tex"})
+	if err == nil {
+		t.Fatal("BuildFinished to a stalled server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("BuildFinished took %v, want it bounded by the timeout", elapsed)
+	}
+}
+
+// blockingNotifier delivers nothing until released
+type blockingNotifier struct {
+	started chan struct{}
+	release chan struct{}
+	sent    chan string
+}
+
+func (b *blockingNotifier) BuildFinished(n BuildFinished) error {
+	b.started <- struct{}{}
+	<-b.release
+	b.sent <- n.BuildID
+	return nil
+}
+
+func (b *blockingNotifier) BillingStatusChanged(n BillingStatusChanged) error {
+	b.started <- struct{}{}
+	<-b.release
+	b.sent <- n.Status
+	return nil
+}
+
+func TestQueueDoesNotBlock(t *testing.T) {
+	next := &blockingNotifier{
+		started: make(chan struct{}, 8),
+		release: make(chan struct{}),
+		sent:    make(chan string, 8),
+	}
+	q := NewQueue(next, 2)
+	audited := make(chan string, 8)
+	q.OnBuildSent = func(n BuildFinished) { audited <- n.BuildID }
+
+	// The first is taken by the worker, which stalls; two more fill the
+	// queue and the next is dropped, none of them waiting
+	start := time.Now()
+	if err := q.BuildFinished(BuildFinished{BuildID: "b1"}); err != nil {
+		t.Fatalf("BuildFinished: %v", err)
+	}
+	<-next.started
+	for _, id := range []string{"b2", "b3"} {
+		if err := q.BuildFinished(BuildFinished{BuildID: id}); err != nil {
+			t.Fatalf("BuildFinished: %v", err)
+		}
+	}
+	if err := q.BuildFinished(BuildFinished{BuildID: "b4"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("BuildFinished on a full queue = %v, want ErrQueueFull", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("queueing took %v with a stalled notifier", elapsed)
+	}
+
+	close(next.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(next.sent) != 3 || len(audited) != 3 {
+		t.Errorf("sent %d and audited %d notifications, want 3", len(next.sent), len(audited))
+	}
+	if err := q.BillingStatusChanged(BillingStatusChanged{Status: "active"}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("BillingStatusChanged after Close = %v, want ErrQueueClosed", err)
+	}
+}
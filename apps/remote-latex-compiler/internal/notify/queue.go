@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var log = logrus.WithField("component", "notify/queue")
+
+// DefaultQueueSize is how many notifications may wait to be sent
+const DefaultQueueSize = 256
+
+// ErrQueueFull is returned for notifications that arrive while the queue is
+// full; they are dropped
+var ErrQueueFull = errors.New("notification queue full")
+
+// ErrQueueClosed is returned for notifications that arrive after Close
+var ErrQueueClosed = errors.New("notification queue closed")
+
+// Queue sends notifications through another Notifier from a goroutine of
+// its own, one at a time, so the build queue and billing runs never wait on
+// a mail server. Delivery errors are logged.
+type Queue struct {
+	next Notifier
+	jobs chan queued
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+
+	// OnBuildSent, if set, is called once a build notification has been
+	// delivered. Set it before the first notification.
+	OnBuildSent func(BuildFinished)
+}
+
+type queued struct {
+	fields logrus.Fields
+	send   func() error
+}
+
+// NewQueue starts a queue holding up to size notifications, or
+// DefaultQueueSize if size is not positive
+func NewQueue(next Notifier, size int) *Queue {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	q := &Queue{
+		next: next,
+		jobs: make(chan queued, size),
+		done: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// BuildFinished queues a build notification
+func (q *Queue) BuildFinished(n BuildFinished) error {
+	return q.enqueue(queued{
+		fields: logrus.Fields{"build_id": n.BuildID},
+		send: func() error {
+			if err := q.next.BuildFinished(n); err != nil {
+				return err
+			}
+			if q.OnBuildSent != nil {
+				q.OnBuildSent(n)
+			}
+			return nil
+		},
+	})
+}
+
+// BillingStatusChanged queues a billing notification
+func (q *Queue) BillingStatusChanged(n BillingStatusChanged) error {
+	return q.enqueue(queued{
+		fields: logrus.Fields{"billing_status": n.Status},
+		send:   func() error { return q.next.BillingStatusChanged(n) },
+	})
+}
+
+func (q *Queue) enqueue(job queued) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	for job := range q.jobs {
+		if err := job.send(); err != nil {
+			log.WithError(err).WithFields(job.fields).Error("Failed to send notification")
+		}
+	}
+}
+
+// Close stops taking notifications and waits until the queued ones are sent
+// or ctx is done
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
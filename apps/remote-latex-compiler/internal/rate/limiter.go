@@ -4,26 +4,19 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"os"
 	"time"
 
+	"github.com/alpha-og/treefrog/apps/remote-latex-compiler/internal/auth"
 	"github.com/go-redis/redis/v8"
 	"github.com/sirupsen/logrus"
 )
 
 var log = logrus.WithField("component", "rate/limiter")
 
-var incrExpireScript = redis.NewScript(`
-	local count = redis.call('INCR', KEYS[1])
-	if count == 1 then
-		redis.call('EXPIRE', KEYS[1], ARGV[1])
-	end
-	return count
-`)
-
-// Limiter provides rate limiting using Redis as a backend
+// Limiter provides rate limiting, counting requests in Redis or, without
+// Redis, in memory
 type Limiter struct {
-	client *redis.Client
+	store  counterStore
 	config map[string]RateLimit
 }
 
@@ -38,24 +31,27 @@ func TierLimits(tier string) map[string]RateLimit {
 	switch tier {
 	case "pro":
 		return map[string]RateLimit{
-			"build":    {Requests: 30, Window: time.Minute},
-			"download": {Requests: 120, Window: time.Minute},
-			"status":   {Requests: 60, Window: time.Minute},
-			"default":  {Requests: 300, Window: time.Minute},
+			"build":       {Requests: 30, Window: time.Minute},
+			"download":    {Requests: 120, Window: time.Minute},
+			"status":      {Requests: 60, Window: time.Minute},
+			"default":     {Requests: 300, Window: time.Minute},
+			"impersonate": {Requests: 10, Window: time.Hour},
 		}
 	case "enterprise":
 		return map[string]RateLimit{
-			"build":    {Requests: 100, Window: time.Minute},
-			"download": {Requests: 300, Window: time.Minute},
-			"status":   {Requests: 120, Window: time.Minute},
-			"default":  {Requests: 600, Window: time.Minute},
+			"build":       {Requests: 100, Window: time.Minute},
+			"download":    {Requests: 300, Window: time.Minute},
+			"status":      {Requests: 120, Window: time.Minute},
+			"default":     {Requests: 600, Window: time.Minute},
+			"impersonate": {Requests: 10, Window: time.Hour},
 		}
 	default: // free tier
 		return map[string]RateLimit{
-			"build":    {Requests: 10, Window: time.Minute},
-			"download": {Requests: 60, Window: time.Minute},
-			"status":   {Requests: 30, Window: time.Minute},
-			"default":  {Requests: 100, Window: time.Minute},
+			"build":       {Requests: 10, Window: time.Minute},
+			"download":    {Requests: 60, Window: time.Minute},
+			"status":      {Requests: 30, Window: time.Minute},
+			"default":     {Requests: 100, Window: time.Minute},
+			"impersonate": {Requests: 10, Window: time.Hour},
 		}
 	}
 }
@@ -65,11 +61,15 @@ func DefaultLimits() map[string]RateLimit {
 	return TierLimits("free")
 }
 
-// NewLimiter creates a new rate limiter connected to Redis
-func NewLimiter() (*Limiter, error) {
-	redisURL := os.Getenv("REDIS_URL")
+// NewLimiter creates a rate limiter counting requests in the Redis at
+// redisURL, or in memory if redisURL is empty
+func NewLimiter(redisURL string) (*Limiter, error) {
 	if redisURL == "" {
-		redisURL = "redis://localhost:6379"
+		log.Warn("REDIS_URL not set, rate limits are counted in memory: with several instances each enforces them separately")
+		return &Limiter{
+			store:  newMemoryStore(),
+			config: DefaultLimits(),
+		}, nil
 	}
 
 	opts, err := redis.ParseURL(redisURL)
@@ -89,30 +89,27 @@ func NewLimiter() (*Limiter, error) {
 	log.WithField("redis_url", redisURL).Info("Rate limiter connected to Redis")
 
 	return &Limiter{
-		client: client,
+		store:  &redisStore{client: client},
 		config: DefaultLimits(),
 	}, nil
 }
 
-// Close closes the Redis connection
+// Close closes the Redis connection, if any
 func (l *Limiter) Close() error {
-	if l.client != nil {
-		return l.client.Close()
-	}
-	return nil
+	return l.store.Close()
 }
 
 // Middleware returns HTTP middleware that enforces rate limits on requests
 func (l *Limiter) Middleware(action string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			userID, ok := r.Context().Value("userID").(string)
-			if !ok || userID == "" {
+			userID, ok := auth.GetUserID(r)
+			if !ok {
 				next.ServeHTTP(w, r)
 				return
 			}
 
-			tier, _ := r.Context().Value("userTier").(string)
+			tier := auth.GetUserTier(r)
 			if tier == "" {
 				tier = "free"
 			}
@@ -128,7 +125,7 @@ func (l *Limiter) Middleware(action string) func(http.Handler) http.Handler {
 			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 			defer cancel()
 
-			count, err := incrExpireScript.Run(ctx, l.client, []string{key}, int(limit.Window.Seconds())).Int64()
+			count, err := l.store.Incr(ctx, key, limit.Window)
 			if err != nil {
 				log.WithError(err).Warn("Redis error during rate limiting, allowing request")
 				next.ServeHTTP(w, r)
@@ -179,7 +176,7 @@ func (l *Limiter) Allow(userID, action, tier string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	count, err := incrExpireScript.Run(ctx, l.client, []string{key}, int(limit.Window.Seconds())).Int64()
+	count, err := l.store.Incr(ctx, key, limit.Window)
 	if err != nil {
 		return false, err
 	}
@@ -207,15 +204,12 @@ func (l *Limiter) GetRemaining(userID, action, tier string) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
-	count, err := l.client.Get(ctx, key).Int()
+	count, err := l.store.Get(ctx, key)
 	if err != nil {
-		if err == redis.Nil {
-			return limit.Requests, nil
-		}
 		return 0, err
 	}
 
-	remaining := limit.Requests - count
+	remaining := limit.Requests - int(count)
 	if remaining < 0 {
 		remaining = 0
 	}
@@ -225,5 +219,5 @@ func (l *Limiter) GetRemaining(userID, action, tier string) (int, error) {
 
 // Increment increments a counter for the given key and returns the new value
 func (l *Limiter) Increment(ctx context.Context, key string, ttl time.Duration) (int64, error) {
-	return incrExpireScript.Run(ctx, l.client, []string{key}, int(ttl.Seconds())).Int64()
+	return l.store.Incr(ctx, key, ttl)
 }
@@ -0,0 +1,112 @@
+package rate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// counterStore counts requests in fixed windows: a key's count starts at
+// its first increment and is reset ttl later
+type counterStore interface {
+	// Incr increments the count of key and returns the new count
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Get returns the count of key, 0 if it has none
+	Get(ctx context.Context, key string) (int64, error)
+	Close() error
+}
+
+var incrExpireScript = redis.NewScript(`
+	local count = redis.call('INCR', KEYS[1])
+	if count == 1 then
+		redis.call('EXPIRE', KEYS[1], ARGV[1])
+	end
+	return count
+`)
+
+// redisStore shares counts between all instances using the same Redis
+type redisStore struct {
+	client *redis.Client
+}
+
+func (s *redisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrExpireScript.Run(ctx, s.client, []string{key}, int(ttl.Seconds())).Int64()
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) (int64, error) {
+	count, err := s.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+// memoryPruneInterval is how often expired windows are dropped from memory
+const memoryPruneInterval = time.Minute
+
+// memoryStore keeps counts in the process. Each instance counts only the
+// requests it serves, so behind a load balancer users get up to the limit
+// once per instance.
+type memoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]memoryWindow
+	lastPrune time.Time
+}
+
+type memoryWindow struct {
+	count   int64
+	expires time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{windows: make(map[string]memoryWindow), lastPrune: time.Now()}
+}
+
+func (s *memoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(now)
+	w := s.windows[key]
+	if !now.Before(w.expires) {
+		w = memoryWindow{expires: now.Add(ttl)}
+	}
+	w.count++
+	s.windows[key] = w
+	return w.count, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[key]
+	if !ok || !time.Now().Before(w.expires) {
+		return 0, nil
+	}
+	return w.count, nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// prune drops expired windows, at most once per memoryPruneInterval
+func (s *memoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < memoryPruneInterval {
+		return
+	}
+	s.lastPrune = now
+	for key, w := range s.windows {
+		if !now.Before(w.expires) {
+			delete(s.windows, key)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package rate
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreCounts(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		count, err := s.Incr(ctx, "a", time.Minute)
+		if err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		if count != want {
+			t.Errorf("Incr = %d, want %d", count, want)
+		}
+	}
+	if count, _ := s.Incr(ctx, "b", time.Minute); count != 1 {
+		t.Errorf("Incr of another key = %d, want 1", count)
+	}
+	if count, _ := s.Get(ctx, "a"); count != 3 {
+		t.Errorf("Get = %d, want 3", count)
+	}
+	if count, _ := s.Get(ctx, "missing"); count != 0 {
+		t.Errorf("Get of unknown key = %d, want 0", count)
+	}
+}
+
+func TestMemoryStoreWindowReset(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+	ttl := 20 * time.Millisecond
+
+	s.Incr(ctx, "a", ttl)
+	s.Incr(ctx, "a", ttl)
+	time.Sleep(2 * ttl)
+
+	if count, _ := s.Get(ctx, "a"); count != 0 {
+		t.Errorf("Get after the window = %d, want 0", count)
+	}
+	if count, _ := s.Incr(ctx, "a", ttl); count != 1 {
+		t.Errorf("Incr after the window = %d, want 1", count)
+	}
+}
+
+func TestMemoryStoreWindowIsFixed(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+	ttl := 50 * time.Millisecond
+
+	// Increments within a window do not extend it
+	s.Incr(ctx, "a", ttl)
+	time.Sleep(ttl / 2)
+	s.Incr(ctx, "a", ttl)
+	time.Sleep(ttl)
+
+	if count, _ := s.Incr(ctx, "a", ttl); count != 1 {
+		t.Errorf("Incr after the first window = %d, want 1", count)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	s := newMemoryStore()
+	ctx := context.Background()
+
+	s.Incr(ctx, "expired", time.Millisecond)
+	s.Incr(ctx, "live", time.Hour)
+	time.Sleep(5 * time.Millisecond)
+
+	// Within the prune interval expired windows stay
+	s.Incr(ctx, "live", time.Hour)
+	if _, ok := s.windows["expired"]; !ok {
+		t.Fatal("expired window pruned before the prune interval")
+	}
+
+	s.lastPrune = time.Now().Add(-memoryPruneInterval)
+	s.Incr(ctx, "live", time.Hour)
+	if _, ok := s.windows["expired"]; ok {
+		t.Error("expired window not pruned")
+	}
+	if count, _ := s.Get(ctx, "live"); count != 3 {
+		t.Errorf("Get of live key = %d, want 3", count)
+	}
+}
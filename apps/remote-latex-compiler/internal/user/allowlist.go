@@ -3,6 +3,7 @@ package user
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,6 +18,9 @@ type AllowlistEntry struct {
 	IsActive  bool       `json:"is_active"`
 	CreatedAt time.Time  `json:"created_at"`
 	CreatedBy string     `json:"created_by"`
+
+	Accepted       int        `json:"accepted"` // users who signed in through the entry
+	LastAcceptedAt *time.Time `json:"last_accepted_at,omitempty"`
 }
 
 type AllowlistStore struct {
@@ -30,15 +34,20 @@ func NewAllowlistStore(db *sql.DB) (*AllowlistStore, error) {
 	return &AllowlistStore{db: db}, nil
 }
 
+// GetByEmail returns the active entry admitting an email: its own entry, or
+// else a wildcard entry of its domain
 func (s *AllowlistStore) GetByEmail(email string) (*AllowlistEntry, error) {
 	if email == "" {
 		return nil, fmt.Errorf("email required")
 	}
+	email = strings.ToLower(strings.TrimSpace(email))
 
 	var entry AllowlistEntry
 	err := s.db.QueryRow(`
 		SELECT id, email, tier, reason, expires_at, is_active, created_at, created_by
-		FROM allowlist WHERE email = $1 AND is_active = true`, email).Scan(
+		FROM allowlist WHERE lower(email) IN ($1, $2) AND is_active = true
+		ORDER BY expires_at IS NOT NULL AND expires_at < $3, lower(email) = $1 DESC
+		LIMIT 1`, email, domainWildcard(email), time.Now()).Scan(
 		&entry.ID, &entry.Email, &entry.Tier, &entry.Reason, &entry.ExpiresAt,
 		&entry.IsActive, &entry.CreatedAt, &entry.CreatedBy)
 
@@ -81,7 +90,7 @@ func (s *AllowlistStore) Create(entry *AllowlistEntry) error {
 }
 
 func (s *AllowlistStore) Remove(email string) error {
-	_, err := s.db.Exec("UPDATE allowlist SET is_active = false WHERE email = $1", email)
+	_, err := s.db.Exec("UPDATE allowlist SET is_active = false WHERE lower(email) = lower($1)", email)
 	if err != nil {
 		return fmt.Errorf("update failed: %w", err)
 	}
@@ -90,9 +99,13 @@ func (s *AllowlistStore) Remove(email string) error {
 
 func (s *AllowlistStore) List() ([]*AllowlistEntry, error) {
 	query := `
-		SELECT id, email, tier, reason, expires_at, is_active, created_at, created_by
-		FROM allowlist WHERE is_active = true
-		ORDER BY created_at DESC
+		SELECT a.id, a.email, a.tier, a.reason, a.expires_at, a.is_active, a.created_at, a.created_by,
+			COUNT(acc.user_id), MAX(acc.accepted_at)
+		FROM allowlist a
+		LEFT JOIN allowlist_acceptances acc ON acc.entry_id = a.id
+		WHERE a.is_active = true
+		GROUP BY a.id
+		ORDER BY a.created_at DESC
 	`
 
 	rows, err := s.db.Query(query)
@@ -106,7 +119,8 @@ func (s *AllowlistStore) List() ([]*AllowlistEntry, error) {
 		entry := &AllowlistEntry{}
 		err := rows.Scan(
 			&entry.ID, &entry.Email, &entry.Tier, &entry.Reason, &entry.ExpiresAt,
-			&entry.IsActive, &entry.CreatedAt, &entry.CreatedBy)
+			&entry.IsActive, &entry.CreatedAt, &entry.CreatedBy,
+			&entry.Accepted, &entry.LastAcceptedAt)
 		if err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
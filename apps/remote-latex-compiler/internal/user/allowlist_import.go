@@ -0,0 +1,237 @@
+package user
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// validTiers are the tiers allowlist entries and coupons may grant
+var validTiers = map[string]bool{"free": true, "pro": true, "enterprise": true}
+
+// allowlistColumns is the column order of allowlist CSV files
+var allowlistColumns = []string{"email", "tier", "reason", "expires_at"}
+
+// AllowlistImportError reports a row of an import that was skipped
+type AllowlistImportError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+// NormalizeAllowlistEmail validates an allowlist email and lowercases it.
+// A domain wildcard such as *@university.edu admits every address of the
+// domain.
+func NormalizeAllowlistEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if domain, ok := strings.CutPrefix(email, "*@"); ok {
+		if domain == "" || strings.ContainsAny(domain, "@* ") || !strings.Contains(domain, ".") {
+			return "", fmt.Errorf("invalid domain wildcard %q", email)
+		}
+		return email, nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", fmt.Errorf("invalid email %q", email)
+	}
+	return email, nil
+}
+
+// domainWildcard returns the wildcard entry matching every address of the
+// email's domain
+func domainWildcard(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	return "*@" + domain
+}
+
+// ParseAllowlistCSV reads allowlist entries from CSV with the columns email,
+// tier, reason and expires_at. A header row may name them in another order.
+// Invalid rows are returned as errors and left out of the entries.
+func ParseAllowlistCSV(r io.Reader) ([]*AllowlistEntry, []AllowlistImportError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := allowlistColumns
+	first := 1
+	if len(records) > 0 && slices.ContainsFunc(records[0], func(name string) bool {
+		return strings.EqualFold(strings.TrimSpace(name), "email")
+	}) {
+		columns = make([]string, len(records[0]))
+		for i, name := range records[0] {
+			columns[i] = strings.ToLower(strings.TrimSpace(name))
+		}
+		records = records[1:]
+		first = 2
+	}
+
+	var entries []*AllowlistEntry
+	var skipped []AllowlistImportError
+	for i, record := range records {
+		fields := make(map[string]string, len(columns))
+		for j, value := range record {
+			if j < len(columns) {
+				fields[columns[j]] = strings.TrimSpace(value)
+			}
+		}
+		entry := &AllowlistEntry{Email: fields["email"], Tier: fields["tier"], Reason: fields["reason"]}
+		if s := fields["expires_at"]; s != "" {
+			at, err := parseAllowlistTime(s)
+			if err != nil {
+				skipped = append(skipped, AllowlistImportError{Row: first + i, Email: entry.Email, Error: err.Error()})
+				continue
+			}
+			entry.ExpiresAt = &at
+		}
+		if err := validateAllowlistEntry(entry); err != nil {
+			skipped = append(skipped, AllowlistImportError{Row: first + i, Email: entry.Email, Error: err.Error()})
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, skipped, nil
+}
+
+// ParseAllowlistJSON reads allowlist entries from a JSON array of objects
+// with the fields email, tier, reason and expires_at. Invalid entries are
+// returned as errors, numbered from 1, and left out.
+func ParseAllowlistJSON(r io.Reader) ([]*AllowlistEntry, []AllowlistImportError, error) {
+	var rows []*AllowlistEntry
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var entries []*AllowlistEntry
+	var skipped []AllowlistImportError
+	for i, entry := range rows {
+		if entry == nil {
+			continue
+		}
+		if err := validateAllowlistEntry(entry); err != nil {
+			skipped = append(skipped, AllowlistImportError{Row: i + 1, Email: entry.Email, Error: err.Error()})
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, skipped, nil
+}
+
+// parseAllowlistTime accepts RFC 3339 times and plain dates
+func parseAllowlistTime(s string) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, s); err == nil {
+		return at, nil
+	}
+	at, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires_at %q: use RFC 3339 or YYYY-MM-DD", s)
+	}
+	return at, nil
+}
+
+// validateAllowlistEntry normalizes the email and checks the tier of an
+// imported entry
+func validateAllowlistEntry(entry *AllowlistEntry) error {
+	email, err := NormalizeAllowlistEmail(entry.Email)
+	if err != nil {
+		return err
+	}
+	entry.Email = email
+	entry.Tier = strings.ToLower(entry.Tier)
+	if entry.Tier != "" && !validTiers[entry.Tier] {
+		return fmt.Errorf("invalid tier %q", entry.Tier)
+	}
+	return nil
+}
+
+// Import adds or reactivates entries in one transaction, overwriting the
+// tier, reason and expiry of emails already listed. It reports how many
+// entries were created and how many updated.
+func (s *AllowlistStore) Import(entries []*AllowlistEntry, createdBy string) (created, updated int, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Tier == "" {
+			entry.Tier = "pro"
+		}
+		entry.IsActive = true
+		entry.CreatedBy = createdBy
+
+		var inserted bool
+		err := tx.QueryRow(`
+			INSERT INTO allowlist (id, email, tier, reason, expires_at, is_active, created_at, created_by)
+			VALUES ($1, $2, $3, $4, $5, true, $6, $7)
+			ON CONFLICT (email) DO UPDATE
+			SET tier = $3, reason = $4, expires_at = $5, is_active = true, created_by = $7
+			RETURNING id, created_at, xmax = 0`,
+			uuid.New().String(), entry.Email, entry.Tier, entry.Reason, entry.ExpiresAt, now, createdBy,
+		).Scan(&entry.ID, &entry.CreatedAt, &inserted)
+		if err != nil {
+			return 0, 0, fmt.Errorf("import of %s failed: %w", entry.Email, err)
+		}
+		if inserted {
+			created++
+		} else {
+			updated++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("commit failed: %w", err)
+	}
+	return created, updated, nil
+}
+
+// Accept records that a user signed in through an entry. Users are counted
+// once per entry.
+func (s *AllowlistStore) Accept(entryID, userID string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO allowlist_acceptances (entry_id, user_id, accepted_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (entry_id, user_id) DO NOTHING`, entryID, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("insert failed: %w", err)
+	}
+	return nil
+}
+
+// WriteAllowlistCSV writes entries in the format ParseAllowlistCSV reads,
+// followed by their acceptance columns
+func WriteAllowlistCSV(w io.Writer, entries []*AllowlistEntry) error {
+	writer := csv.NewWriter(w)
+	header := append(append([]string{}, allowlistColumns...), "accepted", "last_accepted_at")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		record := []string{entry.Email, entry.Tier, entry.Reason, formatAllowlistTime(entry.ExpiresAt),
+			fmt.Sprint(entry.Accepted), formatAllowlistTime(entry.LastAcceptedAt)}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatAllowlistTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,79 @@
+package user
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Billing statuses of an account. A failed payment makes it past due, with
+// full access for a grace period; when the grace period ends or the payment
+// retries run out it is suspended and read-only until a payment succeeds.
+const (
+	BillingActive    = "active"
+	BillingPastDue   = "past_due"
+	BillingSuspended = "suspended"
+)
+
+// BillingStatus returns the billing status of the user and, unless it is
+// active, when the first unpaid payment failed
+func (s *Store) BillingStatus(id string) (string, *time.Time, error) {
+	var status sql.NullString
+	var since sql.NullTime
+	err := s.db.QueryRow(`SELECT billing_status, past_due_since FROM users WHERE id = $1`, id).Scan(&status, &since)
+	if err == sql.ErrNoRows {
+		return "", nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("query failed: %w", err)
+	}
+	if !status.Valid || status.String == "" {
+		return BillingActive, nil, nil
+	}
+	if !since.Valid {
+		return status.String, nil, nil
+	}
+	return status.String, &since.Time, nil
+}
+
+// SetBillingStatus moves the user from one billing status to another and
+// reports whether it did, so a retried webhook or two instances sweeping at
+// once change the status only once. since is when the first unpaid payment
+// failed; it is cleared when the status becomes active.
+func (s *Store) SetBillingStatus(id, from, to string, since *time.Time) (bool, error) {
+	if id == "" {
+		return false, fmt.Errorf("id required")
+	}
+	if to == BillingActive {
+		since = nil
+	}
+	result, err := s.db.Exec(`
+		UPDATE users SET billing_status = $1, past_due_since = $2, updated_at = $3
+		WHERE id = $4 AND billing_status = $5`,
+		to, since, time.Now(), id, from)
+	if err != nil {
+		return false, fmt.Errorf("update failed: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// PastDueSince lists the accounts past due since before the given time
+func (s *Store) PastDueSince(before time.Time) ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM users WHERE billing_status = $1 AND past_due_since < $2`,
+		BillingPastDue, before)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,309 @@
+package user
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxCampaignCodes caps the codes generated for one campaign
+	MaxCampaignCodes = 10000
+	// codeAlphabet fills the # of code patterns, without look-alikes such as
+	// 0/O and 1/I
+	codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	// minCodeRandom is the fewest # a pattern generating several codes needs
+	minCodeRandom = 4
+)
+
+// CouponCampaign generates a batch of coupons from a code pattern and caps
+// their redemptions together
+type CouponCampaign struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	CodePattern    string     `json:"code_pattern"` // # stands for a random character
+	Type           CouponType `json:"type"`
+	PlanID         string     `json:"plan_id,omitempty"`
+	DiscountPct    int        `json:"discount_percent,omitempty"`
+	TrialDays      int        `json:"trial_days,omitempty"`
+	TierUpgrade    string     `json:"tier_upgrade,omitempty"`
+	Codes          int        `json:"codes"`             // coupons generated
+	MaxUsesPerCode int        `json:"max_uses_per_code"` // 0 for no limit
+	MaxRedemptions int        `json:"max_redemptions"`   // across all codes; 0 for no limit
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	CreatedBy      string     `json:"created_by"`
+
+	Stats *CampaignStats `json:"stats,omitempty"`
+}
+
+// CampaignStats counts the redemptions of a campaign's coupons
+type CampaignStats struct {
+	Redemptions    int        `json:"redemptions"`
+	CodesRedeemed  int        `json:"codes_redeemed"`
+	Users          int        `json:"users"`               // distinct users who redeemed a code
+	Remaining      *int       `json:"remaining,omitempty"` // redemptions left under MaxRedemptions
+	LastRedeemedAt *time.Time `json:"last_redeemed_at,omitempty"`
+}
+
+// Validate checks a campaign before its codes are generated
+func (c *CouponCampaign) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("name required")
+	}
+	c.CodePattern = strings.ToUpper(strings.TrimSpace(c.CodePattern))
+	if c.CodePattern == "" {
+		return fmt.Errorf("code_pattern required")
+	}
+	for _, r := range c.CodePattern {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '#') {
+			return fmt.Errorf("code_pattern may only contain letters, digits, - and _ and # for random characters")
+		}
+	}
+	if c.Codes == 0 {
+		c.Codes = 1
+	}
+	if c.Codes < 0 || c.Codes > MaxCampaignCodes {
+		return fmt.Errorf("codes must be between 1 and %d", MaxCampaignCodes)
+	}
+	if random := strings.Count(c.CodePattern, "#"); c.Codes > 1 && random < minCodeRandom {
+		return fmt.Errorf("code_pattern needs at least %d # to generate %d codes", minCodeRandom, c.Codes)
+	}
+	if c.MaxUsesPerCode < 0 || c.MaxRedemptions < 0 {
+		return fmt.Errorf("usage limits must not be negative")
+	}
+	if c.ExpiresAt != nil && c.ExpiresAt.Before(time.Now()) {
+		return fmt.Errorf("expires_at is in the past")
+	}
+
+	switch c.Type {
+	case CouponTypeDiscount:
+		if c.PlanID == "" {
+			return fmt.Errorf("discount campaigns need a plan_id")
+		}
+		if c.DiscountPct <= 0 || c.DiscountPct > 100 {
+			return fmt.Errorf("discount_percent must be between 1 and 100")
+		}
+	case CouponTypeTrial, CouponTypeUpgrade:
+		if !validTiers[c.TierUpgrade] || c.TierUpgrade == "free" {
+			return fmt.Errorf("tier_upgrade must be pro or enterprise")
+		}
+		if c.Type == CouponTypeTrial && c.TrialDays <= 0 {
+			return fmt.Errorf("trial campaigns need trial_days")
+		}
+	default:
+		return fmt.Errorf("type must be discount, trial or upgrade")
+	}
+	return nil
+}
+
+// generateCode fills the # of a pattern with random characters
+func generateCode(pattern string) (string, error) {
+	var b strings.Builder
+	limit := big.NewInt(int64(len(codeAlphabet)))
+	for _, r := range pattern {
+		if r != '#' {
+			b.WriteRune(r)
+			continue
+		}
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// CreateCampaign stores a validated campaign and generates its coupons in
+// one transaction. Campaign coupons are redeemed once per user. It returns
+// the generated codes.
+func (s *CouponStore) CreateCampaign(c *CouponCampaign) ([]string, error) {
+	c.ID = uuid.New().String()
+	c.CreatedAt = time.Now()
+	c.IsActive = true
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO coupon_campaigns (id, name, code_pattern, type, plan_id, discount_percent, trial_days,
+		                              tier_upgrade, codes, max_uses_per_code, max_redemptions, expires_at,
+		                              is_active, created_at, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		c.ID, c.Name, c.CodePattern, c.Type, c.PlanID, c.DiscountPct, c.TrialDays,
+		c.TierUpgrade, c.Codes, c.MaxUsesPerCode, c.MaxRedemptions, c.ExpiresAt,
+		c.IsActive, c.CreatedAt, c.CreatedBy)
+	if err != nil {
+		return nil, fmt.Errorf("insert failed: %w", err)
+	}
+
+	codes := make([]string, 0, c.Codes)
+	// A few collisions with earlier codes are retried; many mean the pattern
+	// has too few random characters left
+	for attempts := 0; len(codes) < c.Codes; attempts++ {
+		if attempts >= 2*c.Codes+10 {
+			return nil, fmt.Errorf("could not generate %d unique codes from %s", c.Codes, c.CodePattern)
+		}
+		code, err := generateCode(c.CodePattern)
+		if err != nil {
+			return nil, err
+		}
+		result, err := tx.Exec(`
+			INSERT INTO coupons (id, code, type, plan_id, plan_name, max_uses, used_count, expires_at,
+			                     discount_percent, trial_days, tier_upgrade, is_active, one_time_use, created_at, campaign_id)
+			VALUES ($1, $2, $3, $4, $5, $6, 0, $7, $8, $9, $10, true, true, $11, $12)
+			ON CONFLICT (code) DO NOTHING`,
+			uuid.New().String(), code, c.Type, c.PlanID, c.Name, c.MaxUsesPerCode, c.ExpiresAt,
+			c.DiscountPct, c.TrialDays, c.TierUpgrade, c.CreatedAt, c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("insert failed: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			codes = append(codes, code)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit failed: %w", err)
+	}
+	return codes, nil
+}
+
+// campaignColumns are the columns scanned by scanCampaign, with the stats
+// of the campaign's coupons aliased c
+const campaignColumns = `c.id, c.name, c.code_pattern, c.type, c.plan_id, c.discount_percent, c.trial_days,
+		c.tier_upgrade, c.codes, c.max_uses_per_code, c.max_redemptions, c.expires_at, c.is_active,
+		c.created_at, c.created_by,
+		(SELECT COALESCE(SUM(used_count), 0) FROM coupons WHERE campaign_id = c.id),
+		(SELECT COUNT(*) FROM coupons WHERE campaign_id = c.id AND used_count > 0),
+		(SELECT COUNT(DISTINCT r.user_id) FROM coupon_redemptions r JOIN coupons cp ON cp.id = r.coupon_id WHERE cp.campaign_id = c.id),
+		(SELECT MAX(r.redeemed_at) FROM coupon_redemptions r JOIN coupons cp ON cp.id = r.coupon_id WHERE cp.campaign_id = c.id)`
+
+func scanCampaign(row interface{ Scan(...any) error }) (*CouponCampaign, error) {
+	c := &CouponCampaign{Stats: &CampaignStats{}}
+	var createdBy sql.NullString
+	err := row.Scan(&c.ID, &c.Name, &c.CodePattern, &c.Type, &c.PlanID, &c.DiscountPct, &c.TrialDays,
+		&c.TierUpgrade, &c.Codes, &c.MaxUsesPerCode, &c.MaxRedemptions, &c.ExpiresAt, &c.IsActive,
+		&c.CreatedAt, &createdBy,
+		&c.Stats.Redemptions, &c.Stats.CodesRedeemed, &c.Stats.Users, &c.Stats.LastRedeemedAt)
+	if err != nil {
+		return nil, err
+	}
+	c.CreatedBy = createdBy.String
+	if c.MaxRedemptions > 0 {
+		remaining := max(c.MaxRedemptions-c.Stats.Redemptions, 0)
+		c.Stats.Remaining = &remaining
+	}
+	return c, nil
+}
+
+// GetCampaign returns a campaign with its redemption stats
+func (s *CouponStore) GetCampaign(id string) (*CouponCampaign, error) {
+	c, err := scanCampaign(s.db.QueryRow(`SELECT `+campaignColumns+` FROM coupon_campaigns c WHERE c.id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("campaign not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return c, nil
+}
+
+// ListCampaigns returns every campaign with its redemption stats, newest
+// first
+func (s *CouponStore) ListCampaigns() ([]*CouponCampaign, error) {
+	rows, err := s.db.Query(`SELECT ` + campaignColumns + ` FROM coupon_campaigns c ORDER BY c.created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var campaigns []*CouponCampaign
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, rows.Err()
+}
+
+// ListCampaignCoupons returns the coupons generated for a campaign
+func (s *CouponStore) ListCampaignCoupons(id string) ([]*Coupon, error) {
+	rows, err := s.db.Query(`
+		SELECT `+couponColumns+`
+		FROM coupons WHERE campaign_id = $1
+		ORDER BY code`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var coupons []*Coupon
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		coupons = append(coupons, coupon)
+	}
+	return coupons, rows.Err()
+}
+
+// DisableCampaign deactivates a campaign and all of its coupons. Redemptions
+// already made are kept.
+func (s *CouponStore) DisableCampaign(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE coupon_campaigns SET is_active = false WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("campaign not found")
+	}
+	if _, err := tx.Exec(`UPDATE coupons SET is_active = false WHERE campaign_id = $1`, id); err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	return tx.Commit()
+}
+
+// CheckCampaign checks that the campaign of a coupon, if any, is active and
+// has redemptions left. It is advisory; IncrementUsage claims the
+// redemption.
+func (s *CouponStore) CheckCampaign(coupon *Coupon) error {
+	if coupon.CampaignID == "" {
+		return nil
+	}
+
+	var active bool
+	var maxRedemptions, redemptions int
+	err := s.db.QueryRow(`
+		SELECT is_active, max_redemptions, redemptions
+		FROM coupon_campaigns WHERE id = $1`, coupon.CampaignID).Scan(&active, &maxRedemptions, &redemptions)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	if err == sql.ErrNoRows || !active {
+		return fmt.Errorf("coupon campaign has ended")
+	}
+	if maxRedemptions > 0 && redemptions >= maxRedemptions {
+		return fmt.Errorf("coupon campaign redemption limit reached")
+	}
+	return nil
+}
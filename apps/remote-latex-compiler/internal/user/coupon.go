@@ -31,6 +31,30 @@ type Coupon struct {
 	IsActive    bool       `json:"is_active"`
 	OneTimeUse  bool       `json:"one_time_use"`
 	CreatedAt   time.Time  `json:"created_at"`
+	CampaignID  string     `json:"campaign_id,omitempty"`
+}
+
+// couponColumns are the columns scanned by scanCoupon
+const couponColumns = `id, code, type, plan_id, plan_name, max_uses, used_count, expires_at,
+		       discount_percent, trial_days, tier_upgrade, is_active, one_time_use, created_at, campaign_id`
+
+// scanCoupon scans a row of couponColumns. Coupons without an expiry get a
+// zero ExpiresAt.
+func scanCoupon(row interface{ Scan(...any) error }) (*Coupon, error) {
+	var coupon Coupon
+	var expiresAt sql.NullTime
+	var campaignID sql.NullString
+	err := row.Scan(
+		&coupon.ID, &coupon.Code, &coupon.Type, &coupon.PlanID, &coupon.PlanName,
+		&coupon.MaxUses, &coupon.UsedCount, &expiresAt,
+		&coupon.DiscountPct, &coupon.TrialDays, &coupon.TierUpgrade,
+		&coupon.IsActive, &coupon.OneTimeUse, &coupon.CreatedAt, &campaignID)
+	if err != nil {
+		return nil, err
+	}
+	coupon.ExpiresAt = expiresAt.Time
+	coupon.CampaignID = campaignID.String
+	return &coupon, nil
 }
 
 type CouponStore struct {
@@ -49,15 +73,9 @@ func (s *CouponStore) GetByCode(code string) (*Coupon, error) {
 		return nil, fmt.Errorf("coupon code required")
 	}
 
-	var coupon Coupon
-	err := s.db.QueryRow(`
-		SELECT id, code, type, plan_id, plan_name, max_uses, used_count, expires_at, 
-		       discount_percent, trial_days, tier_upgrade, is_active, one_time_use, created_at
-		FROM coupons WHERE code = $1`, code).Scan(
-		&coupon.ID, &coupon.Code, &coupon.Type, &coupon.PlanID, &coupon.PlanName,
-		&coupon.MaxUses, &coupon.UsedCount, &coupon.ExpiresAt,
-		&coupon.DiscountPct, &coupon.TrialDays, &coupon.TierUpgrade,
-		&coupon.IsActive, &coupon.OneTimeUse, &coupon.CreatedAt)
+	coupon, err := scanCoupon(s.db.QueryRow(`
+		SELECT `+couponColumns+`
+		FROM coupons WHERE code = $1`, code))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -66,7 +84,7 @@ func (s *CouponStore) GetByCode(code string) (*Coupon, error) {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	return &coupon, nil
+	return coupon, nil
 }
 
 // IsValid validates a coupon
@@ -75,7 +93,7 @@ func (s *CouponStore) IsValid(coupon *Coupon) error {
 		return fmt.Errorf("coupon is inactive")
 	}
 
-	if time.Now().After(coupon.ExpiresAt) {
+	if !coupon.ExpiresAt.IsZero() && time.Now().After(coupon.ExpiresAt) {
 		return fmt.Errorf("coupon has expired")
 	}
 
@@ -83,7 +101,7 @@ func (s *CouponStore) IsValid(coupon *Coupon) error {
 		return fmt.Errorf("coupon usage limit exceeded")
 	}
 
-	return nil
+	return s.CheckCampaign(coupon)
 }
 
 // ValidateForPlan checks if coupon is valid for a specific plan
@@ -100,26 +118,81 @@ func (s *CouponStore) ValidateForPlan(coupon *Coupon, planID string) error {
 	return nil
 }
 
+// IncrementUsage claims a use of a coupon, and a redemption of its campaign
+// if it has one, before what the coupon grants is handed out. Each count is
+// only raised while it is under its limit, in the statement that raises it,
+// so concurrent redemptions cannot claim more than the limits allow.
 func (s *CouponStore) IncrementUsage(couponID string) error {
 	if couponID == "" {
 		return fmt.Errorf("coupon id required")
 	}
 
-	result, err := s.db.Exec(
-		"UPDATE coupons SET used_count = used_count + 1 WHERE id = $1",
-		couponID)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	var campaignID string
+	err = tx.QueryRow(`
+		UPDATE coupons SET used_count = used_count + 1
+		WHERE id = $1 AND (max_uses <= 0 OR used_count < max_uses)
+		RETURNING COALESCE(campaign_id::text, '')`, couponID).Scan(&campaignID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("coupon usage limit exceeded")
+	}
 	if err != nil {
 		return fmt.Errorf("update failed: %w", err)
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return fmt.Errorf("coupon not found")
+	if campaignID != "" {
+		var redemptions int
+		err = tx.QueryRow(`
+			UPDATE coupon_campaigns SET redemptions = redemptions + 1
+			WHERE id = $1 AND is_active AND (max_redemptions <= 0 OR redemptions < max_redemptions)
+			RETURNING redemptions`, campaignID).Scan(&redemptions)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("coupon campaign redemption limit reached")
+		}
+		if err != nil {
+			return fmt.Errorf("update failed: %w", err)
+		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
 	return nil
 }
 
+// ReleaseUsage gives back a use claimed with IncrementUsage when what the
+// coupon grants could not be handed out
+func (s *CouponStore) ReleaseUsage(couponID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	var campaignID string
+	err = tx.QueryRow(`
+		UPDATE coupons SET used_count = GREATEST(used_count - 1, 0)
+		WHERE id = $1
+		RETURNING COALESCE(campaign_id::text, '')`, couponID).Scan(&campaignID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("coupon not found")
+	}
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	if campaignID != "" {
+		if _, err := tx.Exec(`UPDATE coupon_campaigns SET redemptions = GREATEST(redemptions - 1, 0) WHERE id = $1`, campaignID); err != nil {
+			return fmt.Errorf("update failed: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 // Create creates a new coupon (admin function)
 func (s *CouponStore) Create(coupon *Coupon) error {
 	if coupon.Code == "" {
@@ -149,8 +222,7 @@ func (s *CouponStore) Create(coupon *Coupon) error {
 // GetByType retrieves all coupons of a specific type
 func (s *CouponStore) GetByType(couponType CouponType) ([]*Coupon, error) {
 	query := `
-		SELECT id, code, type, plan_id, plan_name, max_uses, used_count, expires_at, 
-		       discount_percent, trial_days, tier_upgrade, is_active, one_time_use, created_at
+		SELECT ` + couponColumns + `
 		FROM coupons WHERE type = $1 AND is_active = true
 		ORDER BY created_at DESC
 	`
@@ -163,12 +235,7 @@ func (s *CouponStore) GetByType(couponType CouponType) ([]*Coupon, error) {
 
 	var coupons []*Coupon
 	for rows.Next() {
-		coupon := &Coupon{}
-		err := rows.Scan(
-			&coupon.ID, &coupon.Code, &coupon.Type, &coupon.PlanID, &coupon.PlanName,
-			&coupon.MaxUses, &coupon.UsedCount, &coupon.ExpiresAt,
-			&coupon.DiscountPct, &coupon.TrialDays, &coupon.TierUpgrade,
-			&coupon.IsActive, &coupon.OneTimeUse, &coupon.CreatedAt)
+		coupon, err := scanCoupon(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scan failed: %w", err)
 		}
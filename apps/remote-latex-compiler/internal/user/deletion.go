@@ -0,0 +1,152 @@
+package user
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ScheduleDeletion marks an account for deletion at the given time. Until
+// then the user may cancel it.
+func (s *Store) ScheduleDeletion(id string, at time.Time) error {
+	if id == "" {
+		return fmt.Errorf("id required")
+	}
+	result, err := s.db.Exec(`UPDATE users SET deletion_scheduled_at = $1, updated_at = $2 WHERE id = $3`,
+		at, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// CancelDeletion clears a scheduled deletion and the regions that already
+// purged the account's files. It reports whether one was scheduled.
+func (s *Store) CancelDeletion(id string) (bool, error) {
+	if id == "" {
+		return false, fmt.Errorf("id required")
+	}
+	result, err := s.db.Exec(`
+		UPDATE users SET deletion_scheduled_at = NULL, updated_at = $1
+		WHERE id = $2 AND deletion_scheduled_at IS NOT NULL`, time.Now(), id)
+	if err != nil {
+		return false, fmt.Errorf("update failed: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return false, nil
+	}
+	if _, err := s.db.Exec(`DELETE FROM account_purges WHERE user_id = $1`, id); err != nil {
+		return true, fmt.Errorf("clearing purges failed: %w", err)
+	}
+	return true, nil
+}
+
+// DeletionScheduledAt returns when the account is deleted, or nil if no
+// deletion is scheduled
+func (s *Store) DeletionScheduledAt(id string) (*time.Time, error) {
+	var at sql.NullTime
+	err := s.db.QueryRow(`SELECT deletion_scheduled_at FROM users WHERE id = $1`, id).Scan(&at)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	if !at.Valid {
+		return nil, nil
+	}
+	return &at.Time, nil
+}
+
+// DueForDeletion lists the accounts whose deletion is scheduled before now
+// and whose files the region has not purged yet. An empty region lists
+// every account due.
+func (s *Store) DueForDeletion(now time.Time, region string) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT id FROM users
+		WHERE deletion_scheduled_at <= $1
+		AND NOT EXISTS (
+			SELECT 1 FROM account_purges
+			WHERE account_purges.user_id = users.id AND account_purges.region = $2
+		)`, now, region)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RecordPurge records that the region removed the files of an account due
+// for deletion
+func (s *Store) RecordPurge(id, region string) error {
+	if id == "" || region == "" {
+		return fmt.Errorf("id and region required")
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO account_purges (user_id, region) VALUES ($1, $2)
+		ON CONFLICT (user_id, region) DO NOTHING`, id, region)
+	if err != nil {
+		return fmt.Errorf("insert failed: %w", err)
+	}
+	return nil
+}
+
+// PurgedRegions lists the regions that removed the account's files
+func (s *Store) PurgedRegions(id string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT region FROM account_purges WHERE user_id = $1`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var regions []string
+	for rows.Next() {
+		var region string
+		if err := rows.Scan(&region); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		regions = append(regions, region)
+	}
+	return regions, rows.Err()
+}
+
+// Purge deletes an account and, through cascades, every row that belongs to
+// it: builds, delta-sync projects, pairing tokens, trials, redemptions,
+// invoices and preferences. Audit entries are kept without the user, IP
+// address or user agent. The caller removes the account's files first.
+func (s *Store) Purge(id string) error {
+	if id == "" {
+		return fmt.Errorf("id required")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE audit_logs SET ip_address = NULL, user_agent = NULL WHERE user_id = $1`, id); err != nil {
+		return fmt.Errorf("anonymizing audit logs failed: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+	// Left behind, the sign-in identity would come back to a new, empty account
+	if _, err := tx.Exec(`DELETE FROM auth.users WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("deleting auth user failed: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,156 @@
+package user
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Subscription states a user list can be filtered by
+const (
+	SubscriptionActive   = "active"   // subscribed and billing
+	SubscriptionPaused   = "paused"   // subscribed, billing paused
+	SubscriptionCanceled = "canceled" // subscription canceled
+	SubscriptionNone     = "none"     // never subscribed
+)
+
+// ListSorts maps the sort keys of a user list to the column they order by
+var ListSorts = map[string]string{
+	"created":     "u.created_at",
+	"email":       "u.email",
+	"last_active": "last_active_at",
+	"builds":      "build_count",
+	"storage":     "build_storage_bytes",
+}
+
+// ListFilter selects, orders and pages the users of the admin list. Zero
+// fields do not filter.
+type ListFilter struct {
+	Search        string     // case-insensitive part of the email
+	Tier          string     // free, pro or enterprise
+	Subscription  string     // one of the Subscription states
+	BillingStatus string     // one of the Billing statuses
+	ActiveAfter   *time.Time // last active at or after
+	ActiveBefore  *time.Time // last active before
+	Sort          string     // a key of ListSorts, "created" if empty
+	Ascending     bool
+	Page          int // from 1
+	PageSize      int
+}
+
+// Summary is a user with the aggregates the admin list shows. A user is
+// last active at their latest build, or at sign-up if they never built.
+type Summary struct {
+	*User
+	BillingStatus     string    `json:"billing_status"`
+	BuildCount        int       `json:"build_count"`
+	BuildStorageBytes int64     `json:"build_storage_bytes"`
+	LastActiveAt      time.Time `json:"last_active_at"`
+}
+
+// listFrom joins each user to the aggregates of their builds. Deleted
+// builds count towards activity but not towards builds or storage.
+const listFrom = `
+	FROM (
+		SELECT u.*,
+		       COALESCE(b.build_count, 0) AS build_count,
+		       COALESCE(b.build_storage_bytes, 0) AS build_storage_bytes,
+		       COALESCE(b.last_build_at, u.created_at) AS last_active_at
+		FROM users u
+		LEFT JOIN (
+			SELECT user_id,
+			       COUNT(*) FILTER (WHERE deleted_at IS NULL) AS build_count,
+			       SUM(storage_bytes) FILTER (WHERE deleted_at IS NULL) AS build_storage_bytes,
+			       MAX(created_at) AS last_build_at
+			FROM builds
+			GROUP BY user_id
+		) b ON b.user_id = u.id
+	) u
+	WHERE ($1 = '' OR u.email ILIKE '%' || $1 || '%' ESCAPE '\')
+	  AND ($2 = '' OR u.tier = $2)
+	  AND ($3 = '' OR u.billing_status = $3)
+	  AND ($4 = ''
+	       OR ($4 = 'active' AND u.razorpay_subscription_id IS NOT NULL AND u.subscription_canceled_at IS NULL AND NOT COALESCE(u.subscription_paused, FALSE))
+	       OR ($4 = 'paused' AND u.razorpay_subscription_id IS NOT NULL AND u.subscription_canceled_at IS NULL AND COALESCE(u.subscription_paused, FALSE))
+	       OR ($4 = 'canceled' AND u.subscription_canceled_at IS NOT NULL)
+	       OR ($4 = 'none' AND u.razorpay_subscription_id IS NULL AND u.subscription_canceled_at IS NULL))
+	  AND ($5::timestamptz IS NULL OR u.last_active_at >= $5)
+	  AND ($6::timestamptz IS NULL OR u.last_active_at < $6)`
+
+// List returns a page of the users matching filter with their aggregates,
+// and how many users match in all
+func (s *Store) List(filter ListFilter) ([]*Summary, int, error) {
+	column, ok := ListSorts[filter.Sort]
+	if filter.Sort == "" {
+		column, ok = ListSorts["created"], true
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+	direction := "DESC"
+	if filter.Ascending {
+		direction = "ASC"
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 {
+		filter.PageSize = 20
+	}
+
+	args := []interface{}{
+		escapeLike(filter.Search), filter.Tier, filter.BillingStatus, filter.Subscription,
+		filter.ActiveAfter, filter.ActiveBefore,
+	}
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*)`+listFrom, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count failed: %w", err)
+	}
+
+	// Ties are broken by ID so pages neither repeat nor skip users
+	query := `
+		SELECT u.id, u.email, u.name, u.is_admin, u.razorpay_customer_id, u.razorpay_subscription_id,
+		       u.tier, u.storage_used_bytes, u.subscription_canceled_at, u.subscription_paused,
+		       u.created_at, u.updated_at, u.billing_status,
+		       u.build_count, u.build_storage_bytes, u.last_active_at` + listFrom + `
+		ORDER BY ` + column + ` ` + direction + `, u.id
+		LIMIT $7 OFFSET $8`
+	rows, err := s.db.Query(query, append(args, filter.PageSize, (filter.Page-1)*filter.PageSize)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*Summary
+	for rows.Next() {
+		user := &User{}
+		summary := &Summary{User: user}
+		var name, razorpayCustomerID, razorpaySubscriptionID, billingStatus sql.NullString
+		var paused sql.NullBool
+		err := rows.Scan(&user.ID, &user.Email, &name, &user.IsAdmin,
+			&razorpayCustomerID, &razorpaySubscriptionID, &user.Tier,
+			&user.StorageUsedBytes, &user.SubscriptionCanceledAt, &paused,
+			&user.CreatedAt, &user.UpdatedAt, &billingStatus,
+			&summary.BuildCount, &summary.BuildStorageBytes, &summary.LastActiveAt)
+		if err != nil {
+			return nil, 0, fmt.Errorf("scan failed: %w", err)
+		}
+		user.Name = nullableString(name)
+		user.RazorpayCustomerID = nullableString(razorpayCustomerID)
+		user.RazorpaySubscriptionID = nullableString(razorpaySubscriptionID)
+		user.SubscriptionPaused = paused.Bool
+		summary.BillingStatus = nullableString(billingStatus)
+		if summary.BillingStatus == "" {
+			summary.BillingStatus = BillingActive
+		}
+		users = append(users, summary)
+	}
+	return users, total, rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern so s matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,134 @@
+package user
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverageBatch is the uninvoiced overage builds of a user in one month,
+// added to their next invoice together
+type OverageBatch struct {
+	UserID string
+	Period time.Time // first day of the month
+	IDs    []string
+	Amount int64 // in the smallest currency unit
+}
+
+// RecordOverage records a build beyond the monthly quota of period, charged
+// amount. Recording a build twice keeps the first record.
+func (s *Store) RecordOverage(userID, buildID string, period time.Time, amount int64) error {
+	if userID == "" || buildID == "" {
+		return fmt.Errorf("user and build required")
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO build_overages (user_id, build_id, period, amount)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (build_id) DO NOTHING`,
+		userID, buildID, period, amount)
+	if err != nil {
+		return fmt.Errorf("insert failed: %w", err)
+	}
+	return nil
+}
+
+// OverageTotal returns the number and total amount of the user's overage
+// builds in the month starting at period
+func (s *Store) OverageTotal(userID string, period time.Time) (int, int64, error) {
+	var count int
+	var amount int64
+	err := s.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM build_overages
+		WHERE user_id = $1 AND period = $2`,
+		userID, period).Scan(&count, &amount)
+	if err != nil {
+		return 0, 0, fmt.Errorf("query failed: %w", err)
+	}
+	return count, amount, nil
+}
+
+// UninvoicedOverages lists the overages not yet invoiced of months before
+// the one starting at before, grouped by user and month
+func (s *Store) UninvoicedOverages(before time.Time) ([]OverageBatch, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, period, amount FROM build_overages
+		WHERE invoiced_at IS NULL AND period < $1
+		ORDER BY period, user_id`,
+		before)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var batches []OverageBatch
+	for rows.Next() {
+		var id, userID string
+		var period time.Time
+		var amount int64
+		if err := rows.Scan(&id, &userID, &period, &amount); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		if n := len(batches); n == 0 || batches[n-1].UserID != userID || !batches[n-1].Period.Equal(period) {
+			batches = append(batches, OverageBatch{UserID: userID, Period: period})
+		}
+		b := &batches[len(batches)-1]
+		b.IDs = append(b.IDs, id)
+		b.Amount += amount
+	}
+	return batches, rows.Err()
+}
+
+// ClaimOverages marks overages invoiced before they are added to an
+// invoice, so two instances sweeping at once do not both charge them. It
+// returns the overages it claimed, those not claimed already, and their
+// total amount.
+func (s *Store) ClaimOverages(ids []string) ([]string, int64, error) {
+	if len(ids) == 0 {
+		return nil, 0, nil
+	}
+	rows, err := s.db.Query(`
+		UPDATE build_overages SET invoiced_at = $1
+		WHERE id = ANY($2) AND invoiced_at IS NULL
+		RETURNING id, amount`,
+		time.Now(), ids)
+	if err != nil {
+		return nil, 0, fmt.Errorf("update failed: %w", err)
+	}
+	defer rows.Close()
+
+	var claimed []string
+	var total int64
+	for rows.Next() {
+		var id string
+		var amount int64
+		if err := rows.Scan(&id, &amount); err != nil {
+			return nil, 0, fmt.Errorf("scan failed: %w", err)
+		}
+		claimed = append(claimed, id)
+		total += amount
+	}
+	return claimed, total, rows.Err()
+}
+
+// SetOveragesInvoiced records the billing provider's add-on that claimed
+// overages were added to the invoice as
+func (s *Store) SetOveragesInvoiced(ids []string, addonID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := s.db.Exec(`UPDATE build_overages SET addon_id = $1 WHERE id = ANY($2)`, addonID, ids); err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	return nil
+}
+
+// ReleaseOverages returns claimed overages that could not be invoiced to
+// the next sweep
+func (s *Store) ReleaseOverages(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := s.db.Exec(`UPDATE build_overages SET invoiced_at = NULL WHERE id = ANY($1) AND addon_id IS NULL`, ids); err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	return nil
+}
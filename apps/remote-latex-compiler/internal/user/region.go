@@ -0,0 +1,38 @@
+package user
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Region returns the data-residency region the user selected, or "" if they
+// have not selected one
+func (s *Store) Region(id string) (string, error) {
+	var region sql.NullString
+	err := s.db.QueryRow(`SELECT region FROM users WHERE id = $1`, id).Scan(&region)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("user not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("query failed: %w", err)
+	}
+	return region.String, nil
+}
+
+// SetRegion sets the region the user's builds and files are kept in. Builds
+// made before keep their region.
+func (s *Store) SetRegion(id, region string) error {
+	if id == "" {
+		return fmt.Errorf("id required")
+	}
+	result, err := s.db.Exec(`UPDATE users SET region = NULLIF($1, ''), updated_at = $2 WHERE id = $3`,
+		region, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("update failed: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// Engine is a LaTeX engine
+type Engine string
+
+const (
+	EnginePDFLaTeX Engine = "pdflatex"
+	EngineXeLaTeX  Engine = "xelatex"
+	EngineLuaLaTeX Engine = "lualatex"
+)
+
+// Build is a compile job and its outcome
+type Build struct {
+	ID              string            `json:"id"`
+	UserID          string            `json:"user_id,omitempty"`
+	ParentID        string            `json:"parent_id,omitempty"`
+	Status          Status            `json:"status"`
+	Engine          Engine            `json:"engine"`
+	MainFile        string            `json:"main_file"`
+	Target          string            `json:"target,omitempty"`  // target of .treefrog.json built; "" for none
+	Project         string            `json:"project,omitempty"` // SHA-256 of the .treefrog.json that declared Target
+	DirPath         string            `json:"dir_path,omitempty"`
+	PDFPath         string            `json:"pdf_path,omitempty"`
+	SyncTeXPath     string            `json:"synctex_path,omitempty"`
+	BuildLog        string            `json:"build_log,omitempty"`
+	ErrorMessage    string            `json:"error_message,omitempty"`
+	ShellEscape     bool              `json:"shell_escape"`
+	ShellRestricted bool              `json:"shell_restricted,omitempty"`
+	AutoShell       bool              `json:"auto_shell,omitempty"` // the restricted profile may be turned on for packages that need it
+	Env             map[string]string `json:"env,omitempty"`
+	OutDir          string            `json:"out_dir,omitempty"`
+	Reproducible    bool              `json:"reproducible,omitempty"`
+	ManifestHash    string            `json:"manifest_hash,omitempty"` // SHA-256 of the inputs of a reproducible build
+	ResultKey       string            `json:"result_key,omitempty"`    // hash of the inputs and options that determine the output
+	ImageDigest     string            `json:"image_digest,omitempty"`  // compiler image the build ran in
+	Network         bool              `json:"network,omitempty"`       // ran with network access
+	Sandbox         string            `json:"sandbox,omitempty"`       // gvisor or firecracker; "" for the compiler's default
+	Timeout         int               `json:"timeout,omitempty"`       // seconds latexmk may run; 0 for the compiler's default
+	Index           string            `json:"index,omitempty"`         // index processor: xindy, or "" for makeindex
+	PDFX            bool              `json:"pdfx,omitempty"`          // also convert the PDF to PDF/X-1a for print
+	FontCheck       *FontCheck        `json:"font_check,omitempty"`    // fonts of the PDF, checked once it is built
+	PDFXPath        string            `json:"pdfx_path,omitempty"`     // PDF/X-1a conversion of the PDF
+	PDFXError       string            `json:"pdfx_error,omitempty"`    // why the conversion failed; the build still completes
+	Split           bool              `json:"split,omitempty"`         // also split the PDF into a PDF per section
+	SplitParts      []SplitPart       `json:"split_parts,omitempty"`   // parts of the split PDF
+	SplitError      string            `json:"split_error,omitempty"`   // why the split failed; the build still completes
+	Encrypted       bool              `json:"encrypted,omitempty"`     // PDF, SyncTeX, logs and previews are encrypted at rest
+	Region          string            `json:"region,omitempty"`        // data-residency region the build ran and is stored in
+	NotifyEmail     bool              `json:"notify_email,omitempty"`
+	Pinned          bool              `json:"pinned,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	ExpiresAt       time.Time         `json:"expires_at,omitempty"`
+	LastAccessedAt  time.Time         `json:"last_accessed_at,omitempty"`
+	StorageBytes    int64             `json:"storage_bytes,omitempty"`
+	DeletedAt       *time.Time        `json:"deleted_at,omitempty"`
+}
+
+// BuildOptions are the user-selected settings of a build
+type BuildOptions struct {
+	MainFile        string            `json:"main_file"`
+	Engine          Engine            `json:"engine"`
+	ShellEscape     bool              `json:"shell_escape"`
+	ShellRestricted bool              `json:"shell_restricted,omitempty"`
+	AutoShell       bool              `json:"-"` // set from the limits, see buildopts.Options.ApplyAutoShell
+	Env             map[string]string `json:"env,omitempty"`
+	OutDir          string            `json:"out_dir,omitempty"`
+	Reproducible    bool              `json:"reproducible,omitempty"`
+	Network         bool              `json:"network,omitempty"`
+	Sandbox         string            `json:"sandbox,omitempty"`
+	Timeout         int               `json:"timeout,omitempty"` // seconds; 0 for the default
+	Index           string            `json:"index,omitempty"`   // makeindex or xindy; "" for makeindex
+	PDFX            bool              `json:"pdfx,omitempty"`    // also convert the PDF to PDF/X-1a for print
+	Split           bool              `json:"split,omitempty"`   // also split the PDF into a PDF per section
+	Target          string            `json:"target,omitempty"`  // target of .treefrog.json to build; overrides the main file, engine, output directory and index
+	Project         string            `json:"-"`                 // set from the project's .treefrog.json, see build.ProjectConfig.Key
+}
+
+// BuildResponse describes a build to clients
+type BuildResponse struct {
+	ID        string    `json:"id"`
+	Status    Status    `json:"status"`
+	Engine    Engine    `json:"engine"`
+	MainFile  string    `json:"main_file"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Pinned    bool      `json:"pinned,omitempty"`
+	Subbuilds []string  `json:"subbuilds,omitempty"`
+}
+
+// StatusResponse is returned by build status endpoints
+type StatusResponse struct {
+	ID             string         `json:"id"`
+	Status         Status         `json:"status"`
+	Message        string         `json:"message,omitempty"`
+	Engine         Engine         `json:"engine"`
+	Progress       int            `json:"progress,omitempty"`        // estimated percent done while compiling
+	Phase          string         `json:"phase,omitempty"`           // compile step, e.g. "compiling" or "bibliography"
+	CurrentFile    string         `json:"current_file,omitempty"`    // source file the engine is reading
+	Pages          int            `json:"pages,omitempty"`           // pages typeset so far in the current run
+	QueuedPosition int            `json:"queued_position,omitempty"` // place in line of a pending build, from 1
+	Warnings       []BuildWarning `json:"warnings,omitempty"`
+	ManifestHash   string         `json:"manifest_hash,omitempty"`
+	ImageDigest    string         `json:"image_digest,omitempty"`
+	CreatedAt      time.Time      `json:"created_at"`
+	CompletedAt    *time.Time     `json:"completed_at,omitempty"`
+}
+
+// Build warning codes
+const (
+	WarningNeedsRerun           = "needs_rerun"           // cross-references had not settled when latexmk stopped
+	WarningUnresolvedReferences = "unresolved_references" // references or citations are still undefined
+)
+
+// BuildWarning flags a finished build whose PDF is likely incomplete even
+// though compilation succeeded
+type BuildWarning struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Keys    []string `json:"keys,omitempty"` // labels or citation keys concerned
+}
+
+// Font is a font used in a PDF, as pdffonts lists it
+type Font struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // Type 1, TrueType, Type 3, ...
+	Embedded bool   `json:"embedded"`
+	Subset   bool   `json:"subset"`
+}
+
+// FontCheck is the result of checking that a PDF embeds all its fonts, as
+// printers and most submission systems require
+type FontCheck struct {
+	OK         bool     `json:"ok"` // every font embedded
+	Fonts      []Font   `json:"fonts"`
+	Unembedded []string `json:"unembedded,omitempty"`
+	Type3      []string `json:"type3,omitempty"` // bitmap fonts, which print poorly
+}
+
+// SplitPart is a range of pages of a split PDF, starting at one or more
+// headings of the same level
+type SplitPart struct {
+	Index     int      `json:"index"` // from 1
+	Title     string   `json:"title"`
+	Level     string   `json:"level,omitempty"` // part, chapter or section; "" for front matter
+	Headings  []string `json:"headings,omitempty"`
+	FirstPage int      `json:"first_page"`
+	LastPage  int      `json:"last_page"`
+}
+
+// BuildListResponse is a page of a user's builds
+type BuildListResponse struct {
+	Builds     []BuildResponse `json:"builds"`
+	Total      int             `json:"total"`
+	Page       int             `json:"page"`
+	PageSize   int             `json:"page_size"`
+	TotalPages int             `json:"total_pages"`
+}
+
+// Validate checks the build's main file, engine, environment and output
+// directory
+func (b *Build) Validate() error {
+	return buildopts.Options{
+		MainFile:        b.MainFile,
+		Engine:          string(b.Engine),
+		ShellEscape:     b.ShellEscape,
+		ShellRestricted: b.ShellRestricted,
+		Env:             b.Env,
+		OutDir:          b.OutDir,
+	}.Validate(buildopts.Limits{AllowShellEscape: true})
+}
+
+// NewBuildResponse describes b for clients
+func NewBuildResponse(b *Build) BuildResponse {
+	return BuildResponse{
+		ID:        b.ID,
+		Status:    b.Status,
+		Engine:    b.Engine,
+		MainFile:  b.MainFile,
+		CreatedAt: b.CreatedAt,
+		ExpiresAt: b.ExpiresAt,
+		Pinned:    b.Pinned,
+	}
+}
+
+// NewStatusResponse reports the status of b. Failed builds carry their error
+// message and completed builds their completion time.
+func NewStatusResponse(b *Build) StatusResponse {
+	resp := StatusResponse{
+		ID:           b.ID,
+		Status:       b.Status,
+		Message:      b.ErrorMessage,
+		Engine:       b.Engine,
+		ManifestHash: b.ManifestHash,
+		ImageDigest:  b.ImageDigest,
+		CreatedAt:    b.CreatedAt,
+	}
+	if b.Status == StatusCompleted {
+		completedAt := b.UpdatedAt
+		resp.Progress = 100
+		resp.CompletedAt = &completedAt
+	}
+	return resp
+}
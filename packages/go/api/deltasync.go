@@ -0,0 +1,70 @@
+package api
+
+import (
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// DeltaSyncBuildOptions are the build options a delta sync sends with both
+// its init and its upload
+type DeltaSyncBuildOptions struct {
+	MainFile        string            `json:"mainFile"`
+	Engine          string            `json:"engine"`
+	ShellEscape     bool              `json:"shellEscape"`
+	ShellRestricted bool              `json:"shellRestricted,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	OutDir          string            `json:"outDir,omitempty"`
+	Reproducible    bool              `json:"reproducible,omitempty"`
+	Sandbox         string            `json:"sandbox,omitempty"`
+	Timeout         int               `json:"timeout,omitempty"` // seconds; 0 for the default
+	Index           string            `json:"index,omitempty"`   // makeindex or xindy; "" for makeindex
+}
+
+// Options returns the options as buildopts.Options
+func (o DeltaSyncBuildOptions) Options() buildopts.Options {
+	return buildopts.Options{
+		MainFile:        o.MainFile,
+		Engine:          o.Engine,
+		ShellEscape:     o.ShellEscape,
+		ShellRestricted: o.ShellRestricted,
+		Env:             o.Env,
+		OutDir:          o.OutDir,
+		Reproducible:    o.Reproducible,
+		Sandbox:         o.Sandbox,
+		Timeout:         time.Duration(o.Timeout) * time.Second,
+		Index:           o.Index,
+	}
+}
+
+// DeltaSyncInitRequest initializes delta-sync for a build
+type DeltaSyncInitRequest struct {
+	ProjectID   string `json:"projectId"`
+	ProjectName string `json:"projectName"`
+	DeltaSyncBuildOptions
+	FileChecksums map[string]string `json:"fileChecksums"` // path -> checksum
+}
+
+// DeltaSyncInitResponse returns existing cached files
+type DeltaSyncInitResponse struct {
+	BuildID       string                            `json:"buildId"`
+	ExistingFiles map[string]map[string]interface{} `json:"existingFiles"` // path -> {checksum, size}
+	FilesToUpload []string                          `json:"filesToUpload"` // files that need to be uploaded
+}
+
+// DeltaSyncUploadRequest contains metadata for uploaded files
+type DeltaSyncUploadRequest struct {
+	ProjectID   string            `json:"projectId"`
+	CachedFiles map[string]string `json:"cachedFiles"` // path -> checksum of cached files to reuse
+	DeltaSyncBuildOptions
+	NewChecksums map[string]string `json:"newChecksums"` // checksums for newly uploaded files
+}
+
+// DeltaSyncUploadResponse reports what the upload contributed to the build
+type DeltaSyncUploadResponse struct {
+	BuildID       string `json:"buildId"`
+	FilesReceived int    `json:"filesReceived"`
+	CachedReused  int    `json:"cachedReused"`
+	AuxCarried    int    `json:"auxCarried"`
+	Status        string `json:"status"`
+}
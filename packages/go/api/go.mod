@@ -0,0 +1,12 @@
+module github.com/alpha-og/treefrog/packages/go/api
+
+go 1.24.0
+
+require github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+
+require github.com/alpha-og/treefrog/packages/go/security v0.0.0 // indirect
+
+replace (
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../buildopts
+	github.com/alpha-og/treefrog/packages/go/security => ../security
+)
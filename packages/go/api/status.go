@@ -0,0 +1,84 @@
+// Package api defines the build types exchanged between the compilers and
+// their clients. The JSON names of these types and enum values are part of
+// the wire contract and must not change.
+package api
+
+import "strings"
+
+// Status is the lifecycle state of a build as reported by a compiler
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusCompiling Status = "compiling"
+	StatusRetrying  Status = "retrying"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusExpired   Status = "expired"
+	StatusDeleted   Status = "deleted"
+)
+
+// legacyStatuses maps names used by older compilers and clients to Status
+var legacyStatuses = map[string]Status{
+	"queued":   StatusPending,
+	"running":  StatusCompiling,
+	"building": StatusCompiling,
+	"success":  StatusCompleted,
+	"error":    StatusFailed,
+}
+
+// ParseStatus converts a status string, including legacy names such as
+// "success" and "error", to a Status
+func ParseStatus(s string) (Status, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch st := Status(s); st {
+	case StatusPending, StatusCompiling, StatusRetrying, StatusCompleted,
+		StatusFailed, StatusExpired, StatusDeleted:
+		return st, true
+	}
+	st, ok := legacyStatuses[s]
+	return st, ok
+}
+
+// Terminal reports whether a build in this state will not change again
+func (s Status) Terminal() bool {
+	switch s {
+	case StatusCompleted, StatusFailed, StatusExpired, StatusDeleted:
+		return true
+	}
+	return false
+}
+
+// ClientState is the simplified build state shown by clients such as the
+// desktop app
+type ClientState string
+
+const (
+	StateIdle     ClientState = "idle"
+	StateQueued   ClientState = "queued"
+	StateRunning  ClientState = "running"
+	StateRetrying ClientState = "retrying"
+	StateSuccess  ClientState = "success"
+	StateError    ClientState = "error"
+
+	// StateDegraded means the client cannot currently reach the compiler
+	// and is waiting for it to recover
+	StateDegraded ClientState = "degraded"
+)
+
+// ClientStateFor maps a compiler status to the client state
+func ClientStateFor(s Status) ClientState {
+	switch s {
+	case StatusPending:
+		return StateQueued
+	case StatusCompiling:
+		return StateRunning
+	case StatusRetrying:
+		return StateRetrying
+	case StatusCompleted:
+		return StateSuccess
+	case StatusFailed, StatusExpired, StatusDeleted:
+		return StateError
+	}
+	return StateIdle
+}
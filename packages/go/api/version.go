@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Version is the current version of the compiler HTTP API. Routes are served
+// under VersionPrefix; the unversioned /api routes are kept as aliases for
+// one release.
+const Version = 1
+
+const (
+	VersionPrefix = "/v1"
+	VersionHeader = "X-Treefrog-API-Version"
+	LegacyPrefix  = "/api"
+)
+
+// SupportedVersions lists the API versions a server speaks
+var SupportedVersions = []int{Version}
+
+// HealthResponse is returned by /health so clients can pick an API version
+type HealthResponse struct {
+	Status      string `json:"status"`
+	APIVersion  int    `json:"api_version"`
+	APIVersions []int  `json:"api_versions"`
+}
+
+// NewHealthResponse reports a healthy server and the API versions it speaks
+func NewHealthResponse() HealthResponse {
+	return HealthResponse{Status: "ok", APIVersion: Version, APIVersions: SupportedVersions}
+}
+
+// VersionMiddleware stamps responses with the API version. Requests that ask
+// for a version in the VersionHeader that this server does not speak are
+// rejected with 406 Not Acceptable.
+func VersionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(VersionHeader, strconv.Itoa(Version))
+
+		if requested := r.Header.Get(VersionHeader); requested != "" {
+			v, err := strconv.Atoi(strings.TrimSpace(requested))
+			if err != nil || !supported(v) {
+				http.Error(w, fmt.Sprintf("Unsupported API version %q (supported: %d)", requested, Version),
+					http.StatusNotAcceptable)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LegacyMiddleware marks responses from the unversioned /api routes as
+// deprecated and links to their /v1 successor
+func LegacyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if rest, ok := strings.CutPrefix(r.URL.Path, LegacyPrefix); ok {
+			w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", VersionPrefix, rest))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func supported(v int) bool {
+	for _, s := range SupportedVersions {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package build
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CarryForwardExtensions are the intermediate files reused from a previous
+// successful build so latexmk can skip bibliography and cross-reference passes
+var CarryForwardExtensions = map[string]bool{
+	".aux": true,
+	".bbl": true,
+	".toc": true,
+}
+
+// CarryForwardAuxFiles copies intermediate files from the output directory
+// outDir of a previous build into the same directory of a new one. Files
+// already present in the new build are left untouched. Returns the number of
+// files copied.
+func CarryForwardAuxFiles(prevBuildDir, buildDir, outDir string) (int, error) {
+	srcRoot := filepath.Join(prevBuildDir, filepath.FromSlash(outDir))
+	dstRoot := filepath.Join(buildDir, filepath.FromSlash(outDir))
+
+	if _, err := os.Stat(srcRoot); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	copied := 0
+	err := filepath.WalkDir(srcRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		if !CarryForwardExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		rel, err := filepath.Rel(srcRoot, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstRoot, rel)
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(path, dst); err != nil {
+			return err
+		}
+		copied++
+		return nil
+	})
+
+	return copied, err
+}
@@ -0,0 +1,67 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// errNotRegularFile is returned for a file a build has replaced with a
+// symlink or anything else that is not a regular file
+var errNotRegularFile = errors.New("not a regular file")
+
+// openBuildFile opens a file in a build directory for reading. The build can
+// write anything there, so only a regular file is opened, never through a
+// symlink.
+func openBuildFile(path string) (*os.File, os.FileInfo, error) {
+	linfo, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !linfo.Mode().IsRegular() {
+		return nil, nil, errNotRegularFile
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|buildFileOpenFlags, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !os.SameFile(linfo, info) {
+		f.Close()
+		return nil, nil, errNotRegularFile
+	}
+	return f, info, nil
+}
+
+// copyBuildFile copies a file a build wrote to dst outside its directory,
+// refusing one that is not a regular file or is larger than limit bytes
+func copyBuildFile(src, dst string, limit int64) error {
+	in, info, err := openBuildFile(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if info.Size() > limit {
+		return fmt.Errorf("%s is larger than %d bytes", info.Name(), limit)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// The file may still be growing, so the limit holds for what is read
+	// too
+	n, err := io.Copy(out, io.LimitReader(in, limit+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("%s is larger than %d bytes", info.Name(), limit)
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package build
+
+import "syscall"
+
+// buildFileOpenFlags keep openBuildFile from following a symlink or blocking
+// on a FIFO that a build put in place of one of its files
+const buildFileOpenFlags = syscall.O_NOFOLLOW | syscall.O_NONBLOCK
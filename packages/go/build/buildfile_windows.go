@@ -0,0 +1,7 @@
+//go:build windows
+
+package build
+
+// buildFileOpenFlags is empty on Windows, where openBuildFile's checks are
+// all there is
+const buildFileOpenFlags = 0
@@ -0,0 +1,75 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ResultKey returns the key a build's outputs can be reused under: the hash
+// of its input manifest, of every option that changes what latexmk produces
+// and of imageDigest, the compiler image it would run in (see
+// ImageDigester). Builds with network access may produce different output
+// from the same sources, and builds whose image is not known may be
+// compiled by another TeX distribution; both get "".
+func ResultKey(build *Build, dir, imageDigest string) (string, error) {
+	if build.Network || imageDigest == "" {
+		return "", nil
+	}
+	manifest, err := ManifestHash(dir, OutDir(build))
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%t\x00%t\x00%s\x00%t\x00%s\x00%s\x00",
+		manifest, imageDigest, build.Engine, build.MainFile, build.ShellEscape, build.ShellRestricted,
+		OutDir(build), build.Reproducible, build.Sandbox, build.Index)
+	keys := make([]string, 0, len(build.Env))
+	for k := range build.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\x00", k, build.Env[k])
+	}
+	if build.Reproducible {
+		build.ManifestHash = manifest
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReuseResult completes build with the outputs of prev, a completed build
+// with the same ResultKey, copying them into dir. It fails if prev's outputs
+// are gone.
+func ReuseResult(build, prev *Build, dir string) error {
+	if prev.PDFPath == "" {
+		return fmt.Errorf("build %s has no PDF", prev.ID)
+	}
+	pdfPath := filepath.Join(dir, "output.pdf")
+	if err := copyFile(prev.PDFPath, pdfPath); err != nil {
+		return err
+	}
+	syncTeXPath := ""
+	if prev.SyncTeXPath != "" {
+		syncTeXPath = filepath.Join(dir, "output.synctex.gz")
+		if err := copyFile(prev.SyncTeXPath, syncTeXPath); err != nil {
+			os.Remove(pdfPath)
+			return err
+		}
+	}
+
+	build.PDFPath = pdfPath
+	build.SyncTeXPath = syncTeXPath
+	build.ImageDigest = prev.ImageDigest
+	build.BuildLog = fmt.Sprintf("treefrog: sources unchanged since build %s; reused its output\n", prev.ID) + prev.BuildLog
+	build.ErrorMessage = ""
+	build.Status = StatusCompleted
+	build.UpdatedAt = time.Now()
+	build.StorageBytes = CalculateDirSize(dir)
+	return nil
+}
@@ -3,11 +3,16 @@ package build
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
@@ -20,26 +25,137 @@ type Compiler interface {
 	Close() error
 }
 
+// ImageDigester is a Compiler that can tell the digest of the compiler image
+// a build would run in before compiling it, as ResultKey needs
+type ImageDigester interface {
+	ImageDigestFor(build *Build) (string, error)
+}
+
 type DockerCompiler struct {
 	dockerClient *client.Client
 	imageName    string
+	imageDigest  string // pinned digest every run is verified against; "" to accept any
 	workDir      string
+	profile      buildopts.RuntimeProfile
+	securityOpts []string
+	allowNetwork bool   // builds may opt in to network access; offline otherwise
+	ociRuntime   string // Docker runtime containers run with, e.g. runsc; "" is the daemon's default
+	pool         *containerPool
+	redactor     *Redactor // masks secrets in build logs; nil keeps them as printed
+
+	defaultTimeout time.Duration // timeout of builds that ask for none
+	maxTimeout     time.Duration // longest timeout a build may ask for
 }
 
+// NewDockerCompiler creates a compiler that runs imageName with the
+// hardened runtime profile. An image reference pinned by digest
+// (name@sha256:...) pins the compiler to it.
 func NewDockerCompiler(imageName, workDir string) (*DockerCompiler, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create docker client: %w", err)
 	}
 
-	return &DockerCompiler{
+	_, digest := SplitImageDigest(imageName)
+	if digest != "" {
+		if err := ValidateImageDigest(digest); err != nil {
+			return nil, err
+		}
+	}
+
+	c := &DockerCompiler{
 		dockerClient: cli,
 		imageName:    imageName,
+		imageDigest:  digest,
 		workDir:      workDir,
-	}, nil
+	}
+	if err := c.SetRuntimeProfile(buildopts.HardenedProfile()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetRuntimeProfile changes how compile containers are locked down
+func (c *DockerCompiler) SetRuntimeProfile(profile buildopts.RuntimeProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	opts, err := profile.SecurityOpts()
+	if err != nil {
+		return err
+	}
+	c.profile = profile
+	c.securityOpts = opts
+	return nil
+}
+
+// SetOCIRuntime runs compile containers with a runtime registered with the
+// Docker daemon, such as runsc for gVisor. Call it before EnablePool.
+func (c *DockerCompiler) SetOCIRuntime(name string) {
+	c.ociRuntime = name
+}
+
+// AllowNetwork lets builds that ask for it run with network access. Builds
+// that do not ask stay offline either way.
+func (c *DockerCompiler) AllowNetwork(allow bool) {
+	c.allowNetwork = allow
+}
+
+// NetworkAllowed reports whether builds may opt in to network access
+func (c *DockerCompiler) NetworkAllowed() bool {
+	return c.allowNetwork
+}
+
+// SetRedactor masks the secrets r matches in the logs of builds compiled
+// from now on
+func (c *DockerCompiler) SetRedactor(r *Redactor) {
+	c.redactor = r
+}
+
+// SetTimeouts sets the timeout of builds that ask for none and the longest
+// timeout a build may ask for. Zero keeps buildopts.DefaultTimeout.
+func (c *DockerCompiler) SetTimeouts(def, max time.Duration) {
+	c.defaultTimeout, c.maxTimeout = def, max
+}
+
+// Timeouts returns the timeouts set with SetTimeouts
+func (c *DockerCompiler) Timeouts() (def, max time.Duration) {
+	return c.defaultTimeout, c.maxTimeout
+}
+
+// PinDigest pins the compiler to an image digest, for images referenced by
+// tag. Each compile checks the image against it before running.
+func (c *DockerCompiler) PinDigest(digest string) error {
+	if err := ValidateImageDigest(digest); err != nil {
+		return err
+	}
+	c.imageDigest = digest
+	return nil
+}
+
+// EnablePool keeps size idle containers warm so builds skip container
+// startup, recycling each after maxUses builds. Builds with shell-escape or
+// network access still get a fresh container. Call it after
+// SetRuntimeProfile; a size of 0 disables the pool.
+func (c *DockerCompiler) EnablePool(size, maxUses int) {
+	if c.pool != nil {
+		c.pool.close()
+		c.pool = nil
+	}
+	if size <= 0 {
+		return
+	}
+	if maxUses <= 0 {
+		maxUses = DefaultPoolMaxUses
+	}
+	c.pool = newContainerPool(c, size, maxUses)
+	c.pool.refill()
 }
 
 func (c *DockerCompiler) Close() error {
+	if c.pool != nil {
+		c.pool.close()
+	}
 	if c.dockerClient != nil {
 		return c.dockerClient.Close()
 	}
@@ -51,6 +167,14 @@ func (c *DockerCompiler) Compile(build *Build) error {
 
 	buildDir := filepath.Join(c.workDir, build.UserID, build.ID)
 
+	img, err := c.verifyImage(ctx)
+	if err != nil {
+		build.Status = StatusFailed
+		build.ErrorMessage = err.Error()
+		return err
+	}
+	build.ImageDigest = img.digest
+
 	engineFlag := "pdf"
 	if build.Engine == EnginePDFLaTeX {
 		engineFlag = "pdf"
@@ -60,63 +184,242 @@ func (c *DockerCompiler) Compile(build *Build) error {
 		engineFlag = "lualatex"
 	}
 
-	shellEscapeFlag := ""
-	if build.ShellEscape {
-		shellEscapeFlag = "-shell-escape "
+	autoShell := AutoShellEscape(build, buildDir)
+	if build.Reproducible {
+		hash, err := ManifestHash(buildDir, OutDir(build))
+		if err != nil {
+			return err
+		}
+		build.ManifestHash = hash
+	}
+
+	args := []string{"-" + engineFlag}
+	shellArgs, shellEnv := shellEscapeArgs(build, containerPath)
+	args = append(args, shellArgs...)
+	reproArgs, reproEnv := reproducibleArgs(build)
+	args = append(args, reproArgs...)
+	args = append(args, indexArgs(build)...)
+	args = append(args, "-interaction=nonstopmode", "-output-directory="+OutDir(build))
+	command := func(extra ...string) string {
+		line := append(append(args[:len(args):len(args)], extra...), build.MainFile)
+		for i, arg := range line {
+			line[i] = shellQuote(arg)
+		}
+		return "latexmk " + strings.Join(line, " ")
+	}
+
+	// Figures and preprocessors run in the compile container before
+	// latexmk, never in a pooled one that later builds reuse
+	config, err := LoadProjectConfig(buildDir)
+	if err != nil {
+		build.Status = StatusFailed
+		build.ErrorMessage = err.Error()
+		return err
+	}
+	hooks, figureNote := restoreFigures(c.workDir, build, buildDir, config.Figures)
+	hooks = append(hooks, config.Preprocess...)
+
+	// A pooled container outlives the build, so the project's latexmkrc,
+	// which is Perl, never runs in one
+	pooled := c.pool != nil && poolable(build) && len(hooks) == 0
+	if pooled {
+		args = append(args, "-norc")
+	}
+	latexmk := command()
+
+	// The preamble is hashed before the container runs, so projects whose
+	// preprocessors could write files it loads always compile it
+	var preamble string
+	if len(config.Preprocess) == 0 {
+		preamble = preambleKey(build, buildDir, img.digest)
+	}
+	if preamble != "" {
+		restorePreamble(c.workDir, build, preamble, buildDir)
+		defer func() { finishPreamble(c.workDir, build, preamble, buildDir, build.BuildLog) }()
+		latexmk = preambleScript(build.MainFile, command(preambleArgs()...), latexmk, command("-gg"))
+	}
+	latexmk = hookScript(hooks) + latexmk
+	if build.ShellRestricted {
+		latexmk = shellShimCheck + latexmk
+	}
+
+	if err := c.prepareMount(buildDir); err != nil {
+		return err
+	}
+
+	env := append(buildopts.EnvList(build.Env), reproEnv...)
+	env = append(env, shellEnv...)
+	networkMode := container.NetworkMode("none")
+	if build.Network {
+		if !c.allowNetwork {
+			build.Status = StatusFailed
+			build.ErrorMessage = buildopts.ErrNetworkNotAllowed.Error()
+			return buildopts.ErrNetworkNotAllowed
+		}
+		networkMode = "bridge"
+	}
+
+	live, closeLive := openLiveLog(build, buildDir, c.redactor)
+	defer closeLive()
+
+	var logContent string
+	if pooled {
+		logContent, err = c.pool.run(build, buildDir, img, latexmk, env, live)
+	} else {
+		logContent, err = c.runContainer(ctx, build, img, buildDir, latexmk, env, networkMode, live)
+	}
+	if errors.Is(err, errCompileTimeout) {
+		build.Status = StatusFailed
+		build.ErrorMessage = timeoutMessage(build)
+	}
+	if err != nil {
+		return err
+	}
+
+	if autoShell != "" {
+		logContent = autoShellNote(autoShell) + logContent
+	}
+	if build.Reproducible {
+		logContent = reproducibleNote(build) + logContent
+	}
+	logContent = c.redactor.Redact(figureNote + logContent)
+
+	if len(logContent) > MaxLogSize {
+		logContent = logContent[:MaxLogSize] + "\n[LOG TRUNCATED - exceeded 10MB]"
+	}
+	build.BuildLog = logContent
+
+	collectOutputs(build, buildDir, filepath.Join(buildDir, filepath.FromSlash(OutDir(build))))
+	if build.PDFPath != "" {
+		build.Status = StatusCompleted
+	} else if msg := hookFailure(logContent); msg != "" {
+		build.Status = StatusFailed
+		build.ErrorMessage = msg
+	} else {
+		build.Status = StatusFailed
+		build.ErrorMessage = "PDF not generated"
+	}
+
+	if build.Status == StatusCompleted {
+		storeFigures(c.workDir, build, buildDir, config.Figures)
 	}
 
-	script := fmt.Sprintf(`#!/bin/bash
+	build.UpdatedAt = time.Now()
+	build.StorageBytes = CalculateDirSize(buildDir)
+
+	return nil
+}
+
+// errCompileTimeout is returned when latexmk runs past the build's Timeout
+var errCompileTimeout = errors.New("compilation timeout")
+
+// Timeout returns how long latexmk may run for a build. Builds that do not
+// set one get MaxBuildTimeout.
+func Timeout(build *Build) time.Duration {
+	if build.Timeout <= 0 {
+		return MaxBuildTimeout
+	}
+	return time.Duration(build.Timeout) * time.Second
+}
+
+// timeoutMessage is the error message of a build that ran out of time
+func timeoutMessage(build *Build) string {
+	return fmt.Sprintf("Compilation timeout (exceeded %s)", formatTimeout(Timeout(build)))
+}
+
+func formatTimeout(d time.Duration) string {
+	if d%time.Minute == 0 {
+		if d == time.Minute {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%d seconds", int(d/time.Second))
+}
+
+// compileScript returns the container script that unpacks the source in the
+// working directory and runs latexmk. Delta-sync builds upload files
+// directly and have no archive.
+func compileScript(latexmk string) string {
+	return fmt.Sprintf(`#!/bin/bash
 set -e
-cd /data
-unzip -o source.zip
-latexmk -%s %s-interaction=nonstopmode -outdir=output %s
-if [ -f output/output.pdf ]; then
-    cp output/output.pdf .
-fi
-if [ -f output/output.synctex.gz ]; then
-    cp output/output.synctex.gz .
-fi
+if [ -f source.zip ]; then unzip -o source.zip; fi
+%s
 exit 0
-`, engineFlag, shellEscapeFlag, build.MainFile)
+`, latexmk)
+}
 
-	resp, err := c.dockerClient.ContainerCreate(ctx, &container.Config{
-		Image: c.imageName,
-		Cmd:   []string{"bash", "-c", script},
-		Labels: map[string]string{
-			"build_id": build.ID,
-			"user_id":  build.UserID,
-		},
-	}, &container.HostConfig{
+// containerConfig returns the config of a compile container of the verified
+// image running cmd in workingDir
+func (c *DockerCompiler) containerConfig(img verifiedImage, cmd, env []string, workingDir string, labels map[string]string) *container.Config {
+	return &container.Config{
+		Image:      img.id,
+		Cmd:        cmd,
+		Env:        append(env, c.profile.Env()...),
+		User:       c.profile.User,
+		WorkingDir: workingDir,
+		Labels:     labels,
+	}
+}
+
+// hostConfig returns the host config of a compile container that sees the
+// host directory source at target, locked down by the runtime profile
+func (c *DockerCompiler) hostConfig(source, target string, networkMode container.NetworkMode) *container.HostConfig {
+	pidsLimit := c.profile.PidsLimit
+	if pidsLimit == 0 {
+		pidsLimit = ContainerPidsLimit
+	}
+	var capDrop []string
+	if c.profile.DropCapabilities {
+		capDrop = []string{"ALL"}
+	}
+	return &container.HostConfig{
 		Mounts: []mount.Mount{
 			{
 				Type:   mount.TypeBind,
-				Source: buildDir,
-				Target: "/data",
+				Source: source,
+				Target: target,
 			},
 		},
 		Tmpfs: map[string]string{
 			"/tmp": fmt.Sprintf("size=%dm,mode=1777", ContainerTmpfsSizeMB),
 		},
-		AutoRemove: true,
+		AutoRemove:     true,
+		Runtime:        c.ociRuntime,
+		ReadonlyRootfs: c.profile.ReadOnlyRootfs,
+		SecurityOpt:    c.securityOpts,
+		CapDrop:        capDrop,
 		Resources: container.Resources{
 			Memory:     ContainerMemoryMB * 1024 * 1024,
 			MemorySwap: ContainerMemoryMB * 1024 * 1024,
 			CPUQuota:   ContainerCPUQuota,
 			CPUShares:  ContainerCPUShares,
-			PidsLimit:  &[]int64{ContainerPidsLimit}[0],
+			PidsLimit:  &pidsLimit,
 		},
-		NetworkMode: "none",
-	}, nil, nil, "")
+		NetworkMode: networkMode,
+	}
+}
 
+// runContainer compiles build in a fresh container and returns its log
+func (c *DockerCompiler) runContainer(ctx context.Context, build *Build, img verifiedImage, buildDir, latexmk string, env []string, networkMode container.NetworkMode, live io.Writer) (string, error) {
+	resp, err := c.dockerClient.ContainerCreate(ctx,
+		c.containerConfig(img, []string{"bash", "-c", compileScript(latexmk)}, env, "/data", map[string]string{
+			"build_id": build.ID,
+			"user_id":  build.UserID,
+		}),
+		c.hostConfig(buildDir, "/data", networkMode), nil, nil, "")
 	if err != nil {
-		return fmt.Errorf("failed to create container: %w", err)
+		return "", fmt.Errorf("failed to create container: %w", err)
 	}
 
 	if err := c.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-		return fmt.Errorf("failed to start container: %w", err)
+		return "", fmt.Errorf("failed to start container: %w", err)
 	}
 
-	timeoutCtx, cancel := context.WithTimeout(ctx, MaxBuildTimeout)
+	stopLive := c.streamLiveLog(ctx, resp.ID, live)
+	defer stopLive()
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, Timeout(build))
 	defer cancel()
 
 	statusCh, errCh := c.dockerClient.ContainerWait(timeoutCtx, resp.ID, container.WaitConditionNotRunning)
@@ -124,7 +427,7 @@ exit 0
 	select {
 	case err := <-errCh:
 		if err != nil {
-			return fmt.Errorf("container error: %w", err)
+			return "", fmt.Errorf("container error: %w", err)
 		}
 	case <-timeoutCtx.Done():
 		stopCtx, stopCancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -133,43 +436,75 @@ exit 0
 		if err := c.dockerClient.ContainerStop(stopCtx, resp.ID, container.StopOptions{}); err != nil {
 			c.dockerClient.ContainerRemove(stopCtx, resp.ID, container.RemoveOptions{Force: true})
 		}
-		build.Status = StatusFailed
-		build.ErrorMessage = "Compilation timeout (exceeded 10 minutes)"
-		return fmt.Errorf("compilation timeout")
+		return "", errCompileTimeout
 	case <-statusCh:
 	}
 
 	logs, err := c.dockerClient.ContainerLogs(ctx, resp.ID, container.LogsOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to get logs: %w", err)
+		return "", fmt.Errorf("failed to get logs: %w", err)
 	}
 	defer logs.Close()
 
 	var stdout, stderr bytes.Buffer
 	stdcopy.StdCopy(&stdout, &stderr, logs)
-	logContent := stdout.String() + stderr.String()
+	return stdout.String() + stderr.String(), nil
+}
 
-	if len(logContent) > MaxLogSize {
-		logContent = logContent[:MaxLogSize] + "\n[LOG TRUNCATED - exceeded 10MB]"
+// streamLiveLog appends the output of a running container to live until it
+// exits. The returned function stops streaming and waits for it to finish.
+func (c *DockerCompiler) streamLiveLog(ctx context.Context, containerID string, live io.Writer) func() {
+	if live == nil {
+		return func() {}
 	}
-	build.BuildLog = logContent
-
-	pdfPath := filepath.Join(buildDir, "output.pdf")
-	if _, err := os.Stat(pdfPath); err == nil {
-		build.PDFPath = pdfPath
-		build.Status = StatusCompleted
-	} else {
-		build.Status = StatusFailed
-		build.ErrorMessage = "PDF not generated"
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logs, err := c.dockerClient.ContainerLogs(ctx, containerID, container.LogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     true,
+		})
+		if err != nil {
+			return
+		}
+		defer logs.Close()
+		stdcopy.StdCopy(live, live, logs)
+	}()
+	return func() {
+		cancel()
+		<-done
 	}
+}
 
-	synctexPath := filepath.Join(buildDir, "output.synctex.gz")
-	if _, err := os.Stat(synctexPath); err == nil {
-		build.SyncTeXPath = synctexPath
+// prepareMount hands the build directory to the container user. Only root
+// can change ownership; an unprivileged server runs containers as itself.
+func (c *DockerCompiler) prepareMount(buildDir string) error {
+	uid, gid, ok := c.profile.UserIDs()
+	if !ok || os.Geteuid() != 0 {
+		return nil
 	}
+	return filepath.WalkDir(buildDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
 
-	build.UpdatedAt = time.Now()
-	build.StorageBytes = CalculateDirSize(buildDir)
-
-	return nil
+// shellEscapeArgs returns the latexmk flags and extra environment for the
+// build's shell-escape setting, given the PATH latexmk runs with. The
+// restricted profile runs the engine with -shell-restricted and an allowlist
+// of commands, so \write18 can call pygmentize or epstopdf through the shim
+// but nothing else. It also skips the project's latexmkrc, which is Perl and
+// could run anything.
+func shellEscapeArgs(build *Build, path string) (args, env []string) {
+	switch {
+	case build.ShellEscape:
+		return []string{"-shell-escape"}, nil
+	case build.ShellRestricted:
+		return []string{"-norc", "-shell-restricted"}, buildopts.RestrictedShellEnv(path)
+	}
+	return nil, nil
 }
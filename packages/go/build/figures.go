@@ -0,0 +1,167 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	MaxFigures = 64
+	// MaxFigureInputSize caps each file hashed into a figure's cache key
+	MaxFigureInputSize = 32 * 1024 * 1024
+	// MaxFigureOutputSize caps each generated figure stored in the cache
+	MaxFigureOutputSize = 32 * 1024 * 1024
+	// FigureCacheTTL is how long a cached figure is kept after it was last used
+	FigureCacheTTL = 30 * 24 * time.Hour
+)
+
+// figureCacheDir is the directory under a compiler's work directory that
+// holds generated figures, per user, by cache key
+const figureCacheDir = ".figures"
+
+// FigureTools are the figure generators a project can declare by name
+var FigureTools = map[string]Preprocessor{
+	"asy": {
+		Extensions: []string{".asy"},
+		Outputs:    []string{".pdf", ".eps", ".png", ".svg"},
+		Args: func(input, output string) []string {
+			ext := path.Ext(output)
+			return []string{"asy", "-f", strings.ToLower(ext[1:]), "-o", strings.TrimSuffix(output, ext), input}
+		},
+	},
+	"gnuplot": {
+		Extensions: []string{".gp", ".gnuplot", ".plt"},
+		Outputs:    []string{".pdf", ".png", ".svg", ".eps"},
+		Args: func(input, output string) []string {
+			// A script that sets its own terminal and output overrides these
+			terminal := map[string]string{".pdf": "pdfcairo", ".png": "pngcairo", ".svg": "svg", ".eps": "epscairo"}
+			setup := fmt.Sprintf("set terminal %s; set output '%s'", terminal[strings.ToLower(path.Ext(output))], output)
+			return []string{"gnuplot", "-e", setup, input}
+		},
+	},
+}
+
+// Figure is a figure-generation pass declared in .treefrog.json. Its output
+// is cached across the user's builds by the content of its input and Deps.
+type Figure struct {
+	Hook
+	Deps []string `json:"deps,omitempty"` // other files the figure reads, e.g. data
+
+	key string // cache key, set by restoreFigures
+}
+
+func (f *Figure) normalize() error {
+	if err := f.Hook.normalize(FigureTools); err != nil {
+		return err
+	}
+	for i, dep := range f.Deps {
+		f.Deps[i] = path.Clean(dep)
+		if err := checkProjectPath(f.Run, f.Deps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheKey hashes everything that determines the figure's output
+func (f *Figure) cacheKey(dir string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", f.Run, path.Ext(f.Output))
+	for _, file := range append([]string{f.Input}, f.Deps...) {
+		data, err := readProjectFile(dir, file, MaxFigureInputSize)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", file, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// figureCachePath is where the output of the figure with key is cached for
+// the build's user
+func figureCachePath(workDir string, build *Build, key string) string {
+	return filepath.Join(workDir, figureCacheDir, build.UserID, key)
+}
+
+// restoreFigures copies the cached output of each figure whose inputs are
+// unchanged into the build directory and returns the figures that still
+// have to run, with a log line per restored figure. Outputs that ship with
+// the project are always regenerated, since unpacking the source would
+// overwrite a restored copy.
+func restoreFigures(workDir string, build *Build, buildDir string, figures []Figure) ([]Hook, string) {
+	var run []Hook
+	var note strings.Builder
+	for i := range figures {
+		f := &figures[i]
+		key, err := f.cacheKey(buildDir)
+		if err != nil {
+			// A missing input fails when the figure runs, with the tool's error
+			run = append(run, f.Hook)
+			continue
+		}
+		f.key = key
+
+		cached := figureCachePath(workDir, build, key)
+		if _, err := readProjectFile(buildDir, f.Output, 0); err == nil {
+			run = append(run, f.Hook)
+			continue
+		}
+		dst := filepath.Join(buildDir, filepath.FromSlash(f.Output))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			run = append(run, f.Hook)
+			continue
+		}
+		if err := copyFile(cached, dst); err != nil {
+			run = append(run, f.Hook)
+			continue
+		}
+		now := time.Now()
+		os.Chtimes(cached, now, now)
+		fmt.Fprintf(&note, "treefrog: %s restored from the figure cache\n", f.Output)
+	}
+	return run, note.String()
+}
+
+// storeFigures caches the outputs of a completed build's figures and drops
+// the user's figures that have not been used for FigureCacheTTL
+func storeFigures(workDir string, build *Build, buildDir string, figures []Figure) {
+	userDir := filepath.Join(workDir, figureCacheDir, build.UserID)
+	for _, f := range figures {
+		if f.key == "" {
+			continue
+		}
+		cached := figureCachePath(workDir, build, f.key)
+		if _, err := os.Stat(cached); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(userDir, 0755); err != nil {
+			return
+		}
+		// Write under a temporary name so a concurrent build never restores
+		// a partial figure
+		tmp := cached + ".tmp"
+		if err := copyBuildFile(filepath.Join(buildDir, filepath.FromSlash(f.Output)), tmp, MaxFigureOutputSize); err != nil {
+			os.Remove(tmp)
+			continue
+		}
+		os.Rename(tmp, cached)
+	}
+
+	entries, err := os.ReadDir(userDir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-FigureCacheTTL)
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(userDir, entry.Name()))
+		}
+	}
+}
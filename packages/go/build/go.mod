@@ -2,7 +2,11 @@ module github.com/alpha-og/treefrog/packages/go/build
 
 go 1.24.0
 
-require github.com/docker/docker v28.5.2+incompatible
+require (
+	github.com/alpha-og/treefrog/packages/go/api v0.0.0
+	github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+	github.com/docker/docker v28.5.2+incompatible
+)
 
 require (
 	github.com/Microsoft/go-winio v0.6.2 // indirect
@@ -37,3 +41,9 @@ require (
 	golang.org/x/time v0.14.0 // indirect
 	gotest.tools/v3 v3.5.2 // indirect
 )
+
+replace (
+	github.com/alpha-og/treefrog/packages/go/api => ../api
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../buildopts
+	github.com/alpha-og/treefrog/packages/go/security => ../security
+)
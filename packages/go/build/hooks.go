@@ -0,0 +1,359 @@
+package build
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// ProjectConfigName is the file in a project's root that declares its
+// targets and the preprocessors to run before latexmk
+const ProjectConfigName = buildopts.ProjectConfigName
+
+const (
+	DefaultHookTimeout = 2 * time.Minute
+	MaxHookTimeout     = 10 * time.Minute
+	MaxHooks           = 8
+)
+
+// maxProjectConfigSize caps how much of .treefrog.json is read
+const maxProjectConfigSize = 64 * 1024
+
+// Preprocessor is a tool a project may run before latexmk. Args returns its
+// command line for an input and output file relative to the project root.
+type Preprocessor struct {
+	Extensions []string // input extensions it accepts, lower-case
+	Outputs    []string // output extensions it writes, lower-case; the first is the default
+	Args       func(input, output string) []string
+}
+
+// Preprocessors are the tools a project can declare by name. Projects name
+// a tool and its files; they cannot run commands of their own.
+var Preprocessors = map[string]Preprocessor{
+	"knitr": {
+		Extensions: []string{".rnw", ".rtex"},
+		Outputs:    []string{".tex"},
+		Args: func(input, output string) []string {
+			return []string{"Rscript", "-e", "a <- commandArgs(TRUE); knitr::knit(a[1], output = a[2])", input, output}
+		},
+	},
+	"rmarkdown": {
+		Extensions: []string{".rmd"},
+		Outputs:    []string{".tex"},
+		Args: func(input, output string) []string {
+			return []string{"Rscript", "-e", "a <- commandArgs(TRUE); rmarkdown::render(a[1], output_format = 'latex_document', " +
+				"output_file = basename(a[2]), output_dir = normalizePath(dirname(a[2])))", input, output}
+		},
+	},
+	"pweave": {
+		Extensions: []string{".texw", ".pnw"},
+		Outputs:    []string{".tex"},
+		Args: func(input, output string) []string {
+			return []string{"pweave", "-f", "tex", "-o", output, input}
+		},
+	},
+}
+
+// ErrPreprocessorsNeedSandbox is returned when a project declares
+// preprocessors or figures but the build may not run arbitrary code where it
+// compiles
+var ErrPreprocessorsNeedSandbox = errors.New("preprocessors and figures need a sandbox or full shell-escape")
+
+// Hook is a preprocessor run declared in .treefrog.json
+type Hook struct {
+	Run     string `json:"run"`               // name in Preprocessors
+	Input   string `json:"input"`             // relative to the project root
+	Output  string `json:"output,omitempty"`  // defaults to Input with the tool's first output extension
+	Timeout int    `json:"timeout,omitempty"` // seconds; 0 for DefaultHookTimeout
+
+	tool Preprocessor
+}
+
+// ProjectConfig is a project's .treefrog.json
+type ProjectConfig struct {
+	buildopts.ProjectSettings
+	Preprocess []Hook   `json:"preprocess"`
+	Figures    []Figure `json:"figures"`
+	// Key is the SHA-256 of the file, which tells apart projects whose
+	// targets share names; "" for a project without one
+	Key string `json:"-"`
+}
+
+// HookError is a preprocessor that failed or ran out of time
+type HookError struct {
+	Hook     Hook
+	TimedOut bool
+	Err      error
+}
+
+func (e *HookError) Error() string {
+	return "preprocessor " + e.describe()
+}
+
+// Message is the error message of the build the hook failed
+func (e *HookError) Message() string {
+	return "Preprocessor " + e.describe()
+}
+
+func (e *HookError) describe() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s timed out on %s after %s", e.Hook.Run, e.Hook.Input, formatTimeout(e.Hook.timeout()))
+	}
+	return fmt.Sprintf("%s failed on %s: %v", e.Hook.Run, e.Hook.Input, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// hookPathPattern keeps hook files safe to pass through a shell
+var hookPathPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// LoadProjectConfig reads the .treefrog.json of the project in dir, from
+// dir or from its source.zip when it has not been extracted yet. A project
+// without one declares nothing.
+func LoadProjectConfig(dir string) (ProjectConfig, error) {
+	data, err := readProjectFile(dir, ProjectConfigName, maxProjectConfigSize)
+	if errors.Is(err, os.ErrNotExist) {
+		return ProjectConfig{}, nil
+	}
+	if err != nil {
+		return ProjectConfig{}, err
+	}
+	return parseProjectConfig(data)
+}
+
+// ProjectConfigFromZip reads the .treefrog.json of a zipped project before
+// it is extracted, e.g. to look up the target a build asks for
+func ProjectConfigFromZip(r io.ReaderAt, size int64) (ProjectConfig, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return ProjectConfig{}, fmt.Errorf("invalid zip file: %w", err)
+	}
+	f, err := reader.Open(ProjectConfigName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ProjectConfig{}, nil
+	}
+	if err != nil {
+		return ProjectConfig{}, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxProjectConfigSize))
+	if err != nil {
+		return ProjectConfig{}, err
+	}
+	return parseProjectConfig(data)
+}
+
+func parseProjectConfig(data []byte) (ProjectConfig, error) {
+	var config ProjectConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid %s: %w", ProjectConfigName, err)
+	}
+	sum := sha256.Sum256(data)
+	config.Key = hex.EncodeToString(sum[:])
+	if len(config.Preprocess) > MaxHooks {
+		return config, fmt.Errorf("invalid %s: at most %d preprocessors", ProjectConfigName, MaxHooks)
+	}
+	if len(config.Figures) > MaxFigures {
+		return config, fmt.Errorf("invalid %s: at most %d figures", ProjectConfigName, MaxFigures)
+	}
+	if err := config.ProjectSettings.Normalize(); err != nil {
+		return config, fmt.Errorf("invalid %s: %w", ProjectConfigName, err)
+	}
+	for i := range config.Preprocess {
+		if err := config.Preprocess[i].normalize(Preprocessors); err != nil {
+			return config, fmt.Errorf("invalid %s: %w", ProjectConfigName, err)
+		}
+	}
+	for i := range config.Figures {
+		if err := config.Figures[i].normalize(); err != nil {
+			return config, fmt.Errorf("invalid %s: %w", ProjectConfigName, err)
+		}
+	}
+	return config, nil
+}
+
+// runsCode reports whether the project declares passes, which run
+// arbitrary code
+func (c ProjectConfig) runsCode() bool {
+	return len(c.Preprocess) > 0 || len(c.Figures) > 0
+}
+
+// normalize looks up the hook's tool in tools, fills in the default output
+// and checks the hook
+func (h *Hook) normalize(tools map[string]Preprocessor) error {
+	p, ok := tools[h.Run]
+	if !ok {
+		return fmt.Errorf("unknown tool %q", h.Run)
+	}
+	h.tool = p
+	h.Input = path.Clean(h.Input)
+	if !hasExtension(h.Input, p.Extensions) {
+		return fmt.Errorf("%s takes %s files, not %s", h.Run, strings.Join(p.Extensions, ", "), h.Input)
+	}
+	if h.Output == "" {
+		h.Output = strings.TrimSuffix(h.Input, path.Ext(h.Input)) + p.Outputs[0]
+	}
+	h.Output = path.Clean(h.Output)
+	if !hasExtension(h.Output, p.Outputs) {
+		return fmt.Errorf("%s writes %s files, not %s", h.Run, strings.Join(p.Outputs, ", "), h.Output)
+	}
+	for _, file := range []string{h.Input, h.Output} {
+		if err := checkProjectPath(h.Run, file); err != nil {
+			return err
+		}
+	}
+	if h.Output == h.Input {
+		return fmt.Errorf("%s: output would overwrite %s", h.Run, h.Input)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("%s: timeout must be positive", h.Run)
+	}
+	return nil
+}
+
+// timeout is how long the hook may run, capped to MaxHookTimeout
+func (h Hook) timeout() time.Duration {
+	if h.Timeout == 0 {
+		return DefaultHookTimeout
+	}
+	return min(time.Duration(h.Timeout)*time.Second, MaxHookTimeout)
+}
+
+func (h Hook) args() []string {
+	return h.tool.Args(h.Input, h.Output)
+}
+
+func hasExtension(file string, exts []string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProjectPath checks that a file named by tool is inside the project
+// and safe to pass through a shell
+func checkProjectPath(tool, file string) error {
+	if !hookPathPattern.MatchString(file) || !filepath.IsLocal(file) {
+		return fmt.Errorf("%s: %q must be a file inside the project", tool, file)
+	}
+	return nil
+}
+
+// runHooks runs hooks in order in the project directory dir, writing their
+// output to out. It stops at the first hook that fails.
+func runHooks(ctx context.Context, hooks []Hook, dir string, env []string, out io.Writer) error {
+	for _, h := range hooks {
+		fmt.Fprintf(out, "treefrog: running %s on %s\n", h.Run, h.Input)
+
+		hookCtx, cancel := context.WithTimeout(ctx, h.timeout())
+		args := h.args()
+		cmd := exec.CommandContext(hookCtx, args[0], args[1:]...)
+		killProcessGroup(cmd)
+		cmd.WaitDelay = 5 * time.Second
+		cmd.Dir = dir
+		cmd.Env = env
+		cmd.Stdout = out
+		cmd.Stderr = out
+		err := cmd.Run()
+		timedOut := hookCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+
+		if err != nil {
+			hookErr := &HookError{Hook: h, TimedOut: timedOut, Err: err}
+			fmt.Fprintf(out, "treefrog: %v\n", hookErr)
+			return hookErr
+		}
+	}
+	return nil
+}
+
+// hookScript returns the shell lines that run hooks before latexmk in a
+// compile container. They log like runHooks and stop the script at the
+// first hook that fails; hookFailure finds the failure in the log.
+func hookScript(hooks []Hook) string {
+	if len(hooks) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(`preprocess() {
+  local name=$1 input=$2 secs=$3 limit=$4 s=0
+  shift 4
+  echo "treefrog: running $name on $input"
+  timeout -k 5 "$secs" "$@" || s=$?
+  if [ $s -eq 124 ]; then echo "treefrog: preprocessor $name timed out on $input after $limit"
+  elif [ $s -ne 0 ]; then echo "treefrog: preprocessor $name failed on $input: exit status $s"; fi
+  return $s
+}
+`)
+	for _, h := range hooks {
+		line := []string{"preprocess", h.Run, h.Input, strconv.Itoa(int(h.timeout() / time.Second)), formatTimeout(h.timeout())}
+		line = append(line, h.args()...)
+		for i, arg := range line {
+			line[i] = shellQuote(arg)
+		}
+		b.WriteString(strings.Join(line, " ") + "\n")
+	}
+	return b.String()
+}
+
+// hookFailure returns the error message of a build whose preprocessor
+// failed in a compile container, like HookError.Message, or "" if none did
+func hookFailure(log string) string {
+	for _, line := range strings.Split(log, "\n") {
+		if msg, ok := strings.CutPrefix(line, "treefrog: preprocessor "); ok {
+			return "Preprocessor " + strings.TrimRight(msg, "\r")
+		}
+	}
+	return ""
+}
+
+// readProjectFile reads up to limit bytes of name from the project in dir,
+// or from dir's source.zip when the project has not been extracted yet
+func readProjectFile(dir, name string, limit int64) ([]byte, error) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+	if err == nil {
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, limit))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	reader, err := zip.OpenReader(filepath.Join(dir, "source.zip"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	zf, err := reader.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer zf.Close()
+	return io.ReadAll(io.LimitReader(zf, limit))
+}
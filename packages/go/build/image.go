@@ -0,0 +1,99 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+)
+
+// ErrImageDigestMismatch is returned when the compiler image does not match
+// its pinned digest
+var ErrImageDigestMismatch = errors.New("compiler image does not match the pinned digest")
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// ValidateImageDigest checks that digest looks like sha256:<64 hex digits>
+func ValidateImageDigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid image digest %q: want sha256:<64 hex digits>", digest)
+	}
+	return nil
+}
+
+// SplitImageDigest splits an image reference pinned by digest, such as
+// "treefrog-compiler@sha256:...", into its name and digest. The digest is ""
+// when ref is not pinned.
+func SplitImageDigest(ref string) (name, digest string) {
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// ImageDigest returns the digest to record for an inspected image: the
+// registry digest if it was pulled, otherwise its image ID. If pinned is set
+// the image must have it as a registry digest or as its ID.
+func ImageDigest(img image.InspectResponse, pinned string) (string, error) {
+	var digests []string
+	for _, repoDigest := range img.RepoDigests {
+		if _, digest := SplitImageDigest(repoDigest); digest != "" {
+			digests = append(digests, digest)
+		}
+	}
+
+	if pinned != "" {
+		if img.ID == pinned {
+			return pinned, nil
+		}
+		for _, digest := range digests {
+			if digest == pinned {
+				return pinned, nil
+			}
+		}
+		return "", fmt.Errorf("%w: want %s, have %s", ErrImageDigestMismatch, pinned, img.ID)
+	}
+
+	if len(digests) > 0 {
+		return digests[0], nil
+	}
+	return img.ID, nil
+}
+
+// ImageDigestFor returns the digest of the compiler image, verified as for
+// a run
+func (c *DockerCompiler) ImageDigestFor(*Build) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	img, err := c.verifyImage(ctx)
+	if err != nil {
+		return "", err
+	}
+	return img.digest, nil
+}
+
+// verifiedImage is the compiler image as inspected before a run. Containers
+// are created from its ID, which names the image's content, so an image
+// tagged over the name after the check cannot take its place.
+type verifiedImage struct {
+	id     string // image ID, sha256:...
+	digest string // digest recorded for builds, see ImageDigest
+}
+
+// verifyImage inspects the compiler image before a run, failing if it does
+// not match the pinned digest
+func (c *DockerCompiler) verifyImage(ctx context.Context) (verifiedImage, error) {
+	img, err := c.dockerClient.ImageInspect(ctx, c.imageName)
+	if err != nil {
+		return verifiedImage{}, fmt.Errorf("failed to inspect compiler image %s: %w", c.imageName, err)
+	}
+	digest, err := ImageDigest(img, c.imageDigest)
+	if err != nil {
+		return verifiedImage{}, err
+	}
+	return verifiedImage{id: img.ID, digest: digest}, nil
+}
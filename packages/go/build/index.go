@@ -0,0 +1,25 @@
+package build
+
+import "github.com/alpha-og/treefrog/packages/go/buildopts"
+
+// glossaryRules teach latexmk to run makeglossaries when the engine writes
+// glossary (.glo) or acronym (.acn) files, and again when they change.
+// latexmk runs makeindex on .idx files by itself but skips glossaries.
+// makeglossaries picks makeindex or xindy from the document's .aux.
+const glossaryRules = `add_cus_dep('glo', 'gls', 0, 'makeglossaries');` +
+	`add_cus_dep('acn', 'acr', 0, 'makeglossaries');` +
+	`sub makeglossaries { my ($base, $path) = fileparse($_[0]); return system('makeglossaries', '-d', $path, $base); }`
+
+// xindyRule makes latexmk process .idx files with texindy instead of
+// makeindex
+const xindyRule = `$makeindex = 'texindy -C utf8 %O -o %D %S';`
+
+// indexArgs returns the latexmk arguments that run the glossary and index
+// processors between engine runs
+func indexArgs(build *Build) []string {
+	args := []string{"-e", glossaryRules}
+	if build.Index == buildopts.IndexXindy {
+		args = append(args, "-e", xindyRule)
+	}
+	return args
+}
@@ -0,0 +1,343 @@
+package build
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultFloatDrift is how many pages a float may land from its first
+// reference before the layout report flags it
+const DefaultFloatDrift = 2
+
+// MaxBoxWarnings caps how many box warnings a layout report lists
+const MaxBoxWarnings = 500
+
+// Kinds of box warning
+const (
+	BoxOverfull  = "overfull"
+	BoxUnderfull = "underfull"
+)
+
+// BoxWarning is an overfull or underfull box TeX reported. Overfull boxes
+// stick out by Points; underfull ones are as loose as Badness (max 10000).
+type BoxWarning struct {
+	Kind    string  `json:"kind"`
+	Box     string  `json:"box"` // hbox or vbox
+	File    string  `json:"file,omitempty"`
+	Line    int     `json:"line,omitempty"`
+	EndLine int     `json:"end_line,omitempty"`
+	Page    int     `json:"page,omitempty"`
+	Points  float64 `json:"points,omitempty"`
+	Badness int     `json:"badness,omitempty"`
+}
+
+// Float is a labelled figure or table and where it landed relative to where
+// it is first referenced. Pages are physical, counted from the first page of
+// the PDF, so front matter numbered apart does not count as drift.
+type Float struct {
+	Label     string `json:"label"`
+	Kind      string `json:"kind"` // figure, table, ...
+	Number    string `json:"number"`
+	PageLabel string `json:"page_label,omitempty"` // page number as printed, e.g. iv
+	Page      int    `json:"page,omitempty"`       // from zref's abspage or SyncTeX; 0 if unknown
+	RefFile   string `json:"ref_file,omitempty"`
+	RefLine   int    `json:"ref_line,omitempty"`
+	RefPage   int    `json:"ref_page,omitempty"` // 0 if unreferenced or unknown
+	Drift     int    `json:"drift"`              // Page - RefPage
+	Drifted   bool   `json:"drifted"`
+}
+
+// LayoutReport sums up how well the pages of a build are set: boxes that
+// stick out or are too loose, float placement warnings, and floats that
+// drifted far from their references
+type LayoutReport struct {
+	Overfull      int          `json:"overfull"`
+	Underfull     int          `json:"underfull"`
+	Boxes         []BoxWarning `json:"boxes"`
+	FloatWarnings []Problem    `json:"float_warnings"`
+	Floats        []Float      `json:"floats"`
+	Drifted       int          `json:"drifted"`
+	MaxDrift      int          `json:"max_drift"`
+}
+
+// PageLocator returns the page a source line was set on, or 0 if unknown.
+// File is relative to the project root.
+type PageLocator func(file string, line int) int
+
+var (
+	boxRe          = regexp.MustCompile(`^(Over|Under)full \\([hv]box) \((?:([\d.]+)pt too (?:wide|high)|badness (\d+))\)(?: in (?:paragraph|alignment) at lines (\d+)--(\d+)| detected at line (\d+))?`)
+	floatWarningRe = regexp.MustCompile("float specifier changed|Float too large|contains only floats|Too many unprocessed floats")
+	newLabelRe     = regexp.MustCompile(`^\\newlabel\{([^{}]+)\}\{\{([^{}]*)\}\{([^{}]*)\}`)
+	zrefLabelRe    = regexp.MustCompile(`^\\zref@newlabel\{([^{}]+)\}`)
+	abspageRe      = regexp.MustCompile(`\\abspage\{(\d+)\}`)
+	labelRe        = regexp.MustCompile(`\\label\{([^{}]+)\}`)
+	floatAnchorRe  = regexp.MustCompile(`\{((?:sub)?(?:figure|table)\*?)\.[^{}]*\}`)
+	auxInputRe     = regexp.MustCompile(`^\\@input\{([^{}]+)\}`)
+	refRe          = regexp.MustCompile(`\\(?:ref|autoref|cref|Cref|vref|Vref|subref|pageref)\*?\{([^{}]+)\}`)
+)
+
+// ParseBoxWarnings extracts the overfull and underfull boxes of the last
+// engine run in a LaTeX log, with the page each was set on from the page
+// numbers the engine prints as it ships pages out
+func ParseBoxWarnings(log string) []BoxWarning {
+	lines := strings.Split(lastEngineRun(log), "\n")
+	boxes := []BoxWarning{}
+	currentFile, shipped := "", 0
+
+	for i := 0; i < len(lines) && len(boxes) < MaxBoxWarnings; i++ {
+		line := strings.TrimRight(lines[i], "\r")
+
+		if m := boxRe.FindStringSubmatch(line); m != nil {
+			b := BoxWarning{Kind: BoxUnderfull, Box: m[2], File: currentFile, Page: shipped + 1}
+			if m[1] == "Over" {
+				b.Kind = BoxOverfull
+			}
+			b.Points, _ = strconv.ParseFloat(m[3], 64)
+			b.Badness, _ = strconv.Atoi(m[4])
+			if m[5] != "" {
+				b.Line, _ = strconv.Atoi(m[5])
+				b.EndLine, _ = strconv.Atoi(m[6])
+			} else if m[7] != "" {
+				b.Line, _ = strconv.Atoi(m[7])
+			}
+			boxes = append(boxes, b)
+			// The box's contents follow until a blank line; they may hold
+			// text like "[3]" that is not a page number
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+				i++
+			}
+			continue
+		}
+
+		if m := fileOpenRe.FindAllStringSubmatch(line, -1); len(m) > 0 {
+			currentFile = cleanLogPath(m[len(m)-1][1])
+		}
+		for _, m := range progressPageRe.FindAllStringSubmatch(line+" ", -1) {
+			if page, _ := strconv.Atoi(m[1]); page > shipped {
+				shipped = page
+			}
+		}
+	}
+	return boxes
+}
+
+// ParseFloatWarnings extracts the float placement warnings and errors of
+// the last engine run in a LaTeX log
+func ParseFloatWarnings(log string) []Problem {
+	warnings := []Problem{}
+	for _, p := range ParseProblems(lastEngineRun(log)) {
+		if floatWarningRe.MatchString(p.Message) {
+			warnings = append(warnings, p)
+		}
+	}
+	return warnings
+}
+
+// ReadFloats reads the labelled floats from the .aux file at auxPath and
+// the .aux files it inputs, as \include does for each chapter. Labels are
+// taken as floats by their hyperref anchor or else by a fig: or tab:
+// prefix. Floats whose label zref also recorded with its abspage property
+// get their physical page; the others are left for SyncTeX to place.
+func ReadFloats(auxPath string) ([]Float, error) {
+	floats := []Float{}
+	seen := map[string]bool{}
+	abspages := map[string]int{}
+	var read func(p string, depth int) error
+	read = func(p string, depth int) error {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			line := sc.Text()
+			if m := auxInputRe.FindStringSubmatch(line); m != nil && depth < 4 {
+				// Chapters' aux files are optional: \include writes them only
+				// once the chapter has been compiled
+				read(filepath.Join(filepath.Dir(auxPath), filepath.FromSlash(m[1])), depth+1)
+				continue
+			}
+			if m := zrefLabelRe.FindStringSubmatch(line); m != nil {
+				if p := abspageRe.FindStringSubmatch(line); p != nil {
+					abspages[m[1]], _ = strconv.Atoi(p[1])
+				}
+				continue
+			}
+			m := newLabelRe.FindStringSubmatch(line)
+			if m == nil || strings.Contains(m[1], "@") || seen[m[1]] {
+				continue
+			}
+			kind := floatKind(m[1], line[len(m[0]):])
+			if kind == "" {
+				continue
+			}
+			seen[m[1]] = true
+			floats = append(floats, Float{Label: m[1], Kind: kind, Number: m[2], PageLabel: m[3]})
+		}
+		return sc.Err()
+	}
+	if err := read(auxPath, 0); err != nil {
+		return nil, err
+	}
+	for i := range floats {
+		floats[i].Page = abspages[floats[i].Label]
+	}
+	return floats, nil
+}
+
+// floatKind tells what kind of float a label is on, or "" if none
+func floatKind(label, rest string) string {
+	if m := floatAnchorRe.FindStringSubmatch(rest); m != nil {
+		return strings.TrimPrefix(strings.TrimSuffix(m[1], "*"), "sub")
+	}
+	switch {
+	case strings.HasPrefix(label, "fig:"):
+		return "figure"
+	case strings.HasPrefix(label, "tab:"):
+		return "table"
+	}
+	return ""
+}
+
+// NewLayoutReport reports on the layout of the completed build b from its
+// log, its aux files and the references in its sources. pageOf places the
+// references, and the labels of floats zref did not place, on physical
+// pages; without it floats are listed but none drifts. Floats more than
+// maxDrift pages from their first reference are flagged.
+func NewLayoutReport(b *Build, maxDrift int, pageOf PageLocator) (*LayoutReport, error) {
+	report := &LayoutReport{
+		Boxes:         ParseBoxWarnings(b.BuildLog),
+		FloatWarnings: ParseFloatWarnings(b.BuildLog),
+		Floats:        []Float{},
+		MaxDrift:      maxDrift,
+	}
+	for _, box := range report.Boxes {
+		if box.Kind == BoxOverfull {
+			report.Overfull++
+		} else {
+			report.Underfull++
+		}
+	}
+
+	base := strings.TrimSuffix(filepath.Base(b.MainFile), filepath.Ext(b.MainFile))
+	mainDir := filepath.Join(b.DirPath, filepath.Dir(b.MainFile))
+	auxPath := findFile([]string{filepath.Join(b.DirPath, OutDir(b)), mainDir}, base+".aux")
+	if auxPath == "" {
+		return report, nil
+	}
+	floats, err := ReadFloats(auxPath)
+	if err != nil {
+		return nil, err
+	}
+	if pageOf != nil {
+		refs, labels, err := findReferences(b.DirPath, OutDir(b))
+		if err != nil {
+			return nil, err
+		}
+		for i := range floats {
+			f := &floats[i]
+			// Lines inside a float are set where the float lands
+			if def, ok := labels[f.Label]; ok && f.Page == 0 {
+				f.Page = pageOf(def.file, def.line)
+			}
+			for _, ref := range refs[f.Label] {
+				if page := pageOf(ref.file, ref.line); page > 0 && (f.RefPage == 0 || page < f.RefPage) {
+					f.RefFile, f.RefLine, f.RefPage = ref.file, ref.line, page
+				}
+			}
+			if f.Page > 0 && f.RefPage > 0 {
+				f.Drift = f.Page - f.RefPage
+				f.Drifted = f.Drift > maxDrift || -f.Drift > maxDrift
+			}
+			if f.Drifted {
+				report.Drifted++
+			}
+		}
+	}
+	report.Floats = floats
+	return report, nil
+}
+
+type reference struct {
+	file string
+	line int
+}
+
+// findReferences finds where each label is referenced, and where it is
+// first defined, in the .tex files of the project in dir, leaving out its
+// output directory
+func findReferences(dir, outDir string) (map[string][]reference, map[string]reference, error) {
+	refs := make(map[string][]reference)
+	labels := make(map[string]reference)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || rel == outDir) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(rel) != ".tex" {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if c := commentStart(line); c >= 0 {
+				line = line[:c]
+			}
+			for _, m := range refRe.FindAllStringSubmatch(line, -1) {
+				for _, label := range strings.Split(m[1], ",") {
+					label = strings.TrimSpace(label)
+					refs[label] = append(refs[label], reference{file: rel, line: i + 1})
+				}
+			}
+			for _, m := range labelRe.FindAllStringSubmatch(line, -1) {
+				if _, ok := labels[m[1]]; !ok {
+					labels[m[1]] = reference{file: rel, line: i + 1}
+				}
+			}
+		}
+		return nil
+	})
+	for _, r := range refs {
+		sort.Slice(r, func(i, j int) bool {
+			if r[i].file != r[j].file {
+				return r[i].file < r[j].file
+			}
+			return r[i].line < r[j].line
+		})
+	}
+	return refs, labels, err
+}
+
+// commentStart returns where the comment of a LaTeX line starts, or -1
+func commentStart(line string) int {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '%':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,167 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LiveLogName is the file in a build directory that latexmk's output is
+// appended to while it runs. Build.BuildLog is only set once it finishes.
+const LiveLogName = ".build.log"
+
+// followInterval is how often FollowLog checks the live log for new output
+const followInterval = 500 * time.Millisecond
+
+// LiveLogPath returns the live log of the build in buildDir
+func LiveLogPath(buildDir string) string {
+	return filepath.Join(buildDir, LiveLogName)
+}
+
+// createLiveLog starts an empty live log for a compile attempt. The build
+// directory is writable by the build, so whatever an earlier attempt left at
+// the path is removed and the log created afresh rather than opened.
+func createLiveLog(buildDir string) (*os.File, error) {
+	path := LiveLogPath(buildDir)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+}
+
+// openLiveLogFile opens the live log of the build in buildDir for reading
+func openLiveLogFile(buildDir string) (*os.File, os.FileInfo, error) {
+	return openBuildFile(LiveLogPath(buildDir))
+}
+
+// openLiveLog starts the live log of a compile attempt and returns it with a
+// function that closes it. Output is redacted by r before it reaches the
+// disk, so the held-back end of the last line is written on close. A live
+// log that cannot be created is logged and skipped, with a nil writer; it
+// never fails the build.
+func openLiveLog(build *Build, buildDir string, r *Redactor) (io.Writer, func()) {
+	f, err := createLiveLog(buildDir)
+	if err != nil {
+		log.Printf("Live log unavailable for build %s: %v", build.ID, err)
+		return nil, func() {}
+	}
+	live := &liveLog{w: r.Writer(f)}
+	return live, func() {
+		live.mu.Lock()
+		live.w.Flush()
+		live.mu.Unlock()
+		f.Close()
+	}
+}
+
+// liveLog serializes writes to a live log. Stdout and stderr are copied into
+// it from separate goroutines, and a RedactWriter keeps state between writes.
+type liveLog struct {
+	mu sync.Mutex
+	w  *RedactWriter
+}
+
+func (l *liveLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// ReadLiveLog returns the output latexmk has produced so far for the build
+// in buildDir. A build that has not started has an empty log.
+func ReadLiveLog(buildDir string) ([]byte, error) {
+	f, _, err := openLiveLogFile(buildDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// TailLines returns the last n lines of data, or all of it if n <= 0
+func TailLines(data []byte, n int) []byte {
+	if n <= 0 {
+		return data
+	}
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	for i := end - 1; i >= 0; i-- {
+		if data[i] != '\n' {
+			continue
+		}
+		if n--; n == 0 {
+			return data[i+1:]
+		}
+	}
+	return data
+}
+
+// FollowLog copies output appended to the live log of the build in buildDir
+// past offset to w until finished reports true or ctx is done. The output of
+// a new attempt, which restarts the log, is copied from its beginning.
+func FollowLog(ctx context.Context, w io.Writer, buildDir string, offset int64, finished func() bool) error {
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		// Check before reading so output written just before the build
+		// finished is still copied
+		done := finished()
+
+		n, err := copyLiveLog(w, buildDir, offset)
+		if err != nil {
+			return err
+		}
+		offset = n
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// copyLiveLog copies the live log from offset to w and returns the offset
+// to continue from
+func copyLiveLog(w io.Writer, buildDir string, offset int64) (int64, error) {
+	f, info, err := openLiveLogFile(buildDir)
+	if os.IsNotExist(err) {
+		return offset, nil
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	if info.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	n, err := io.Copy(w, f)
+	return offset + n, err
+}
+
+// teeLive collects a process's output in buf and also appends it to the
+// live log, if there is one
+func teeLive(buf *bytes.Buffer, live io.Writer) io.Writer {
+	if live == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, live)
+}
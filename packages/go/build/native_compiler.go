@@ -2,6 +2,8 @@ package build
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,11 +11,14 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
 )
 
 // NativeCompiler compiles LaTeX directly on the filesystem (no Docker)
 type NativeCompiler struct {
-	workDir string
+	workDir  string
+	redactor *Redactor // masks secrets in build logs; nil keeps them as printed
 }
 
 // NewNativeCompiler creates a new native compiler
@@ -23,6 +28,12 @@ func NewNativeCompiler(workDir string) (*NativeCompiler, error) {
 	}, nil
 }
 
+// SetRedactor masks the secrets r matches in the logs of builds compiled
+// from now on
+func (c *NativeCompiler) SetRedactor(r *Redactor) {
+	c.redactor = r
+}
+
 // Close is a no-op for native compiler
 func (c *NativeCompiler) Close() error {
 	return nil
@@ -37,10 +48,13 @@ func (c *NativeCompiler) Compile(build *Build) error {
 		return fmt.Errorf("failed to create build directory: %w", err)
 	}
 
-	// Unzip source files
-	unzipCmd := exec.Command("unzip", "-o", filepath.Join(buildDir, "source.zip"), "-d", buildDir)
-	if output, err := unzipCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to unzip source: %w\n%s", err, string(output))
+	// Unzip source files (delta-sync builds upload files directly and have no archive)
+	zipPath := filepath.Join(buildDir, "source.zip")
+	if _, err := os.Stat(zipPath); err == nil {
+		unzipCmd := exec.Command("unzip", "-o", zipPath, "-d", buildDir)
+		if output, err := unzipCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to unzip source: %w\n%s", err, string(output))
+		}
 	}
 
 	// Determine engine flag
@@ -62,36 +76,124 @@ func (c *NativeCompiler) Compile(build *Build) error {
 	}
 
 	// Build latexmk args
-	outputDir := filepath.Join(buildDir, "output")
+	outputDir := filepath.Join(buildDir, filepath.FromSlash(OutDir(build)))
 	args := []string{
 		engineFlag,
 		"-interaction=nonstopmode",
 		"-synctex=1",
-		"-outdir=" + outputDir,
+		"-output-directory=" + outputDir,
 	}
 
-	if build.ShellEscape {
-		args = append(args, "-shell-escape")
+	autoShell := AutoShellEscape(build, buildDir)
+	shellArgs, shellEnv := shellEscapeArgs(build, os.Getenv("PATH"))
+	args = append(args, shellArgs...)
+
+	if build.Reproducible {
+		hash, err := ManifestHash(buildDir, OutDir(build))
+		if err != nil {
+			return err
+		}
+		build.ManifestHash = hash
+	}
+	reproArgs, reproEnv := reproducibleArgs(build)
+	args = append(args, reproArgs...)
+	args = append(args, indexArgs(build)...)
+
+	// Figures and preprocessors run arbitrary code, which only full
+	// shell-escape builds may do outside a sandbox
+	config, err := LoadProjectConfig(buildDir)
+	if err == nil && config.runsCode() && !build.ShellEscape {
+		err = ErrPreprocessorsNeedSandbox
+	}
+	if err == nil && build.ShellRestricted {
+		err = checkShellShim()
+	}
+	if err != nil {
+		build.Status = StatusFailed
+		build.ErrorMessage = err.Error()
+		build.UpdatedAt = time.Now()
+		return err
 	}
+	hooks, figureNote := restoreFigures(c.workDir, build, buildDir, config.Figures)
+	hooks = append(hooks, config.Preprocess...)
 
-	args = append(args, mainFileName)
+	// Run latexmk from the main file's directory, killing it once the
+	// build's timeout passes
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout(build))
+	defer cancel()
+	env := append(buildopts.EnvList(build.Env), reproEnv...)
+	env = append(env, shellEnv...)
 
-	// Run latexmk from the main file's directory
-	cmd := exec.Command("latexmk", args...)
-	cmd.Dir = mainFileDir
+	live, closeLive := openLiveLog(build, buildDir, c.redactor)
+	defer closeLive()
 
 	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	stdoutW, stderrW := teeLive(&stdout, live), teeLive(&stderr, live)
+	latexmk := func(extra ...string) error {
+		cmdArgs := append(append(args[:len(args):len(args)], extra...), mainFileName)
+		cmd := exec.CommandContext(ctx, "latexmk", cmdArgs...)
+		killProcessGroup(cmd)
+		// The engine latexmk started may outlive it and hold the output pipes
+		cmd.WaitDelay = 5 * time.Second
+		cmd.Dir = mainFileDir
+		if len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		cmd.Stdout = stdoutW
+		cmd.Stderr = stderrW
+		return cmd.Run()
+	}
 
-	err := cmd.Run()
+	stdout.WriteString(figureNote)
+	err = runHooks(ctx, hooks, buildDir, append(os.Environ(), env...), stdoutW)
+	if err == nil {
+		// Keyed after the preprocessors, whose output the preamble may load
+		preamble := preambleKey(build, buildDir, "")
+		if preamble != "" {
+			defer func() { finishPreamble(c.workDir, build, preamble, mainFileDir, build.BuildLog) }()
+		}
+		fast := preamble != "" && (restorePreamble(c.workDir, build, preamble, mainFileDir) ||
+			dumpPreamble(ctx, mainFileDir, mainFileName, append(os.Environ(), env...)))
+		if !fast {
+			err = latexmk()
+		} else {
+			fmt.Fprintln(stdoutW, preambleNote)
+			// A format that no longer matches what the body expects fails
+			// the build; start over without it
+			if err = latexmk(preambleArgs()...); err != nil && ctx.Err() == nil {
+				fmt.Fprintln(stdoutW, preambleFallbackNote)
+				os.Remove(filepath.Join(mainFileDir, PreambleFormat+".fmt"))
+				err = latexmk("-gg")
+			}
+		}
+	}
 	logContent := stdout.String() + stderr.String()
+	if autoShell != "" {
+		logContent = autoShellNote(autoShell) + logContent
+	}
+	if build.Reproducible {
+		logContent = reproducibleNote(build) + logContent
+	}
+	logContent = c.redactor.Redact(logContent)
 
 	if len(logContent) > MaxLogSize {
 		logContent = logContent[:MaxLogSize] + "\n[LOG TRUNCATED - exceeded 10MB]"
 	}
 	build.BuildLog = logContent
 
+	if ctx.Err() == context.DeadlineExceeded {
+		build.Status = StatusFailed
+		build.ErrorMessage = timeoutMessage(build)
+		build.UpdatedAt = time.Now()
+		return errCompileTimeout
+	}
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		build.Status = StatusFailed
+		build.ErrorMessage = hookErr.Message()
+		build.UpdatedAt = time.Now()
+		return err
+	}
 	if err != nil {
 		build.Status = StatusFailed
 		build.ErrorMessage = fmt.Sprintf("Compilation failed: %v", err)
@@ -99,46 +201,18 @@ func (c *NativeCompiler) Compile(build *Build) error {
 		return fmt.Errorf("compilation failed: %w", err)
 	}
 
-	// Check for output PDF - use main file name without extension
-	mainBase := strings.TrimSuffix(mainFileName, filepath.Ext(mainFileName))
-	pdfPath := filepath.Join(outputDir, mainBase+".pdf")
-	if _, err := os.Stat(pdfPath); err != nil {
-		// Fallback: try output.pdf
-		pdfPath = filepath.Join(buildDir, "output", "output.pdf")
-	}
-	if _, err := os.Stat(pdfPath); err == nil {
-		// Copy to build dir root for consistency
-		destPath := filepath.Join(buildDir, "output.pdf")
-		if err := copyFile(pdfPath, destPath); err == nil {
-			build.PDFPath = destPath
-		} else {
-			build.PDFPath = pdfPath
-		}
+	collectOutputs(build, buildDir, outputDir)
+	if build.PDFPath != "" {
 		build.Status = StatusCompleted
 	} else {
 		build.Status = StatusFailed
 		build.ErrorMessage = "PDF not generated"
 	}
-
-	// Check for SyncTeX - use main file name without extension
-	synctexPath := filepath.Join(outputDir, mainBase+".synctex.gz")
-	log.Printf("Looking for SyncTeX at: %s", synctexPath)
-	if _, err := os.Stat(synctexPath); err != nil {
-		// Fallback: try output.synctex.gz
-		synctexPath = filepath.Join(outputDir, "output.synctex.gz")
-		log.Printf("Fallback: looking for SyncTeX at: %s", synctexPath)
+	if build.SyncTeXPath == "" {
+		log.Printf("SyncTeX not found in %s", outputDir)
 	}
-	if _, err := os.Stat(synctexPath); err == nil {
-		destPath := filepath.Join(buildDir, "output.synctex.gz")
-		if err := copyFile(synctexPath, destPath); err == nil {
-			build.SyncTeXPath = destPath
-			log.Printf("SyncTeX copied to: %s", destPath)
-		} else {
-			build.SyncTeXPath = synctexPath
-			log.Printf("SyncTeX using original path: %s", synctexPath)
-		}
-	} else {
-		log.Printf("SyncTeX not found: %v", err)
+	if build.Status == StatusCompleted {
+		storeFigures(c.workDir, build, buildDir, config.Figures)
 	}
 
 	build.UpdatedAt = time.Now()
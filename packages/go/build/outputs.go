@@ -0,0 +1,55 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// OutDir returns the build's latexmk output directory, relative to the
+// project root. Builds created before OutDir existed use the default.
+func OutDir(build *Build) string {
+	if build.OutDir == "" {
+		return buildopts.DefaultOutDir
+	}
+	return build.OutDir
+}
+
+// findFile returns the first of names that exists in dirs, searching each
+// dir in order, or "" if none does
+func findFile(dirs []string, names ...string) string {
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// collectOutputs finds the PDF and SyncTeX file of a finished build, looking
+// in the output directory first, and copies them to output.pdf and
+// output.synctex.gz in the build root where the API serves them from
+func collectOutputs(build *Build, buildDir, outputDir string) {
+	base := strings.TrimSuffix(filepath.Base(build.MainFile), filepath.Ext(build.MainFile))
+	dirs := []string{outputDir, buildDir}
+
+	build.PDFPath = collectOutput(findFile(dirs, base+".pdf", "output.pdf"), filepath.Join(buildDir, "output.pdf"))
+	build.SyncTeXPath = collectOutput(findFile(dirs, base+".synctex.gz", "output.synctex.gz"), filepath.Join(buildDir, "output.synctex.gz"))
+}
+
+// collectOutput copies src to dest and returns the path to serve: dest, or
+// src if the copy failed, or "" if there is no src
+func collectOutput(src, dest string) string {
+	if src == "" || src == dest {
+		return src
+	}
+	if err := copyFile(src, dest); err != nil {
+		return src
+	}
+	return dest
+}
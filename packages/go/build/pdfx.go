@@ -0,0 +1,210 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+)
+
+// PDFCheckTimeout bounds the post-build font check and PDF/X conversion
+const PDFCheckTimeout = 2 * time.Minute
+
+// cmykProfiles are where ghostscript's default CMYK ICC profile is
+// installed, which PDF/X-1a output intents need
+var cmykProfiles = []string{
+	"/usr/share/color/icc/ghostscript/default_cmyk.icc",
+	"/usr/share/ghostscript/iccprofiles/default_cmyk.icc",
+	"/usr/local/share/ghostscript/iccprofiles/default_cmyk.icc",
+	"/opt/homebrew/share/ghostscript/iccprofiles/default_cmyk.icc",
+}
+
+// pdfxDef is the PostScript ghostscript runs before the PDF to mark its
+// output as PDF/X-1a with a CMYK output intent
+const pdfxDef = `%%!
+/ICCProfile (%s) def
+[ /GTS_PDFXVersion (PDF/X-1:2001) /GTS_PDFXConformance (PDF/X-1a:2001) /Trapped /False /DOCINFO pdfmark
+[/_objdef {icc_PDFX} /type /stream /OBJ pdfmark
+[{icc_PDFX} <</N 4>> /PUT pdfmark
+[{icc_PDFX} ICCProfile (r) file /PUT pdfmark
+[/_objdef {OutputIntent_PDFX} /type /dict /OBJ pdfmark
+[{OutputIntent_PDFX} <<
+  /Type /OutputIntent
+  /S /GTS_PDFX
+  /OutputCondition (Commercial and specialty printing)
+  /OutputConditionIdentifier (CGATS TR 001)
+  /RegistryName (http://www.color.org)
+  /Info (CMYK)
+  /DestOutputProfile {icc_PDFX}
+>> /PUT pdfmark
+[{Catalog} <</OutputIntents [ {OutputIntent_PDFX} ]>> /PUT pdfmark
+`
+
+// CheckFonts lists the fonts of the PDF at pdfPath with pdffonts and
+// reports those it does not embed
+func CheckFonts(ctx context.Context, pdfPath string) (*api.FontCheck, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "pdffonts", pdfPath)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pdffonts failed: %w\n%s", err, stderr.String())
+	}
+
+	check := &api.FontCheck{Fonts: ParseFonts(out)}
+	for _, f := range check.Fonts {
+		if !f.Embedded {
+			check.Unembedded = append(check.Unembedded, f.Name)
+		}
+		if f.Type == "Type 3" {
+			check.Type3 = append(check.Type3, f.Name)
+		}
+	}
+	check.OK = len(check.Unembedded) == 0
+	return check, nil
+}
+
+// ParseFonts reads the output of pdffonts: two header lines, then a row per
+// font of name, type (which may hold spaces), encoding, emb, sub, uni and
+// object ID
+func ParseFonts(out []byte) []api.Font {
+	fonts := []api.Font{}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for i := 0; sc.Scan(); i++ {
+		fields := strings.Fields(sc.Text())
+		if i < 2 || len(fields) < 8 {
+			continue
+		}
+		n := len(fields)
+		fonts = append(fonts, api.Font{
+			Name:     fields[0],
+			Type:     strings.Join(fields[1:n-6], " "),
+			Embedded: fields[n-5] == "yes",
+			Subset:   fields[n-4] == "yes",
+		})
+	}
+	return fonts
+}
+
+// FindCMYKProfile returns the CMYK ICC profile PDF/X conversions use:
+// profile if set, else ghostscript's default, or "" if there is none
+func FindCMYKProfile(profile string) string {
+	if profile != "" {
+		return profile
+	}
+	for _, p := range cmykProfiles {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	matches, _ := filepath.Glob("/usr/share/ghostscript/*/iccprofiles/default_cmyk.icc")
+	if len(matches) > 0 {
+		return matches[0]
+	}
+	return ""
+}
+
+// ConvertPDFX writes a PDF/X-1a:2001 version of the PDF at src to dst with
+// ghostscript: CMYK colour, every font embedded, transparency flattened, and
+// an output intent with the CMYK ICC profile at iccProfile
+func ConvertPDFX(ctx context.Context, src, dst, iccProfile string) error {
+	if _, err := exec.LookPath("gs"); err != nil {
+		return fmt.Errorf("ghostscript not installed")
+	}
+	if iccProfile == "" {
+		return fmt.Errorf("no CMYK ICC profile found")
+	}
+	if strings.ContainsAny(iccProfile, "()\\") {
+		return fmt.Errorf("unsupported ICC profile path %q", iccProfile)
+	}
+
+	def, err := os.CreateTemp(filepath.Dir(dst), "pdfx-def-*.ps")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(def.Name())
+	if _, err := fmt.Fprintf(def, pdfxDef, iccProfile); err != nil {
+		def.Close()
+		return err
+	}
+	if err := def.Close(); err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "gs",
+		"-dPDFX", "-dBATCH", "-dNOPAUSE", "-dNOOUTERSAVE", "-dSAFER",
+		"--permit-file-read="+iccProfile,
+		"-sDEVICE=pdfwrite",
+		"-dCompatibilityLevel=1.3",
+		"-dPDFSETTINGS=/prepress",
+		"-sColorConversionStrategy=CMYK",
+		"-sProcessColorModel=DeviceCMYK",
+		"-dEmbedAllFonts=true",
+		"-dSubsetFonts=true",
+		"-sOutputFile="+dst,
+		def.Name(), src,
+	)
+	cmd.Stdout = &stderr
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("ghostscript failed: %w\n%s", err, TailLines(stderr.Bytes(), 20))
+	}
+	return nil
+}
+
+// CheckPDF is the post-build step of a completed build: it records which
+// fonts the PDF embeds and, if the build asked for it, converts the PDF to
+// PDF/X-1a as output.pdfx.pdf in the build directory, checking the fonts of
+// the conversion too. Neither fails the build; a missing pdffonts leaves
+// FontCheck unset and a failed conversion is recorded in PDFXError.
+//
+// The build could have left anything in its directory, so the PDF must be a
+// regular file and the conversion is written next to the build directory and
+// renamed into it, replacing whatever is at output.pdfx.pdf.
+func CheckPDF(ctx context.Context, b *Build, iccProfile string) {
+	if b.Status != StatusCompleted || b.PDFPath == "" {
+		return
+	}
+	if info, err := os.Lstat(b.PDFPath); err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	if _, err := exec.LookPath("pdffonts"); err == nil {
+		if check, err := CheckFonts(ctx, b.PDFPath); err == nil {
+			b.FontCheck = check
+		}
+	}
+	if !b.PDFX {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.DirPath), "pdfx-*.pdf")
+	if err != nil {
+		b.PDFXError = err.Error()
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := ConvertPDFX(ctx, b.PDFPath, tmp.Name(), FindCMYKProfile(iccProfile)); err != nil {
+		b.PDFXError = err.Error()
+		return
+	}
+	if check, err := CheckFonts(ctx, tmp.Name()); err == nil && !check.OK {
+		b.PDFXError = "fonts not embedded in PDF/X output: " + strings.Join(check.Unembedded, ", ")
+		return
+	}
+	dst := filepath.Join(b.DirPath, "output.pdfx.pdf")
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		b.PDFXError = err.Error()
+		return
+	}
+	b.PDFXPath = dst
+}
@@ -0,0 +1,329 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+const (
+	// DefaultPoolSize is how many idle compile containers are kept warm
+	DefaultPoolSize = 2
+	// DefaultPoolMaxUses is how many builds a pooled container runs before
+	// it is replaced
+	DefaultPoolMaxUses = 20
+)
+
+const (
+	// poolMountDir is where pooled containers see their slot directory
+	poolMountDir = "/builds"
+	// poolSlotDir holds the slot directories under the work directory; the
+	// leading dot keeps cleanup from treating it as a user
+	poolSlotDir = ".pool"
+	// poolJobName is where a build's directory sits in a slot while it runs
+	poolJobName = "job"
+)
+
+// containerPool keeps idle compile containers running so builds skip
+// container startup. Each pooled container bind-mounts an empty slot
+// directory of its own; a build's directory is moved into the slot for the
+// length of its exec and moved back afterwards, so a container only ever sees
+// the build it is running.
+type containerPool struct {
+	c       *DockerCompiler
+	size    int
+	maxUses int
+
+	mu      sync.Mutex
+	idle    []*pooledContainer
+	filling bool
+	closed  bool
+}
+
+type pooledContainer struct {
+	id    string
+	image verifiedImage // image the container was created from
+	dir   string        // slot directory mounted at poolMountDir
+	uses  int
+}
+
+func newContainerPool(c *DockerCompiler, size, maxUses int) *containerPool {
+	// Slots left by an earlier run belong to containers that are gone
+	if err := os.RemoveAll(filepath.Join(c.workDir, poolSlotDir)); err != nil {
+		log.Printf("Warning: failed to clear pool slots: %v", err)
+	}
+	return &containerPool{c: c, size: size, maxUses: maxUses}
+}
+
+// poolable reports whether build may share a warm container. Builds that can
+// run commands or reach the network get a fresh one.
+func poolable(build *Build) bool {
+	return !build.ShellEscape && !build.ShellRestricted && !build.Network
+}
+
+// run compiles build in a pooled container and returns its log. A build that
+// fails to start or times out takes its container down with it.
+func (p *containerPool) run(build *Build, buildDir string, img verifiedImage, latexmk string, env []string, live io.Writer) (string, error) {
+	ctx := context.Background()
+	pc, err := p.get(ctx, img)
+	if err != nil {
+		return "", err
+	}
+
+	job, err := pc.mount(buildDir)
+	if err != nil {
+		p.put(pc)
+		return "", err
+	}
+	logContent, err := p.exec(ctx, pc, build, latexmk, env, live)
+	if err != nil {
+		// The container goes before the directory is moved back, so
+		// nothing left running in it can write there
+		p.stop(pc)
+	}
+	if uerr := pc.unmount(job, buildDir); uerr != nil {
+		log.Printf("Warning: failed to move build %s out of pooled container: %v", build.ID, uerr)
+		return "", uerr
+	}
+	if err != nil {
+		p.removeSlot(pc)
+		p.refill()
+		return "", err
+	}
+	p.put(pc)
+	return logContent, nil
+}
+
+// exec runs latexmk in pc's job directory and waits for it
+func (p *containerPool) exec(ctx context.Context, pc *pooledContainer, build *Build, latexmk string, env []string, live io.Writer) (string, error) {
+	dir := path.Join(poolMountDir, poolJobName)
+	env = append(env, "openin_any=p", "openout_any=p", "TEXMFOUTPUT="+dir)
+	exec, err := p.c.dockerClient.ContainerExecCreate(ctx, pc.id, container.ExecOptions{
+		User:         p.c.profile.User,
+		Env:          env,
+		WorkingDir:   dir,
+		Cmd:          []string{"bash", "-c", compileScript(latexmk)},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create exec: %w", err)
+	}
+	hijacked, err := p.c.dockerClient.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to start exec: %w", err)
+	}
+	defer hijacked.Close()
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		stdcopy.StdCopy(teeLive(&stdout, live), teeLive(&stderr, live), hijacked.Reader)
+		close(done)
+	}()
+
+	timer := time.NewTimer(Timeout(build))
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return "", errCompileTimeout
+	}
+	return stdout.String() + stderr.String(), nil
+}
+
+// mount moves buildDir into pc's slot and leaves a symlink in its place, so
+// the live log and progress stay readable at the usual path
+func (pc *pooledContainer) mount(buildDir string) (string, error) {
+	job := filepath.Join(pc.dir, poolJobName)
+	if err := os.Rename(buildDir, job); err != nil {
+		return "", fmt.Errorf("failed to move build into pooled container: %w", err)
+	}
+	if err := os.Symlink(job, buildDir); err != nil {
+		if rerr := os.Rename(job, buildDir); rerr != nil {
+			log.Printf("Warning: failed to move build back from %s: %v", job, rerr)
+		}
+		return "", fmt.Errorf("failed to link pooled build: %w", err)
+	}
+	return job, nil
+}
+
+// unmount puts a build's directory back where mount found it
+func (pc *pooledContainer) unmount(job, buildDir string) error {
+	if err := os.Remove(buildDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(job, buildDir)
+}
+
+// get takes an idle container created from img, or starts one if there is
+// none, and tops the pool up in the background
+func (p *containerPool) get(ctx context.Context, img verifiedImage) (*pooledContainer, error) {
+	var pc *pooledContainer
+	p.mu.Lock()
+	for len(p.idle) > 0 && pc == nil {
+		pc = p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if pc.image.id != img.id {
+			go p.remove(pc)
+			pc = nil
+		}
+	}
+	p.mu.Unlock()
+
+	if pc == nil {
+		var err error
+		if pc, err = p.create(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+	pc.uses++
+	p.refill()
+	return pc, nil
+}
+
+// put returns a container to the pool, or removes it once it has run
+// maxUses builds or the pool is full
+func (p *containerPool) put(pc *pooledContainer) {
+	p.mu.Lock()
+	if p.closed || pc.uses >= p.maxUses || len(p.idle) >= p.size {
+		p.mu.Unlock()
+		p.remove(pc)
+		p.refill()
+		return
+	}
+	p.idle = append(p.idle, pc)
+	p.mu.Unlock()
+}
+
+// discard removes a container whose build went wrong
+func (p *containerPool) discard(pc *pooledContainer) {
+	p.remove(pc)
+	p.refill()
+}
+
+// refill starts idle containers until the pool is full. Only one refill
+// runs at a time.
+func (p *containerPool) refill() {
+	p.mu.Lock()
+	if p.filling || p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.filling = true
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.filling = false
+			p.mu.Unlock()
+		}()
+		for {
+			p.mu.Lock()
+			full := p.closed || len(p.idle) >= p.size
+			p.mu.Unlock()
+			if full {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			img, err := p.c.verifyImage(ctx)
+			var pc *pooledContainer
+			if err == nil {
+				pc, err = p.create(ctx, img)
+			}
+			cancel()
+			if err != nil {
+				log.Printf("Warning: failed to warm compile container: %v", err)
+				return
+			}
+			p.put(pc)
+		}
+	}()
+}
+
+// create starts an idle container that waits for builds, with a fresh slot
+// directory as its only mount
+func (p *containerPool) create(ctx context.Context, img verifiedImage) (*pooledContainer, error) {
+	slots := filepath.Join(p.c.workDir, poolSlotDir)
+	if err := os.MkdirAll(slots, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create pool directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(slots, "slot-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool slot: %w", err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := p.c.prepareMount(dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	resp, err := p.c.dockerClient.ContainerCreate(ctx,
+		p.c.containerConfig(img, []string{"sleep", "infinity"}, nil, poolMountDir, map[string]string{
+			"treefrog.pool": "true",
+		}),
+		p.c.hostConfig(dir, poolMountDir, "none"), nil, nil, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create pooled container: %w", err)
+	}
+	if err := p.c.dockerClient.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		p.remove(&pooledContainer{id: resp.ID, dir: dir})
+		return nil, fmt.Errorf("failed to start pooled container: %w", err)
+	}
+	return &pooledContainer{id: resp.ID, image: img, dir: dir}, nil
+}
+
+// stop deletes a pooled container, leaving its slot directory
+func (p *containerPool) stop(pc *pooledContainer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := p.c.dockerClient.ContainerRemove(ctx, pc.id, container.RemoveOptions{Force: true}); err != nil {
+		log.Printf("Warning: failed to remove pooled container %s: %v", pc.id, err)
+	}
+}
+
+// remove deletes a pooled container and its slot directory. The slot must
+// not hold a build.
+func (p *containerPool) remove(pc *pooledContainer) {
+	p.stop(pc)
+	p.removeSlot(pc)
+}
+
+// removeSlot deletes pc's slot directory
+func (p *containerPool) removeSlot(pc *pooledContainer) {
+	if pc.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(pc.dir); err != nil {
+		log.Printf("Warning: failed to remove pool slot %s: %v", pc.dir, err)
+	}
+}
+
+// close removes the idle containers; busy ones are removed when their build
+// finishes
+func (p *containerPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, pc := range idle {
+		p.remove(pc)
+	}
+}
@@ -0,0 +1,214 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PreambleFormat is the format a document's preamble is dumped to with
+// mylatexformat, in the directory the engine runs in. Compiling with it
+// skips the preamble, so edits to the body recompile much faster.
+const PreambleFormat = "treefrog-preamble"
+
+const (
+	// MaxFormatsPerUser caps how many precompiled preambles a user keeps;
+	// the least recently used go first
+	MaxFormatsPerUser = 8
+	// FormatCacheTTL is how long a precompiled preamble is kept after it was
+	// last used
+	FormatCacheTTL = 7 * 24 * time.Hour
+)
+
+// formatCacheDir is the directory under a compiler's work directory that
+// holds precompiled preambles, per user, by preamble hash
+const formatCacheDir = ".formats"
+
+// maxPreambleSource caps how much of the main file and each file its
+// preamble loads is hashed
+const maxPreambleSource = 1024 * 1024
+
+// maxPreambleFormat caps the size of a format stored in the cache
+const maxPreambleFormat = 256 * 1024 * 1024
+
+// Log lines of builds that use a precompiled preamble
+const (
+	preambleNote         = "treefrog: compiling with the precompiled preamble"
+	preambleFallbackNote = "treefrog: precompiled preamble failed; compiling without it"
+)
+
+// preambleFileRe finds the project files a preamble may load
+var preambleFileRe = regexp.MustCompile(`\\(?:input|include|usepackage|RequirePackage|documentclass|LoadClass)\s*(?:\[[^\]]*\])?\s*\{([^}]*)\}`)
+
+// preambleKey returns the key the build's precompiled preamble is cached
+// under, or "" if the build cannot use one. The key covers the preamble of
+// the main file and the project files it loads directly, so any change to
+// them dumps a new format. Only pdflatex formats hold everything a preamble
+// sets up, and reproducible builds always compile from scratch.
+func preambleKey(build *Build, dir, imageDigest string) string {
+	if build.Engine != EnginePDFLaTeX || build.Reproducible {
+		return ""
+	}
+	src, err := readProjectFile(dir, build.MainFile, maxPreambleSource)
+	if err != nil {
+		return ""
+	}
+	text := string(src)
+	end := strings.Index(text, `\begin{document}`)
+	if end < 0 || !strings.Contains(text[:end], `\documentclass`) {
+		return ""
+	}
+	preamble := text[:end]
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00", imageDigest, build.Engine, preamble)
+	mainDir := path.Dir(build.MainFile)
+	for _, m := range preambleFileRe.FindAllStringSubmatch(preamble, -1) {
+		for _, name := range strings.Split(m[1], ",") {
+			name = strings.TrimSpace(name)
+			for _, ext := range []string{"", ".tex", ".sty", ".cls"} {
+				file := path.Join(mainDir, name+ext)
+				if data, err := readProjectFile(dir, file, maxPreambleSource); err == nil {
+					fmt.Fprintf(h, "%s\x00%d\x00", file, len(data))
+					h.Write(data)
+					break
+				}
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func formatCachePath(workDir string, build *Build, key string) string {
+	return filepath.Join(workDir, formatCacheDir, build.UserID, key+".fmt")
+}
+
+// restorePreamble copies the cached format with key into engineDir and
+// reports whether there was one
+func restorePreamble(workDir string, build *Build, key, engineDir string) bool {
+	cached := formatCachePath(workDir, build, key)
+	if err := copyFile(cached, filepath.Join(engineDir, PreambleFormat+".fmt")); err != nil {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	return true
+}
+
+// dumpPreambleArgs is the command that dumps the preamble of mainFile
+func dumpPreambleArgs(mainFile string) []string {
+	return []string{"pdflatex", "-ini", "-interaction=batchmode", "-jobname=" + PreambleFormat, "&pdflatex", "mylatexformat.ltx", mainFile}
+}
+
+// dumpPreamble dumps the preamble of mainFile into engineDir and reports
+// whether it succeeded. A preamble mylatexformat cannot dump is compiled
+// the usual way.
+func dumpPreamble(ctx context.Context, engineDir, mainFile string, env []string) bool {
+	args := dumpPreambleArgs(mainFile)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	killProcessGroup(cmd)
+	cmd.Dir = engineDir
+	cmd.Env = env
+	err := cmd.Run()
+	os.Remove(filepath.Join(engineDir, PreambleFormat+".log"))
+	if err != nil {
+		os.Remove(filepath.Join(engineDir, PreambleFormat+".fmt"))
+		return false
+	}
+	return true
+}
+
+// preambleArgs are the latexmk arguments that compile with the dumped
+// preamble
+func preambleArgs() []string {
+	return []string{"-pdflatex=pdflatex -fmt=" + PreambleFormat + " %O %S"}
+}
+
+// preambleScript returns the container script lines that compile with a
+// precompiled preamble: the one restored from the cache, or one dumped
+// now. If the fast compile fails, the build starts over with force, which
+// compiles without it.
+func preambleScript(mainFile, fast, plain, force string) string {
+	format := PreambleFormat + ".fmt"
+	dump := dumpPreambleArgs(mainFile)
+	for i, arg := range dump {
+		dump[i] = shellQuote(arg)
+	}
+	return fmt.Sprintf(`if [ ! -f %[1]s ]; then
+  %[2]s >/dev/null 2>&1 || rm -f %[1]s
+  rm -f %[3]s.log
+fi
+if [ -f %[1]s ]; then
+  echo '%[4]s'
+  %[5]s || { echo '%[6]s'; rm -f %[1]s; %[8]s; }
+else
+  %[7]s
+fi`, format, strings.Join(dump, " "), PreambleFormat, preambleNote, fast, preambleFallbackNote, plain, force)
+}
+
+// finishPreamble caches the format a completed build compiled with and
+// removes it from engineDir. A format the build had to fall back from is
+// dropped from the cache instead.
+func finishPreamble(workDir string, build *Build, key, engineDir, log string) {
+	format := filepath.Join(engineDir, PreambleFormat+".fmt")
+	defer os.Remove(format)
+
+	cached := formatCachePath(workDir, build, key)
+	if strings.Contains(log, preambleFallbackNote) {
+		os.Remove(cached)
+		return
+	}
+	if build.Status != StatusCompleted {
+		return
+	}
+	if _, err := os.Stat(cached); err == nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cached), 0755); err != nil {
+		return
+	}
+	tmp := cached + ".tmp"
+	if err := copyBuildFile(format, tmp, maxPreambleFormat); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	os.Rename(tmp, cached)
+	pruneFormats(filepath.Dir(cached))
+}
+
+// pruneFormats drops the formats in a user's cache that have not been used
+// for FormatCacheTTL, and the least recently used ones past
+// MaxFormatsPerUser
+func pruneFormats(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type format struct {
+		name string
+		used time.Time
+	}
+	var formats []format
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && strings.HasSuffix(entry.Name(), ".fmt") {
+			formats = append(formats, format{entry.Name(), info.ModTime()})
+		}
+	}
+	sort.Slice(formats, func(i, j int) bool { return formats[i].used.After(formats[j].used) })
+
+	cutoff := time.Now().Add(-FormatCacheTTL)
+	for i, f := range formats {
+		if i >= MaxFormatsPerUser || f.used.Before(cutoff) {
+			os.Remove(filepath.Join(dir, f.name))
+		}
+	}
+}
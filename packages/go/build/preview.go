@@ -0,0 +1,115 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preview sizes rendered for each page
+const (
+	PreviewThumb = "thumb"
+	PreviewFull  = "full"
+
+	DefaultPreviewPages = 5
+
+	// PreviewTimeout bounds each pdfinfo and pdftoppm run, so a PDF that
+	// makes poppler spin does not hold a worker
+	PreviewTimeout = 30 * time.Second
+)
+
+// previewArgs maps a preview size to its pdftoppm scaling flags
+var previewArgs = map[string][]string{
+	PreviewThumb: {"-scale-to", "256"},
+	PreviewFull:  {"-r", "150"},
+}
+
+// ValidPreviewSizes lists the sizes accepted by PreviewPath
+var ValidPreviewSizes = map[string]bool{
+	PreviewThumb: true,
+	PreviewFull:  true,
+}
+
+// PreviewPath returns where the PNG for a page (1-based) and size is stored
+func PreviewPath(buildDir string, page int, size string) string {
+	name := strconv.Itoa(page)
+	if size == PreviewThumb {
+		name += "_thumb"
+	}
+	return filepath.Join(buildDir, "pages", name+".png")
+}
+
+// RenderPreviews rasterizes the first maxPages pages of pdfPath at thumbnail
+// and full resolution using pdftoppm. It returns the number of pages rendered.
+func RenderPreviews(pdfPath, buildDir string, maxPages int) (int, error) {
+	if maxPages <= 0 {
+		return 0, nil
+	}
+
+	total, err := PDFPageCount(pdfPath)
+	if err != nil {
+		return 0, err
+	}
+	if total < maxPages {
+		maxPages = total
+	}
+
+	if err := os.MkdirAll(filepath.Join(buildDir, "pages"), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create preview directory: %w", err)
+	}
+
+	for page := 1; page <= maxPages; page++ {
+		for size, scale := range previewArgs {
+			out := strings.TrimSuffix(PreviewPath(buildDir, page, size), ".png")
+			args := append([]string{"-png", "-singlefile", "-f", strconv.Itoa(page), "-l", strconv.Itoa(page)}, scale...)
+			args = append(args, pdfPath, out)
+
+			ctx, cancel := context.WithTimeout(context.Background(), PreviewTimeout)
+			output, err := exec.CommandContext(ctx, "pdftoppm", args...).CombinedOutput()
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("timed out after %v", PreviewTimeout)
+			}
+			cancel()
+			if err != nil {
+				return page - 1, fmt.Errorf("pdftoppm failed on page %d: %w\n%s", page, err, string(output))
+			}
+		}
+	}
+
+	return maxPages, nil
+}
+
+// PDFPageCount reads the page count of a PDF using pdfinfo
+func PDFPageCount(pdfPath string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), PreviewTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "pdfinfo", pdfPath).Output()
+	if err != nil {
+		return 0, fmt.Errorf("pdfinfo failed: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Pages:") {
+			return strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Pages:")))
+		}
+	}
+	return 0, fmt.Errorf("page count not found in pdfinfo output")
+}
+
+// PreviewCount returns how many pages have pre-rendered previews
+func PreviewCount(buildDir string) int {
+	matches, err := filepath.Glob(filepath.Join(buildDir, "pages", "*_thumb.png"))
+	if err != nil {
+		return 0
+	}
+	return len(matches)
+}
@@ -0,0 +1,135 @@
+package build
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Problem severities
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// MaxProblems caps how many problems are extracted from one log
+const MaxProblems = 200
+
+// Problem is a single error or warning found in a LaTeX log
+type Problem struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+	// Position is where the problem's line was typeset, set by
+	// LocateProblems
+	Position *Position `json:"position,omitempty"`
+}
+
+// Position is a point on a page of the PDF, in PDF coordinates
+type Position struct {
+	Page int     `json:"page"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	// Exact is false when the point is that of a neighbouring line, as the
+	// line itself left nothing on the page
+	Exact bool `json:"exact"`
+}
+
+// Locator finds where line of file was typeset, or returns nil
+type Locator func(file string, line int) *Position
+
+var (
+	fileLineErrorRe = regexp.MustCompile(`^(\.?/?[^:\s]+\.(?:tex|sty|cls|bib|ltx)):(\d+): (.+)$`)
+	errorLineRe     = regexp.MustCompile(`^l\.(\d+)`)
+	warningRe       = regexp.MustCompile(`^(?:LaTeX|Package [\w-]+|Class [\w-]+) Warning: (.+)$`)
+	warningLineRe   = regexp.MustCompile(`on input line (\d+)`)
+	boxWarningRe    = regexp.MustCompile(`^(?:Over|Under)full \\[hv]box .* at lines? (\d+)`)
+	continuationRe  = regexp.MustCompile(`^\([\w-]+\)\s*`)
+	fileOpenRe      = regexp.MustCompile(`\(([^()\s]+\.(?:tex|ltx))`)
+)
+
+// ParseProblems extracts errors and warnings from a LaTeX log. File names
+// are attributed from "file:line: message" lines when the engine ran with
+// -file-line-error, otherwise from the most recently opened .tex file.
+func ParseProblems(log string) []Problem {
+	lines := strings.Split(log, "\n")
+	problems := []Problem{}
+	currentFile := ""
+
+	for i := 0; i < len(lines) && len(problems) < MaxProblems; i++ {
+		line := strings.TrimRight(lines[i], "\r")
+
+		if m := fileOpenRe.FindAllStringSubmatch(line, -1); len(m) > 0 {
+			currentFile = cleanLogPath(m[len(m)-1][1])
+		}
+
+		if m := fileLineErrorRe.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			problems = append(problems, Problem{Severity: SeverityError, File: cleanLogPath(m[1]), Line: n, Message: m[3]})
+			continue
+		}
+
+		if strings.HasPrefix(line, "! ") {
+			p := Problem{Severity: SeverityError, File: currentFile, Message: strings.TrimPrefix(line, "! ")}
+			// The offending line number follows within a few lines as "l.<n> ..."
+			for j := i + 1; j < len(lines) && j <= i+10; j++ {
+				if m := errorLineRe.FindStringSubmatch(lines[j]); m != nil {
+					p.Line, _ = strconv.Atoi(m[1])
+					break
+				}
+			}
+			problems = append(problems, p)
+			continue
+		}
+
+		if m := warningRe.FindStringSubmatch(line); m != nil {
+			message := m[1]
+			// Warnings wrap onto continuation lines until a blank line
+			for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && !strings.Contains(message, "input line") {
+				i++
+				message += " " + continuationRe.ReplaceAllString(strings.TrimSpace(lines[i]), "")
+			}
+			p := Problem{Severity: SeverityWarning, File: currentFile, Message: strings.TrimSpace(message)}
+			if lm := warningLineRe.FindStringSubmatch(message); lm != nil {
+				p.Line, _ = strconv.Atoi(lm[1])
+			}
+			problems = append(problems, p)
+			continue
+		}
+
+		if m := boxWarningRe.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			problems = append(problems, Problem{Severity: SeverityWarning, File: currentFile, Line: n, Message: line})
+		}
+	}
+
+	return problems
+}
+
+func cleanLogPath(p string) string {
+	return strings.TrimPrefix(p, "./")
+}
+
+// LocateProblems sets the Position of each problem with a file and line
+// that locate finds on the page
+func LocateProblems(problems []Problem, locate Locator) {
+	type key struct {
+		file string
+		line int
+	}
+	seen := make(map[key]*Position)
+	for i := range problems {
+		p := &problems[i]
+		if p.File == "" || p.Line < 1 {
+			continue
+		}
+		k := key{p.File, p.Line}
+		pos, ok := seen[k]
+		if !ok {
+			pos = locate(p.File, p.Line)
+			seen[k] = pos
+		}
+		p.Position = pos
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package build
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is on Windows, where cancelling its
+// context kills only the process itself
+func killProcessGroup(cmd *exec.Cmd) {}
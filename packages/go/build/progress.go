@@ -0,0 +1,260 @@
+package build
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+)
+
+// Phases of a running compilation, as reported in build status
+const (
+	PhasePreparing    = "preparing"    // latexmk has not started an engine yet
+	PhaseCompiling    = "compiling"    // first engine run
+	PhaseBibliography = "bibliography" // bibtex or biber
+	PhaseIndex        = "index"        // makeindex or xindy
+	PhaseRerunning    = "rerunning"    // later engine runs resolving references
+)
+
+// Progress is an estimate of how far a running compilation has got, read
+// from latexmk's output
+type Progress struct {
+	Percent int    `json:"percent"`
+	Phase   string `json:"phase"`
+	Run     int    `json:"run,omitempty"`   // engine runs started, from 1
+	File    string `json:"file,omitempty"`  // source file the engine is reading
+	Pages   int    `json:"pages,omitempty"` // pages shipped out in the current run
+	Total   int    `json:"total,omitempty"` // pages of the previous run's output, 0 until one finished
+}
+
+var (
+	progressRuleRe   = regexp.MustCompile(`Run number (\d+) of rule '([^']+)'`)
+	progressFileRe   = regexp.MustCompile(`\(([^\s()]+\.tex)\b`)
+	progressPageRe   = regexp.MustCompile(`\[(\d+)[\]{<\s]`)
+	progressOutputRe = regexp.MustCompile(`Output written on .*\((\d+) pages?`)
+)
+
+// EstimateProgress reads the output latexmk has produced so far and
+// estimates its progress. The first engine run is the bulk of the work and
+// its length is unknown, so it approaches 60% as pages are shipped out;
+// later runs are measured against the page count of the run before.
+// The estimate stops at 95% until the build has finished.
+func EstimateProgress(log []byte) Progress {
+	var t progressTracker
+	t.write(log)
+	t.scan(t.partial)
+	t.partial = nil
+	return t.progress()
+}
+
+// maxProgressLine is how much of an unfinished line progressTracker holds
+// back before reading it anyway
+const maxProgressLine = 64 << 10
+
+// progressTracker reads latexmk's output as it is appended and keeps what
+// EstimateProgress needs, so a running build's log is only read once
+type progressTracker struct {
+	runs    int    // engine rules started
+	rule    string // rule running now, "" before the first
+	output  int    // pages of the last output written
+	total   int    // output pages when the current rule started
+	file    string // last source file opened in the current rule
+	pages   int    // highest page shipped out in the current rule
+	partial []byte // unfinished last line
+}
+
+// write reads the complete lines of data, holding back an unfinished one
+func (t *progressTracker) write(data []byte) {
+	buf := append(t.partial, data...)
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	if len(buf)-end > maxProgressLine {
+		end = len(buf)
+	}
+	t.scan(buf[:end])
+	t.partial = append([]byte(nil), buf[end:]...)
+}
+
+// scan reads whole lines of output in order
+func (t *progressTracker) scan(data []byte) {
+	for len(data) > 0 {
+		next := len(data)
+		m := progressRuleRe.FindSubmatchIndex(data)
+		if m != nil {
+			next = m[0]
+		}
+		t.scanRule(data[:next])
+		if m == nil {
+			return
+		}
+		t.rule = string(data[m[4]:m[5]])
+		if isEngineRule(t.rule) {
+			t.runs++
+		}
+		t.total = t.output
+		t.file, t.pages = "", 0
+		data = data[m[1]:]
+	}
+}
+
+// scanRule reads output that belongs to the current rule
+func (t *progressTracker) scanRule(data []byte) {
+	if outputs := progressOutputRe.FindAllSubmatch(data, -1); len(outputs) > 0 {
+		t.output, _ = strconv.Atoi(string(outputs[len(outputs)-1][1]))
+	}
+	if t.rule == "" {
+		return
+	}
+	if files := progressFileRe.FindAllSubmatch(data, -1); len(files) > 0 {
+		t.file = strings.TrimPrefix(string(files[len(files)-1][1]), "./")
+	}
+	for _, m := range progressPageRe.FindAllSubmatch(data, -1) {
+		if page, _ := strconv.Atoi(string(m[1])); page > t.pages {
+			t.pages = page
+		}
+	}
+}
+
+// progress estimates progress from the output read so far
+func (t *progressTracker) progress() Progress {
+	p := Progress{Phase: PhasePreparing, Percent: 5}
+	if t.rule == "" {
+		return p
+	}
+	p.Run, p.Total = t.runs, t.total
+
+	switch {
+	case strings.HasPrefix(t.rule, "bibtex") || strings.HasPrefix(t.rule, "biber"):
+		p.Phase, p.Percent = PhaseBibliography, 60
+		return p
+	case strings.HasPrefix(t.rule, "makeindex") || strings.HasPrefix(t.rule, "xindy"):
+		p.Phase, p.Percent = PhaseIndex, 62
+		return p
+	case !isEngineRule(t.rule):
+		p.Phase, p.Percent = PhaseRerunning, 90
+		return p
+	}
+	p.File, p.Pages = t.file, t.pages
+
+	if p.Run <= 1 || p.Total == 0 {
+		p.Phase = PhaseCompiling
+		p.Percent = 10 + 50*p.Pages/(p.Pages+10)
+		return p
+	}
+	p.Phase = PhaseRerunning
+	base := 65
+	if p.Run > 2 {
+		base = 80
+	}
+	p.Percent = base + 15*min(p.Pages, p.Total)/p.Total
+	return p
+}
+
+// liveProgressIdle is how long a build's tracker is kept after its last
+// status request
+const liveProgressIdle = 10 * time.Minute
+
+// liveTracker is the progress of one build's live log, read up to offset
+type liveTracker struct {
+	mu     sync.Mutex
+	file   os.FileInfo // live log the offset belongs to
+	offset int64
+	used   time.Time
+	progressTracker
+}
+
+var (
+	liveTrackersMu sync.Mutex
+	liveTrackers   = map[string]*liveTracker{}
+)
+
+// LiveProgress estimates the progress of the build in buildDir from its live
+// log. Only output appended since the last call for the build is read; a new
+// compile attempt, which replaces the log, starts over.
+func LiveProgress(buildDir string) (Progress, error) {
+	now := time.Now()
+	liveTrackersMu.Lock()
+	for dir, lt := range liveTrackers {
+		if now.Sub(lt.used) > liveProgressIdle {
+			delete(liveTrackers, dir)
+		}
+	}
+	lt := liveTrackers[buildDir]
+	if lt == nil {
+		lt = &liveTracker{}
+		liveTrackers[buildDir] = lt
+	}
+	lt.used = now
+	liveTrackersMu.Unlock()
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	f, info, err := openLiveLogFile(buildDir)
+	if os.IsNotExist(err) {
+		return EstimateProgress(nil), nil
+	}
+	if err != nil {
+		return Progress{}, err
+	}
+	defer f.Close()
+
+	if lt.file == nil || !os.SameFile(lt.file, info) || info.Size() < lt.offset {
+		lt.file, lt.offset, lt.progressTracker = info, 0, progressTracker{}
+	}
+	if _, err := f.Seek(lt.offset, io.SeekStart); err != nil {
+		return Progress{}, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return Progress{}, err
+	}
+	lt.offset += int64(len(data))
+	lt.write(data)
+	return lt.progress(), nil
+}
+
+// forgetLiveProgress drops the tracker of the build in buildDir once it has
+// stopped compiling
+func forgetLiveProgress(buildDir string) {
+	liveTrackersMu.Lock()
+	delete(liveTrackers, buildDir)
+	liveTrackersMu.Unlock()
+}
+
+// isEngineRule reports whether a latexmk rule runs a TeX engine: pdflatex,
+// xelatex, lualatex or latex, optionally followed by the main file
+func isEngineRule(rule string) bool {
+	return strings.Contains(rule, "latex")
+}
+
+// NewStatusResponse reports the status of b like api.NewStatusResponse, with
+// a progress estimate while it compiles and build warnings once it has
+// completed. A live log that cannot be read leaves the estimate out rather
+// than failing the status request.
+func NewStatusResponse(b *Build) StatusResponse {
+	resp := api.NewStatusResponse(b)
+	if b.Status == StatusCompleted {
+		resp.Warnings = ParseBuildWarnings(b.BuildLog)
+		return resp
+	}
+	if b.Status != StatusCompiling || b.DirPath == "" {
+		if b.DirPath != "" {
+			forgetLiveProgress(b.DirPath)
+		}
+		return resp
+	}
+	p, err := LiveProgress(b.DirPath)
+	if err != nil {
+		return resp
+	}
+	resp.Progress = p.Percent
+	resp.Phase = p.Phase
+	resp.CurrentFile = p.File
+	resp.Pages = p.Pages
+	return resp
+}
@@ -0,0 +1,138 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// RedactedText replaces every secret found in a build log
+const RedactedText = "[REDACTED]"
+
+// DefaultRedactions match the secrets shell-escape scripts and custom
+// commands most often print: AWS keys, bearer tokens and email addresses
+var DefaultRedactions = []string{
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	`(?i)aws_secret_access_key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`,
+	`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]{8,}=*`,
+	`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`,
+}
+
+// Redactor masks secrets in build logs before they are stored or served. A
+// nil Redactor leaves logs as they are.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor compiles a redactor matching any of patterns
+func NewRedactor(patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// LoadRedactor builds the redactor of a server: DefaultRedactions if
+// defaults is set, plus the patterns in file, one per line, if file is set.
+// Blank lines and lines starting with # are skipped. It returns nil when
+// there is nothing to redact.
+func LoadRedactor(defaults bool, file string) (*Redactor, error) {
+	var patterns []string
+	if defaults {
+		patterns = append(patterns, DefaultRedactions...)
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redaction patterns: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	return NewRedactor(patterns)
+}
+
+// Redact returns s with every match replaced by RedactedText
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, RedactedText)
+	}
+	return s
+}
+
+// RedactBytes is Redact for a byte slice
+func (r *Redactor) RedactBytes(b []byte) []byte {
+	if r == nil {
+		return b
+	}
+	for _, re := range r.patterns {
+		b = re.ReplaceAllLiteral(b, []byte(RedactedText))
+	}
+	return b
+}
+
+// RedactWriter redacts a log streamed through it a line at a time, so
+// secrets split across writes are still caught. A partial line is held
+// back until its newline arrives or Flush is called.
+type RedactWriter struct {
+	r       *Redactor
+	w       io.Writer
+	pending []byte
+}
+
+// Writer returns a writer redacting everything written to it before
+// passing it on to w
+func (r *Redactor) Writer(w io.Writer) *RedactWriter {
+	return &RedactWriter{r: r, w: w}
+}
+
+// Write redacts and passes on every complete line of p
+func (rw *RedactWriter) Write(p []byte) (int, error) {
+	if rw.r == nil {
+		return rw.w.Write(p)
+	}
+	rw.pending = append(rw.pending, p...)
+	end := bytes.LastIndexByte(rw.pending, '\n')
+	if end < 0 {
+		// A line longer than a scanner's buffer is not a log line anyone
+		// reads; pass it on rather than hold it forever
+		if len(rw.pending) < bufio.MaxScanTokenSize {
+			return len(p), nil
+		}
+		end = len(rw.pending) - 1
+	}
+	if _, err := rw.w.Write(rw.r.RedactBytes(rw.pending[:end+1])); err != nil {
+		return 0, err
+	}
+	rw.pending = append(rw.pending[:0], rw.pending[end+1:]...)
+	return len(p), nil
+}
+
+// Flush redacts and passes on the partial line held back
+func (rw *RedactWriter) Flush() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(rw.r.RedactBytes(rw.pending))
+	rw.pending = rw.pending[:0]
+	return err
+}
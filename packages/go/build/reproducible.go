@@ -0,0 +1,162 @@
+package build
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultSourceDateEpoch is the timestamp reproducible builds embed when the
+// build environment does not set SOURCE_DATE_EPOCH
+const DefaultSourceDateEpoch = "0"
+
+// manifestSkip are files the compilers keep next to a project's sources;
+// they are not inputs of the build
+var manifestSkip = map[string]bool{
+	"source.zip":          true,
+	"build.json":          true,
+	".build_context.json": true,
+	LiveLogName:           true,
+	"output.pdf":          true,
+	"output.synctex.gz":   true,
+}
+
+// ManifestHash returns the SHA-256 of a project's input manifest: every
+// source file's path and content hash, sorted by path. Sources are read from
+// dir's source.zip if there is one, otherwise from dir itself, skipping the
+// output directory outDir. Identical sources give the same hash wherever and
+// whenever they are uploaded.
+func ManifestHash(dir, outDir string) (string, error) {
+	sums := make(map[string]string)
+
+	var err error
+	if reader, zerr := zip.OpenReader(filepath.Join(dir, "source.zip")); zerr == nil {
+		err = zipChecksums(reader, sums)
+		reader.Close()
+	} else {
+		err = dirChecksums(dir, outDir, sums)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to hash build inputs: %w", err)
+	}
+
+	paths := make([]string, 0, len(sums))
+	for p := range sums {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	for _, p := range paths {
+		fmt.Fprintf(h, "%s\x00%s\n", p, sums[p])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func zipChecksums(reader *zip.ReadCloser, sums map[string]string) error {
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		sum, err := readerChecksum(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		sums[path.Clean(file.Name)] = sum
+	}
+	return nil
+}
+
+func dirChecksums(dir, outDir string, sums map[string]string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == outDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || manifestSkip[rel] {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		sum, err := readerChecksum(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		sums[rel] = sum
+		return nil
+	})
+}
+
+func readerChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reproducibleArgs returns the latexmk arguments and environment that make a
+// reproducible build byte-identical across runs: a fixed SOURCE_DATE_EPOCH
+// for the PDF dates and \today, and a PDF /ID derived from the manifest hash
+// rather than from the output path and time. xdvipdfmx already derives the
+// ID from SOURCE_DATE_EPOCH, so xelatex needs no extra argument.
+func reproducibleArgs(build *Build) (args []string, env []string) {
+	if !build.Reproducible {
+		return nil, nil
+	}
+
+	env = []string{"FORCE_SOURCE_DATE=1"}
+	if _, ok := build.Env["SOURCE_DATE_EPOCH"]; !ok {
+		env = append(env, "SOURCE_DATE_EPOCH="+DefaultSourceDateEpoch)
+	}
+
+	id := build.ManifestHash
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	switch build.Engine {
+	case EnginePDFLaTeX:
+		args = []string{`-usepretex=\pdftrailerid{` + id + `}`}
+	case EngineLuaLaTeX:
+		args = []string{`-usepretex=\pdfvariable trailerid{[<` + id + `> <` + id + `>]}`}
+	}
+	return args, env
+}
+
+// reproducibleNote is prepended to the log of a reproducible build
+func reproducibleNote(build *Build) string {
+	return "treefrog: reproducible build, input manifest sha256:" + build.ManifestHash + "\n"
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,97 @@
+package build
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+)
+
+// maxWarningKeys caps how many labels or citation keys a build warning lists
+const maxWarningKeys = 20
+
+var (
+	maxRunsRe    = regexp.MustCompile(`Maximum runs of \S+ reached`)
+	rerunRe      = regexp.MustCompile(`Rerun to get|Please rerun|Please \(re\)run|has changed\.\s+Rerun`)
+	undefinedRe  = regexp.MustCompile("(Reference|Citation) `([^']+)' on page \\d+\\s+undefined")
+	undefinedAny = regexp.MustCompile(`There were undefined (?:references|citations)`)
+)
+
+// ParseBuildWarnings reports a completed build whose PDF is likely
+// incomplete: latexmk gave up before cross-references settled, or the last
+// engine run still had undefined references or citations. Only the output of
+// the last engine run counts, since earlier runs always leave some
+// references undefined.
+func ParseBuildWarnings(log string) []api.BuildWarning {
+	last := lastEngineRun(log)
+	warnings := []api.BuildWarning{}
+
+	if maxRunsRe.MatchString(log) || rerunRe.MatchString(last) {
+		warnings = append(warnings, api.BuildWarning{
+			Code:    api.WarningNeedsRerun,
+			Message: "Cross-references may be wrong: latexmk stopped before they settled",
+		})
+	}
+
+	var refs, cites []string
+	seen := map[string]bool{}
+	for _, m := range undefinedRe.FindAllStringSubmatch(last, -1) {
+		key := m[1] + ":" + m[2]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if m[1] == "Citation" {
+			cites = append(cites, m[2])
+		} else {
+			refs = append(refs, m[2])
+		}
+	}
+	if len(refs) > 0 || len(cites) > 0 || undefinedAny.MatchString(last) {
+		keys := append(refs, cites...)
+		if len(keys) > maxWarningKeys {
+			keys = keys[:maxWarningKeys]
+		}
+		warnings = append(warnings, api.BuildWarning{
+			Code:    api.WarningUnresolvedReferences,
+			Message: undefinedMessage(len(refs), len(cites)),
+			Keys:    keys,
+		})
+	}
+
+	return warnings
+}
+
+// lastEngineRun returns the output of latexmk's last engine run, or all of
+// log if latexmk did not announce its runs
+func lastEngineRun(log string) string {
+	rules := progressRuleRe.FindAllStringSubmatchIndex(log, -1)
+	for i := len(rules) - 1; i >= 0; i-- {
+		if isEngineRule(log[rules[i][4]:rules[i][5]]) {
+			return log[rules[i][1]:]
+		}
+	}
+	return log
+}
+
+func undefinedMessage(refs, cites int) string {
+	var parts []string
+	if refs > 0 {
+		parts = append(parts, plural(refs, "undefined reference"))
+	}
+	if cites > 0 {
+		parts = append(parts, plural(cites, "undefined citation"))
+	}
+	if len(parts) == 0 {
+		return "The document has undefined references"
+	}
+	return "The document has " + strings.Join(parts, " and ")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
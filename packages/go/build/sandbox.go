@@ -0,0 +1,79 @@
+package build
+
+import (
+	"fmt"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// SandboxCompiler runs each build in the compiler of the sandbox it asks
+// for, and builds without a sandbox in the default compiler
+type SandboxCompiler struct {
+	def       Compiler
+	sandboxes map[string]Compiler
+}
+
+// NewSandboxCompiler creates a compiler that offers no sandboxes yet
+func NewSandboxCompiler(def Compiler) *SandboxCompiler {
+	return &SandboxCompiler{def: def, sandboxes: make(map[string]Compiler)}
+}
+
+// Add offers sandbox, run by c
+func (s *SandboxCompiler) Add(sandbox string, c Compiler) error {
+	if err := buildopts.ValidateSandbox(sandbox); err != nil {
+		return err
+	}
+	s.sandboxes[sandbox] = c
+	return nil
+}
+
+// Available returns the sandboxes on offer, for buildopts.Limits
+func (s *SandboxCompiler) Available() map[string]bool {
+	available := make(map[string]bool, len(s.sandboxes))
+	for name := range s.sandboxes {
+		available[name] = true
+	}
+	return available
+}
+
+func (s *SandboxCompiler) Compile(build *Build) error {
+	if build.Sandbox == "" {
+		return s.def.Compile(build)
+	}
+	c, ok := s.sandboxes[build.Sandbox]
+	if !ok {
+		err := fmt.Errorf("%w: %s", buildopts.ErrSandboxNotAvailable, build.Sandbox)
+		build.Status = StatusFailed
+		build.ErrorMessage = err.Error()
+		return err
+	}
+	return c.Compile(build)
+}
+
+// ImageDigestFor returns the image digest of the compiler of build's
+// sandbox
+func (s *SandboxCompiler) ImageDigestFor(build *Build) (string, error) {
+	c := s.def
+	if build.Sandbox != "" {
+		var ok bool
+		if c, ok = s.sandboxes[build.Sandbox]; !ok {
+			return "", fmt.Errorf("%w: %s", buildopts.ErrSandboxNotAvailable, build.Sandbox)
+		}
+	}
+	d, ok := c.(ImageDigester)
+	if !ok {
+		return "", fmt.Errorf("compiler does not report its image")
+	}
+	return d.ImageDigestFor(build)
+}
+
+// Close closes the default compiler and every sandbox's compiler
+func (s *SandboxCompiler) Close() error {
+	err := s.def.Close()
+	for _, c := range s.sandboxes {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
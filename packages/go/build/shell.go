@@ -0,0 +1,85 @@
+package build
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+// containerPath is the PATH of the compile image, which restricted builds
+// put buildopts.ShellShimDir ahead of
+const containerPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ErrNoShellShim is returned for restricted shell-escape builds where the
+// treefrog-shell shim is not installed, since pygmentize and epstopdf would
+// then run unchecked
+var ErrNoShellShim = errors.New("restricted shell-escape needs treefrog-shell in " + buildopts.ShellShimDir)
+
+// shellShimCheck fails a compile container's script the same way when the
+// image lacks the shim
+var shellShimCheck = "[ -x " + buildopts.ShellShim + " ] || { echo 'treefrog: " + ErrNoShellShim.Error() + "'; exit 1; }\n"
+
+// checkShellShim returns ErrNoShellShim unless the shim is installed
+func checkShellShim() error {
+	info, err := os.Stat(buildopts.ShellShim)
+	if err != nil || !info.Mode().IsRegular() {
+		return ErrNoShellShim
+	}
+	return nil
+}
+
+// shellPackages cannot compile without shell-escape; minted runs pygmentize
+var shellPackages = []string{"minted"}
+
+// AutoShellEscape enables the restricted shell-escape profile when the main
+// file under dir loads a package that needs it, the build asked for no
+// shell-escape at all and its limits allowed turning it on (AutoShell). The
+// main file is read from dir, or from dir's source.zip when the project has
+// not been extracted yet. It returns the package that triggered it, or "" if
+// nothing changed.
+func AutoShellEscape(build *Build, dir string) string {
+	if !build.AutoShell || build.ShellEscape || build.ShellRestricted {
+		return ""
+	}
+
+	analysis, err := analyzeMainFile(dir, build.MainFile)
+	if err != nil {
+		return ""
+	}
+	for _, name := range shellPackages {
+		if analysis.UsesPackage(name) {
+			build.ShellRestricted = true
+			return name
+		}
+	}
+	return ""
+}
+
+func analyzeMainFile(dir, mainFile string) (buildopts.Analysis, error) {
+	if analysis, err := buildopts.AnalyzeFile(filepath.Join(dir, filepath.FromSlash(mainFile))); err == nil {
+		return analysis, nil
+	}
+
+	reader, err := zip.OpenReader(filepath.Join(dir, "source.zip"))
+	if err != nil {
+		return buildopts.Analysis{}, err
+	}
+	defer reader.Close()
+
+	f, err := reader.Open(path.Clean(mainFile))
+	if err != nil {
+		return buildopts.Analysis{}, err
+	}
+	defer f.Close()
+	return buildopts.AnalyzeReader(f)
+}
+
+// autoShellNote is prepended to the log of a build whose shell-escape
+// profile was enabled by AutoShellEscape
+func autoShellNote(pkg string) string {
+	return "treefrog: " + pkg + " needs shell-escape; compiling with the restricted profile\n"
+}
@@ -0,0 +1,129 @@
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+)
+
+// Heading levels a PDF can be split at, outermost first
+var SplitLevels = []string{"part", "chapter", "section"}
+
+// SplitDir is the directory of a build holding its split PDF
+const SplitDir = "split"
+
+var headingRe = regexp.MustCompile(`\\(part|chapter|section)\*?\s*(?:\[[^\]]*\]\s*)?\{((?:[^{}]|\{[^{}]*\})*)\}`)
+
+// Heading is a sectioning command in the sources of a build
+type Heading struct {
+	Level string
+	Title string
+	File  string // relative to the build directory
+	Line  int
+}
+
+// SplitPartName is the file name of part index (from 1) of a split PDF
+func SplitPartName(index int) string {
+	return fmt.Sprintf("part-%03d.pdf", index)
+}
+
+// SplitPartPath is where part index (from 1) of b's split PDF is written
+func SplitPartPath(b *Build, index int) string {
+	return filepath.Join(b.DirPath, SplitDir, SplitPartName(index))
+}
+
+// ReadHeadings finds the \part, \chapter and \section commands of the .tex
+// files of the build directory dir, leaving out its output directory
+func ReadHeadings(dir, outDir string) ([]Heading, error) {
+	var headings []Heading
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || rel == outDir || rel == SplitDir) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(rel) != ".tex" {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if c := commentStart(line); c >= 0 {
+				line = line[:c]
+			}
+			for _, m := range headingRe.FindAllStringSubmatch(line, -1) {
+				headings = append(headings, Heading{Level: m[1], Title: strings.Join(strings.Fields(m[2]), " "), File: rel, Line: i + 1})
+			}
+		}
+		return nil
+	})
+	return headings, err
+}
+
+// SplitRanges divides a PDF of pageCount pages into parts at the headings
+// of level that pageOf places, or at the outermost level with at least two
+// placed headings if level is "". Pages before the first heading make a
+// front part, and headings starting on the same page share a part.
+func SplitRanges(headings []Heading, level string, pageOf PageLocator, pageCount int) []api.SplitPart {
+	type placed struct {
+		Heading
+		page int
+	}
+	byLevel := make(map[string][]placed)
+	for _, h := range headings {
+		if page := pageOf(h.File, h.Line); page > 0 && page <= pageCount {
+			byLevel[h.Level] = append(byLevel[h.Level], placed{h, page})
+		}
+	}
+	if level == "" {
+		for _, l := range SplitLevels {
+			if len(byLevel[l]) >= 2 {
+				level = l
+				break
+			}
+		}
+	}
+	starts := byLevel[level]
+	sort.SliceStable(starts, func(i, j int) bool { return starts[i].page < starts[j].page })
+
+	parts := []api.SplitPart{}
+	if len(starts) == 0 || starts[0].page > 1 {
+		end := pageCount
+		if len(starts) > 0 {
+			end = starts[0].page - 1
+		}
+		parts = append(parts, api.SplitPart{Title: "Front matter", FirstPage: 1, LastPage: end})
+	}
+	for _, s := range starts {
+		if n := len(parts); n > 0 && parts[n-1].FirstPage == s.page {
+			parts[n-1].Headings = append(parts[n-1].Headings, s.Title)
+			continue
+		}
+		if n := len(parts); n > 0 {
+			parts[n-1].LastPage = s.page - 1
+		}
+		parts = append(parts, api.SplitPart{Title: s.Title, Level: level, Headings: []string{s.Title}, FirstPage: s.page, LastPage: pageCount})
+	}
+	for i := range parts {
+		parts[i].Index = i + 1
+	}
+	return parts
+}
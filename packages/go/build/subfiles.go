@@ -0,0 +1,76 @@
+package build
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var subdocClassPattern = regexp.MustCompile(`^\s*\\documentclass\s*(\[[^\]]*\])?\s*\{(subfiles|standalone)\}`)
+
+// DetectSubdocuments finds .tex files under root that can be compiled on
+// their own because they use the subfiles or standalone class. Paths are
+// relative to root with forward slashes; mainFile is never included.
+func DetectSubdocuments(root, mainFile string) ([]string, error) {
+	var docs []string
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || rel == "output") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.EqualFold(filepath.Ext(rel), ".tex") || rel == mainFile {
+			return nil
+		}
+
+		if isSubdocument(path) {
+			docs = append(docs, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(docs)
+	return docs, nil
+}
+
+// isSubdocument reports whether the first \documentclass of a file selects
+// the subfiles or standalone class
+func isSubdocument(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lines := 0; scanner.Scan() && lines < 200; lines++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "%") {
+			continue
+		}
+		if strings.HasPrefix(line, `\documentclass`) {
+			return subdocClassPattern.MatchString(line)
+		}
+	}
+	return false
+}
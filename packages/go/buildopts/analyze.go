@@ -0,0 +1,135 @@
+package buildopts
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// maxAnalyzeSize caps how much of a main file is read for analysis; the
+// preamble is at the top
+const maxAnalyzeSize = 256 * 1024
+
+var (
+	packagePattern = regexp.MustCompile(`\\(?:usepackage|RequirePackage)\s*(?:\[[^\]]*\])?\s*\{([^}]*)\}`)
+	// "% !TEX program = xelatex" or "% !TeX TS-program = lualatex"
+	magicProgramPattern = regexp.MustCompile(`(?im)^\s*%\s*!TeX\s+(?:TS-)?program\s*=\s*(\w+)`)
+)
+
+// xelatexPackages need a Unicode engine; xelatex is preferred for them
+// unless the document also uses Lua
+var xelatexPackages = []string{"fontspec", "polyglossia", "unicode-math", "xeCJK", "xunicode", "xltxtra"}
+
+// lualatexPackages only work under lualatex
+var lualatexPackages = []string{"luacode", "luatexbase", "luaotfload", "luatexja", "luatexja-fontspec", "luamplib"}
+
+// Analysis is what AnalyzeSource found in a document's preamble
+type Analysis struct {
+	// Packages lists the packages loaded in the preamble, in order
+	Packages []string
+	// Program is the engine named by a "% !TEX program" magic comment
+	Program string
+	// DirectLua is set when the preamble calls \directlua
+	DirectLua bool
+}
+
+// EngineRecommendation is the engine suggested for a document and why
+type EngineRecommendation struct {
+	Engine string `json:"engine"`
+	Reason string `json:"reason"`
+}
+
+// AnalyzeSource scans the preamble of a LaTeX main file, that is everything
+// before \begin{document}, ignoring comments
+func AnalyzeSource(src string) Analysis {
+	var a Analysis
+	if m := magicProgramPattern.FindStringSubmatch(src); m != nil {
+		a.Program = strings.ToLower(m[1])
+	}
+
+	preamble := stripComments(src)
+	if i := strings.Index(preamble, `\begin{document}`); i >= 0 {
+		preamble = preamble[:i]
+	}
+
+	for _, m := range packagePattern.FindAllStringSubmatch(preamble, -1) {
+		for _, name := range strings.Split(m[1], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				a.Packages = append(a.Packages, name)
+			}
+		}
+	}
+	a.DirectLua = strings.Contains(preamble, `\directlua`)
+	return a
+}
+
+// AnalyzeFile reads and analyzes the main file at path
+func AnalyzeFile(path string) (Analysis, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Analysis{}, err
+	}
+	defer f.Close()
+	return AnalyzeReader(f)
+}
+
+// AnalyzeReader reads and analyzes a main file
+func AnalyzeReader(r io.Reader) (Analysis, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxAnalyzeSize))
+	if err != nil {
+		return Analysis{}, err
+	}
+	return AnalyzeSource(string(data)), nil
+}
+
+// stripComments removes % comments, keeping escaped \% signs
+func stripComments(src string) string {
+	lines := strings.Split(src, "\n")
+	for i, line := range lines {
+		for j := 0; j < len(line); j++ {
+			if line[j] == '\\' {
+				j++
+				continue
+			}
+			if line[j] == '%' {
+				lines[i] = line[:j]
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// UsesPackage reports whether the preamble loads the named package
+func (a Analysis) UsesPackage(name string) bool {
+	for _, p := range a.Packages {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RecommendEngine picks the engine the document most likely needs. A magic
+// comment wins; Lua code needs lualatex; fontspec and other Unicode font
+// packages need xelatex or lualatex; anything else builds with pdflatex.
+func (a Analysis) RecommendEngine() EngineRecommendation {
+	if Engines[a.Program] {
+		return EngineRecommendation{Engine: a.Program, Reason: "set by % !TEX program"}
+	}
+	if a.DirectLua {
+		return EngineRecommendation{Engine: "lualatex", Reason: `uses \directlua`}
+	}
+	for _, name := range lualatexPackages {
+		if a.UsesPackage(name) {
+			return EngineRecommendation{Engine: "lualatex", Reason: "uses " + name}
+		}
+	}
+	for _, name := range xelatexPackages {
+		if a.UsesPackage(name) {
+			return EngineRecommendation{Engine: "xelatex", Reason: "uses " + name}
+		}
+	}
+	return EngineRecommendation{Engine: DefaultEngine, Reason: "no Unicode font or Lua packages"}
+}
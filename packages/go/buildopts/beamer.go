@@ -0,0 +1,216 @@
+package buildopts
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Where beamer puts speaker notes, from \setbeameroption
+const (
+	NotesHidden = "hidden" // no notes in the PDF
+	NotesPages  = "pages"  // a notes page after each annotated frame
+	NotesOnly   = "only"   // only the notes pages
+	NotesRight  = "right"  // second screen: each page is the slide with its notes beside it
+	NotesLeft   = "left"
+	NotesTop    = "top"
+	NotesBottom = "bottom"
+)
+
+// maxDeckDepth caps how deeply \input files are followed for slides
+const maxDeckDepth = 16
+
+var (
+	beamerClassPattern  = regexp.MustCompile(`\\documentclass\s*(?:\[[^\]]*\]\s*)?\{\s*beamer\s*\}`)
+	beamerOptionPattern = regexp.MustCompile(`\\setbeameroption\s*\{\s*(show notes on second screen|show only notes|show notes|hide notes)\s*(?:=\s*(\w+))?\s*\}`)
+	// slideTokenPattern finds what the slide scan follows, in order
+	slideTokenPattern  = regexp.MustCompile(`\\(begin\s*\{frame\}|end\s*\{frame\}|(?:frametitle|note|input|include|subfile)\b)`)
+	optionalArgPattern = regexp.MustCompile(`^\s*(?:<[^>]*>\s*)?(?:\[[^\]]*\]\s*)*`)
+)
+
+// Frame is a \begin{frame} of a beamer deck with its speaker notes
+type Frame struct {
+	Index int    `json:"index"` // 1-based, in document order
+	Title string `json:"title,omitempty"`
+	File  string `json:"file"` // relative to the project root
+	Line  int    `json:"line"`
+	Notes []Note `json:"notes"`
+}
+
+// Note is a \note of a frame; one outside a frame belongs to the frame
+// before it
+type Note struct {
+	Text string `json:"text"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Deck is the frames of a beamer presentation, read from its sources
+type Deck struct {
+	MainFile string  `json:"main_file"`
+	Notes    string  `json:"notes"` // NotesHidden, NotesPages, ...
+	Frames   []Frame `json:"frames"`
+}
+
+type deckReader struct {
+	dir    string
+	inputs map[string][]string // file and line to the files it inputs there, in order
+	deck   *Deck
+	frame  *Frame // the open frame
+}
+
+// ReadDeck reads the frames and speaker notes of the beamer presentation
+// with main file mainFile in dir, following the files it inputs. Frames
+// made with \frame{...} or \againframe are not listed.
+func ReadDeck(dir, mainFile string) (*Deck, error) {
+	if err := ValidateMainFile(mainFile); err != nil {
+		return nil, err
+	}
+	mainFile = filepath.ToSlash(filepath.Clean(mainFile))
+	src, err := readGraphSource(filepath.Join(dir, filepath.FromSlash(mainFile)))
+	if err != nil {
+		return nil, err
+	}
+	src = stripComments(src)
+	if !beamerClassPattern.MatchString(src) {
+		return nil, fmt.Errorf("%s is not a beamer presentation", mainFile)
+	}
+
+	graph, err := LoadGraph(dir, []string{mainFile})
+	if err != nil {
+		return nil, err
+	}
+	r := &deckReader{dir: dir, inputs: make(map[string][]string), deck: &Deck{MainFile: mainFile, Notes: NotesHidden, Frames: []Frame{}}}
+	for _, e := range graph.Edges {
+		if e.Command == "input" || e.Command == "include" || e.Command == "subfile" {
+			key := fmt.Sprintf("%s:%d", e.From, e.Line)
+			r.inputs[key] = append(r.inputs[key], e.To)
+		}
+	}
+
+	preamble := src
+	if i := strings.Index(src, `\begin{document}`); i >= 0 {
+		preamble = src[:i]
+	}
+	for _, m := range beamerOptionPattern.FindAllStringSubmatch(preamble, -1) {
+		switch m[1] {
+		case "show notes on second screen":
+			r.deck.Notes = NotesRight
+			if side := strings.ToLower(m[2]); side == NotesLeft || side == NotesTop || side == NotesBottom {
+				r.deck.Notes = side
+			}
+		case "show only notes":
+			r.deck.Notes = NotesOnly
+		case "show notes":
+			r.deck.Notes = NotesPages
+		default:
+			r.deck.Notes = NotesHidden
+		}
+	}
+
+	r.scan(mainFile, src, map[string]bool{mainFile: true})
+	if r.frame != nil {
+		r.close()
+	}
+	return r.deck, nil
+}
+
+// scan follows the frames, titles and notes of file, whose source without
+// comments is src, into the files it inputs
+func (r *deckReader) scan(file, src string, open map[string]bool) {
+	inputsAt := make(map[int]int) // line to inputs of it already followed
+	line, pos := 1, 0
+	for _, loc := range slideTokenPattern.FindAllStringSubmatchIndex(src, -1) {
+		token := strings.Join(strings.Fields(src[loc[2]:loc[3]]), "")
+		line += strings.Count(src[pos:loc[0]], "\n")
+		pos = loc[0]
+		rest := src[loc[1]:]
+
+		switch token {
+		case "begin{frame}":
+			if r.frame != nil {
+				r.close()
+			}
+			r.frame = &Frame{Index: len(r.deck.Frames) + 1, File: file, Line: line, Notes: []Note{}}
+			rest = rest[len(optionalArgPattern.FindString(rest)):]
+			if title, ok := bracedArg(rest); ok {
+				r.frame.Title = collapseSpace(title)
+			}
+		case "end{frame}":
+			if r.frame != nil {
+				r.close()
+			}
+		case "frametitle":
+			rest = rest[len(optionalArgPattern.FindString(rest)):]
+			if title, ok := bracedArg(rest); ok && r.frame != nil && r.frame.Title == "" {
+				r.frame.Title = collapseSpace(title)
+			}
+		case "note":
+			rest = rest[len(optionalArgPattern.FindString(rest)):]
+			text, ok := bracedArg(rest)
+			if !ok {
+				continue
+			}
+			note := Note{Text: strings.TrimSpace(text), File: file, Line: line}
+			switch {
+			case r.frame != nil:
+				r.frame.Notes = append(r.frame.Notes, note)
+			case len(r.deck.Frames) > 0:
+				last := &r.deck.Frames[len(r.deck.Frames)-1]
+				last.Notes = append(last.Notes, note)
+			}
+		default:
+			targets := r.inputs[fmt.Sprintf("%s:%d", file, line)]
+			i := inputsAt[line]
+			if i >= len(targets) {
+				continue
+			}
+			inputsAt[line]++
+			to := targets[i]
+			if open[to] || len(open) >= maxDeckDepth {
+				continue
+			}
+			child, err := readGraphSource(filepath.Join(r.dir, filepath.FromSlash(to)))
+			if err != nil {
+				continue
+			}
+			open[to] = true
+			r.scan(to, stripComments(child), open)
+			delete(open, to)
+		}
+	}
+}
+
+func (r *deckReader) close() {
+	r.deck.Frames = append(r.deck.Frames, *r.frame)
+	r.frame = nil
+}
+
+// bracedArg returns the contents of the balanced {...} group src starts
+// with, after spaces
+func bracedArg(src string) (string, bool) {
+	src = strings.TrimLeft(src, " \t\r\n")
+	if !strings.HasPrefix(src, "{") {
+		return "", false
+	}
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return src[1:i], true
+			}
+		}
+	}
+	return "", false
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
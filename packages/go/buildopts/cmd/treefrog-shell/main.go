@@ -0,0 +1,47 @@
+// Command treefrog-shell stands in for the buildopts.ShimmedShellCommands on
+// the PATH of restricted shell-escape builds. Linked under the name of each
+// command in buildopts.ShellShimDir, it checks the arguments the document
+// passed and runs the real program found further down the PATH.
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+)
+
+func main() {
+	name := filepath.Base(os.Args[0])
+	args, err := buildopts.CheckShellCommand(name, os.Args[1:])
+	if err != nil {
+		fail(err)
+	}
+	program, err := lookPath(name)
+	if err != nil {
+		fail(err)
+	}
+	fail(syscall.Exec(program, append([]string{name}, args...), os.Environ()))
+}
+
+// lookPath finds name on the PATH, skipping the shim's own directory
+func lookPath(name string) (string, error) {
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" || filepath.Clean(dir) == buildopts.ShellShimDir {
+			continue
+		}
+		program := filepath.Join(dir, name)
+		if info, err := os.Stat(program); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return program, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", name, exec.ErrNotFound)
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "treefrog-shell: %v\n", err)
+	os.Exit(1)
+}
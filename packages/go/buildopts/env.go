@@ -0,0 +1,102 @@
+package buildopts
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxEnvValueLen caps the length of a build environment value
+const MaxEnvValueLen = 1024
+
+// envKind says how a build environment value is checked
+type envKind int
+
+const (
+	envSearchPath envKind = iota // kpathsea search path inside the project
+	envNumber                    // non-negative integer
+)
+
+// AllowedEnv lists the environment variables a build may set. Search paths
+// are colon-separated and must stay inside the project; an empty element
+// stands for the default path, as in TEXINPUTS=./classes//:
+var AllowedEnv = map[string]envKind{
+	"TEXINPUTS":         envSearchPath,
+	"BIBINPUTS":         envSearchPath,
+	"BSTINPUTS":         envSearchPath,
+	"max_print_line":    envNumber,
+	"error_line":        envNumber,
+	"half_error_line":   envNumber,
+	"SOURCE_DATE_EPOCH": envNumber,
+}
+
+// ValidateEnv checks that every key in env is allowlisted and its value is
+// well-formed
+func ValidateEnv(env map[string]string) error {
+	for key, value := range env {
+		kind, ok := AllowedEnv[key]
+		if !ok {
+			return &Error{Field: "env", Reason: fmt.Sprintf("%q cannot be set", key)}
+		}
+		if len(value) > MaxEnvValueLen {
+			return &Error{Field: "env", Reason: fmt.Sprintf("%s is too long (max %d chars)", key, MaxEnvValueLen)}
+		}
+		if strings.ContainsAny(value, "\x00\n\r") {
+			return &Error{Field: "env", Reason: fmt.Sprintf("%s contains control characters", key)}
+		}
+
+		switch kind {
+		case envNumber:
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return &Error{Field: "env", Reason: fmt.Sprintf("%s must be a non-negative integer", key)}
+			}
+		case envSearchPath:
+			for _, dir := range strings.Split(value, ":") {
+				dir = strings.TrimRight(dir, "/")
+				if path.IsAbs(dir) || strings.Contains(dir, `\`) || hasDotDot(dir) {
+					return &Error{Field: "env", Reason: fmt.Sprintf("%s must only name directories inside the project", key)}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasDotDot(dir string) bool {
+	for _, part := range strings.Split(dir, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseEnv reads the env form field, a JSON object of strings. An empty
+// field means no extra environment.
+func ParseEnv(value string) (map[string]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var env map[string]string
+	if err := json.Unmarshal([]byte(value), &env); err != nil {
+		return nil, &Error{Field: "env", Reason: "must be a JSON object of strings"}
+	}
+	return env, nil
+}
+
+// EnvList formats env as KEY=value pairs in key order, for exec.Cmd.Env or
+// a container config
+func EnvList(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(env))
+	for key, value := range env {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,7 @@
+module github.com/alpha-og/treefrog/packages/go/buildopts
+
+go 1.24.0
+
+require github.com/alpha-og/treefrog/packages/go/security v0.0.0
+
+replace github.com/alpha-og/treefrog/packages/go/security => ../security
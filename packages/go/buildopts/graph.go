@@ -0,0 +1,388 @@
+package buildopts
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of file in a project graph
+const (
+	NodeTeX   = "tex"
+	NodeStyle = "style" // local .sty and .cls files
+	NodeBib   = "bib"
+	NodeImage = "image"
+	NodeOther = "other"
+)
+
+const (
+	// MaxGraphFiles caps how many files of a project are put in its graph
+	MaxGraphFiles = 10000
+	// maxGraphSource caps how much of each LaTeX file is scanned
+	maxGraphSource = 1024 * 1024
+	// maxBibSource caps how much of each .bib file is scanned; bibliography
+	// databases run much larger than LaTeX sources
+	maxBibSource = 32 * 1024 * 1024
+)
+
+var (
+	// refPattern finds the commands that pull a file into the document:
+	// \input{ch1}, \includegraphics[width=3cm]{fig}, \bibliography{a,b}
+	refPattern = regexp.MustCompile(`\\(input|include|subfile|includegraphics|includesvg|includepdf|lstinputlisting|bibliography|bibliographystyle|addbibresource|documentclass|usepackage|RequirePackage|LoadClass)\*?\s*(?:\[[^\]]*\]\s*)*\{([^{}]*)\}`)
+	// bareInputPattern finds TeX's \input ch1, without braces
+	bareInputPattern = regexp.MustCompile(`\\input\s+([^\s{}\\%]+)`)
+	// importPattern finds \import{dir}{file} and \subimport{dir}{file}
+	importPattern = regexp.MustCompile(`\\(import|subimport|inputfrom|subinputfrom|includefrom|subincludefrom)\*?\s*\{([^{}]*)\}\s*\{([^{}]*)\}`)
+	// graphicsPathPattern finds \graphicspath{{figures/}{img/}}
+	graphicsPathPattern = regexp.MustCompile(`\\graphicspath\s*\{((?:\s*\{[^{}]*\})+)\s*\}`)
+	bracedPattern       = regexp.MustCompile(`\{([^{}]*)\}`)
+)
+
+var (
+	texExtensions   = []string{".tex"}
+	imageExtensions = []string{".pdf", ".png", ".jpg", ".jpeg", ".eps", ".svg"}
+	bibExtensions   = []string{".bib"}
+)
+
+// intermediateSuffixes are the files LaTeX writes next to its sources; they
+// are left out of project graphs
+var intermediateSuffixes = []string{
+	".aux", ".bbl", ".bcf", ".blg", ".fdb_latexmk", ".fls", ".log", ".out",
+	".run.xml", ".toc", ".lof", ".lot", ".xdv", ".dvi", ".idx", ".ilg", ".ind",
+	".nav", ".snm", ".vrb", ".synctex.gz", ".synctex(busy)",
+}
+
+// GraphNode is a file of the project, or one its sources refer to that is
+// missing
+type GraphNode struct {
+	Path      string `json:"path"` // relative to the project root, with slashes
+	Kind      string `json:"kind"`
+	Size      int64  `json:"size"`
+	Root      bool   `json:"root,omitempty"`    // a main file
+	Missing   bool   `json:"missing,omitempty"` // referred to but not in the project
+	Reachable bool   `json:"reachable"`         // used by a main file, directly or not
+}
+
+// GraphEdge is a reference from one file to another
+type GraphEdge struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Command string `json:"command"` // input, includegraphics, bibliography, ...
+	Line    int    `json:"line"`
+}
+
+// ProjectGraph is the dependency graph of a project's files: what each
+// LaTeX file inputs, includes, loads or cites, starting from its main files
+type ProjectGraph struct {
+	Roots []string    `json:"roots"`
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// Orphans returns the LaTeX, image and bibliography files of the graph no
+// main file uses. Other files may be read by commands the graph does not
+// know, so they are never reported.
+func (g *ProjectGraph) Orphans() []GraphNode {
+	orphans := []GraphNode{}
+	for _, n := range g.Nodes {
+		if n.Kind != NodeTeX && n.Kind != NodeImage && n.Kind != NodeBib {
+			continue
+		}
+		if !n.Reachable && !n.Missing {
+			orphans = append(orphans, n)
+		}
+	}
+	return orphans
+}
+
+// LoadGraph builds the graph of the project in dir from roots, its main
+// files relative to dir. Without roots the main files of the project's
+// targets are used, or else DefaultMainFile, or else every top-level .tex
+// file with a \documentclass. Hidden directories, output directories and
+// LaTeX's intermediate files are left out.
+func LoadGraph(dir string, roots []string) (*ProjectGraph, error) {
+	settings, err := LoadProjectSettings(dir)
+	if err != nil {
+		return nil, err
+	}
+	skip := map[string]bool{DefaultOutDir: true}
+	for _, t := range settings.Targets {
+		skip[t.OutDir] = true
+	}
+
+	files, err := projectFiles(dir, skip)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		roots = defaultRoots(dir, settings, files)
+	}
+
+	b := &graphBuilder{dir: dir, files: files, nodes: make(map[string]*GraphNode, len(files))}
+	for p, size := range files {
+		b.nodes[p] = &GraphNode{Path: p, Kind: nodeKind(p), Size: size}
+	}
+	graph := &ProjectGraph{Roots: []string{}}
+	for _, root := range roots {
+		root = path.Clean(filepath.ToSlash(root))
+		if err := ValidateMainFile(root); err != nil {
+			return nil, err
+		}
+		node := b.node(root)
+		node.Root = true
+		graph.Roots = append(graph.Roots, root)
+	}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if kind := nodeKind(p); kind == NodeTeX || kind == NodeStyle {
+			b.scan(p)
+		}
+	}
+
+	// Walk the edges from the roots to find the files in use
+	next := make(map[string][]string)
+	for _, e := range b.edges {
+		next[e.From] = append(next[e.From], e.To)
+	}
+	queue := append([]string(nil), graph.Roots...)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if n := b.nodes[p]; n.Reachable {
+			continue
+		} else {
+			n.Reachable = true
+		}
+		queue = append(queue, next[p]...)
+	}
+
+	graph.Nodes = make([]GraphNode, 0, len(b.nodes))
+	for _, n := range b.nodes {
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool { return graph.Nodes[i].Path < graph.Nodes[j].Path })
+	graph.Edges = b.edges
+	if graph.Edges == nil {
+		graph.Edges = []GraphEdge{}
+	}
+	return graph, nil
+}
+
+// projectFiles lists the files of the project in dir by their slash path,
+// with their sizes
+func projectFiles(dir string, skip map[string]bool) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && (strings.HasPrefix(d.Name(), ".") || skip[rel]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") || intermediate(d.Name()) {
+			return nil
+		}
+		if len(files) == MaxGraphFiles {
+			return fmt.Errorf("project has more than %d files", MaxGraphFiles)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = info.Size()
+		return nil
+	})
+	return files, err
+}
+
+// defaultRoots picks the main files of a project that names none
+func defaultRoots(dir string, settings ProjectSettings, files map[string]int64) []string {
+	var roots []string
+	for _, t := range settings.Targets {
+		roots = append(roots, t.MainFile)
+	}
+	if len(roots) > 0 {
+		return roots
+	}
+	if _, ok := files[DefaultMainFile]; ok {
+		return []string{DefaultMainFile}
+	}
+	for p := range files {
+		if path.Dir(p) != "." || nodeKind(p) != NodeTeX {
+			continue
+		}
+		src, err := readGraphSource(filepath.Join(dir, p))
+		if err == nil && strings.Contains(stripComments(src), `\documentclass`) {
+			roots = append(roots, p)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+type graphBuilder struct {
+	dir   string
+	files map[string]int64
+	nodes map[string]*GraphNode
+	edges []GraphEdge
+}
+
+// node returns the node of p, adding a missing one if the project has no
+// such file
+func (b *graphBuilder) node(p string) *GraphNode {
+	n, ok := b.nodes[p]
+	if !ok {
+		n = &GraphNode{Path: p, Kind: nodeKind(p), Missing: true}
+		b.nodes[p] = n
+	}
+	return n
+}
+
+// scan adds the edges of the LaTeX file p
+func (b *graphBuilder) scan(from string) {
+	src, err := readGraphSource(filepath.Join(b.dir, filepath.FromSlash(from)))
+	if err != nil {
+		return
+	}
+	fromDir := path.Dir(from)
+	graphicsDirs := []string{"."}
+	for i, line := range strings.Split(stripComments(src), "\n") {
+		for _, m := range graphicsPathPattern.FindAllStringSubmatch(line, -1) {
+			for _, d := range bracedPattern.FindAllStringSubmatch(m[1], -1) {
+				if d := strings.TrimSpace(d[1]); d != "" {
+					graphicsDirs = append(graphicsDirs, d)
+				}
+			}
+		}
+		for _, m := range refPattern.FindAllStringSubmatch(line, -1) {
+			command := m[1]
+			for _, name := range strings.Split(m[2], ",") {
+				if name = strings.TrimSpace(name); name == "" {
+					continue
+				}
+				switch command {
+				case "input", "include", "subfile":
+					b.refer(from, command, i+1, name, []string{".", fromDir}, texExtensions, true)
+				case "includegraphics", "includesvg":
+					b.refer(from, command, i+1, name, append(graphicsDirs, fromDir), imageExtensions, true)
+				case "includepdf":
+					b.refer(from, command, i+1, name, append(graphicsDirs, fromDir), []string{".pdf"}, true)
+				case "lstinputlisting":
+					b.refer(from, command, i+1, name, []string{".", fromDir}, []string{""}, true)
+				case "bibliography", "addbibresource":
+					b.refer(from, command, i+1, name, []string{".", fromDir}, bibExtensions, true)
+				case "bibliographystyle":
+					b.refer(from, command, i+1, name, []string{".", fromDir}, []string{".bst"}, false)
+				case "documentclass", "LoadClass":
+					// Classes and packages of the TeX distribution are not
+					// part of the project
+					b.refer(from, command, i+1, name, []string{".", fromDir}, []string{".cls"}, false)
+				default:
+					b.refer(from, command, i+1, name, []string{".", fromDir}, []string{".sty"}, false)
+				}
+			}
+		}
+		for _, m := range bareInputPattern.FindAllStringSubmatch(line, -1) {
+			b.refer(from, "input", i+1, m[1], []string{".", fromDir}, texExtensions, true)
+		}
+		for _, m := range importPattern.FindAllStringSubmatch(line, -1) {
+			base := strings.TrimSpace(m[2])
+			if strings.HasPrefix(m[1], "sub") {
+				base = path.Join(fromDir, base)
+			}
+			b.refer(from, m[1], i+1, path.Join(base, strings.TrimSpace(m[3])), []string{"."}, texExtensions, true)
+		}
+	}
+}
+
+// refer adds an edge from a file to name, looked up in dirs as given and
+// with each of exts when it has no extension. A name found nowhere is
+// added as missing if it must be in the project.
+func (b *graphBuilder) refer(from, command string, line int, name string, dirs, exts []string, local bool) {
+	if path.Ext(name) != "" {
+		exts = []string{""}
+	}
+	var first string
+	for _, d := range dirs {
+		for _, ext := range exts {
+			p := path.Join(d, name+ext)
+			if strings.HasPrefix(p, "../") || path.IsAbs(p) {
+				continue
+			}
+			if first == "" {
+				first = p
+			}
+			if _, ok := b.files[p]; ok {
+				b.edges = append(b.edges, GraphEdge{From: from, To: p, Command: command, Line: line})
+				return
+			}
+		}
+	}
+	if local && first != "" {
+		b.node(first)
+		b.edges = append(b.edges, GraphEdge{From: from, To: first, Command: command, Line: line})
+	}
+}
+
+func readGraphSource(p string) (string, error) {
+	src, _, err := readSource(p, maxGraphSource)
+	return src, err
+}
+
+// readSource reads up to limit bytes of the file at p and reports whether
+// there was more
+func readSource(p string, limit int64) (string, bool, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if int64(len(data)) > limit {
+		return string(data[:limit]), true, err
+	}
+	return string(data), false, err
+}
+
+func intermediate(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range intermediateSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeKind tells what kind of file p is from its extension
+func nodeKind(p string) string {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".tex", ".ltx":
+		return NodeTeX
+	case ".sty", ".cls":
+		return NodeStyle
+	case ".bib":
+		return NodeBib
+	case ".pdf", ".png", ".jpg", ".jpeg", ".eps", ".svg", ".gif", ".tif", ".tiff":
+		return NodeImage
+	}
+	return NodeOther
+}
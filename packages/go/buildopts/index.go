@@ -0,0 +1,36 @@
+package buildopts
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Index processors latexmk can run on a document's .idx files. Glossaries
+// need no option: makeglossaries reads which processor to use from the .aux.
+const (
+	IndexMakeindex = "makeindex"
+	IndexXindy     = "xindy" // texindy, for UTF-8 and non-English sort orders
+)
+
+// IndexProcessors are the index processors a build may ask for
+var IndexProcessors = map[string]bool{
+	IndexMakeindex: true,
+	IndexXindy:     true,
+}
+
+// ValidateIndex checks that name is a known index processor. An empty name
+// uses makeindex.
+func ValidateIndex(name string) error {
+	if name != "" && !IndexProcessors[name] {
+		return &Error{Field: "index", Reason: fmt.Sprintf("%q is not one of makeindex, xindy", name)}
+	}
+	return nil
+}
+
+func normalizeIndex(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == IndexMakeindex {
+		return ""
+	}
+	return name
+}
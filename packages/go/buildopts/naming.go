@@ -0,0 +1,118 @@
+package buildopts
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultExportName is the name template of exported PDFs when the project
+// sets none: the main file's name, e.g. paper.pdf
+const DefaultExportName = "{main}.pdf"
+
+// MaxExportNameLen caps the length of export name templates and names
+const MaxExportNameLen = 128
+
+// ExportPlaceholders are the placeholders an export name template may use
+var ExportPlaceholders = map[string]bool{
+	"project": true, // name of the project directory
+	"main":    true, // main file without its extension
+	"target":  true, // target built; the main file's name for builds of none
+	"engine":  true,
+	"date":    true, // YYYY-MM-DD
+	"time":    true, // HHMMSS
+	"gitsha":  true, // short hash of the commit checked out
+	"build":   true, // build ID
+}
+
+var (
+	placeholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+	// unsafeNameRe matches runs of characters that do not belong in a file
+	// name on every platform
+	unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9._+-]+`)
+	dashesRe     = regexp.MustCompile(`[-_]*-[-_]*`)
+)
+
+// ExportVars are the values an export name template is filled in with.
+// Empty values drop their placeholder, e.g. {gitsha} outside a repository.
+type ExportVars struct {
+	Project string
+	Main    string // main file, with or without a directory and extension
+	Target  string
+	Engine  string
+	GitSHA  string
+	Build   string
+	Time    time.Time
+}
+
+// ValidateExportName checks that template only uses ExportPlaceholders
+func ValidateExportName(template string) error {
+	if len(template) > MaxExportNameLen {
+		return &Error{Field: "export_name", Reason: fmt.Sprintf("too long (max %d chars)", MaxExportNameLen)}
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return &Error{Field: "export_name", Reason: "must be a file name, not a path"}
+	}
+	for _, m := range placeholderRe.FindAllStringSubmatch(template, -1) {
+		if !ExportPlaceholders[m[1]] {
+			return &Error{Field: "export_name", Reason: fmt.Sprintf("unknown placeholder {%s}", m[1])}
+		}
+	}
+	return nil
+}
+
+// ExportName fills in template, DefaultExportName if empty, and returns a
+// file name safe on every platform that ends in .pdf
+func ExportName(template string, vars ExportVars) (string, error) {
+	if template == "" {
+		template = DefaultExportName
+	}
+	if err := ValidateExportName(template); err != nil {
+		return "", err
+	}
+
+	main := path.Base(strings.ReplaceAll(vars.Main, `\`, "/"))
+	main = strings.TrimSuffix(main, path.Ext(main))
+	if main == "." || main == "/" {
+		main = ""
+	}
+	target := vars.Target
+	if target == "" {
+		target = main
+	}
+	t := vars.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	values := map[string]string{
+		"project": vars.Project,
+		"main":    main,
+		"target":  target,
+		"engine":  vars.Engine,
+		"date":    t.Format("2006-01-02"),
+		"time":    t.Format("150405"),
+		"gitsha":  vars.GitSHA,
+		"build":   vars.Build,
+	}
+	name := placeholderRe.ReplaceAllStringFunc(template, func(p string) string {
+		return values[p[1:len(p)-1]]
+	})
+
+	// Placeholders that were empty leave separators behind: "paper--.pdf"
+	ext := path.Ext(name)
+	if !strings.EqualFold(ext, ".pdf") {
+		ext = ""
+	}
+	base := unsafeNameRe.ReplaceAllString(strings.TrimSuffix(name, ext), "-")
+	base = dashesRe.ReplaceAllString(base, "-")
+	base = strings.Trim(base, "-_.")
+	if base == "" {
+		base = "document"
+	}
+	if len(base) > MaxExportNameLen-4 {
+		base = base[:MaxExportNameLen-4]
+	}
+	return base + ".pdf", nil
+}
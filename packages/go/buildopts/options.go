@@ -0,0 +1,228 @@
+// Package buildopts holds the rules for LaTeX build options shared by the
+// remote and local compilers, the desktop app and the CLI, so an option that
+// is accepted in one place is accepted everywhere.
+package buildopts
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/security"
+)
+
+const (
+	DefaultEngine   = "pdflatex"
+	DefaultMainFile = "main.tex"
+	DefaultOutDir   = "output"
+	MaxSourceSize   = 100 * 1024 * 1024
+	MaxMainFileLen  = 256
+)
+
+// Engines lists the supported LaTeX engines
+var Engines = map[string]bool{
+	"pdflatex": true,
+	"xelatex":  true,
+	"lualatex": true,
+}
+
+// ErrShellEscapeNotAllowed is returned when shell-escape is requested but the
+// limits do not permit it
+var ErrShellEscapeNotAllowed = errors.New("shell-escape is not allowed")
+
+// ErrNetworkNotAllowed is returned when a build asks for network access but
+// the limits do not permit it
+var ErrNetworkNotAllowed = errors.New("network access is not allowed")
+
+// Error reports an invalid build option
+type Error struct {
+	Field  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// Options are the user-supplied settings of a build
+type Options struct {
+	MainFile        string
+	Engine          string
+	ShellEscape     bool
+	ShellRestricted bool              // allow only the RestrictedShellCommands; ignored with ShellEscape
+	AutoShell       bool              // the compiler may turn on ShellRestricted for packages that need it, see ApplyAutoShell
+	Env             map[string]string // extra compile environment; keys from AllowedEnv
+	OutDir          string            // latexmk output directory, relative to the project root
+	Reproducible    bool              // fixed timestamps and PDF IDs, see build.ManifestHash
+	Network         bool              // network access, e.g. for tlmgr; builds are offline by default
+	Sandbox         string            // one of Sandboxes; "" runs the build the compiler's default way
+	Timeout         time.Duration     // how long latexmk may run; 0 for the default, see ApplyTimeout
+	Index           string            // one of IndexProcessors; "" for makeindex
+	PDFX            bool              // also convert the PDF to PDF/X-1a, see build.ConvertPDFX
+	Split           bool              // also split the PDF into a PDF per section, see build.SplitRanges
+	SourceSize      int64             // bytes; 0 when the source size is not known yet
+}
+
+// Limits are the caller's constraints. Zero values fall back to the defaults.
+type Limits struct {
+	MaxSourceSize    int64
+	MaxMainFileLen   int
+	AllowShellEscape bool
+	AllowNetwork     bool
+	AutoShellEscape  bool // the compiler may turn on the restricted profile for documents that need it
+
+	Sandboxes          map[string]bool // sandboxes the compiler offers
+	Sandbox            string          // sandbox every build runs in, whatever it asks for
+	ShellEscapeSandbox string          // sandbox for full shell-escape builds that ask for none
+
+	DefaultTimeout time.Duration // timeout of builds that ask for none
+	MinTimeout     time.Duration // shortest timeout a build may ask for
+	MaxTimeout     time.Duration // longest timeout a build may ask for
+}
+
+// Normalize fills in the default main file and engine and lower-cases the
+// engine and index processor
+func (o *Options) Normalize() {
+	o.MainFile = strings.TrimSpace(o.MainFile)
+	if o.MainFile == "" {
+		o.MainFile = DefaultMainFile
+	}
+	o.Engine = strings.ToLower(strings.TrimSpace(o.Engine))
+	if o.Engine == "" {
+		o.Engine = DefaultEngine
+	}
+	o.OutDir = strings.Trim(strings.TrimSpace(o.OutDir), "/")
+	if o.OutDir == "" {
+		o.OutDir = DefaultOutDir
+	}
+	if o.ShellEscape {
+		o.ShellRestricted = false
+	}
+	o.Index = normalizeIndex(o.Index)
+}
+
+// RestrictShellEscape downgrades a request for full shell-escape to the
+// restricted profile when the limits do not allow it, and reports whether it
+// did. The restricted profile is allowed for everyone.
+func (o *Options) RestrictShellEscape(l Limits) bool {
+	if !o.ShellEscape || l.AllowShellEscape {
+		return false
+	}
+	o.ShellEscape = false
+	o.ShellRestricted = true
+	return true
+}
+
+// ApplyAutoShell lets the compiler turn on the restricted profile for
+// documents that need it when the limits allow it and the build asked for
+// no shell-escape of its own
+func (o *Options) ApplyAutoShell(l Limits) {
+	o.AutoShell = l.AutoShellEscape && !o.ShellEscape && !o.ShellRestricted
+}
+
+// Validate checks the engine, main file, shell-escape and network flags,
+// sandbox, timeout, index processor, environment, output directory and
+// source size. Call Normalize first to apply defaults.
+func (o Options) Validate(l Limits) error {
+	if err := ValidateEngine(o.Engine); err != nil {
+		return err
+	}
+
+	maxLen := l.MaxMainFileLen
+	if maxLen <= 0 {
+		maxLen = MaxMainFileLen
+	}
+	if err := validateMainFile(o.MainFile, maxLen); err != nil {
+		return err
+	}
+
+	if o.ShellEscape && !l.AllowShellEscape {
+		return ErrShellEscapeNotAllowed
+	}
+	if o.Network && !l.AllowNetwork {
+		return ErrNetworkNotAllowed
+	}
+	if err := validateSandbox(o.Sandbox, l); err != nil {
+		return err
+	}
+	if err := validateTimeout(o.Timeout); err != nil {
+		return err
+	}
+	if err := ValidateIndex(o.Index); err != nil {
+		return err
+	}
+	if err := ValidateEnv(o.Env); err != nil {
+		return err
+	}
+	if err := ValidateOutDir(o.OutDir); err != nil {
+		return err
+	}
+
+	maxSize := l.MaxSourceSize
+	if maxSize <= 0 {
+		maxSize = MaxSourceSize
+	}
+	return ValidateSourceSize(o.SourceSize, maxSize)
+}
+
+// ValidateEngine checks that engine is a supported LaTeX engine
+func ValidateEngine(engine string) error {
+	if !Engines[engine] {
+		return &Error{Field: "engine", Reason: fmt.Sprintf("%q is not one of pdflatex, xelatex, lualatex", engine)}
+	}
+	return nil
+}
+
+// ValidateMainFile checks that path is a relative path inside the project
+func ValidateMainFile(path string) error {
+	return validateMainFile(path, MaxMainFileLen)
+}
+
+func validateMainFile(path string, maxLen int) error {
+	if path == "" {
+		return &Error{Field: "main_file", Reason: "required"}
+	}
+	if len(path) > maxLen {
+		return &Error{Field: "main_file", Reason: fmt.Sprintf("too long (max %d chars)", maxLen)}
+	}
+	if security.HasPathTraversal(path) {
+		return &Error{Field: "main_file", Reason: "path traversal not allowed"}
+	}
+	return nil
+}
+
+// outDirPattern keeps output directories safe to pass to latexmk through a
+// shell
+var outDirPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// ValidateOutDir checks that dir names a directory inside the project. An
+// empty dir means DefaultOutDir.
+func ValidateOutDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if len(dir) > MaxMainFileLen {
+		return &Error{Field: "out_dir", Reason: fmt.Sprintf("too long (max %d chars)", MaxMainFileLen)}
+	}
+	if !outDirPattern.MatchString(dir) {
+		return &Error{Field: "out_dir", Reason: "may only contain letters, digits, '.', '_', '-' and '/'"}
+	}
+	if security.HasPathTraversal(dir) || path.Clean(dir) == "." {
+		return &Error{Field: "out_dir", Reason: "must be a directory inside the project"}
+	}
+	return nil
+}
+
+// ValidateSourceSize checks an uploaded project against max bytes
+func ValidateSourceSize(size, max int64) error {
+	if size < 0 {
+		return &Error{Field: "source", Reason: "negative size"}
+	}
+	if size > max {
+		return &Error{Field: "source", Reason: fmt.Sprintf("too large (max %dMB)", max/(1024*1024))}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package buildopts
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Kinds of reference key
+const (
+	KeyLabel    = "label"
+	KeyCitation = "citation"
+)
+
+var (
+	labelPattern = regexp.MustCompile(`\\label\s*(?:\[[^\]]*\]\s*)?\{([^{}]+)\}`)
+	// labelRefPattern finds references to labels; cleveref's take lists
+	labelRefPattern = regexp.MustCompile(`\\(?:ref|eqref|pageref|autoref|nameref|vref|Vref|cref|Cref|cpageref|Cpageref|labelcref|subref)\*?\s*\{([^{}]+)\}`)
+	// citePattern finds the citation commands of natbib and biblatex:
+	// \cite, \citep[p.~3]{key}, \parencite, \textcite, \footcite, \nocite, ...
+	citePattern     = regexp.MustCompile(`\\([A-Za-z]*[Cc]ite[A-Za-z]*)\*?\s*(?:\[[^\]]*\]\s*){0,2}\{([^{}]+)\}`)
+	bibitemPattern  = regexp.MustCompile(`\\bibitem\s*(?:\[[^\]]*\]\s*)?\{([^{}]+)\}`)
+	bibEntryPattern = regexp.MustCompile(`@\s*([A-Za-z]+)\s*[{(]\s*([^,\s{}()]+)\s*,`)
+)
+
+// notCitations are commands citePattern matches that do not cite
+var notCitations = map[string]bool{"citestyle": true, "citeindextrue": true, "citeindexfalse": true}
+
+// RefLocation is where in the project a key is defined or used
+type RefLocation struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// RefIssue is a label or citation key that is undefined, defined more than
+// once, or never used. Locations are where it is used if undefined and
+// where it is defined otherwise.
+type RefIssue struct {
+	Kind      string        `json:"kind"` // label or citation
+	Key       string        `json:"key"`
+	Locations []RefLocation `json:"locations"`
+}
+
+// ReferenceReport is what CheckReferences found
+type ReferenceReport struct {
+	Files      int        `json:"files"` // .tex and .bib files checked
+	Labels     int        `json:"labels"`
+	BibEntries int        `json:"bib_entries"`
+	Undefined  []RefIssue `json:"undefined"`
+	Duplicate  []RefIssue `json:"duplicate"`
+	Unused     []RefIssue `json:"unused"`
+	// Truncated lists the files too large to check in full; keys past the
+	// cut may show up as undefined or unused
+	Truncated []string `json:"truncated,omitempty"`
+}
+
+// CheckReferences cross-checks the \ref and \cite commands of the project
+// in dir against its \label commands, \bibitem entries and .bib entries,
+// reading the sources rather than compiling. Only the files of graph that
+// its main files use are checked, or all of them if it has none. Keys built
+// from macro parameters (#1) are skipped, and \nocite{*} uses every entry.
+func CheckReferences(dir string, graph *ProjectGraph) (*ReferenceReport, error) {
+	labels := make(map[string][]RefLocation)
+	refs := make(map[string][]RefLocation)
+	entries := make(map[string][]RefLocation)
+	cites := make(map[string][]RefLocation)
+	nociteAll := false
+
+	report := &ReferenceReport{}
+	for _, n := range graph.Nodes {
+		if n.Missing || (len(graph.Roots) > 0 && !n.Reachable) || (n.Kind != NodeTeX && n.Kind != NodeBib) {
+			continue
+		}
+		limit := int64(maxGraphSource)
+		if n.Kind == NodeBib {
+			limit = maxBibSource
+		}
+		src, truncated, err := readSource(filepath.Join(dir, filepath.FromSlash(n.Path)), limit)
+		if err != nil {
+			return nil, err
+		}
+		report.Files++
+		if truncated {
+			report.Truncated = append(report.Truncated, n.Path)
+		}
+
+		if n.Kind == NodeBib {
+			for i, line := range strings.Split(src, "\n") {
+				for _, m := range bibEntryPattern.FindAllStringSubmatch(line, -1) {
+					switch strings.ToLower(m[1]) {
+					case "string", "comment", "preamble":
+						continue
+					}
+					entries[m[2]] = append(entries[m[2]], RefLocation{File: n.Path, Line: i + 1})
+				}
+			}
+			continue
+		}
+
+		for i, line := range strings.Split(stripComments(src), "\n") {
+			at := RefLocation{File: n.Path, Line: i + 1}
+			for _, m := range labelPattern.FindAllStringSubmatch(line, -1) {
+				addKeys(labels, m[1], at, false)
+			}
+			for _, m := range labelRefPattern.FindAllStringSubmatch(line, -1) {
+				addKeys(refs, m[1], at, true)
+			}
+			for _, m := range bibitemPattern.FindAllStringSubmatch(line, -1) {
+				addKeys(entries, m[1], at, false)
+			}
+			for _, m := range citePattern.FindAllStringSubmatch(line, -1) {
+				if notCitations[m[1]] {
+					continue
+				}
+				if m[1] == "nocite" && strings.TrimSpace(m[2]) == "*" {
+					nociteAll = true
+					continue
+				}
+				addKeys(cites, m[2], at, true)
+			}
+		}
+	}
+
+	report.Labels = len(labels)
+	report.BibEntries = len(entries)
+	report.Undefined = append(missingKeys(KeyLabel, refs, labels), missingKeys(KeyCitation, cites, entries)...)
+	report.Duplicate = append(duplicateKeys(KeyLabel, labels), duplicateKeys(KeyCitation, entries)...)
+	report.Unused = missingKeys(KeyLabel, labels, refs)
+	if !nociteAll {
+		report.Unused = append(report.Unused, missingKeys(KeyCitation, entries, cites)...)
+	}
+	return report, nil
+}
+
+// addKeys records at as a location of each key in keys, a comma separated
+// list if list is set
+func addKeys(m map[string][]RefLocation, keys string, at RefLocation, list bool) {
+	names := []string{keys}
+	if list {
+		names = strings.Split(keys, ",")
+	}
+	for _, key := range names {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.Contains(key, "#") {
+			continue
+		}
+		m[key] = append(m[key], at)
+	}
+}
+
+// missingKeys returns the keys of have that want lacks, sorted
+func missingKeys(kind string, have, want map[string][]RefLocation) []RefIssue {
+	issues := []RefIssue{}
+	for key, locs := range have {
+		if _, ok := want[key]; !ok {
+			issues = append(issues, RefIssue{Kind: kind, Key: key, Locations: locs})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Key < issues[j].Key })
+	return issues
+}
+
+// duplicateKeys returns the keys of defs defined more than once, sorted
+func duplicateKeys(kind string, defs map[string][]RefLocation) []RefIssue {
+	issues := []RefIssue{}
+	for key, locs := range defs {
+		if len(locs) > 1 {
+			issues = append(issues, RefIssue{Kind: kind, Key: key, Locations: locs})
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Key < issues[j].Key })
+	return issues
+}
@@ -0,0 +1,158 @@
+package buildopts
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultContainerUser runs compile containers as an unprivileged user
+	// when the host gives no better choice
+	DefaultContainerUser = "1000:1000"
+	// DefaultPidsLimit caps the processes a compile container may fork
+	DefaultPidsLimit = 256
+	// SeccompUnconfined disables seccomp filtering
+	SeccompUnconfined = "unconfined"
+)
+
+var containerUserPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*(:[A-Za-z0-9_][A-Za-z0-9_.-]*)?$`)
+
+// RuntimeProfile says how a compile container is locked down. The zero value
+// runs it with Docker's defaults.
+type RuntimeProfile struct {
+	ReadOnlyRootfs   bool   // only the build mount and a /tmp tmpfs are writable
+	User             string // user[:group] to run as; "" keeps the image's user
+	Seccomp          string // path to a seccomp profile or "unconfined"; "" is Docker's default profile
+	AppArmor         string // AppArmor profile name; "" is Docker's default profile
+	PidsLimit        int64  // 0 leaves the runtime's default
+	NoNewPrivileges  bool   // setuid binaries cannot gain privileges
+	DropCapabilities bool   // drop every Linux capability
+}
+
+// HardenedProfile returns the profile compile containers run with unless a
+// deployment says otherwise: read-only root filesystem, no capabilities, no
+// privilege escalation, a pids limit, and the host user so the files written
+// to the build mount stay the caller's. Network access is controlled
+// separately by the compilers.
+func HardenedProfile() RuntimeProfile {
+	return RuntimeProfile{
+		ReadOnlyRootfs:   true,
+		User:             hostUser(),
+		PidsLimit:        DefaultPidsLimit,
+		NoNewPrivileges:  true,
+		DropCapabilities: true,
+	}
+}
+
+// hostUser returns the uid:gid of the current process, or
+// DefaultContainerUser when it is root or has no numeric ids (Windows)
+func hostUser() string {
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid <= 0 || gid < 0 {
+		return DefaultContainerUser
+	}
+	return fmt.Sprintf("%d:%d", uid, gid)
+}
+
+// Validate checks the user and pids limit
+func (p RuntimeProfile) Validate() error {
+	if p.User != "" && !containerUserPattern.MatchString(p.User) {
+		return &Error{Field: "container user", Reason: fmt.Sprintf("%q is not user[:group]", p.User)}
+	}
+	if user, _, _ := strings.Cut(p.User, ":"); user == "0" || user == "root" {
+		return &Error{Field: "container user", Reason: "must not be root"}
+	}
+	if p.PidsLimit < 0 {
+		return &Error{Field: "pids limit", Reason: "must not be negative"}
+	}
+	return nil
+}
+
+// UserIDs returns the numeric uid and gid of User, with ok false when User
+// is empty or names a user rather than an id. A missing group is the uid.
+func (p RuntimeProfile) UserIDs() (uid, gid int, ok bool) {
+	user, group, _ := strings.Cut(p.User, ":")
+	uid, err := strconv.Atoi(user)
+	if err != nil {
+		return 0, 0, false
+	}
+	gid = uid
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			return 0, 0, false
+		}
+	}
+	return uid, gid, true
+}
+
+// Env returns the environment TeX needs when it cannot write to the image:
+// the kpathsea and font caches go to /tmp
+func (p RuntimeProfile) Env() []string {
+	if !p.ReadOnlyRootfs && p.User == "" {
+		return nil
+	}
+	return []string{
+		"HOME=/tmp",
+		"TEXMFVAR=/tmp/texmf-var",
+		"TEXMFCONFIG=/tmp/texmf-config",
+		"XDG_CACHE_HOME=/tmp/.cache",
+	}
+}
+
+// SecurityOpts returns the Docker security options of the profile. The
+// Engine API takes a seccomp profile's JSON rather than its path, so the
+// file is read here.
+func (p RuntimeProfile) SecurityOpts() ([]string, error) {
+	var opts []string
+	if p.NoNewPrivileges {
+		opts = append(opts, "no-new-privileges")
+	}
+	switch p.Seccomp {
+	case "":
+	case SeccompUnconfined:
+		opts = append(opts, "seccomp="+SeccompUnconfined)
+	default:
+		data, err := os.ReadFile(p.Seccomp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seccomp profile: %w", err)
+		}
+		opts = append(opts, "seccomp="+string(data))
+	}
+	if p.AppArmor != "" {
+		opts = append(opts, "apparmor="+p.AppArmor)
+	}
+	return opts, nil
+}
+
+// DockerArgs returns the `docker run` flags of the profile
+func (p RuntimeProfile) DockerArgs() []string {
+	var args []string
+	if p.ReadOnlyRootfs {
+		args = append(args, "--read-only", "--tmpfs=/tmp:rw,exec,mode=1777")
+	}
+	if p.User != "" {
+		args = append(args, "--user="+p.User)
+	}
+	if p.NoNewPrivileges {
+		args = append(args, "--security-opt=no-new-privileges")
+	}
+	if p.Seccomp != "" {
+		args = append(args, "--security-opt=seccomp="+p.Seccomp)
+	}
+	if p.AppArmor != "" {
+		args = append(args, "--security-opt=apparmor="+p.AppArmor)
+	}
+	if p.DropCapabilities {
+		args = append(args, "--cap-drop=ALL")
+	}
+	if p.PidsLimit > 0 {
+		args = append(args, fmt.Sprintf("--pids-limit=%d", p.PidsLimit))
+	}
+	for _, kv := range p.Env() {
+		args = append(args, "--env="+kv)
+	}
+	return args
+}
@@ -0,0 +1,59 @@
+package buildopts
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sandboxes a build can run in for stronger isolation than a plain runc
+// container. Which ones a compiler offers depends on its deployment.
+const (
+	SandboxGVisor      = "gvisor"      // gVisor's runsc user-space kernel
+	SandboxFirecracker = "firecracker" // a Firecracker microVM
+)
+
+// Sandboxes are the sandboxes a build may ask for
+var Sandboxes = map[string]bool{
+	SandboxGVisor:      true,
+	SandboxFirecracker: true,
+}
+
+// ErrSandboxNotAvailable is returned when a build asks for a sandbox the
+// compiler does not offer
+var ErrSandboxNotAvailable = errors.New("sandbox is not available")
+
+// ValidateSandbox checks that name is a known sandbox. An empty name runs
+// the build the compiler's default way.
+func ValidateSandbox(name string) error {
+	if name != "" && !Sandboxes[name] {
+		return &Error{Field: "sandbox", Reason: fmt.Sprintf("%q is not one of gvisor, firecracker", name)}
+	}
+	return nil
+}
+
+// ApplySandbox sets the sandbox of a build: the limits' sandbox overrides
+// the one asked for, and full shell-escape builds that ask for none get the
+// shell-escape one
+func (o *Options) ApplySandbox(l Limits) {
+	o.Sandbox = strings.ToLower(strings.TrimSpace(o.Sandbox))
+	if l.Sandbox != "" {
+		o.Sandbox = l.Sandbox
+	}
+	if o.Sandbox == "" && o.ShellEscape {
+		o.Sandbox = l.ShellEscapeSandbox
+	}
+}
+
+func validateSandbox(name string, l Limits) error {
+	if name == "" {
+		return nil
+	}
+	if err := ValidateSandbox(name); err != nil {
+		return err
+	}
+	if !l.Sandboxes[name] {
+		return fmt.Errorf("%w: %s", ErrSandboxNotAvailable, name)
+	}
+	return nil
+}
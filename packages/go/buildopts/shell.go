@@ -0,0 +1,193 @@
+package buildopts
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Values of the shell_escape form field. Anything else means disabled.
+const (
+	ShellEscapeFull       = "true"
+	ShellEscapeRestricted = "restricted"
+)
+
+// RestrictedShellCommands are the programs a document may run through
+// \write18 under the restricted shell-escape profile: the TeX Live defaults,
+// none of which can start another program, plus the ShimmedShellCommands.
+// Each runs with its arguments quoted by the engine, so no shell
+// metacharacters get through.
+var RestrictedShellCommands = []string{
+	"bibtex", "bibtex8", "extractbb", "kpsewhich", "makeindex", "repstopdf", "rpdfcrop",
+}
+
+// ShimmedShellCommands are what minted and epstopdf need. Given the right
+// arguments they run other programs (epstopdf's --gscmd, pygmentize's -x
+// lexers), so restricted builds only reach them through the treefrog-shell
+// shim in ShellShimDir, which refuses such arguments before running the real
+// program.
+var ShimmedShellCommands = []string{"epstopdf", "pygmentize"}
+
+// ShellShimDir holds treefrog-shell and a link to it named after each of the
+// ShimmedShellCommands. Restricted builds find it first on their PATH.
+const ShellShimDir = "/usr/local/lib/treefrog/shell"
+
+// ShellShim is the path of the treefrog-shell shim
+const ShellShim = ShellShimDir + "/treefrog-shell"
+
+// ParseShellEscape reads a shell_escape form value
+func ParseShellEscape(value string) (escape, restricted bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case ShellEscapeFull:
+		return true, false
+	case ShellEscapeRestricted:
+		return false, true
+	}
+	return false, false
+}
+
+// FormatShellEscape is the inverse of ParseShellEscape
+func FormatShellEscape(escape, restricted bool) string {
+	switch {
+	case escape:
+		return ShellEscapeFull
+	case restricted:
+		return ShellEscapeRestricted
+	}
+	return "false"
+}
+
+// RestrictedShellEnv returns the environment that makes a TeX engine started
+// with -shell-restricted allow exactly RestrictedShellCommands and
+// ShimmedShellCommands, the latter through ShellShimDir placed ahead of path.
+// kpathsea reads these texmf.cnf variables from the environment before its
+// config files.
+func RestrictedShellEnv(path string) []string {
+	commands := append(RestrictedShellCommands[:len(RestrictedShellCommands):len(RestrictedShellCommands)], ShimmedShellCommands...)
+	return []string{
+		"shell_escape=p",
+		"shell_escape_commands=" + strings.Join(commands, ","),
+		"PATH=" + ShellShimDir + ":" + path,
+	}
+}
+
+// shellName matches the lexer, formatter, filter and style names pygmentize
+// may be given. Names with a colon or slash would load Python from a file.
+var shellName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+#-]*$`)
+
+// shellOption matches the key=value options pygmentize may be given
+var shellOption = regexp.MustCompile(`^[A-Za-z_]+=[^:/\\]*$`)
+
+// epstopdfFlags are the epstopdf options that neither start a program nor
+// loosen ghostscript's -dSAFER
+var epstopdfFlags = map[string]bool{
+	"--hires": true, "--exact": true, "--debug": true, "--filter": true,
+	"--compress": true, "--nocompress": true, "--embed": true, "--noembed": true,
+	"--autorotate=None": true, "--autorotate=All": true, "--autorotate=PageByPage": true,
+}
+
+// CheckShellCommand checks the arguments a restricted build passes to one of
+// the ShimmedShellCommands and returns those to run the real program with.
+// Files must be relative to the build's directory and stay inside it.
+func CheckShellCommand(name string, args []string) ([]string, error) {
+	switch name {
+	case "pygmentize":
+		if err := checkPygmentize(args); err != nil {
+			return nil, err
+		}
+		return args, nil
+	case "epstopdf":
+		if err := checkEpstopdf(args); err != nil {
+			return nil, err
+		}
+		// --restricted makes epstopdf itself refuse --gscmd and unsafe files
+		return append([]string{"--restricted"}, args...), nil
+	}
+	return nil, fmt.Errorf("%s is not allowed in restricted shell-escape", name)
+}
+
+func checkPygmentize(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-V":
+			continue
+		case "-l", "-f", "-F", "-S", "-O", "-P", "-o":
+			if i+1 == len(args) {
+				return fmt.Errorf("pygmentize %s needs a value", arg)
+			}
+			i++
+			if err := checkPygmentizeValue(arg, args[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("pygmentize option %s is not allowed", arg)
+		}
+		if err := checkShellPath(arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPygmentizeValue(flag, value string) error {
+	switch flag {
+	case "-o":
+		return checkShellPath(value)
+	case "-O", "-P":
+		for _, opt := range strings.Split(value, ",") {
+			if !shellOption.MatchString(opt) {
+				return fmt.Errorf("pygmentize option %q is not allowed", opt)
+			}
+		}
+		return nil
+	}
+	if !shellName.MatchString(value) {
+		return fmt.Errorf("pygmentize name %q is not allowed", value)
+	}
+	return nil
+}
+
+func checkEpstopdf(args []string) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case epstopdfFlags[arg]:
+		case strings.HasPrefix(arg, "--outfile="):
+			if err := checkShellPath(strings.TrimPrefix(arg, "--outfile=")); err != nil {
+				return err
+			}
+		case arg == "--outfile" || arg == "-o":
+			if i+1 == len(args) {
+				return fmt.Errorf("epstopdf %s needs a value", arg)
+			}
+			i++
+			if err := checkShellPath(args[i]); err != nil {
+				return err
+			}
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("epstopdf option %s is not allowed", arg)
+		default:
+			if err := checkShellPath(arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkShellPath refuses files outside the build's directory
+func checkShellPath(p string) error {
+	if p == "" || path.IsAbs(p) || strings.ContainsRune(p, '\\') {
+		return fmt.Errorf("file %q is not allowed", p)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return fmt.Errorf("file %q is outside the project", p)
+		}
+	}
+	return nil
+}
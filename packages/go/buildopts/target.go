@@ -0,0 +1,172 @@
+package buildopts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ProjectConfigName is the file in a project's root that declares its build
+// targets, preprocessors and figures
+const ProjectConfigName = ".treefrog.json"
+
+// MaxTargets caps how many documents a project may declare
+const MaxTargets = 16
+
+// targetNamePattern keeps target names safe in URLs and directory names
+var targetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Target is one of several independent documents of a project, e.g. the
+// paper, its slides and a response letter, declared in .treefrog.json and
+// built on its own
+type Target struct {
+	Name     string `json:"name"`
+	MainFile string `json:"main_file"`
+	Engine   string `json:"engine,omitempty"`  // DefaultEngine if empty
+	OutDir   string `json:"out_dir,omitempty"` // DefaultOutDir/<name> if empty, so targets keep apart
+	Index    string `json:"index,omitempty"`
+	// ExportName is the name template of the target's exported PDF, see
+	// ExportName; the project's if empty
+	ExportName string `json:"export_name,omitempty"`
+}
+
+// ProjectSettings are the settings of .treefrog.json that are not about
+// how the compiler runs: the project's targets and how its PDFs are named
+type ProjectSettings struct {
+	Targets    []Target `json:"targets"`
+	ExportName string   `json:"export_name,omitempty"` // DefaultExportName if empty
+}
+
+// Normalize fills in the defaults of the settings and checks them
+func (s *ProjectSettings) Normalize() error {
+	if err := ValidateExportName(s.ExportName); err != nil {
+		return err
+	}
+	return NormalizeTargets(s.Targets)
+}
+
+// ExportNameFor returns the export name template of builds of mainFile: the
+// one of the target that builds it, or else the project's
+func (s ProjectSettings) ExportNameFor(target, mainFile string) string {
+	for _, t := range s.Targets {
+		if (t.Name == target || target == "" && t.MainFile == path.Clean(mainFile)) && t.ExportName != "" {
+			return t.ExportName
+		}
+	}
+	return s.ExportName
+}
+
+// NormalizeTargets fills in the defaults of targets and checks them and
+// that their names and output directories are unique, so targets can be
+// compiled at once in one directory
+func NormalizeTargets(targets []Target) error {
+	if len(targets) > MaxTargets {
+		return &Error{Field: "targets", Reason: fmt.Sprintf("at most %d", MaxTargets)}
+	}
+	seen := make(map[string]bool, len(targets))
+	outDirs := make(map[string]string, len(targets))
+	for i := range targets {
+		t := &targets[i]
+		if err := ValidateTargetName(t.Name); err != nil {
+			return err
+		}
+		if seen[t.Name] {
+			return &Error{Field: "target", Reason: fmt.Sprintf("%q is declared twice", t.Name)}
+		}
+		seen[t.Name] = true
+
+		t.MainFile = path.Clean(strings.TrimSpace(t.MainFile))
+		t.Engine = strings.ToLower(strings.TrimSpace(t.Engine))
+		if t.Engine == "" {
+			t.Engine = DefaultEngine
+		}
+		t.OutDir = strings.Trim(strings.TrimSpace(t.OutDir), "/")
+		if t.OutDir == "" {
+			t.OutDir = DefaultOutDir + "/" + t.Name
+		}
+		t.Index = normalizeIndex(t.Index)
+
+		if err := validateMainFile(t.MainFile, MaxMainFileLen); err != nil || t.MainFile == "." {
+			return &Error{Field: "target", Reason: fmt.Sprintf("%s: invalid main_file %q", t.Name, t.MainFile)}
+		}
+		if err := ValidateEngine(t.Engine); err != nil {
+			return &Error{Field: "target", Reason: t.Name + ": " + err.Error()}
+		}
+		if err := ValidateOutDir(t.OutDir); err != nil {
+			return &Error{Field: "target", Reason: t.Name + ": " + err.Error()}
+		}
+		if err := ValidateIndex(t.Index); err != nil {
+			return &Error{Field: "target", Reason: t.Name + ": " + err.Error()}
+		}
+		if err := ValidateExportName(t.ExportName); err != nil {
+			return &Error{Field: "target", Reason: t.Name + ": " + err.Error()}
+		}
+		if other, ok := outDirs[path.Clean(t.OutDir)]; ok {
+			return &Error{Field: "target", Reason: fmt.Sprintf("%s and %s share out_dir %q", other, t.Name, t.OutDir)}
+		}
+		outDirs[path.Clean(t.OutDir)] = t.Name
+	}
+	return nil
+}
+
+// LoadProjectSettings reads the settings of the .treefrog.json of the
+// project in dir. A project without one has the defaults.
+func LoadProjectSettings(dir string) (ProjectSettings, error) {
+	var settings ProjectSettings
+	data, err := os.ReadFile(filepath.Join(dir, ProjectConfigName))
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return settings, fmt.Errorf("invalid %s: %w", ProjectConfigName, err)
+	}
+	if err := settings.Normalize(); err != nil {
+		return settings, fmt.Errorf("invalid %s: %w", ProjectConfigName, err)
+	}
+	return settings, nil
+}
+
+// LoadTargets reads the targets declared in the .treefrog.json of the
+// project in dir. A project without one declares none.
+func LoadTargets(dir string) ([]Target, error) {
+	settings, err := LoadProjectSettings(dir)
+	return settings.Targets, err
+}
+
+// ValidateTargetName checks that name may name a target
+func ValidateTargetName(name string) error {
+	if !targetNamePattern.MatchString(name) {
+		return &Error{Field: "target", Reason: fmt.Sprintf("name %q must be lower-case letters, digits, '-' and '_' (max 32 chars)", name)}
+	}
+	return nil
+}
+
+// FindTarget returns the target of targets named name
+func FindTarget(targets []Target, name string) (Target, error) {
+	for _, t := range targets {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	if len(targets) == 0 {
+		return Target{}, &Error{Field: "target", Reason: fmt.Sprintf("the project declares no targets in %s", ProjectConfigName)}
+	}
+	return Target{}, &Error{Field: "target", Reason: fmt.Sprintf("the project has no target %q", name)}
+}
+
+// Apply sets the main file, engine, output directory and index processor
+// of o to the target's
+func (t Target) Apply(o *Options) {
+	o.MainFile = t.MainFile
+	o.Engine = t.Engine
+	o.OutDir = t.OutDir
+	o.Index = t.Index
+}
@@ -0,0 +1,57 @@
+package buildopts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout = 10 * time.Minute // how long latexmk may run unless limited otherwise
+	MinTimeout     = 30 * time.Second
+)
+
+// ParseTimeout parses a requested build timeout in whole seconds. An empty
+// value requests the default.
+func ParseTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, &Error{Field: "timeout", Reason: "must be a whole number of seconds"}
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// ApplyTimeout resolves the timeout of a build: builds that ask for none get
+// the limits' default, and requests are clamped to the limits' range
+func (o *Options) ApplyTimeout(l Limits) {
+	shortest, longest, def := l.MinTimeout, l.MaxTimeout, l.DefaultTimeout
+	if longest <= 0 {
+		longest = DefaultTimeout
+	}
+	if shortest <= 0 || shortest > longest {
+		shortest = min(MinTimeout, longest)
+	}
+	if def <= 0 || def > longest {
+		def = longest
+	}
+	switch {
+	case o.Timeout <= 0:
+		o.Timeout = def
+	case o.Timeout > longest:
+		o.Timeout = longest
+	case o.Timeout < shortest:
+		o.Timeout = shortest
+	}
+}
+
+func validateTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return &Error{Field: "timeout", Reason: fmt.Sprintf("%v is negative", timeout)}
+	}
+	return nil
+}
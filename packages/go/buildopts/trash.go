@@ -0,0 +1,50 @@
+package buildopts
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/security"
+)
+
+// TrashDirName is the hidden directory of a project that unused files are
+// moved to. Being hidden, it is left out of uploads and project graphs.
+const TrashDirName = ".treefrog-trash"
+
+// MoveToTrash moves files, relative to the project in dir, into a new
+// directory of TrashDirName named after the time, keeping their paths. It
+// returns that directory relative to dir and the files moved, which are
+// all of them unless it fails part way.
+func MoveToTrash(dir string, files []string) (string, []string, error) {
+	for _, f := range files {
+		if f == "" || security.HasPathTraversal(f) || path.Clean(f) == "." {
+			return "", nil, &Error{Field: "files", Reason: fmt.Sprintf("invalid path %q", f)}
+		}
+	}
+
+	stamp := time.Now().Format("20060102-150405")
+	trash := path.Join(TrashDirName, stamp)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(trash))); os.IsNotExist(err) {
+			break
+		}
+		trash = path.Join(TrashDirName, fmt.Sprintf("%s-%d", stamp, i))
+	}
+
+	moved := make([]string, 0, len(files))
+	for _, f := range files {
+		f = path.Clean(f)
+		dst := filepath.Join(dir, filepath.FromSlash(trash), filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return trash, moved, err
+		}
+		if err := os.Rename(filepath.Join(dir, filepath.FromSlash(f)), dst); err != nil {
+			return trash, moved, err
+		}
+		moved = append(moved, f)
+	}
+	return trash, moved, nil
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrDegraded is returned without contacting the compiler while the circuit
+// breaker is open
+var ErrDegraded = errors.New("builder degraded: compiler is unavailable, retrying later")
+
+// Breaker is a circuit breaker around compiler requests. After Threshold
+// consecutive failures it opens and requests fail fast with ErrDegraded.
+// Once Cooldown has passed a single probe request is let through; its
+// outcome closes the breaker again or restarts the cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker creates a closed circuit breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow reports whether a request may be sent, returning ErrDegraded if not
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.Cooldown {
+		return ErrDegraded
+	}
+	b.probing = true
+	return nil
+}
+
+// Success records a request that reached a healthy compiler
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// Failure records a request that failed because of the compiler or network
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.Threshold {
+		b.openedAt = time.Now()
+	}
+	b.mu.Unlock()
+}
+
+// Release records a request whose outcome says nothing about the
+// compiler's health. A probe that ends this way lets the next request probe.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// Degraded reports whether the breaker is open
+func (b *Breaker) Degraded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.Threshold
+}
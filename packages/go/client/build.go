@@ -0,0 +1,221 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+	"github.com/alpha-og/treefrog/packages/go/buildopts"
+	"github.com/alpha-og/treefrog/packages/go/security"
+)
+
+const (
+	DefaultPollInterval = 1 * time.Second
+	maxPollInterval     = 5 * time.Second
+)
+
+// CreateBuild uploads a zipped project and queues a build of it. With a
+// SourceKey the archive is encrypted before it leaves the client.
+func (c *Client) CreateBuild(ctx context.Context, source io.Reader, opts api.BuildOptions) (*api.BuildResponse, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	_ = writer.WriteField("main_file", opts.MainFile)
+	_ = writer.WriteField("engine", string(opts.Engine))
+	_ = writer.WriteField("shell_escape", buildopts.FormatShellEscape(opts.ShellEscape, opts.ShellRestricted))
+	if opts.Reproducible {
+		_ = writer.WriteField("reproducible", "true")
+	}
+	if opts.Network {
+		_ = writer.WriteField("network", "true")
+	}
+	if opts.Sandbox != "" {
+		_ = writer.WriteField("sandbox", opts.Sandbox)
+	}
+	if opts.PDFX {
+		_ = writer.WriteField("pdfx", "true")
+	}
+	if opts.Split {
+		_ = writer.WriteField("split", "true")
+	}
+	if opts.Index != "" {
+		_ = writer.WriteField("index", opts.Index)
+	}
+	if opts.Timeout > 0 {
+		_ = writer.WriteField("timeout", strconv.Itoa(opts.Timeout))
+	}
+	if opts.OutDir != "" {
+		_ = writer.WriteField("out_dir", opts.OutDir)
+	}
+	if len(opts.Env) > 0 {
+		env, err := json.Marshal(opts.Env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode build environment: %w", err)
+		}
+		_ = writer.WriteField("env", string(env))
+	}
+
+	filename := "source.zip"
+	if c.SourceKey != nil {
+		plaintext, err := io.ReadAll(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read project source: %w", err)
+		}
+		sealed, err := security.SealSource(c.SourceKey, plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt project source: %w", err)
+		}
+		source = bytes.NewReader(sealed)
+		filename = "source.zip.sealed"
+		_ = writer.WriteField("encrypted", "true")
+	}
+
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := io.Copy(part, source); err != nil {
+		return nil, fmt.Errorf("failed to read project source: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode build request: %w", err)
+	}
+
+	endpoint := c.url(ctx, "/build")
+	if opts.Target != "" {
+		endpoint += "?target=" + url.QueryEscape(opts.Target)
+	}
+	resp, err := c.send(ctx, request{
+		method:      http.MethodPost,
+		url:         endpoint,
+		body:        body.Bytes(),
+		contentType: writer.FormDataContentType(),
+		progress:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var build api.BuildResponse
+	if err := decode(resp, &build); err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// Status fetches the current status of a build. Legacy status names are
+// normalized, so callers can compare against the api.Status constants.
+func (c *Client) Status(ctx context.Context, id string) (*api.StatusResponse, error) {
+	resp, err := c.get(ctx, "/build/"+url.PathEscape(id)+"/status")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var status api.StatusResponse
+	if err := decode(resp, &status); err != nil {
+		return nil, err
+	}
+	if parsed, ok := api.ParseStatus(string(status.Status)); ok {
+		status.Status = parsed
+	}
+	return &status, nil
+}
+
+// WaitForBuild polls a build until it reaches a terminal status or ctx is
+// done. The poll interval starts at DefaultPollInterval and backs off while
+// the build is still running. onStatus, if set, sees every status polled.
+//
+// Transient errors, including an open circuit breaker, do not end the wait:
+// they are passed to onStatus with a nil status and polling continues.
+func (c *Client) WaitForBuild(ctx context.Context, id string, onStatus func(*api.StatusResponse, error)) (*api.StatusResponse, error) {
+	interval := DefaultPollInterval
+	for {
+		status, err := c.Status(ctx, id)
+		if err != nil && (ctx.Err() != nil || !IsTransient(err)) {
+			return nil, err
+		}
+		if onStatus != nil {
+			onStatus(status, err)
+		}
+		if status != nil && status.Status.Terminal() {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = min(interval*3/2, maxPollInterval)
+	}
+}
+
+// DownloadArtifact writes a build artifact ("pdf", "log" or "synctex") to
+// w and returns the number of bytes written. The remote compiler hands out
+// signed URLs for artifacts; the local compiler serves them directly.
+func (c *Client) DownloadArtifact(ctx context.Context, id, resource string, w io.Writer) (int64, error) {
+	resp, err := c.openArtifact(ctx, id, resource)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body := newRateLimitedReader(ctx, resp.Body, c.DownloadLimiter)
+	n, err := io.Copy(w, newProgressReader(body, OpDownload, resp.ContentLength, c.OnProgress))
+	if err != nil {
+		return n, fmt.Errorf("failed to download %s: %w", resource, err)
+	}
+	return n, nil
+}
+
+func (c *Client) openArtifact(ctx context.Context, id, resource string) (*http.Response, error) {
+	base := "/build/" + url.PathEscape(id)
+
+	var signed struct {
+		URL string `json:"url"`
+	}
+	err := c.GetJSON(ctx, base+"/pdf/url?resource="+url.QueryEscape(resource), &signed)
+	if IsNotFound(err) {
+		return c.get(ctx, base+"/"+url.PathEscape(resource))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signed URL: %w", err)
+	}
+	if signed.URL == "" {
+		return nil, fmt.Errorf("signed URL is empty")
+	}
+
+	// Signed URLs are relative to the compiler, or point at the bucket the
+	// artifacts are stored in
+	downloadURL := signed.URL
+	if !strings.HasPrefix(downloadURL, "http") {
+		downloadURL = c.BaseURL + downloadURL
+	}
+	return c.send(ctx, request{method: http.MethodGet, url: downloadURL, idempotent: true, external: !c.sameHost(downloadURL)})
+}
+
+// Log returns the LaTeX log of a build
+func (c *Client) Log(ctx context.Context, id string) (string, error) {
+	resp, err := c.get(ctx, "/build/"+url.PathEscape(id)+"/log")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read build log: %w", err)
+	}
+	return string(data), nil
+}
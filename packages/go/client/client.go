@@ -0,0 +1,381 @@
+// Package client is a Go client for the Treefrog compiler HTTP API. It works
+// against both the local and the remote compiler, negotiates the API version
+// from /health and retries requests that failed transiently.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+)
+
+const (
+	DefaultTimeout         = 30 * time.Second
+	DefaultMaxRetries      = 3
+	DefaultRetryBackoff    = 500 * time.Millisecond
+	DefaultMaxRetryBackoff = 10 * time.Second
+	maxErrorBody           = 4096
+)
+
+// Client talks to a single compiler. It is safe for concurrent use.
+//
+// Failed requests are retried up to MaxRetries times with jittered
+// exponential backoff starting at RetryBackoff and capped at
+// MaxRetryBackoff. Breaker, if set, stops sending requests to a compiler
+// that keeps failing. OnProgress, if set, is told about project uploads and
+// artifact downloads. UploadLimiter and DownloadLimiter, if set, throttle
+// request bodies and artifact downloads. SourceKey, if set, encrypts
+// uploaded sources for a builder configured with the same key.
+type Client struct {
+	BaseURL         string
+	HTTPClient      *http.Client
+	MaxRetries      int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	Breaker         *Breaker
+	OnProgress      ProgressFunc
+	UploadLimiter   *RateLimiter
+	DownloadLimiter *RateLimiter
+	SourceKey       []byte
+
+	mu     sync.RWMutex
+	token  string
+	prefix string
+}
+
+// New creates a client for the compiler at baseURL, which may be a socket URL
+// (see UnixScheme and PipeScheme). The token is sent as a bearer token and
+// may be empty for the local compiler.
+func New(baseURL, token string) *Client {
+	httpClient, baseURL := NewHTTPClient(baseURL, DefaultTimeout)
+	return &Client{
+		BaseURL:         baseURL,
+		HTTPClient:      httpClient,
+		MaxRetries:      DefaultMaxRetries,
+		RetryBackoff:    DefaultRetryBackoff,
+		MaxRetryBackoff: DefaultMaxRetryBackoff,
+		Breaker:         NewBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
+		token:           token,
+	}
+}
+
+// SetToken replaces the bearer token used for subsequent requests
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// Error is returned when the compiler answers with a non-2xx status
+type Error struct {
+	StatusCode int
+	Message    string
+	// Reason is the machine-readable reason of a refused build, such as
+	// "insufficient_storage" or "queue_full"; empty for other errors
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("compiler returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("compiler returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Degraded reports whether the circuit breaker has stopped requests to the
+// compiler
+func (c *Client) Degraded() bool {
+	return c.Breaker != nil && c.Breaker.Degraded()
+}
+
+// IsTransient reports whether err is likely to go away on its own: the
+// compiler was unreachable or overloaded, or the circuit breaker is open
+func IsTransient(err error) bool {
+	if errors.Is(err, ErrDegraded) {
+		return true
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return retryable(apiErr.StatusCode, true)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsNotFound reports whether err is a 404 from the compiler
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Health fetches /health from the compiler
+func (c *Client) Health(ctx context.Context) (*api.HealthResponse, error) {
+	resp, err := c.send(ctx, request{method: http.MethodGet, url: c.BaseURL + "/health"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var health api.HealthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return nil, fmt.Errorf("failed to decode health response: %w", err)
+	}
+	return &health, nil
+}
+
+// GetJSON decodes the response of a GET to an API path such as "/user/me"
+// into v. It is meant for endpoints without a dedicated method.
+func (c *Client) GetJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, v)
+}
+
+// apiPrefix returns the route prefix the compiler serves its API under.
+// Compilers that predate versioning report no api_version and only serve
+// the legacy /api routes.
+func (c *Client) apiPrefix(ctx context.Context) string {
+	c.mu.RLock()
+	prefix := c.prefix
+	c.mu.RUnlock()
+	if prefix != "" {
+		return prefix
+	}
+
+	health, err := c.Health(ctx)
+	if err != nil {
+		// Don't cache a guess; the next request will ask again
+		return api.VersionPrefix
+	}
+
+	prefix = api.VersionPrefix
+	if health.APIVersion == 0 {
+		prefix = api.LegacyPrefix
+	}
+
+	c.mu.Lock()
+	c.prefix = prefix
+	c.mu.Unlock()
+	return prefix
+}
+
+func (c *Client) url(ctx context.Context, path string) string {
+	return c.BaseURL + c.apiPrefix(ctx) + path
+}
+
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	return c.send(ctx, request{method: http.MethodGet, url: c.url(ctx, path), idempotent: true})
+}
+
+// request is a single API call as sent by send
+type request struct {
+	method      string
+	url         string
+	body        []byte
+	contentType string
+	idempotent  bool
+	progress    bool // report upload progress to OnProgress
+	// external requests go to another host, such as a presigned bucket URL:
+	// they carry neither the token nor the API version and say nothing
+	// about the compiler's health
+	external bool
+}
+
+// send performs a request, retrying rate limiting and server unavailability.
+// Network errors and other 5xx responses are only retried for idempotent
+// requests, since the server may already have acted on them. Non-2xx
+// responses are returned as *Error.
+func (c *Client) send(ctx context.Context, r request) (*http.Response, error) {
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	maxBackoff := c.MaxRetryBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxRetryBackoff
+	}
+
+	breaker := c.Breaker
+	if r.external {
+		breaker = nil
+	}
+	for attempt := 0; ; attempt++ {
+		if breaker != nil {
+			if err := breaker.Allow(); err != nil {
+				return nil, err
+			}
+		}
+
+		req, err := c.newRequest(ctx, r)
+		if err != nil {
+			if breaker != nil {
+				breaker.Release()
+			}
+			return nil, err
+		}
+
+		resp, err := c.HTTPClient.Do(req)
+		if breaker != nil {
+			c.record(ctx, resp, err)
+		}
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var retry bool
+		var wait time.Duration
+		if err != nil {
+			retry = r.idempotent
+			err = fmt.Errorf("%s %s: %w", r.method, r.url, err)
+		} else {
+			retry = retryable(resp.StatusCode, r.idempotent)
+			wait = min(retryAfter(resp), maxBackoff)
+			err = responseError(resp)
+		}
+
+		if !retry || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		if wait <= 0 {
+			wait = jitter(backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// record feeds the outcome of a request to the circuit breaker. Rate
+// limiting, builds refused for lack of disk space and cancelled requests say
+// nothing about the compiler's health, but still end a probe.
+func (c *Client) record(ctx context.Context, resp *http.Response, err error) {
+	switch {
+	case c.Breaker == nil:
+	case ctx.Err() != nil:
+		c.Breaker.Release()
+	case err != nil:
+		c.Breaker.Failure()
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusInsufficientStorage:
+		c.Breaker.Release()
+	case resp.StatusCode >= 500:
+		c.Breaker.Failure()
+	default:
+		c.Breaker.Success()
+	}
+}
+
+// jitter spreads retries of many clients over [d/2, d)
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
+
+func (c *Client) newRequest(ctx context.Context, r request) (*http.Request, error) {
+	var body io.Reader
+	if r.body != nil {
+		body = newRateLimitedReader(ctx, bytes.NewReader(r.body), c.UploadLimiter)
+		if r.progress {
+			body = newProgressReader(body, OpUpload, int64(len(r.body)), c.OnProgress)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = int64(len(r.body))
+
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if r.external {
+		return req, nil
+	}
+	req.Header.Set(api.VersionHeader, strconv.Itoa(api.Version))
+
+	c.mu.RLock()
+	token := c.token
+	c.mu.RUnlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// sameHost reports whether rawURL is on the compiler's scheme and host
+func (c *Client) sameHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
+
+func retryable(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// responseError reads the error of a non-2xx response. Refused builds are
+// answered with a JSON limit check, whose message is used rather than the
+// raw body.
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var check struct {
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &check) == nil && check.Message != "" {
+		return &Error{StatusCode: resp.StatusCode, Message: check.Message, Reason: check.Reason}
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
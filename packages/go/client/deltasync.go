@@ -0,0 +1,182 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
+)
+
+// Checksums returns the SHA-256 of every file under root, keyed by its
+// slash-separated path relative to root. Entries for which skip returns true
+// are left out; skipping a directory skips its contents.
+func Checksums(root string, skip func(rel string, d fs.DirEntry) bool) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if skip != nil && skip(rel, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		sums[rel] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum project: %w", err)
+	}
+	return sums, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// DeltaSyncInit starts a delta-sync build and reports which files the
+// compiler already has
+func (c *Client) DeltaSyncInit(ctx context.Context, req api.DeltaSyncInitRequest) (*api.DeltaSyncInitResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delta-sync request: %w", err)
+	}
+
+	resp, err := c.send(ctx, request{
+		method:      http.MethodPost,
+		url:         c.url(ctx, "/builds/init"),
+		body:        body,
+		contentType: "application/json",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var init api.DeltaSyncInitResponse
+	if err := decode(resp, &init); err != nil {
+		return nil, err
+	}
+	return &init, nil
+}
+
+// DeltaSyncUpload uploads the listed files, relative to root, for a build
+// started with DeltaSyncInit and queues it
+func (c *Client) DeltaSyncUpload(ctx context.Context, buildID string, meta api.DeltaSyncUploadRequest, root string, files []string) (*api.DeltaSyncUploadResponse, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	metadata, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delta-sync metadata: %w", err)
+	}
+	_ = writer.WriteField("metadata", string(metadata))
+
+	for _, rel := range files {
+		if err := addFormFile(writer, root, rel); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode delta-sync upload: %w", err)
+	}
+
+	path := "/builds/" + url.PathEscape(buildID) + "/upload"
+	resp, err := c.send(ctx, request{
+		method:      http.MethodPost,
+		url:         c.url(ctx, path),
+		body:        body.Bytes(),
+		contentType: writer.FormDataContentType(),
+		progress:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var upload api.DeltaSyncUploadResponse
+	if err := decode(resp, &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func addFormFile(writer *multipart.Writer, root, rel string) error {
+	src, err := os.Open(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", rel, err)
+	}
+	defer src.Close()
+
+	part, err := writer.CreateFormFile("files", rel)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := io.Copy(part, src); err != nil {
+		return fmt.Errorf("failed to read %s: %w", rel, err)
+	}
+	return nil
+}
+
+// DeltaSync builds the project at root, uploading only the files whose
+// checksums the compiler does not already have. req.FileChecksums must be
+// filled in, typically from Checksums.
+func (c *Client) DeltaSync(ctx context.Context, root string, req api.DeltaSyncInitRequest) (*api.DeltaSyncUploadResponse, error) {
+	init, err := c.DeltaSyncInit(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := api.DeltaSyncUploadRequest{
+		ProjectID:             req.ProjectID,
+		CachedFiles:           make(map[string]string),
+		DeltaSyncBuildOptions: req.DeltaSyncBuildOptions,
+		NewChecksums:          make(map[string]string),
+	}
+	for rel := range init.ExistingFiles {
+		if sum, ok := req.FileChecksums[rel]; ok {
+			meta.CachedFiles[rel] = sum
+		}
+	}
+	for _, rel := range init.FilesToUpload {
+		meta.NewChecksums[rel] = req.FileChecksums[rel]
+	}
+
+	return c.DeltaSyncUpload(ctx, init.BuildID, meta, root, init.FilesToUpload)
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultConnectTimeout is how long dialing a compiler may take when
+// DialOptions sets no ConnectTimeout
+const DefaultConnectTimeout = 30 * time.Second
+
+// DefaultFallbackDelay is how long a dial waits on the preferred address
+// family before racing the other one, as net.Dialer does by default
+const DefaultFallbackDelay = 300 * time.Millisecond
+
+// DialOptions is how TCP connections to a compiler are made, for compilers
+// only reachable over IPv6 or behind split-horizon DNS. The zero value
+// dials like net.Dialer.
+type DialOptions struct {
+	PreferIPv6     bool          // try IPv6 addresses before IPv4 ones
+	Resolver       string        // DNS server, host or host:port, to use instead of the system resolver
+	ConnectTimeout time.Duration // for the whole dial, over every address tried
+}
+
+// DialContext returns a dial function for http.Transport.DialContext. The
+// host's addresses are dialed by net.Dialer, which spreads the timeout over
+// them and races the second address family after DefaultFallbackDelay
+// rather than waiting out the first. With PreferIPv6 the IPv6 family goes
+// first.
+func (o DialOptions) DialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	timeout := o.ConnectTimeout
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second, FallbackDelay: DefaultFallbackDelay}
+	if o.Resolver != "" {
+		server := o.Resolver
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		dns := &net.Dialer{Timeout: timeout}
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dns.DialContext(ctx, network, server)
+			},
+		}
+	}
+	if !o.PreferIPv6 {
+		return dialer.DialContext
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || network != "tcp" || net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return dialPreferIPv6(ctx, dialer, addr)
+	}
+}
+
+// dialPreferIPv6 dials addr over IPv6, and over IPv4 as well once IPv6 has
+// failed or not connected within the dialer's FallbackDelay, returning the
+// first connection made
+func dialPreferIPv6(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result)
+	dial := func(network string) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		select {
+		case results <- result{conn, err}:
+		case <-ctx.Done():
+			// Another dial won
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	go dial("tcp6")
+	fallback := time.NewTimer(dialer.FallbackDelay)
+	defer fallback.Stop()
+
+	var errs []error
+	for pending, fellBack := 1, false; pending > 0; {
+		select {
+		case <-fallback.C:
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+		}
+		if !fellBack {
+			fellBack = true
+			pending++
+			go dial("tcp4")
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Transport returns a clone of http.DefaultTransport dialing with o
+func (o DialOptions) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = o.DialContext()
+	return transport
+}
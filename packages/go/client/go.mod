@@ -0,0 +1,20 @@
+module github.com/alpha-og/treefrog/packages/go/client
+
+go 1.24.0
+
+require (
+	github.com/Microsoft/go-winio v0.6.2
+	github.com/alpha-og/treefrog/packages/go/api v0.0.0
+	github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0
+	github.com/alpha-og/treefrog/packages/go/security v0.0.0
+)
+
+require (
+	golang.org/x/sys v0.10.0 // indirect
+)
+
+replace (
+	github.com/alpha-og/treefrog/packages/go/api => ../api
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../buildopts
+	github.com/alpha-og/treefrog/packages/go/security => ../security
+)
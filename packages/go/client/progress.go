@@ -0,0 +1,82 @@
+package client
+
+import (
+	"io"
+	"time"
+)
+
+// progressInterval throttles progress callbacks during a transfer
+const progressInterval = 200 * time.Millisecond
+
+// Transfer directions reported in Progress.Op
+const (
+	OpUpload   = "upload"
+	OpDownload = "download"
+)
+
+// Progress describes a transfer in flight
+type Progress struct {
+	Op      string
+	Bytes   int64
+	Total   int64 // -1 when the size is unknown
+	Elapsed time.Duration
+	Done    bool
+}
+
+// Percent returns how much of the transfer is done, or -1 if the size is
+// unknown
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return float64(p.Bytes) * 100 / float64(p.Total)
+}
+
+// ETA estimates the time left from the average rate so far, or returns 0 if
+// it cannot be estimated yet
+func (p Progress) ETA() time.Duration {
+	if p.Total <= 0 || p.Bytes <= 0 || p.Bytes >= p.Total {
+		return 0
+	}
+	rate := float64(p.Bytes) / p.Elapsed.Seconds()
+	return time.Duration(float64(p.Total-p.Bytes) / rate * float64(time.Second))
+}
+
+// ProgressFunc receives progress of uploads and downloads. It is called from
+// the goroutine doing the transfer, at most every progressInterval and once
+// more when the transfer is done.
+type ProgressFunc func(Progress)
+
+// progressReader reports bytes read through it to fn
+type progressReader struct {
+	r        io.Reader
+	fn       ProgressFunc
+	p        Progress
+	start    time.Time
+	reported time.Time
+}
+
+func newProgressReader(r io.Reader, op string, total int64, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return r
+	}
+	return &progressReader{r: r, fn: fn, p: Progress{Op: op, Total: total}, start: time.Now()}
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	if pr.p.Done {
+		return pr.r.Read(b)
+	}
+
+	n, err := pr.r.Read(b)
+	pr.p.Bytes += int64(n)
+
+	now := time.Now()
+	pr.p.Done = err == io.EOF || (pr.p.Total > 0 && pr.p.Bytes >= pr.p.Total)
+	if pr.p.Done || now.Sub(pr.reported) >= progressInterval {
+		pr.p.Elapsed = now.Sub(pr.start)
+		pr.reported = now
+		pr.fn(pr.p)
+	}
+	return n, err
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRateChunk is the most a rate limited transfer reads at once, so the
+// limit is kept smoothly rather than in bursts
+const maxRateChunk = 32 * 1024
+
+// RateLimiter is a token bucket limiting the bytes per second of transfers.
+// Transfers sharing a limiter share its rate. It is safe for concurrent use
+// and its rate may be changed while transfers run.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second; 0 for no limit
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter of bytesPerSecond, or one that lets
+// everything through if it is 0
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetRate(bytesPerSecond)
+	return l
+}
+
+// SetRate changes the limit to bytesPerSecond, 0 for none
+func (l *RateLimiter) SetRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(max(bytesPerSecond, 0))
+	l.tokens = min(l.tokens, l.rate)
+	l.last = time.Now()
+}
+
+// Rate returns the limit in bytes per second, 0 for none
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.rate)
+}
+
+// wait blocks until n more bytes may pass. The bucket holds at most a
+// second's worth of tokens and goes into debt for waiting transfers, which
+// are then served in turn.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// chunk returns how much to read at once at the current rate
+func (l *RateLimiter) chunk(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return n
+	}
+	return max(1, min(n, maxRateChunk, int(l.rate)))
+}
+
+// rateLimitedReader reads from r no faster than its limiter allows
+type rateLimitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *RateLimiter
+}
+
+func newRateLimitedReader(ctx context.Context, r io.Reader, l *RateLimiter) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, l: l}
+}
+
+func (rr *rateLimitedReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return rr.r.Read(b)
+	}
+	n, err := rr.r.Read(b[:rr.l.chunk(len(b))])
+	if n > 0 {
+		if werr := rr.l.wait(rr.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Base URL schemes for a compiler listening on a Unix socket or a Windows
+// named pipe, e.g. "unix:///run/treefrog/compiler.sock" or
+// "npipe:////./pipe/treefrog-compiler"
+const (
+	UnixScheme = "unix://"
+	PipeScheme = "npipe://"
+)
+
+// socketHost stands in for the host in requests sent over a socket
+const socketHost = "http://localhost"
+
+// IsSocketURL reports whether baseURL names a Unix socket or named pipe
+// rather than an HTTP address
+func IsSocketURL(baseURL string) bool {
+	return strings.HasPrefix(baseURL, UnixScheme) || strings.HasPrefix(baseURL, PipeScheme)
+}
+
+// NewHTTPClient returns an HTTP client for requests to the compiler at
+// baseURL, and the base URL to send them to. For an ordinary HTTP URL that is
+// baseURL itself; for a socket URL every connection is dialed to the socket
+// whatever the request's host.
+func NewHTTPClient(baseURL string, timeout time.Duration) (*http.Client, string) {
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	var dial func(ctx context.Context) (net.Conn, error)
+	switch {
+	case strings.HasPrefix(baseURL, UnixScheme):
+		path := strings.TrimPrefix(baseURL, UnixScheme)
+		dial = func(ctx context.Context) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	case strings.HasPrefix(baseURL, PipeScheme):
+		name := strings.ReplaceAll(strings.TrimPrefix(baseURL, PipeScheme), "/", `\`)
+		dial = func(ctx context.Context) (net.Conn, error) {
+			return dialPipe(ctx, name)
+		}
+	default:
+		return &http.Client{Timeout: timeout}, baseURL
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		conn, err := dial(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", baseURL, err)
+		}
+		return conn, nil
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, socketHost
+}
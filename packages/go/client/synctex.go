@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// SyncTeXResult is a position in the PDF (view) or in the source (edit)
+type SyncTeXResult struct {
+	Page int     `json:"page,omitempty"`
+	X    float64 `json:"x,omitempty"`
+	Y    float64 `json:"y,omitempty"`
+	File string  `json:"file,omitempty"`
+	Line int     `json:"line,omitempty"`
+	Col  int     `json:"col,omitempty"`
+}
+
+// SyncTeXView maps a source position to a position in the PDF. col is
+// optional and ignored when zero.
+func (c *Client) SyncTeXView(ctx context.Context, id, file string, line, col int) (*SyncTeXResult, error) {
+	q := url.Values{}
+	q.Set("file", file)
+	q.Set("line", strconv.Itoa(line))
+	if col > 0 {
+		q.Set("col", strconv.Itoa(col))
+	}
+	return c.syncTeX(ctx, id, "view", q)
+}
+
+// SyncTeXEdit maps a position in the PDF to a source position
+func (c *Client) SyncTeXEdit(ctx context.Context, id string, page int, x, y float64) (*SyncTeXResult, error) {
+	q := url.Values{}
+	q.Set("page", strconv.Itoa(page))
+	q.Set("x", fmt.Sprintf("%f", x))
+	q.Set("y", fmt.Sprintf("%f", y))
+	return c.syncTeX(ctx, id, "edit", q)
+}
+
+func (c *Client) syncTeX(ctx context.Context, id, direction string, q url.Values) (*SyncTeXResult, error) {
+	var result SyncTeXResult
+	if err := c.GetJSON(ctx, "/build/"+url.PathEscape(id)+"/synctex/"+direction+"?"+q.Encode(), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
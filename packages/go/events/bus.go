@@ -0,0 +1,106 @@
+package events
+
+import (
+	"strings"
+	"sync"
+)
+
+// Handler receives published events
+type Handler func(Event)
+
+// Bus delivers published events to subscribers. Handlers run on the
+// publisher's goroutine, in subscription order, so every subscriber sees
+// events in the order they were published. Handlers must not block; one that
+// writes to a network connection should hand events to its own goroutine.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   []subscription
+}
+
+type subscription struct {
+	id     int
+	topics map[Topic]bool // nil means every topic
+	fn     Handler
+}
+
+// NewBus creates an empty bus
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn for the given topics, or for every topic if none
+// are given. The returned function removes the subscription.
+func (b *Bus) Subscribe(fn Handler, topics ...Topic) (unsubscribe func()) {
+	sub := subscription{fn: fn}
+	if len(topics) > 0 {
+		sub.topics = make(map[Topic]bool, len(topics))
+		for _, t := range topics {
+			sub.topics[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.nextID++
+	sub.id = b.nextID
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.remove(sub.id) })
+	}
+}
+
+// On subscribes fn to events of type E only
+func On[E Event](b *Bus, fn func(E)) (unsubscribe func()) {
+	var zero E
+	return b.Subscribe(func(e Event) {
+		if typed, ok := e.(E); ok {
+			fn(typed)
+		}
+	}, zero.Topic())
+}
+
+// Publish delivers e to every subscriber of its topic. Publishing on a nil
+// bus is a no-op, so producers need not check whether anyone listens.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+
+	topic := e.Topic()
+	for _, sub := range subs {
+		if sub.topics == nil || sub.topics[topic] {
+			sub.fn(e)
+		}
+	}
+}
+
+// PublishLog publishes each line of a build log as a LogLine
+func (b *Bus) PublishLog(buildID, log string) {
+	if b == nil || log == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(log, "\n"), "\n") {
+		b.Publish(LogLine{BuildID: buildID, Line: line})
+	}
+}
+
+func (b *Bus) remove(id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Copy rather than splice so a Publish in progress keeps its snapshot
+	subs := make([]subscription, 0, len(b.subs))
+	for _, sub := range b.subs {
+		if sub.id != id {
+			subs = append(subs, sub)
+		}
+	}
+	b.subs = subs
+}
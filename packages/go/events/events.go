@@ -0,0 +1,91 @@
+// Package events is an in-process publish/subscribe bus for build and
+// workspace events. Producers publish typed events; transports such as Wails
+// runtime events or a streaming endpoint subscribe to them instead of being
+// called directly. The JSON form of each event is what transports send.
+package events
+
+import "github.com/alpha-og/treefrog/packages/go/api"
+
+// Topic names a kind of event. Topics double as the event names used by
+// transports.
+type Topic string
+
+const (
+	TopicBuildStarted   Topic = "build-started"
+	TopicBuildStatus    Topic = "build-status"
+	TopicLogLine        Topic = "build-log"
+	TopicArtifactReady  Topic = "artifact-ready"
+	TopicFSChanged      Topic = "fs-changed"
+	TopicCompilerHealth Topic = "compiler-health"
+)
+
+// Event is implemented by every event published on a Bus
+type Event interface {
+	Topic() Topic
+}
+
+// BuildStarted is published when a build is handed to a compiler
+type BuildStarted struct {
+	BuildID  string `json:"id"`
+	MainFile string `json:"mainFile"`
+	Engine   string `json:"engine"`
+}
+
+// BuildStatusChanged is published whenever a build's state or message
+// changes
+type BuildStatusChanged struct {
+	BuildID   string          `json:"id"`
+	State     api.ClientState `json:"state"`
+	Message   string          `json:"message"`
+	StartedAt string          `json:"startedAt"`
+	EndedAt   string          `json:"endedAt"`
+}
+
+// LogLine is one line of a build's LaTeX log
+type LogLine struct {
+	BuildID string `json:"id"`
+	Line    string `json:"line"`
+}
+
+// ArtifactReady is published when a build output ("pdf", "log" or
+// "synctex") is available at Path
+type ArtifactReady struct {
+	BuildID  string `json:"id"`
+	Resource string `json:"resource"`
+	Path     string `json:"path"`
+}
+
+// FSOp is the kind of change reported by FSChanged
+type FSOp string
+
+const (
+	FSCreate FSOp = "create"
+	FSWrite  FSOp = "write"
+	FSRename FSOp = "rename"
+	FSDelete FSOp = "delete"
+)
+
+// FSChanged is published when a project file is created, written, renamed
+// or deleted. Paths are relative to the project root; From is only set for
+// renames and moves.
+type FSChanged struct {
+	Op   FSOp   `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from,omitempty"`
+}
+
+// CompilerHealthChanged is published when a compiler's health check flips
+// between healthy and unhealthy
+type CompilerHealthChanged struct {
+	URL          string `json:"url"`
+	Healthy      bool   `json:"healthy"`
+	Error        string `json:"error,omitempty"`
+	ResponseTime int64  `json:"responseTime"` // milliseconds
+}
+
+func (BuildStarted) Topic() Topic          { return TopicBuildStarted }
+func (BuildStatusChanged) Topic() Topic    { return TopicBuildStatus }
+func (LogLine) Topic() Topic               { return TopicLogLine }
+func (ArtifactReady) Topic() Topic         { return TopicArtifactReady }
+func (FSChanged) Topic() Topic             { return TopicFSChanged }
+func (CompilerHealthChanged) Topic() Topic { return TopicCompilerHealth }
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ArtifactMaxAge is how long clients may reuse a build artifact without
+// asking again. Artifacts never change under their build ID, so it is the
+// longest lifetime caches honor.
+const ArtifactMaxAge = 365 * 24 * time.Hour
+
+// ArtifactETag returns a strong ETag for an artifact file of a build. The
+// build ID and resource name the artifact; size and modification time tell
+// apart a file rewritten under the same build, e.g. by a restore.
+func ArtifactETag(buildID, resource string, info os.FileInfo) string {
+	return fmt.Sprintf(`"%s-%s-%x-%x"`, buildID, resource, info.Size(), info.ModTime().UnixNano())
+}
+
+// SetArtifactCaching marks a response as an immutable build artifact tagged
+// etag. http.ServeFile and http.ServeContent then set Last-Modified and
+// answer conditional requests with 304 on their own. Artifacts of servers
+// with per-user access are kept private to the requesting client; shared
+// lets CDNs and other shared caches store them too.
+func SetArtifactCaching(w http.ResponseWriter, etag string, shared bool) {
+	scope := "private"
+	if shared {
+		scope = "public"
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d, immutable", scope, int(ArtifactMaxAge.Seconds())))
+}
+
+// NotModified answers with 304 if the request's If-None-Match names the
+// ETag already set on w, and reports whether it did. Handlers use it to
+// skip work such as decrypting an artifact the client already has.
+func NotModified(w http.ResponseWriter, r *http.Request) bool {
+	etag := w.Header().Get("ETag")
+	if etag == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return false
+	}
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			h := w.Header()
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			h.Del("Content-Disposition")
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
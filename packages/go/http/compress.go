@@ -0,0 +1,15 @@
+package http
+
+import "compress/flate"
+
+// CompressionLevel trades a little CPU for much smaller logs and listings
+const CompressionLevel = flate.DefaultCompression
+
+// CompressibleTypes are the response types servers compress when the client
+// accepts it: build logs and JSON listings, which shrink many times over.
+// Artifacts are already compressed and served as is.
+var CompressibleTypes = []string{
+	"text/plain",
+	"application/json",
+	"application/x-ndjson",
+}
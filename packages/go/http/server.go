@@ -0,0 +1,93 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Defaults of the limits ServerConfig leaves unset
+var (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultMaxHeaderBytes    = 64 * 1024
+	DefaultMaxBodyBytes      = int64(1024 * 1024)
+)
+
+// MultipartOverhead is the room left for multipart framing and form fields
+// when a route's body limit is derived from the size of the file it takes
+const MultipartOverhead = 1024 * 1024
+
+// ServerConfig holds the limits every HTTP server of treefrog applies.
+// Zero values get the defaults above; ReadTimeout, WriteTimeout and
+// IdleTimeout are left to the net/http defaults when zero.
+type ServerConfig struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration // to send the request headers, so slow clients cannot hold connections open
+	ReadTimeout       time.Duration // to send the whole request, body included
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64 // request bodies of routes without their own LimitBody
+}
+
+// NewServer creates a server for handler with the limits of cfg. Request
+// bodies are capped at cfg.MaxBodyBytes; routes taking uploads raise their
+// own cap with LimitBody.
+func NewServer(cfg ServerConfig, handler http.Handler) *http.Server {
+	if cfg.ReadHeaderTimeout <= 0 {
+		cfg.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		cfg.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           LimitBody(cfg.MaxBodyBytes)(handler),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// limitedBody is a request body capped by LimitBody, keeping the original
+// body so a route's own limit replaces the server's instead of nesting in it
+type limitedBody struct {
+	io.ReadCloser
+	original io.ReadCloser
+}
+
+// LimitBody returns middleware capping request bodies at n bytes, replacing
+// any limit applied before it. Requests declaring a longer body are refused
+// with 413 at once; others fail with *http.MaxBytesError when they read past
+// n, and the connection is closed.
+func LimitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				body := r.Body
+				if limited, ok := body.(*limitedBody); ok {
+					body = limited.original
+				}
+				r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, body, n), original: body}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyTooLarge reports whether err comes from reading a request body past
+// its LimitBody cap
+func BodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
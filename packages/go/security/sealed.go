@@ -0,0 +1,94 @@
+package security
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SourceKeySize is the size of source encryption keys, for AES-256
+const SourceKeySize = 32
+
+// sealMagic starts sealed sources and is authenticated with them
+var sealMagic = []byte("TFSEAL1\n")
+
+// ErrSealedSource is returned when sealed sources fail to decrypt
+var ErrSealedSource = errors.New("failed to decrypt sources: wrong key or corrupted upload")
+
+// GenerateSourceKey returns a new random source encryption key, base64
+// encoded
+func GenerateSourceKey() (string, error) {
+	key := make([]byte, SourceKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ParseSourceKey decodes a base64 source encryption key
+func ParseSourceKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source key: %w", err)
+	}
+	if len(key) != SourceKeySize {
+		return nil, fmt.Errorf("invalid source key: must be %d bytes, got %d", SourceKeySize, len(key))
+	}
+	return key, nil
+}
+
+// IsSealed reports whether data was sealed with SealSource
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealMagic)
+}
+
+// SealSource encrypts a source archive with AES-256-GCM under key, so only a
+// builder holding the key can read it
+func SealSource(key, plaintext []byte) ([]byte, error) {
+	aead, err := sourceAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(sealMagic)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, sealMagic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, sealMagic), nil
+}
+
+// OpenSource decrypts sources sealed with SealSource
+func OpenSource(key, sealed []byte) ([]byte, error) {
+	aead, err := sourceAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSealed(sealed) || len(sealed) < len(sealMagic)+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrSealedSource
+	}
+	body := sealed[len(sealMagic):]
+	nonce, ciphertext := body[:aead.NonceSize()], body[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, sealMagic)
+	if err != nil {
+		return nil, ErrSealedSource
+	}
+	return plaintext, nil
+}
+
+func sourceAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != SourceKeySize {
+		return nil, fmt.Errorf("invalid source key: must be %d bytes, got %d", SourceKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
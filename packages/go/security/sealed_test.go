@@ -0,0 +1,84 @@
+package security
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealSourceRoundTrip(t *testing.T) {
+	encoded, err := GenerateSourceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseSourceKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("PK\x03\x04 project archive")
+	sealed, err := SealSource(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) {
+		t.Error("sealed sources not recognized")
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("sealed sources contain the plaintext")
+	}
+
+	opened, err := OpenSource(key, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("OpenSource = %q, expected %q", opened, plaintext)
+	}
+}
+
+func TestOpenSourceRejects(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, SourceKeySize)
+	other := bytes.Repeat([]byte{2}, SourceKeySize)
+	sealed, err := SealSource(key, []byte("sources"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name   string
+		key    []byte
+		sealed []byte
+	}{
+		{"wrong key", other, sealed},
+		{"tampered", key, tampered},
+		{"truncated", key, sealed[:len(sealMagic)+4]},
+		{"not sealed", key, []byte("PK\x03\x04")},
+	}
+	for _, test := range tests {
+		if _, err := OpenSource(test.key, test.sealed); !errors.Is(err, ErrSealedSource) {
+			t.Errorf("%s: OpenSource error = %v, expected ErrSealedSource", test.name, err)
+		}
+	}
+}
+
+func TestParseSourceKey(t *testing.T) {
+	tests := []struct {
+		input string
+		valid bool
+	}{
+		{"AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=", true},
+		{" AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\n", true},
+		{"AQEB", false},
+		{"not base64!", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		_, err := ParseSourceKey(test.input)
+		if (err == nil) != test.valid {
+			t.Errorf("ParseSourceKey(%q) error = %v, expected valid %v", test.input, err, test.valid)
+		}
+	}
+}
@@ -1,3 +1,16 @@
 module github.com/alpha-og/treefrog/packages/go/signer
 
-go 1.24.0
\ No newline at end of file
+go 1.24.0
+
+require github.com/alpha-og/treefrog/packages/go/api v0.0.0
+
+require (
+	github.com/alpha-og/treefrog/packages/go/buildopts v0.0.0 // indirect
+	github.com/alpha-og/treefrog/packages/go/security v0.0.0 // indirect
+)
+
+replace (
+	github.com/alpha-og/treefrog/packages/go/api => ../api
+	github.com/alpha-og/treefrog/packages/go/buildopts => ../buildopts
+	github.com/alpha-og/treefrog/packages/go/security => ../security
+)
@@ -11,6 +11,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/alpha-og/treefrog/packages/go/api"
 )
 
 type SignedURLSigner struct {
@@ -98,14 +100,21 @@ func (s *SignedURLSigner) generateTokenData(buildID, resource, userID string) (s
 	return token, dataB64, nil
 }
 
+// GenerateToken returns a signed token for a build resource without the URL,
+// for links served from a route other than the API artifact endpoint
+func (s *SignedURLSigner) GenerateToken(buildID, resource, userID string) (string, error) {
+	token, _, err := s.generateTokenData(buildID, resource, userID)
+	return token, err
+}
+
 func (s *SignedURLSigner) GenerateURL(buildID, resource, userID string) (string, error) {
 	token, _, err := s.generateTokenData(buildID, resource, userID)
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("/api/build/%s/artifact/%s?token=%s", url.QueryEscape(buildID), url.QueryEscape(resource),
-		url.QueryEscape(token)), nil
+	return fmt.Sprintf("%s/build/%s/artifact/%s?token=%s", api.VersionPrefix, url.PathEscape(buildID),
+		url.PathEscape(resource), url.QueryEscape(token)), nil
 }
 
 func (s *SignedURLSigner) VerifyURL(token, buildID, resource, userID string) (bool, error) {
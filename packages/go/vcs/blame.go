@@ -0,0 +1,131 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// uncommitted is the commit git blame gives lines not committed yet
+const uncommitted = "0000000000000000000000000000000000000000"
+
+// BlameRange is a run of consecutive lines last changed by one commit
+type BlameRange struct {
+	Start       int       `json:"start"` // first line, from 1
+	End         int       `json:"end"`   // last line, inclusive
+	Commit      string    `json:"commit"`
+	Author      string    `json:"author"`
+	Email       string    `json:"email"`
+	Date        time.Time `json:"date"`
+	Summary     string    `json:"summary"`
+	Uncommitted bool      `json:"uncommitted"`
+}
+
+// Blame returns who last changed each line of file, a path in the
+// repository dir is in, as ranges of lines in order
+func Blame(ctx context.Context, dir, file string) ([]BlameRange, error) {
+	// Untrimmed, as the last line may be an empty line of the file
+	out, err := gitOutput(ctx, dir, nil, "blame", "--porcelain", "--", file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBlame(out)
+}
+
+// BlameFile is Blame for an absolute file path
+func BlameFile(ctx context.Context, path string) ([]BlameRange, error) {
+	return Blame(ctx, filepath.Dir(path), filepath.Base(path))
+}
+
+// BlameLine returns the one of ranges that holds line, or nil
+func BlameLine(ranges []BlameRange, line int) *BlameRange {
+	for i := range ranges {
+		if ranges[i].Start <= line && line <= ranges[i].End {
+			return &ranges[i]
+		}
+	}
+	return nil
+}
+
+// ParseBlame parses the output of `git blame --porcelain`
+func ParseBlame(porcelain string) ([]BlameRange, error) {
+	type commitInfo struct {
+		author, email, summary string
+		date                   time.Time
+	}
+	commits := make(map[string]*commitInfo)
+	ranges := []BlameRange{}
+
+	var info *commitInfo
+	var commit string
+	var line int
+	for _, text := range strings.Split(porcelain, "\n") {
+		if strings.HasPrefix(text, "\t") {
+			// The line's content ends its entry
+			if info == nil {
+				return nil, fmt.Errorf("invalid blame output: content without header")
+			}
+			if n := len(ranges); n > 0 && ranges[n-1].Commit == commit && ranges[n-1].End == line-1 {
+				ranges[n-1].End = line
+				continue
+			}
+			ranges = append(ranges, BlameRange{
+				Start:       line,
+				End:         line,
+				Commit:      commit,
+				Author:      info.author,
+				Email:       info.email,
+				Date:        info.date,
+				Summary:     info.summary,
+				Uncommitted: commit == uncommitted,
+			})
+			continue
+		}
+
+		key, value, _ := strings.Cut(text, " ")
+		if len(key) == 40 && isHex(key) {
+			fields := strings.Fields(value)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid blame header %q", text)
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid blame header %q", text)
+			}
+			commit, line = key, n
+			if info = commits[commit]; info == nil {
+				info = &commitInfo{}
+				commits[commit] = info
+			}
+			continue
+		}
+		if info == nil {
+			continue
+		}
+		switch key {
+		case "author":
+			info.author = value
+		case "author-mail":
+			info.email = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+		case "author-time":
+			if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+				info.date = time.Unix(sec, 0).UTC()
+			}
+		case "summary":
+			info.summary = value
+		}
+	}
+	return ranges, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
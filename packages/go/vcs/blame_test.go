@@ -0,0 +1,59 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBlame(t *testing.T) {
+	a := "1111111111111111111111111111111111111111"
+	b := "2222222222222222222222222222222222222222"
+	raw := a + " 1 1 2\n" +
+		"author Ada\n" +
+		"author-mail <ada@example.com>\n" +
+		"author-time 1700000000\n" +
+		"author-tz +0000\n" +
+		"summary Draft introduction\n" +
+		"filename main.tex\n" +
+		"\t\\section{Introduction}\n" +
+		a + " 2 2\n" +
+		"\tFirst paragraph.\n" +
+		b + " 3 3 1\n" +
+		"author Grace\n" +
+		"author-mail <grace@example.com>\n" +
+		"author-time 1710000000\n" +
+		"summary Add results\n" +
+		"filename main.tex\n" +
+		"\tResults.\n" +
+		a + " 3 4 1\n" +
+		"\t\n" +
+		uncommitted + " 5 5 1\n" +
+		"author Not Committed Yet\n" +
+		"author-mail <not.committed.yet>\n" +
+		"author-time 1720000000\n" +
+		"summary Version of main.tex from main.tex\n" +
+		"filename main.tex\n" +
+		"\tNew line.\n"
+
+	ranges, err := ParseBlame(raw)
+	if err != nil {
+		t.Fatalf("ParseBlame: %v", err)
+	}
+	want := []BlameRange{
+		{Start: 1, End: 2, Commit: a, Author: "Ada", Email: "ada@example.com", Date: time.Unix(1700000000, 0).UTC(), Summary: "Draft introduction"},
+		{Start: 3, End: 3, Commit: b, Author: "Grace", Email: "grace@example.com", Date: time.Unix(1710000000, 0).UTC(), Summary: "Add results"},
+		{Start: 4, End: 4, Commit: a, Author: "Ada", Email: "ada@example.com", Date: time.Unix(1700000000, 0).UTC(), Summary: "Draft introduction"},
+		{Start: 5, End: 5, Commit: uncommitted, Author: "Not Committed Yet", Email: "not.committed.yet", Date: time.Unix(1720000000, 0).UTC(), Summary: "Version of main.tex from main.tex", Uncommitted: true},
+	}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("ParseBlame = %+v, want %+v", ranges, want)
+	}
+
+	if r := BlameLine(ranges, 2); r == nil || r.Start != 1 || r.Commit != a {
+		t.Errorf("BlameLine(2) = %+v", r)
+	}
+	if r := BlameLine(ranges, 6); r != nil {
+		t.Errorf("BlameLine(6) = %+v, want nil", r)
+	}
+}
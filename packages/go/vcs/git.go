@@ -0,0 +1,196 @@
+// Package vcs sets up version control for projects that have none: it
+// detects whether a project is in a git repository and initializes one with
+// a .gitignore suited to LaTeX.
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCommitMessage is the message of the first commit of a repository
+// Init creates
+const DefaultCommitMessage = "Initial commit"
+
+// Identity used for the first commit when git has no user configured, so
+// users who have never set up git can still get a repository
+const (
+	fallbackName  = "Treefrog"
+	fallbackEmail = "treefrog@localhost"
+)
+
+// Gitignore is the .gitignore Init writes: LaTeX intermediate files, the
+// compilers' output directory and Treefrog's own cache
+const Gitignore = `# LaTeX intermediate files
+*.aux
+*.bbl
+*.bcf
+*.blg
+*.fdb_latexmk
+*.fls
+*.log
+*.out
+*.run.xml
+*.synctex
+*.synctex.gz
+*.synctex(busy)
+*.toc
+*.lof
+*.lot
+*.xdv
+*.dvi
+
+# Indexes and glossaries
+*.idx
+*.ilg
+*.ind
+*.acn
+*.acr
+*.alg
+*.glg
+*.glo
+*.gls
+*.ist
+
+# Beamer
+*.nav
+*.snm
+*.vrb
+
+# Packages that write into the project
+_minted*/
+*.pyg
+*.pytxcode
+pythontex-files-*/
+
+# Build output and caches
+/output/
+.treefrog-cache/
+`
+
+// ErrAlreadyRepo is returned by Init for a directory already in a git
+// repository
+var ErrAlreadyRepo = errors.New("project is already in a git repository")
+
+// InitResult describes the repository Init created
+type InitResult struct {
+	Root             string `json:"root"`
+	Commit           string `json:"commit"`            // hash of the first commit
+	GitignoreCreated bool   `json:"gitignore_created"` // false if the project had one
+}
+
+// RepoRoot returns the root of the git repository dir is in, or "" if it is
+// in none
+func RepoRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Init makes dir a git repository, writes Gitignore unless the project has
+// a .gitignore, and commits every file it does not ignore
+func Init(ctx context.Context, dir, message string) (*InitResult, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	if RepoRoot(dir) != "" {
+		return nil, ErrAlreadyRepo
+	}
+	if message = strings.TrimSpace(message); message == "" {
+		message = DefaultCommitMessage
+	}
+
+	result := &InitResult{Root: dir}
+	if _, err := git(ctx, dir, "init", "--quiet"); err != nil {
+		return nil, err
+	}
+
+	ignore := filepath.Join(dir, ".gitignore")
+	if _, err := os.Stat(ignore); os.IsNotExist(err) {
+		if err := os.WriteFile(ignore, []byte(Gitignore), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write .gitignore: %w", err)
+		}
+		result.GitignoreCreated = true
+	}
+
+	if _, err := git(ctx, dir, "add", "-A"); err != nil {
+		return nil, err
+	}
+	var env []string
+	if !hasIdentity(ctx, dir) {
+		env = []string{
+			"GIT_AUTHOR_NAME=" + fallbackName, "GIT_AUTHOR_EMAIL=" + fallbackEmail,
+			"GIT_COMMITTER_NAME=" + fallbackName, "GIT_COMMITTER_EMAIL=" + fallbackEmail,
+		}
+	}
+	if _, err := gitEnv(ctx, dir, env, "commit", "--quiet", "--allow-empty", "-m", message); err != nil {
+		return nil, err
+	}
+	head, err := git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	result.Commit = head
+	return result, nil
+}
+
+// hasIdentity reports whether git knows who commits in dir
+func hasIdentity(ctx context.Context, dir string) bool {
+	name, err := git(ctx, dir, "config", "user.name")
+	if err != nil || name == "" {
+		return false
+	}
+	email, err := git(ctx, dir, "config", "user.email")
+	return err == nil && email != ""
+}
+
+// git runs git in dir and returns its trimmed output
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	return gitEnv(ctx, dir, nil, args...)
+}
+
+// gitEnv runs git in dir with env added to the environment
+func gitEnv(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	out, err := gitOutput(ctx, dir, env, args...)
+	return strings.TrimSpace(out), err
+}
+
+// gitOutput is gitEnv without trimming the output
+func gitOutput(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,3 @@
+module github.com/alpha-og/treefrog/packages/go/vcs
+
+go 1.24.0
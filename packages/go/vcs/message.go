@@ -0,0 +1,409 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// emptyTree is the hash of git's empty tree, which changes are compared
+// with before the first commit
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+const (
+	// maxSubjectSections caps the sections a suggested subject names
+	maxSubjectSections = 3
+	// maxBodyFiles caps the files a suggested body lists
+	maxBodyFiles = 20
+)
+
+// PreambleSection is the section of changes before \begin{document}
+const PreambleSection = "preamble"
+
+var (
+	headingRe = regexp.MustCompile(`\\(?:part|chapter|section|subsection|subsubsection)\*?\s*(?:\[[^\]]*\])?\s*\{([^}]*)\}`)
+	commandRe = regexp.MustCompile(`\\[A-Za-z@]+`)
+	// keyArgRe matches commands whose argument is a key or name, not prose
+	keyArgRe = regexp.MustCompile(`\\(?:begin|end|[A-Za-z]*cite[A-Za-z]*|[A-Za-z]*ref|label|usepackage|input|include|includegraphics)\*?\s*(?:\[[^\]]*\]\s*)*\{[^}]*\}(?:\s*\[[^\]]*\])*`)
+	wordRe   = regexp.MustCompile(`[\p{L}\p{N}]+(?:['-][\p{L}\p{N}]+)*`)
+	hunkRe   = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@`)
+)
+
+// FileSummary describes the changes to one file for a commit message
+type FileSummary struct {
+	Path         string   `json:"path"`
+	Change       string   `json:"change"`             // ChangeAdded, ChangeModified or ChangeDeleted
+	Sections     []string `json:"sections,omitempty"` // LaTeX sections touched, in order
+	WordsAdded   int      `json:"words_added"`
+	WordsRemoved int      `json:"words_removed"`
+}
+
+// CommitSuggestion is a commit message suggested from the changes, for the
+// user to edit before committing
+type CommitSuggestion struct {
+	Message      string        `json:"message"` // Subject, a blank line and the body
+	Subject      string        `json:"subject"`
+	Type         string        `json:"type,omitempty"` // conventional commit type
+	Scope        string        `json:"scope,omitempty"`
+	Files        []FileSummary `json:"files"`
+	WordsAdded   int           `json:"words_added"`
+	WordsRemoved int           `json:"words_removed"`
+}
+
+// SuggestCommitMessage suggests a message for committing the changes in the
+// repository dir is in: every change including untracked files when all is
+// set, as the desktop app commits, or else what is staged. With
+// conventional the subject is prefixed "type(scope): ".
+func SuggestCommitMessage(ctx context.Context, dir string, all, conventional bool) (*CommitSuggestion, error) {
+	_, status, err := ReadStatus(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	base := "HEAD"
+	if status.NoCommits {
+		base = emptyTree
+	}
+	args := []string{"-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff", "--no-renames", "--unified=0"}
+	if all {
+		args = append(args, base)
+	} else {
+		args = append(args, "--cached", base)
+	}
+	// Untrimmed, as trailing blank lines are part of the diff
+	out, err := gitOutput(ctx, dir, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	root := RepoRoot(dir)
+	files := parseDiff(out)
+	if all {
+		for _, p := range status.Untracked {
+			files = append(files, diffFile{path: p, change: ChangeAdded, untracked: true})
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("nothing to commit")
+	}
+
+	summaries := make([]FileSummary, 0, len(files))
+	for _, f := range files {
+		s := FileSummary{Path: f.path, Change: f.change}
+		if isText(f.path) {
+			if f.untracked {
+				f.added = readLines(ctx, root, f.path, false)
+				f.changed = allLines(len(f.added))
+			}
+			for _, line := range f.added {
+				s.WordsAdded += countWords(line)
+			}
+			for _, line := range f.removed {
+				s.WordsRemoved += countWords(line)
+			}
+			if isTeX(f.path) && f.change != ChangeDeleted && len(f.changed) > 0 {
+				s.Sections = sectionsAt(readLines(ctx, root, f.path, !all), f.changed)
+			}
+		}
+		summaries = append(summaries, s)
+	}
+	return composeSuggestion(summaries, conventional), nil
+}
+
+// diffFile is one file of a unified diff
+type diffFile struct {
+	path           string
+	change         string
+	changed        []int // lines of the new file that were changed or follow a removal
+	added, removed []string
+	untracked      bool
+}
+
+// parseDiff parses the output of `git diff --unified=0 --no-renames`
+func parseDiff(diff string) []diffFile {
+	var files []diffFile
+	var f *diffFile
+	next, inHunk := 0, false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, diffFile{path: diffHeaderPath(strings.TrimPrefix(line, "diff --git ")), change: ChangeModified})
+			f, inHunk = &files[len(files)-1], false
+		case f == nil:
+		case !inHunk && strings.HasPrefix(line, "new file mode"):
+			f.change = ChangeAdded
+		case !inHunk && strings.HasPrefix(line, "deleted file mode"):
+			f.change = ChangeDeleted
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			if p, ok := strings.CutPrefix(unquotePath(line[4:]), "b/"); ok {
+				f.path = p
+			}
+		case !inHunk && strings.HasPrefix(line, "--- "):
+		case strings.HasPrefix(line, "@@ "):
+			inHunk = true
+			m := hunkRe.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			next, _ = strconv.Atoi(m[1])
+			if m[2] == "0" {
+				// A pure removal; the change sits after line next
+				f.changed = append(f.changed, max(next, 1))
+			}
+		case strings.HasPrefix(line, "+"):
+			f.added = append(f.added, line[1:])
+			f.changed = append(f.changed, next)
+			next++
+		case strings.HasPrefix(line, "-"):
+			f.removed = append(f.removed, line[1:])
+		}
+	}
+	return files
+}
+
+// diffHeaderPath returns the path of a "diff --git a/p b/p" header, whose
+// two paths are the same without renames
+func diffHeaderPath(header string) string {
+	if n := (len(header) - 5) / 2; n > 0 && strings.HasPrefix(header, "a/") && len(header) == 2*n+5 {
+		return header[2 : 2+n]
+	}
+	if _, b, ok := strings.Cut(header, " b/"); ok {
+		return b
+	}
+	return header
+}
+
+// sectionsAt returns the sections of a LaTeX file that hold lines, in the
+// order they appear, given the file's content
+func sectionsAt(content []string, lines []int) []string {
+	type heading struct {
+		line  int
+		title string
+	}
+	var headings []heading
+	begin := 0
+	for i, text := range content {
+		if begin == 0 && strings.Contains(text, `\begin{document}`) {
+			begin = i + 1
+		}
+		if m := headingRe.FindStringSubmatch(stripComment(text)); m != nil {
+			headings = append(headings, heading{i + 1, strings.TrimSpace(m[1])})
+		}
+	}
+
+	seen := make(map[string]bool)
+	var sections []string
+	sorted := append([]int(nil), lines...)
+	sort.Ints(sorted)
+	for _, line := range sorted {
+		title := ""
+		if begin > 0 && line < begin {
+			title = PreambleSection
+		}
+		for _, h := range headings {
+			if h.line > line {
+				break
+			}
+			title = h.title
+		}
+		if title != "" && !seen[title] {
+			seen[title] = true
+			sections = append(sections, title)
+		}
+	}
+	return sections
+}
+
+// composeSuggestion writes the suggested message for files
+func composeSuggestion(files []FileSummary, conventional bool) *CommitSuggestion {
+	s := &CommitSuggestion{Files: files}
+	var sections []string
+	seen := make(map[string]bool)
+	sectioned := true // every file has sections to name it by
+	verb := ""
+	for _, f := range files {
+		s.WordsAdded += f.WordsAdded
+		s.WordsRemoved += f.WordsRemoved
+		sectioned = sectioned && len(f.Sections) > 0
+		for _, section := range f.Sections {
+			if !seen[section] {
+				seen[section] = true
+				sections = append(sections, section)
+			}
+		}
+		switch {
+		case verb == "":
+			verb = f.Change
+		case verb != f.Change:
+			verb = ChangeModified
+		}
+	}
+
+	target := fmt.Sprintf("%d files", len(files))
+	switch {
+	case sectioned && len(sections) <= maxSubjectSections && verb == ChangeModified:
+		target = joinAnd(sections)
+	case len(files) == 1:
+		target = files[0].Path
+	}
+	switch verb {
+	case ChangeAdded:
+		s.Subject = "Add " + target
+	case ChangeDeleted:
+		s.Subject = "Remove " + target
+	default:
+		s.Subject = "Update " + target
+	}
+
+	if conventional {
+		s.Type = commitType(files)
+		switch {
+		case sectioned && len(sections) == 1:
+			s.Scope = slug(sections[0])
+		case len(files) == 1:
+			s.Scope = slug(strings.TrimSuffix(path.Base(files[0].Path), path.Ext(files[0].Path)))
+		}
+		prefix := s.Type
+		if s.Scope != "" {
+			prefix += "(" + s.Scope + ")"
+		}
+		s.Subject = prefix + ": " + strings.ToLower(s.Subject[:1]) + s.Subject[1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(s.Subject)
+	b.WriteString("\n\n")
+	for i, f := range files {
+		if i == maxBodyFiles {
+			fmt.Fprintf(&b, "- and %d more files\n", len(files)-i)
+			break
+		}
+		detail := f.Change
+		if len(f.Sections) > 0 {
+			detail = strings.Join(f.Sections, ", ")
+		}
+		if f.WordsAdded > 0 || f.WordsRemoved > 0 {
+			detail += fmt.Sprintf(" (+%d/-%d words)", f.WordsAdded, f.WordsRemoved)
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", f.Path, detail)
+	}
+	if s.WordsAdded > 0 || s.WordsRemoved > 0 {
+		fmt.Fprintf(&b, "\nWords: +%d/-%d\n", s.WordsAdded, s.WordsRemoved)
+	}
+	s.Message = strings.TrimSuffix(b.String(), "\n")
+	return s
+}
+
+// commitType is the conventional commit type of a change to files: docs
+// for the paper's content, style for classes and packages, build for build
+// configuration and chore for anything else
+func commitType(files []FileSummary) string {
+	kinds := make(map[string]bool)
+	for _, f := range files {
+		name := path.Base(f.Path)
+		switch ext := strings.ToLower(path.Ext(name)); {
+		case isText(f.Path) || ext == ".bib" || isFigure(ext):
+			kinds["docs"] = true
+		case ext == ".cls" || ext == ".sty" || ext == ".bst":
+			kinds["style"] = true
+		case name == "latexmkrc" || name == ".latexmkrc" || name == ".treefrog.json" || name == "Makefile":
+			kinds["build"] = true
+		default:
+			kinds["chore"] = true
+		}
+	}
+	for _, t := range []string{"docs", "style", "build"} {
+		if kinds[t] {
+			return t
+		}
+	}
+	return "chore"
+}
+
+// readLines returns the lines of file, a path from the repository root, in
+// the working tree or the index, or nil if it cannot be read
+func readLines(ctx context.Context, root, file string, index bool) []string {
+	var content string
+	if index {
+		out, err := gitOutput(ctx, root, nil, "show", ":"+file)
+		if err != nil {
+			return nil
+		}
+		content = out
+	} else {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			return nil
+		}
+		content = string(data)
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// countWords counts the words of a line of LaTeX, ignoring commands and
+// comments
+func countWords(line string) int {
+	line = keyArgRe.ReplaceAllString(stripComment(line), " ")
+	return len(wordRe.FindAllString(commandRe.ReplaceAllString(line, " "), -1))
+}
+
+// stripComment removes a LaTeX comment from a line
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '%' && (i == 0 || line[i-1] != '\\') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func allLines(n int) []int {
+	lines := make([]int, n)
+	for i := range lines {
+		lines[i] = i + 1
+	}
+	return lines
+}
+
+func isTeX(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".tex", ".ltx":
+		return true
+	}
+	return false
+}
+
+// isText reports whether p is prose whose words are counted
+func isText(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".tex", ".ltx", ".md", ".txt":
+		return true
+	}
+	return false
+}
+
+func isFigure(ext string) bool {
+	switch ext {
+	case ".pdf", ".png", ".jpg", ".jpeg", ".eps", ".svg", ".tikz", ".pgf":
+		return true
+	}
+	return false
+}
+
+// slug turns a section title into a commit scope
+func slug(title string) string {
+	words := wordRe.FindAllString(strings.ToLower(commandRe.ReplaceAllString(title, " ")), -1)
+	return strings.Join(words, "-")
+}
+
+func joinAnd(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -0,0 +1,136 @@
+package vcs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDiff(t *testing.T) {
+	diff := "diff --git a/main.tex b/main.tex\n" +
+		"index 1111111..2222222 100644\n" +
+		"--- a/main.tex\n" +
+		"+++ b/main.tex\n" +
+		"@@ -3 +3 @@\n" +
+		"-Old sentence here.\n" +
+		"+A new sentence here.\n" +
+		"@@ -8,2 +7,0 @@\n" +
+		"--- a dashed line\n" +
+		"-gone\n" +
+		"@@ -12,0 +11,2 @@\n" +
+		"+++ added with pluses\n" +
+		"+last\n" +
+		"diff --git a/fig.png b/fig.png\n" +
+		"new file mode 100644\n" +
+		"index 0000000..3333333\n" +
+		"Binary files /dev/null and b/fig.png differ\n" +
+		"diff --git a/old.tex b/old.tex\n" +
+		"deleted file mode 100644\n" +
+		"--- a/old.tex\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-Bye\n"
+
+	files := parseDiff(diff)
+	if len(files) != 3 {
+		t.Fatalf("parseDiff returned %d files, want 3", len(files))
+	}
+	main := files[0]
+	if main.path != "main.tex" || main.change != ChangeModified {
+		t.Errorf("file 0 = %s %s", main.path, main.change)
+	}
+	if want := []int{3, 7, 11, 12}; !reflect.DeepEqual(main.changed, want) {
+		t.Errorf("changed = %v, want %v", main.changed, want)
+	}
+	if want := []string{"A new sentence here.", "++ added with pluses", "last"}; !reflect.DeepEqual(main.added, want) {
+		t.Errorf("added = %q, want %q", main.added, want)
+	}
+	if want := []string{"Old sentence here.", "-- a dashed line", "gone"}; !reflect.DeepEqual(main.removed, want) {
+		t.Errorf("removed = %q, want %q", main.removed, want)
+	}
+	if files[1].path != "fig.png" || files[1].change != ChangeAdded {
+		t.Errorf("file 1 = %s %s", files[1].path, files[1].change)
+	}
+	if files[2].path != "old.tex" || files[2].change != ChangeDeleted {
+		t.Errorf("file 2 = %s %s", files[2].path, files[2].change)
+	}
+}
+
+func TestSectionsAt(t *testing.T) {
+	content := []string{
+		`\documentclass{article}`,
+		`\usepackage{amsmath}`,
+		`\begin{document}`,
+		`\section{Introduction}`,
+		`Text.`,
+		`% \section{Commented}`,
+		`\subsection*{Related Work}`,
+		`More.`,
+		`\section[Short]{Results}`,
+		`Numbers.`,
+	}
+	got := sectionsAt(content, []int{10, 2, 5, 6, 8})
+	want := []string{PreambleSection, "Introduction", "Related Work", "Results"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sectionsAt = %q, want %q", got, want)
+	}
+}
+
+func TestComposeSuggestion(t *testing.T) {
+	files := []FileSummary{
+		{Path: "main.tex", Change: ChangeModified, Sections: []string{"Introduction"}, WordsAdded: 40, WordsRemoved: 12},
+		{Path: "chapters/methods.tex", Change: ChangeModified, Sections: []string{"Introduction", "Methods"}, WordsAdded: 5},
+	}
+
+	s := composeSuggestion(files, false)
+	if s.Subject != "Update Introduction and Methods" {
+		t.Errorf("Subject = %q", s.Subject)
+	}
+	if s.WordsAdded != 45 || s.WordsRemoved != 12 {
+		t.Errorf("words = +%d/-%d", s.WordsAdded, s.WordsRemoved)
+	}
+	wantBody := "- main.tex: Introduction (+40/-12 words)\n" +
+		"- chapters/methods.tex: Introduction, Methods (+5/-0 words)\n" +
+		"\nWords: +45/-12"
+	if !strings.HasSuffix(s.Message, wantBody) || !strings.HasPrefix(s.Message, s.Subject+"\n\n") {
+		t.Errorf("Message = %q", s.Message)
+	}
+
+	s = composeSuggestion(files[:1], true)
+	if s.Subject != "docs(introduction): update Introduction" || s.Type != "docs" || s.Scope != "introduction" {
+		t.Errorf("conventional Subject = %q (%s, %s)", s.Subject, s.Type, s.Scope)
+	}
+
+	s = composeSuggestion([]FileSummary{
+		{Path: "figures/plot.pdf", Change: ChangeAdded},
+		{Path: "figures/map.png", Change: ChangeAdded},
+	}, true)
+	if s.Subject != "docs: add 2 files" {
+		t.Errorf("added Subject = %q", s.Subject)
+	}
+
+	s = composeSuggestion([]FileSummary{{Path: "paper.cls", Change: ChangeModified}}, true)
+	if s.Subject != "style(paper): update paper.cls" {
+		t.Errorf("style Subject = %q", s.Subject)
+	}
+}
+
+func TestCountWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want int
+	}{
+		{`The quick brown fox.`, 4},
+		{`\emph{Don't} stop \cite[p.~4]{knuth84} % not counted`, 2},
+		{`See Figure~\ref{fig:plot}.`, 2},
+		{`50\% of well-known results`, 4},
+		{`\begin{figure}[t]`, 0},
+		{``, 0},
+	}
+
+	for _, test := range tests {
+		if got := countWords(test.line); got != test.want {
+			t.Errorf("countWords(%q) = %d, want %d", test.line, got, test.want)
+		}
+	}
+}
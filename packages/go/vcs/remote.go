@@ -0,0 +1,65 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DefaultRemote is the remote used when none is given
+const DefaultRemote = "origin"
+
+// Head returns the hash of the commit checked out in dir
+func Head(ctx context.Context, dir string) (string, error) {
+	return git(ctx, dir, "rev-parse", "--verify", "HEAD")
+}
+
+// ShortHash abbreviates a commit hash the way git shows it by default
+func ShortHash(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// RemoteURL returns the fetch URL of remote in the repository dir is in
+func RemoteURL(ctx context.Context, dir, remote string) (string, error) {
+	return git(ctx, dir, "remote", "get-url", "--", remote)
+}
+
+// Pushed reports whether commit is on a branch of remote as last fetched
+func Pushed(ctx context.Context, dir, remote, commit string) (bool, error) {
+	out, err := git(ctx, dir, "branch", "-r", "--contains", commit, "--list", remote+"/*")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// Tag creates the lightweight tag name on commit
+func Tag(ctx context.Context, dir, name, commit string) error {
+	_, err := git(ctx, dir, "tag", "--", name, commit)
+	return err
+}
+
+// GitHubRepo returns the owner and name of the GitHub repository a remote
+// URL points to, in any of the https, ssh or scp-like forms git accepts
+func GitHubRepo(remoteURL string) (owner, name string, err error) {
+	var host, path string
+	if u, perr := url.Parse(remoteURL); perr == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else if at, rest, ok := strings.Cut(remoteURL, "@"); ok && !strings.Contains(at, "/") {
+		// scp-like: git@github.com:owner/name.git
+		host, path, _ = strings.Cut(rest, ":")
+	}
+	if !strings.EqualFold(host, "github.com") && !strings.EqualFold(host, "www.github.com") {
+		return "", "", fmt.Errorf("%s is not a GitHub repository", remoteURL)
+	}
+	owner, name, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	name = strings.TrimSuffix(name, ".git")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%s is not a GitHub repository", remoteURL)
+	}
+	return owner, name, nil
+}
@@ -0,0 +1,28 @@
+package vcs
+
+import "testing"
+
+func TestGitHubRepo(t *testing.T) {
+	tests := []struct {
+		url         string
+		owner, name string
+		ok          bool
+	}{
+		{"https://github.com/alpha-og/treefrog.git", "alpha-og", "treefrog", true},
+		{"https://github.com/alpha-og/treefrog", "alpha-og", "treefrog", true},
+		{"https://user@github.com/alpha-og/treefrog/", "alpha-og", "treefrog", true},
+		{"git@github.com:alpha-og/treefrog.git", "alpha-og", "treefrog", true},
+		{"ssh://git@github.com/alpha-og/treefrog.git", "alpha-og", "treefrog", true},
+		{"https://gitlab.com/alpha-og/treefrog.git", "", "", false},
+		{"https://github.com/alpha-og", "", "", false},
+		{"https://github.com/alpha-og/treefrog/tree/main", "", "", false},
+		{"/srv/git/paper.git", "", "", false},
+	}
+
+	for _, test := range tests {
+		owner, name, err := GitHubRepo(test.url)
+		if (err == nil) != test.ok || owner != test.owner || name != test.name {
+			t.Errorf("GitHubRepo(%q) = %q, %q, %v", test.url, owner, name, err)
+		}
+	}
+}
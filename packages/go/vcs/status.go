@@ -0,0 +1,156 @@
+package vcs
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Kinds of change to a file
+const (
+	ChangeAdded       = "added"
+	ChangeModified    = "modified"
+	ChangeDeleted     = "deleted"
+	ChangeRenamed     = "renamed"
+	ChangeCopied      = "copied"
+	ChangeTypeChanged = "type-changed"
+)
+
+// FileChange is a changed file of a working tree or index
+type FileChange struct {
+	Path   string `json:"path"`
+	From   string `json:"from,omitempty"` // original path of a rename or copy
+	Change string `json:"change"`
+}
+
+// Status is the parsed form of `git status --porcelain=v1 -b`
+type Status struct {
+	Branch     string       `json:"branch"` // "" on a detached HEAD
+	Upstream   string       `json:"upstream,omitempty"`
+	Ahead      int          `json:"ahead"`
+	Behind     int          `json:"behind"`
+	Detached   bool         `json:"detached"`
+	NoCommits  bool         `json:"no_commits"` // the branch has no commits yet
+	Staged     []FileChange `json:"staged"`
+	Unstaged   []FileChange `json:"unstaged"`
+	Untracked  []string     `json:"untracked"`
+	Conflicted []string     `json:"conflicted"`
+}
+
+// Clean reports whether the working tree has no changes
+func (s *Status) Clean() bool {
+	return len(s.Staged) == 0 && len(s.Unstaged) == 0 && len(s.Untracked) == 0 && len(s.Conflicted) == 0
+}
+
+// ReadStatus returns the porcelain status of the repository dir is in and
+// its parsed form
+func ReadStatus(ctx context.Context, dir string) (string, *Status, error) {
+	raw, err := git(ctx, dir, "status", "--porcelain=v1", "-b")
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, ParseStatus(raw), nil
+}
+
+// ParseStatus parses the output of `git status --porcelain=v1 -b`
+func ParseStatus(porcelain string) *Status {
+	s := &Status{
+		Staged:     []FileChange{},
+		Unstaged:   []FileChange{},
+		Untracked:  []string{},
+		Conflicted: []string{},
+	}
+	for _, line := range strings.Split(porcelain, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if branch, ok := strings.CutPrefix(line, "## "); ok {
+			s.parseBranch(branch)
+			continue
+		}
+		if len(line) < 4 {
+			continue
+		}
+		x, y, name := line[0], line[1], line[3:]
+
+		switch {
+		case x == '?' && y == '?':
+			s.Untracked = append(s.Untracked, unquotePath(name))
+			continue
+		case x == '!' && y == '!':
+			continue
+		case x == 'U' || y == 'U' || (x == 'A' && y == 'A') || (x == 'D' && y == 'D'):
+			s.Conflicted = append(s.Conflicted, unquotePath(name))
+			continue
+		}
+
+		path, from := name, ""
+		if x == 'R' || x == 'C' {
+			if before, after, ok := strings.Cut(name, " -> "); ok {
+				from, path = unquotePath(before), after
+			}
+		}
+		path = unquotePath(path)
+		if change := changeKind(x); change != "" {
+			s.Staged = append(s.Staged, FileChange{Path: path, From: from, Change: change})
+		}
+		if change := changeKind(y); change != "" {
+			s.Unstaged = append(s.Unstaged, FileChange{Path: path, Change: change})
+		}
+	}
+	return s
+}
+
+// parseBranch parses the header line, such as "main...origin/main [ahead 1,
+// behind 2]", "No commits yet on main" or "HEAD (no branch)"
+func (s *Status) parseBranch(line string) {
+	if branch, ok := strings.CutPrefix(line, "No commits yet on "); ok {
+		s.Branch, s.NoCommits = branch, true
+		return
+	}
+	if branch, ok := strings.CutPrefix(line, "Initial commit on "); ok {
+		// Older git
+		s.Branch, s.NoCommits = branch, true
+		return
+	}
+	if strings.HasPrefix(line, "HEAD (no branch)") {
+		s.Detached = true
+		return
+	}
+
+	head, track, _ := strings.Cut(line, " [")
+	s.Branch, s.Upstream, _ = strings.Cut(head, "...")
+	for _, part := range strings.Split(strings.TrimSuffix(track, "]"), ", ") {
+		if n, ok := strings.CutPrefix(part, "ahead "); ok {
+			s.Ahead, _ = strconv.Atoi(n)
+		} else if n, ok := strings.CutPrefix(part, "behind "); ok {
+			s.Behind, _ = strconv.Atoi(n)
+		}
+	}
+}
+
+func changeKind(code byte) string {
+	switch code {
+	case 'A':
+		return ChangeAdded
+	case 'M':
+		return ChangeModified
+	case 'D':
+		return ChangeDeleted
+	case 'R':
+		return ChangeRenamed
+	case 'C':
+		return ChangeCopied
+	case 'T':
+		return ChangeTypeChanged
+	}
+	return ""
+}
+
+// unquotePath undoes git's C-style quoting of paths with unusual characters
+func unquotePath(p string) string {
+	if len(p) >= 2 && p[0] == '"' && p[len(p)-1] == '"' {
+		if s, err := strconv.Unquote(p); err == nil {
+			return s
+		}
+	}
+	return p
+}
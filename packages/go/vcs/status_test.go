@@ -0,0 +1,74 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	raw := "## main...origin/main [ahead 2, behind 1]\n" +
+		"M  main.tex\n" +
+		" M chapters/intro.tex\n" +
+		"MM refs.bib\n" +
+		"A  figures/plot.pdf\n" +
+		" D old.tex\n" +
+		"R  draft.tex -> paper.tex\n" +
+		"UU conflict.tex\n" +
+		"?? notes.txt\n" +
+		"?? \"caf\\303\\251.tex\"\n"
+
+	s := ParseStatus(raw)
+	if s.Branch != "main" || s.Upstream != "origin/main" || s.Ahead != 2 || s.Behind != 1 {
+		t.Errorf("branch = %q %q +%d -%d", s.Branch, s.Upstream, s.Ahead, s.Behind)
+	}
+	wantStaged := []FileChange{
+		{Path: "main.tex", Change: ChangeModified},
+		{Path: "refs.bib", Change: ChangeModified},
+		{Path: "figures/plot.pdf", Change: ChangeAdded},
+		{Path: "paper.tex", From: "draft.tex", Change: ChangeRenamed},
+	}
+	if !reflect.DeepEqual(s.Staged, wantStaged) {
+		t.Errorf("Staged = %+v, want %+v", s.Staged, wantStaged)
+	}
+	wantUnstaged := []FileChange{
+		{Path: "chapters/intro.tex", Change: ChangeModified},
+		{Path: "refs.bib", Change: ChangeModified},
+		{Path: "old.tex", Change: ChangeDeleted},
+	}
+	if !reflect.DeepEqual(s.Unstaged, wantUnstaged) {
+		t.Errorf("Unstaged = %+v, want %+v", s.Unstaged, wantUnstaged)
+	}
+	if want := []string{"notes.txt", "café.tex"}; !reflect.DeepEqual(s.Untracked, want) {
+		t.Errorf("Untracked = %q, want %q", s.Untracked, want)
+	}
+	if want := []string{"conflict.tex"}; !reflect.DeepEqual(s.Conflicted, want) {
+		t.Errorf("Conflicted = %q, want %q", s.Conflicted, want)
+	}
+	if s.Clean() {
+		t.Error("Clean() = true for a dirty tree")
+	}
+}
+
+func TestParseStatusBranch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Status
+	}{
+		{"## main", Status{Branch: "main"}},
+		{"## main...origin/main", Status{Branch: "main", Upstream: "origin/main"}},
+		{"## dev...origin/dev [behind 3]", Status{Branch: "dev", Upstream: "origin/dev", Behind: 3}},
+		{"## No commits yet on main", Status{Branch: "main", NoCommits: true}},
+		{"## HEAD (no branch)", Status{Detached: true}},
+	}
+
+	for _, test := range tests {
+		s := ParseStatus(test.header)
+		got := Status{Branch: s.Branch, Upstream: s.Upstream, Ahead: s.Ahead, Behind: s.Behind, Detached: s.Detached, NoCommits: s.NoCommits}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseStatus(%q) = %+v, want %+v", test.header, got, test.want)
+		}
+		if !s.Clean() {
+			t.Errorf("ParseStatus(%q) is not clean", test.header)
+		}
+	}
+}
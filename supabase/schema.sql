@@ -16,6 +16,10 @@ CREATE TABLE IF NOT EXISTS users (
     storage_used_bytes BIGINT DEFAULT 0,
     subscription_canceled_at TIMESTAMPTZ,
     subscription_paused BOOLEAN DEFAULT FALSE,
+    billing_status TEXT NOT NULL DEFAULT 'active' CHECK (billing_status IN ('active', 'past_due', 'suspended')),
+    past_due_since TIMESTAMPTZ,
+    deletion_scheduled_at TIMESTAMPTZ,
+    region TEXT,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW()
 );
@@ -24,11 +28,14 @@ CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_users_razorpay_customer ON users(razorpay_customer_id);
 CREATE INDEX IF NOT EXISTS idx_users_tier ON users(tier);
 CREATE INDEX IF NOT EXISTS idx_users_is_admin ON users(is_admin);
+CREATE INDEX IF NOT EXISTS idx_users_deletion ON users(deletion_scheduled_at) WHERE deletion_scheduled_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_users_past_due ON users(past_due_since) WHERE billing_status = 'past_due';
 
 -- Builds table
 CREATE TABLE IF NOT EXISTS builds (
     id TEXT PRIMARY KEY,
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id TEXT REFERENCES builds(id) ON DELETE CASCADE,
     status TEXT DEFAULT 'pending' CHECK (status IN ('pending', 'compiling', 'retrying', 'completed', 'failed', 'expired', 'deleted')),
     engine TEXT DEFAULT 'pdflatex' CHECK (engine IN ('pdflatex', 'xelatex', 'lualatex')),
     main_file TEXT,
@@ -38,6 +45,24 @@ CREATE TABLE IF NOT EXISTS builds (
     build_log TEXT,
     error_message TEXT,
     shell_escape BOOLEAN DEFAULT FALSE,
+    shell_restricted BOOLEAN NOT NULL DEFAULT FALSE,
+    auto_shell BOOLEAN NOT NULL DEFAULT FALSE,
+    env JSONB,
+    out_dir TEXT,
+    reproducible BOOLEAN NOT NULL DEFAULT FALSE,
+    manifest_hash TEXT,
+    result_key TEXT,
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    object_stored BOOLEAN NOT NULL DEFAULT FALSE,
+    region TEXT NOT NULL DEFAULT '',
+    sandbox TEXT,
+    timeout_seconds INTEGER NOT NULL DEFAULT 0,
+    index_processor TEXT,
+    notify_email BOOLEAN DEFAULT FALSE,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    heartbeat_at TIMESTAMPTZ,
+    dead_lettered_at TIMESTAMPTZ,
     storage_bytes BIGINT DEFAULT 0,
     created_at TIMESTAMPTZ DEFAULT NOW(),
     updated_at TIMESTAMPTZ DEFAULT NOW(),
@@ -51,6 +76,119 @@ CREATE INDEX IF NOT EXISTS idx_builds_status ON builds(status);
 CREATE INDEX IF NOT EXISTS idx_builds_expires ON builds(expires_at);
 CREATE INDEX IF NOT EXISTS idx_builds_created ON builds(created_at);
 CREATE INDEX IF NOT EXISTS idx_builds_user_created ON builds(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_builds_parent ON builds(parent_id);
+CREATE INDEX IF NOT EXISTS idx_builds_result ON builds(user_id, result_key, updated_at DESC) WHERE result_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_builds_dead_letter ON builds(dead_lettered_at DESC) WHERE dead_lettered_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_builds_region_status ON builds(region, status);
+
+-- Content-addressable blobs shared by delta-sync builds
+-- Each region stores its own copy of a blob
+CREATE TABLE IF NOT EXISTS blobs (
+    region TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL,
+    size_bytes BIGINT DEFAULT 0,
+    ref_count INTEGER DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (region, hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_blobs_unreferenced ON blobs(region, last_used_at) WHERE ref_count <= 0;
+
+-- Delta-sync projects and their file manifests (path -> blob hash)
+CREATE TABLE IF NOT EXISTS delta_projects (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    project_id TEXT NOT NULL,
+    region TEXT NOT NULL DEFAULT '',
+    last_build_id TEXT,
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, project_id)
+);
+
+CREATE TABLE IF NOT EXISTS project_files (
+    user_id UUID NOT NULL,
+    project_id TEXT NOT NULL,
+    path TEXT NOT NULL,
+    region TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL,
+    PRIMARY KEY (user_id, project_id, path),
+    FOREIGN KEY (user_id, project_id) REFERENCES delta_projects(user_id, project_id) ON DELETE CASCADE,
+    FOREIGN KEY (region, hash) REFERENCES blobs(region, hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_project_files_hash ON project_files(region, hash);
+
+-- Compiler instances and their capacity, heartbeated by each instance
+CREATE TABLE IF NOT EXISTS instances (
+    id TEXT PRIMARY KEY,
+    region TEXT NOT NULL DEFAULT '',
+    workers INTEGER NOT NULL DEFAULT 0,
+    busy INTEGER NOT NULL DEFAULT 0,
+    pending INTEGER NOT NULL DEFAULT 0,
+    heartbeat_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_instances_region ON instances(region, heartbeat_at);
+
+-- Pairing tokens for the read-only companion API (only the SHA256 is stored)
+CREATE TABLE IF NOT EXISTS pairing_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    label TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_pairing_tokens_user ON pairing_tokens(user_id);
+
+-- Short-lived tokens minted by admins to act as a user while troubleshooting
+-- Like pairing tokens, only the SHA256 of each token is stored
+CREATE TABLE IF NOT EXISTS impersonation_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    admin_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_tokens_user ON impersonation_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_impersonation_tokens_admin ON impersonation_tokens(admin_id);
+
+-- Regions that removed the files of an account due for deletion. The
+-- account's row goes once every region has purged its files.
+CREATE TABLE IF NOT EXISTS account_purges (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    region TEXT NOT NULL,
+    purged_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, region)
+);
+
+-- Coupon campaigns generate batches of coupons and cap their redemptions
+CREATE TABLE IF NOT EXISTS coupon_campaigns (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name TEXT NOT NULL,
+    code_pattern TEXT NOT NULL,
+    type TEXT NOT NULL CHECK (type IN ('discount', 'trial', 'upgrade')),
+    plan_id TEXT NOT NULL DEFAULT '',
+    discount_percent INTEGER DEFAULT 0 CHECK (discount_percent >= 0 AND discount_percent <= 100),
+    trial_days INTEGER DEFAULT 0,
+    tier_upgrade TEXT NOT NULL DEFAULT '',
+    codes INTEGER NOT NULL DEFAULT 1,
+    max_uses_per_code INTEGER DEFAULT 0,
+    max_redemptions INTEGER DEFAULT 0,
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
 
 -- Coupons table
 CREATE TABLE IF NOT EXISTS coupons (
@@ -67,12 +205,14 @@ CREATE TABLE IF NOT EXISTS coupons (
     expires_at TIMESTAMPTZ,
     is_active BOOLEAN DEFAULT TRUE,
     one_time_use BOOLEAN DEFAULT FALSE,
-    created_at TIMESTAMPTZ DEFAULT NOW()
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    campaign_id UUID REFERENCES coupon_campaigns(id) ON DELETE CASCADE
 );
 
 CREATE INDEX IF NOT EXISTS idx_coupons_code ON coupons(code);
 CREATE INDEX IF NOT EXISTS idx_coupons_active ON coupons(is_active);
 CREATE INDEX IF NOT EXISTS idx_coupons_type ON coupons(type);
+CREATE INDEX IF NOT EXISTS idx_coupons_campaign ON coupons(campaign_id);
 
 -- Allowlist table
 CREATE TABLE IF NOT EXISTS allowlist (
@@ -88,6 +228,16 @@ CREATE TABLE IF NOT EXISTS allowlist (
 
 CREATE INDEX IF NOT EXISTS idx_allowlist_email ON allowlist(email);
 CREATE INDEX IF NOT EXISTS idx_allowlist_active ON allowlist(is_active);
+CREATE INDEX IF NOT EXISTS idx_allowlist_email_lower ON allowlist(lower(email));
+
+-- Users who signed in through an allowlist entry; wildcard entries such as
+-- *@university.edu are accepted by many
+CREATE TABLE IF NOT EXISTS allowlist_acceptances (
+    entry_id UUID NOT NULL REFERENCES allowlist(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    accepted_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (entry_id, user_id)
+);
 
 -- Trials table
 CREATE TABLE IF NOT EXISTS trials (
@@ -129,6 +279,22 @@ CREATE INDEX IF NOT EXISTS idx_invoices_user ON invoices(user_id);
 CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
 CREATE INDEX IF NOT EXISTS idx_invoices_razorpay ON invoices(razorpay_invoice_id);
 
+-- Builds beyond the monthly quota of plans with an overage price. Each month's
+-- overages are added to the next invoice as one subscription add-on.
+CREATE TABLE IF NOT EXISTS build_overages (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    build_id TEXT UNIQUE REFERENCES builds(id) ON DELETE SET NULL,
+    period DATE NOT NULL,
+    amount BIGINT NOT NULL,
+    addon_id TEXT,
+    invoiced_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_build_overages_user_period ON build_overages(user_id, period);
+CREATE INDEX IF NOT EXISTS idx_build_overages_uninvoiced ON build_overages(period) WHERE invoiced_at IS NULL;
+
 -- Audit logs table
 CREATE TABLE IF NOT EXISTS audit_logs (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
@@ -139,10 +305,12 @@ CREATE TABLE IF NOT EXISTS audit_logs (
     details JSONB,
     ip_address TEXT,
     user_agent TEXT,
+    impersonated_by UUID REFERENCES users(id) ON DELETE SET NULL,
     created_at TIMESTAMPTZ DEFAULT NOW()
 );
 
 CREATE INDEX IF NOT EXISTS idx_audit_logs_user ON audit_logs(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_impersonated ON audit_logs(impersonated_by) WHERE impersonated_by IS NOT NULL;
 CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs(action);
 CREATE INDEX IF NOT EXISTS idx_audit_logs_created ON audit_logs(created_at);
 
@@ -246,11 +414,20 @@ ALTER TABLE users ENABLE ROW LEVEL SECURITY;
 ALTER TABLE builds ENABLE ROW LEVEL SECURITY;
 ALTER TABLE invoices ENABLE ROW LEVEL SECURITY;
 ALTER TABLE coupons ENABLE ROW LEVEL SECURITY;
+ALTER TABLE coupon_campaigns ENABLE ROW LEVEL SECURITY;
 ALTER TABLE allowlist ENABLE ROW LEVEL SECURITY;
+ALTER TABLE allowlist_acceptances ENABLE ROW LEVEL SECURITY;
 ALTER TABLE trials ENABLE ROW LEVEL SECURITY;
 ALTER TABLE user_preferences ENABLE ROW LEVEL SECURITY;
 ALTER TABLE audit_logs ENABLE ROW LEVEL SECURITY;
 ALTER TABLE coupon_redemptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE build_overages ENABLE ROW LEVEL SECURITY;
+ALTER TABLE blobs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE delta_projects ENABLE ROW LEVEL SECURITY;
+ALTER TABLE project_files ENABLE ROW LEVEL SECURITY;
+ALTER TABLE pairing_tokens ENABLE ROW LEVEL SECURITY;
+ALTER TABLE impersonation_tokens ENABLE ROW LEVEL SECURITY;
+ALTER TABLE account_purges ENABLE ROW LEVEL SECURITY;
 
 -- RLS Policies for users table
 CREATE POLICY "Users can view own profile"